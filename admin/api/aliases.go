@@ -0,0 +1,24 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/fabiolb/fabio/registry/alias"
+)
+
+// AliasesHandler returns the state of the service aliases
+// registered for routes with the "register" option.
+type AliasesHandler struct{}
+
+func (h *AliasesHandler) Operations() []Operation {
+	return []Operation{{
+		Method:   "GET",
+		Summary:  "Returns the state of the service aliases",
+		Params:   []Param{prettyParam},
+		Response: alias.Default.States(),
+	}}
+}
+
+func (h *AliasesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, alias.Default.States())
+}
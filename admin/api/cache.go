@@ -0,0 +1,65 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/fabiolb/fabio/cache"
+)
+
+// CacheHandler returns the statistics of the response cache
+// and purges cached responses.
+type CacheHandler struct {
+	// Store is the response cache. If it is nil the
+	// cache is empty.
+	Store cache.Store
+}
+
+type cachePurgeResponse struct {
+	Purged int `json:"purged"`
+}
+
+func (h *CacheHandler) Operations() []Operation {
+	return []Operation{
+		{
+			Method:   "GET",
+			Summary:  "Returns the number and the size of the cached responses",
+			Params:   []Param{prettyParam},
+			Response: cache.Stats{},
+		},
+		{
+			Method:  "DELETE",
+			Summary: "Purges the cached responses",
+			Params: []Param{
+				{Name: "service", In: "query", Type: "string", Description: "Purge only the responses of this service"},
+				{Name: "path", In: "query", Type: "string", Description: "Purge only the responses for paths with this prefix"},
+				prettyParam,
+			},
+			Response: cachePurgeResponse{},
+		},
+	}
+}
+
+func (h *CacheHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		var stats cache.Stats
+		if h.Store != nil {
+			stats = h.Store.Stats()
+		}
+		writeJSON(w, r, stats)
+
+	case "DELETE":
+		service, path := r.URL.Query().Get("service"), r.URL.Query().Get("path")
+		var n int
+		if h.Store != nil {
+			n = h.Store.Purge(func(e *cache.Entry) bool {
+				return (service == "" || e.Service == service) && strings.HasPrefix(e.Path, path)
+			})
+		}
+		writeJSON(w, r, cachePurgeResponse{Purged: n})
+
+	default:
+		http.Error(w, "not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,47 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fabiolb/fabio/cache"
+)
+
+func TestCacheHandlerPurge(t *testing.T) {
+	store := cache.NewMemoryStore(1024)
+	h := &CacheHandler{Store: store}
+
+	tests := []struct {
+		desc, query, body string
+	}{
+		{"service and path", "?service=a&path=/foo", `{"purged":1}`},
+		{"service", "?service=a", `{"purged":2}`},
+		{"path", "?path=/foo", `{"purged":2}`},
+		{"all", "", `{"purged":3}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			exp := time.Now().Add(time.Hour)
+			for _, e := range []*cache.Entry{
+				{Service: "a", Path: "/foo/1", Expires: exp},
+				{Service: "a", Path: "/bar", Expires: exp},
+				{Service: "b", Path: "/foo/2", Expires: exp},
+			} {
+				store.Set(e.Service+e.Path, e)
+			}
+			defer store.Purge(func(*cache.Entry) bool { return true })
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest("DELETE", "/api/v1/cache"+tt.query, nil))
+			if got, want := w.Code, http.StatusOK; got != want {
+				t.Fatalf("got status %d want %d", got, want)
+			}
+			if got, want := strings.TrimSpace(w.Body.String()), tt.body; got != want {
+				t.Fatalf("got %s want %s", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/fabiolb/fabio/route"
+)
+
+// RouteChecksumHandler returns the checksum and the
+// version of the active routing table.
+type RouteChecksumHandler struct{}
+
+type apiChecksum struct {
+	// Checksum is the SHA-256 checksum of the route definitions
+	// which is equal on all instances with the same routes.
+	Checksum string `json:"checksum"`
+
+	// Version is the sequence number of the last change of
+	// the routing table of this instance.
+	Version uint64 `json:"version"`
+
+	Routes int `json:"routes"`
+}
+
+func (h *RouteChecksumHandler) Operations() []Operation {
+	return []Operation{{
+		Method:   "GET",
+		Summary:  "Returns the checksum and the version of the routing table",
+		Params:   []Param{prettyParam},
+		Response: apiChecksum{},
+	}}
+}
+
+func (h *RouteChecksumHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, activeChecksum())
+}
+
+func activeChecksum() apiChecksum {
+	c := apiChecksum{Checksum: route.ActiveChecksum(), Version: route.Events.Seq()}
+	for _, routes := range route.GetTable() {
+		c.Routes += len(routes)
+	}
+	return c
+}
+
+// FleetHandler compares the routing table of this instance with
+// the routing tables of the other instances of the fleet.
+type FleetHandler struct {
+	// Peers are the admin URLs of the other instances.
+	Peers []string
+
+	// Client fetches the checksums of the peers.
+	// If nil, a client with a timeout of Timeout is used.
+	Client *http.Client
+
+	// Timeout is the timeout for a peer. Defaults to 2s.
+	Timeout time.Duration
+}
+
+type apiFleetInstance struct {
+	// Instance is the admin URL of the peer or
+	// 'local' for this instance.
+	Instance string `json:"instance"`
+	apiChecksum
+	Error string `json:"error,omitempty"`
+
+	// Straggler is true if the instance does not serve the
+	// routing table of the majority of the fleet.
+	Straggler bool `json:"straggler"`
+}
+
+func (h *FleetHandler) Operations() []Operation {
+	return []Operation{{
+		Method:   "GET",
+		Summary:  "Returns the checksums of the routing tables of this instance and its peers",
+		Params:   []Param{prettyParam},
+		Response: []apiFleetInstance{},
+	}}
+}
+
+func (h *FleetHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	client := h.Client
+	if client == nil {
+		timeout := h.Timeout
+		if timeout <= 0 {
+			timeout = 2 * time.Second
+		}
+		client = &http.Client{Timeout: timeout}
+	}
+
+	fleet := make([]apiFleetInstance, len(h.Peers)+1)
+	fleet[0] = apiFleetInstance{Instance: "local", apiChecksum: activeChecksum()}
+	var wg sync.WaitGroup
+	for i, peer := range h.Peers {
+		wg.Add(1)
+		go func(i int, peer string) {
+			defer wg.Done()
+			fleet[i+1] = fetchChecksum(client, peer)
+		}(i, peer)
+	}
+	wg.Wait()
+
+	// the checksum which most instances serve is the reference
+	votes := map[string]int{}
+	var majority string
+	for _, in := range fleet {
+		if in.Error != "" {
+			continue
+		}
+		votes[in.Checksum]++
+		if votes[in.Checksum] > votes[majority] {
+			majority = in.Checksum
+		}
+	}
+	for i := range fleet {
+		fleet[i].Straggler = fleet[i].Error != "" || fleet[i].Checksum != majority
+	}
+	writeJSON(w, r, fleet)
+}
+
+// fetchChecksum returns the checksum of the routing table of a peer.
+func fetchChecksum(client *http.Client, peer string) apiFleetInstance {
+	in := apiFleetInstance{Instance: peer}
+	resp, err := client.Get(peer + "/api/routes/checksum")
+	if err != nil {
+		in.Error = err.Error()
+		return in
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		in.Error = fmt.Sprintf("unexpected status %d", resp.StatusCode)
+		return in
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&in.apiChecksum); err != nil {
+		in.Error = err.Error()
+	}
+	return in
+}
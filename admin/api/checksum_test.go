@@ -0,0 +1,65 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fabiolb/fabio/route"
+)
+
+func TestFleetHandler(t *testing.T) {
+	tbl, err := route.NewTable(bytes.NewBufferString("route add a /a http://1.2.3.4/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	route.SetTable(tbl)
+	defer route.SetTable(make(route.Table))
+
+	peer := func(checksum string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/api/routes/checksum" {
+				http.NotFound(w, r)
+				return
+			}
+			json.NewEncoder(w).Encode(apiChecksum{Checksum: checksum, Version: 7, Routes: 1})
+		}))
+	}
+	same, other := peer(tbl.Checksum()), peer("0123")
+	defer same.Close()
+	defer other.Close()
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	h := &FleetHandler{Peers: []string{same.URL, other.URL, down.URL}}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/api/routes/fleet", nil))
+
+	var fleet []apiFleetInstance
+	if err := json.NewDecoder(w.Body).Decode(&fleet); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(fleet), 4; got != want {
+		t.Fatalf("got %d instances want %d", got, want)
+	}
+	want := []struct {
+		instance  string
+		straggler bool
+		err       bool
+	}{
+		{"local", false, false},
+		{same.URL, false, false},
+		{other.URL, true, false},
+		{down.URL, true, true},
+	}
+	for i, in := range fleet {
+		if in.Instance != want[i].instance || in.Straggler != want[i].straggler || (in.Error != "") != want[i].err {
+			t.Errorf("%d: got %+v want %+v", i, in, want[i])
+		}
+	}
+	if got, want := fleet[0].Checksum, tbl.Checksum(); got != want {
+		t.Fatalf("got local checksum %s want %s", got, want)
+	}
+}
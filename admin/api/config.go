@@ -6,6 +6,15 @@ type ConfigHandler struct {
 	Config interface{}
 }
 
+func (h *ConfigHandler) Operations() []Operation {
+	return []Operation{{
+		Method:   "GET",
+		Summary:  "Returns the configuration",
+		Params:   []Param{prettyParam},
+		Response: h.Config,
+	}}
+}
+
 func (h *ConfigHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, r, h.Config)
 }
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/fabiolb/fabio/route"
+)
+
+// DebugTokenHandler issues the tokens for the debug header which
+// route a request to a specific target.
+type DebugTokenHandler struct {
+	// MaxTTL is the maximum lifetime of a token.
+	MaxTTL time.Duration
+}
+
+type debugTokenRequest struct {
+	// Target is the URL of the target, e.g. http://10.0.0.1:8080/
+	Target string `json:"target"`
+
+	tokenRequest
+}
+
+type debugToken struct {
+	Header  string    `json:"header"`
+	Token   string    `json:"token"`
+	Target  string    `json:"target"`
+	Expires time.Time `json:"expires"`
+}
+
+func (h *DebugTokenHandler) Operations() []Operation {
+	return []Operation{{
+		Method:   "POST",
+		Summary:  "Issues a token for the debug header which routes requests to a specific target",
+		Params:   []Param{prettyParam},
+		Request:  debugTokenRequest{},
+		Response: debugToken{},
+		Errors:   []int{http.StatusBadRequest, http.StatusNotFound},
+	}}
+}
+
+func (h *DebugTokenHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "POST" && (route.DebugHeader == "" || len(route.DebugSecret) == 0) {
+		http.Error(w, "debug tokens are disabled. Set proxy.debug.header", http.StatusNotFound)
+		return
+	}
+
+	var req debugTokenRequest
+	expires, ok := readTokenRequest(w, r, &req, h.MaxTTL)
+	if !ok {
+		return
+	}
+	if !route.GetTable().HasTarget(req.Target) {
+		http.Error(w, fmt.Sprintf("invalid target. No route has the target %q", req.Target), http.StatusBadRequest)
+		return
+	}
+
+	token, err := route.DebugToken(req.Target, expires)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, r, debugToken{Header: route.DebugHeader, Token: token, Target: req.Target, Expires: expires})
+}
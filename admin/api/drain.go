@@ -0,0 +1,58 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/fabiolb/fabio/proxy"
+	"github.com/fabiolb/fabio/registry"
+)
+
+// DrainHandler takes fabio out of service without stopping it.
+type DrainHandler struct {
+	// Grace is the time new connections are still
+	// accepted after fabio was deregistered.
+	Grace time.Duration
+
+	// Wait is the maximum time the in-flight
+	// requests are served after that.
+	Wait time.Duration
+}
+
+func (h *DrainHandler) Operations() []Operation {
+	return []Operation{
+		{
+			Method:   "GET",
+			Summary:  "Returns the progress of draining",
+			Params:   []Param{prettyParam},
+			Response: proxy.DrainStatus{},
+		},
+		{
+			Method:   "POST",
+			Summary:  "Deregisters fabio, fails the health check and stops accepting new connections after a grace period",
+			Response: proxy.DrainStatus{},
+		},
+	}
+}
+
+func (h *DrainHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		writeJSON(w, r, proxy.GetDrainStatus())
+
+	case "POST":
+		deregister := func() {
+			if registry.Default != nil {
+				registry.Default.DeregisterAll()
+			}
+		}
+		if proxy.Drain(h.Grace, h.Wait, deregister) {
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.WriteHeader(http.StatusAccepted)
+		}
+		writeJSON(w, r, proxy.GetDrainStatus())
+
+	default:
+		http.Error(w, "not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/fabiolb/fabio/route"
+)
+
+// RouteEvalHandler reports how a list of requests would be routed
+// with a candidate routing table without applying the table.
+type RouteEvalHandler struct {
+	Matcher      string
+	GlobDisabled bool
+}
+
+type routeEval struct {
+	// Table is the candidate routing table in the config language.
+	// The active routing table is used if it is empty.
+	Table string `json:"table"`
+
+	// Requests contains one request per line.
+	// See route.Eval for the format.
+	Requests string `json:"requests"`
+}
+
+func (h *RouteEvalHandler) Operations() []Operation {
+	return []Operation{{
+		Method:   "POST",
+		Summary:  "Reports how requests would be routed with a candidate routing table",
+		Params:   []Param{prettyParam},
+		Request:  routeEval{},
+		Response: []route.EvalResult{},
+		Errors:   []int{http.StatusBadRequest},
+	}}
+}
+
+func (h *RouteEvalHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var e routeEval
+	if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	t := route.GetTable()
+	if strings.TrimSpace(e.Table) != "" {
+		var err error
+		if t, err = route.NewTable(bytes.NewBufferString(e.Table)); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	results, err := route.Eval(t, strings.NewReader(e.Requests), h.Matcher, h.GlobDisabled)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, r, results)
+}
@@ -0,0 +1,100 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/fabiolb/fabio/route"
+)
+
+// RouteEventsHandler returns the recent changes of the routing
+// table. Clients can request only the changes after a given
+// sequence number with the 'since' parameter and can receive
+// new changes as server-sent events with the 'stream' parameter
+// or by accepting 'text/event-stream'.
+type RouteEventsHandler struct{}
+
+func (h *RouteEventsHandler) Operations() []Operation {
+	return []Operation{{
+		Method:  "GET",
+		Summary: "Returns the recent changes of the routing table",
+		Params: []Param{
+			{Name: "since", In: "query", Type: "integer", Description: "Return only the changes after this sequence number"},
+			{Name: "stream", In: "query", Type: "boolean", Description: "Stream new changes as server-sent events"},
+			prettyParam,
+		},
+		Response:     []route.TableEvent{},
+		ContentTypes: []string{"text/event-stream"},
+		Errors:       []int{http.StatusBadRequest},
+	}}
+}
+
+func (h *RouteEventsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var since uint64
+	if s := r.URL.Query().Get("since"); s != "" {
+		n, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			http.Error(w, "invalid since parameter", http.StatusBadRequest)
+			return
+		}
+		since = n
+	}
+
+	_, stream := r.URL.Query()["stream"]
+	if !stream && !strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		writeJSON(w, r, route.Events.Since(since))
+		return
+	}
+
+	f, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	// subscribe before reading the backlog so that no event is lost
+	ch, cancel := route.Events.Subscribe()
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	send := func(ev route.TableEvent) bool {
+		if ev.Seq <= since {
+			return true
+		}
+		since = ev.Seq
+		data, err := json.Marshal(ev)
+		if err != nil {
+			log.Print("[ERROR] ", err)
+			return false
+		}
+		if _, err := fmt.Fprintf(w, "id: %d\nevent: routes\ndata: %s\n\n", ev.Seq, data); err != nil {
+			return false
+		}
+		f.Flush()
+		return true
+	}
+
+	for _, ev := range route.Events.Since(since) {
+		if !send(ev) {
+			return
+		}
+	}
+	f.Flush()
+
+	for {
+		select {
+		case ev := <-ch:
+			if !send(ev) {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/fabiolb/fabio/route"
+)
+
+// FailoverHandler returns the active regions of the services with the
+// failover option and forces the traffic of a service into a region.
+type FailoverHandler struct {
+	BasePath string
+}
+
+type failoverRequest struct {
+	// Service is the name of the service.
+	Service string `json:"service"`
+
+	// Region receives the traffic of the service until
+	// the forced failover is cleared.
+	Region string `json:"region"`
+}
+
+func (h *FailoverHandler) Operations() []Operation {
+	if !strings.HasSuffix(h.BasePath, "/") {
+		return []Operation{
+			{
+				Method:   "GET",
+				Summary:  "Returns the active and the healthy targets per region of the services with the failover option",
+				Params:   []Param{prettyParam},
+				Response: []route.FailoverStatus{},
+			},
+			{
+				Method:   "POST",
+				Summary:  "Moves the traffic of a service to a region independently of the health of the regions",
+				Params:   []Param{prettyParam},
+				Request:  failoverRequest{},
+				Response: route.FailoverStatus{},
+				Errors:   []int{http.StatusBadRequest},
+			},
+		}
+	}
+	service := Param{Name: "service", In: "path", Type: "string", Description: "Name of the service", Required: true}
+	return []Operation{
+		{
+			Method:   "GET",
+			Summary:  "Returns the active and the healthy targets per region of the service",
+			Params:   []Param{service, prettyParam, notModifiedParam},
+			Response: route.FailoverStatus{},
+			Errors:   []int{http.StatusNotModified, http.StatusNotFound},
+		},
+		{
+			Method:   "PUT",
+			Summary:  "Moves the traffic of the service to a region unless it is already forced into the region",
+			Params:   []Param{service, prettyParam, ifMatchParam, ifNoneMatchParam},
+			Request:  failoverRequest{},
+			Response: route.FailoverStatus{},
+			Errors:   []int{http.StatusBadRequest, http.StatusNotFound, http.StatusPreconditionFailed},
+		},
+		{
+			Method:  "DELETE",
+			Summary: "Clears the forced failover of the service",
+			Params:  []Param{service, ifMatchParam},
+			Errors:  []int{http.StatusNotFound, http.StatusPreconditionFailed},
+		},
+	}
+}
+
+func (h *FailoverHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	service := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, h.BasePath), "/")
+
+	switch {
+	case r.Method == "GET" && service == "":
+		writeJSON(w, r, route.Failovers())
+
+	case r.Method == "POST" && service == "":
+		var req failoverRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer r.Body.Close()
+
+		st, err := route.ForceFailover(req.Service, req.Region)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, r, st)
+
+	case r.Method == "GET" && service != "":
+		st, ok := failover(service)
+		if !ok {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		tag := failoverETag(st)
+		w.Header().Set("ETag", tag)
+		if matchTag(r.Header.Get("If-None-Match"), tag, true) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		writeJSON(w, r, st)
+
+	case r.Method == "PUT" && service != "":
+		var req failoverRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer r.Body.Close()
+		if req.Service != "" && req.Service != service {
+			http.Error(w, "service does not match the path", http.StatusBadRequest)
+			return
+		}
+
+		st, ok := failover(service)
+		if !ok {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		if !preconditions(w, r, failoverETag(st), st.Forced != "") {
+			return
+		}
+
+		// forcing the same region again is a no-op so that
+		// clients can apply their desired state repeatedly.
+		if st.Forced != req.Region {
+			var err error
+			if st, err = route.ForceFailover(service, req.Region); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		w.Header().Set("ETag", failoverETag(st))
+		writeJSON(w, r, st)
+
+	case r.Method == "DELETE" && service != "":
+		if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+			st, ok := failover(service)
+			if !ok || !matchTag(ifMatch, failoverETag(st), st.Forced != "") {
+				http.Error(w, "precondition failed", http.StatusPreconditionFailed)
+				return
+			}
+		}
+		if !route.ClearFailover(service) {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// failover returns the failover state of the service.
+func failover(service string) (route.FailoverStatus, bool) {
+	for _, st := range route.Failovers() {
+		if st.Service == service {
+			return st, true
+		}
+	}
+	return route.FailoverStatus{}, false
+}
+
+// failoverETag returns the entity tag of the forced region of the
+// service. The health of the regions is not part of the tag since
+// it is not set by the clients.
+func failoverETag(st route.FailoverStatus) string {
+	return contentETag([]string{st.Service, st.Forced})
+}
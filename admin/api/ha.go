@@ -0,0 +1,24 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/fabiolb/fabio/ha"
+)
+
+// HAHandler returns the state of the instance in the
+// active-standby mode.
+type HAHandler struct{}
+
+func (h *HAHandler) Operations() []Operation {
+	return []Operation{{
+		Method:   "GET",
+		Summary:  "Returns whether the instance is active or on standby",
+		Params:   []Param{prettyParam},
+		Response: ha.Status{},
+	}}
+}
+
+func (h *HAHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, ha.GetStatus())
+}
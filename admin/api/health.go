@@ -0,0 +1,28 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/fabiolb/fabio/proxy"
+)
+
+// HealthHandler reports that fabio is running
+// and fails while fabio is drained.
+type HealthHandler struct{}
+
+func (h *HealthHandler) Operations() []Operation {
+	return []Operation{{
+		Method:  "GET",
+		Summary: "Returns OK if fabio is running and not drained",
+		Errors:  []int{http.StatusServiceUnavailable},
+	}}
+}
+
+func (h *HealthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if proxy.Draining() {
+		http.Error(w, "DRAINING", http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "OK")
+}
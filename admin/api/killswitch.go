@@ -0,0 +1,234 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/fabiolb/fabio/config"
+	"github.com/fabiolb/fabio/proxy"
+)
+
+// KillSwitchHandler enables and disables the kill switches which
+// replace the responses of all HTTP routes to a service with a
+// static response.
+type KillSwitchHandler struct {
+	BasePath string
+
+	// Defaults contains the response and the lifetime of a
+	// kill switch if they are not part of the request.
+	Defaults config.KillSwitch
+}
+
+type killSwitchRequest struct {
+	// Service is the name of the service.
+	Service string `json:"service"`
+
+	// Status, ContentType and Body define the response.
+	Status      int     `json:"status"`
+	ContentType *string `json:"content_type"`
+	Body        *string `json:"body"`
+
+	// TTL is the time after which the kill switch expires, e.g. 30m.
+	TTL string `json:"ttl"`
+
+	// Reason is logged and returned with the kill switch.
+	Reason string `json:"reason"`
+}
+
+func (h *KillSwitchHandler) Operations() []Operation {
+	if !strings.HasSuffix(h.BasePath, "/") {
+		return []Operation{
+			{
+				Method:   "GET",
+				Summary:  "Returns the enabled kill switches",
+				Params:   []Param{prettyParam},
+				Response: []proxy.KillSwitch{},
+			},
+			{
+				Method:   "POST",
+				Summary:  "Enables the kill switch which replaces the responses of all routes to a service with a static response",
+				Params:   []Param{prettyParam},
+				Request:  killSwitchRequest{},
+				Response: proxy.KillSwitch{},
+				Errors:   []int{http.StatusBadRequest},
+			},
+		}
+	}
+	service := Param{Name: "service", In: "path", Type: "string", Description: "Name of the service", Required: true}
+	return []Operation{
+		{
+			Method:   "GET",
+			Summary:  "Returns the kill switch of the service",
+			Params:   []Param{service, prettyParam, notModifiedParam},
+			Response: proxy.KillSwitch{},
+			Errors:   []int{http.StatusNotModified, http.StatusNotFound},
+		},
+		{
+			Method:   "PUT",
+			Summary:  "Enables the kill switch of the service unless it is enabled with the same response",
+			Params:   []Param{service, prettyParam, ifMatchParam, ifNoneMatchParam},
+			Request:  killSwitchRequest{},
+			Response: proxy.KillSwitch{},
+			Errors:   []int{http.StatusBadRequest, http.StatusPreconditionFailed},
+		},
+		{
+			Method:  "DELETE",
+			Summary: "Disables the kill switch of the service",
+			Params:  []Param{service, ifMatchParam},
+			Errors:  []int{http.StatusNotFound, http.StatusPreconditionFailed},
+		},
+	}
+}
+
+func (h *KillSwitchHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	service := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, h.BasePath), "/")
+
+	switch {
+	case r.Method == "GET" && service == "":
+		writeJSON(w, r, proxy.KillSwitches())
+
+	case r.Method == "POST" && service == "":
+		var req killSwitchRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer r.Body.Close()
+
+		k, err := h.killSwitch(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		proxy.SetKillSwitch(k)
+		writeJSON(w, r, k)
+
+	case r.Method == "GET" && service != "":
+		k, ok := killSwitch(service)
+		if !ok {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		tag := killSwitchETag(k)
+		w.Header().Set("ETag", tag)
+		if matchTag(r.Header.Get("If-None-Match"), tag, true) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		writeJSON(w, r, k)
+
+	case r.Method == "PUT" && service != "":
+		var req killSwitchRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer r.Body.Close()
+		if req.Service != "" && req.Service != service {
+			http.Error(w, "service does not match the path", http.StatusBadRequest)
+			return
+		}
+		req.Service = service
+
+		cur, ok := killSwitch(service)
+		tag := ""
+		if ok {
+			tag = killSwitchETag(cur)
+		}
+		if !preconditions(w, r, tag, ok) {
+			return
+		}
+		k, err := h.killSwitch(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// enabling the same kill switch again is a no-op so that
+		// clients can apply their desired state repeatedly.
+		if ok && killSwitchETag(k) == tag {
+			k = cur
+		} else {
+			proxy.SetKillSwitch(k)
+		}
+		w.Header().Set("ETag", killSwitchETag(k))
+		writeJSON(w, r, k)
+
+	case r.Method == "DELETE" && service != "":
+		if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+			k, ok := killSwitch(service)
+			if !ok || !matchTag(ifMatch, killSwitchETag(k), true) {
+				http.Error(w, "precondition failed", http.StatusPreconditionFailed)
+				return
+			}
+		}
+		if !proxy.ClearKillSwitch(service) {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// killSwitch returns the kill switch for the request
+// with the defaults for the missing values.
+func (h *KillSwitchHandler) killSwitch(req killSwitchRequest) (proxy.KillSwitch, error) {
+	if req.Service == "" {
+		return proxy.KillSwitch{}, fmt.Errorf("missing service")
+	}
+
+	k := proxy.KillSwitch{
+		Service:     req.Service,
+		Status:      h.Defaults.Status,
+		ContentType: h.Defaults.ContentType,
+		Body:        h.Defaults.Body,
+		Reason:      req.Reason,
+	}
+	if req.Status != 0 {
+		if req.Status < 100 || req.Status > 999 {
+			return proxy.KillSwitch{}, fmt.Errorf("invalid status %d", req.Status)
+		}
+		k.Status = req.Status
+	}
+	if req.ContentType != nil {
+		k.ContentType = *req.ContentType
+	}
+	if req.Body != nil {
+		k.Body = *req.Body
+	}
+
+	ttl := h.Defaults.TTL
+	if req.TTL != "" {
+		d, err := time.ParseDuration(req.TTL)
+		if err != nil || d <= 0 {
+			return proxy.KillSwitch{}, fmt.Errorf("invalid ttl %q", req.TTL)
+		}
+		ttl = d
+	}
+	k.Since = time.Now().UTC().Truncate(time.Second)
+	k.Expires = k.Since.Add(ttl)
+	return k, nil
+}
+
+// killSwitch returns the enabled kill switch of the service.
+func killSwitch(service string) (proxy.KillSwitch, bool) {
+	for _, k := range proxy.KillSwitches() {
+		if k.Service == service {
+			return k, true
+		}
+	}
+	return proxy.KillSwitch{}, false
+}
+
+// killSwitchETag returns the entity tag of the response of a kill
+// switch. The time of the kill switch is not part of the tag so that
+// enabling the same kill switch again does not change it.
+func killSwitchETag(k proxy.KillSwitch) string {
+	return contentETag([]interface{}{k.Service, k.Status, k.ContentType, k.Body, k.Reason})
+}
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fabiolb/fabio/config"
+	"github.com/fabiolb/fabio/proxy"
+)
+
+func TestKillSwitchHandlerPut(t *testing.T) {
+	defer proxy.ClearKillSwitch("foo")
+
+	h := &KillSwitchHandler{BasePath: "/api/v1/killswitch", Defaults: config.KillSwitch{Status: 503, TTL: time.Hour}}
+	do := func(method, body string, header map[string]string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, "/api/v1/killswitch/foo", strings.NewReader(body))
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	if got, want := do("GET", "", nil).Code, 404; got != want {
+		t.Fatalf("got code %d want %d", got, want)
+	}
+
+	// create only if the kill switch is not enabled
+	w := do("PUT", `{"status":502}`, map[string]string{"If-None-Match": "*"})
+	if got, want := w.Code, 200; got != want {
+		t.Fatalf("got code %d want %d", got, want)
+	}
+	tag := w.Header().Get("ETag")
+	if tag == "" {
+		t.Fatal("got no etag")
+	}
+	k, _ := killSwitch("foo")
+	if got, want := do("PUT", `{"status":502}`, map[string]string{"If-None-Match": "*"}).Code, 412; got != want {
+		t.Fatalf("got code %d want %d", got, want)
+	}
+
+	// applying the same kill switch again does not change it
+	w = do("PUT", `{"status":502}`, nil)
+	if got, want := w.Header().Get("ETag"), tag; got != want {
+		t.Fatalf("got etag %q want %q", got, want)
+	}
+	if got, _ := killSwitch("foo"); got != k {
+		t.Fatalf("got %v want unchanged %v", got, k)
+	}
+
+	if got, want := do("GET", "", map[string]string{"If-None-Match": tag}).Code, 304; got != want {
+		t.Fatalf("got code %d want %d", got, want)
+	}
+	if got, want := do("PUT", `{"service":"bar"}`, nil).Code, 400; got != want {
+		t.Fatalf("got code %d want %d", got, want)
+	}
+
+	// updates with a stale etag fail
+	w = do("PUT", `{"status":500}`, map[string]string{"If-Match": tag})
+	if got, want := w.Code, 200; got != want {
+		t.Fatalf("got code %d want %d", got, want)
+	}
+	if w.Header().Get("ETag") == tag {
+		t.Fatal("got unchanged etag")
+	}
+	if got, want := do("PUT", `{"status":404}`, map[string]string{"If-Match": tag}).Code, 412; got != want {
+		t.Fatalf("got code %d want %d", got, want)
+	}
+	if got, want := do("DELETE", "", map[string]string{"If-Match": tag}).Code, 412; got != want {
+		t.Fatalf("got code %d want %d", got, want)
+	}
+	if got, want := do("DELETE", "", map[string]string{"If-Match": w.Header().Get("ETag")}).Code, 204; got != want {
+		t.Fatalf("got code %d want %d", got, want)
+	}
+}
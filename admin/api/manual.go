@@ -1,9 +1,13 @@
 package api
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/fabiolb/fabio/registry"
 )
@@ -18,6 +22,36 @@ type manual struct {
 	Version uint64 `json:"version,string"`
 }
 
+var (
+	ifMatchParam     = Param{Name: "If-Match", In: "header", Type: "string", Description: "Update only if the ETag matches"}
+	ifNoneMatchParam = Param{Name: "If-None-Match", In: "header", Type: "string", Description: "'*' updates only if the value does not exist"}
+	notModifiedParam = Param{Name: "If-None-Match", In: "header", Type: "string", Description: "Return 304 if the ETag matches"}
+)
+
+func (h *ManualHandler) Operations() []Operation {
+	var params []Param
+	if strings.HasSuffix(h.BasePath, "/") {
+		params = []Param{{Name: "path", In: "path", Type: "string", Description: "Path of the manual override"}}
+	}
+	return []Operation{
+		{
+			Method:   "GET",
+			Summary:  "Returns the manual overrides and their version",
+			Params:   append(params, prettyParam, notModifiedParam),
+			Response: manual{},
+			Errors:   []int{http.StatusNotModified, http.StatusInternalServerError},
+		},
+		{
+			Method:   "PUT",
+			Summary:  "Updates the manual overrides if the version matches",
+			Params:   append(params, ifMatchParam, ifNoneMatchParam),
+			Request:  manual{},
+			Response: manual{},
+			Errors:   []int{http.StatusBadRequest, http.StatusConflict, http.StatusPreconditionFailed, http.StatusInternalServerError},
+		},
+	}
+}
+
 func (h *ManualHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// we need this for testing.
 	// under normal circumstances this is never nil
@@ -35,6 +69,11 @@ func (h *ManualHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		w.Header().Set("ETag", etag(version))
+		if matchETag(r.Header.Get("If-None-Match"), version) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
 		writeJSON(w, r, manual{value, version})
 		return
 
@@ -47,6 +86,38 @@ func (h *ManualHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 		defer r.Body.Close()
 
+		value, version, err := registry.Default.ReadManual(path)
+		if err != nil {
+			log.Print("[ERROR] ", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// preconditions from the If-Match and If-None-Match headers
+		// take precedence over the version in the body.
+		ifMatch, ifNoneMatch := r.Header.Get("If-Match"), r.Header.Get("If-None-Match")
+		precondition := ifMatch != "" || ifNoneMatch != ""
+		switch {
+		case ifNoneMatch == "*" && version != 0:
+			w.Header().Set("ETag", etag(version))
+			http.Error(w, "precondition failed", http.StatusPreconditionFailed)
+			return
+		case ifMatch != "" && !matchETag(ifMatch, version):
+			w.Header().Set("ETag", etag(version))
+			http.Error(w, "precondition failed", http.StatusPreconditionFailed)
+			return
+		case precondition:
+			m.Version = version
+		}
+
+		// writing the current value again is a no-op so that
+		// clients can apply their desired state repeatedly.
+		if value == m.Value && version != 0 {
+			w.Header().Set("ETag", etag(version))
+			writeJSON(w, r, manual{value, version})
+			return
+		}
+
 		ok, err := registry.Default.WriteManual(path, m.Value, m.Version)
 		if err != nil {
 			log.Print("[ERROR] ", err)
@@ -55,11 +126,74 @@ func (h *ManualHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 
 		if !ok {
+			if precondition {
+				http.Error(w, "precondition failed", http.StatusPreconditionFailed)
+				return
+			}
 			http.Error(w, "version mismatch", http.StatusConflict)
 			return
 		}
 
+		value, version, err = registry.Default.ReadManual(path)
+		if err != nil {
+			log.Print("[ERROR] ", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("ETag", etag(version))
+		writeJSON(w, r, manual{value, version})
+
 	default:
 		http.Error(w, "not allowed", http.StatusMethodNotAllowed)
 	}
 }
+
+// etag returns the entity tag for the version of the manual overrides.
+func etag(version uint64) string {
+	return strconv.Quote(strconv.FormatUint(version, 10))
+}
+
+// matchETag returns true if the value of an If-Match or If-None-Match
+// header matches the version. Weak tags are compared like strong
+// tags since the version identifies the value.
+func matchETag(header string, version uint64) bool {
+	return matchTag(header, etag(version), version != 0)
+}
+
+// matchTag returns true if the value of an If-Match or If-None-Match
+// header contains the tag or is '*' and the resource exists.
+func matchTag(header, tag string, exists bool) bool {
+	if strings.TrimSpace(header) == "*" {
+		return exists
+	}
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// contentETag returns the entity tag for the JSON
+// encoding of a runtime setting.
+func contentETag(v interface{}) string {
+	b, _ := json.Marshal(v)
+	sum := sha256.Sum256(b)
+	return strconv.Quote(hex.EncodeToString(sum[:8]))
+}
+
+// preconditions checks the If-Match and If-None-Match headers of an
+// update of a runtime setting with its tag. It responds with 412 and
+// returns false if they do not match.
+func preconditions(w http.ResponseWriter, r *http.Request, tag string, exists bool) bool {
+	ifMatch, ifNoneMatch := r.Header.Get("If-Match"), r.Header.Get("If-None-Match")
+	if (ifMatch != "" && !matchTag(ifMatch, tag, exists)) || (ifNoneMatch != "" && matchTag(ifNoneMatch, tag, exists)) {
+		if tag != "" {
+			w.Header().Set("ETag", tag)
+		}
+		http.Error(w, "precondition failed", http.StatusPreconditionFailed)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fabiolb/fabio/registry"
+)
+
+// manualBackend stores a single manual override
+// with the CAS semantics of the consul backend.
+type manualBackend struct {
+	registry.Backend
+	value   string
+	version uint64
+}
+
+func (b *manualBackend) ReadManual(string) (string, uint64, error) {
+	return b.value, b.version, nil
+}
+
+func (b *manualBackend) WriteManual(path, value string, version uint64) (bool, error) {
+	if version != b.version {
+		return false, nil
+	}
+	b.value, b.version = value, b.version+1
+	return true, nil
+}
+
+func TestManualHandler(t *testing.T) {
+	tests := []struct {
+		desc        string
+		method      string
+		header      map[string]string
+		body        string
+		code        int
+		etag        string
+		value       string
+		wantVersion uint64
+	}{
+		{"get", "GET", nil, "", 200, `"3"`, "route del a", 3},
+		{"get not modified", "GET", map[string]string{"If-None-Match": `"3"`}, "", 304, `"3"`, "route del a", 3},
+		{"put with version", "PUT", nil, `{"value":"route del b","version":"3"}`, 200, `"4"`, "route del b", 4},
+		{"put with stale version", "PUT", nil, `{"value":"route del b","version":"2"}`, 409, "", "route del a", 3},
+		{"put same value with stale version", "PUT", nil, `{"value":"route del a","version":"1"}`, 200, `"3"`, "route del a", 3},
+		{"put if match", "PUT", map[string]string{"If-Match": `"3"`}, `{"value":"route del b"}`, 200, `"4"`, "route del b", 4},
+		{"put if match weak", "PUT", map[string]string{"If-Match": `W/"3"`}, `{"value":"route del b"}`, 200, `"4"`, "route del b", 4},
+		{"put if match failed", "PUT", map[string]string{"If-Match": `"2"`}, `{"value":"route del b","version":"3"}`, 412, `"3"`, "route del a", 3},
+		{"put if match any", "PUT", map[string]string{"If-Match": "*"}, `{"value":"route del b"}`, 200, `"4"`, "route del b", 4},
+		{"put if none match failed", "PUT", map[string]string{"If-None-Match": "*"}, `{"value":"route del b"}`, 412, `"3"`, "route del a", 3},
+	}
+
+	defer func(b registry.Backend) { registry.Default = b }(registry.Default)
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			b := &manualBackend{value: "route del a", version: 3}
+			registry.Default = b
+
+			req := httptest.NewRequest(tt.method, "/api/manual", strings.NewReader(tt.body))
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			(&ManualHandler{BasePath: "/api/manual"}).ServeHTTP(w, req)
+
+			if got, want := w.Code, tt.code; got != want {
+				t.Fatalf("got code %d want %d", got, want)
+			}
+			if got, want := w.Header().Get("ETag"), tt.etag; got != want {
+				t.Fatalf("got etag %q want %q", got, want)
+			}
+			if got, want := b.value, tt.value; got != want {
+				t.Fatalf("got value %q want %q", got, want)
+			}
+			if got, want := b.version, tt.wantVersion; got != want {
+				t.Fatalf("got version %d want %d", got, want)
+			}
+		})
+	}
+
+	t.Run("create if none match", func(t *testing.T) {
+		b := &manualBackend{}
+		registry.Default = b
+		req := httptest.NewRequest("PUT", "/api/manual", strings.NewReader(`{"value":"route del a"}`))
+		req.Header.Set("If-None-Match", "*")
+		w := httptest.NewRecorder()
+		(&ManualHandler{BasePath: "/api/manual"}).ServeHTTP(w, req)
+		if got, want := w.Code, http.StatusOK; got != want {
+			t.Fatalf("got code %d want %d", got, want)
+		}
+		if got, want := w.Header().Get("ETag"), `"1"`; got != want {
+			t.Fatalf("got etag %q want %q", got, want)
+		}
+	})
+}
@@ -0,0 +1,49 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/fabiolb/fabio/noroute"
+)
+
+// NoRouteHandler returns the hosts and paths which
+// were most frequently requested without a route.
+type NoRouteHandler struct{}
+
+type apiNoRoute struct {
+	Total   uint64          `json:"total"`
+	Entries []noroute.Entry `json:"entries"`
+}
+
+func (h *NoRouteHandler) Operations() []Operation {
+	return []Operation{{
+		Method:  "GET",
+		Summary: "Returns the hosts and paths which were most frequently requested without a route",
+		Params: []Param{
+			{Name: "n", In: "query", Type: "integer", Description: "Maximum number of entries. Defaults to all"},
+			prettyParam,
+		},
+		Response: apiNoRoute{},
+		Errors:   []int{http.StatusBadRequest},
+	}}
+}
+
+func (h *NoRouteHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n := 0
+	if s := r.URL.Query().Get("n"); s != "" {
+		var err error
+		n, err = strconv.Atoi(s)
+		if err != nil || n < 1 {
+			http.Error(w, fmt.Sprintf("invalid n %q. Must be a positive number", s), http.StatusBadRequest)
+			return
+		}
+	}
+
+	entries, total := noroute.Top(n)
+	if entries == nil {
+		entries = []noroute.Entry{}
+	}
+	writeJSON(w, r, apiNoRoute{Total: total, Entries: entries})
+}
@@ -0,0 +1,259 @@
+package api
+
+import (
+	"encoding"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Operation describes a single method of an API endpoint.
+type Operation struct {
+	Method  string
+	Summary string
+	Params  []Param
+
+	// Request is a value of the type of the JSON request
+	// body or nil if the operation has no request body.
+	Request interface{}
+
+	// Response is a value of the type of the JSON response
+	// or nil if the response is plain text.
+	Response interface{}
+
+	// ContentTypes lists the media types of the response
+	// in addition to application/json.
+	ContentTypes []string
+
+	// Errors lists the status codes of the error responses.
+	Errors []int
+}
+
+// Param describes a query or path parameter of an operation.
+type Param struct {
+	Name        string
+	In          string
+	Type        string
+	Description string
+	Required    bool
+}
+
+// prettyParam is supported by all handlers which use writeJSON.
+var prettyParam = Param{Name: "pretty", In: "query", Type: "boolean", Description: "Indent the JSON response"}
+
+// Describer is implemented by handlers which document their
+// operations in the OpenAPI document.
+type Describer interface {
+	Operations() []Operation
+}
+
+// OpenAPIHandler serves an OpenAPI 3 document which is generated
+// from the operations of the registered handlers.
+type OpenAPIHandler struct {
+	Title   string
+	Version string
+
+	mu    sync.Mutex
+	paths map[string][]Operation
+}
+
+// Add documents the operations of h under path if h is a Describer.
+func (h *OpenAPIHandler) Add(path string, handler http.Handler) {
+	d, ok := handler.(Describer)
+	if !ok {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.paths == nil {
+		h.paths = map[string][]Operation{}
+	}
+	h.paths[path] = append(h.paths[path], d.Operations()...)
+}
+
+func (h *OpenAPIHandler) Operations() []Operation {
+	return []Operation{{
+		Method:   "GET",
+		Summary:  "Returns the OpenAPI document of the API",
+		Params:   []Param{prettyParam},
+		Response: map[string]interface{}{},
+	}}
+}
+
+func (h *OpenAPIHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, h.Document())
+}
+
+// Document returns the OpenAPI 3 document.
+func (h *OpenAPIHandler) Document() map[string]interface{} {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	paths := map[string]interface{}{}
+	for path, ops := range h.paths {
+		item := map[string]interface{}{}
+		for _, op := range ops {
+			item[strings.ToLower(op.Method)] = operationDoc(op)
+		}
+		paths[path] = item
+	}
+
+	title := h.Title
+	if title == "" {
+		title = "fabio admin API"
+	}
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   title,
+			"version": h.Version,
+		},
+		"paths": paths,
+	}
+}
+
+func operationDoc(op Operation) map[string]interface{} {
+	doc := map[string]interface{}{"summary": op.Summary}
+
+	if len(op.Params) > 0 {
+		var params []interface{}
+		for _, p := range op.Params {
+			params = append(params, map[string]interface{}{
+				"name":        p.Name,
+				"in":          p.In,
+				"description": p.Description,
+				"required":    p.Required || p.In == "path",
+				"schema":      map[string]interface{}{"type": p.Type},
+			})
+		}
+		doc["parameters"] = params
+	}
+
+	if op.Request != nil {
+		doc["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": schemaOf(reflect.TypeOf(op.Request), nil)},
+			},
+		}
+	}
+
+	content := map[string]interface{}{}
+	if op.Response != nil {
+		content["application/json"] = map[string]interface{}{"schema": schemaOf(reflect.TypeOf(op.Response), nil)}
+	} else {
+		content["text/plain"] = map[string]interface{}{"schema": map[string]interface{}{"type": "string"}}
+	}
+	for _, ct := range op.ContentTypes {
+		content[ct] = map[string]interface{}{"schema": map[string]interface{}{"type": "string"}}
+	}
+
+	responses := map[string]interface{}{
+		"200": map[string]interface{}{"description": "OK", "content": content},
+	}
+	errs := append([]int(nil), op.Errors...)
+	sort.Ints(errs)
+	for _, code := range errs {
+		responses[strconv.Itoa(code)] = map[string]interface{}{"description": http.StatusText(code)}
+	}
+	doc["responses"] = responses
+	return doc
+}
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// schemaOf returns the JSON schema of the JSON encoding of values
+// of type t. seen contains the struct types which are being
+// expanded to stop recursive types.
+func schemaOf(t reflect.Type, seen map[reflect.Type]bool) map[string]interface{} {
+	if t == nil {
+		return map[string]interface{}{}
+	}
+	if t == timeType {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+	if t.Implements(textMarshalerType) {
+		return map[string]interface{}{"type": "string"}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return schemaOf(t.Elem(), seen)
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": schemaOf(t.Elem(), seen)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaOf(t.Elem(), seen)}
+	case reflect.Struct:
+		if seen[t] {
+			return map[string]interface{}{"type": "object"}
+		}
+		next := map[reflect.Type]bool{t: true}
+		for k := range seen {
+			next[k] = true
+		}
+		props := map[string]interface{}{}
+		addFields(t, props, next)
+		return map[string]interface{}{"type": "object", "properties": props}
+	default:
+		// interfaces can hold any value
+		return map[string]interface{}{}
+	}
+}
+
+// addFields adds the schemas of the exported fields of the struct
+// type t to props following the rules of encoding/json.
+func addFields(t reflect.Type, props map[string]interface{}, seen map[reflect.Type]bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts := tag, ""
+		if n := strings.Index(tag, ","); n >= 0 {
+			name, opts = tag[:n], tag[n+1:]
+		}
+
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				addFields(ft, props, seen)
+				continue
+			}
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		if strings.Contains(opts, "string") {
+			props[name] = map[string]interface{}{"type": "string"}
+		} else {
+			props[name] = schemaOf(f.Type, seen)
+		}
+	}
+}
@@ -12,6 +12,16 @@ type ManualPathsHandler struct {
 	Prefix string
 }
 
+func (h *ManualPathsHandler) Operations() []Operation {
+	return []Operation{{
+		Method:   "GET",
+		Summary:  "Returns the paths of the manual overrides",
+		Params:   []Param{prettyParam},
+		Response: []string{},
+		Errors:   []int{http.StatusInternalServerError},
+	}}
+}
+
 func (h *ManualPathsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// we need this for testing.
 	// under normal circumstances this is never nil
@@ -0,0 +1,39 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/fabiolb/fabio/registry"
+)
+
+// RegistryHandler reports the status of the registry backend
+// if the backend supports it.
+type RegistryHandler struct{}
+
+func (h *RegistryHandler) Operations() []Operation {
+	var status interface{} = map[string]interface{}{}
+	if sr, ok := registry.Default.(registry.StatusReporter); ok {
+		status = sr.Status()
+	}
+	return []Operation{{
+		Method:   "GET",
+		Summary:  "Returns the status of the registry backend",
+		Params:   []Param{prettyParam},
+		Response: status,
+	}}
+}
+
+func (h *RegistryHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// we need this for testing.
+	// under normal circumstances this is never nil
+	if registry.Default == nil {
+		return
+	}
+
+	sr, ok := registry.Default.(registry.StatusReporter)
+	if !ok {
+		writeJSON(w, r, struct{}{})
+		return
+	}
+	writeJSON(w, r, sr.Status())
+}
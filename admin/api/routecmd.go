@@ -0,0 +1,384 @@
+package api
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/fabiolb/fabio/registry"
+	"github.com/fabiolb/fabio/route"
+)
+
+// routeCmdPath is the path of the manual overrides below which
+// the route commands of the API are stored. Each route is stored
+// as a separate override so that it can be updated on its own.
+const routeCmdPath = "/routes/"
+
+// reRouteID matches the valid ids of a route.
+var reRouteID = regexp.MustCompile(`^[a-zA-Z0-9_.-]{1,64}$`)
+
+// RouteCmdHandler manages single route commands which are stored
+// as manual overrides in the registry backend.
+type RouteCmdHandler struct {
+	BasePath string
+
+	// Prefix is the prefix of the paths returned by the backend
+	// which is removed to get the path of the override.
+	Prefix string
+}
+
+type routeCmd struct {
+	ID      string `json:"id"`
+	Cmd     string `json:"cmd"`
+	Version uint64 `json:"version,string,omitempty"`
+}
+
+func (h *RouteCmdHandler) Operations() []Operation {
+	if !strings.HasSuffix(h.BasePath, "/") {
+		return []Operation{
+			{
+				Method:   "GET",
+				Summary:  "Returns the route commands managed by the API",
+				Params:   []Param{prettyParam},
+				Response: []routeCmd{},
+				Errors:   []int{http.StatusInternalServerError},
+			},
+			{
+				Method:   "POST",
+				Summary:  "Creates a route command with the given or a generated id",
+				Request:  routeCmd{},
+				Response: routeCmd{},
+				Errors:   []int{http.StatusBadRequest, http.StatusConflict, http.StatusInternalServerError, http.StatusNotImplemented},
+			},
+		}
+	}
+	id := Param{Name: "id", In: "path", Type: "string", Description: "Id of the route", Required: true}
+	return []Operation{
+		{
+			Method:   "GET",
+			Summary:  "Returns the route command and its version",
+			Params:   []Param{id, prettyParam, notModifiedParam},
+			Response: routeCmd{},
+			Errors:   []int{http.StatusNotModified, http.StatusNotFound, http.StatusInternalServerError},
+		},
+		{
+			Method:   "PUT",
+			Summary:  "Creates or replaces the route command if the version matches",
+			Params:   []Param{id, ifMatchParam, {Name: "If-None-Match", In: "header", Type: "string", Description: "'*' creates the route only if it does not exist"}},
+			Request:  routeCmd{},
+			Response: routeCmd{},
+			Errors:   []int{http.StatusBadRequest, http.StatusConflict, http.StatusPreconditionFailed, http.StatusPreconditionRequired, http.StatusInternalServerError, http.StatusNotImplemented},
+		},
+		{
+			Method:  "DELETE",
+			Summary: "Deletes the route command if the version matches",
+			Params:  []Param{id, {Name: "If-Match", In: "header", Type: "string", Description: "ETag of the route", Required: true}},
+			Errors:  []int{http.StatusNotFound, http.StatusPreconditionFailed, http.StatusPreconditionRequired, http.StatusInternalServerError, http.StatusNotImplemented},
+		},
+	}
+}
+
+func (h *RouteCmdHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// we need this for testing.
+	// under normal circumstances this is never nil
+	if registry.Default == nil {
+		return
+	}
+
+	id := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, h.BasePath), "/")
+	if id == "" {
+		switch r.Method {
+		case "GET":
+			h.list(w, r)
+		case "POST":
+			h.create(w, r)
+		default:
+			http.Error(w, "not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	if !reRouteID.MatchString(id) {
+		http.Error(w, "invalid route id", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		cmd, version, err := registry.Default.ReadManual(routeCmdPath + id)
+		if err != nil {
+			log.Print("[ERROR] ", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if cmd == "" {
+			http.Error(w, "route not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("ETag", etag(version))
+		if matchETag(r.Header.Get("If-None-Match"), version) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		writeJSON(w, r, routeCmd{id, cmd, version})
+	case "PUT":
+		h.update(w, r, id)
+	case "DELETE":
+		h.delete(w, r, id)
+	default:
+		http.Error(w, "not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// list returns the route commands sorted by id which
+// is also the order in which they are applied.
+func (h *RouteCmdHandler) list(w http.ResponseWriter, r *http.Request) {
+	paths, err := registry.Default.ManualPaths()
+	if err != nil {
+		log.Print("[ERROR] ", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	cmds := []routeCmd{}
+	for _, p := range paths {
+		p = strings.TrimPrefix(p, h.Prefix)
+		id := strings.TrimPrefix(p, routeCmdPath)
+		if id == p || !reRouteID.MatchString(id) {
+			continue
+		}
+		cmd, version, err := registry.Default.ReadManual(p)
+		if err != nil {
+			log.Print("[ERROR] ", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// the route was deleted in the meantime
+		if cmd == "" {
+			continue
+		}
+		cmds = append(cmds, routeCmd{id, cmd, version})
+	}
+	sort.Slice(cmds, func(i, j int) bool { return cmds[i].ID < cmds[j].ID })
+	writeJSON(w, r, cmds)
+}
+
+func (h *RouteCmdHandler) create(w http.ResponseWriter, r *http.Request) {
+	c, err := readRouteCmd(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if c.ID == "" {
+		if c.ID, err = newRouteID(); err != nil {
+			log.Print("[ERROR] ", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	if !writable(w) {
+		return
+	}
+
+	ok, err := registry.Default.WriteManual(routeCmdPath+c.ID, c.Cmd, 0)
+	if err != nil {
+		log.Print("[ERROR] ", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "route exists", http.StatusConflict)
+		return
+	}
+	log.Printf("[INFO] api: Created route %q: %s", c.ID, c.Cmd)
+
+	w.Header().Set("Location", strings.TrimSuffix(h.BasePath, "/")+"/"+c.ID)
+	h.written(w, r, c.ID, http.StatusCreated)
+}
+
+// update creates or replaces a route. Replacing a route requires its
+// version either in the If-Match header or in the request body.
+func (h *RouteCmdHandler) update(w http.ResponseWriter, r *http.Request, id string) {
+	c, err := readRouteCmd(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if c.ID != "" && c.ID != id {
+		http.Error(w, "route id does not match the path", http.StatusBadRequest)
+		return
+	}
+	if !writable(w) {
+		return
+	}
+
+	cmd, version, err := registry.Default.ReadManual(routeCmdPath + id)
+	if err != nil {
+		log.Print("[ERROR] ", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	exists := cmd != ""
+
+	ifMatch, ifNoneMatch := r.Header.Get("If-Match"), r.Header.Get("If-None-Match")
+	precondition := ifMatch != "" || ifNoneMatch != ""
+	switch {
+	case ifNoneMatch == "*" && exists:
+		w.Header().Set("ETag", etag(version))
+		http.Error(w, "precondition failed", http.StatusPreconditionFailed)
+		return
+	case ifMatch != "" && (!exists || !matchETag(ifMatch, version)):
+		if exists {
+			w.Header().Set("ETag", etag(version))
+		}
+		http.Error(w, "precondition failed", http.StatusPreconditionFailed)
+		return
+	case !exists:
+		c.Version = 0
+	case precondition:
+		c.Version = version
+	case c.Version == 0:
+		http.Error(w, "version required", http.StatusPreconditionRequired)
+		return
+	}
+
+	// writing the current command again is a no-op so that
+	// clients can apply their desired state repeatedly.
+	if exists && cmd == c.Cmd {
+		w.Header().Set("ETag", etag(version))
+		writeJSON(w, r, routeCmd{id, cmd, version})
+		return
+	}
+
+	ok, err := registry.Default.WriteManual(routeCmdPath+id, c.Cmd, c.Version)
+	if err != nil {
+		log.Print("[ERROR] ", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		if precondition {
+			http.Error(w, "precondition failed", http.StatusPreconditionFailed)
+			return
+		}
+		http.Error(w, "version mismatch", http.StatusConflict)
+		return
+	}
+	log.Printf("[INFO] api: Updated route %q: %s", id, c.Cmd)
+
+	code := http.StatusOK
+	if !exists {
+		code = http.StatusCreated
+	}
+	h.written(w, r, id, code)
+}
+
+// delete removes a route if the If-Match header matches its version.
+func (h *RouteCmdHandler) delete(w http.ResponseWriter, r *http.Request, id string) {
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" {
+		http.Error(w, "If-Match header required", http.StatusPreconditionRequired)
+		return
+	}
+	if !writable(w) {
+		return
+	}
+
+	cmd, version, err := registry.Default.ReadManual(routeCmdPath + id)
+	if err != nil {
+		log.Print("[ERROR] ", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if cmd == "" {
+		http.Error(w, "route not found", http.StatusNotFound)
+		return
+	}
+	if !matchETag(ifMatch, version) {
+		w.Header().Set("ETag", etag(version))
+		http.Error(w, "precondition failed", http.StatusPreconditionFailed)
+		return
+	}
+
+	ok, err := registry.Default.(registry.ManualDeleter).DeleteManual(routeCmdPath+id, version)
+	if errors.Is(err, registry.ErrNotSupported) {
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+		return
+	}
+	if err != nil {
+		log.Print("[ERROR] ", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "precondition failed", http.StatusPreconditionFailed)
+		return
+	}
+	log.Printf("[INFO] api: Deleted route %q", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// written returns the route which was just stored.
+func (h *RouteCmdHandler) written(w http.ResponseWriter, r *http.Request, id string, code int) {
+	cmd, version, err := registry.Default.ReadManual(routeCmdPath + id)
+	if err != nil {
+		log.Print("[ERROR] ", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("ETag", etag(version))
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(routeCmd{id, cmd, version})
+}
+
+// writable returns false and writes an error if the
+// backend cannot store the routes of the API.
+func writable(w http.ResponseWriter) bool {
+	if _, ok := registry.Default.(registry.ManualDeleter); !ok {
+		http.Error(w, "registry backend does not support route updates", http.StatusNotImplemented)
+		return false
+	}
+	return true
+}
+
+// readRouteCmd decodes and validates the route in the request body.
+func readRouteCmd(r *http.Request) (routeCmd, error) {
+	defer r.Body.Close()
+	var c routeCmd
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		return c, err
+	}
+	if c.ID != "" && !reRouteID.MatchString(c.ID) {
+		return c, errors.New("invalid route id")
+	}
+	c.Cmd = strings.TrimSpace(c.Cmd)
+	if strings.ContainsAny(c.Cmd, "\r\n") {
+		return c, errors.New("route must be a single command")
+	}
+	defs, err := route.Parse(bytes.NewBufferString(c.Cmd))
+	if err != nil {
+		return c, err
+	}
+	if len(defs) != 1 {
+		return c, fmt.Errorf("invalid route %q", c.Cmd)
+	}
+	return c, nil
+}
+
+// newRouteID returns a random id for a route.
+func newRouteID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,179 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/fabiolb/fabio/registry"
+)
+
+// kvBackend stores manual overrides with the
+// CAS semantics of the consul backend.
+type kvBackend struct {
+	registry.Backend
+	kv    map[string]manual
+	index uint64
+}
+
+func (b *kvBackend) ManualPaths() ([]string, error) {
+	var paths []string
+	for p := range b.kv {
+		paths = append(paths, "fabio/config"+p)
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+func (b *kvBackend) ReadManual(path string) (string, uint64, error) {
+	m := b.kv[path]
+	return m.Value, m.Version, nil
+}
+
+func (b *kvBackend) WriteManual(path, value string, version uint64) (bool, error) {
+	if b.kv[path].Version != version {
+		return false, nil
+	}
+	b.index++
+	b.kv[path] = manual{value, b.index}
+	return true, nil
+}
+
+func (b *kvBackend) DeleteManual(path string, version uint64) (bool, error) {
+	if b.kv[path].Version != version {
+		return false, nil
+	}
+	delete(b.kv, path)
+	return true, nil
+}
+
+func TestRouteCmdHandler(t *testing.T) {
+	tests := []struct {
+		desc   string
+		method string
+		uri    string
+		header map[string]string
+		body   string
+		code   int
+		etag   string
+		routes map[string]string
+	}{
+		{"list", "GET", "/api/v1/routes", nil, "", 200, "", nil},
+		{"get", "GET", "/api/v1/routes/web", nil, "", 200, `"3"`, nil},
+		{"get not modified", "GET", "/api/v1/routes/web", map[string]string{"If-None-Match": `"3"`}, "", 304, `"3"`, nil},
+		{"get unknown", "GET", "/api/v1/routes/api", nil, "", 404, "", nil},
+		{"get invalid id", "GET", "/api/v1/routes/a/b", nil, "", 400, "", nil},
+		{"post", "POST", "/api/v1/routes", nil, `{"id":"api","cmd":"route add api /api http://1.2.3.4/"}`, 201, `"4"`, map[string]string{"api": "route add api /api http://1.2.3.4/"}},
+		{"post existing", "POST", "/api/v1/routes", nil, `{"id":"web","cmd":"route add api /api http://1.2.3.4/"}`, 409, "", nil},
+		{"post invalid route", "POST", "/api/v1/routes", nil, `{"id":"api","cmd":"route add api"}`, 400, "", nil},
+		{"post multiple routes", "POST", "/api/v1/routes", nil, `{"id":"api","cmd":"route del a\nroute del b"}`, 400, "", nil},
+		{"post comment", "POST", "/api/v1/routes", nil, `{"id":"api","cmd":"# route del a"}`, 400, "", nil},
+		{"post invalid id", "POST", "/api/v1/routes", nil, `{"id":"a/b","cmd":"route del a"}`, 400, "", nil},
+		{"put new", "PUT", "/api/v1/routes/api", nil, `{"cmd":"route del api"}`, 201, `"4"`, map[string]string{"api": "route del api"}},
+		{"put with version", "PUT", "/api/v1/routes/web", nil, `{"cmd":"route del web","version":"3"}`, 200, `"4"`, map[string]string{"web": "route del web"}},
+		{"put with stale version", "PUT", "/api/v1/routes/web", nil, `{"cmd":"route del web","version":"2"}`, 409, "", nil},
+		{"put without version", "PUT", "/api/v1/routes/web", nil, `{"cmd":"route del web"}`, 428, "", nil},
+		{"put same route", "PUT", "/api/v1/routes/web", nil, `{"cmd":"route add web / http://1.2.3.4/","version":"1"}`, 200, `"3"`, nil},
+		{"put if match", "PUT", "/api/v1/routes/web", map[string]string{"If-Match": `"3"`}, `{"cmd":"route del web"}`, 200, `"4"`, map[string]string{"web": "route del web"}},
+		{"put if match failed", "PUT", "/api/v1/routes/web", map[string]string{"If-Match": `"2"`}, `{"cmd":"route del web"}`, 412, `"3"`, nil},
+		{"put if match unknown", "PUT", "/api/v1/routes/api", map[string]string{"If-Match": "*"}, `{"cmd":"route del api"}`, 412, "", nil},
+		{"put if none match failed", "PUT", "/api/v1/routes/web", map[string]string{"If-None-Match": "*"}, `{"cmd":"route del web"}`, 412, `"3"`, nil},
+		{"put other id", "PUT", "/api/v1/routes/web", nil, `{"id":"api","cmd":"route del web","version":"3"}`, 400, "", nil},
+		{"delete", "DELETE", "/api/v1/routes/web", map[string]string{"If-Match": `"3"`}, "", 204, "", map[string]string{}},
+		{"delete without if match", "DELETE", "/api/v1/routes/web", nil, "", 428, "", nil},
+		{"delete if match failed", "DELETE", "/api/v1/routes/web", map[string]string{"If-Match": `"2"`}, "", 412, `"3"`, nil},
+		{"delete unknown", "DELETE", "/api/v1/routes/api", map[string]string{"If-Match": "*"}, "", 404, "", nil},
+	}
+
+	defer func(b registry.Backend) { registry.Default = b }(registry.Default)
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			b := &kvBackend{
+				kv: map[string]manual{
+					"":            {"route del a", 1},
+					"/routes/web": {"route add web / http://1.2.3.4/", 3},
+				},
+				index: 3,
+			}
+			registry.Default = b
+
+			req := httptest.NewRequest(tt.method, tt.uri, strings.NewReader(tt.body))
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			(&RouteCmdHandler{BasePath: "/api/v1/routes", Prefix: "fabio/config"}).ServeHTTP(w, req)
+
+			if got, want := w.Code, tt.code; got != want {
+				t.Fatalf("got code %d want %d: %s", got, want, w.Body)
+			}
+			if got, want := w.Header().Get("ETag"), tt.etag; got != want {
+				t.Fatalf("got etag %q want %q", got, want)
+			}
+
+			want := map[string]string{"web": "route add web / http://1.2.3.4/"}
+			for id, cmd := range tt.routes {
+				want[id] = cmd
+			}
+			if tt.routes != nil && len(tt.routes) == 0 {
+				want = map[string]string{}
+			}
+			got := map[string]string{}
+			for p, m := range b.kv {
+				if strings.HasPrefix(p, routeCmdPath) {
+					got[strings.TrimPrefix(p, routeCmdPath)] = m.Value
+				}
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("got routes %v want %v", got, want)
+			}
+			if b.kv[""].Value != "route del a" {
+				t.Fatal("manual overrides modified")
+			}
+		})
+	}
+}
+
+func TestRouteCmdHandlerList(t *testing.T) {
+	defer func(b registry.Backend) { registry.Default = b }(registry.Default)
+	registry.Default = &kvBackend{kv: map[string]manual{
+		"":            {"route del a", 1},
+		"/canary":     {"route weight web / weight 0.1", 2},
+		"/routes/web": {"route add web / http://1.2.3.4/", 3},
+		"/routes/api": {"route add api /api http://1.2.3.4/", 4},
+	}}
+
+	w := httptest.NewRecorder()
+	(&RouteCmdHandler{BasePath: "/api/v1/routes", Prefix: "fabio/config"}).ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/routes", nil))
+	if w.Code != 200 {
+		t.Fatalf("got code %d want 200", w.Code)
+	}
+	var got []routeCmd
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	want := []routeCmd{
+		{"api", "route add api /api http://1.2.3.4/", 4},
+		{"web", "route add web / http://1.2.3.4/", 3},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+}
+
+func TestRouteCmdHandlerReadOnlyBackend(t *testing.T) {
+	defer func(b registry.Backend) { registry.Default = b }(registry.Default)
+	registry.Default = &manualBackend{}
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/api/v1/routes", strings.NewReader(`{"cmd":"route del web"}`))
+	(&RouteCmdHandler{BasePath: "/api/v1/routes"}).ServeHTTP(w, req)
+	if got, want := w.Code, http.StatusNotImplemented; got != want {
+		t.Fatalf("got code %d want %d", got, want)
+	}
+}
@@ -1,28 +1,84 @@
 package api
 
 import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
 	"fmt"
+	"log"
+	"mime"
 	"net/http"
+	"net/url"
 	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/fabiolb/fabio/route"
 )
 
-type RoutesHandler struct{}
+// RoutesHandler returns the routing table. Tables with more than
+// PageSize routes are returned in pages of PageSize routes unless the
+// client requests a different page size. The routes can be filtered
+// by service, host, tag, target address and option and pagination
+// applies to the filtered routes.
+type RoutesHandler struct {
+	PageSize int
+
+	// Group is the option key or the tag key by which the
+	// routes are grouped in the UI, e.g. 'env'.
+	Group string
+}
 
 type apiRoute struct {
-	Service string   `json:"service"`
-	Host    string   `json:"host"`
-	Path    string   `json:"path"`
-	Src     string   `json:"src"`
-	Dst     string   `json:"dst"`
-	Opts    string   `json:"opts"`
-	Weight  float64  `json:"weight"`
-	Tags    []string `json:"tags,omitempty"`
-	Cmd     string   `json:"cmd"`
-	Rate1   float64  `json:"rate1"`
-	Pct99   float64  `json:"pct99"`
+	Service     string            `json:"service"`
+	Host        string            `json:"host"`
+	Path        string            `json:"path"`
+	Src         string            `json:"src"`
+	Dst         string            `json:"dst"`
+	Opts        string            `json:"opts"`
+	Options     map[string]string `json:"options,omitempty"`
+	Weight      float64           `json:"weight"`
+	FixedWeight float64           `json:"fixedWeight"`
+	Flapping    bool              `json:"flapping,omitempty"`
+	Health      string            `json:"health,omitempty"`
+	Tags        []string          `json:"tags,omitempty"`
+	Group       string            `json:"group,omitempty"`
+	Source      string            `json:"source,omitempty"`
+	Cmd         string            `json:"cmd"`
+	Config      string            `json:"config"`
+	Rate1       float64           `json:"rate1"`
+	Pct99       float64           `json:"pct99"`
+}
+
+// routeFormats maps the values of the format parameter
+// and the supported media types to the export formats.
+var routeFormats = map[string]string{
+	"json":               "json",
+	"yaml":               "yaml",
+	"csv":                "csv",
+	"application/json":   "json",
+	"application/yaml":   "yaml",
+	"application/x-yaml": "yaml",
+	"text/yaml":          "yaml",
+	"text/csv":           "csv",
+}
+
+func (h *RoutesHandler) Operations() []Operation {
+	return []Operation{{
+		Method:  "GET",
+		Summary: "Returns the routing table",
+		Params: append([]Param{
+			{Name: "format", In: "query", Type: "string", Description: "Export format: json, yaml or csv. Defaults to the Accept header"},
+			{Name: "raw", In: "query", Type: "boolean", Description: "Return the routing table in the config language"},
+			{Name: "page", In: "query", Type: "integer", Description: "Page of the routing table starting at 1. The X-Total-Count header contains the number of routes and the Link header the first, prev, next and last page"},
+			{Name: "per_page", In: "query", Type: "integer", Description: "Number of routes per page. Defaults to ui.pagesize"},
+			{Name: "group", In: "query", Type: "string", Description: "Group of the target by the ui.group key. '*' matches any characters"},
+			prettyParam,
+		}, routeFilterParams...),
+		Response:     []apiRoute{},
+		ContentTypes: []string{"application/yaml", "text/csv", "text/plain"},
+		Errors:       []int{http.StatusBadRequest},
+	}}
 }
 
 func (h *RoutesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -30,7 +86,28 @@ func (h *RoutesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	if _, ok := r.URL.Query()["raw"]; ok {
 		w.Header().Set("Content-Type", "text/plain")
-		fmt.Fprintln(w, t.String())
+		t.WriteTo(w)
+		fmt.Fprintln(w)
+		return
+	}
+
+	format := "json"
+	if f := r.URL.Query().Get("format"); f != "" {
+		var ok bool
+		if format, ok = routeFormats[f]; !ok {
+			http.Error(w, fmt.Sprintf("invalid format %q. Must be one of json, yaml or csv", f), http.StatusBadRequest)
+			return
+		}
+	} else {
+		format = negotiateFormat(r.Header.Get("Accept"))
+	}
+
+	filter, err := parseRouteFilter(r.URL.Query())
+	if err == nil {
+		filter, err = h.groupFilter(filter, r.URL.Query()["group"])
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
@@ -40,31 +117,255 @@ func (h *RoutesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 	sort.Strings(hosts)
 
-	var routes []apiRoute
+	// select the targets first since the filtered
+	// table determines the number of pages
+	type target struct {
+		r *route.Route
+		t *route.Target
+	}
+	var targets []target
 	for _, host := range hosts {
 		for _, tr := range t[host] {
 			for _, tg := range tr.Targets {
-				var opts []string
-				for k, v := range tg.Opts {
-					opts = append(opts, k+"="+v)
+				if filter.match(tr, tg) {
+					targets = append(targets, target{tr, tg})
 				}
+			}
+		}
+	}
 
-				ar := apiRoute{
-					Service: tg.Service,
-					Host:    tr.Host,
-					Path:    tr.Path,
-					Src:     tr.Host + tr.Path,
-					Dst:     tg.URL.String(),
-					Opts:    strings.Join(opts, " "),
-					Weight:  tg.Weight,
-					Tags:    tg.Tags,
-					Cmd:     "route add",
-					Rate1:   tg.Timer.Rate1(),
-					Pct99:   tg.Timer.Percentile(0.99),
-				}
-				routes = append(routes, ar)
+	total := len(targets)
+	from, to, size, err := h.page(r.URL.Query(), total)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if size > 0 {
+		setPageHeaders(w, r.URL, from/size+1, size, total)
+	}
+	if from > total {
+		from = total
+	}
+
+	// only build the routes of the requested page
+	var routes []apiRoute
+	for _, x := range targets[from:to] {
+		tr, tg := x.r, x.t
+		var opts []string
+		for k, v := range tg.Opts {
+			opts = append(opts, k+"="+v)
+		}
+		sort.Strings(opts)
+
+		ar := apiRoute{
+			Service:     tg.Service,
+			Host:        tr.Host,
+			Path:        tr.Path,
+			Src:         tr.Host + tr.Path,
+			Dst:         tg.URL.String(),
+			Opts:        strings.Join(opts, " "),
+			Options:     tg.Opts,
+			Weight:      tg.Weight,
+			FixedWeight: tg.FixedWeight,
+			Flapping:    tg.Flapping(),
+			Health:      targetHealth(tg),
+			Tags:        tg.Tags,
+			Group:       routeGroup(tg, h.Group),
+			Source:      tg.Source,
+			Cmd:         "route add",
+			Config:      tr.TargetConfig(tg, false),
+			Rate1:       tg.Timer.Rate1(),
+			Pct99:       tg.Timer.Percentile(0.99),
+		}
+		routes = append(routes, ar)
+	}
+
+	switch format {
+	case "yaml":
+		w.Header().Set("Content-Type", "application/yaml; charset=utf-8")
+		w.Write(routesYAML(routes))
+	case "csv":
+		buf, err := routesCSV(routes)
+		if err != nil {
+			log.Print("[ERROR] ", err)
+			http.Error(w, "internal error", 500)
+			return
+		}
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Write(buf)
+	default:
+		writeJSON(w, r, routes)
+	}
+}
+
+// page returns the index of the first and the one after the last
+// route of the requested page and the page size. The size is zero if
+// the whole table is returned which is the case when the table has no
+// more than PageSize routes and no page was requested.
+func (h *RoutesHandler) page(q url.Values, total int) (from, to, size int, err error) {
+	size, page := h.PageSize, 1
+	if v := q.Get("per_page"); v != "" {
+		if size, err = strconv.Atoi(v); err != nil || size < 1 {
+			return 0, 0, 0, fmt.Errorf("invalid per_page %q", v)
+		}
+	}
+	if v := q.Get("page"); v != "" {
+		if page, err = strconv.Atoi(v); err != nil || page < 1 {
+			return 0, 0, 0, fmt.Errorf("invalid page %q", v)
+		}
+	}
+	if size < 1 || (total <= size && q.Get("page") == "" && q.Get("per_page") == "") {
+		return 0, total, 0, nil
+	}
+	from = (page - 1) * size
+	to = from + size
+	if to > total {
+		to = total
+	}
+	return from, to, size, nil
+}
+
+// setPageHeaders sets the X-Total-Count header and a Link header
+// with the first, prev, next and last page.
+func setPageHeaders(w http.ResponseWriter, u *url.URL, page, size, total int) {
+	last := (total + size - 1) / size
+	if last < 1 {
+		last = 1
+	}
+
+	link := func(page int, rel string) string {
+		q := u.Query()
+		q.Set("page", strconv.Itoa(page))
+		q.Set("per_page", strconv.Itoa(size))
+		return fmt.Sprintf("<%s?%s>; rel=%q", u.Path, q.Encode(), rel)
+	}
+	links := []string{link(1, "first")}
+	if page > 1 {
+		links = append(links, link(page-1, "prev"))
+	}
+	if page < last {
+		links = append(links, link(page+1, "next"))
+	}
+	links = append(links, link(last, "last"))
+
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	w.Header().Set("Link", strings.Join(links, ", "))
+}
+
+// negotiateFormat returns the export format for the first media
+// type of the Accept header which is supported. The default is json.
+func negotiateFormat(accept string) string {
+	for _, s := range strings.Split(accept, ",") {
+		mt, _, err := mime.ParseMediaType(strings.TrimSpace(s))
+		if err != nil {
+			continue
+		}
+		if f, ok := routeFormats[mt]; ok && strings.Contains(mt, "/") {
+			return f
+		}
+	}
+	return "json"
+}
+
+// targetHealth returns the result of the active health check of the
+// target, "ejected" if the outlier detection has ejected it or an
+// empty string if the target is not checked.
+func targetHealth(t *route.Target) string {
+	switch {
+	case t.Unhealthy():
+		return "unhealthy"
+	case t.Ejected():
+		return "ejected"
+	case t.HealthCheck == nil:
+		return ""
+	default:
+		return "healthy"
+	}
+}
+
+// routesCSV returns the routes as CSV with a header row. Tags are
+// separated by commas and options by spaces.
+func routesCSV(routes []apiRoute) ([]byte, error) {
+	var buf bytes.Buffer
+	cw := csv.NewWriter(&buf)
+	cw.Write([]string{"service", "host", "path", "src", "dst", "weight", "fixedWeight", "tags", "opts", "source", "config"})
+	for _, r := range routes {
+		cw.Write([]string{
+			r.Service,
+			r.Host,
+			r.Path,
+			r.Src,
+			r.Dst,
+			strconv.FormatFloat(r.Weight, 'f', -1, 64),
+			strconv.FormatFloat(r.FixedWeight, 'f', -1, 64),
+			strings.Join(r.Tags, ","),
+			r.Opts,
+			r.Source,
+			r.Config,
+		})
+	}
+	cw.Flush()
+	return buf.Bytes(), cw.Error()
+}
+
+// routesYAML returns the routes as a YAML sequence. Strings are
+// written as double quoted scalars which are valid YAML since
+// JSON strings are a subset of YAML.
+func routesYAML(routes []apiRoute) []byte {
+	var buf bytes.Buffer
+	if len(routes) == 0 {
+		buf.WriteString("[]\n")
+		return buf.Bytes()
+	}
+
+	quote := func(s string) string {
+		b, _ := json.Marshal(s)
+		return string(b)
+	}
+	num := func(f float64) string {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+
+	for _, r := range routes {
+		fmt.Fprintf(&buf, "- service: %s\n", quote(r.Service))
+		fmt.Fprintf(&buf, "  host: %s\n", quote(r.Host))
+		fmt.Fprintf(&buf, "  path: %s\n", quote(r.Path))
+		fmt.Fprintf(&buf, "  src: %s\n", quote(r.Src))
+		fmt.Fprintf(&buf, "  dst: %s\n", quote(r.Dst))
+		fmt.Fprintf(&buf, "  weight: %s\n", num(r.Weight))
+		fmt.Fprintf(&buf, "  fixedWeight: %s\n", num(r.FixedWeight))
+		if r.Flapping {
+			buf.WriteString("  flapping: true\n")
+		}
+		if r.Health != "" {
+			fmt.Fprintf(&buf, "  health: %s\n", quote(r.Health))
+		}
+		if r.Group != "" {
+			fmt.Fprintf(&buf, "  group: %s\n", quote(r.Group))
+		}
+		if r.Source != "" {
+			fmt.Fprintf(&buf, "  source: %s\n", quote(r.Source))
+		}
+		if len(r.Tags) > 0 {
+			buf.WriteString("  tags:\n")
+			for _, t := range r.Tags {
+				fmt.Fprintf(&buf, "    - %s\n", quote(t))
+			}
+		}
+		if len(r.Options) > 0 {
+			var keys []string
+			for k := range r.Options {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			buf.WriteString("  options:\n")
+			for _, k := range keys {
+				fmt.Fprintf(&buf, "    %s: %s\n", quote(k), quote(r.Options[k]))
 			}
 		}
+		fmt.Fprintf(&buf, "  config: %s\n", quote(r.Config))
+		fmt.Fprintf(&buf, "  rate1: %s\n", num(r.Rate1))
+		fmt.Fprintf(&buf, "  pct99: %s\n", num(r.Pct99))
 	}
-	writeJSON(w, r, routes)
+	return buf.Bytes()
 }
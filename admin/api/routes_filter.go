@@ -0,0 +1,186 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/fabiolb/fabio/route"
+)
+
+// routeFilterParams are the query parameters which filter the routes.
+// Values of the same parameter are alternatives and all parameters
+// must match.
+var routeFilterParams = []Param{
+	{Name: "service", In: "query", Type: "string", Description: "Service name. '*' matches any characters"},
+	{Name: "host", In: "query", Type: "string", Description: "Host of the route. '*' matches any characters"},
+	{Name: "tag", In: "query", Type: "string", Description: "Tag of the target. '*' matches any characters"},
+	{Name: "dst", In: "query", Type: "string", Description: "Address host:port of the target. '*' matches any characters"},
+	{Name: "opt", In: "query", Type: "string", Description: "Option key or key=value of the target. '*' matches any characters"},
+	{Name: "q", In: "query", Type: "string", Description: "Words which must all be contained in the service, source, destination, options or tags"},
+}
+
+// routeFilter selects the targets of the routing table.
+type routeFilter []func(r *route.Route, t *route.Target) bool
+
+// routeFields maps the filter parameters to the
+// functions which match a pattern against a target.
+var routeFields = []struct {
+	name  string
+	match func(r *route.Route, t *route.Target, re *regexp.Regexp) bool
+}{
+	{"service", func(r *route.Route, t *route.Target, re *regexp.Regexp) bool {
+		return re.MatchString(t.Service)
+	}},
+	{"host", func(r *route.Route, t *route.Target, re *regexp.Regexp) bool {
+		return re.MatchString(r.Host)
+	}},
+	{"tag", func(r *route.Route, t *route.Target, re *regexp.Regexp) bool {
+		for _, tag := range t.Tags {
+			if re.MatchString(tag) {
+				return true
+			}
+		}
+		return false
+	}},
+	{"dst", func(r *route.Route, t *route.Target, re *regexp.Regexp) bool {
+		return t.URL != nil && re.MatchString(t.URL.Host)
+	}},
+	{"opt", func(r *route.Route, t *route.Target, re *regexp.Regexp) bool {
+		for k, v := range t.Opts {
+			if re.MatchString(k) || re.MatchString(k+"="+v) {
+				return true
+			}
+		}
+		return false
+	}},
+}
+
+// parseRouteFilter returns the filter for the query parameters
+// or nil if the query has no filter parameters.
+func parseRouteFilter(q url.Values) (routeFilter, error) {
+	var f routeFilter
+	for _, fld := range routeFields {
+		vals := q[fld.name]
+		if len(vals) == 0 {
+			continue
+		}
+		var res []*regexp.Regexp
+		for _, v := range vals {
+			if v == "" {
+				return nil, fmt.Errorf("invalid %s. Must not be empty", fld.name)
+			}
+			res = append(res, wildcard(v))
+		}
+		match := fld.match
+		f = append(f, func(r *route.Route, t *route.Target) bool {
+			for _, re := range res {
+				if match(r, t, re) {
+					return true
+				}
+			}
+			return false
+		})
+	}
+
+	if words := strings.Fields(strings.ToLower(strings.Join(q["q"], " "))); len(words) > 0 {
+		f = append(f, func(r *route.Route, t *route.Target) bool {
+			s := strings.ToLower(searchText(r, t))
+			for _, w := range words {
+				if !strings.Contains(s, w) {
+					return false
+				}
+			}
+			return true
+		})
+	}
+	return f, nil
+}
+
+// match returns true if the target matches all filters.
+func (f routeFilter) match(r *route.Route, t *route.Target) bool {
+	for _, fn := range f {
+		if !fn(r, t) {
+			return false
+		}
+	}
+	return true
+}
+
+// searchText returns the text of a target which is searched
+// by the q parameter. It contains the columns of the UI.
+func searchText(r *route.Route, t *route.Target) string {
+	var b strings.Builder
+	b.WriteString(t.Service)
+	b.WriteString(" ")
+	b.WriteString(r.Host + r.Path)
+	if t.URL != nil {
+		b.WriteString(" ")
+		b.WriteString(t.URL.String())
+	}
+	for k, v := range t.Opts {
+		b.WriteString(" " + k + "=" + v)
+	}
+	for _, tag := range t.Tags {
+		b.WriteString(" " + tag)
+	}
+	return b.String()
+}
+
+// wildcard returns a case-insensitive regular expression which
+// matches s where '*' matches any characters.
+func wildcard(s string) *regexp.Regexp {
+	parts := strings.Split(s, "*")
+	for i, p := range parts {
+		parts[i] = regexp.QuoteMeta(p)
+	}
+	return regexp.MustCompile("(?i)^" + strings.Join(parts, ".*") + "$")
+}
+
+// groupFilter adds a filter for the groups of the targets to f. The
+// group parameter is only supported if the group key is configured.
+func (h *RoutesHandler) groupFilter(f routeFilter, vals []string) (routeFilter, error) {
+	if len(vals) == 0 {
+		return f, nil
+	}
+	if h.Group == "" {
+		return nil, errors.New("invalid group. ui.group is not set")
+	}
+	var res []*regexp.Regexp
+	for _, v := range vals {
+		if v == "" {
+			return nil, errors.New("invalid group. Must not be empty")
+		}
+		res = append(res, wildcard(v))
+	}
+	return append(f, func(r *route.Route, t *route.Target) bool {
+		g := routeGroup(t, h.Group)
+		for _, re := range res {
+			if re.MatchString(g) {
+				return true
+			}
+		}
+		return false
+	}), nil
+}
+
+// routeGroup returns the group of the target for the key. It is the
+// value of the option key or of the first tag 'key=value' or
+// 'key:value'. The group is empty if key is empty or the target has
+// neither option nor tag.
+func routeGroup(t *route.Target, key string) string {
+	if key == "" {
+		return ""
+	}
+	if v, ok := t.Opts[key]; ok {
+		return v
+	}
+	for _, tag := range t.Tags {
+		if len(tag) > len(key) && strings.HasPrefix(tag, key) && (tag[len(key)] == '=' || tag[len(key)] == ':') {
+			return tag[len(key)+1:]
+		}
+	}
+	return ""
+}
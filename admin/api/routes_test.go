@@ -0,0 +1,413 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/fabiolb/fabio/route"
+)
+
+func TestRoutesHandlerPagination(t *testing.T) {
+	tbl, err := route.NewTable(bytes.NewBufferString(`
+route add a /a http://1.2.3.4/
+route add b /b http://1.2.3.5/
+route add c /c http://1.2.3.6/
+route add d /d http://1.2.3.7/
+route add e /e http://1.2.3.8/
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	route.SetTable(tbl)
+	defer route.SetTable(make(route.Table))
+
+	tests := []struct {
+		desc     string
+		pageSize int
+		query    string
+		code     int
+		services []string
+		total    string
+		link     string
+	}{
+		{
+			desc:     "table smaller than page size",
+			pageSize: 10,
+			code:     200,
+			services: []string{"e", "d", "c", "b", "a"},
+		},
+		{
+			desc:     "table larger than page size",
+			pageSize: 2,
+			code:     200,
+			services: []string{"e", "d"},
+			total:    "5",
+			link:     `</api/routes?page=1&per_page=2>; rel="first", </api/routes?page=2&per_page=2>; rel="next", </api/routes?page=3&per_page=2>; rel="last"`,
+		},
+		{
+			desc:     "page and per_page",
+			pageSize: 10,
+			query:    "?page=2&per_page=2&pretty",
+			code:     200,
+			services: []string{"c", "b"},
+			total:    "5",
+			link:     `</api/routes?page=1&per_page=2&pretty=>; rel="first", </api/routes?page=1&per_page=2&pretty=>; rel="prev", </api/routes?page=3&per_page=2&pretty=>; rel="next", </api/routes?page=3&per_page=2&pretty=>; rel="last"`,
+		},
+		{
+			desc:     "last page",
+			pageSize: 2,
+			query:    "?page=3",
+			code:     200,
+			services: []string{"a"},
+			total:    "5",
+			link:     `</api/routes?page=1&per_page=2>; rel="first", </api/routes?page=2&per_page=2>; rel="prev", </api/routes?page=3&per_page=2>; rel="last"`,
+		},
+		{
+			desc:     "page after the last page",
+			pageSize: 2,
+			query:    "?page=4",
+			code:     200,
+			total:    "5",
+			link:     `</api/routes?page=1&per_page=2>; rel="first", </api/routes?page=3&per_page=2>; rel="prev", </api/routes?page=3&per_page=2>; rel="last"`,
+		},
+		{
+			desc:     "invalid page",
+			pageSize: 2,
+			query:    "?page=0",
+			code:     400,
+		},
+		{
+			desc:     "invalid per_page",
+			pageSize: 2,
+			query:    "?per_page=x",
+			code:     400,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			h := &RoutesHandler{PageSize: tt.pageSize}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest("GET", "/api/routes"+tt.query, nil))
+
+			if got, want := rec.Code, tt.code; got != want {
+				t.Fatalf("got status %d want %d", got, want)
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+
+			var routes []apiRoute
+			if err := json.Unmarshal(rec.Body.Bytes(), &routes); err != nil {
+				t.Fatal(err)
+			}
+			var services []string
+			for _, r := range routes {
+				services = append(services, r.Service)
+			}
+			if got, want := services, tt.services; !reflect.DeepEqual(got, want) {
+				t.Fatalf("got services %v want %v", got, want)
+			}
+			if got, want := rec.Header().Get("X-Total-Count"), tt.total; got != want {
+				t.Fatalf("got X-Total-Count %q want %q", got, want)
+			}
+			if got, want := rec.Header().Get("Link"), tt.link; got != want {
+				t.Fatalf("got Link %q want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestRoutesHandlerFilter(t *testing.T) {
+	tbl, err := route.NewTable(bytes.NewBufferString(`
+route add web a.com/ http://10.0.0.1:80/ tags "blue,prod"
+route add web b.com/ http://10.0.0.2:80/ tags "green,prod"
+route add api a.com/api http://10.0.1.1:8080/ opts "strip=/api"
+route add admin a.com/admin http://10.0.1.2:8080/ opts "auth=basic"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	route.SetTable(tbl)
+	defer route.SetTable(make(route.Table))
+
+	tests := []struct {
+		query string
+		code  int
+		dsts  []string
+		total string
+	}{
+		{query: "?service=web", code: 200, dsts: []string{"http://10.0.0.1:80/", "http://10.0.0.2:80/"}},
+		{query: "?service=WEB&host=b.com", code: 200, dsts: []string{"http://10.0.0.2:80/"}},
+		{query: "?service=api&service=admin", code: 200, dsts: []string{"http://10.0.1.1:8080/", "http://10.0.1.2:8080/"}},
+		{query: "?tag=blue", code: 200, dsts: []string{"http://10.0.0.1:80/"}},
+		{query: "?dst=10.0.1.*", code: 200, dsts: []string{"http://10.0.1.1:8080/", "http://10.0.1.2:8080/"}},
+		{query: "?opt=strip", code: 200, dsts: []string{"http://10.0.1.1:8080/"}},
+		{query: "?opt=auth=basic", code: 200, dsts: []string{"http://10.0.1.2:8080/"}},
+		{query: "?opt=auth=digest", code: 200},
+		{query: "?q=a.com+PROD", code: 200, dsts: []string{"http://10.0.0.1:80/"}},
+		{query: "?host=a.com&per_page=1&page=2", code: 200, dsts: []string{"http://10.0.1.2:8080/"}, total: "3"},
+		{query: "?service=", code: 400},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			(&RoutesHandler{}).ServeHTTP(rec, httptest.NewRequest("GET", "/api/routes"+tt.query, nil))
+			if got, want := rec.Code, tt.code; got != want {
+				t.Fatalf("got status %d want %d", got, want)
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+			var routes []apiRoute
+			if err := json.Unmarshal(rec.Body.Bytes(), &routes); err != nil {
+				t.Fatal(err)
+			}
+			var dsts []string
+			for _, r := range routes {
+				dsts = append(dsts, r.Dst)
+			}
+			if got, want := dsts, tt.dsts; !reflect.DeepEqual(got, want) {
+				t.Fatalf("got %v want %v", got, want)
+			}
+			if got, want := rec.Header().Get("X-Total-Count"), tt.total; got != want {
+				t.Fatalf("got X-Total-Count %q want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestRoutesHandlerGroup(t *testing.T) {
+	tbl, err := route.NewTable(bytes.NewBufferString(`
+route add web a.com/ http://10.0.0.1:80/ tags "blue,env=prod"
+route add web b.com/ http://10.0.0.2:80/ tags "env:staging"
+route add api a.com/api http://10.0.1.1:8080/ opts "env=dev"
+route add admin a.com/admin http://10.0.1.2:8080/
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	route.SetTable(tbl)
+	defer route.SetTable(make(route.Table))
+
+	tests := []struct {
+		desc   string
+		group  string
+		query  string
+		code   int
+		groups []string
+	}{
+		{"no group", "", "", 200, []string{"", "", "", ""}},
+		{"tags and options", "env", "", 200, []string{"dev", "", "prod", "staging"}},
+		{"filter", "env", "?group=prod&group=stag*", 200, []string{"prod", "staging"}},
+		{"filter without group", "", "?group=prod", 400, nil},
+		{"empty filter", "env", "?group=", 400, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			(&RoutesHandler{Group: tt.group}).ServeHTTP(rec, httptest.NewRequest("GET", "/api/routes"+tt.query, nil))
+			if got, want := rec.Code, tt.code; got != want {
+				t.Fatalf("got status %d want %d", got, want)
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+			var routes []apiRoute
+			if err := json.Unmarshal(rec.Body.Bytes(), &routes); err != nil {
+				t.Fatal(err)
+			}
+			var groups []string
+			for _, r := range routes {
+				groups = append(groups, r.Group)
+			}
+			if got, want := groups, tt.groups; !reflect.DeepEqual(got, want) {
+				t.Fatalf("got %q want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestNegotiateFormat(t *testing.T) {
+	tests := []struct {
+		accept string
+		format string
+	}{
+		{"", "json"},
+		{"*/*", "json"},
+		{"application/json", "json"},
+		{"application/yaml", "yaml"},
+		{"application/x-yaml", "yaml"},
+		{"text/csv", "csv"},
+		{"text/html, text/csv;q=0.9", "csv"},
+		{"application/yaml, application/json", "yaml"},
+		{"csv", "json"},
+		{"invalid;;, text/csv", "csv"},
+	}
+	for _, tt := range tests {
+		if got, want := negotiateFormat(tt.accept), tt.format; got != want {
+			t.Errorf("%q: got %s want %s", tt.accept, got, want)
+		}
+	}
+}
+
+func TestRoutesHandlerFormat(t *testing.T) {
+	tbl, err := route.NewTableSources(
+		route.ConfigSource{Name: "consul", Config: `route add a /a http://1.2.3.4/ tags "x,y" opts "strip=/a"`},
+		route.ConfigSource{Name: "manual", Config: `route add b /b http://1.2.3.5/`},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	route.SetTable(tbl)
+	defer route.SetTable(make(route.Table))
+
+	tests := []struct {
+		desc        string
+		query       string
+		accept      string
+		code        int
+		contentType string
+	}{
+		{"default", "", "", 200, "application/json; charset=utf-8"},
+		{"accept yaml", "", "application/yaml", 200, "application/yaml; charset=utf-8"},
+		{"accept csv", "", "text/csv", 200, "text/csv; charset=utf-8"},
+		{"format overrides accept", "?format=csv", "application/yaml", 200, "text/csv; charset=utf-8"},
+		{"format yaml", "?format=yaml", "", 200, "application/yaml; charset=utf-8"},
+		{"invalid format", "?format=xml", "", 400, "text/plain; charset=utf-8"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/routes"+tt.query, nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			rec := httptest.NewRecorder()
+			(&RoutesHandler{}).ServeHTTP(rec, req)
+			if got, want := rec.Code, tt.code; got != want {
+				t.Fatalf("got code %d want %d", got, want)
+			}
+			if got, want := rec.Header().Get("Content-Type"), tt.contentType; got != want {
+				t.Fatalf("got content type %q want %q", got, want)
+			}
+		})
+	}
+
+	// the json output contains the source of the routes
+	rec := httptest.NewRecorder()
+	(&RoutesHandler{}).ServeHTTP(rec, httptest.NewRequest("GET", "/api/routes", nil))
+	var routes []apiRoute
+	if err := json.Unmarshal(rec.Body.Bytes(), &routes); err != nil {
+		t.Fatal(err)
+	}
+	sources := map[string]string{}
+	for _, r := range routes {
+		sources[r.Service] = r.Source
+	}
+	if got, want := sources, map[string]string{"a": "consul", "b": "manual"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+}
+
+func TestRoutesCSV(t *testing.T) {
+	routes := []apiRoute{
+		{
+			Service:     "a",
+			Host:        "example.com",
+			Path:        "/a",
+			Src:         "example.com/a",
+			Dst:         "http://1.2.3.4/",
+			Weight:      0.5,
+			FixedWeight: 0.25,
+			Tags:        []string{"x", "y"},
+			Opts:        "strip=/a",
+			Source:      "consul",
+			Config:      `route add a example.com/a http://1.2.3.4/ opts "strip=/a"`,
+		},
+		{Service: "b", Src: "/b", Dst: "http://1.2.3.5/", Weight: 1},
+	}
+	b, err := routesCSV(routes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `service,host,path,src,dst,weight,fixedWeight,tags,opts,source,config
+a,example.com,/a,example.com/a,http://1.2.3.4/,0.5,0.25,"x,y",strip=/a,consul,"route add a example.com/a http://1.2.3.4/ opts ""strip=/a"""
+b,,,/b,http://1.2.3.5/,1,0,,,,
+`
+	if got := string(b); got != want {
+		t.Fatalf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestRoutesYAML(t *testing.T) {
+	tests := []struct {
+		desc   string
+		routes []apiRoute
+		want   string
+	}{
+		{"empty", nil, "[]\n"},
+		{
+			desc: "routes",
+			routes: []apiRoute{
+				{
+					Service:     "a",
+					Host:        "example.com",
+					Path:        "/a",
+					Src:         "example.com/a",
+					Dst:         "http://1.2.3.4/",
+					Weight:      0.5,
+					FixedWeight: 0.25,
+					Health:      "healthy",
+					Source:      "consul",
+					Tags:        []string{"x"},
+					Options:     map[string]string{"strip": "/a", "host": "dst"},
+					Config:      `route add a example.com/a http://1.2.3.4/`,
+				},
+				{Service: "b", Src: "/b", Dst: "http://1.2.3.5/", Weight: 1, Flapping: true},
+			},
+			want: `- service: "a"
+  host: "example.com"
+  path: "/a"
+  src: "example.com/a"
+  dst: "http://1.2.3.4/"
+  weight: 0.5
+  fixedWeight: 0.25
+  health: "healthy"
+  source: "consul"
+  tags:
+    - "x"
+  options:
+    "host": "dst"
+    "strip": "/a"
+  config: "route add a example.com/a http://1.2.3.4/"
+  rate1: 0
+  pct99: 0
+- service: "b"
+  host: ""
+  path: ""
+  src: "/b"
+  dst: "http://1.2.3.5/"
+  weight: 1
+  fixedWeight: 0
+  flapping: true
+  config: ""
+  rate1: 0
+  pct99: 0
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			if got, want := string(routesYAML(tt.routes)), tt.want; got != want {
+				t.Fatalf("got\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
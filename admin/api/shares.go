@@ -0,0 +1,82 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/fabiolb/fabio/route"
+)
+
+// defaultShareRequests is the number of requests the effective
+// shares are computed from if the n parameter is not set.
+const defaultShareRequests = 10000
+
+// maxShareRequests limits the work of a single request.
+const maxShareRequests = 1000000
+
+// SharesHandler returns the share of the traffic
+// each target of the routing table receives.
+type SharesHandler struct{}
+
+type apiShare struct {
+	Service     string  `json:"service"`
+	Src         string  `json:"src"`
+	Dst         string  `json:"dst"`
+	Backup      bool    `json:"backup,omitempty"`
+	FixedWeight float64 `json:"fixedWeight"`
+	Weight      float64 `json:"weight"`
+	Effective   float64 `json:"effective"`
+}
+
+func (h *SharesHandler) Operations() []Operation {
+	return []Operation{{
+		Method:  "GET",
+		Summary: "Returns the share of the traffic of each route target",
+		Params: []Param{
+			{Name: "n", In: "query", Type: "integer", Description: fmt.Sprintf("Number of requests the effective shares are computed from. Defaults to %d", defaultShareRequests)},
+			prettyParam,
+		},
+		Response: []apiShare{},
+		Errors:   []int{http.StatusBadRequest},
+	}}
+}
+
+func (h *SharesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n := defaultShareRequests
+	if s := r.URL.Query().Get("n"); s != "" {
+		var err error
+		n, err = strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxShareRequests {
+			http.Error(w, fmt.Sprintf("invalid n %q. Must be between 1 and %d", s, maxShareRequests), http.StatusBadRequest)
+			return
+		}
+	}
+
+	t := route.GetTable()
+
+	var hosts []string
+	for host := range t {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+
+	shares := []apiShare{}
+	for _, host := range hosts {
+		for _, tr := range t[host] {
+			for _, s := range tr.Shares(n) {
+				shares = append(shares, apiShare{
+					Service:     s.Target.Service,
+					Src:         tr.Host + tr.Path,
+					Dst:         s.Target.URL.String(),
+					Backup:      s.Target.Backup,
+					FixedWeight: s.Target.FixedWeight,
+					Weight:      s.Weight,
+					Effective:   s.Effective,
+				})
+			}
+		}
+	}
+	writeJSON(w, r, shares)
+}
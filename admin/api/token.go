@@ -0,0 +1,44 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// tokenRequest contains the fields which the requests
+// for all signed tokens have in common.
+type tokenRequest struct {
+	// TTL is the lifetime of the token, e.g. 10m.
+	// It defaults to the maximum lifetime.
+	TTL string `json:"ttl"`
+}
+
+func (t tokenRequest) ttl() string { return t.TTL }
+
+// readTokenRequest decodes the body of a POST request for a signed
+// token into req and returns the expiry of the token. It writes the
+// error response and returns false if the request is invalid.
+func readTokenRequest(w http.ResponseWriter, r *http.Request, req interface{ ttl() string }, maxTTL time.Duration) (time.Time, bool) {
+	if r.Method != "POST" {
+		http.Error(w, "not allowed", http.StatusMethodNotAllowed)
+		return time.Time{}, false
+	}
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return time.Time{}, false
+	}
+
+	ttl := maxTTL
+	if s := req.ttl(); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 || d > maxTTL {
+			http.Error(w, fmt.Sprintf("invalid ttl. Must be between 0s and %s", maxTTL), http.StatusBadRequest)
+			return time.Time{}, false
+		}
+		ttl = d
+	}
+	return time.Now().Add(ttl).Truncate(time.Second).UTC(), true
+}
@@ -0,0 +1,47 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadTokenRequest(t *testing.T) {
+	tests := []struct {
+		desc, method, body string
+		code               int
+		ttl                time.Duration
+	}{
+		{"default ttl", "POST", `{"id":"a"}`, http.StatusOK, time.Hour},
+		{"ttl", "POST", `{"id":"a","ttl":"10m"}`, http.StatusOK, 10 * time.Minute},
+		{"ttl too long", "POST", `{"ttl":"2h"}`, http.StatusBadRequest, 0},
+		{"invalid ttl", "POST", `{"ttl":"x"}`, http.StatusBadRequest, 0},
+		{"invalid json", "POST", `{`, http.StatusBadRequest, 0},
+		{"get", "GET", ``, http.StatusMethodNotAllowed, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			var req traceTokenRequest
+			w := httptest.NewRecorder()
+			start := time.Now().Truncate(time.Second)
+			expires, ok := readTokenRequest(w, httptest.NewRequest(tt.method, "/", strings.NewReader(tt.body)), &req, time.Hour)
+			if got, want := ok, tt.code == http.StatusOK; got != want {
+				t.Fatalf("got %v want %v", got, want)
+			}
+			if got, want := w.Code, tt.code; got != want {
+				t.Fatalf("got status %d want %d", got, want)
+			}
+			if !ok {
+				return
+			}
+			if got, want := expires.Sub(start), tt.ttl; got < want || got > want+time.Second {
+				t.Fatalf("got ttl %s want %s", got, want)
+			}
+			if got, want := req.ID, "a"; got != want {
+				t.Fatalf("got id %q want %q", got, want)
+			}
+		})
+	}
+}
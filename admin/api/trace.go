@@ -0,0 +1,71 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/fabiolb/fabio/route"
+)
+
+// TraceTokenHandler issues the tokens for the trace header which
+// enable the tracing of the route lookup of a request.
+type TraceTokenHandler struct {
+	// MaxTTL is the maximum lifetime of a token.
+	MaxTTL time.Duration
+}
+
+type traceTokenRequest struct {
+	// ID is logged with the trace messages. It defaults to
+	// a random id.
+	ID string `json:"id"`
+
+	tokenRequest
+}
+
+type traceToken struct {
+	Header  string    `json:"header"`
+	Token   string    `json:"token"`
+	ID      string    `json:"id"`
+	Expires time.Time `json:"expires"`
+}
+
+func (h *TraceTokenHandler) Operations() []Operation {
+	return []Operation{{
+		Method:   "POST",
+		Summary:  "Issues a token for the trace header which enables the tracing of the route lookup",
+		Params:   []Param{prettyParam},
+		Request:  traceTokenRequest{},
+		Response: traceToken{},
+		Errors:   []int{http.StatusBadRequest, http.StatusNotFound},
+	}}
+}
+
+func (h *TraceTokenHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "POST" && (route.TraceHeader == "" || len(route.TraceSecret) == 0) {
+		http.Error(w, "trace tokens are disabled. Set proxy.trace.header", http.StatusNotFound)
+		return
+	}
+
+	var req traceTokenRequest
+	expires, ok := readTokenRequest(w, r, &req, h.MaxTTL)
+	if !ok {
+		return
+	}
+	if req.ID == "" {
+		b := make([]byte, 4)
+		if _, err := rand.Read(b); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		req.ID = hex.EncodeToString(b)
+	}
+
+	token, err := route.TraceToken(req.ID, expires)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, r, traceToken{Header: route.TraceHeader, Token: token, ID: req.ID, Expires: expires})
+}
@@ -9,6 +9,10 @@ type VersionHandler struct {
 	Version string
 }
 
+func (h *VersionHandler) Operations() []Operation {
+	return []Operation{{Method: "GET", Summary: "Returns the version of fabio"}}
+}
+
 func (h *VersionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, h.Version)
 }
@@ -2,13 +2,14 @@ package admin
 
 import (
 	"crypto/tls"
-	"fmt"
 	"net/http"
+	"net/url"
 	"strings"
 
 	"github.com/fabiolb/fabio/admin/api"
 	"github.com/fabiolb/fabio/admin/ui"
 	_ "github.com/fabiolb/fabio/admin/ui/statik"
+	"github.com/fabiolb/fabio/cache"
 	"github.com/fabiolb/fabio/config"
 	"github.com/fabiolb/fabio/proxy"
 	"github.com/rakyll/statik/fs"
@@ -22,16 +23,64 @@ type Server struct {
 	Version  string
 	Commands string
 	Cfg      *config.Config
+
+	// Metrics serves the metrics in the Prometheus exposition
+	// format on /metrics if it is not nil.
+	Metrics http.Handler
+
+	// Cache is the response cache which is purged via the API.
+	Cache cache.Store
 }
 
 // ListenAndServe starts the admin server.
 func (s *Server) ListenAndServe(l config.Listen, tlscfg *tls.Config) error {
-	return proxy.ListenAndServeHTTP(l, s.handler(), tlscfg)
+	return proxy.ListenAndServeAdmin(l, s.handler(), tlscfg)
+}
+
+// Mount returns a handler which serves the API, the health check and
+// the metrics of the admin server under prefix and all other requests
+// with next. This allows serving the admin endpoints on a proxy
+// listener. The UI is not available under the prefix since its pages
+// use absolute paths. Only the read-only API is served unless the
+// read-write API is enabled with ui.mount.rw. The configuration is
+// never served on the proxy listeners.
+func (s *Server) Mount(prefix string, next http.Handler) http.Handler {
+	m := *s
+	if m.Cfg == nil || !m.Cfg.UI.MountRW {
+		m.Access = "ro"
+	}
+	h := m.handler()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := r.URL.Path
+		if p != prefix && !strings.HasPrefix(p, prefix+"/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		p = strings.TrimPrefix(p, prefix)
+		if !strings.HasPrefix(p, "/api/") && p != "/health" && (p != "/metrics" || s.Metrics == nil) || p == "/api/config" {
+			http.NotFound(w, r)
+			return
+		}
+		r2 := new(http.Request)
+		*r2 = *r
+		r2.URL = new(url.URL)
+		*r2.URL = *r.URL
+		r2.URL.Path = p
+		r2.URL.RawPath = ""
+		h.ServeHTTP(w, r2)
+	})
 }
 
 func (s *Server) handler() http.Handler {
 	mux := http.NewServeMux()
 
+	// spec documents all API handlers which are registered with handle
+	spec := &api.OpenAPIHandler{Version: s.Version}
+	handle := func(path string, h http.Handler) {
+		mux.Handle(path, h)
+		spec.Add(path, h)
+	}
+
 	switch s.Access {
 	case "ro":
 		mux.HandleFunc("/api/paths", forbidden)
@@ -39,13 +88,43 @@ func (s *Server) handler() http.Handler {
 		mux.HandleFunc("/api/manual/", forbidden)
 		mux.HandleFunc("/manual", forbidden)
 		mux.HandleFunc("/manual/", forbidden)
+		mux.HandleFunc("/api/v1/routes", forbidden)
+		mux.HandleFunc("/api/v1/routes/", forbidden)
+		mux.HandleFunc("/api/v1/drain", forbidden)
+		mux.HandleFunc("/api/v1/debug/token", forbidden)
+		mux.HandleFunc("/api/v1/trace/token", forbidden)
+		mux.HandleFunc("/api/v1/killswitch", forbidden)
+		mux.HandleFunc("/api/v1/killswitch/", forbidden)
+		mux.HandleFunc("/api/v1/failover", forbidden)
+		mux.HandleFunc("/api/v1/failover/", forbidden)
+		mux.HandleFunc("/api/v1/cache", forbidden)
 	case "rw":
 		// for historical reasons the configured config path starts with a '/'
 		// but Consul treats all KV paths without a leading slash.
 		pathsPrefix := strings.TrimPrefix(s.Cfg.Registry.Consul.KVPath, "/")
-		mux.Handle("/api/paths", &api.ManualPathsHandler{Prefix: pathsPrefix})
-		mux.Handle("/api/manual", &api.ManualHandler{BasePath: "/api/manual"})
+		switch s.Cfg.Registry.Backend {
+		case "etcd":
+			pathsPrefix = s.Cfg.Registry.Etcd.KVPath
+		case "zookeeper":
+			pathsPrefix = s.Cfg.Registry.Zookeeper.KVPath
+		}
+		handle("/api/paths", &api.ManualPathsHandler{Prefix: pathsPrefix})
+		handle("/api/manual", &api.ManualHandler{BasePath: "/api/manual"})
 		mux.Handle("/api/manual/", &api.ManualHandler{BasePath: "/api/manual"})
+		spec.Add("/api/manual/{path}", &api.ManualHandler{BasePath: "/api/manual/"})
+		handle("/api/v1/routes", &api.RouteCmdHandler{BasePath: "/api/v1/routes", Prefix: pathsPrefix})
+		mux.Handle("/api/v1/routes/", &api.RouteCmdHandler{BasePath: "/api/v1/routes", Prefix: pathsPrefix})
+		spec.Add("/api/v1/routes/{id}", &api.RouteCmdHandler{BasePath: "/api/v1/routes/"})
+		handle("/api/v1/drain", &api.DrainHandler{Grace: s.Cfg.Proxy.DrainGrace, Wait: s.Cfg.Proxy.ShutdownWait})
+		handle("/api/v1/debug/token", &api.DebugTokenHandler{MaxTTL: s.Cfg.Proxy.DebugMaxTTL})
+		handle("/api/v1/trace/token", &api.TraceTokenHandler{MaxTTL: s.Cfg.Proxy.TraceMaxTTL})
+		handle("/api/v1/killswitch", &api.KillSwitchHandler{BasePath: "/api/v1/killswitch", Defaults: s.Cfg.Proxy.KillSwitch})
+		mux.Handle("/api/v1/killswitch/", &api.KillSwitchHandler{BasePath: "/api/v1/killswitch", Defaults: s.Cfg.Proxy.KillSwitch})
+		spec.Add("/api/v1/killswitch/{service}", &api.KillSwitchHandler{BasePath: "/api/v1/killswitch/"})
+		handle("/api/v1/failover", &api.FailoverHandler{BasePath: "/api/v1/failover"})
+		mux.Handle("/api/v1/failover/", &api.FailoverHandler{BasePath: "/api/v1/failover"})
+		spec.Add("/api/v1/failover/{service}", &api.FailoverHandler{BasePath: "/api/v1/failover/"})
+		handle("/api/v1/cache", &api.CacheHandler{Store: s.Cache})
 		mux.Handle("/manual", &ui.ManualHandler{
 			BasePath: "/manual",
 			Color:    s.Color,
@@ -62,11 +141,31 @@ func (s *Server) handler() http.Handler {
 		})
 	}
 
-	mux.Handle("/api/config", &api.ConfigHandler{Config: s.Cfg})
-	mux.Handle("/api/routes", &api.RoutesHandler{})
-	mux.Handle("/api/version", &api.VersionHandler{Version: s.Version})
-	mux.Handle("/routes", &ui.RoutesHandler{Color: s.Color, Title: s.Title, Version: s.Version})
-	mux.HandleFunc("/health", handleHealth)
+	handle("/api/aliases", &api.AliasesHandler{})
+	handle("/api/config", &api.ConfigHandler{Config: s.Cfg.Redacted()})
+	handle("/api/ha", &api.HAHandler{})
+	handle("/api/noroute", &api.NoRouteHandler{})
+	handle("/api/registry", &api.RegistryHandler{})
+	handle("/api/routes", &api.RoutesHandler{PageSize: s.Cfg.UI.PageSize, Group: s.Cfg.UI.Group})
+	handle("/api/routes/events", &api.RouteEventsHandler{})
+	handle("/api/routes/shares", &api.SharesHandler{})
+	handle("/api/routes/checksum", &api.RouteChecksumHandler{})
+	if len(s.Cfg.UI.Peers) > 0 {
+		handle("/api/routes/fleet", &api.FleetHandler{Peers: s.Cfg.UI.Peers})
+	}
+	handle("/api/routes/eval", &api.RouteEvalHandler{Matcher: s.Cfg.Proxy.Matcher, GlobDisabled: s.Cfg.GlobMatchingDisabled})
+	handle("/api/version", &api.VersionHandler{Version: s.Version})
+	handle("/api/openapi", spec)
+	if s.Metrics != nil {
+		mux.Handle("/metrics", s.Metrics)
+	}
+	mux.Handle("/routes", &ui.RoutesHandler{Color: s.Color, Title: s.Title, Version: s.Version, PageSize: s.Cfg.UI.PageSize, Pages: s.Cfg.UI.PagesDir != "", Group: s.Cfg.UI.Group, GroupColors: s.Cfg.UI.GroupColors, Fleet: len(s.Cfg.UI.Peers) > 0})
+	if s.Cfg.UI.PagesDir != "" {
+		pages := &ui.CustomHandler{BasePath: "/ui/custom", Dir: s.Cfg.UI.PagesDir, Color: s.Color, Title: s.Title, Version: s.Version}
+		mux.Handle("/ui/custom", pages)
+		mux.Handle("/ui/custom/", pages)
+	}
+	handle("/health", &api.HealthHandler{})
 
 	statikFS, err := fs.New()
 	if err != nil {
@@ -82,10 +181,6 @@ func (s *Server) handler() http.Handler {
 	return mux
 }
 
-func handleHealth(w http.ResponseWriter, r *http.Request) {
-	fmt.Fprintln(w, "OK")
-}
-
 func forbidden(w http.ResponseWriter, r *http.Request) {
 	http.Error(w, "Forbidden", http.StatusForbidden)
 }
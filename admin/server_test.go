@@ -1,8 +1,11 @@
 package admin
 
 import (
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"sort"
 	"testing"
 
 	"github.com/fabiolb/fabio/config"
@@ -49,9 +52,30 @@ func TestAdminServerAccess(t *testing.T) {
 	roTests := []test{
 		{"/api/manual", 403},
 		{"/api/paths", 403},
+		{"/api/aliases", 200},
 		{"/api/config", 200},
+		{"/api/ha", 200},
+		{"/api/noroute", 200},
+		{"/api/noroute?n=0", 400},
+		{"/api/registry", 200},
 		{"/api/routes", 200},
+		{"/api/routes?format=yaml", 200},
+		{"/api/routes?format=csv", 200},
+		{"/api/routes?format=xml", 400},
+		{"/api/routes/events", 200},
+		{"/api/routes/eval", 405},
+		{"/api/routes/shares", 200},
+		{"/api/routes/shares?n=0", 400},
+		{"/api/routes/checksum", 200},
+		{"/api/v1/routes", 403},
+		{"/api/v1/drain", 403},
+		{"/api/v1/debug/token", 403},
+		{"/api/v1/trace/token", 403},
+		{"/api/v1/killswitch", 403},
+		{"/api/v1/failover", 403},
+		{"/api/v1/cache", 403},
 		{"/api/version", 200},
+		{"/api/openapi", 200},
 		{"/manual", 403},
 		{"/routes", 200},
 		{"/health", 200},
@@ -63,9 +87,30 @@ func TestAdminServerAccess(t *testing.T) {
 	rwTests := []test{
 		{"/api/manual", 200},
 		{"/api/paths", 200},
+		{"/api/aliases", 200},
 		{"/api/config", 200},
+		{"/api/ha", 200},
+		{"/api/noroute", 200},
+		{"/api/noroute?n=0", 400},
+		{"/api/registry", 200},
 		{"/api/routes", 200},
+		{"/api/routes?format=yaml", 200},
+		{"/api/routes?format=csv", 200},
+		{"/api/routes?format=xml", 400},
+		{"/api/routes/events", 200},
+		{"/api/routes/eval", 405},
+		{"/api/routes/shares", 200},
+		{"/api/routes/shares?n=0", 400},
+		{"/api/routes/checksum", 200},
+		{"/api/v1/routes", 200},
+		{"/api/v1/drain", 200},
+		{"/api/v1/debug/token", 405},
+		{"/api/v1/trace/token", 405},
+		{"/api/v1/killswitch", 200},
+		{"/api/v1/failover", 200},
+		{"/api/v1/cache", 200},
 		{"/api/version", 200},
+		{"/api/openapi", 200},
 		{"/manual", 200},
 		{"/routes", 200},
 		{"/health", 200},
@@ -77,3 +122,171 @@ func TestAdminServerAccess(t *testing.T) {
 	testAccess("ro", roTests)
 	testAccess("rw", rwTests)
 }
+
+func TestAdminServerOpenAPI(t *testing.T) {
+	tests := []struct {
+		access string
+		paths  []string
+	}{
+		{
+			access: "ro",
+			paths: []string{
+				"/api/aliases", "/api/config", "/api/ha", "/api/noroute", "/api/openapi", "/api/registry", "/api/routes",
+				"/api/routes/checksum", "/api/routes/eval", "/api/routes/events", "/api/routes/shares", "/api/version", "/health",
+			},
+		},
+		{
+			access: "rw",
+			paths: []string{
+				"/api/aliases", "/api/config", "/api/ha", "/api/manual", "/api/manual/{path}", "/api/noroute", "/api/openapi", "/api/paths",
+				"/api/registry", "/api/routes", "/api/routes/checksum", "/api/routes/eval", "/api/routes/events", "/api/routes/shares",
+				"/api/v1/cache", "/api/v1/debug/token", "/api/v1/drain", "/api/v1/failover", "/api/v1/failover/{service}", "/api/v1/killswitch", "/api/v1/killswitch/{service}", "/api/v1/routes", "/api/v1/routes/{id}", "/api/v1/trace/token", "/api/version", "/health",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.access, func(t *testing.T) {
+			srv := &Server{Access: tt.access, Version: "1.2.3", Cfg: &config.Config{}}
+			ts := httptest.NewServer(srv.handler())
+			defer ts.Close()
+
+			resp, err := http.Get(ts.URL + "/api/openapi")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			var doc struct {
+				OpenAPI string
+				Info    struct{ Version string }
+				Paths   map[string]map[string]interface{}
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := doc.OpenAPI, "3.0.3"; got != want {
+				t.Fatalf("got openapi %q want %q", got, want)
+			}
+			if got, want := doc.Info.Version, "1.2.3"; got != want {
+				t.Fatalf("got version %q want %q", got, want)
+			}
+			var paths []string
+			for p := range doc.Paths {
+				paths = append(paths, p)
+			}
+			sort.Strings(paths)
+			if got, want := paths, tt.paths; !reflect.DeepEqual(got, want) {
+				t.Fatalf("got paths %v want %v", got, want)
+			}
+			if _, ok := doc.Paths["/api/routes/eval"]["post"]; !ok {
+				t.Fatal("missing post operation for /api/routes/eval")
+			}
+		})
+	}
+}
+
+func TestAdminServerMetrics(t *testing.T) {
+	metrics := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("fabio_requests_total 1\n"))
+	})
+
+	tests := []struct {
+		desc    string
+		metrics http.Handler
+		code    int
+	}{
+		{"disabled", nil, 303},
+		{"enabled", metrics, 200},
+	}
+
+	noRedirectClient := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			srv := &Server{Access: "ro", Cfg: &config.Config{}, Metrics: tt.metrics}
+			ts := httptest.NewServer(srv.handler())
+			defer ts.Close()
+
+			resp, err := noRedirectClient.Get(ts.URL + "/metrics")
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if got, want := resp.StatusCode, tt.code; got != want {
+				t.Fatalf("got code %d want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestAdminServerMount(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	srv := &Server{Access: "ro", Cfg: &config.Config{UI: config.UI{PageSize: 10}}}
+	ts := httptest.NewServer(srv.Mount("/_fabio", next))
+	defer ts.Close()
+
+	tests := []struct {
+		uri  string
+		code int
+	}{
+		{"/", 418},
+		{"/_fabiox/health", 418},
+		{"/api/version", 418},
+		{"/_fabio/health", 200},
+		{"/_fabio/api/version", 200},
+		{"/_fabio/api/routes", 200},
+		{"/_fabio/api/config", 404},
+		{"/_fabio/api/v1/drain", 403},
+		{"/_fabio/routes", 404},
+		{"/_fabio/metrics", 404},
+		{"/_fabio", 404},
+	}
+	for _, tt := range tests {
+		t.Run(tt.uri, func(t *testing.T) {
+			resp, err := http.Get(ts.URL + tt.uri)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if got, want := resp.StatusCode, tt.code; got != want {
+				t.Fatalf("got code %d want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestAdminServerMountAccess(t *testing.T) {
+	tests := []struct {
+		desc    string
+		access  string
+		mountRW bool
+		code    int
+	}{
+		{"ro", "ro", false, 403},
+		{"rw without ui.mount.rw", "rw", false, 403},
+		{"rw with ui.mount.rw", "rw", true, 200},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			cfg := &config.Config{UI: config.UI{PageSize: 10, MountRW: tt.mountRW}}
+			srv := &Server{Access: tt.access, Cfg: cfg}
+			ts := httptest.NewServer(srv.Mount("/_fabio", http.NotFoundHandler()))
+			defer ts.Close()
+
+			resp, err := http.Get(ts.URL + "/_fabio/api/v1/drain")
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if got, want := resp.StatusCode, tt.code; got != want {
+				t.Fatalf("got code %d want %d", got, want)
+			}
+		})
+	}
+}
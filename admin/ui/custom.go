@@ -0,0 +1,191 @@
+package ui
+
+import (
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// CustomHandler serves the custom pages of the operator from a
+// directory. Markdown (.md) and HTML (.html) files are rendered into
+// the UI and are addressed by their name without the extension. All
+// other files, e.g. images, are served as they are. The files are
+// read on every request so that they can be changed at runtime.
+type CustomHandler struct {
+	BasePath string
+	Dir      string
+	Color    string
+	Title    string
+	Version  string
+}
+
+// customPage describes a custom page in the index.
+type customPage struct {
+	Name  string
+	Title string
+}
+
+func (h *CustomHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, h.BasePath), "/")
+	if name == "" {
+		h.serveIndex(w)
+		return
+	}
+
+	// custom pages are flat and hidden files are not served
+	if strings.Contains(name, "/") || strings.HasPrefix(name, ".") {
+		http.NotFound(w, r)
+		return
+	}
+
+	if ext := filepath.Ext(name); ext != "" {
+		if ext == ".md" || ext == ".html" {
+			http.Redirect(w, r, h.BasePath+"/"+strings.TrimSuffix(name, ext), http.StatusMovedPermanently)
+			return
+		}
+		h.serveFile(w, r, name)
+		return
+	}
+
+	for _, ext := range []string{".md", ".html"} {
+		data, err := ioutil.ReadFile(filepath.Join(h.Dir, name+ext))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		body := string(data)
+		if ext == ".md" {
+			body = markdown(body)
+		}
+		h.render(w, pageTitle(name), template.HTML(body), nil)
+		return
+	}
+	http.NotFound(w, r)
+}
+
+// serveFile serves a regular file of the directory.
+func (h *CustomHandler) serveFile(w http.ResponseWriter, r *http.Request, name string) {
+	path := filepath.Join(h.Dir, name)
+	fi, err := os.Stat(path)
+	if err != nil || !fi.Mode().IsRegular() {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeFile(w, r, path)
+}
+
+// serveIndex lists the custom pages sorted by name.
+func (h *CustomHandler) serveIndex(w http.ResponseWriter) {
+	files, err := ioutil.ReadDir(h.Dir)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	seen := map[string]bool{}
+	pages := []customPage{}
+	for _, f := range files {
+		ext := filepath.Ext(f.Name())
+		if !f.Mode().IsRegular() || strings.HasPrefix(f.Name(), ".") || (ext != ".md" && ext != ".html") {
+			continue
+		}
+		name := strings.TrimSuffix(f.Name(), ext)
+		if !seen[name] {
+			seen[name] = true
+			pages = append(pages, customPage{Name: name, Title: pageTitle(name)})
+		}
+	}
+	sort.Slice(pages, func(i, j int) bool { return pages[i].Name < pages[j].Name })
+	h.render(w, "Pages", "", pages)
+}
+
+// render renders a page or the index if pages is not nil.
+func (h *CustomHandler) render(w http.ResponseWriter, title string, content template.HTML, pages []customPage) {
+	data := struct {
+		*CustomHandler
+		PageTitle string
+		Content   template.HTML
+		Index     bool
+		Pages     []customPage
+	}{h, title, content, pages != nil, pages}
+	tmplCustom.ExecuteTemplate(w, "custom", data)
+}
+
+// pageTitle returns the title of a page from its name,
+// e.g. 'on-call_contacts' becomes 'On call contacts'.
+func pageTitle(name string) string {
+	t := strings.NewReplacer("-", " ", "_", " ").Replace(name)
+	if t == "" {
+		return t
+	}
+	return strings.ToUpper(t[:1]) + t[1:]
+}
+
+var tmplCustom = template.Must(template.New("custom").Parse(`
+<!doctype html>
+<html lang="en">
+<head>
+	<meta charset="utf-8">
+	<title>fabio{{if .Title}} - {{.Title}}{{end}} - {{.PageTitle}}</title>
+	<script type="text/javascript" src="/assets/code.jquery.com/jquery-3.3.1.min.js"></script>
+    <link href="/assets/fonts/material-icons.css" rel="stylesheet">
+    <link rel="stylesheet" href="/assets/cdnjs.cloudflare.com/ajax/libs/materialize/0.100.2/css/materialize.min.css">
+    <script src="/assets/cdnjs.cloudflare.com/ajax/libs/materialize/0.100.2/js/materialize.min.js"></script>
+	<meta name="viewport" content="width=device-width, initial-scale=1.0"/>
+
+	<style type="text/css">
+		.footer { padding-top: 10px; }
+		.logo { height: 32px; margin: 0 auto; display: block; }
+		.page pre { padding: 10px; background: #f5f5f5; overflow-x: auto; }
+		.page ul li { list-style-type: disc; margin-left: 20px; }
+		.page blockquote { border-left-color: #9e9e9e; }
+	</style>
+</head>
+<body>
+
+<nav class="top-nav {{.Color}}">
+
+	<div class="container">
+		<div class="nav-wrapper">
+			<a href="/" class="brand-logo">fabio{{if .Title}} - {{.Title}}{{end}}</a>
+			<ul id="nav-mobile" class="right hide-on-med-and-down">
+				<li><a href="/routes">Routes</a></li>
+				<li><a href="{{.BasePath}}">Pages</a></li>
+				<li><a href="https://github.com/fabiolb/fabio/blob/master/CHANGELOG.md">{{.Version}}</a></li>
+				<li><a href="https://github.com/fabiolb/fabio">Github</a></li>
+			</ul>
+		</div>
+	</div>
+
+</nav>
+
+<div class="container">
+
+	<div class="section page">
+{{if .Index}}
+		<h5>Pages</h5>
+		<div class="collection">
+		{{range .Pages}}
+			<a class="collection-item" href="{{$.BasePath}}/{{.Name}}">{{.Title}}</a>
+		{{end}}
+		</div>
+{{else}}
+		{{.Content}}
+{{end}}
+	</div>
+
+	<div class="section footer">
+		<img class="logo" src="/assets/logo.svg">
+	</div>
+
+</div>
+
+</body>
+</html>
+`))
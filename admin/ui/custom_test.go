@@ -0,0 +1,90 @@
+package ui
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMarkdown(t *testing.T) {
+	tests := []struct {
+		desc, in, out string
+	}{
+		{"heading", "## On call ##", "<h2>On call</h2>\n"},
+		{"paragraph", "line one\nline two\n\nnext", "<p>line one line two</p>\n<p>next</p>\n"},
+		{"emphasis", "**bold** and *em* and _em_", "<p><strong>bold</strong> and <em>em</em> and <em>em</em></p>\n"},
+		{"code span", "run `rm -rf <dir>` **now**", "<p>run <code>rm -rf &lt;dir&gt;</code> <strong>now</strong></p>\n"},
+		{"html is escaped", "<script>alert(1)</script>", "<p>&lt;script&gt;alert(1)&lt;/script&gt;</p>\n"},
+		{"link", "see [runbook](https://wiki/x?a=1&b=2)", `<p>see <a href="https://wiki/x?a=1&amp;b=2">runbook</a></p>` + "\n"},
+		{"unsafe link", "[x](javascript:alert)", "<p>x</p>\n"},
+		{"list", "- a\n- b\n  c\n\n1. x\n2. y", "<ul>\n<li>a</li>\n<li>b c</li>\n</ul>\n<ol>\n<li>x</li>\n<li>y</li>\n</ol>\n"},
+		{"fenced code", "```\n<b>\n```", "<pre><code>&lt;b&gt;</code></pre>\n"},
+		{"indented code", "    a\n    b", "<pre><code>a\nb</code></pre>\n"},
+		{"quote", "> call *ops*", "<blockquote>\n<p>call <em>ops</em></p>\n</blockquote>\n"},
+		{"rule", "---", "<hr>\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			if got, want := markdown(tt.in), tt.out; got != want {
+				t.Fatalf("got %q want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestCustomHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fabio-pages")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"runbook.md":    "# Runbook",
+		"contacts.html": "<table><tr><td>ops</td></tr></table>",
+		"diagram.txt":   "diagram",
+		".hidden.md":    "secret",
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	h := &CustomHandler{BasePath: "/ui/custom", Dir: dir}
+	tests := []struct {
+		path string
+		code int
+		body string
+	}{
+		{"/ui/custom", 200, `<a class="collection-item" href="/ui/custom/contacts">Contacts</a>`},
+		{"/ui/custom/runbook", 200, "<h1>Runbook</h1>"},
+		{"/ui/custom/contacts", 200, "<table><tr><td>ops</td></tr></table>"},
+		{"/ui/custom/diagram.txt", 200, "diagram"},
+		{"/ui/custom/runbook.md", 301, ""},
+		{"/ui/custom/.hidden", 404, ""},
+		{"/ui/custom/../custom_test.go", 404, ""},
+		{"/ui/custom/missing", 404, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest("GET", "http://localhost"+tt.path, nil))
+			if got, want := rec.Code, tt.code; got != want {
+				t.Fatalf("got status %d want %d", got, want)
+			}
+			if !strings.Contains(rec.Body.String(), tt.body) {
+				t.Fatalf("got body %q want %q", rec.Body.String(), tt.body)
+			}
+		})
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/ui/custom", nil))
+	if strings.Contains(rec.Body.String(), "hidden") {
+		t.Fatal("index contains hidden page")
+	}
+}
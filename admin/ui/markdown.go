@@ -0,0 +1,150 @@
+package ui
+
+import (
+	"bytes"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// markdown renders the subset of markdown which is common in runbooks
+// and contact pages to HTML: headings, paragraphs, lists, block quotes,
+// fenced and indented code blocks, horizontal rules, code spans,
+// emphasis and links. Raw HTML is escaped.
+func markdown(src string) string {
+	var b bytes.Buffer
+	lines := strings.Split(strings.Replace(src, "\r\n", "\n", -1), "\n")
+
+	var para []string
+	flush := func() {
+		if len(para) > 0 {
+			b.WriteString("<p>" + inline(strings.Join(para, "\n")) + "</p>\n")
+			para = nil
+		}
+	}
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+
+		switch {
+		case trimmed == "":
+			flush()
+
+		case strings.HasPrefix(trimmed, "```"):
+			flush()
+			var code []string
+			for i++; i < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), "```"); i++ {
+				code = append(code, lines[i])
+			}
+			b.WriteString("<pre><code>" + html.EscapeString(strings.Join(code, "\n")) + "</code></pre>\n")
+
+		case len(para) == 0 && (strings.HasPrefix(line, "    ") || strings.HasPrefix(line, "\t")):
+			var code []string
+			for ; i < len(lines) && (strings.HasPrefix(lines[i], "    ") || strings.HasPrefix(lines[i], "\t") || strings.TrimSpace(lines[i]) == ""); i++ {
+				code = append(code, strings.TrimPrefix(strings.TrimPrefix(lines[i], "\t"), "    "))
+			}
+			i--
+			b.WriteString("<pre><code>" + html.EscapeString(strings.TrimRight(strings.Join(code, "\n"), "\n")) + "</code></pre>\n")
+
+		case reHeading.MatchString(trimmed):
+			flush()
+			m := reHeading.FindStringSubmatch(trimmed)
+			n := strconv.Itoa(len(m[1]))
+			b.WriteString("<h" + n + ">" + inline(strings.TrimRight(m[2], " #")) + "</h" + n + ">\n")
+
+		case reRule.MatchString(trimmed):
+			flush()
+			b.WriteString("<hr>\n")
+
+		case strings.HasPrefix(trimmed, ">"):
+			flush()
+			var quote []string
+			for ; i < len(lines) && strings.HasPrefix(strings.TrimSpace(lines[i]), ">"); i++ {
+				quote = append(quote, strings.TrimPrefix(strings.TrimPrefix(strings.TrimSpace(lines[i]), ">"), " "))
+			}
+			i--
+			b.WriteString("<blockquote>\n" + markdown(strings.Join(quote, "\n")) + "</blockquote>\n")
+
+		case reBullet.MatchString(line) || reNumber.MatchString(line):
+			flush()
+			re, tag := reBullet, "ul"
+			if !reBullet.MatchString(line) {
+				re, tag = reNumber, "ol"
+			}
+			b.WriteString("<" + tag + ">\n")
+			for ; i < len(lines) && re.MatchString(lines[i]); i++ {
+				item := re.ReplaceAllString(lines[i], "")
+				// continuation lines of the item are indented
+				for i+1 < len(lines) && strings.TrimSpace(lines[i+1]) != "" && (strings.HasPrefix(lines[i+1], "  ") || strings.HasPrefix(lines[i+1], "\t")) && !re.MatchString(lines[i+1]) {
+					i++
+					item += "\n" + strings.TrimSpace(lines[i])
+				}
+				b.WriteString("<li>" + inline(item) + "</li>\n")
+			}
+			i--
+			b.WriteString("</" + tag + ">\n")
+
+		default:
+			para = append(para, trimmed)
+		}
+	}
+	flush()
+	return b.String()
+}
+
+var (
+	reHeading = regexp.MustCompile(`^(#{1,6})\s+(.*)$`)
+	reRule    = regexp.MustCompile(`^([-*_])(\s*[-*_]){2,}$`)
+	reBullet  = regexp.MustCompile(`^\s{0,3}[-*+]\s+`)
+	reNumber  = regexp.MustCompile(`^\s{0,3}\d+[.)]\s+`)
+
+	reCode   = regexp.MustCompile("`([^`]+)`")
+	reLink   = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)\)`)
+	reStrong = regexp.MustCompile(`\*\*([^*]+)\*\*|__([^_]+)__`)
+	reEm     = regexp.MustCompile(`\*([^*]+)\*|\b_([^_]+)_\b`)
+)
+
+// inline renders the code spans, links and emphasis of a block
+// of text. The text of code spans is not processed further.
+func inline(s string) string {
+	var codes []string
+	s = reCode.ReplaceAllStringFunc(s, func(m string) string {
+		codes = append(codes, "<code>"+html.EscapeString(m[1:len(m)-1])+"</code>")
+		return "\x00" + strconv.Itoa(len(codes)-1) + "\x00"
+	})
+
+	s = html.EscapeString(s)
+	s = reLink.ReplaceAllStringFunc(s, func(m string) string {
+		sm := reLink.FindStringSubmatch(m)
+		href := html.UnescapeString(sm[2])
+		if !safeURL(href) {
+			return sm[1]
+		}
+		return `<a href="` + html.EscapeString(href) + `">` + sm[1] + `</a>`
+	})
+	s = reStrong.ReplaceAllString(s, "<strong>$1$2</strong>")
+	s = reEm.ReplaceAllString(s, "<em>$1$2</em>")
+	s = strings.Replace(s, "\n", " ", -1)
+
+	for i, c := range codes {
+		s = strings.Replace(s, "\x00"+strconv.Itoa(i)+"\x00", c, 1)
+	}
+	return s
+}
+
+// safeURL returns true if u is a relative URL or uses
+// the http, https or mailto scheme.
+func safeURL(u string) bool {
+	i := strings.IndexAny(u, ":/?#")
+	if i < 0 || u[i] != ':' {
+		return true
+	}
+	switch strings.ToLower(u[:i]) {
+	case "http", "https", "mailto":
+		return true
+	default:
+		return false
+	}
+}
@@ -8,6 +8,22 @@ import (
 // RoutesHandler provides the UI for managing the routing table.
 type RoutesHandler struct {
 	Color, Title, Version string
+
+	// PageSize is the number of routes per page.
+	PageSize int
+
+	// Pages enables the link to the custom pages.
+	Pages bool
+
+	// Group is the option or tag key by which the routes are
+	// grouped and GroupColors the colors of the groups. Groups
+	// without a color get one from a fixed palette.
+	Group       string
+	GroupColors map[string]string
+
+	// Fleet enables the comparison of the routing
+	// table with the other fabio instances.
+	Fleet bool
 }
 
 func (h *RoutesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -28,6 +44,7 @@ var tmplRoutes = template.Must(template.New("routes").Parse(`
 
 	<style type="text/css">
 		td.tags { display: none; }
+		#groups .chip { cursor: pointer; }
 		.footer { padding-top: 10px; }
 		.logo { height: 32px; margin: 0 auto; display: block; }
 
@@ -47,6 +64,7 @@ var tmplRoutes = template.Must(template.New("routes").Parse(`
 			<a href="/" class="brand-logo">fabio{{if .Title}} - {{.Title}}{{end}}</a>
 			<ul id="nav-mobile" class="right hide-on-med-and-down">
                 <li><a class="dropdown-button" href="#!" data-activates="overrides">Overrides<i class="material-icons right">arrow_drop_down</i></a></li>
+				{{if .Pages}}<li><a href="/ui/custom">Pages</a></li>{{end}}
 				<li><a href="https://github.com/fabiolb/fabio/blob/master/CHANGELOG.md">{{.Version}}</a></li>
 				<li><a href="https://github.com/fabiolb/fabio">Github</a></li>
 			</ul>
@@ -59,8 +77,22 @@ var tmplRoutes = template.Must(template.New("routes").Parse(`
 
 	<div class="section">
 		<h5>Routing Table</h5>
-		<p><input type="text" id="filter" placeholder="type to filter routes"></p>
+		<p><input type="text" id="filter" placeholder="type to search routes, e.g. service:web tag:prod"></p>
+		<p id="groups" style="display: none"></p>
 		<table class="routes highlight"></table>
+		<ul class="pagination routes" style="display: none"></ul>
+	</div>
+
+	{{if .Fleet}}
+	<div class="section fleet">
+		<h5>Fleet</h5>
+		<table class="fleet highlight"></table>
+	</div>
+	{{end}}
+
+	<div class="section aliases" style="display: none">
+		<h5>Aliases</h5>
+		<table class="aliases highlight"></table>
 	</div>
 
 	<div class="section footer">
@@ -72,12 +104,50 @@ var tmplRoutes = template.Must(template.New("routes").Parse(`
 <script>
 $(function(){
 	var params={};window.location.search.replace(/[?&]+([^=&]+)=([^&]*)/gi,function(str,key,value){params[key] = value;});
+	var page = parseInt(params.page) || 1;
+
+	// routes are grouped by the value of an option or tag, e.g. env=prod
+	var groupKey = {{.Group}};
+	var groupColors = {{.GroupColors}} || {};
+	var palette = ['blue', 'teal', 'orange', 'purple', 'pink', 'indigo', 'cyan', 'brown', 'lime', 'deep-orange'];
+
+	function groupColor(g) {
+		if (groupColors[g]) return groupColors[g];
+		var h = 0;
+		for (var i=0; i < g.length; i++) h = (h * 31 + g.charCodeAt(i)) >>> 0;
+		return palette[h % palette.length];
+	}
+
+	function renderGroups(routes) {
+		var $groups = $('#groups');
+		var seen = {}, groups = [];
+		for (var i=0; i < routes.length; i++) {
+			var g = routes[i].group;
+			if (g && !seen[g]) {
+				seen[g] = true;
+				groups.push(g);
+			}
+		}
+		if (groups.length == 0) {
+			$groups.hide();
+			return;
+		}
+		groups.sort();
+		$groups.empty();
+		$.each(groups, function(idx, g) {
+			$('<span />').addClass('chip white-text ' + groupColor(g)).text(groupKey + '=' + g).click(function() {
+				setFilter('group:' + g);
+			}).appendTo($groups);
+		});
+		$groups.show();
+	}
 
-	function renderRoutes(routes) {
+	function renderRoutes(routes, offset) {
 		var $table = $('table.routes');
 
 		var thead = '<thead><tr>';
 		thead += '<th>#</th>';
+		if (groupKey) thead += '<th>Group</th>';
 		thead += '<th>Service</th>';
 		thead += '<th>Source</th>';
 		thead += '<th>Dest</th>';
@@ -92,12 +162,53 @@ $(function(){
 
 			var $tr = $('<tr />')
 
-			$tr.append($('<td />').text(i+1));
+			$tr.append($('<td />').text(offset+i+1));
+			if (groupKey) {
+				var $td = $('<td />');
+				if (r.group) {
+					var color = groupColor(r.group);
+					$tr.addClass(color.split(' ')[0] + ' lighten-5');
+					$td.append($('<span />').addClass('chip white-text ' + color).text(r.group));
+				}
+				$tr.append($td);
+			}
 			$tr.append($('<td />').text(r.service));
 			$tr.append($('<td />').text(r.src));
 			$tr.append($('<td />').append($('<a />').attr('href', r.dst).text(r.dst)));
 			$tr.append($('<td />').text(r.opts));
-			$tr.append($('<td />').text((r.weight * 100).toFixed(2) + '%'));
+			$tr.append($('<td />').text((r.weight * 100).toFixed(2) + '%' + (r.flapping ? ' (flapping)' : '') + (r.health ? ' (' + r.health + ')' : '')));
+
+			$tr.appendTo($tbody);
+		}
+
+		$table.empty().
+			append($(thead)).
+			append($tbody);
+	}
+
+	function renderAliases(aliases) {
+		if (!aliases || aliases.length == 0) return;
+
+		var $table = $('table.aliases');
+
+		var thead = '<thead><tr>';
+		thead += '<th>Name</th>';
+		thead += '<th>Service</th>';
+		thead += '<th>Hosts</th>';
+		thead += '<th>DNS</th>';
+		thead += '</tr></thead>';
+
+		var $tbody = $('<tbody />');
+
+		for (var i=0; i < aliases.length; i++) {
+			var a = aliases[i];
+
+			var $tr = $('<tr />')
+
+			$tr.append($('<td />').text(a.name));
+			$tr.append($('<td />').text(a.services.join(', ')));
+			$tr.append($('<td />').text(a.hosts.join(', ')));
+			$tr.append($('<td />').text(a.validated ? 'ok' : (a.error || 'pending')));
 
 			$tr.appendTo($tbody);
 		}
@@ -105,34 +216,131 @@ $(function(){
 		$table.empty().
 			append($(thead)).
 			append($tbody);
+		$('div.aliases').show();
 	}
 
+	// the filter is evaluated by the API. Words like 'service:web' filter
+	// by service, host, tag, dst, opt and group and all other words are
+	// searched.
 	var $filter = $('#filter');
-	function doFilter(v) {
-		$("tr").show();
-		if (!v) return;
-		var words = v.split(' ');
-		for (var i=0; i < words.length; i++) {
-			var w = words[i].trim();
+	var filter = params.filter ? decodeURIComponent(params.filter.replace(/\+/g, ' ')) : '';
+
+	function filterQuery(v) {
+		var q = [], words = [];
+		var parts = v.split(' ');
+		for (var i=0; i < parts.length; i++) {
+			var w = parts[i].trim();
 			if (w == "") continue;
-			$("tbody tr:not(:contains('"+w+"'))").hide();
+			var m = w.match(/^(service|host|tag|dst|opt|group):(.+)$/);
+			if (m) {
+				q.push(m[1] + '=' + encodeURIComponent(m[2]));
+			} else {
+				words.push(w);
+			}
+		}
+		if (words.length > 0) q.push('q=' + encodeURIComponent(words.join(' ')));
+		return q.join('&');
+	}
+
+	function pageURL(p) {
+		return '?page=' + p + (filter ? '&filter=' + encodeURIComponent(filter) : '');
+	}
+
+	// large routing tables are paginated by the API
+	function renderPages(page, total) {
+		var $pages = $('ul.pagination.routes');
+		var last = Math.ceil(total / {{.PageSize}});
+		if (last <= 1) {
+			$pages.hide();
+			return;
+		}
+
+		function item(p, text, active) {
+			var $li = $('<li />').addClass(active ? 'active' : 'waves-effect');
+			if (p < 1 || p > last) {
+				$li.removeClass('waves-effect').addClass('disabled');
+			}
+			return $li.append($('<a />').attr('href', pageURL(p)).text(text));
+		}
+
+		$pages.empty();
+		$pages.append(item(page-1, '<'));
+		for (var p = Math.max(1, page-5); p <= Math.min(last, page+5); p++) {
+			$pages.append(item(p, p, p == page));
 		}
+		$pages.append(item(page+1, '>'));
+		$pages.show();
+	}
+
+	function loadRoutes() {
+		var q = filterQuery(filter);
+		$.get("/api/routes?page=" + page + "&per_page={{.PageSize}}" + (q ? '&' + q : ''), function(data, status, xhr) {
+			renderRoutes(data || [], (page-1) * {{.PageSize}});
+			if (groupKey) renderGroups(data || []);
+			renderPages(page, parseInt(xhr.getResponseHeader('X-Total-Count')) || 0);
+		});
+	}
+
+	var timer;
+	function setFilter(v) {
+		if (v == filter) return;
+		filter = v;
+		page = 1;
+		$filter.val(v);
+		window.history.pushState(null, null, pageURL(page));
+		clearTimeout(timer);
+		timer = setTimeout(loadRoutes, 300);
 	}
 
+	$filter.val(filter);
 	$filter.focus();
 	$filter.keyup(function() {
-		var v = $filter.val();
-		window.history.pushState(null, null, "?filter=" +v);
-		doFilter(v);
+		setFilter($filter.val());
 	});
 
-	$.get("/api/routes", function(data) {
-		renderRoutes(data);
-		if (!params.filter) return;
-		var v = decodeURIComponent(params.filter);
-		$filter.val(v);
-		doFilter(v);
-	});
+	loadRoutes();
+
+	$.get("/api/aliases", renderAliases);
+
+	// instances which do not serve the routing table of the
+	// majority of the fleet are highlighted as stragglers
+	function renderFleet(fleet) {
+		var $table = $('table.fleet');
+
+		var thead = '<thead><tr>';
+		thead += '<th>Instance</th>';
+		thead += '<th>Checksum</th>';
+		thead += '<th>Version</th>';
+		thead += '<th>Routes</th>';
+		thead += '<th>Status</th>';
+		thead += '</tr></thead>';
+
+		var $tbody = $('<tbody />');
+
+		for (var i=0; i < fleet.length; i++) {
+			var f = fleet[i];
+
+			var $tr = $('<tr />')
+			if (f.straggler) $tr.addClass('red lighten-4');
+
+			$tr.append($('<td />').text(f.instance));
+			$tr.append($('<td />').text(f.error ? '' : f.checksum.substr(0, 12)));
+			$tr.append($('<td />').text(f.error ? '' : f.version));
+			$tr.append($('<td />').text(f.error ? '' : f.routes));
+			$tr.append($('<td />').text(f.error || (f.straggler ? 'straggler' : 'ok')));
+
+			$tr.appendTo($tbody);
+		}
+
+		$table.empty().
+			append($(thead)).
+			append($tbody);
+	}
+
+	if ({{.Fleet}}) {
+		$.get("/api/routes/fleet", renderFleet);
+		setInterval(function() { $.get("/api/routes/fleet", renderFleet); }, 10000);
+	}
 
 	$.get('/api/paths', function(data) {
 		var d = $("#overrides");
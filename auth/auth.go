@@ -7,6 +7,10 @@ import (
 	"github.com/fabiolb/fabio/config"
 )
 
+// AuthScheme authorizes requests. Authorized returns false if the
+// request is not authorized. Schemes may write their own response for
+// unauthorized requests. Otherwise, the proxy responds with
+// '401 Unauthorized'.
 type AuthScheme interface {
 	Authorized(request *http.Request, response http.ResponseWriter) bool
 }
@@ -21,6 +25,18 @@ func LoadAuthSchemes(cfg map[string]config.AuthScheme) (map[string]AuthScheme, e
 				return nil, err
 			}
 			auths[a.Name] = b
+		case "jwt":
+			j, err := newJWTAuth(a.JWT)
+			if err != nil {
+				return nil, err
+			}
+			auths[a.Name] = j
+		case "extauthz":
+			e, err := newExtAuthz(a.ExtAuthz)
+			if err != nil {
+				return nil, err
+			}
+			auths[a.Name] = e
 		default:
 			return nil, fmt.Errorf("unknown auth type '%s'", a.Type)
 		}
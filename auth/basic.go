@@ -18,6 +18,10 @@ type basic struct {
 }
 
 func newBasicAuth(cfg config.BasicAuth) (AuthScheme, error) {
+	if cfg.LDAP.URL != "" {
+		return newLDAPAuth(cfg)
+	}
+
 	bad := func(err error) {
 		log.Println("[WARN] Error processing a line in an htpasswd file:", err)
 	}
@@ -0,0 +1,407 @@
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fabiolb/fabio/config"
+	"github.com/fabiolb/fabio/internal/rawpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// extAuthzGRPCMethod is the method of the Envoy external authorization service.
+const extAuthzGRPCMethod = "/envoy.service.auth.v3.Authorization/Check"
+
+// maxAuthzBody is the maximum size of the body of a denied response
+// from the authorization service which is sent to the client.
+const maxAuthzBody = 64 << 10
+
+// hopHeaders are the headers which are not forwarded
+// to and from the authorization service.
+var hopHeaders = map[string]bool{
+	"Connection":          true,
+	"Content-Length":      true,
+	"Keep-Alive":          true,
+	"Proxy-Authenticate":  true,
+	"Proxy-Authorization": true,
+	"Te":                  true,
+	"Trailer":             true,
+	"Transfer-Encoding":   true,
+	"Upgrade":             true,
+}
+
+// extAuthz is an implementation of AuthScheme which asks an external
+// authorization service whether a request is allowed.
+type extAuthz struct {
+	cfg     config.ExtAuthzAuth
+	headers map[string]bool
+	check   func(ctx context.Context, r *http.Request) (*authzResult, error)
+}
+
+// authzResult is the decision of the authorization service.
+type authzResult struct {
+	allowed bool
+
+	// status and body are the response to a denied request.
+	status int
+	body   []byte
+
+	// upstream are the headers which are set on the request to the
+	// upstream service and remove the ones which are removed from it.
+	upstream []headerOp
+	remove   []string
+
+	// downstream are the headers which are set on the response.
+	downstream []headerOp
+}
+
+// headerOp sets or appends a header value.
+type headerOp struct {
+	key, value string
+	append     bool
+}
+
+func newExtAuthz(cfg config.ExtAuthzAuth) (AuthScheme, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &extAuthz{cfg: cfg}
+	if len(cfg.Headers) > 0 {
+		a.headers = map[string]bool{}
+		for _, h := range cfg.Headers {
+			a.headers[http.CanonicalHeaderKey(h)] = true
+		}
+	}
+
+	switch u.Scheme {
+	case "http", "https":
+		client := &http.Client{
+			// redirects are responses of the authorization service
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		}
+		a.check = func(ctx context.Context, r *http.Request) (*authzResult, error) {
+			return a.checkHTTP(ctx, client, u, r)
+		}
+	case "grpc", "grpcs":
+		opt := grpc.WithInsecure()
+		if u.Scheme == "grpcs" {
+			opt = grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{}))
+		}
+		conn, err := grpc.Dial(u.Host, opt)
+		if err != nil {
+			return nil, err
+		}
+		a.check = func(ctx context.Context, r *http.Request) (*authzResult, error) {
+			return a.checkGRPC(ctx, conn, r)
+		}
+	default:
+		return nil, fmt.Errorf("invalid authorization service URL %q", cfg.URL)
+	}
+	return a, nil
+}
+
+func (a *extAuthz) Authorized(request *http.Request, response http.ResponseWriter) bool {
+	ctx, cancel := context.WithTimeout(request.Context(), a.cfg.Timeout)
+	defer cancel()
+
+	res, err := a.check(ctx, request)
+	if err != nil {
+		if a.cfg.FailOpen {
+			log.Printf("[WARN] auth: Cannot check request with %s. Allowing it. %s", a.cfg.URL, err)
+			return true
+		}
+		log.Printf("[ERROR] auth: Cannot check request with %s. %s", a.cfg.URL, err)
+		http.Error(response, "authorization failed", http.StatusForbidden)
+		return false
+	}
+
+	if !res.allowed {
+		applyHeaders(response.Header(), res.downstream)
+		status := res.status
+		if status < 200 || status > 599 {
+			status = http.StatusForbidden
+		}
+		response.WriteHeader(status)
+		response.Write(res.body)
+		return false
+	}
+
+	for _, h := range res.remove {
+		request.Header.Del(h)
+	}
+	applyHeaders(request.Header, res.upstream)
+	applyHeaders(response.Header(), res.downstream)
+	return true
+}
+
+// sendHeader returns true if the request header is sent
+// to the authorization service.
+func (a *extAuthz) sendHeader(name string) bool {
+	return !hopHeaders[name] && (a.headers == nil || a.headers[name])
+}
+
+// checkHTTP sends a request with the method, the path and the headers
+// of r to the authorization service. The path of r is appended to the
+// path of the service URL. Requests with a 2xx response are allowed.
+// All other responses are sent to the client.
+func (a *extAuthz) checkHTTP(ctx context.Context, client *http.Client, u *url.URL, r *http.Request) (*authzResult, error) {
+	target := *u
+	prefix := strings.TrimSuffix(u.EscapedPath(), "/")
+	target.Path = strings.TrimSuffix(u.Path, "/") + r.URL.Path
+	target.RawPath = prefix + r.URL.EscapedPath()
+	target.RawQuery = r.URL.RawQuery
+
+	req, err := http.NewRequest(r.Method, target.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	for k, v := range r.Header {
+		if a.sendHeader(k) {
+			req.Header[k] = v
+		}
+	}
+	req.Header.Set("X-Forwarded-Host", r.Host)
+	req.Header.Set("X-Forwarded-Proto", requestScheme(r))
+	if ip, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		req.Header.Set("X-Forwarded-For", ip)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	res := &authzResult{status: resp.StatusCode}
+	if resp.StatusCode/100 == 2 {
+		res.allowed = true
+		res.upstream = copyHeaders(resp.Header, a.cfg.Upstream)
+		res.downstream = copyHeaders(resp.Header, a.cfg.Downstream)
+		io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxAuthzBody))
+		return res, nil
+	}
+
+	if res.body, err = ioutil.ReadAll(io.LimitReader(resp.Body, maxAuthzBody)); err != nil {
+		return nil, err
+	}
+	var names []string
+	for k := range resp.Header {
+		if !hopHeaders[k] {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+	res.downstream = copyHeaders(resp.Header, names)
+	return res, nil
+}
+
+// checkGRPC calls the Check method of the Envoy external authorization
+// service with the attributes of r.
+func (a *extAuthz) checkGRPC(ctx context.Context, conn *grpc.ClientConn, r *http.Request) (*authzResult, error) {
+	req := a.encodeCheckRequest(r, time.Now())
+	var resp []byte
+	if err := conn.Invoke(ctx, extAuthzGRPCMethod, &req, &resp, grpc.ForceCodec(rawpb.Codec{})); err != nil {
+		return nil, err
+	}
+	return decodeCheckResponse(resp)
+}
+
+// copyHeaders returns the operations which set the headers
+// with the given names to their values in h.
+func copyHeaders(h http.Header, names []string) []headerOp {
+	var ops []headerOp
+	for _, name := range names {
+		for i, v := range h[http.CanonicalHeaderKey(name)] {
+			ops = append(ops, headerOp{key: name, value: v, append: i > 0})
+		}
+	}
+	return ops
+}
+
+func applyHeaders(h http.Header, ops []headerOp) {
+	for _, op := range ops {
+		if op.append {
+			h.Add(op.key, op.value)
+		} else {
+			h.Set(op.key, op.value)
+		}
+	}
+}
+
+func requestScheme(r *http.Request) string {
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// The functions below encode and decode the messages of the Envoy
+// external authorization service (envoy/service/auth/v3) in the
+// protobuf wire format.
+
+// encodeCheckRequest encodes a CheckRequest with the
+// source address and the HTTP attributes of r.
+func (a *extAuthz) encodeCheckRequest(r *http.Request, now time.Time) []byte {
+	var names []string
+	for k := range r.Header {
+		if a.sendHeader(k) {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+
+	var h []byte
+	if id := r.Header.Get("X-Request-Id"); id != "" {
+		h = rawpb.AppendString(h, 1, id)
+	}
+	h = rawpb.AppendString(h, 2, r.Method)
+	for _, k := range names {
+		var e []byte
+		e = rawpb.AppendString(e, 1, strings.ToLower(k))
+		e = rawpb.AppendString(e, 2, strings.Join(r.Header[k], ","))
+		h = rawpb.AppendBytes(h, 3, e)
+	}
+	h = rawpb.AppendString(h, 4, r.URL.RequestURI())
+	h = rawpb.AppendString(h, 5, r.Host)
+	h = rawpb.AppendString(h, 6, requestScheme(r))
+	if r.ContentLength > 0 {
+		h = protowire.AppendTag(h, 9, protowire.VarintType)
+		h = protowire.AppendVarint(h, uint64(r.ContentLength))
+	}
+	h = rawpb.AppendString(h, 10, r.Proto)
+
+	var ts []byte
+	ts = protowire.AppendTag(ts, 1, protowire.VarintType)
+	ts = protowire.AppendVarint(ts, uint64(now.Unix()))
+	ts = protowire.AppendTag(ts, 2, protowire.VarintType)
+	ts = protowire.AppendVarint(ts, uint64(now.Nanosecond()))
+
+	var req []byte
+	req = rawpb.AppendBytes(req, 1, ts)
+	req = rawpb.AppendBytes(req, 2, h)
+
+	var attrs []byte
+	if host, port, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		var sa []byte
+		sa = rawpb.AppendString(sa, 2, host)
+		if n, err := strconv.ParseUint(port, 10, 32); err == nil {
+			sa = protowire.AppendTag(sa, 3, protowire.VarintType)
+			sa = protowire.AppendVarint(sa, n)
+		}
+		var addr, peer []byte
+		addr = rawpb.AppendBytes(addr, 1, sa)
+		peer = rawpb.AppendBytes(peer, 1, addr)
+		attrs = rawpb.AppendBytes(attrs, 1, peer)
+	}
+	attrs = rawpb.AppendBytes(attrs, 4, req)
+
+	var b []byte
+	return rawpb.AppendBytes(b, 1, attrs)
+}
+
+// decodeCheckResponse decodes a CheckResponse. Requests are allowed
+// if the status code is OK. Denied requests without a status are
+// rejected with '403 Forbidden'.
+func decodeCheckResponse(b []byte) (*authzResult, error) {
+	res := &authzResult{status: http.StatusForbidden}
+	var code uint64
+	err := rawpb.Walk(b, func(num protowire.Number, v uint64, data []byte) error {
+		switch num {
+		case 1: // status
+			return rawpb.Walk(data, func(num protowire.Number, v uint64, _ []byte) error {
+				if num == 1 {
+					code = v
+				}
+				return nil
+			})
+		case 2: // denied_response
+			return rawpb.Walk(data, func(num protowire.Number, v uint64, data []byte) error {
+				switch num {
+				case 1:
+					return rawpb.Walk(data, func(num protowire.Number, v uint64, _ []byte) error {
+						if num == 1 && v > 0 {
+							res.status = int(v)
+						}
+						return nil
+					})
+				case 2:
+					op, err := decodeHeaderValueOption(data)
+					res.downstream = append(res.downstream, op)
+					return err
+				case 3:
+					res.body = append([]byte(nil), data...)
+				}
+				return nil
+			})
+		case 3: // ok_response
+			return rawpb.Walk(data, func(num protowire.Number, v uint64, data []byte) error {
+				switch num {
+				case 2:
+					op, err := decodeHeaderValueOption(data)
+					res.upstream = append(res.upstream, op)
+					return err
+				case 5:
+					res.remove = append(res.remove, string(data))
+				case 6:
+					op, err := decodeHeaderValueOption(data)
+					res.downstream = append(res.downstream, op)
+					return err
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	res.allowed = code == 0
+	return res, nil
+}
+
+// decodeHeaderValueOption decodes a HeaderValueOption. Values
+// replace existing headers unless append is true.
+func decodeHeaderValueOption(b []byte) (op headerOp, err error) {
+	err = rawpb.Walk(b, func(num protowire.Number, v uint64, data []byte) error {
+		switch num {
+		case 1:
+			return rawpb.Walk(data, func(num protowire.Number, v uint64, data []byte) error {
+				switch num {
+				case 1:
+					op.key = string(data)
+				case 2:
+					op.value = string(data)
+				}
+				return nil
+			})
+		case 2:
+			return rawpb.Walk(data, func(num protowire.Number, v uint64, _ []byte) error {
+				if num == 1 {
+					op.append = v != 0
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	if err == nil && op.key == "" {
+		err = fmt.Errorf("auth: header without name")
+	}
+	return op, err
+}
@@ -0,0 +1,274 @@
+package auth
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fabiolb/fabio/config"
+	"github.com/fabiolb/fabio/internal/rawpb"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestExtAuthzHTTP(t *testing.T) {
+	var got *http.Request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		switch r.Header.Get("Authorization") {
+		case "Bearer ok":
+			w.Header().Set("X-User", "alice")
+			w.Header().Set("X-Internal", "secret")
+			w.Header().Add("X-Auth", "a")
+			w.Header().Add("X-Auth", "b")
+		case "Bearer login":
+			http.Redirect(w, r, "https://login/", http.StatusFound)
+		default:
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("denied"))
+		}
+	}))
+	defer srv.Close()
+
+	a, err := newExtAuthz(config.ExtAuthzAuth{
+		URL:        srv.URL + "/authz/",
+		Timeout:    time.Second,
+		Headers:    []string{"authorization"},
+		Upstream:   []string{"X-User"},
+		Downstream: []string{"X-Auth"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newRequest := func(auth string) *http.Request {
+		r := httptest.NewRequest("POST", "http://example.com/api/a%2Fb?x=1", nil)
+		r.Header.Set("Authorization", auth)
+		r.Header.Set("Cookie", "session=1")
+		r.Header.Set("X-User", "mallory")
+		return r
+	}
+
+	t.Run("allow", func(t *testing.T) {
+		r, w := newRequest("Bearer ok"), httptest.NewRecorder()
+		if !a.Authorized(r, w) {
+			t.Fatal("request denied")
+		}
+		if got, want := got.Method, "POST"; got != want {
+			t.Fatalf("got method %q want %q", got, want)
+		}
+		if got, want := got.URL.RequestURI(), "/authz/api/a%2Fb?x=1"; got != want {
+			t.Fatalf("got uri %q want %q", got, want)
+		}
+		if got.Header.Get("Cookie") != "" {
+			t.Fatal("sent header which is not configured")
+		}
+		if got, want := got.Header.Get("X-Forwarded-Host"), "example.com"; got != want {
+			t.Fatalf("got host %q want %q", got, want)
+		}
+		if got, want := r.Header.Get("X-User"), "alice"; got != want {
+			t.Fatalf("got upstream header %q want %q", got, want)
+		}
+		if r.Header.Get("X-Internal") != "" {
+			t.Fatal("copied header which is not configured")
+		}
+		if got, want := w.Header()["X-Auth"], []string{"a", "b"}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("got downstream header %q want %q", got, want)
+		}
+	})
+
+	t.Run("deny", func(t *testing.T) {
+		r, w := newRequest("Bearer bad"), httptest.NewRecorder()
+		if a.Authorized(r, w) {
+			t.Fatal("request allowed")
+		}
+		if got, want := w.Code, http.StatusUnauthorized; got != want {
+			t.Fatalf("got status %d want %d", got, want)
+		}
+		if got, want := w.Body.String(), "denied"; got != want {
+			t.Fatalf("got body %q want %q", got, want)
+		}
+		if got, want := w.Header().Get("WWW-Authenticate"), "Bearer"; got != want {
+			t.Fatalf("got header %q want %q", got, want)
+		}
+	})
+
+	t.Run("redirect", func(t *testing.T) {
+		r, w := newRequest("Bearer login"), httptest.NewRecorder()
+		if a.Authorized(r, w) {
+			t.Fatal("request allowed")
+		}
+		if got, want := w.Code, http.StatusFound; got != want {
+			t.Fatalf("got status %d want %d", got, want)
+		}
+		if got, want := w.Header().Get("Location"), "https://login/"; got != want {
+			t.Fatalf("got location %q want %q", got, want)
+		}
+	})
+}
+
+func TestExtAuthzUnavailable(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	url := srv.URL
+	srv.Close()
+
+	for _, failOpen := range []bool{false, true} {
+		a, err := newExtAuthz(config.ExtAuthzAuth{URL: url, Timeout: time.Second, FailOpen: failOpen})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		if got, want := a.Authorized(httptest.NewRequest("GET", "/", nil), w), failOpen; got != want {
+			t.Fatalf("failopen=%v: got %v want %v", failOpen, got, want)
+		}
+		if !failOpen && w.Code != http.StatusForbidden {
+			t.Fatalf("got status %d want %d", w.Code, http.StatusForbidden)
+		}
+	}
+}
+
+func TestExtAuthzGRPC(t *testing.T) {
+	var method, path string
+	var headers map[string]string
+	handler := func(srv interface{}, stream grpc.ServerStream) error {
+		var req []byte
+		if err := stream.RecvMsg(&req); err != nil {
+			return err
+		}
+		method, path, headers = decodeTestCheckRequest(t, req)
+
+		var resp []byte
+		switch headers["authorization"] {
+		case "Bearer ok":
+			var ok []byte
+			ok = rawpb.AppendBytes(ok, 2, testHeaderValueOption("X-User", "alice", false))
+			ok = rawpb.AppendBytes(ok, 2, testHeaderValueOption("X-Roles", "admin", true))
+			ok = rawpb.AppendString(ok, 5, "Cookie")
+			ok = rawpb.AppendBytes(ok, 6, testHeaderValueOption("X-Auth", "ok", false))
+			resp = rawpb.AppendBytes(resp, 1, nil)
+			resp = rawpb.AppendBytes(resp, 3, ok)
+		default:
+			var st, status, denied []byte
+			st = protowire.AppendTag(st, 1, protowire.VarintType)
+			st = protowire.AppendVarint(st, 7) // PERMISSION_DENIED
+			status = protowire.AppendTag(status, 1, protowire.VarintType)
+			status = protowire.AppendVarint(status, 401)
+			denied = rawpb.AppendBytes(denied, 1, status)
+			denied = rawpb.AppendBytes(denied, 2, testHeaderValueOption("WWW-Authenticate", "Bearer", false))
+			denied = rawpb.AppendString(denied, 3, "denied")
+			resp = rawpb.AppendBytes(resp, 1, st)
+			resp = rawpb.AppendBytes(resp, 2, denied)
+		}
+		return stream.SendMsg(&resp)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer(grpc.CustomCodec(rawpb.Codec{}), grpc.UnknownServiceHandler(handler))
+	go srv.Serve(l)
+	defer srv.Stop()
+
+	a, err := newExtAuthz(config.ExtAuthzAuth{URL: "grpc://" + l.Addr().String(), Timeout: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("allow", func(t *testing.T) {
+		r, w := httptest.NewRequest("GET", "http://example.com/api?x=1", nil), httptest.NewRecorder()
+		r.Header.Set("Authorization", "Bearer ok")
+		r.Header.Set("Cookie", "session=1")
+		r.Header.Set("X-Roles", "user")
+		if !a.Authorized(r, w) {
+			t.Fatal("request denied")
+		}
+		if method != "GET" || path != "/api?x=1" {
+			t.Fatalf("got %s %s want GET /api?x=1", method, path)
+		}
+		if got, want := headers["cookie"], "session=1"; got != want {
+			t.Fatalf("got cookie %q want %q", got, want)
+		}
+		if got, want := r.Header.Get("X-User"), "alice"; got != want {
+			t.Fatalf("got user %q want %q", got, want)
+		}
+		if got, want := r.Header["X-Roles"], []string{"user", "admin"}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("got roles %q want %q", got, want)
+		}
+		if r.Header.Get("Cookie") != "" {
+			t.Fatal("header not removed")
+		}
+		if got, want := w.Header().Get("X-Auth"), "ok"; got != want {
+			t.Fatalf("got response header %q want %q", got, want)
+		}
+	})
+
+	t.Run("deny", func(t *testing.T) {
+		r, w := httptest.NewRequest("GET", "/", nil), httptest.NewRecorder()
+		if a.Authorized(r, w) {
+			t.Fatal("request allowed")
+		}
+		if got, want := w.Code, http.StatusUnauthorized; got != want {
+			t.Fatalf("got status %d want %d", got, want)
+		}
+		if got, want := w.Body.String(), "denied"; got != want {
+			t.Fatalf("got body %q want %q", got, want)
+		}
+		if got, want := w.Header().Get("WWW-Authenticate"), "Bearer"; got != want {
+			t.Fatalf("got header %q want %q", got, want)
+		}
+	})
+}
+
+func testHeaderValueOption(key, value string, append bool) []byte {
+	var hv, b []byte
+	hv = rawpb.AppendString(hv, 1, key)
+	hv = rawpb.AppendString(hv, 2, value)
+	b = rawpb.AppendBytes(b, 1, hv)
+	if append {
+		var bv []byte
+		bv = protowire.AppendTag(bv, 1, protowire.VarintType)
+		bv = protowire.AppendVarint(bv, 1)
+		b = rawpb.AppendBytes(b, 2, bv)
+	}
+	return b
+}
+
+// decodeTestCheckRequest returns the method, the path and the
+// headers of the HTTP attributes of a CheckRequest.
+func decodeTestCheckRequest(t *testing.T, b []byte) (method, path string, headers map[string]string) {
+	headers = map[string]string{}
+	field := func(b []byte, num protowire.Number) []byte {
+		var v []byte
+		if err := rawpb.Walk(b, func(n protowire.Number, _ uint64, data []byte) error {
+			if n == num {
+				v = data
+			}
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	h := field(field(field(b, 1), 4), 2)
+	err := rawpb.Walk(h, func(num protowire.Number, _ uint64, data []byte) error {
+		switch num {
+		case 2:
+			method = string(data)
+		case 3:
+			k, v := field(data, 1), field(data, 2)
+			headers[string(k)] = string(v)
+		case 4:
+			path = string(data)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return method, path, headers
+}
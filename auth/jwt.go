@@ -0,0 +1,250 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fabiolb/fabio/config"
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+// minRefetch is the minimum time between two requests for the key set
+// when a token is signed with an unknown key.
+var minRefetch = time.Minute
+
+// timeNow is stubbed out for testing.
+var timeNow = time.Now
+
+// jwtAuth is an implementation of AuthScheme which validates bearer
+// tokens with the keys of a JSON Web Key Set.
+type jwtAuth struct {
+	cfg    config.JWTAuth
+	client *http.Client
+
+	// keys contains the *jose.JSONWebKeySet.
+	keys atomic.Value
+
+	// mu guards fetched which is the time of the last
+	// request for the key set.
+	mu      sync.Mutex
+	fetched time.Time
+}
+
+func newJWTAuth(cfg config.JWTAuth) (AuthScheme, error) {
+	if !strings.HasPrefix(cfg.JWKS, "http://") && !strings.HasPrefix(cfg.JWKS, "https://") {
+		return nil, fmt.Errorf("invalid jwks URL %q", cfg.JWKS)
+	}
+
+	a := &jwtAuth{cfg: cfg, client: &http.Client{Timeout: 10 * time.Second}}
+	a.keys.Store(&jose.JSONWebKeySet{})
+
+	// the identity provider may not be available yet and the
+	// keys are fetched again when a token with an unknown key
+	// arrives.
+	a.mu.Lock()
+	if err := a.fetch(); err != nil {
+		log.Printf("[WARN] auth: Cannot load the keys of %s. %s", cfg.Realm, err)
+	}
+	a.mu.Unlock()
+
+	if cfg.Refresh > 0 {
+		go func() {
+			for range time.NewTicker(cfg.Refresh).C {
+				a.mu.Lock()
+				if err := a.fetch(); err != nil {
+					log.Printf("[WARN] auth: Cannot refresh the keys of %s. %s", cfg.Realm, err)
+				}
+				a.mu.Unlock()
+			}
+		}()
+	}
+	return a, nil
+}
+
+// fetch loads the key set. The caller must hold the lock.
+func (a *jwtAuth) fetch() error {
+	a.fetched = timeNow()
+	resp, err := a.client.Get(a.cfg.JWKS)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	var keys jose.JSONWebKeySet
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return err
+	}
+	a.keys.Store(&keys)
+	return nil
+}
+
+func (a *jwtAuth) Authorized(request *http.Request, response http.ResponseWriter) bool {
+	// the headers of the claims must not be set by the client
+	for _, h := range a.cfg.Claims {
+		request.Header.Del(h)
+	}
+
+	token := bearerToken(request)
+	if token == "" {
+		response.Header().Set("WWW-Authenticate", "Bearer realm=\""+a.cfg.Realm+"\"")
+		return false
+	}
+
+	claims, err := a.verify(token)
+	if err != nil {
+		log.Printf("[DEBUG] auth: Rejected token for %s. %s", a.cfg.Realm, err)
+		response.Header().Set("WWW-Authenticate", "Bearer realm=\""+a.cfg.Realm+"\", error=\"invalid_token\"")
+		return false
+	}
+
+	for claim, h := range a.cfg.Claims {
+		if v, ok := claimValue(claims, claim); ok {
+			request.Header.Set(h, v)
+		}
+	}
+	return true
+}
+
+// verify checks the signature, the issuer, the audience and the
+// expiry of the token and returns its claims.
+func (a *jwtAuth) verify(token string) (map[string]interface{}, error) {
+	tok, err := jwt.ParseSigned(token)
+	if err != nil {
+		return nil, err
+	}
+	if len(tok.Headers) != 1 {
+		return nil, errors.New("token must have one signature")
+	}
+
+	kid := tok.Headers[0].KeyID
+	keys := a.signingKeys(kid)
+	if len(keys) == 0 {
+		a.mu.Lock()
+		if timeNow().Sub(a.fetched) >= minRefetch {
+			if err := a.fetch(); err != nil {
+				log.Printf("[WARN] auth: Cannot load the keys of %s. %s", a.cfg.Realm, err)
+			}
+		}
+		a.mu.Unlock()
+		keys = a.signingKeys(kid)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+
+	var std jwt.Claims
+	var claims map[string]interface{}
+	for _, k := range keys {
+		if err = tok.Claims(k, &std, &claims); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if std.Expiry == nil {
+		return nil, errors.New("token has no expiry")
+	}
+	if err := std.ValidateWithLeeway(jwt.Expected{Issuer: a.cfg.Issuer, Time: timeNow()}, a.cfg.Leeway); err != nil {
+		return nil, err
+	}
+	if len(a.cfg.Audience) > 0 {
+		ok := false
+		for _, aud := range a.cfg.Audience {
+			if std.Audience.Contains(aud) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return nil, jwt.ErrInvalidAudience
+		}
+	}
+	return claims, nil
+}
+
+// signingKeys returns the keys with the given id or all
+// signing keys if the token does not name a key.
+func (a *jwtAuth) signingKeys(kid string) []jose.JSONWebKey {
+	set := a.keys.Load().(*jose.JSONWebKeySet)
+	if kid != "" {
+		return set.Key(kid)
+	}
+	var keys []jose.JSONWebKey
+	for _, k := range set.Keys {
+		if k.Use == "" || k.Use == "sig" {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// bearerToken returns the token of the Authorization header.
+func bearerToken(r *http.Request) string {
+	h := r.Header.Get("Authorization")
+	if len(h) < 7 || !strings.EqualFold(h[:7], "bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(h[7:])
+}
+
+// claimValue returns the value of a claim as header value. Nested
+// claims are addressed with dots, e.g. 'realm_access.roles'. Lists
+// are joined with commas and objects are encoded as JSON.
+func claimValue(claims map[string]interface{}, name string) (string, bool) {
+	var v interface{} = claims
+	for _, p := range strings.Split(name, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return "", false
+		}
+		if v, ok = m[p]; !ok {
+			return "", false
+		}
+	}
+
+	var s string
+	switch x := v.(type) {
+	case nil:
+		return "", false
+	case string:
+		s = x
+	case float64:
+		s = strconv.FormatFloat(x, 'f', -1, 64)
+	case bool:
+		s = strconv.FormatBool(x)
+	case []interface{}:
+		var vals []string
+		for _, e := range x {
+			if str, ok := e.(string); ok {
+				vals = append(vals, str)
+			} else {
+				b, _ := json.Marshal(e)
+				vals = append(vals, string(b))
+			}
+		}
+		s = strings.Join(vals, ",")
+	default:
+		b, _ := json.Marshal(x)
+		s = string(b)
+	}
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(s), true
+}
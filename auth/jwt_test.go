@@ -0,0 +1,186 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fabiolb/fabio/config"
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+type testIDP struct {
+	mu   sync.Mutex
+	keys map[string]*rsa.PrivateKey
+	srv  *httptest.Server
+}
+
+func newTestIDP(t *testing.T, kids ...string) *testIDP {
+	idp := &testIDP{keys: map[string]*rsa.PrivateKey{}}
+	for _, kid := range kids {
+		idp.addKey(t, kid)
+	}
+	idp.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		idp.mu.Lock()
+		defer idp.mu.Unlock()
+		var set jose.JSONWebKeySet
+		for kid, k := range idp.keys {
+			set.Keys = append(set.Keys, jose.JSONWebKey{Key: &k.PublicKey, KeyID: kid, Algorithm: "RS256", Use: "sig"})
+		}
+		json.NewEncoder(w).Encode(set)
+	}))
+	return idp
+}
+
+func (idp *testIDP) addKey(t *testing.T, kid string) {
+	k, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp.mu.Lock()
+	idp.keys[kid] = k
+	idp.mu.Unlock()
+}
+
+func (idp *testIDP) token(t *testing.T, kid string, claims ...interface{}) string {
+	idp.mu.Lock()
+	k := idp.keys[kid]
+	idp.mu.Unlock()
+	if k == nil {
+		var err error
+		if k, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sig, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: k}, (&jose.SignerOptions{}).WithHeader("kid", kid))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := jwt.Signed(sig)
+	for _, c := range claims {
+		b = b.Claims(c)
+	}
+	s, err := b.CompactSerialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestJWTAuth(t *testing.T) {
+	now := time.Now()
+	defer func(f func() time.Time) { timeNow = f }(timeNow)
+	timeNow = func() time.Time { return now }
+
+	idp := newTestIDP(t, "k1")
+	defer idp.srv.Close()
+
+	a, err := newJWTAuth(config.JWTAuth{
+		Realm:    "api",
+		JWKS:     idp.srv.URL,
+		Issuer:   "https://idp/",
+		Audience: []string{"api", "web"},
+		Leeway:   time.Minute,
+		Claims:   map[string]string{"sub": "X-User", "roles": "X-Roles", "org.id": "X-Org"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	valid := jwt.Claims{
+		Issuer:   "https://idp/",
+		Subject:  "alice",
+		Audience: jwt.Audience{"web"},
+		Expiry:   jwt.NewNumericDate(now.Add(time.Hour)),
+	}
+	withClaims := func(f func(c *jwt.Claims)) jwt.Claims {
+		c := valid
+		f(&c)
+		return c
+	}
+	extra := map[string]interface{}{"roles": []string{"admin", "dev"}, "org": map[string]interface{}{"id": 42}}
+
+	tests := []struct {
+		desc   string
+		header string
+		ok     bool
+		user   string
+	}{
+		{"no token", "", false, ""},
+		{"basic auth", "Basic Zm9vOmJhcg==", false, ""},
+		{"garbage", "Bearer garbage", false, ""},
+		{"valid", "Bearer " + idp.token(t, "k1", valid, extra), true, "alice"},
+		{"lower case scheme", "bearer " + idp.token(t, "k1", valid), true, "alice"},
+		{"wrong issuer", "Bearer " + idp.token(t, "k1", withClaims(func(c *jwt.Claims) { c.Issuer = "https://evil/" })), false, ""},
+		{"wrong audience", "Bearer " + idp.token(t, "k1", withClaims(func(c *jwt.Claims) { c.Audience = jwt.Audience{"other"} })), false, ""},
+		{"expired", "Bearer " + idp.token(t, "k1", withClaims(func(c *jwt.Claims) { c.Expiry = jwt.NewNumericDate(now.Add(-2 * time.Minute)) })), false, ""},
+		{"expired within leeway", "Bearer " + idp.token(t, "k1", withClaims(func(c *jwt.Claims) { c.Expiry = jwt.NewNumericDate(now.Add(-30 * time.Second)) })), true, "alice"},
+		{"no expiry", "Bearer " + idp.token(t, "k1", withClaims(func(c *jwt.Claims) { c.Expiry = nil })), false, ""},
+		{"not yet valid", "Bearer " + idp.token(t, "k1", withClaims(func(c *jwt.Claims) { c.NotBefore = jwt.NewNumericDate(now.Add(time.Hour)) })), false, ""},
+		{"wrong key", "Bearer " + idp.token(t, "unknown", valid), false, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("X-User", "mallory")
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			if got, want := a.Authorized(req, rec), tt.ok; got != want {
+				t.Fatalf("got %v want %v", got, want)
+			}
+			if got, want := req.Header.Get("X-User"), tt.user; got != want {
+				t.Fatalf("got user %q want %q", got, want)
+			}
+			if !tt.ok && rec.Header().Get("WWW-Authenticate") == "" {
+				t.Fatal("missing WWW-Authenticate header")
+			}
+		})
+	}
+
+	t.Run("claims", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Authorization", "Bearer "+idp.token(t, "k1", valid, extra))
+		if !a.Authorized(req, httptest.NewRecorder()) {
+			t.Fatal("token rejected")
+		}
+		if got, want := req.Header.Get("X-Roles"), "admin,dev"; got != want {
+			t.Fatalf("got roles %q want %q", got, want)
+		}
+		if got, want := req.Header.Get("X-Org"), "42"; got != want {
+			t.Fatalf("got org %q want %q", got, want)
+		}
+	})
+
+	t.Run("key rotation", func(t *testing.T) {
+		idp.addKey(t, "k2")
+		token := "Bearer " + idp.token(t, "k2", valid)
+
+		// the keys were fetched within minRefetch
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Authorization", token)
+		if a.Authorized(req, httptest.NewRecorder()) {
+			t.Fatal("token with new key accepted before refetch")
+		}
+
+		now = now.Add(minRefetch)
+		req = httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Authorization", token)
+		if !a.Authorized(req, httptest.NewRecorder()) {
+			t.Fatal("token with new key rejected")
+		}
+	})
+}
+
+func TestNewJWTAuthInvalidURL(t *testing.T) {
+	if _, err := newJWTAuth(config.JWTAuth{JWKS: "/etc/keys.json"}); err == nil {
+		t.Fatal("got nil want error")
+	}
+}
@@ -0,0 +1,418 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fabiolb/fabio/config"
+)
+
+// ldapStartTLSOID is the name of the StartTLS extended operation.
+const ldapStartTLSOID = "1.3.6.1.4.1.1466.20037"
+
+// ldapInvalidCredentials is the result code of a bind with a wrong password.
+const ldapInvalidCredentials = 49
+
+// maxLDAPCache is the number of cached logins after which
+// the expired entries are removed.
+const maxLDAPCache = 10000
+
+// ldapAuth is an implementation of AuthScheme which verifies the
+// credentials of HTTP basic auth with an LDAP server. The user is
+// searched with the service account and then bound with the password
+// of the request. Successful logins are cached for CacheTTL.
+type ldapAuth struct {
+	realm  string
+	cfg    config.LDAPAuth
+	addr   string
+	tls    *tls.Config
+	ldaps  bool
+	filter string
+
+	// mu guards cache which maps the user names to the
+	// keyed hash of the password and the expiry time.
+	mu    sync.Mutex
+	key   []byte
+	cache map[string]ldapLogin
+}
+
+type ldapLogin struct {
+	hash   []byte
+	expiry time.Time
+}
+
+func newLDAPAuth(cfg config.BasicAuth) (AuthScheme, error) {
+	l := cfg.LDAP
+	u, err := url.Parse(l.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &ldapAuth{
+		realm:  cfg.Realm,
+		cfg:    l,
+		addr:   u.Host,
+		ldaps:  u.Scheme == "ldaps",
+		filter: l.Filter,
+		cache:  map[string]ldapLogin{},
+		key:    make([]byte, 32),
+	}
+	if u.Port() == "" {
+		port := "389"
+		if a.ldaps {
+			port = "636"
+		}
+		a.addr = net.JoinHostPort(u.Hostname(), port)
+	}
+	if a.filter == "" {
+		a.filter = "(uid={user})"
+	}
+
+	// check the filter before the first login
+	if _, err := ldapFilter(strings.Replace(a.filter, "{user}", "x", -1)); err != nil {
+		return nil, err
+	}
+
+	if a.ldaps || l.StartTLS {
+		a.tls = &tls.Config{ServerName: u.Hostname(), InsecureSkipVerify: l.InsecureSkipVerify}
+		if l.CAFile != "" {
+			pem, err := ioutil.ReadFile(l.CAFile)
+			if err != nil {
+				return nil, err
+			}
+			a.tls.RootCAs = x509.NewCertPool()
+			if !a.tls.RootCAs.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("ldap: no certificates in %s", l.CAFile)
+			}
+		}
+	}
+
+	if _, err := rand.Read(a.key); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *ldapAuth) Authorized(request *http.Request, response http.ResponseWriter) bool {
+	user, password, ok := request.BasicAuth()
+	if !ok {
+		response.Header().Set("WWW-Authenticate", "Basic realm=\""+a.realm+"\"")
+		return false
+	}
+
+	// a bind with an empty password is an anonymous
+	// bind which most servers accept.
+	if user == "" || password == "" {
+		return false
+	}
+
+	hash := a.hash(user, password)
+	if a.cached(user, hash) {
+		return true
+	}
+
+	if err := a.login(user, password); err != nil {
+		if le, ok := err.(*ldapError); ok && le.code == ldapInvalidCredentials || err == errLDAPNoUser || err == errLDAPNoMember {
+			log.Printf("[DEBUG] auth: Login of %q failed for %s. %s", user, a.realm, err)
+		} else {
+			log.Printf("[WARN] auth: Cannot verify %q for %s. %s", user, a.realm, err)
+		}
+		return false
+	}
+
+	if a.cfg.CacheTTL > 0 {
+		a.store(user, hash)
+	}
+	return true
+}
+
+// hash returns a keyed hash of the credentials so that
+// the passwords are not kept in memory.
+func (a *ldapAuth) hash(user, password string) []byte {
+	h := hmac.New(sha256.New, a.key)
+	h.Write([]byte(user))
+	h.Write([]byte{0})
+	h.Write([]byte(password))
+	return h.Sum(nil)
+}
+
+func (a *ldapAuth) cached(user string, hash []byte) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	l, ok := a.cache[user]
+	return ok && timeNow().Before(l.expiry) && hmac.Equal(l.hash, hash)
+}
+
+func (a *ldapAuth) store(user string, hash []byte) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := timeNow()
+	if len(a.cache) >= maxLDAPCache {
+		for u, l := range a.cache {
+			if !now.Before(l.expiry) {
+				delete(a.cache, u)
+			}
+		}
+	}
+	if len(a.cache) < maxLDAPCache {
+		a.cache[user] = ldapLogin{hash: hash, expiry: now.Add(a.cfg.CacheTTL)}
+	}
+}
+
+var (
+	errLDAPNoUser   = errors.New("user not found")
+	errLDAPNoMember = errors.New("user is not a member of the group")
+)
+
+// login searches the user, checks the group membership and
+// binds with the password of the user.
+func (a *ldapAuth) login(user, password string) error {
+	c, err := a.dial()
+	if err != nil {
+		return err
+	}
+	defer c.close()
+
+	if a.cfg.BindDN != "" {
+		if err := c.bind(a.cfg.BindDN, a.cfg.BindPassword); err != nil {
+			return fmt.Errorf("bind as %s failed. %s", a.cfg.BindDN, err)
+		}
+	}
+
+	filter, err := ldapFilter(strings.Replace(a.filter, "{user}", filterEscape(user), -1))
+	if err != nil {
+		return err
+	}
+	dns, err := c.search(a.cfg.BaseDN, 2, filter)
+	if err != nil {
+		return err
+	}
+	switch len(dns) {
+	case 0:
+		return errLDAPNoUser
+	case 1:
+	default:
+		return fmt.Errorf("%d entries match the user", len(dns))
+	}
+	dn := dns[0]
+
+	if a.cfg.Group != "" {
+		dnv := filterEscape(dn)
+		filter, err := ldapFilter("(|(member=" + dnv + ")(uniqueMember=" + dnv + "))")
+		if err != nil {
+			return err
+		}
+		groups, err := c.search(a.cfg.Group, 0, filter)
+		if err != nil {
+			return err
+		}
+		if len(groups) == 0 {
+			return errLDAPNoMember
+		}
+	}
+
+	return c.bind(dn, password)
+}
+
+// ldapError is a result code other than success.
+type ldapError struct {
+	code int64
+	msg  string
+}
+
+func (e *ldapError) Error() string {
+	if e.msg == "" {
+		return fmt.Sprintf("ldap: result code %d", e.code)
+	}
+	return fmt.Sprintf("ldap: result code %d: %s", e.code, e.msg)
+}
+
+// ldapConn is a connection to an LDAP server.
+type ldapConn struct {
+	conn net.Conn
+	id   int64
+}
+
+// dial connects to the server and upgrades the connection
+// to TLS if configured. The deadline of the connection
+// limits the whole login to the configured timeout.
+func (a *ldapAuth) dial() (*ldapConn, error) {
+	d := &net.Dialer{Timeout: a.cfg.Timeout}
+	var conn net.Conn
+	var err error
+	if a.ldaps {
+		conn, err = tls.DialWithDialer(d, "tcp", a.addr, a.tls)
+	} else {
+		conn, err = d.Dial("tcp", a.addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(a.cfg.Timeout))
+
+	c := &ldapConn{conn: conn}
+	if a.cfg.StartTLS {
+		op := berEncode(ldapExtendedRequest, berString(0x80, ldapStartTLSOID))
+		if _, err := c.roundTrip(op, ldapExtendedResponse); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("starttls failed. %s", err)
+		}
+		tc := tls.Client(conn, a.tls)
+		if err := tc.Handshake(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		c.conn = tc
+	}
+	return c, nil
+}
+
+// send writes a request with the next message id.
+func (c *ldapConn) send(op []byte) error {
+	c.id++
+	_, err := c.conn.Write(berEncode(berSequence, berInt(berInteger, c.id), op))
+	return err
+}
+
+// receive reads the next response to the last request.
+func (c *ldapConn) receive() (berElement, error) {
+	for {
+		msg, err := berRead(c.conn)
+		if err != nil {
+			return berElement{}, err
+		}
+		elems, err := msg.children()
+		if err != nil {
+			return berElement{}, err
+		}
+		if msg.tag != berSequence || len(elems) < 2 {
+			return berElement{}, errors.New("ldap: invalid message")
+		}
+		id, err := elems[0].int()
+		if err != nil {
+			return berElement{}, err
+		}
+		// skip unsolicited notifications
+		if id == c.id {
+			return elems[1], nil
+		}
+	}
+}
+
+// roundTrip sends a request and checks the result of the response.
+func (c *ldapConn) roundTrip(op []byte, tag byte) (berElement, error) {
+	if err := c.send(op); err != nil {
+		return berElement{}, err
+	}
+	resp, err := c.receive()
+	if err != nil {
+		return berElement{}, err
+	}
+	if resp.tag != tag {
+		return berElement{}, fmt.Errorf("ldap: unexpected response 0x%02x", resp.tag)
+	}
+	return resp, ldapResult(resp)
+}
+
+// ldapResult returns an error if the result code
+// of the response is not success.
+func ldapResult(resp berElement) error {
+	elems, err := resp.children()
+	if err != nil {
+		return err
+	}
+	if len(elems) < 3 || elems[0].tag != berEnumerated {
+		return errors.New("ldap: invalid result")
+	}
+	code, err := elems[0].int()
+	if err != nil {
+		return err
+	}
+	if code != 0 {
+		return &ldapError{code: code, msg: string(elems[2].data)}
+	}
+	return nil
+}
+
+// bind authenticates with a simple bind.
+func (c *ldapConn) bind(dn, password string) error {
+	op := berEncode(ldapBindRequest,
+		berInt(berInteger, 3),
+		berString(berOctetString, dn),
+		berString(0x80, password),
+	)
+	_, err := c.roundTrip(op, ldapBindResponse)
+	return err
+}
+
+// search returns the DNs of the entries below base which
+// match the filter. Scope is 0 for the base object only
+// and 2 for the whole subtree.
+func (c *ldapConn) search(base string, scope int64, filter []byte) ([]string, error) {
+	op := berEncode(ldapSearchRequest,
+		berString(berOctetString, base),
+		berInt(berEnumerated, scope),
+		berInt(berEnumerated, 0), // never dereference aliases
+		berInt(berInteger, 2),    // size limit
+		berInt(berInteger, 0),    // the deadline of the connection limits the time
+		berBool(true),            // types only
+		filter,
+		berEncode(berSequence, berString(berOctetString, "1.1")), // no attributes
+	)
+	if err := c.send(op); err != nil {
+		return nil, err
+	}
+
+	var dns []string
+	for {
+		resp, err := c.receive()
+		if err != nil {
+			return nil, err
+		}
+		switch resp.tag {
+		case ldapSearchResultEntry:
+			elems, err := resp.children()
+			if err != nil {
+				return nil, err
+			}
+			if len(elems) == 0 {
+				return nil, errors.New("ldap: invalid search result")
+			}
+			dns = append(dns, string(elems[0].data))
+		case ldapSearchResultReference:
+			// referrals are not followed
+		case ldapSearchResultDone:
+			err := ldapResult(resp)
+			// a base object which does not exist is not an error
+			if le, ok := err.(*ldapError); ok && le.code == 32 && scope == 0 {
+				return nil, nil
+			}
+			// the size limit exceeded result still returns the entries
+			if le, ok := err.(*ldapError); ok && le.code == 4 {
+				return dns, nil
+			}
+			return dns, err
+		default:
+			return nil, fmt.Errorf("ldap: unexpected response 0x%02x", resp.tag)
+		}
+	}
+}
+
+// close sends an unbind request and closes the connection.
+func (c *ldapConn) close() {
+	c.send(berEncode(ldapUnbindRequest))
+	c.conn.Close()
+}
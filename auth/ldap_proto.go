@@ -0,0 +1,377 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// The BER tags of the LDAP protocol (RFC 4511) used by the client.
+const (
+	berBoolean     = 0x01
+	berInteger     = 0x02
+	berOctetString = 0x04
+	berEnumerated  = 0x0a
+	berSequence    = 0x30
+
+	ldapBindRequest           = 0x60
+	ldapBindResponse          = 0x61
+	ldapUnbindRequest         = 0x42
+	ldapSearchRequest         = 0x63
+	ldapSearchResultEntry     = 0x64
+	ldapSearchResultDone      = 0x65
+	ldapSearchResultReference = 0x73
+	ldapExtendedRequest       = 0x77
+	ldapExtendedResponse      = 0x78
+)
+
+// maxLDAPMessage is the maximum size of a message from the LDAP server.
+const maxLDAPMessage = 1 << 20
+
+// berElement is a decoded BER element.
+type berElement struct {
+	tag  byte
+	data []byte
+}
+
+// children decodes the elements of a constructed element.
+func (e berElement) children() ([]berElement, error) {
+	var elems []berElement
+	b := e.data
+	for len(b) > 0 {
+		c, rest, err := berDecode(b)
+		if err != nil {
+			return nil, err
+		}
+		elems = append(elems, c)
+		b = rest
+	}
+	return elems, nil
+}
+
+// int returns the value of an integer or enumerated element.
+func (e berElement) int() (int64, error) {
+	if len(e.data) == 0 || len(e.data) > 8 {
+		return 0, errors.New("ldap: invalid integer")
+	}
+	v := int64(int8(e.data[0]))
+	for _, c := range e.data[1:] {
+		v = v<<8 | int64(c)
+	}
+	return v, nil
+}
+
+// berDecode decodes the first element of b. Only the definite length
+// form and single byte tags are supported which is what LDAP uses.
+func berDecode(b []byte) (e berElement, rest []byte, err error) {
+	if len(b) < 2 {
+		return e, nil, io.ErrUnexpectedEOF
+	}
+	if b[0]&0x1f == 0x1f {
+		return e, nil, errors.New("ldap: unsupported tag")
+	}
+	e.tag = b[0]
+	n := int(b[1])
+	b = b[2:]
+	if n&0x80 != 0 {
+		l := n & 0x7f
+		if l == 0 || l > 4 || len(b) < l {
+			return e, nil, errors.New("ldap: invalid length")
+		}
+		n = 0
+		for _, c := range b[:l] {
+			n = n<<8 | int(c)
+		}
+		b = b[l:]
+	}
+	if n < 0 || n > len(b) {
+		return e, nil, io.ErrUnexpectedEOF
+	}
+	e.data = b[:n]
+	return e, b[n:], nil
+}
+
+// berRead reads one element from r.
+func berRead(r io.Reader) (berElement, error) {
+	hdr := make([]byte, 2, 6)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return berElement{}, err
+	}
+	n := int(hdr[1])
+	if n&0x80 != 0 {
+		l := n & 0x7f
+		if l == 0 || l > 4 {
+			return berElement{}, errors.New("ldap: invalid length")
+		}
+		hdr = hdr[:2+l]
+		if _, err := io.ReadFull(r, hdr[2:]); err != nil {
+			return berElement{}, err
+		}
+		n = 0
+		for _, c := range hdr[2:] {
+			n = n<<8 | int(c)
+		}
+	}
+	if n > maxLDAPMessage {
+		return berElement{}, fmt.Errorf("ldap: message too large (%d bytes)", n)
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return berElement{}, err
+	}
+	return berElement{tag: hdr[0], data: data}, nil
+}
+
+// berEncode encodes an element with the given tag and contents.
+func berEncode(tag byte, data ...[]byte) []byte {
+	content := bytes.Join(data, nil)
+	b := []byte{tag}
+	n := len(content)
+	if n < 0x80 {
+		b = append(b, byte(n))
+	} else {
+		var l []byte
+		for ; n > 0; n >>= 8 {
+			l = append([]byte{byte(n)}, l...)
+		}
+		b = append(b, 0x80|byte(len(l)))
+		b = append(b, l...)
+	}
+	return append(b, content...)
+}
+
+// berInt encodes an integer in the shortest two's complement form.
+func berInt(tag byte, v int64) []byte {
+	var b []byte
+	for {
+		b = append([]byte{byte(v)}, b...)
+		if v >= -128 && v < 128 {
+			return berEncode(tag, b)
+		}
+		v >>= 8
+	}
+}
+
+func berString(tag byte, s string) []byte {
+	return berEncode(tag, []byte(s))
+}
+
+func berBool(v bool) []byte {
+	if v {
+		return berEncode(berBoolean, []byte{0xff})
+	}
+	return berEncode(berBoolean, []byte{0})
+}
+
+// ldapFilter compiles a search filter in the string representation
+// of RFC 4515, e.g. '(&(objectClass=person)(uid=alice))', to BER.
+func ldapFilter(s string) ([]byte, error) {
+	b, rest, err := parseFilter(s)
+	if err != nil {
+		return nil, err
+	}
+	if rest != "" {
+		return nil, fmt.Errorf("ldap: invalid filter %q", s)
+	}
+	return b, nil
+}
+
+// parseFilter compiles the filter at the start of s
+// and returns the remainder of s.
+func parseFilter(s string) ([]byte, string, error) {
+	if len(s) < 2 || s[0] != '(' {
+		return nil, "", fmt.Errorf("ldap: invalid filter %q", s)
+	}
+	s = s[1:]
+
+	switch s[0] {
+	case '&', '|', '!':
+		tag := map[byte]byte{'&': 0xa0, '|': 0xa1, '!': 0xa2}[s[0]]
+		s = s[1:]
+		var list [][]byte
+		for len(s) > 0 && s[0] == '(' {
+			f, rest, err := parseFilter(s)
+			if err != nil {
+				return nil, "", err
+			}
+			list = append(list, f)
+			s = rest
+		}
+		if len(s) == 0 || s[0] != ')' || (tag == 0xa2 && len(list) != 1) {
+			return nil, "", fmt.Errorf("ldap: invalid filter %q", s)
+		}
+		return berEncode(tag, list...), s[1:], nil
+
+	default:
+		i := strings.IndexByte(s, ')')
+		if i < 0 {
+			return nil, "", fmt.Errorf("ldap: invalid filter %q", s)
+		}
+		f, err := filterItem(s[:i])
+		if err != nil {
+			return nil, "", err
+		}
+		return f, s[i+1:], nil
+	}
+}
+
+// filterItem compiles a simple filter like 'uid=alice' or 'cn=a*'.
+func filterItem(item string) ([]byte, error) {
+	eq := strings.IndexByte(item, '=')
+	if eq < 1 {
+		return nil, fmt.Errorf("ldap: invalid filter item %q", item)
+	}
+	attr, value := item[:eq], item[eq+1:]
+
+	var tag byte
+	switch attr[len(attr)-1] {
+	case '~':
+		tag = 0xa8
+	case '>':
+		tag = 0xa5
+	case '<':
+		tag = 0xa6
+	case ':':
+		return extensibleItem(attr[:len(attr)-1], value)
+	}
+	if tag != 0 {
+		attr = attr[:len(attr)-1]
+	}
+	if !validAttr(attr) {
+		return nil, fmt.Errorf("ldap: invalid attribute %q", attr)
+	}
+
+	if tag == 0 {
+		switch {
+		case value == "*":
+			return berString(0x87, attr), nil
+		case strings.Contains(value, "*"):
+			return substringsItem(attr, value)
+		default:
+			tag = 0xa3
+		}
+	}
+	v, err := filterUnescape(value)
+	if err != nil {
+		return nil, err
+	}
+	return berEncode(tag, berString(berOctetString, attr), berString(berOctetString, v)), nil
+}
+
+// substringsItem compiles a filter like 'cn=a*b*c'.
+func substringsItem(attr, value string) ([]byte, error) {
+	parts := strings.Split(value, "*")
+	var subs [][]byte
+	for i, p := range parts {
+		if p == "" {
+			continue
+		}
+		v, err := filterUnescape(p)
+		if err != nil {
+			return nil, err
+		}
+		var tag byte = 0x81 // any
+		switch i {
+		case 0:
+			tag = 0x80 // initial
+		case len(parts) - 1:
+			tag = 0x82 // final
+		}
+		subs = append(subs, berString(tag, v))
+	}
+	return berEncode(0xa4, berString(berOctetString, attr), berEncode(berSequence, subs...)), nil
+}
+
+// extensibleItem compiles a filter like 'memberOf:1.2.840.113556.1.4.1941:=<dn>'
+// where left is the part before ':='.
+func extensibleItem(left, value string) ([]byte, error) {
+	parts := strings.Split(left, ":")
+	attr, dn, rule := parts[0], false, ""
+	for _, p := range parts[1:] {
+		switch {
+		case strings.EqualFold(p, "dn") && !dn && rule == "":
+			dn = true
+		case p != "" && rule == "":
+			rule = p
+		default:
+			return nil, fmt.Errorf("ldap: invalid filter item %q", left+":="+value)
+		}
+	}
+	if attr == "" && rule == "" || attr != "" && !validAttr(attr) {
+		return nil, fmt.Errorf("ldap: invalid filter item %q", left+":="+value)
+	}
+	v, err := filterUnescape(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var b [][]byte
+	if rule != "" {
+		b = append(b, berString(0x81, rule))
+	}
+	if attr != "" {
+		b = append(b, berString(0x82, attr))
+	}
+	b = append(b, berString(0x83, v))
+	if dn {
+		b = append(b, berEncode(0x84, []byte{0xff}))
+	}
+	return berEncode(0xa9, b...), nil
+}
+
+// validAttr returns true if s is an attribute description.
+func validAttr(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '.', c == ';':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// filterUnescape decodes the \XX escape sequences of a filter value.
+func filterUnescape(s string) (string, error) {
+	if !strings.Contains(s, `\`) {
+		return s, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+3 > len(s) {
+			return "", fmt.Errorf("ldap: invalid escape sequence in %q", s)
+		}
+		c, err := hex.DecodeString(s[i+1 : i+3])
+		if err != nil {
+			return "", fmt.Errorf("ldap: invalid escape sequence in %q", s)
+		}
+		b.WriteByte(c[0])
+		i += 2
+	}
+	return b.String(), nil
+}
+
+// filterEscape escapes the characters of s which
+// have a special meaning in a filter value.
+func filterEscape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '*', '(', ')', '\\', 0:
+			fmt.Fprintf(&b, `\%02x`, c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
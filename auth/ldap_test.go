@@ -0,0 +1,315 @@
+package auth
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fabiolb/fabio/config"
+)
+
+// testDirectory is an LDAP server which supports the bind, search and
+// unbind operations on a fixed set of entries.
+type testDirectory struct {
+	entries   map[string]map[string][]string
+	passwords map[string]string
+
+	mu    sync.Mutex
+	binds map[string]int
+}
+
+func (d *testDirectory) serve(t *testing.T, l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go d.handle(t, conn)
+	}
+}
+
+func (d *testDirectory) handle(t *testing.T, conn net.Conn) {
+	defer conn.Close()
+	for {
+		msg, err := berRead(conn)
+		if err != nil {
+			return
+		}
+		elems, _ := msg.children()
+		id, _ := elems[0].int()
+		reply := func(op []byte) {
+			conn.Write(berEncode(berSequence, berInt(berInteger, id), op))
+		}
+		result := func(tag byte, code int64) {
+			reply(berEncode(tag, berInt(berEnumerated, code), berString(berOctetString, ""), berString(berOctetString, "")))
+		}
+
+		op := elems[1]
+		args, _ := op.children()
+		switch op.tag {
+		case ldapBindRequest:
+			dn, pw := string(args[1].data), string(args[2].data)
+			d.mu.Lock()
+			d.binds[dn]++
+			d.mu.Unlock()
+			if pw != "" && d.passwords[dn] == pw {
+				result(ldapBindResponse, 0)
+			} else {
+				result(ldapBindResponse, ldapInvalidCredentials)
+			}
+
+		case ldapSearchRequest:
+			base := string(args[0].data)
+			scope, _ := args[1].int()
+			if scope == 0 && d.entries[base] == nil {
+				result(ldapSearchResultDone, 32)
+				continue
+			}
+			for dn, attrs := range d.entries {
+				if scope == 0 && dn != base || !strings.HasSuffix(dn, base) {
+					continue
+				}
+				if matchTestFilter(t, args[6], attrs) {
+					reply(berEncode(ldapSearchResultEntry, berString(berOctetString, dn), berEncode(berSequence)))
+				}
+			}
+			result(ldapSearchResultDone, 0)
+
+		case ldapUnbindRequest:
+			return
+
+		default:
+			t.Errorf("unexpected operation 0x%02x", op.tag)
+			return
+		}
+	}
+}
+
+// matchTestFilter evaluates the and, or, equality
+// and presence filters against the attributes.
+func matchTestFilter(t *testing.T, f berElement, attrs map[string][]string) bool {
+	elems, _ := f.children()
+	switch f.tag {
+	case 0xa0, 0xa1:
+		for _, e := range elems {
+			if matchTestFilter(t, e, attrs) == (f.tag == 0xa1) {
+				return f.tag == 0xa1
+			}
+		}
+		return f.tag == 0xa0
+	case 0xa3:
+		for _, v := range attrs[strings.ToLower(string(elems[0].data))] {
+			if v == string(elems[1].data) {
+				return true
+			}
+		}
+		return false
+	case 0x87:
+		return attrs[strings.ToLower(string(f.data))] != nil
+	default:
+		t.Errorf("unexpected filter 0x%02x", f.tag)
+		return false
+	}
+}
+
+func TestLDAPAuth(t *testing.T) {
+	const (
+		admin = "cn=fabio,dc=example,dc=org"
+		alice = "uid=alice,ou=people,dc=example,dc=org"
+		bob   = "uid=bob,ou=people,dc=example,dc=org"
+		group = "cn=ops,ou=groups,dc=example,dc=org"
+	)
+	dir := &testDirectory{
+		entries: map[string]map[string][]string{
+			alice: {"uid": {"alice"}, "objectclass": {"person"}},
+			bob:   {"uid": {"bob"}, "objectclass": {"person"}},
+			group: {"member": {alice}},
+		},
+		passwords: map[string]string{admin: "secret", alice: "alice-pw", bob: "bob-pw"},
+		binds:     map[string]int{},
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go dir.serve(t, l)
+
+	now := time.Now()
+	timeNow = func() time.Time { return now }
+	defer func() { timeNow = time.Now }()
+
+	a, err := newBasicAuth(config.BasicAuth{
+		Realm: "ldap",
+		LDAP: config.LDAPAuth{
+			URL:          "ldap://" + l.Addr().String(),
+			Timeout:      5 * time.Second,
+			BindDN:       admin,
+			BindPassword: "secret",
+			BaseDN:       "ou=people,dc=example,dc=org",
+			Filter:       "(&(objectClass=person)(uid={user}))",
+			Group:        group,
+			CacheTTL:     time.Minute,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	login := func(user, password string) bool {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.SetBasicAuth(user, password)
+		return a.Authorized(r, httptest.NewRecorder())
+	}
+	binds := func(dn string) int {
+		dir.mu.Lock()
+		defer dir.mu.Unlock()
+		return dir.binds[dn]
+	}
+
+	t.Run("no credentials", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		if a.Authorized(&http.Request{Header: http.Header{}}, w) {
+			t.Fatal("request allowed")
+		}
+		if got, want := w.Header().Get("WWW-Authenticate"), `Basic realm="ldap"`; got != want {
+			t.Fatalf("got %q want %q", got, want)
+		}
+	})
+
+	tests := []struct {
+		desc, user, password string
+		ok                   bool
+	}{
+		{"valid", "alice", "alice-pw", true},
+		{"wrong password", "alice", "wrong", false},
+		{"empty password", "alice", "", false},
+		{"unknown user", "carol", "carol-pw", false},
+		{"not in group", "bob", "bob-pw", false},
+		{"filter injection", "*", "alice-pw", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			if got, want := login(tt.user, tt.password), tt.ok; got != want {
+				t.Fatalf("got %v want %v", got, want)
+			}
+		})
+	}
+
+	t.Run("cache", func(t *testing.T) {
+		n := binds(alice)
+		if !login("alice", "alice-pw") {
+			t.Fatal("login failed")
+		}
+		if got, want := binds(alice), n; got != want {
+			t.Fatalf("got %d binds want %d", got, want)
+		}
+		if login("alice", "wrong") {
+			t.Fatal("login with wrong password succeeded")
+		}
+		if got, want := binds(alice), n+1; got != want {
+			t.Fatalf("got %d binds want %d", got, want)
+		}
+
+		now = now.Add(time.Minute)
+		if !login("alice", "alice-pw") {
+			t.Fatal("login failed")
+		}
+		if got, want := binds(alice), n+2; got != want {
+			t.Fatalf("got %d binds want %d", got, want)
+		}
+	})
+}
+
+func TestLDAPAuthUnavailable(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	a, err := newBasicAuth(config.BasicAuth{
+		LDAP: config.LDAPAuth{URL: "ldap://" + addr, Timeout: time.Second, BaseDN: "dc=org", Filter: "(uid={user})"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest("GET", "/", nil)
+	r.SetBasicAuth("alice", "alice-pw")
+	if a.Authorized(r, httptest.NewRecorder()) {
+		t.Fatal("request allowed")
+	}
+}
+
+func TestLDAPFilter(t *testing.T) {
+	tests := []struct {
+		in  string
+		out []byte
+		err bool
+	}{
+		{
+			in:  "(uid=alice)",
+			out: berEncode(0xa3, berString(berOctetString, "uid"), berString(berOctetString, "alice")),
+		},
+		{
+			in:  `(cn=a\2a\29)`,
+			out: berEncode(0xa3, berString(berOctetString, "cn"), berString(berOctetString, "a*)")),
+		},
+		{
+			in:  "(mail=*)",
+			out: berString(0x87, "mail"),
+		},
+		{
+			in: "(cn=a*b*)",
+			out: berEncode(0xa4, berString(berOctetString, "cn"), berEncode(berSequence,
+				berString(0x80, "a"), berString(0x81, "b"))),
+		},
+		{
+			in: "(&(uid=a)(!(age>=3)))",
+			out: berEncode(0xa0,
+				berEncode(0xa3, berString(berOctetString, "uid"), berString(berOctetString, "a")),
+				berEncode(0xa2, berEncode(0xa5, berString(berOctetString, "age"), berString(berOctetString, "3")))),
+		},
+		{
+			in: "(memberOf:1.2.840.113556.1.4.1941:=cn=ops)",
+			out: berEncode(0xa9, berString(0x81, "1.2.840.113556.1.4.1941"),
+				berString(0x82, "memberOf"), berString(0x83, "cn=ops")),
+		},
+		{in: "uid=alice", err: true},
+		{in: "(uid=alice", err: true},
+		{in: "(uid=alice))", err: true},
+		{in: "(!(a=b)(c=d))", err: true},
+		{in: "(u id=a)", err: true},
+		{in: `(uid=\2)`, err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ldapFilter(tt.in)
+			if gotErr := err != nil; gotErr != tt.err {
+				t.Fatalf("got error %v want error %v", err, tt.err)
+			}
+			if !bytes.Equal(got, tt.out) {
+				t.Fatalf("got %x want %x", got, tt.out)
+			}
+		})
+	}
+}
+
+func TestBERInt(t *testing.T) {
+	for _, v := range []int64{0, 1, 127, 128, 255, 256, -1, -128, -129, 1 << 40} {
+		e, rest, err := berDecode(berInt(berInteger, v))
+		if err != nil || len(rest) > 0 {
+			t.Fatalf("%d: %v", v, err)
+		}
+		if got, err := e.int(); err != nil || got != v {
+			t.Fatalf("got %d want %d", got, v)
+		}
+	}
+}
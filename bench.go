@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fabiolb/fabio/config"
+	"github.com/fabiolb/fabio/logger"
+	"github.com/fabiolb/fabio/route"
+)
+
+// benchOptions configures the load generator of the bench command.
+type benchOptions struct {
+	Duration    time.Duration
+	Concurrency int
+	Backends    int
+	Size        int
+	Latency     time.Duration
+	Routes      []string
+}
+
+// benchResult contains the measurements of a bench run.
+type benchResult struct {
+	Requests     int64         `json:"requests"`
+	Errors       int64         `json:"errors"`
+	Duration     time.Duration `json:"duration"`
+	Throughput   float64       `json:"throughput"`
+	Latency      benchLatency  `json:"latency"`
+	AllocsPerReq float64       `json:"allocsPerReq"`
+	BytesPerReq  float64       `json:"bytesPerReq"`
+}
+
+// benchLatency contains the latency percentiles of a bench run.
+type benchLatency struct {
+	P50  time.Duration `json:"p50"`
+	P90  time.Duration `json:"p90"`
+	P99  time.Duration `json:"p99"`
+	P999 time.Duration `json:"p999"`
+	Max  time.Duration `json:"max"`
+}
+
+// runBench implements the 'fabio bench' command. Arguments after '--'
+// are fabio command line flags which configure the proxy under test.
+func runBench(args []string, out io.Writer) error {
+	var benchArgs, fabioArgs []string
+	benchArgs = args
+	for i, arg := range args {
+		if arg == "--" {
+			benchArgs, fabioArgs = args[:i], args[i+1:]
+			break
+		}
+	}
+
+	var opts benchOptions
+	var routes string
+	var asJSON bool
+	f := flag.NewFlagSet("fabio bench", flag.ContinueOnError)
+	f.SetOutput(out)
+	f.Usage = func() {
+		fmt.Fprintln(out, "Usage: fabio bench [options] [-- fabio options]")
+		f.PrintDefaults()
+	}
+	f.DurationVar(&opts.Duration, "duration", 10*time.Second, "duration of the load test")
+	f.IntVar(&opts.Concurrency, "c", 16, "number of concurrent clients")
+	f.IntVar(&opts.Backends, "backends", 4, "number of mock backends per route")
+	f.IntVar(&opts.Size, "size", 1024, "response body size of the mock backends in bytes")
+	f.DurationVar(&opts.Latency, "latency", 0, "response latency of the mock backends")
+	f.StringVar(&routes, "routes", "/", "comma separated list of route sources, e.g. '/,example.com/api'")
+	f.BoolVar(&asJSON, "json", false, "write the result as JSON")
+	if err := f.Parse(benchArgs); err != nil {
+		if err == flag.ErrHelp {
+			return nil
+		}
+		return err
+	}
+	for _, src := range strings.Split(routes, ",") {
+		if src = strings.TrimSpace(src); src != "" {
+			opts.Routes = append(opts.Routes, src)
+		}
+	}
+
+	cfg, err := config.Load(append([]string{"fabio"}, fabioArgs...), os.Environ())
+	if err != nil {
+		return err
+	}
+	if cfg == nil {
+		return errors.New("invalid fabio options")
+	}
+
+	// the proxy logs every request which fails. Only
+	// report problems to not skew the measurements.
+	log.SetOutput(logger.NewLevelWriter(os.Stderr, "WARN", "2017/01/01 00:00:00 "))
+
+	res, err := bench(opts, cfg)
+	if err != nil {
+		return err
+	}
+	if asJSON {
+		return json.NewEncoder(out).Encode(res)
+	}
+	printBenchResult(out, opts, res)
+	return nil
+}
+
+// bench runs the proxy for the given config in-process, routes the
+// sources to mock backends and sends requests with opts.Concurrency
+// clients for opts.Duration.
+func bench(opts benchOptions, cfg *config.Config) (*benchResult, error) {
+	switch {
+	case opts.Duration <= 0:
+		return nil, errors.New("duration must be positive")
+	case opts.Concurrency < 1:
+		return nil, errors.New("concurrency must be at least 1")
+	case opts.Backends < 1:
+		return nil, errors.New("at least one backend is required")
+	case opts.Size < 0:
+		return nil, errors.New("size must not be negative")
+	case len(opts.Routes) == 0:
+		return nil, errors.New("at least one route is required")
+	}
+
+	body := bytes.Repeat([]byte("x"), opts.Size)
+	backend := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if opts.Latency > 0 {
+			time.Sleep(opts.Latency)
+		}
+		w.Write(body)
+	})
+
+	var table bytes.Buffer
+	for i := 0; i < opts.Backends; i++ {
+		srv := httptest.NewServer(backend)
+		defer srv.Close()
+		for _, src := range opts.Routes {
+			fmt.Fprintf(&table, "route add bench-%d %s %s\n", i, src, srv.URL)
+		}
+	}
+	t, err := route.NewTable(&table)
+	if err != nil {
+		return nil, err
+	}
+	route.SetTable(t)
+
+	// the proxy uses the strategy and the matcher of the running config
+	runtimeCfg.Store(cfg)
+	proxySrv := httptest.NewServer(newHTTPProxy(cfg))
+	defer proxySrv.Close()
+
+	var reqs []*http.Request
+	for _, src := range opts.Routes {
+		p := strings.Index(src, "/")
+		if p < 0 {
+			return nil, fmt.Errorf("invalid route source %q", src)
+		}
+		req, err := http.NewRequest("GET", proxySrv.URL+src[p:], nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Host = src[:p]
+		reqs = append(reqs, req)
+	}
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			MaxIdleConnsPerHost: opts.Concurrency,
+			DisableCompression:  true,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	var (
+		mu        sync.Mutex
+		wg        sync.WaitGroup
+		latencies []time.Duration
+		errs      int64
+	)
+
+	runtime.GC()
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+
+	start := time.Now()
+	deadline := start.Add(opts.Duration)
+	for i := 0; i < opts.Concurrency; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var lat []time.Duration
+			var n int64
+			for j := i; time.Now().Before(deadline); j++ {
+				req := reqs[j%len(reqs)]
+				t0 := time.Now()
+				resp, err := client.Do(req)
+				if err == nil {
+					_, err = io.Copy(ioutil.Discard, resp.Body)
+					resp.Body.Close()
+					if err == nil && resp.StatusCode >= 400 {
+						err = errors.New(resp.Status)
+					}
+				}
+				lat = append(lat, time.Since(t0))
+				if err != nil {
+					n++
+				}
+			}
+			mu.Lock()
+			latencies = append(latencies, lat...)
+			errs += n
+			mu.Unlock()
+		}(i)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	runtime.ReadMemStats(&after)
+
+	res := &benchResult{
+		Requests: int64(len(latencies)),
+		Errors:   errs,
+		Duration: elapsed,
+	}
+	if res.Requests == 0 {
+		return res, nil
+	}
+	res.Throughput = float64(res.Requests) / elapsed.Seconds()
+	res.AllocsPerReq = float64(after.Mallocs-before.Mallocs) / float64(res.Requests)
+	res.BytesPerReq = float64(after.TotalAlloc-before.TotalAlloc) / float64(res.Requests)
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	res.Latency = benchLatency{
+		P50:  percentile(latencies, 50),
+		P90:  percentile(latencies, 90),
+		P99:  percentile(latencies, 99),
+		P999: percentile(latencies, 99.9),
+		Max:  latencies[len(latencies)-1],
+	}
+	return res, nil
+}
+
+// percentile returns the p-th percentile of the sorted
+// values with the nearest rank method.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	n := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if n < 0 {
+		n = 0
+	}
+	return sorted[n]
+}
+
+func round(d time.Duration) time.Duration {
+	return d.Round(time.Microsecond)
+}
+
+func printBenchResult(w io.Writer, opts benchOptions, res *benchResult) {
+	fmt.Fprintf(w, "Clients       %d\n", opts.Concurrency)
+	fmt.Fprintf(w, "Backends      %d per route\n", opts.Backends)
+	fmt.Fprintf(w, "Routes        %s\n", strings.Join(opts.Routes, ", "))
+	fmt.Fprintf(w, "Duration      %s\n", res.Duration.Round(time.Millisecond))
+	fmt.Fprintf(w, "Requests      %d (%d errors)\n", res.Requests, res.Errors)
+	fmt.Fprintf(w, "Throughput    %.1f req/s\n", res.Throughput)
+	fmt.Fprintf(w, "Latency       p50 %s  p90 %s  p99 %s  p99.9 %s  max %s\n",
+		round(res.Latency.P50), round(res.Latency.P90), round(res.Latency.P99), round(res.Latency.P999), round(res.Latency.Max))
+	fmt.Fprintf(w, "Allocations   %.0f allocs/req  %.0f B/req\n", res.AllocsPerReq, res.BytesPerReq)
+}
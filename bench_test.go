@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fabiolb/fabio/config"
+)
+
+func TestPercentile(t *testing.T) {
+	var d []time.Duration
+	for i := 1; i <= 100; i++ {
+		d = append(d, time.Duration(i))
+	}
+	tests := []struct {
+		p    float64
+		want time.Duration
+	}{
+		{0, 1}, {50, 50}, {90, 90}, {99, 99}, {99.9, 100}, {100, 100},
+	}
+	for _, tt := range tests {
+		if got := percentile(d, tt.p); got != tt.want {
+			t.Errorf("p%v: got %v want %v", tt.p, got, tt.want)
+		}
+	}
+	if got := percentile(nil, 50); got != 0 {
+		t.Errorf("got %v want 0", got)
+	}
+}
+
+func TestBench(t *testing.T) {
+	cfg, err := config.Load([]string{"fabio"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := benchOptions{
+		Duration:    200 * time.Millisecond,
+		Concurrency: 2,
+		Backends:    2,
+		Size:        16,
+		Routes:      []string{"/", "example.com/api"},
+	}
+	res, err := bench(opts, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Requests == 0 {
+		t.Fatal("no requests")
+	}
+	if res.Errors != 0 {
+		t.Fatalf("got %d errors want 0", res.Errors)
+	}
+	if res.Latency.P50 <= 0 || res.Latency.P50 > res.Latency.Max {
+		t.Fatalf("invalid latencies %+v", res.Latency)
+	}
+	if res.AllocsPerReq <= 0 {
+		t.Fatalf("got %v allocs/req want > 0", res.AllocsPerReq)
+	}
+}
@@ -0,0 +1,169 @@
+// Package cache stores the responses of routes with the cache option
+// so that repeated GET requests are served without a request to the
+// target.
+//
+// Responses are only stored if their Cache-Control header allows a
+// shared cache to store them. The lifetime of an entry is the max-age
+// or s-maxage of the response but never longer than the lifetime
+// configured for the route. Entries are kept in memory or on disk
+// with a Store.
+package cache
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Entry is a stored response.
+type Entry struct {
+	// Service and Path identify the entries which are purged.
+	Service string
+	Path    string
+
+	Status int
+	Header http.Header
+	Body   []byte
+
+	Stored  time.Time
+	Expires time.Time
+}
+
+// Size returns the approximate memory size of the entry in bytes.
+func (e *Entry) Size() int64 {
+	n := len(e.Service) + len(e.Path) + len(e.Body)
+	for k, vs := range e.Header {
+		n += len(k)
+		for _, v := range vs {
+			n += len(v)
+		}
+	}
+	return int64(n)
+}
+
+// Expired returns true if the entry has expired at time now.
+func (e *Entry) Expired(now time.Time) bool {
+	return !now.Before(e.Expires)
+}
+
+// Stats describes the content of a store.
+type Stats struct {
+	Entries int   `json:"entries"`
+	Size    int64 `json:"size"`
+}
+
+// Store stores the cache entries by key. Implementations must be
+// safe for concurrent use.
+type Store interface {
+	// Get returns the entry for the key or nil if there is no entry
+	// or it has expired.
+	Get(key string) *Entry
+
+	// Set stores the entry under the key. Entries which are too
+	// large for the store are not stored.
+	Set(key string, e *Entry)
+
+	// Purge removes all entries for which match returns true and
+	// returns the number of removed entries.
+	Purge(match func(e *Entry) bool) int
+
+	// Stats returns the number and the size of the entries.
+	Stats() Stats
+}
+
+// Key returns the key of the request for a route to service. parts
+// contains the components of the request which distinguish the
+// entries: 'host', 'path', 'query' and 'header:<name>'.
+func Key(r *http.Request, service string, parts []string) string {
+	var b strings.Builder
+	b.WriteString(service)
+	for _, p := range parts {
+		b.WriteByte(' ')
+		switch {
+		case p == "host":
+			b.WriteString(strings.ToLower(r.Host))
+		case p == "path":
+			b.WriteString(r.URL.Path)
+		case p == "query":
+			b.WriteString("?" + r.URL.Query().Encode())
+		case strings.HasPrefix(p, "header:"):
+			b.WriteString(strconv.Quote(r.Header.Get(p[len("header:"):])))
+		}
+	}
+	return b.String()
+}
+
+// Lookup returns true if the response to r may be served from
+// the cache and store returns true if it may be stored. Only GET
+// requests without credentials are cached.
+func Lookup(r *http.Request) (lookup, store bool) {
+	if r.Method != "GET" || r.Header.Get("Authorization") != "" {
+		return false, false
+	}
+	cc := directives(r.Header)
+	if _, ok := cc["no-store"]; ok {
+		return false, false
+	}
+	if _, ok := cc["no-cache"]; ok || r.Header.Get("Pragma") == "no-cache" {
+		return false, true
+	}
+	return true, true
+}
+
+// TTL returns the lifetime of a response in a shared cache which
+// is at most max. It returns zero if the response must not be stored.
+func TTL(status int, h http.Header, max time.Duration) time.Duration {
+	if status != http.StatusOK || h.Get("Set-Cookie") != "" {
+		return 0
+	}
+	for _, v := range h.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" && !strings.EqualFold(name, "Accept-Encoding") {
+				return 0
+			}
+		}
+	}
+
+	cc := directives(h)
+	for _, d := range []string{"no-store", "no-cache", "private"} {
+		if _, ok := cc[d]; ok {
+			return 0
+		}
+	}
+	age, ok := cc["s-maxage"]
+	if !ok {
+		age, ok = cc["max-age"]
+	}
+	if ok {
+		n, err := strconv.Atoi(age)
+		if err != nil || n <= 0 {
+			return 0
+		}
+		if d := time.Duration(n) * time.Second; d < max {
+			return d
+		}
+	}
+	return max
+}
+
+// directives returns the directives of the Cache-Control header.
+func directives(h http.Header) map[string]string {
+	m := map[string]string{}
+	for _, v := range h.Values("Cache-Control") {
+		for _, d := range strings.Split(v, ",") {
+			d = strings.TrimSpace(d)
+			if d == "" {
+				continue
+			}
+			p := strings.SplitN(d, "=", 2)
+			name := strings.ToLower(p[0])
+			if len(p) == 2 {
+				m[name] = strings.Trim(p[1], `"`)
+			} else {
+				m[name] = ""
+			}
+		}
+	}
+	return m
+}
@@ -0,0 +1,144 @@
+package cache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestKey(t *testing.T) {
+	r := httptest.NewRequest("GET", "http://Example.com/foo?b=2&a=1", nil)
+	r.Header.Set("Accept-Language", "de")
+
+	tests := []struct {
+		parts []string
+		key   string
+	}{
+		{nil, "svc"},
+		{[]string{"host", "path", "query"}, "svc example.com /foo ?a=1&b=2"},
+		{[]string{"path", "header:Accept-Language"}, `svc /foo "de"`},
+	}
+	for _, tt := range tests {
+		if got, want := Key(r, "svc", tt.parts), tt.key; got != want {
+			t.Errorf("%v: got %q want %q", tt.parts, got, want)
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	tests := []struct {
+		method       string
+		header       http.Header
+		lookup, save bool
+	}{
+		{"GET", nil, true, true},
+		{"HEAD", nil, false, false},
+		{"POST", nil, false, false},
+		{"GET", http.Header{"Authorization": {"Basic Zm9vOmJhcg=="}}, false, false},
+		{"GET", http.Header{"Cache-Control": {"no-cache"}}, false, true},
+		{"GET", http.Header{"Pragma": {"no-cache"}}, false, true},
+		{"GET", http.Header{"Cache-Control": {"max-age=0, no-store"}}, false, false},
+	}
+	for i, tt := range tests {
+		r := httptest.NewRequest(tt.method, "/", nil)
+		for k, v := range tt.header {
+			r.Header[k] = v
+		}
+		lookup, save := Lookup(r)
+		if lookup != tt.lookup || save != tt.save {
+			t.Errorf("%d: got %v, %v want %v, %v", i, lookup, save, tt.lookup, tt.save)
+		}
+	}
+}
+
+func TestTTL(t *testing.T) {
+	tests := []struct {
+		status int
+		header http.Header
+		ttl    time.Duration
+	}{
+		{200, nil, time.Minute},
+		{200, http.Header{"Cache-Control": {"public, max-age=10"}}, 10 * time.Second},
+		{200, http.Header{"Cache-Control": {"max-age=10, s-maxage=20"}}, 20 * time.Second},
+		{200, http.Header{"Cache-Control": {"max-age=3600"}}, time.Minute},
+		{200, http.Header{"Cache-Control": {"max-age=0"}}, 0},
+		{200, http.Header{"Cache-Control": {"max-age=abc"}}, 0},
+		{200, http.Header{"Cache-Control": {"no-store"}}, 0},
+		{200, http.Header{"Cache-Control": {"No-Cache"}}, 0},
+		{200, http.Header{"Cache-Control": {`private="Set-Cookie"`}}, 0},
+		{200, http.Header{"Set-Cookie": {"a=b"}}, 0},
+		{200, http.Header{"Vary": {"Accept-Encoding"}}, time.Minute},
+		{200, http.Header{"Vary": {"Accept-Encoding, Cookie"}}, 0},
+		{404, nil, 0},
+		{500, nil, 0},
+	}
+	for i, tt := range tests {
+		if got, want := TTL(tt.status, tt.header, time.Minute), tt.ttl; got != want {
+			t.Errorf("%d: got %v want %v", i, got, want)
+		}
+	}
+}
+
+// testStore tests the common behavior of the stores. The store must
+// have room for three entries with a 100 byte body.
+func testStore(t *testing.T, s Store) {
+	t.Helper()
+	entry := func(svc string) *Entry {
+		return &Entry{
+			Service: svc,
+			Path:    "/",
+			Status:  200,
+			Header:  http.Header{"Content-Type": {"text/plain"}},
+			Body:    make([]byte, 100),
+			Expires: time.Now().Add(time.Hour),
+		}
+	}
+
+	if e := s.Get("a"); e != nil {
+		t.Fatalf("got %v want nil", e)
+	}
+	s.Set("a", entry("a"))
+	s.Set("b", entry("b"))
+	if e := s.Get("a"); e == nil || e.Service != "a" || len(e.Body) != 100 || e.Header.Get("Content-Type") != "text/plain" {
+		t.Fatalf("got %v want entry a", e)
+	}
+	if got := s.Stats().Entries; got != 2 {
+		t.Fatalf("got %d entries want 2", got)
+	}
+
+	// replacing an entry does not change the number of entries
+	s.Set("b", entry("b"))
+	if got := s.Stats().Entries; got != 2 {
+		t.Fatalf("got %d entries want 2", got)
+	}
+
+	// expired entries are not returned
+	e := entry("c")
+	e.Expires = time.Now().Add(-time.Second)
+	s.Set("a", e)
+	if e := s.Get("a"); e != nil {
+		t.Fatalf("got %v want nil", e)
+	}
+
+	// entries larger than the store are not stored
+	e = entry("d")
+	e.Body = make([]byte, 1<<20)
+	s.Set("d", e)
+	if e := s.Get("d"); e != nil {
+		t.Fatal("got entry want nil")
+	}
+
+	s.Set("a", entry("a"))
+	s.Set("c", entry("c"))
+	if got := s.Purge(func(e *Entry) bool { return e.Service == "c" }); got != 1 {
+		t.Fatalf("got %d purged entries want 1", got)
+	}
+	if e := s.Get("c"); e != nil {
+		t.Fatalf("got %v want nil", e)
+	}
+	s.Purge(func(*Entry) bool { return true })
+	if got := s.Stats(); got != (Stats{}) {
+		t.Fatalf("got %v want empty store", got)
+	}
+}
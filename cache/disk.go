@@ -0,0 +1,162 @@
+package cache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DiskStore keeps the entries in files in a directory so that they
+// survive a restart. Expired entries are removed when the size of the
+// entries exceeds MaxSize. New entries are not stored if there is
+// still not enough space.
+type DiskStore struct {
+	dir     string
+	maxSize int64
+
+	mu      sync.Mutex
+	size    int64
+	entries int
+}
+
+// NewDiskStore returns a store which keeps up to maxSize bytes of
+// entries in dir. The directory is created if it does not exist.
+func NewDiskStore(dir string, maxSize int64) (*DiskStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	s := &DiskStore{dir: dir, maxSize: maxSize}
+	s.scan(func(string, *Entry) bool { return false })
+	return s, nil
+}
+
+func (s *DiskStore) Get(key string) *Entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, err := s.read(s.file(key))
+	if err != nil {
+		return nil
+	}
+	if e.Expired(time.Now()) {
+		s.remove(s.file(key))
+		return nil
+	}
+	return e
+}
+
+func (s *DiskStore) Set(key string, e *Entry) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(e); err != nil {
+		log.Printf("[ERROR] cache: Cannot encode entry. %s", err)
+		return
+	}
+	n := int64(buf.Len())
+	if n > s.maxSize {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	name := s.file(key)
+	s.remove(name)
+	if s.size+n > s.maxSize {
+		now := time.Now()
+		s.scan(func(_ string, e *Entry) bool { return e.Expired(now) })
+		if s.size+n > s.maxSize {
+			return
+		}
+	}
+
+	tmp := name + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf.Bytes(), 0600); err != nil {
+		log.Printf("[ERROR] cache: Cannot write entry. %s", err)
+		os.Remove(tmp)
+		return
+	}
+	if err := os.Rename(tmp, name); err != nil {
+		log.Printf("[ERROR] cache: Cannot write entry. %s", err)
+		os.Remove(tmp)
+		return
+	}
+	s.size += n
+	s.entries++
+}
+
+func (s *DiskStore) Purge(match func(e *Entry) bool) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := s.entries
+	s.scan(func(_ string, e *Entry) bool { return match(e) })
+	return n - s.entries
+}
+
+func (s *DiskStore) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return Stats{Entries: s.entries, Size: s.size}
+}
+
+// file returns the name of the file for the key.
+func (s *DiskStore) file(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:])+".entry")
+}
+
+func (s *DiskStore) read(name string) (*Entry, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	e := &Entry{}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(e); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// remove removes the file of an entry. The caller must hold the lock.
+func (s *DiskStore) remove(name string) {
+	fi, err := os.Stat(name)
+	if err != nil {
+		return
+	}
+	if err := os.Remove(name); err != nil {
+		log.Printf("[ERROR] cache: Cannot remove entry. %s", err)
+		return
+	}
+	s.size -= fi.Size()
+	s.entries--
+}
+
+// scan removes the entries for which del returns true and entries
+// which cannot be read and recomputes the size of the store. The
+// caller must hold the lock.
+func (s *DiskStore) scan(del func(name string, e *Entry) bool) {
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		log.Printf("[ERROR] cache: Cannot read %s. %s", s.dir, err)
+		return
+	}
+	s.size, s.entries = 0, 0
+	for _, fi := range files {
+		if fi.IsDir() || !strings.HasSuffix(fi.Name(), ".entry") {
+			continue
+		}
+		name := filepath.Join(s.dir, fi.Name())
+		e, err := s.read(name)
+		if err != nil || del(name, e) {
+			os.Remove(name)
+			continue
+		}
+		s.size += fi.Size()
+		s.entries++
+	}
+}
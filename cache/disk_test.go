@@ -0,0 +1,35 @@
+package cache
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestDiskStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fabio-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := NewDiskStore(dir, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStore(t, s)
+
+	// entries survive a restart
+	s.Set("a", &Entry{Service: "a", Body: []byte("foo"), Expires: time.Now().Add(time.Hour)})
+	s, err = NewDiskStore(dir, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Stats().Entries; got != 1 {
+		t.Fatalf("got %d entries want 1", got)
+	}
+	if e := s.Get("a"); e == nil || string(e.Body) != "foo" {
+		t.Fatalf("got %v want entry a", e)
+	}
+}
@@ -0,0 +1,93 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps the entries in memory. The least recently used
+// entries are removed when the size of the entries exceeds MaxSize.
+type MemoryStore struct {
+	maxSize int64
+
+	mu      sync.Mutex
+	size    int64
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+type memoryItem struct {
+	key string
+	e   *Entry
+}
+
+// NewMemoryStore returns a store which keeps up to
+// maxSize bytes of entries in memory.
+func NewMemoryStore(maxSize int64) *MemoryStore {
+	return &MemoryStore{
+		maxSize: maxSize,
+		entries: map[string]*list.Element{},
+		lru:     list.New(),
+	}
+}
+
+func (s *MemoryStore) Get(key string) *Entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	el, ok := s.entries[key]
+	if !ok {
+		return nil
+	}
+	e := el.Value.(*memoryItem).e
+	if e.Expired(time.Now()) {
+		s.remove(el)
+		return nil
+	}
+	s.lru.MoveToFront(el)
+	return e
+}
+
+func (s *MemoryStore) Set(key string, e *Entry) {
+	n := e.Size()
+	if n > s.maxSize {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if el, ok := s.entries[key]; ok {
+		s.remove(el)
+	}
+	s.entries[key] = s.lru.PushFront(&memoryItem{key, e})
+	s.size += n
+	for s.size > s.maxSize {
+		s.remove(s.lru.Back())
+	}
+}
+
+func (s *MemoryStore) Purge(match func(e *Entry) bool) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var n int
+	for _, el := range s.entries {
+		if match(el.Value.(*memoryItem).e) {
+			s.remove(el)
+			n++
+		}
+	}
+	return n
+}
+
+func (s *MemoryStore) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return Stats{Entries: len(s.entries), Size: s.size}
+}
+
+// remove removes the entry. The caller must hold the lock.
+func (s *MemoryStore) remove(el *list.Element) {
+	item := s.lru.Remove(el).(*memoryItem)
+	delete(s.entries, item.key)
+	s.size -= item.e.Size()
+}
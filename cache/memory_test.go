@@ -0,0 +1,30 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore(400))
+}
+
+func TestMemoryStoreEvictsLeastRecentlyUsed(t *testing.T) {
+	s := NewMemoryStore(30)
+	set := func(key string) {
+		s.Set(key, &Entry{Body: make([]byte, 10), Expires: time.Now().Add(time.Hour)})
+	}
+	set("a")
+	set("b")
+	set("c")
+	s.Get("a")
+	set("d")
+	for key, want := range map[string]bool{"a": true, "b": false, "c": true, "d": true} {
+		if got := s.Get(key) != nil; got != want {
+			t.Errorf("%s: got %v want %v", key, got, want)
+		}
+	}
+	if got, want := s.Stats(), (Stats{Entries: 3, Size: 30}); got != want {
+		t.Fatalf("got %v want %v", got, want)
+	}
+}
@@ -0,0 +1,246 @@
+// Package capture records the metadata and optional body samples of
+// proxied requests for debugging.
+//
+// Only the requests of routes with the capture option are recorded.
+// The number of captured requests is capped per second and the values
+// of sensitive headers, cookies, query parameters and body fields are
+// redacted before they are written.
+//
+// The capture file is written either as a JSON document per line or
+// as a HAR (HTTP Archive) file which can be opened by the developer
+// tools of most browsers.
+package capture
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/fabiolb/fabio/config"
+)
+
+// Exchange is a captured request and its response.
+type Exchange struct {
+	Start    time.Time     `json:"start"`
+	Duration time.Duration `json:"-"`
+	Service  string        `json:"service"`
+	Upstream string        `json:"upstream"`
+	Request  Request       `json:"request"`
+	Response Response      `json:"response"`
+
+	// DurationMS is the duration of the exchange in milliseconds.
+	// It is set when the exchange is written.
+	DurationMS float64 `json:"durationMs"`
+}
+
+// Request contains the metadata of a captured request.
+type Request struct {
+	Method     string      `json:"method"`
+	URL        string      `json:"url"`
+	Proto      string      `json:"proto"`
+	RemoteAddr string      `json:"remoteAddr"`
+	Header     http.Header `json:"header"`
+	Body       *Body       `json:"body,omitempty"`
+}
+
+// Response contains the metadata of a captured response.
+type Response struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header"`
+	Body   *Body       `json:"body,omitempty"`
+}
+
+// Body describes a request or response body.
+type Body struct {
+	// Size is the number of bytes of the complete body.
+	Size int64 `json:"size"`
+
+	// Sample contains the first bytes of the body. Binary
+	// samples are base64 encoded.
+	Sample    string `json:"sample,omitempty"`
+	Encoding  string `json:"encoding,omitempty"`
+	Truncated bool   `json:"truncated,omitempty"`
+}
+
+// Sample is an io.Writer which counts all bytes and keeps
+// up to a maximum number of bytes.
+type Sample struct {
+	max  int
+	buf  []byte
+	size int64
+}
+
+// NewSample creates a sample which keeps up to max bytes.
+func NewSample(max int) *Sample {
+	return &Sample{max: max}
+}
+
+func (s *Sample) Write(p []byte) (int, error) {
+	s.size += int64(len(p))
+	if n := s.max - len(s.buf); n > 0 {
+		if n > len(p) {
+			n = len(p)
+		}
+		s.buf = append(s.buf, p[:n]...)
+	}
+	return len(p), nil
+}
+
+// Body returns the description of the sampled body.
+func (s *Sample) Body() *Body {
+	b := &Body{Size: s.size, Truncated: int64(len(s.buf)) < s.size && s.max > 0}
+	if len(s.buf) == 0 {
+		return b
+	}
+	if utf8.Valid(s.buf) {
+		b.Sample = string(s.buf)
+	} else {
+		b.Sample = base64.StdEncoding.EncodeToString(s.buf)
+		b.Encoding = "base64"
+	}
+	return b
+}
+
+// TeeBody returns a body which writes everything that is
+// read from body to w.
+func TeeBody(body io.ReadCloser, w io.Writer) io.ReadCloser {
+	return &teeBody{body, w}
+}
+
+type teeBody struct {
+	io.ReadCloser
+	w io.Writer
+}
+
+func (t *teeBody) Read(p []byte) (int, error) {
+	n, err := t.ReadCloser.Read(p)
+	if n > 0 {
+		t.w.Write(p[:n])
+	}
+	return n, err
+}
+
+// Recorder writes the captured exchanges to a file.
+type Recorder struct {
+	maxBody  int
+	rate     int
+	redactor *redactor
+
+	mu      sync.Mutex
+	window  int64
+	count   int
+	dropped int
+	w       io.WriteCloser
+	har     *harWriter
+}
+
+// New creates a recorder which writes to the capture file
+// configured in cfg.
+func New(cfg config.Capture) (*Recorder, error) {
+	r := &Recorder{
+		maxBody:  cfg.MaxBody,
+		rate:     cfg.Rate,
+		redactor: newRedactor(cfg.Redact),
+	}
+
+	switch cfg.Format {
+	case "json", "":
+		f, err := os.OpenFile(cfg.Target, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+		if err != nil {
+			return nil, err
+		}
+		r.w = f
+	case "har":
+		r.har = newHARWriter(cfg.Target)
+	default:
+		return nil, fmt.Errorf("capture: invalid format %q", cfg.Format)
+	}
+	return r, nil
+}
+
+// Allow returns true if another request can be captured
+// within the rate limit.
+func (r *Recorder) Allow() bool {
+	if r.rate <= 0 {
+		return true
+	}
+	now := time.Now().Unix()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if now != r.window {
+		if r.dropped > 0 {
+			log.Printf("[INFO] capture: Skipped %d requests because of the rate limit", r.dropped)
+		}
+		r.window, r.count, r.dropped = now, 0, 0
+	}
+	if r.count >= r.rate {
+		r.dropped++
+		return false
+	}
+	r.count++
+	return true
+}
+
+// SampleSize returns the number of body bytes which are
+// captured for a route which requests n bytes.
+func (r *Recorder) SampleSize(n int) int {
+	if n > r.maxBody {
+		return r.maxBody
+	}
+	if n < 0 {
+		return 0
+	}
+	return n
+}
+
+// Record redacts and writes the exchange.
+func (r *Recorder) Record(e *Exchange) {
+	r.redactor.exchange(e)
+	e.DurationMS = float64(e.Duration) / float64(time.Millisecond)
+
+	if r.har != nil {
+		r.har.add(e)
+		return
+	}
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		log.Printf("[ERROR] capture: Cannot encode request. %s", err)
+		return
+	}
+	b = append(b, '\n')
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, err := r.w.Write(b); err != nil {
+		log.Printf("[ERROR] capture: Cannot write request. %s", err)
+	}
+}
+
+// Close flushes the pending exchanges and closes the capture file.
+func (r *Recorder) Close() error {
+	if r.har != nil {
+		return r.har.close()
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.w.Close()
+}
+
+// contentType returns the media type of the header without parameters.
+func contentType(h http.Header) string {
+	ct := h.Get("Content-Type")
+	if i := strings.Index(ct, ";"); i >= 0 {
+		ct = ct[:i]
+	}
+	return strings.ToLower(strings.TrimSpace(ct))
+}
@@ -0,0 +1,251 @@
+package capture
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// harMaxEntries is the number of the most recent
+// exchanges which are kept in the HAR file.
+const harMaxEntries = 1000
+
+// harFlushInterval is the interval in which the
+// HAR file is rewritten when there are new entries.
+const harFlushInterval = time.Second
+
+// harWriter keeps the most recent exchanges and periodically
+// rewrites the HAR file since a HAR file is a single JSON
+// document which cannot be appended to.
+type harWriter struct {
+	path string
+
+	mu      sync.Mutex
+	entries []harEntry
+	dirty   bool
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+func newHARWriter(path string) *harWriter {
+	w := &harWriter{path: path, done: make(chan struct{}), stopped: make(chan struct{})}
+	go w.run()
+	return w
+}
+
+func (w *harWriter) add(e *Exchange) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.entries = append(w.entries, newHAREntry(e))
+	if n := len(w.entries) - harMaxEntries; n > 0 {
+		w.entries = append(w.entries[:0], w.entries[n:]...)
+	}
+	w.dirty = true
+}
+
+func (w *harWriter) run() {
+	defer close(w.stopped)
+	t := time.NewTicker(harFlushInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			if err := w.flush(); err != nil {
+				log.Printf("[ERROR] capture: Cannot write %s. %s", w.path, err)
+			}
+		case <-w.done:
+			return
+		}
+	}
+}
+
+func (w *harWriter) close() error {
+	close(w.done)
+	<-w.stopped
+	return w.flush()
+}
+
+// flush atomically replaces the HAR file if there are new entries.
+func (w *harWriter) flush() error {
+	w.mu.Lock()
+	if !w.dirty {
+		w.mu.Unlock()
+		return nil
+	}
+	w.dirty = false
+	doc := harDocument{Log: harLog{
+		Version: "1.2",
+		Creator: harCreator{Name: "fabio", Version: "1.0"},
+		Entries: append([]harEntry(nil), w.entries...),
+	}}
+	w.mu.Unlock()
+
+	b, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(w.path), filepath.Base(w.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), w.path)
+}
+
+// The types below describe the HAR 1.2 format.
+// See http://www.softwareishard.com/blog/har-12-spec/
+
+type harDocument struct {
+	Log harLog `json:"log"`
+}
+
+type harLog struct {
+	Version string     `json:"version"`
+	Creator harCreator `json:"creator"`
+	Entries []harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+	ServerIPAddress string      `json:"serverIPAddress,omitempty"`
+	Comment         string      `json:"comment,omitempty"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type harContent struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+type harTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+func newHAREntry(e *Exchange) harEntry {
+	h := harEntry{
+		StartedDateTime: e.Start.Format(time.RFC3339Nano),
+		Time:            e.DurationMS,
+		Timings:         harTimings{Wait: e.DurationMS},
+		Comment:         strings.TrimSpace(e.Service + " " + e.Upstream),
+		Request: harRequest{
+			Method:      e.Request.Method,
+			URL:         e.Request.URL,
+			HTTPVersion: e.Request.Proto,
+			Cookies:     []harNameValue{},
+			Headers:     harHeaders(e.Request.Header),
+			QueryString: []harNameValue{},
+			HeadersSize: -1,
+			BodySize:    -1,
+		},
+		Response: harResponse{
+			Status:      e.Response.Status,
+			StatusText:  http.StatusText(e.Response.Status),
+			HTTPVersion: e.Request.Proto,
+			Cookies:     []harNameValue{},
+			Headers:     harHeaders(e.Response.Header),
+			Content:     harContent{Size: -1, MimeType: e.Response.Header.Get("Content-Type")},
+			RedirectURL: e.Response.Header.Get("Location"),
+			HeadersSize: -1,
+			BodySize:    -1,
+		},
+	}
+
+	if u, err := url.Parse(e.Request.URL); err == nil {
+		h.Request.QueryString = harValues(u.Query())
+	}
+	if b := e.Request.Body; b != nil {
+		h.Request.BodySize = b.Size
+		if b.Sample != "" && b.Encoding == "" {
+			h.Request.PostData = &harPostData{MimeType: e.Request.Header.Get("Content-Type"), Text: b.Sample}
+		}
+	}
+	if b := e.Response.Body; b != nil {
+		h.Response.BodySize = b.Size
+		h.Response.Content.Size = b.Size
+		h.Response.Content.Text = b.Sample
+		h.Response.Content.Encoding = b.Encoding
+	}
+	return h
+}
+
+func harHeaders(h http.Header) []harNameValue {
+	return harValues(url.Values(h))
+}
+
+func harValues(m map[string][]string) []harNameValue {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	nv := []harNameValue{}
+	for _, k := range keys {
+		for _, v := range m[k] {
+			nv = append(nv, harNameValue{Name: k, Value: v})
+		}
+	}
+	return nv
+}
@@ -0,0 +1,150 @@
+package capture
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/fabiolb/fabio/redact"
+)
+
+// redacted replaces the values of sensitive fields.
+const redacted = "[REDACTED]"
+
+// redactor removes the values of headers, cookies, query
+// parameters and body fields with one of the configured
+// names. Names are matched case-insensitive.
+type redactor struct {
+	names map[string]bool
+
+	// jsonField matches the configured fields with string, number
+	// or literal values in JSON documents. A regular expression is
+	// used since body samples are usually truncated.
+	jsonField *regexp.Regexp
+}
+
+func newRedactor(names []string) *redactor {
+	r := &redactor{names: map[string]bool{}}
+	var quoted []string
+	for _, n := range names {
+		n = strings.ToLower(strings.TrimSpace(n))
+		if n == "" || r.names[n] {
+			continue
+		}
+		r.names[n] = true
+		quoted = append(quoted, regexp.QuoteMeta(n))
+	}
+	if len(quoted) > 0 {
+		r.jsonField = regexp.MustCompile(`("(?i:` + strings.Join(quoted, "|") + `)"\s*:\s*)("(?:[^"\\]|\\.)*"?|[^\s,}\]]+)`)
+	}
+	return r
+}
+
+func (r *redactor) match(name string) bool {
+	return r.names[strings.ToLower(name)]
+}
+
+func (r *redactor) exchange(e *Exchange) {
+	// apply the global redaction rules first since
+	// the headers are redacted in place below.
+	e.Request.Header = redact.Default.Header(e.Request.Header)
+	e.Response.Header = redact.Default.Header(e.Response.Header)
+	e.Request.URL = redact.Default.RequestURI(e.Request.URL)
+	e.Upstream = redact.Default.RequestURI(e.Upstream)
+
+	e.Request.URL = r.url(e.Request.URL)
+	r.header(e.Request.Header)
+	r.header(e.Response.Header)
+	r.body(e.Request.Body, contentType(e.Request.Header))
+	r.body(e.Response.Body, contentType(e.Response.Header))
+}
+
+// header redacts the values of the matching headers and
+// the values of the matching cookies.
+func (r *redactor) header(h http.Header) {
+	for k, vals := range h {
+		if r.match(k) {
+			for i := range vals {
+				vals[i] = redacted
+			}
+			continue
+		}
+		switch http.CanonicalHeaderKey(k) {
+		case "Cookie":
+			for i, v := range vals {
+				vals[i] = r.cookies(v, "; ")
+			}
+		case "Set-Cookie":
+			for i, v := range vals {
+				p := strings.SplitN(v, ";", 2)
+				p[0] = r.cookies(p[0], "")
+				vals[i] = strings.Join(p, ";")
+			}
+		}
+	}
+}
+
+// cookies redacts the values of the matching cookies in
+// a list of name=value pairs separated by semicolons.
+func (r *redactor) cookies(s, sep string) string {
+	p := strings.Split(s, ";")
+	for i, c := range p {
+		kv := strings.SplitN(strings.TrimSpace(c), "=", 2)
+		if len(kv) == 2 && r.match(kv[0]) {
+			kv[1] = redacted
+		}
+		p[i] = strings.Join(kv, "=")
+	}
+	return strings.Join(p, sep)
+}
+
+// url removes the password and redacts the matching query parameters.
+func (r *redactor) url(s string) string {
+	u, err := url.Parse(s)
+	if err != nil {
+		return s
+	}
+	if u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), redacted)
+		}
+	}
+	if u.RawQuery != "" {
+		u.RawQuery = r.query(u.RawQuery)
+	}
+	return u.String()
+}
+
+func (r *redactor) query(s string) string {
+	q, err := url.ParseQuery(s)
+	if err != nil {
+		return s
+	}
+	changed := false
+	for k, vals := range q {
+		if r.match(k) {
+			for i := range vals {
+				vals[i] = redacted
+			}
+			changed = true
+		}
+	}
+	if !changed {
+		return s
+	}
+	return q.Encode()
+}
+
+// body redacts the matching fields of JSON and form encoded body samples.
+func (r *redactor) body(b *Body, contentType string) {
+	if b == nil || b.Sample == "" || b.Encoding != "" {
+		return
+	}
+	switch {
+	case contentType == "application/x-www-form-urlencoded":
+		b.Sample = r.query(b.Sample)
+	case r.jsonField != nil && (contentType == "application/json" || strings.HasSuffix(contentType, "+json")):
+		b.Sample = r.jsonField.ReplaceAllString(b.Sample, `${1}"`+redacted+`"`)
+	}
+}
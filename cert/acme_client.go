@@ -0,0 +1,451 @@
+package cert
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// acmePollInterval is the time between two status requests
+// while waiting for an authorization or an order.
+var acmePollInterval = time.Second
+
+// acmeClient is a minimal ACME (RFC 8555) client which obtains
+// certificates for single domains with the http-01 and tls-alpn-01
+// challenges.
+type acmeClient struct {
+	http   *http.Client
+	dirURL string
+	key    *ecdsa.PrivateKey
+
+	dir struct {
+		NewNonce   string `json:"newNonce"`
+		NewAccount string `json:"newAccount"`
+		NewOrder   string `json:"newOrder"`
+	}
+
+	mu     sync.Mutex
+	kid    string
+	nonces []string
+}
+
+// acmeProblem is an ACME error document.
+type acmeProblem struct {
+	Status int    `json:"status"`
+	Type   string `json:"type"`
+	Detail string `json:"detail"`
+}
+
+func (p *acmeProblem) Error() string {
+	return fmt.Sprintf("acme: %d %s: %s", p.Status, p.Type, p.Detail)
+}
+
+type acmeOrder struct {
+	Status         string       `json:"status"`
+	Authorizations []string     `json:"authorizations"`
+	Finalize       string       `json:"finalize"`
+	Certificate    string       `json:"certificate"`
+	Error          *acmeProblem `json:"error"`
+}
+
+type acmeChallenge struct {
+	Type   string       `json:"type"`
+	URL    string       `json:"url"`
+	Token  string       `json:"token"`
+	Status string       `json:"status"`
+	Error  *acmeProblem `json:"error"`
+}
+
+type acmeAuthorization struct {
+	Status     string `json:"status"`
+	Identifier struct {
+		Value string `json:"value"`
+	} `json:"identifier"`
+	Challenges []acmeChallenge `json:"challenges"`
+}
+
+// acmeResponse is the response of a signed request.
+type acmeResponse struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+func newACMEClient(dirURL string, key *ecdsa.PrivateKey) *acmeClient {
+	return &acmeClient{
+		http:   &http.Client{Timeout: 30 * time.Second},
+		dirURL: dirURL,
+		key:    key,
+	}
+}
+
+// register fetches the directory and creates the account or looks up
+// the existing account for the key.
+func (c *acmeClient) register(ctx context.Context, email string) error {
+	req, err := http.NewRequest("GET", c.dirURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.http.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("acme: cannot fetch directory %s. %s", c.dirURL, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&c.dir); err != nil {
+		return fmt.Errorf("acme: invalid directory %s. %s", c.dirURL, err)
+	}
+
+	acct := map[string]interface{}{"termsOfServiceAgreed": true}
+	if email != "" {
+		acct["contact"] = []string{"mailto:" + email}
+	}
+	r, err := c.post(ctx, c.dir.NewAccount, acct)
+	if err != nil {
+		return err
+	}
+	kid := r.header.Get("Location")
+	if kid == "" {
+		return errors.New("acme: missing account URL")
+	}
+	c.mu.Lock()
+	c.kid = kid
+	c.mu.Unlock()
+	return nil
+}
+
+// obtain orders a certificate for the domain and returns the PEM
+// encoded certificate chain and private key. The solve function
+// provisions the response for a challenge and returns a function
+// which removes it.
+func (c *acmeClient) obtain(ctx context.Context, domain string, challenges []string, solve func(domain string, ch acmeChallenge, keyAuth string) (func(), error)) (certPEM, keyPEM []byte, err error) {
+	newOrder := map[string]interface{}{
+		"identifiers": []map[string]string{{"type": "dns", "value": domain}},
+	}
+	r, err := c.post(ctx, c.dir.NewOrder, newOrder)
+	if err != nil {
+		return nil, nil, err
+	}
+	orderURL := r.header.Get("Location")
+	var order acmeOrder
+	if err := json.Unmarshal(r.body, &order); err != nil {
+		return nil, nil, err
+	}
+
+	for _, u := range order.Authorizations {
+		if err := c.authorize(ctx, u, challenges, solve); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// create the key and request the certificate
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: domain},
+		DNSNames: []string{domain},
+	}, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	r, err = c.post(ctx, order.Finalize, map[string]string{"csr": b64(csr)})
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := json.Unmarshal(r.body, &order); err != nil {
+		return nil, nil, err
+	}
+
+	// wait until the certificate has been issued
+	for order.Status != "valid" {
+		switch order.Status {
+		case "pending", "ready", "processing":
+		default:
+			if order.Error != nil {
+				return nil, nil, order.Error
+			}
+			return nil, nil, fmt.Errorf("acme: order for %s is %s", domain, order.Status)
+		}
+		if err := sleep(ctx, retryAfter(r.header)); err != nil {
+			return nil, nil, err
+		}
+		if r, err = c.post(ctx, orderURL, nil); err != nil {
+			return nil, nil, err
+		}
+		if err := json.Unmarshal(r.body, &order); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if r, err = c.post(ctx, order.Certificate, nil); err != nil {
+		return nil, nil, err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return r.body, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), nil
+}
+
+// authorize solves one of the challenges of the authorization
+// and waits until it is valid.
+func (c *acmeClient) authorize(ctx context.Context, authzURL string, challenges []string, solve func(domain string, ch acmeChallenge, keyAuth string) (func(), error)) error {
+	var authz acmeAuthorization
+	r, err := c.post(ctx, authzURL, nil)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(r.body, &authz); err != nil {
+		return err
+	}
+	if authz.Status == "valid" {
+		return nil
+	}
+
+	ch, ok := pickChallenge(authz.Challenges, challenges)
+	if !ok {
+		return fmt.Errorf("acme: no supported challenge for %s", authz.Identifier.Value)
+	}
+	cleanup, err := solve(authz.Identifier.Value, ch, c.keyAuth(ch.Token))
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	if _, err := c.post(ctx, ch.URL, struct{}{}); err != nil {
+		return err
+	}
+
+	for {
+		if err := sleep(ctx, retryAfter(r.header)); err != nil {
+			return err
+		}
+		if r, err = c.post(ctx, authzURL, nil); err != nil {
+			return err
+		}
+		if err := json.Unmarshal(r.body, &authz); err != nil {
+			return err
+		}
+		switch authz.Status {
+		case "valid":
+			return nil
+		case "pending", "processing":
+			continue
+		}
+		for _, x := range authz.Challenges {
+			if x.URL == ch.URL && x.Error != nil {
+				return x.Error
+			}
+		}
+		return fmt.Errorf("acme: authorization for %s is %s", authz.Identifier.Value, authz.Status)
+	}
+}
+
+// pickChallenge returns the first challenge in the order of
+// the preferred challenge types.
+func pickChallenge(offered []acmeChallenge, preferred []string) (acmeChallenge, bool) {
+	for _, typ := range preferred {
+		for _, ch := range offered {
+			if ch.Type == typ {
+				return ch, true
+			}
+		}
+	}
+	return acmeChallenge{}, false
+}
+
+// keyAuth returns the key authorization for the challenge token.
+func (c *acmeClient) keyAuth(token string) string {
+	sum := sha256.Sum256([]byte(jwkJSON(&c.key.PublicKey)))
+	return token + "." + b64(sum[:])
+}
+
+// post sends a signed request. A nil payload sends a POST-as-GET
+// request. Requests which are rejected because of an invalid
+// nonce are retried once.
+func (c *acmeClient) post(ctx context.Context, url string, payload interface{}) (*acmeResponse, error) {
+	for retry := true; ; retry = false {
+		r, err := c.postOnce(ctx, url, payload)
+		if p, ok := err.(*acmeProblem); ok && retry && p.Type == "urn:ietf:params:acme:error:badNonce" {
+			continue
+		}
+		return r, err
+	}
+}
+
+func (c *acmeClient) postOnce(ctx context.Context, url string, payload interface{}) (*acmeResponse, error) {
+	nonce, err := c.nonce(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := c.sign(url, nonce, payload)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/jose+json")
+	resp, err := c.http.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	c.addNonce(resp.Header.Get("Replay-Nonce"))
+
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, MaxSize))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		p := &acmeProblem{Status: resp.StatusCode}
+		if json.Unmarshal(b, p) != nil || p.Type == "" {
+			p.Detail = string(b)
+		}
+		return nil, p
+	}
+	return &acmeResponse{status: resp.StatusCode, header: resp.Header, body: b}, nil
+}
+
+// sign returns the JWS of the payload in the flattened JSON
+// serialization. The account key is identified by the account
+// URL once the account has been registered.
+func (c *acmeClient) sign(url, nonce string, payload interface{}) ([]byte, error) {
+	c.mu.Lock()
+	kid := c.kid
+	c.mu.Unlock()
+
+	hdr := map[string]interface{}{"alg": "ES256", "nonce": nonce, "url": url}
+	if kid != "" {
+		hdr["kid"] = kid
+	} else {
+		hdr["jwk"] = json.RawMessage(jwkJSON(&c.key.PublicKey))
+	}
+	protected, err := json.Marshal(hdr)
+	if err != nil {
+		return nil, err
+	}
+
+	var data string
+	if payload != nil {
+		b, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		data = b64(b)
+	}
+
+	input := b64(protected) + "." + data
+	sum := sha256.Sum256([]byte(input))
+	r, s, err := ecdsa.Sign(rand.Reader, c.key, sum[:])
+	if err != nil {
+		return nil, err
+	}
+	sig := append(pad(r.Bytes(), 32), pad(s.Bytes(), 32)...)
+
+	return json.Marshal(map[string]string{
+		"protected": b64(protected),
+		"payload":   data,
+		"signature": b64(sig),
+	})
+}
+
+// nonce returns an unused nonce from a previous response or
+// fetches a new one.
+func (c *acmeClient) nonce(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	if n := len(c.nonces); n > 0 {
+		nonce := c.nonces[n-1]
+		c.nonces = c.nonces[:n-1]
+		c.mu.Unlock()
+		return nonce, nil
+	}
+	c.mu.Unlock()
+
+	req, err := http.NewRequest("HEAD", c.dir.NewNonce, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.http.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	nonce := resp.Header.Get("Replay-Nonce")
+	if nonce == "" {
+		return "", errors.New("acme: missing nonce")
+	}
+	return nonce, nil
+}
+
+func (c *acmeClient) addNonce(nonce string) {
+	if nonce == "" {
+		return
+	}
+	c.mu.Lock()
+	c.nonces = append(c.nonces, nonce)
+	c.mu.Unlock()
+}
+
+// jwkJSON returns the JSON web key of the public key with the
+// members in lexicographic order as required for the thumbprint.
+func jwkJSON(pub *ecdsa.PublicKey) string {
+	x, y := pad(pub.X.Bytes(), 32), pad(pub.Y.Bytes(), 32)
+	return fmt.Sprintf(`{"crv":"P-256","kty":"EC","x":"%s","y":"%s"}`, b64(x), b64(y))
+}
+
+// pad returns b left padded with zeros to n bytes.
+func pad(b []byte, n int) []byte {
+	if len(b) >= n {
+		return b
+	}
+	return append(make([]byte, n-len(b)), b...)
+}
+
+// retryAfter returns the wait time from the Retry-After header
+// which must not be shorter than the poll interval.
+func retryAfter(h http.Header) time.Duration {
+	if n, err := strconv.Atoi(h.Get("Retry-After")); err == nil {
+		if d := time.Duration(n) * time.Second; d > acmePollInterval {
+			return d
+		}
+	}
+	return acmePollInterval
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,582 @@
+package cert
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// LetsEncryptURL is the directory URL of the Let's Encrypt
+// production environment.
+const LetsEncryptURL = "https://acme-v02.api.letsencrypt.org/directory"
+
+// acmeTimeout limits the time for obtaining a single certificate.
+const acmeTimeout = 5 * time.Minute
+
+// acmeRenewInterval is the time between two checks for
+// certificates which need to be renewed.
+var acmeRenewInterval = time.Hour
+
+// acmeBackoffMin and acmeBackoffMax limit the time after which a
+// certificate is requested again once obtaining it has failed. The
+// time doubles with every failure.
+var (
+	acmeBackoffMin = time.Minute
+	acmeBackoffMax = 24 * time.Hour
+)
+
+// ACMESource implements a certificate source which obtains and renews
+// certificates from an ACME certificate authority like Let's Encrypt.
+// The certificates and the account key are stored in a directory or
+// in the consul KV store so that they survive restarts. Certificates
+// for the configured domains are obtained on startup and certificates
+// for names which match a domain pattern on-demand.
+//
+// The http-01 challenge is answered by HTTPChallengeHandler on the
+// HTTP listeners and the tls-alpn-01 challenge by the TLS config of
+// the listeners which use the source.
+type ACMESource struct {
+	// Directory is the directory URL of the certificate authority.
+	Directory string
+
+	// Email is the contact address of the account.
+	Email string
+
+	// Domains are the names the source obtains certificates for.
+	// Patterns like *.example.com allow certificates for all names
+	// of the domain which are obtained on the first request.
+	Domains []string
+
+	// Challenges are the preferred challenge types.
+	Challenges []string
+
+	// StorePath is the directory or consul KV URL for the certificates.
+	StorePath string
+
+	ClientCAPath string
+	CAUpgradeCN  string
+
+	// Re-new certificates this long before they expire.
+	Refresh time.Duration
+
+	once sync.Once
+
+	mu       sync.Mutex
+	store    acmeStore
+	client   *acmeClient
+	certs    map[string]tls.Certificate
+	subs     []chan []tls.Certificate
+	failures map[string]acmeFailure
+
+	// clientMu serializes the creation of the
+	// account key and the registration.
+	clientMu sync.Mutex
+}
+
+// acmeFailure records the failed attempts to obtain a certificate.
+type acmeFailure struct {
+	n     int
+	until time.Time
+	err   error
+}
+
+// ACMEHostPolicy returns true if the ACME sources may obtain a
+// certificate on-demand for the name. Otherwise any client could make
+// fabio request certificates by sending a matching server name. If it
+// is nil no certificates are obtained on-demand.
+var ACMEHostPolicy func(name string) bool
+
+var (
+	acmeSourcesMu sync.Mutex
+	acmeSources   = map[string]*ACMESource{}
+)
+
+// sharedACMESource returns the source for the cert source name so that
+// all listeners which use the same cert source share the certificates.
+func sharedACMESource(name string, s *ACMESource) *ACMESource {
+	acmeSourcesMu.Lock()
+	defer acmeSourcesMu.Unlock()
+	if x := acmeSources[name]; x != nil {
+		return x
+	}
+	acmeSources[name] = s
+	return s
+}
+
+func (s *ACMESource) LoadClientCAs() (*x509.CertPool, error) {
+	if s.ClientCAPath == "" {
+		return nil, nil
+	}
+	return newCertPool(s.ClientCAPath, s.CAUpgradeCN, loadPath)
+}
+
+// Certificates returns a channel with the current certificates
+// for every caller since all listeners share the source.
+func (s *ACMESource) Certificates() chan []tls.Certificate {
+	ch := make(chan []tls.Certificate, 1)
+	s.mu.Lock()
+	s.subs = append(s.subs, ch)
+	s.mu.Unlock()
+
+	s.once.Do(func() { go s.run() })
+	return ch
+}
+
+// Issue obtains a certificate for a name which matches
+// one of the domain patterns and which is allowed by the
+// ACMEHostPolicy.
+func (s *ACMESource) Issue(commonName string) (*tls.Certificate, error) {
+	if !s.allowed(commonName) {
+		return nil, fmt.Errorf("acme: %q does not match any of the domains", commonName)
+	}
+
+	s.mu.Lock()
+	cert, ok := s.certs[commonName]
+	s.mu.Unlock()
+	if ok {
+		return &cert, nil
+	}
+
+	if ACMEHostPolicy == nil || !ACMEHostPolicy(commonName) {
+		return nil, fmt.Errorf("acme: %q is not routed", commonName)
+	}
+
+	if err := s.obtainBackoff(commonName); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	cert = s.certs[commonName]
+	s.mu.Unlock()
+	return &cert, nil
+}
+
+// run loads the stored certificates, obtains the missing ones
+// and renews them before they expire.
+func (s *ACMESource) run() {
+	store, err := newACMEStore(s.StorePath)
+	if err != nil {
+		log.Printf("[ERROR] cert: acme: %s", err)
+		return
+	}
+	pemBlocks, err := store.load()
+	if err != nil {
+		log.Printf("[ERROR] cert: acme: Failed to load certificates. %s", err)
+	}
+	certs, err := loadCertificates(pemBlocks)
+	if err != nil {
+		log.Printf("[ERROR] cert: acme: Failed to load certificates. %s", err)
+	}
+	s.mu.Lock()
+	s.store = store
+	s.certs = map[string]tls.Certificate{}
+	for _, c := range certs {
+		if x, err := x509.ParseCertificate(c.Certificate[0]); err == nil && len(x.DNSNames) > 0 {
+			s.certs[x.DNSNames[0]] = c
+		}
+	}
+	s.mu.Unlock()
+	s.publish()
+
+	for {
+		s.renew()
+		time.Sleep(acmeRenewInterval)
+	}
+}
+
+// renew obtains the certificates for the domains which are
+// missing or which expire within the refresh period.
+func (s *ACMESource) renew() {
+	names := map[string]bool{}
+	for _, d := range s.Domains {
+		if !strings.Contains(d, "*") {
+			names[d] = true
+		}
+	}
+	s.mu.Lock()
+	for name := range s.certs {
+		names[name] = true
+	}
+	s.mu.Unlock()
+
+	for name := range names {
+		s.mu.Lock()
+		c, ok := s.certs[name]
+		s.mu.Unlock()
+		if ok {
+			x, err := x509.ParseCertificate(c.Certificate[0])
+			if err == nil && time.Until(x.NotAfter) > s.Refresh {
+				continue
+			}
+		}
+		if err := s.obtainBackoff(name); err != nil {
+			log.Printf("[ERROR] cert: acme: Failed to obtain certificate for %s. %s", name, err)
+		}
+	}
+}
+
+// obtainBackoff obtains the certificate for the name unless the last
+// attempt has failed recently. The time until the next attempt grows
+// exponentially with the number of failures so that clients cannot
+// make fabio hammer the certificate authority.
+func (s *ACMESource) obtainBackoff(name string) error {
+	s.mu.Lock()
+	f, failed := s.failures[name]
+	s.mu.Unlock()
+	if failed && time.Now().Before(f.until) {
+		return fmt.Errorf("acme: not retrying %s until %s. %s", name, f.until.Format(time.RFC3339), f.err)
+	}
+
+	err := s.obtain(name)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err == nil {
+		delete(s.failures, name)
+		return nil
+	}
+	d := acmeBackoffMin << uint(f.n)
+	if d <= 0 || d > acmeBackoffMax {
+		d = acmeBackoffMax
+	}
+	if s.failures == nil {
+		s.failures = map[string]acmeFailure{}
+	}
+	s.failures[name] = acmeFailure{n: f.n + 1, until: time.Now().Add(d), err: err}
+	return err
+}
+
+// obtain fetches a new certificate for the name, stores it
+// and updates the listeners.
+func (s *ACMESource) obtain(name string) error {
+	s.mu.Lock()
+	store := s.store
+	s.mu.Unlock()
+	if store == nil {
+		return errors.New("acme: certificate store not ready")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), acmeTimeout)
+	defer cancel()
+
+	c, err := s.acmeClient(ctx, store)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[INFO] cert: acme: Obtaining certificate for %s", name)
+	certPEM, keyPEM, err := c.obtain(ctx, name, s.Challenges, solveChallenge)
+	if err != nil {
+		return err
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return fmt.Errorf("acme: invalid certificate for %s. %s", name, err)
+	}
+	if err := store.put(name+"-cert.pem", certPEM); err != nil {
+		return err
+	}
+	if err := store.put(name+"-key.pem", keyPEM); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.certs[name] = cert
+	s.mu.Unlock()
+	s.publish()
+
+	if x, err := x509.ParseCertificate(cert.Certificate[0]); err == nil {
+		log.Printf("[INFO] cert: acme: Obtained certificate for %s valid until %s", name, x.NotAfter.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// acmeClient returns the client for the account and registers the
+// account on first use. The account key is created if it does not
+// exist.
+func (s *ACMESource) acmeClient(ctx context.Context, store acmeStore) (*acmeClient, error) {
+	s.clientMu.Lock()
+	defer s.clientMu.Unlock()
+
+	s.mu.Lock()
+	c := s.client
+	s.mu.Unlock()
+	if c != nil {
+		return c, nil
+	}
+
+	var key *ecdsa.PrivateKey
+	b, err := store.get("account.key")
+	switch {
+	case err != nil:
+		return nil, err
+	case b == nil:
+		if key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+			return nil, err
+		}
+		der, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		if err := store.put("account.key", pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})); err != nil {
+			return nil, err
+		}
+	default:
+		p, _ := pem.Decode(b)
+		if p == nil {
+			return nil, errors.New("acme: invalid account key")
+		}
+		if key, err = x509.ParseECPrivateKey(p.Bytes); err != nil {
+			return nil, fmt.Errorf("acme: invalid account key. %s", err)
+		}
+	}
+
+	c = newACMEClient(s.Directory, key)
+	if err := c.register(ctx, s.Email); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.client = c
+	s.mu.Unlock()
+	return c, nil
+}
+
+// publish sends the current certificates to all listeners.
+// A pending update which has not been consumed yet is replaced.
+func (s *ACMESource) publish() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var certs []tls.Certificate
+	for _, c := range s.certs {
+		certs = append(certs, c)
+	}
+	for _, ch := range s.subs {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- certs
+	}
+}
+
+// allowed returns true if the name matches one of the domains.
+// A * matches exactly one label.
+func (s *ACMESource) allowed(name string) bool {
+	name = strings.ToLower(name)
+	for _, d := range s.Domains {
+		if ok, _ := path.Match(strings.Replace(strings.ToLower(d), ".", "/", -1), strings.Replace(name, ".", "/", -1)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// acmeChallenges contains the responses for the pending challenges.
+var acmeChallenges = struct {
+	sync.Mutex
+	tokens map[string]string
+	certs  map[string]*tls.Certificate
+}{
+	tokens: map[string]string{},
+	certs:  map[string]*tls.Certificate{},
+}
+
+// solveChallenge provisions the response for the challenge.
+func solveChallenge(domain string, ch acmeChallenge, keyAuth string) (func(), error) {
+	switch ch.Type {
+	case "http-01":
+		acmeChallenges.Lock()
+		acmeChallenges.tokens[ch.Token] = keyAuth
+		acmeChallenges.Unlock()
+		return func() {
+			acmeChallenges.Lock()
+			delete(acmeChallenges.tokens, ch.Token)
+			acmeChallenges.Unlock()
+		}, nil
+
+	case "tls-alpn-01":
+		cert, err := alpnCertificate(domain, keyAuth)
+		if err != nil {
+			return nil, err
+		}
+		acmeChallenges.Lock()
+		acmeChallenges.certs[domain] = cert
+		acmeChallenges.Unlock()
+		return func() {
+			acmeChallenges.Lock()
+			delete(acmeChallenges.certs, domain)
+			acmeChallenges.Unlock()
+		}, nil
+
+	default:
+		return nil, fmt.Errorf("acme: unsupported challenge %s", ch.Type)
+	}
+}
+
+// acmeTLSProto is the ALPN protocol of the tls-alpn-01 challenge.
+const acmeTLSProto = "acme-tls/1"
+
+// acmeIdentifierOID is the id-pe-acmeIdentifier extension of
+// the tls-alpn-01 challenge certificate.
+var acmeIdentifierOID = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 31}
+
+// alpnCertificate creates the self-signed certificate
+// for the tls-alpn-01 challenge.
+func alpnCertificate(domain, keyAuth string) (*tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256([]byte(keyAuth))
+	ext, err := asn1.Marshal(sum[:])
+	if err != nil {
+		return nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:    serial,
+		Subject:         pkix.Name{CommonName: "ACME challenge"},
+		NotBefore:       time.Now().Add(-time.Hour),
+		NotAfter:        time.Now().Add(24 * time.Hour),
+		DNSNames:        []string{domain},
+		ExtraExtensions: []pkix.Extension{{Id: acmeIdentifierOID, Critical: true, Value: ext}},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+// acmeChallengeCert returns the tls-alpn-01 challenge certificate
+// if the client is an ACME validation server.
+func acmeChallengeCert(hello *tls.ClientHelloInfo) (*tls.Certificate, bool) {
+	if len(hello.SupportedProtos) != 1 || hello.SupportedProtos[0] != acmeTLSProto {
+		return nil, false
+	}
+	acmeChallenges.Lock()
+	defer acmeChallenges.Unlock()
+	cert := acmeChallenges.certs[strings.ToLower(hello.ServerName)]
+	return cert, cert != nil
+}
+
+// acmeChallengePath is the path prefix of the http-01 challenge.
+const acmeChallengePath = "/.well-known/acme-challenge/"
+
+// HTTPChallengeHandler answers the http-01 challenges of the ACME
+// sources and passes all other requests to the next handler.
+func HTTPChallengeHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, acmeChallengePath) {
+			acmeChallenges.Lock()
+			keyAuth, ok := acmeChallenges.tokens[r.URL.Path[len(acmeChallengePath):]]
+			acmeChallenges.Unlock()
+			if ok {
+				w.Header().Set("Content-Type", "text/plain")
+				w.Write([]byte(keyAuth))
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// acmeStore stores the account key and the certificates
+// of an ACME source.
+type acmeStore interface {
+	// load returns the PEM encoded certificates and keys.
+	load() (map[string][]byte, error)
+
+	// get returns the value or nil if it does not exist.
+	get(name string) ([]byte, error)
+
+	put(name string, value []byte) error
+}
+
+// newACMEStore returns a store in the consul KV store for
+// consul URLs and a store in a directory otherwise.
+func newACMEStore(storePath string) (acmeStore, error) {
+	if strings.HasPrefix(storePath, "http://") || strings.HasPrefix(storePath, "https://") {
+		cfg, key, err := parseConsulURL(storePath)
+		if err != nil {
+			return nil, err
+		}
+		client, err := api.NewClient(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return &consulACMEStore{client: client, key: strings.TrimSuffix(key, "/")}, nil
+	}
+	return dirACMEStore(storePath), nil
+}
+
+// dirACMEStore stores the files in a directory.
+type dirACMEStore string
+
+func (d dirACMEStore) load() (map[string][]byte, error) {
+	return loadPath(string(d))
+}
+
+func (d dirACMEStore) get(name string) ([]byte, error) {
+	b, err := ioutil.ReadFile(filepath.Join(string(d), name))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return b, err
+}
+
+func (d dirACMEStore) put(name string, value []byte) error {
+	if err := os.MkdirAll(string(d), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(string(d), name), value, 0600)
+}
+
+// consulACMEStore stores the files below a key in the consul KV store.
+type consulACMEStore struct {
+	client *api.Client
+	key    string
+}
+
+func (s *consulACMEStore) load() (map[string][]byte, error) {
+	pemBlocks, _, err := getCerts(s.client, s.key+"/", 0)
+	return pemBlocks, err
+}
+
+func (s *consulACMEStore) get(name string) ([]byte, error) {
+	kv, _, err := s.client.KV().Get(s.key+"/"+name, &api.QueryOptions{RequireConsistent: true})
+	if err != nil || kv == nil {
+		return nil, err
+	}
+	return kv.Value, nil
+}
+
+func (s *consulACMEStore) put(name string, value []byte) error {
+	_, err := s.client.KV().Put(&api.KVPair{Key: s.key + "/" + name, Value: value}, nil)
+	return err
+}
@@ -0,0 +1,511 @@
+package cert
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeACME implements the parts of an ACME server which are used
+// by the ACME source. It validates the challenges by querying the
+// challenge handlers of the cert package directly.
+type fakeACME struct {
+	srv *httptest.Server
+	ca  *x509.Certificate
+	key *ecdsa.PrivateKey
+
+	mu       sync.Mutex
+	nonce    int
+	jwk      string
+	accounts int
+	domains  map[string]string // order id -> domain
+	valid    map[string]bool   // order id -> authz valid
+	certs    map[string][]byte // order id -> cert chain
+}
+
+func newFakeACME(t *testing.T) *fakeACME {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "fake ACME CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := &fakeACME{
+		ca:      ca,
+		key:     key,
+		domains: map[string]string{},
+		valid:   map[string]bool{},
+		certs:   map[string][]byte{},
+	}
+	f.srv = httptest.NewServer(f)
+	return f
+}
+
+func (f *fakeACME) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.nonce++
+	w.Header().Set("Replay-Nonce", fmt.Sprintf("nonce-%d", f.nonce))
+	f.mu.Unlock()
+
+	switch {
+	case r.URL.Path == "/dir":
+		fmt.Fprintf(w, `{"newNonce":%q,"newAccount":%q,"newOrder":%q}`, f.srv.URL+"/nonce", f.srv.URL+"/account", f.srv.URL+"/order")
+		return
+	case r.URL.Path == "/nonce":
+		return
+	}
+
+	payload, ok := f.verify(w, r)
+	if !ok {
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	id := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+
+	switch {
+	case r.URL.Path == "/account":
+		f.accounts++
+		w.Header().Set("Location", f.srv.URL+"/account/1")
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, `{"status":"valid"}`)
+
+	case r.URL.Path == "/order":
+		var req struct{ Identifiers []struct{ Value string } }
+		json.Unmarshal(payload, &req)
+		id := fmt.Sprintf("%d", len(f.domains)+1)
+		f.domains[id] = req.Identifiers[0].Value
+		w.Header().Set("Location", f.srv.URL+"/order/"+id)
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, f.order(id))
+
+	case strings.HasPrefix(r.URL.Path, "/order/"):
+		fmt.Fprint(w, f.order(id))
+
+	case strings.HasPrefix(r.URL.Path, "/authz/"):
+		status := "pending"
+		if f.valid[id] {
+			status = "valid"
+		}
+		fmt.Fprintf(w, `{"status":%q,"identifier":{"type":"dns","value":%q},"challenges":[
+			{"type":"dns-01","url":"%s/chall/dns-01/%s","token":"token-%s"},
+			{"type":"http-01","url":"%s/chall/http-01/%s","token":"token-%s"},
+			{"type":"tls-alpn-01","url":"%s/chall/tls-alpn-01/%s","token":"token-%s"}
+		]}`, status, f.domains[id], f.srv.URL, id, id, f.srv.URL, id, id, f.srv.URL, id, id)
+
+	case strings.HasPrefix(r.URL.Path, "/chall/"):
+		typ := strings.Split(r.URL.Path, "/")[2]
+		f.valid[id] = f.validate(typ, f.domains[id], "token-"+id)
+		fmt.Fprint(w, `{"status":"processing"}`)
+
+	case strings.HasPrefix(r.URL.Path, "/finalize/"):
+		var req struct{ CSR string }
+		json.Unmarshal(payload, &req)
+		der, _ := base64.RawURLEncoding.DecodeString(req.CSR)
+		csr, err := x509.ParseCertificateRequest(der)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		tmpl := &x509.Certificate{
+			SerialNumber: big.NewInt(int64(len(f.certs) + 2)),
+			Subject:      csr.Subject,
+			DNSNames:     csr.DNSNames,
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		}
+		crt, err := x509.CreateCertificate(rand.Reader, tmpl, f.ca, csr.PublicKey, f.key)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.certs[id] = append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: crt}), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: f.ca.Raw})...)
+		fmt.Fprint(w, `{"status":"processing"}`)
+
+	case strings.HasPrefix(r.URL.Path, "/cert/"):
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		w.Write(f.certs[id])
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeACME) order(id string) string {
+	status := "pending"
+	switch {
+	case f.certs[id] != nil:
+		status = "valid"
+	case f.valid[id]:
+		status = "ready"
+	}
+	return fmt.Sprintf(`{"status":%q,"authorizations":["%s/authz/%s"],"finalize":"%s/finalize/%s","certificate":"%s/cert/%s"}`,
+		status, f.srv.URL, id, f.srv.URL, id, f.srv.URL, id)
+}
+
+// verify checks the signature of the request and returns the payload.
+func (f *fakeACME) verify(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	var jws struct{ Protected, Payload, Signature string }
+	if err := json.NewDecoder(r.Body).Decode(&jws); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	b, _ := base64.RawURLEncoding.DecodeString(jws.Protected)
+	var hdr struct {
+		Alg, Nonce, URL, Kid string
+		JWK                  json.RawMessage
+	}
+	json.Unmarshal(b, &hdr)
+
+	f.mu.Lock()
+	if hdr.Kid == "" {
+		f.jwk = string(hdr.JWK)
+	}
+	jwk := f.jwk
+	f.mu.Unlock()
+
+	if hdr.Alg != "ES256" || hdr.Nonce == "" || hdr.URL != f.srv.URL+r.URL.Path {
+		http.Error(w, `{"type":"urn:ietf:params:acme:error:malformed"}`, http.StatusBadRequest)
+		return nil, false
+	}
+	var k struct{ X, Y string }
+	json.Unmarshal([]byte(jwk), &k)
+	x, _ := base64.RawURLEncoding.DecodeString(k.X)
+	y, _ := base64.RawURLEncoding.DecodeString(k.Y)
+	pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+	sig, _ := base64.RawURLEncoding.DecodeString(jws.Signature)
+	sum := sha256.Sum256([]byte(jws.Protected + "." + jws.Payload))
+	if len(sig) != 64 || !ecdsa.Verify(pub, sum[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+		http.Error(w, `{"type":"urn:ietf:params:acme:error:unauthorized"}`, http.StatusUnauthorized)
+		return nil, false
+	}
+	payload, _ := base64.RawURLEncoding.DecodeString(jws.Payload)
+	return payload, true
+}
+
+// validate checks the response for the challenge.
+func (f *fakeACME) validate(typ, domain, token string) bool {
+	sum := sha256.Sum256([]byte(f.jwk))
+	keyAuth := token + "." + base64.RawURLEncoding.EncodeToString(sum[:])
+
+	switch typ {
+	case "http-01":
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "http://"+domain+"/.well-known/acme-challenge/"+token, nil)
+		HTTPChallengeHandler(http.NotFoundHandler()).ServeHTTP(rec, req)
+		return rec.Code == 200 && rec.Body.String() == keyAuth
+
+	case "tls-alpn-01":
+		cert, ok := acmeChallengeCert(&tls.ClientHelloInfo{ServerName: domain, SupportedProtos: []string{acmeTLSProto}})
+		if !ok {
+			return false
+		}
+		x, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil || len(x.DNSNames) != 1 || x.DNSNames[0] != domain {
+			return false
+		}
+		want := sha256.Sum256([]byte(keyAuth))
+		for _, ext := range x.Extensions {
+			var got []byte
+			if ext.Id.Equal(acmeIdentifierOID) && ext.Critical {
+				if _, err := asn1.Unmarshal(ext.Value, &got); err == nil && string(got) == string(want[:]) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	return false
+}
+
+func TestACMESource(t *testing.T) {
+	defer func(d time.Duration) { acmePollInterval = d }(acmePollInterval)
+	acmePollInterval = 10 * time.Millisecond
+	defer func(p func(string) bool) { ACMEHostPolicy = p }(ACMEHostPolicy)
+	ACMEHostPolicy = func(name string) bool { return name != "www2.example.org" }
+
+	for _, challenge := range []string{"http-01", "tls-alpn-01"} {
+		t.Run(challenge, func(t *testing.T) {
+			ca := newFakeACME(t)
+			defer ca.srv.Close()
+
+			dir := tempDir()
+			defer os.RemoveAll(dir)
+
+			src := &ACMESource{
+				Directory:  ca.srv.URL + "/dir",
+				Email:      "ops@example.com",
+				Domains:    []string{"example.com", "*.example.org"},
+				Challenges: []string{challenge},
+				StorePath:  dir,
+				Refresh:    24 * time.Hour,
+			}
+
+			// the certificates for the domains are obtained on startup
+			certs := waitForCerts(t, src.Certificates(), 1)
+			x, err := x509.ParseCertificate(certs[0].Certificate[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := x.DNSNames, []string{"example.com"}; len(got) != 1 || got[0] != want[0] {
+				t.Fatalf("got %v want %v", got, want)
+			}
+			for _, name := range []string{"account.key", "example.com-cert.pem", "example.com-key.pem"} {
+				if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// names which match a pattern are obtained on-demand
+			if _, err := src.Issue("www.example.org"); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := src.Issue("a.b.example.org"); err == nil {
+				t.Fatal("got nil want error")
+			}
+			if _, err := src.Issue("example.net"); err == nil {
+				t.Fatal("got nil want error")
+			}
+			// names which are not routed are rejected
+			if _, err := src.Issue("www2.example.org"); err == nil {
+				t.Fatal("got nil want error")
+			}
+
+			// the stored certificates are loaded on restart
+			src2 := &ACMESource{Directory: src.Directory, Domains: src.Domains, Challenges: src.Challenges, StorePath: dir, Refresh: time.Hour}
+			waitForCerts(t, src2.Certificates(), 2)
+
+			ca.mu.Lock()
+			defer ca.mu.Unlock()
+			if got, want := len(ca.certs), 2; got != want {
+				t.Fatalf("got %d certificates want %d", got, want)
+			}
+			if got, want := ca.accounts, 1; got != want {
+				t.Fatalf("got %d accounts want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestACMESourceRenew(t *testing.T) {
+	defer func(d time.Duration) { acmePollInterval = d }(acmePollInterval)
+	acmePollInterval = 10 * time.Millisecond
+
+	ca := newFakeACME(t)
+	defer ca.srv.Close()
+
+	dir := tempDir()
+	defer os.RemoveAll(dir)
+
+	// the stored certificate expires within the refresh period
+	certPEM, keyPEM := makePEM("example.com", 24*time.Hour)
+	saveCert(dir, "example.com", certPEM, keyPEM)
+
+	src := &ACMESource{
+		Directory:  ca.srv.URL + "/dir",
+		Domains:    []string{"example.com"},
+		Challenges: []string{"http-01"},
+		StorePath:  dir,
+		Refresh:    7 * 24 * time.Hour,
+	}
+	ch := src.Certificates()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case certs := <-ch:
+			x, err := x509.ParseCertificate(certs[0].Certificate[0])
+			if err == nil && x.Issuer.CommonName == "fake ACME CA" {
+				return
+			}
+		case <-timeout:
+			t.Fatal("certificate not renewed")
+		}
+	}
+}
+
+func TestACMESourceBackoff(t *testing.T) {
+	defer func(d time.Duration) { acmeBackoffMin = d }(acmeBackoffMin)
+	acmeBackoffMin = 50 * time.Millisecond
+	defer func(p func(string) bool) { ACMEHostPolicy = p }(ACMEHostPolicy)
+	ACMEHostPolicy = func(string) bool { return true }
+
+	var mu sync.Mutex
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		mu.Unlock()
+		http.Error(w, "down", http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+	count := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return requests
+	}
+
+	dir := tempDir()
+	defer os.RemoveAll(dir)
+
+	src := &ACMESource{Directory: srv.URL + "/dir", Domains: []string{"*.example.org"}, StorePath: dir}
+	src.once.Do(func() {}) // do not start the source
+	src.store, src.certs = dirACMEStore(dir), map[string]tls.Certificate{}
+
+	if _, err := src.Issue("www.example.org"); err == nil {
+		t.Fatal("got nil want error")
+	}
+	n := count()
+	if n == 0 {
+		t.Fatal("got no request to the certificate authority")
+	}
+
+	// failures are cached until the backoff has passed
+	if _, err := src.Issue("www.example.org"); err == nil || !strings.Contains(err.Error(), "not retrying") {
+		t.Fatalf("got %v want backoff error", err)
+	}
+	if got, want := count(), n; got != want {
+		t.Fatalf("got %d requests want %d", got, want)
+	}
+
+	time.Sleep(acmeBackoffMin)
+	if _, err := src.Issue("www.example.org"); err == nil || strings.Contains(err.Error(), "not retrying") {
+		t.Fatalf("got %v want error of the certificate authority", err)
+	}
+	if got := count(); got == n {
+		t.Fatal("got no new request after the backoff")
+	}
+
+	// the backoff doubles with every failure
+	src.mu.Lock()
+	f := src.failures["www.example.org"]
+	src.mu.Unlock()
+	if got, want := f.n, 2; got != want {
+		t.Fatalf("got %d failures want %d", got, want)
+	}
+	if d := time.Until(f.until); d <= acmeBackoffMin || d > 2*acmeBackoffMin {
+		t.Fatalf("got backoff %s want %s", d, 2*acmeBackoffMin)
+	}
+}
+
+func TestACMESourceClientOnce(t *testing.T) {
+	ca := newFakeACME(t)
+	defer ca.srv.Close()
+
+	dir := tempDir()
+	defer os.RemoveAll(dir)
+
+	src := &ACMESource{Directory: ca.srv.URL + "/dir"}
+	store := dirACMEStore(dir)
+
+	var wg sync.WaitGroup
+	clients := make([]*acmeClient, 5)
+	for i := range clients {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			c, err := src.acmeClient(context.Background(), store)
+			if err != nil {
+				t.Error(err)
+			}
+			clients[i] = c
+		}(i)
+	}
+	wg.Wait()
+
+	for _, c := range clients[1:] {
+		if c != clients[0] {
+			t.Fatal("got different clients want one")
+		}
+	}
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	if got, want := ca.accounts, 1; got != want {
+		t.Fatalf("got %d accounts want %d", got, want)
+	}
+}
+
+func TestACMETLSConfig(t *testing.T) {
+	src := &ACMESource{}
+	src.once.Do(func() {}) // do not start the source
+	cfg, err := TLSConfig(src, MatchOptions{}, 0, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := cfg.NextProtos, []string{"h2", "http/1.1", acmeTLSProto}; strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("got %v want %v", got, want)
+	}
+
+	cert, err := alpnCertificate("example.com", "token.thumbprint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	acmeChallenges.Lock()
+	acmeChallenges.certs["example.com"] = cert
+	acmeChallenges.Unlock()
+	defer func() {
+		acmeChallenges.Lock()
+		delete(acmeChallenges.certs, "example.com")
+		acmeChallenges.Unlock()
+	}()
+
+	got, err := cfg.GetCertificate(&tls.ClientHelloInfo{ServerName: "example.com", SupportedProtos: []string{acmeTLSProto}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != cert {
+		t.Fatal("got wrong certificate for the tls-alpn-01 challenge")
+	}
+}
+
+// waitForCerts waits until the source publishes n certificates.
+func waitForCerts(t *testing.T, ch chan []tls.Certificate, n int) []tls.Certificate {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case certs := <-ch:
+			if len(certs) == n {
+				return certs
+			}
+		case <-timeout:
+			t.Fatalf("timeout waiting for %d certificates", n)
+		}
+	}
+}
@@ -24,6 +24,7 @@ type ConsulSource struct {
 	CertURL     string
 	ClientCAURL string
 	CAUpgradeCN string
+	Passphrase  PassphraseProvider
 }
 
 func parseConsulURL(rawurl string) (config *api.Config, key string, err error) {
@@ -95,6 +96,11 @@ func (s ConsulSource) Certificates() chan []tls.Certificate {
 	ch := make(chan []tls.Certificate, 1)
 	go func() {
 		for pemBlocks := range pemBlocksCh {
+			pemBlocks, err := decryptPEMBlocks(pemBlocks, s.Passphrase)
+			if err != nil {
+				log.Printf("[ERROR] cert: Failed to decrypt certificates. %s", err)
+				continue
+			}
 			certs, err := loadCertificates(pemBlocks)
 			if err != nil {
 				log.Printf("[ERROR] cert: Failed to load certificates. %s", err)
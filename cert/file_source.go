@@ -4,6 +4,7 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"io/ioutil"
+	"time"
 
 	"github.com/fabiolb/fabio/exit"
 )
@@ -11,7 +12,9 @@ import (
 // FileSource implements a certificate source for one
 // TLS and one client authentication certificate.
 // The certificates are loaded during startup and are cached
-// in memory until the program exits.
+// in memory until the program exits unless Refresh is set.
+// Then the certificates are reloaded when the files change
+// and the files are also checked for changes in that interval.
 // It exists to support the legacy configuration only. The
 // PathSource should be used instead.
 type FileSource struct {
@@ -19,6 +22,8 @@ type FileSource struct {
 	KeyFile        string
 	ClientAuthFile string
 	CAUpgradeCN    string
+	Refresh        time.Duration
+	Passphrase     PassphraseProvider
 }
 
 func (s FileSource) LoadClientCAs() (*x509.CertPool, error) {
@@ -33,23 +38,54 @@ func (s FileSource) LoadClientCAs() (*x509.CertPool, error) {
 
 func (s FileSource) Certificates() chan []tls.Certificate {
 	ch := make(chan []tls.Certificate, 1)
-	ch <- []tls.Certificate{loadX509KeyPair(s.CertFile, s.KeyFile)}
-	close(ch)
+	loadFn := withPassphrase(s.loadPEM, s.Passphrase)
+	cert, pemBlocks := s.loadX509KeyPair(loadFn)
+	ch <- []tls.Certificate{cert}
+	if s.Refresh <= 0 {
+		close(ch)
+		return ch
+	}
+	files := []string{s.CertFile}
+	if s.KeyFile != "" {
+		files = append(files, s.KeyFile)
+	}
+	go watchFiles(ch, s.Refresh, s.CertFile, files, pemBlocks, loadFn)
 	return ch
 }
 
-func loadX509KeyPair(certFile, keyFile string) tls.Certificate {
-	if certFile == "" {
+// loadPEM reads the certificate and the key file and names them so
+// that loadCertificates can pair them.
+func (s FileSource) loadPEM(certFile string) (map[string][]byte, error) {
+	keyFile := s.KeyFile
+	if keyFile == "" {
+		keyFile = certFile
+	}
+	cert, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return map[string][]byte{"file-cert.pem": cert, "file-key.pem": key}, nil
+}
+
+// loadX509KeyPair returns the certificate and the PEM blocks
+// it was created from.
+func (s FileSource) loadX509KeyPair(loadFn func(string) (map[string][]byte, error)) (tls.Certificate, map[string][]byte) {
+	if s.CertFile == "" {
 		exit.Fatalf("[FATAL] cert: CertFile is required")
 	}
 
-	if keyFile == "" {
-		keyFile = certFile
+	pemBlocks, err := loadFn(s.CertFile)
+	if err != nil {
+		exit.Fatalf("[FATAL] cert: Error loading certificate. %s", err)
 	}
 
-	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	cert, err := tls.X509KeyPair(pemBlocks["file-cert.pem"], pemBlocks["file-key.pem"])
 	if err != nil {
 		exit.Fatalf("[FATAL] cert: Error loading certificate. %s", err)
 	}
-	return cert
+	return cert, pemBlocks
 }
@@ -19,6 +19,7 @@ type HTTPSource struct {
 	ClientCAURL string
 	CAUpgradeCN string
 	Refresh     time.Duration
+	Passphrase  PassphraseProvider
 }
 
 func (s HTTPSource) LoadClientCAs() (*x509.CertPool, error) {
@@ -27,6 +28,6 @@ func (s HTTPSource) LoadClientCAs() (*x509.CertPool, error) {
 
 func (s HTTPSource) Certificates() chan []tls.Certificate {
 	ch := make(chan []tls.Certificate, 1)
-	go watch(ch, s.Refresh, s.CertURL, loadURL)
+	go watch(ch, s.Refresh, s.CertURL, withPassphrase(loadURL, s.Passphrase))
 	return ch
 }
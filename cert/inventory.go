@@ -0,0 +1,80 @@
+package cert
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Info describes a certificate which fabio serves.
+type Info struct {
+	Subject     string    `json:"subject"`
+	DNSNames    []string  `json:"dnsNames,omitempty"`
+	Issuer      string    `json:"issuer"`
+	NotBefore   time.Time `json:"notBefore"`
+	NotAfter    time.Time `json:"notAfter"`
+	Fingerprint string    `json:"fingerprint"`
+}
+
+// stores contains the certificate stores of the listeners.
+var stores = struct {
+	sync.Mutex
+	list []*Store
+}{}
+
+func registerStore(s *Store) {
+	stores.Lock()
+	stores.list = append(stores.list, s)
+	stores.Unlock()
+}
+
+// Inventory returns the leaf certificates of all listeners sorted by
+// their expiry. Certificates which are served by more than one
+// listener are only listed once.
+func Inventory() []Info {
+	stores.Lock()
+	list := append([]*Store(nil), stores.list...)
+	stores.Unlock()
+
+	seen := map[string]bool{}
+	var certs []Info
+	for _, s := range list {
+		for _, c := range s.certstore().Certificates {
+			if len(c.Certificate) == 0 {
+				continue
+			}
+			sum := sha256.Sum256(c.Certificate[0])
+			fp := hex.EncodeToString(sum[:])
+			if seen[fp] {
+				continue
+			}
+			seen[fp] = true
+
+			leaf := c.Leaf
+			if leaf == nil {
+				var err error
+				if leaf, err = x509.ParseCertificate(c.Certificate[0]); err != nil {
+					continue
+				}
+			}
+			certs = append(certs, Info{
+				Subject:     leaf.Subject.String(),
+				DNSNames:    leaf.DNSNames,
+				Issuer:      leaf.Issuer.String(),
+				NotBefore:   leaf.NotBefore,
+				NotAfter:    leaf.NotAfter,
+				Fingerprint: fp,
+			})
+		}
+	}
+	sort.Slice(certs, func(i, j int) bool {
+		if !certs[i].NotAfter.Equal(certs[j].NotAfter) {
+			return certs[i].NotAfter.Before(certs[j].NotAfter)
+		}
+		return certs[i].Fingerprint < certs[j].Fingerprint
+	})
+	return certs
+}
@@ -0,0 +1,44 @@
+package cert
+
+import (
+	"crypto/tls"
+	"testing"
+	"time"
+)
+
+func TestInventory(t *testing.T) {
+	stores.Lock()
+	old := stores.list
+	stores.list = nil
+	stores.Unlock()
+	defer func() {
+		stores.Lock()
+		stores.list = old
+		stores.Unlock()
+	}()
+
+	a, b := makeCert("a.com", time.Hour), makeCert("b.com", time.Minute)
+	s1, s2 := NewStore(), NewStore()
+	s1.SetCertificates([]tls.Certificate{a, b})
+	s2.SetCertificates([]tls.Certificate{a})
+	registerStore(s1)
+	registerStore(s2)
+
+	certs := Inventory()
+	if got, want := len(certs), 2; got != want {
+		t.Fatalf("got %d certificates want %d", got, want)
+	}
+	// sorted by expiry
+	if got, want := certs[0].DNSNames, "b.com"; len(got) != 1 || got[0] != want {
+		t.Fatalf("got names %v want [%s]", got, want)
+	}
+	if got, want := certs[1].DNSNames, "a.com"; len(got) != 1 || got[0] != want {
+		t.Fatalf("got names %v want [%s]", got, want)
+	}
+	if got, want := certs[1].Subject, "O=Fabio Co"; got != want {
+		t.Fatalf("got subject %q want %q", got, want)
+	}
+	if got, want := certs[1].Fingerprint, fingerprints([]tls.Certificate{a})[0]; got != want {
+		t.Fatalf("got fingerprint %q want %q", got, want)
+	}
+}
@@ -0,0 +1,318 @@
+package cert
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// PassphraseProvider returns the passphrase for encrypted private keys.
+type PassphraseProvider func() ([]byte, error)
+
+// NewPassphraseProvider creates a passphrase provider from a spec
+// of the form
+//
+//	env:NAME                  read the passphrase from the environment variable NAME
+//	file:/path/to/file        read the passphrase from a file
+//	vault:secret/path[#field] read the passphrase from a Vault secret.
+//	                          The field defaults to 'passphrase'.
+//
+// The passphrase is read every time it is needed so that it can be
+// rotated together with the keys.
+func NewPassphraseProvider(spec string, vault *vaultClient) (PassphraseProvider, error) {
+	p := strings.SplitN(spec, ":", 2)
+	if len(p) != 2 || p[1] == "" {
+		return nil, fmt.Errorf("cert: invalid passphrase provider %q", spec)
+	}
+	typ, arg := p[0], p[1]
+
+	switch typ {
+	case "env":
+		return func() ([]byte, error) {
+			v, ok := os.LookupEnv(arg)
+			if !ok {
+				return nil, fmt.Errorf("cert: passphrase variable %s not set", arg)
+			}
+			return []byte(v), nil
+		}, nil
+
+	case "file":
+		return func() ([]byte, error) {
+			b, err := ioutil.ReadFile(arg)
+			if err != nil {
+				return nil, fmt.Errorf("cert: cannot read passphrase. %s", err)
+			}
+			return bytes.TrimRight(b, "\r\n"), nil
+		}, nil
+
+	case "vault":
+		path, field := arg, "passphrase"
+		if n := strings.LastIndex(arg, "#"); n >= 0 {
+			path, field = arg[:n], arg[n+1:]
+		}
+		return func() ([]byte, error) {
+			c, err := vault.Get()
+			if err != nil {
+				return nil, fmt.Errorf("vault: client: %s", err)
+			}
+			secret, err := c.Logical().Read(path)
+			if err != nil {
+				return nil, fmt.Errorf("vault: read %s: %s", path, err)
+			}
+			if secret == nil {
+				return nil, fmt.Errorf("vault: no secret at %s", path)
+			}
+			data := secret.Data
+			// KV v2 secrets are wrapped in a 'data' field
+			if x, ok := data["data"].(map[string]interface{}); ok {
+				data = x
+			}
+			v, ok := data[field].(string)
+			if !ok {
+				return nil, fmt.Errorf("vault: no field %s in %s", field, path)
+			}
+			return []byte(v), nil
+		}, nil
+
+	default:
+		return nil, fmt.Errorf("cert: unknown passphrase provider %q", typ)
+	}
+}
+
+// decryptPEMBlocks decrypts all encrypted private keys in the
+// pem blocks. It is a no-op if pass is nil.
+func decryptPEMBlocks(pemBlocks map[string][]byte, pass PassphraseProvider) (map[string][]byte, error) {
+	if pass == nil {
+		return pemBlocks, nil
+	}
+
+	var passphrase []byte
+	out := make(map[string][]byte, len(pemBlocks))
+	for name, data := range pemBlocks {
+		if !isEncryptedPEM(data) {
+			out[name] = data
+			continue
+		}
+		if passphrase == nil {
+			p, err := pass()
+			if err != nil {
+				return nil, err
+			}
+			passphrase = p
+		}
+		b, err := decryptPEM(data, passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("cert: cannot decrypt %s. %s", name, err)
+		}
+		out[name] = b
+	}
+	return out, nil
+}
+
+// withPassphrase wraps a load function so that encrypted
+// private keys are decrypted.
+func withPassphrase(loadFn func(string) (map[string][]byte, error), pass PassphraseProvider) func(string) (map[string][]byte, error) {
+	if pass == nil {
+		return loadFn
+	}
+	return func(path string) (map[string][]byte, error) {
+		pemBlocks, err := loadFn(path)
+		if err != nil {
+			return nil, err
+		}
+		return decryptPEMBlocks(pemBlocks, pass)
+	}
+}
+
+func isEncryptedPEM(data []byte) bool {
+	return bytes.Contains(data, []byte("ENCRYPTED"))
+}
+
+// decryptPEM decrypts all encrypted private keys in data and returns
+// them as unencrypted PEM blocks. Other blocks are passed through.
+// Legacy OpenSSL encrypted keys and PKCS#8 encrypted keys with PBES2
+// are supported.
+func decryptPEM(data, passphrase []byte) ([]byte, error) {
+	var out bytes.Buffer
+	for {
+		var b *pem.Block
+		b, data = pem.Decode(data)
+		if b == nil {
+			break
+		}
+
+		switch {
+		case b.Type == "ENCRYPTED PRIVATE KEY":
+			der, err := decryptPKCS8(b.Bytes, passphrase)
+			if err != nil {
+				return nil, err
+			}
+			b = &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+
+		case x509.IsEncryptedPEMBlock(b):
+			der, err := x509.DecryptPEMBlock(b, passphrase)
+			if err != nil {
+				return nil, err
+			}
+			// the padding check can succeed with a wrong passphrase
+			if !isPrivateKey(der) {
+				return nil, errors.New("decryption failed. Wrong passphrase?")
+			}
+			b = &pem.Block{Type: b.Type, Bytes: der}
+		}
+
+		if err := pem.Encode(&out, b); err != nil {
+			return nil, err
+		}
+	}
+	if out.Len() == 0 {
+		return nil, errors.New("no PEM data found")
+	}
+	return out.Bytes(), nil
+}
+
+func isPrivateKey(der []byte) bool {
+	if _, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return true
+	}
+	if _, err := x509.ParseECPrivateKey(der); err == nil {
+		return true
+	}
+	_, err := x509.ParsePKCS8PrivateKey(der)
+	return err == nil
+}
+
+var (
+	oidPBES2          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidHMACWithSHA1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidHMACWithSHA512 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 11}
+	oidAES128CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+	oidDESEDE3CBC     = asn1.ObjectIdentifier{1, 2, 840, 113549, 3, 7}
+)
+
+type algorithmIdentifier struct {
+	Algorithm  asn1.ObjectIdentifier
+	Parameters asn1.RawValue `asn1:"optional"`
+}
+
+type encryptedPrivateKeyInfo struct {
+	Algorithm     algorithmIdentifier
+	EncryptedData []byte
+}
+
+type pbes2Params struct {
+	KeyDerivationFunc algorithmIdentifier
+	EncryptionScheme  algorithmIdentifier
+}
+
+type pbkdf2Params struct {
+	Salt       []byte
+	Iterations int
+	KeyLength  int                 `asn1:"optional"`
+	PRF        algorithmIdentifier `asn1:"optional"`
+}
+
+// decryptPKCS8 decrypts a PKCS#8 EncryptedPrivateKeyInfo which
+// was encrypted with PBES2 and returns the PKCS#8 private key.
+func decryptPKCS8(der, passphrase []byte) ([]byte, error) {
+	var info encryptedPrivateKeyInfo
+	if _, err := asn1.Unmarshal(der, &info); err != nil {
+		return nil, fmt.Errorf("invalid encrypted private key. %s", err)
+	}
+	if !info.Algorithm.Algorithm.Equal(oidPBES2) {
+		return nil, fmt.Errorf("unsupported encryption %s. Only PBES2 is supported", info.Algorithm.Algorithm)
+	}
+
+	var params pbes2Params
+	if _, err := asn1.Unmarshal(info.Algorithm.Parameters.FullBytes, &params); err != nil {
+		return nil, fmt.Errorf("invalid PBES2 parameters. %s", err)
+	}
+	if !params.KeyDerivationFunc.Algorithm.Equal(oidPBKDF2) {
+		return nil, fmt.Errorf("unsupported key derivation %s", params.KeyDerivationFunc.Algorithm)
+	}
+
+	var kdf pbkdf2Params
+	if _, err := asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdf); err != nil {
+		return nil, fmt.Errorf("invalid PBKDF2 parameters. %s", err)
+	}
+
+	var prf func() hash.Hash
+	switch alg := kdf.PRF.Algorithm; {
+	case len(alg) == 0, alg.Equal(oidHMACWithSHA1):
+		prf = sha1.New
+	case alg.Equal(oidHMACWithSHA256):
+		prf = sha256.New
+	case alg.Equal(oidHMACWithSHA512):
+		prf = sha512.New
+	default:
+		return nil, fmt.Errorf("unsupported PRF %s", alg)
+	}
+
+	var keyLen int
+	var newCipher func([]byte) (cipher.Block, error)
+	switch alg := params.EncryptionScheme.Algorithm; {
+	case alg.Equal(oidAES128CBC):
+		keyLen, newCipher = 16, aes.NewCipher
+	case alg.Equal(oidAES192CBC):
+		keyLen, newCipher = 24, aes.NewCipher
+	case alg.Equal(oidAES256CBC):
+		keyLen, newCipher = 32, aes.NewCipher
+	case alg.Equal(oidDESEDE3CBC):
+		keyLen, newCipher = 24, des.NewTripleDESCipher
+	default:
+		return nil, fmt.Errorf("unsupported cipher %s", alg)
+	}
+
+	var iv []byte
+	if _, err := asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv); err != nil {
+		return nil, fmt.Errorf("invalid IV. %s", err)
+	}
+
+	key := pbkdf2.Key(passphrase, kdf.Salt, kdf.Iterations, keyLen, prf)
+	block, err := newCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	data := info.EncryptedData
+	if len(iv) != block.BlockSize() || len(data) == 0 || len(data)%block.BlockSize() != 0 {
+		return nil, errors.New("invalid encrypted data")
+	}
+	out := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(out, data)
+
+	// remove the PKCS#7 padding
+	n := int(out[len(out)-1])
+	if n == 0 || n > block.BlockSize() || n > len(out) {
+		return nil, errors.New("decryption failed. Wrong passphrase?")
+	}
+	for _, b := range out[len(out)-n:] {
+		if int(b) != n {
+			return nil, errors.New("decryption failed. Wrong passphrase?")
+		}
+	}
+	out = out[:len(out)-n]
+
+	if !isPrivateKey(out) {
+		return nil, errors.New("decryption failed. Wrong passphrase?")
+	}
+	return out, nil
+}
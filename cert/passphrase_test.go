@@ -0,0 +1,147 @@
+package cert
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// encryptPKCS8 encrypts a PKCS#8 private key with PBES2, PBKDF2-SHA256
+// and AES-256-CBC like 'openssl pkcs8 -topk8 -v2 aes-256-cbc' does.
+func encryptPKCS8(t *testing.T, der, passphrase []byte) []byte {
+	t.Helper()
+	salt, iv := make([]byte, 8), make([]byte, aes.BlockSize)
+	rand.Read(salt)
+	rand.Read(iv)
+
+	key := pbkdf2.Key(passphrase, salt, 2048, 32, sha256.New)
+	block, _ := aes.NewCipher(key)
+	n := aes.BlockSize - len(der)%aes.BlockSize
+	data := append(append([]byte{}, der...), bytes.Repeat([]byte{byte(n)}, n)...)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(data, data)
+
+	mustMarshal := func(v interface{}) asn1.RawValue {
+		b, err := asn1.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return asn1.RawValue{FullBytes: b}
+	}
+	params := pbes2Params{
+		KeyDerivationFunc: algorithmIdentifier{
+			Algorithm: oidPBKDF2,
+			Parameters: mustMarshal(pbkdf2Params{
+				Salt:       salt,
+				Iterations: 2048,
+				PRF:        algorithmIdentifier{Algorithm: oidHMACWithSHA256, Parameters: asn1.NullRawValue},
+			}),
+		},
+		EncryptionScheme: algorithmIdentifier{Algorithm: oidAES256CBC, Parameters: mustMarshal(iv)},
+	}
+	b, err := asn1.Marshal(encryptedPrivateKeyInfo{
+		Algorithm:     algorithmIdentifier{Algorithm: oidPBES2, Parameters: mustMarshal(params)},
+		EncryptedData: data,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: b})
+}
+
+func TestDecryptPEM(t *testing.T) {
+	certPEM, keyPEM := makePEM("localhost", time.Minute)
+	keyBlock, _ := pem.Decode(keyPEM)
+	pass := []byte("secret")
+
+	legacy, err := x509.EncryptPEMBlock(rand.Reader, keyBlock.Type, keyBlock.Bytes, pass, x509.PEMCipherAES256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := x509.ParsePKCS1PrivateKey(keyBlock.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		desc string
+		key  []byte
+	}{
+		{"legacy", pem.EncodeToMemory(legacy)},
+		{"pkcs8", encryptPKCS8(t, pkcs8, pass)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			if _, err := decryptPEM(tt.key, []byte("wrong")); err == nil {
+				t.Fatal("got nil want error for wrong passphrase")
+			}
+			b, err := decryptPEM(tt.key, pass)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := tls.X509KeyPair(certPEM, b); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestFileSourceEncryptedKey(t *testing.T) {
+	dir := tempDir()
+	defer os.RemoveAll(dir)
+
+	certPEM, keyPEM := makePEM("localhost", time.Minute)
+	keyBlock, _ := pem.Decode(keyPEM)
+	encrypted, err := x509.EncryptPEMBlock(rand.Reader, keyBlock.Type, keyBlock.Bytes, []byte("secret"), x509.PEMCipherAES256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := saveCert(dir, "localhost", certPEM, pem.EncodeToMemory(encrypted))
+
+	passFile := filepath.Join(dir, "pass")
+	if err := ioutil.WriteFile(passFile, []byte("secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	pass, err := NewPassphraseProvider("file:"+passFile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testSource(t, FileSource{CertFile: certFile, KeyFile: keyFile, Passphrase: pass}, makeCertPool(certPEM), 0)
+}
+
+func TestNewPassphraseProvider(t *testing.T) {
+	os.Setenv("FABIO_TEST_PASSPHRASE", "secret")
+	defer os.Unsetenv("FABIO_TEST_PASSPHRASE")
+
+	p, err := NewPassphraseProvider("env:FABIO_TEST_PASSPHRASE", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err := p(); err != nil || string(b) != "secret" {
+		t.Fatalf("got %q, %v want %q, nil", b, err, "secret")
+	}
+
+	for _, spec := range []string{"", "env", "env:", "foo:bar"} {
+		if _, err := NewPassphraseProvider(spec, nil); err == nil {
+			t.Errorf("%q: got nil want error", spec)
+		}
+	}
+}
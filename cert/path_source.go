@@ -18,6 +18,7 @@ type PathSource struct {
 	ClientCAPath string
 	CAUpgradeCN  string
 	Refresh      time.Duration
+	Passphrase   PassphraseProvider
 }
 
 func (s PathSource) LoadClientCAs() (*x509.CertPool, error) {
@@ -28,7 +29,7 @@ func (s PathSource) LoadClientCAs() (*x509.CertPool, error) {
 func (s PathSource) Certificates() chan []tls.Certificate {
 	path := makePath(s.Path, s.CertPath, DefaultCertPath)
 	ch := make(chan []tls.Certificate, 1)
-	go watch(ch, s.Refresh, path, loadPath)
+	go watchFiles(ch, s.Refresh, path, []string{path}, nil, withPassphrase(loadPath, s.Passphrase))
 	return ch
 }
 
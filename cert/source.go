@@ -32,6 +32,14 @@ type Issuer interface {
 
 // NewSource generates a cert source from the config options.
 func NewSource(cfg config.CertSource) (Source, error) {
+	var pass PassphraseProvider
+	if cfg.KeyPassphrase != "" {
+		var err error
+		if pass, err = NewPassphraseProvider(cfg.KeyPassphrase, NewVaultClient(cfg.VaultFetchToken)); err != nil {
+			return nil, err
+		}
+	}
+
 	switch cfg.Type {
 	case "file":
 		return FileSource{
@@ -39,6 +47,8 @@ func NewSource(cfg config.CertSource) (Source, error) {
 			KeyFile:        cfg.KeyPath,
 			ClientAuthFile: cfg.ClientCAPath,
 			CAUpgradeCN:    cfg.CAUpgradeCN,
+			Refresh:        cfg.Refresh,
+			Passphrase:     pass,
 		}, nil
 
 	case "path":
@@ -47,6 +57,7 @@ func NewSource(cfg config.CertSource) (Source, error) {
 			ClientCAPath: cfg.ClientCAPath,
 			CAUpgradeCN:  cfg.CAUpgradeCN,
 			Refresh:      cfg.Refresh,
+			Passphrase:   pass,
 		}, nil
 
 	case "http":
@@ -55,6 +66,7 @@ func NewSource(cfg config.CertSource) (Source, error) {
 			ClientCAURL: cfg.ClientCAPath,
 			CAUpgradeCN: cfg.CAUpgradeCN,
 			Refresh:     cfg.Refresh,
+			Passphrase:  pass,
 		}, nil
 
 	case "consul":
@@ -62,6 +74,7 @@ func NewSource(cfg config.CertSource) (Source, error) {
 			CertURL:     cfg.CertPath,
 			ClientCAURL: cfg.ClientCAPath,
 			CAUpgradeCN: cfg.CAUpgradeCN,
+			Passphrase:  pass,
 		}, nil
 
 	case "vault":
@@ -71,6 +84,7 @@ func NewSource(cfg config.CertSource) (Source, error) {
 			CAUpgradeCN:  cfg.CAUpgradeCN,
 			Refresh:      cfg.Refresh,
 			Client:       NewVaultClient(cfg.VaultFetchToken),
+			Passphrase:   pass,
 		}, nil
 	case "vault-pki":
 		src := NewVaultPKISource()
@@ -81,6 +95,22 @@ func NewSource(cfg config.CertSource) (Source, error) {
 		src.Client = NewVaultClient(cfg.VaultFetchToken)
 		return src, nil
 
+	case "acme":
+		dir := cfg.ACMEDirectory
+		if dir == "" {
+			dir = LetsEncryptURL
+		}
+		return sharedACMESource(cfg.Name, &ACMESource{
+			Directory:    dir,
+			Email:        cfg.ACMEEmail,
+			Domains:      cfg.ACMEDomains,
+			Challenges:   cfg.ACMEChallenges,
+			StorePath:    cfg.CertPath,
+			ClientCAPath: cfg.ClientCAPath,
+			CAUpgradeCN:  cfg.CAUpgradeCN,
+			Refresh:      cfg.Refresh,
+		}), nil
+
 	default:
 		return nil, fmt.Errorf("invalid certificate source %q", cfg.Type)
 	}
@@ -92,21 +122,31 @@ func NewSource(cfg config.CertSource) (Source, error) {
 //
 // It also sets the ClientCAs field if src.LoadClientCAs returns a non-nil
 // value and sets ClientAuth to RequireAndVerifyClientCert.
-func TLSConfig(src Source, strictMatch bool, minVersion, maxVersion uint16, cipherSuites []uint16) (*tls.Config, error) {
+func TLSConfig(src Source, match MatchOptions, minVersion, maxVersion uint16, cipherSuites []uint16) (*tls.Config, error) {
 	clientCAs, err := src.LoadClientCAs()
 	if err != nil {
 		return nil, err
 	}
 
+	_, isACME := src.(*ACMESource)
+
 	sf := &singleflight.Group{}
 	store := NewStore()
+	registerStore(store)
 	x := &tls.Config{
 		MinVersion:   minVersion,
 		MaxVersion:   maxVersion,
 		CipherSuites: cipherSuites,
 		NextProtos:   []string{"h2", "http/1.1"},
 		GetCertificate: func(clientHello *tls.ClientHelloInfo) (cert *tls.Certificate, err error) {
-			cert, err = getCertificate(store.certstore(), clientHello, strictMatch)
+			// answer the tls-alpn-01 challenge of the ACME source
+			if isACME {
+				if cert, ok := acmeChallengeCert(clientHello); ok {
+					return cert, nil
+				}
+			}
+
+			cert, err = getCertificate(store.certstore(), clientHello, match)
 			if cert != nil {
 				return
 			}
@@ -136,6 +176,10 @@ func TLSConfig(src Source, strictMatch bool, minVersion, maxVersion uint16, ciph
 		},
 	}
 
+	if isACME {
+		x.NextProtos = append(x.NextProtos, acmeTLSProto)
+	}
+
 	if clientCAs != nil {
 		x.ClientCAs = clientCAs
 		x.ClientAuth = tls.RequireAndVerifyClientCert
@@ -44,7 +44,7 @@ func TestTLSConfig(t *testing.T) {
 	tlsciphers := []uint16{0x1234, 0x5678}
 	nextprotos := []string{"h2", "http/1.1"}
 
-	cfg, err := TLSConfig(src, false, tlsmin, tlsmax, tlsciphers)
+	cfg, err := TLSConfig(src, MatchOptions{}, tlsmin, tlsmax, tlsciphers)
 	if err != nil {
 		t.Fatalf("got error %v want nil", err)
 	}
@@ -106,6 +106,7 @@ func TestNewSource(t *testing.T) {
 				KeyFile:        "key",
 				ClientAuthFile: "clientca",
 				CAUpgradeCN:    "upgcn",
+				Refresh:        3 * time.Second,
 			},
 		},
 		{
@@ -148,6 +149,17 @@ func TestNewSource(t *testing.T) {
 				Refresh:      3 * time.Second,
 			},
 		},
+		{
+			desc: "acme",
+			cfg:  certsource("acme"),
+			src: &ACMESource{
+				Directory:    LetsEncryptURL,
+				StorePath:    "cert",
+				ClientCAPath: "clientca",
+				CAUpgradeCN:  "upgcn",
+				Refresh:      3 * time.Second,
+			},
+		},
 	}
 
 	for i, tt := range tests {
@@ -200,6 +212,49 @@ func TestFileSource(t *testing.T) {
 	testSource(t, FileSource{CertFile: certFile, KeyFile: keyFile}, makeCertPool(certPEM), 0)
 }
 
+func TestFileSourceReload(t *testing.T) {
+	tests := []struct {
+		desc    string
+		refresh time.Duration
+	}{
+		{"poll", 10 * time.Millisecond},
+		// the refresh interval is too long for the test so that
+		// the reload has to be triggered by the file system event
+		{"notify", time.Hour},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			dir := tempDir()
+			defer os.RemoveAll(dir)
+			certPEM, keyPEM := makePEM("localhost", time.Minute)
+			certFile, keyFile := saveCert(dir, "localhost", certPEM, keyPEM)
+
+			ch := FileSource{CertFile: certFile, KeyFile: keyFile, Refresh: tt.refresh}.Certificates()
+			first := <-ch
+
+			// the watcher must not send the initial certificate again
+			select {
+			case <-ch:
+				t.Fatal("got initial certificate twice")
+			case <-time.After(1500 * time.Millisecond):
+			}
+
+			certPEM, keyPEM = makePEM("localhost", time.Hour)
+			saveCert(dir, "localhost", certPEM, keyPEM)
+
+			select {
+			case next := <-ch:
+				if got, want := fingerprints(next), fingerprints(first); reflect.DeepEqual(got, want) {
+					t.Fatalf("got unchanged certificate %v", got)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("timeout waiting for reload")
+			}
+		})
+	}
+}
+
 func TestPathSource(t *testing.T) {
 	dir := tempDir()
 	defer os.RemoveAll(dir)
@@ -561,8 +616,7 @@ func TestVaultPKISource(t *testing.T) {
 // the HTTPS client can validate the certificate presented by the
 // server.
 func testSource(t *testing.T, source Source, rootCAs *x509.CertPool, sleep time.Duration) {
-	const NoStrictMatch = false
-	srvConfig, err := TLSConfig(source, NoStrictMatch, 0, 0, nil)
+	srvConfig, err := TLSConfig(source, MatchOptions{StrictMatch: false}, 0, 0, nil)
 	if err != nil {
 		t.Fatalf("TLSConfig: got %q want nil", err)
 	}
@@ -1,8 +1,10 @@
 package cert
 
 import (
+	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/hex"
 	"errors"
 	"log"
 	"strings"
@@ -24,14 +26,37 @@ func NewStore() *Store {
 
 // SetCertificates replaces the certificates of the store.
 func (s *Store) SetCertificates(certs []tls.Certificate) {
+	old := fingerprints(s.certstore().Certificates)
 	cs := certstore{Certificates: certs}
 	cs.BuildNameToCertificate()
 	s.cs.Store(cs)
+
+	cur := fingerprints(certs)
+	if len(old) > 0 && strings.Join(old, ",") == strings.Join(cur, ",") {
+		log.Printf("[DEBUG] cert: Certificates unchanged")
+		return
+	}
 	var names []string
 	for name := range cs.NameToCertificate {
 		names = append(names, name)
 	}
 	log.Printf("[INFO] cert: Store has certificates for [%q]", strings.Join(names, ","))
+	if len(old) > 0 {
+		log.Printf("[INFO] cert: Replaced certificates %v with %v", old, cur)
+	}
+}
+
+// fingerprints returns the SHA-256 fingerprints of the leaf certificates.
+func fingerprints(certs []tls.Certificate) []string {
+	var fp []string
+	for _, c := range certs {
+		if len(c.Certificate) == 0 {
+			continue
+		}
+		sum := sha256.Sum256(c.Certificate[0])
+		fp = append(fp, hex.EncodeToString(sum[:]))
+	}
+	return fp
 }
 
 func (s *Store) certstore() certstore {
@@ -40,14 +65,43 @@ func (s *Store) certstore() certstore {
 
 var ErrNoCertsStored = errors.New("cert: no certificates stored")
 
-func getCertificate(cs certstore, clientHello *tls.ClientHelloInfo, strictMatch bool) (cert *tls.Certificate, err error) {
+// MatchOptions controls how a certificate is selected for a connection.
+type MatchOptions struct {
+	// StrictMatch disables the fallback to the first certificate
+	// when no certificate matches the server name.
+	StrictMatch bool
+
+	// PreferWildcard selects a matching wildcard certificate over
+	// a certificate with an exact match when both exist.
+	PreferWildcard bool
+
+	// NoSNI controls the behavior for connections without a server
+	// name. "default" serves the first certificate, "fail" aborts the
+	// handshake and an empty value follows StrictMatch.
+	NoSNI string
+}
+
+// ErrNoSNI is returned when a client does not send a server name
+// and the listener requires one.
+var ErrNoSNI = errors.New("cert: client did not send a server name")
+
+func getCertificate(cs certstore, clientHello *tls.ClientHelloInfo, opts MatchOptions) (cert *tls.Certificate, err error) {
 	if len(cs.Certificates) == 0 {
 		return nil, ErrNoCertsStored
 	}
 
+	if clientHello.ServerName == "" {
+		switch opts.NoSNI {
+		case "fail":
+			return nil, ErrNoSNI
+		case "default":
+			return &cs.Certificates[0], nil
+		}
+	}
+
 	// There's only one choice, so no point doing any work.
 	// However, if fallback is disabled we need to check.
-	if !strictMatch && (len(cs.Certificates) == 1 || cs.NameToCertificate == nil) {
+	if !opts.StrictMatch && (len(cs.Certificates) == 1 || cs.NameToCertificate == nil) {
 		return &cs.Certificates[0], nil
 	}
 
@@ -56,8 +110,9 @@ func getCertificate(cs certstore, clientHello *tls.ClientHelloInfo, strictMatch
 		name = name[:len(name)-1]
 	}
 
-	if cert, ok := cs.NameToCertificate[name]; ok {
-		return cert, nil
+	exact := cs.NameToCertificate[name]
+	if exact != nil && !opts.PreferWildcard {
+		return exact, nil
 	}
 
 	// try replacing labels in the name with wildcards until we get a match
@@ -70,9 +125,13 @@ func getCertificate(cs certstore, clientHello *tls.ClientHelloInfo, strictMatch
 		}
 	}
 
+	if exact != nil {
+		return exact, nil
+	}
+
 	// If nothing matches, return the first certificate
 	// unless fallback to the first cert is disabled.
-	if strictMatch {
+	if opts.StrictMatch {
 		return nil, nil
 	}
 	return &cs.Certificates[0], nil
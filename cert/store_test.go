@@ -12,12 +12,14 @@ func TestGetCertificate(t *testing.T) {
 	fooCert := makeCert("foo.com", time.Minute)
 	barCert := makeCert("bar.com", time.Minute)
 	wildBarCert := makeCert("*.bar.com", time.Minute)
+	quuxBarCert := makeCert("quux.bar.com", time.Minute)
 
 	tests := []struct {
 		desc   string
 		certs  []tls.Certificate
 		hello  *tls.ClientHelloInfo
 		strict bool
+		opts   MatchOptions
 		cert   *tls.Certificate
 		err    error
 	}{
@@ -97,12 +99,66 @@ func TestGetCertificate(t *testing.T) {
 			strict: true,
 			err:    nil,
 		},
+
+		// exact or wildcard preference
+		{
+			desc:  "exact match preferred",
+			certs: []tls.Certificate{fooCert, wildBarCert, quuxBarCert},
+			hello: &tls.ClientHelloInfo{ServerName: "quux.bar.com"},
+			cert:  &quuxBarCert,
+			err:   nil,
+		},
+		{
+			desc:  "wildcard preferred",
+			certs: []tls.Certificate{fooCert, wildBarCert, quuxBarCert},
+			hello: &tls.ClientHelloInfo{ServerName: "quux.bar.com"},
+			opts:  MatchOptions{PreferWildcard: true},
+			cert:  &wildBarCert,
+			err:   nil,
+		},
+		{
+			desc:  "wildcard preferred without wildcard",
+			certs: []tls.Certificate{fooCert, quuxBarCert},
+			hello: &tls.ClientHelloInfo{ServerName: "quux.bar.com"},
+			opts:  MatchOptions{PreferWildcard: true},
+			cert:  &quuxBarCert,
+			err:   nil,
+		},
+
+		// missing SNI
+		{
+			desc:   "no sni strict match",
+			certs:  []tls.Certificate{fooCert, barCert},
+			hello:  &tls.ClientHelloInfo{},
+			strict: true,
+			cert:   nil,
+			err:    nil,
+		},
+		{
+			desc:   "no sni serve default",
+			certs:  []tls.Certificate{fooCert, barCert},
+			hello:  &tls.ClientHelloInfo{},
+			strict: true,
+			opts:   MatchOptions{NoSNI: "default"},
+			cert:   &fooCert,
+			err:    nil,
+		},
+		{
+			desc:  "no sni fail",
+			certs: []tls.Certificate{fooCert, barCert},
+			hello: &tls.ClientHelloInfo{},
+			opts:  MatchOptions{NoSNI: "fail"},
+			cert:  nil,
+			err:   ErrNoSNI,
+		},
 	}
 
 	for i, tt := range tests {
 		cs := certstore{Certificates: tt.certs}
 		cs.BuildNameToCertificate()
-		cert, err := getCertificate(cs, tt.hello, tt.strict)
+		opts := tt.opts
+		opts.StrictMatch = tt.strict
+		cert, err := getCertificate(cs, tt.hello, opts)
 		if got, want := err, tt.err; !reflect.DeepEqual(got, want) {
 			t.Errorf("%d: %q: got %v want %v", i, tt.desc, got, want)
 			continue
@@ -26,6 +26,7 @@ type VaultSource struct {
 	ClientCAPath string
 	CAUpgradeCN  string
 	Refresh      time.Duration
+	Passphrase   PassphraseProvider
 }
 
 func (s *VaultSource) LoadClientCAs() (*x509.CertPool, error) {
@@ -37,7 +38,7 @@ func (s *VaultSource) LoadClientCAs() (*x509.CertPool, error) {
 
 func (s *VaultSource) Certificates() chan []tls.Certificate {
 	ch := make(chan []tls.Certificate, 1)
-	go watch(ch, s.Refresh, s.CertPath, s.load)
+	go watch(ch, s.Refresh, s.CertPath, withPassphrase(s.load, s.Passphrase))
 	return ch
 }
 
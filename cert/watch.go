@@ -3,12 +3,29 @@ package cert
 import (
 	"crypto/tls"
 	"log"
+	"os"
+	"path/filepath"
 	"reflect"
 	"time"
+
+	"github.com/fsnotify/fsnotify"
 )
 
+// settle is the time to wait after a file system event for further
+// events before the certificates are reloaded. Replacing a certificate
+// usually touches several files.
+var settle = 100 * time.Millisecond
+
 // watch monitors the result of the loadFn function for changes.
 func watch(ch chan []tls.Certificate, refresh time.Duration, path string, loadFn func(path string) (map[string][]byte, error)) {
+	watchFiles(ch, refresh, path, nil, nil, loadFn)
+}
+
+// watchFiles is like watch but also reloads the certificates as soon
+// as one of the files or directories changes. The refresh interval is
+// the fallback if the file system cannot be watched. last contains the
+// PEM blocks of the certificates which have already been sent on ch.
+func watchFiles(ch chan []tls.Certificate, refresh time.Duration, path string, files []string, last map[string][]byte, loadFn func(path string) (map[string][]byte, error)) {
 	once := refresh <= 0
 
 	// do not refresh more often than once a second to prevent busy loops
@@ -16,23 +33,35 @@ func watch(ch chan []tls.Certificate, refresh time.Duration, path string, loadFn
 		refresh = time.Second
 	}
 
-	var last map[string][]byte
+	var w *fileWatcher
+	if !once && len(files) > 0 {
+		var err error
+		if w, err = newFileWatcher(files); err != nil {
+			log.Printf("[WARN] cert: Cannot watch %s for changes. Checking every %s. %s", path, refresh, err)
+		} else {
+			defer w.Close()
+		}
+	}
+
 	for {
 		next, err := loadFn(path)
 		if err != nil {
 			log.Printf("[ERROR] cert: Cannot load certificates from %s. %s", path, err)
-			time.Sleep(refresh)
+			w.wait(refresh)
 			continue
 		}
 
 		if reflect.DeepEqual(next, last) {
-			time.Sleep(refresh)
+			w.wait(refresh)
 			continue
 		}
 
+		// keep the current certificates until the files are fixed,
+		// e.g. when only the cert but not yet the key was replaced.
 		certs, err := loadCertificates(next)
 		if err != nil {
 			log.Printf("[ERROR] cert: Cannot make certificates: %s", err)
+			w.wait(refresh)
 			continue
 		}
 
@@ -44,3 +73,89 @@ func watch(ch chan []tls.Certificate, refresh time.Duration, path string, loadFn
 		}
 	}
 }
+
+// fileWatcher reports changes of a set of files and directories.
+// The parent directories of files are watched instead of the files
+// themselves so that atomic replacements via rename are detected.
+type fileWatcher struct {
+	w     *fsnotify.Watcher
+	files map[string]bool
+	dirs  map[string]bool
+}
+
+func newFileWatcher(paths []string) (*fileWatcher, error) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	fw := &fileWatcher{w: w, files: map[string]bool{}, dirs: map[string]bool{}}
+	watched := map[string]bool{}
+	for _, p := range paths {
+		p = filepath.Clean(p)
+		dir := filepath.Dir(p)
+		if fi, err := os.Stat(p); err == nil && fi.IsDir() {
+			fw.dirs[p] = true
+			dir = p
+		} else {
+			fw.files[p] = true
+		}
+		if watched[dir] {
+			continue
+		}
+		if err := w.Add(dir); err != nil {
+			w.Close()
+			return nil, err
+		}
+		watched[dir] = true
+	}
+	return fw, nil
+}
+
+// wait blocks until one of the watched files has changed or until the
+// refresh interval has expired. A nil watcher only waits for refresh.
+func (fw *fileWatcher) wait(refresh time.Duration) {
+	if fw == nil {
+		time.Sleep(refresh)
+		return
+	}
+
+	timeout := time.NewTimer(refresh)
+	defer timeout.Stop()
+	for {
+		select {
+		case <-timeout.C:
+			return
+		case err := <-fw.w.Errors:
+			log.Printf("[WARN] cert: Error watching certificates. %s", err)
+		case ev := <-fw.w.Events:
+			if !fw.match(ev.Name) {
+				continue
+			}
+			fw.drain()
+			return
+		}
+	}
+}
+
+// drain discards the events which arrive within the settle time.
+func (fw *fileWatcher) drain() {
+	t := time.NewTimer(settle)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			return
+		case <-fw.w.Events:
+		case <-fw.w.Errors:
+		}
+	}
+}
+
+func (fw *fileWatcher) match(name string) bool {
+	name = filepath.Clean(name)
+	return fw.files[name] || fw.dirs[filepath.Dir(name)]
+}
+
+func (fw *fileWatcher) Close() error {
+	return fw.w.Close()
+}
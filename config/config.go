@@ -1,7 +1,9 @@
 package config
 
 import (
+	"net"
 	"net/http"
+	"os"
 	"regexp"
 	"time"
 )
@@ -15,6 +17,8 @@ type Config struct {
 	UI                   UI
 	Runtime              Runtime
 	Tracing              Tracing
+	Snapshot             Snapshot
+	HA                   HA
 	ProfileMode          string
 	ProfilePath          string
 	Insecure             bool
@@ -32,6 +36,11 @@ type CertSource struct {
 	Refresh         time.Duration
 	Header          http.Header
 	VaultFetchToken string
+	KeyPassphrase   string
+	ACMEDirectory   string
+	ACMEEmail       string
+	ACMEDomains     []string
+	ACMEChallenges  []string
 }
 
 type Listen struct {
@@ -42,41 +51,210 @@ type Listen struct {
 	IdleTimeout        time.Duration
 	CertSource         CertSource
 	StrictMatch        bool
+	CertPrefer         string
+	NoSNI              string
 	TLSMinVersion      uint16
 	TLSMaxVersion      uint16
 	TLSCiphers         []uint16
 	ProxyProto         bool
 	ProxyHeaderTimeout time.Duration
+	ProxyTrusted       []*net.IPNet
+	ProxyOut           int
+	MaxHeaders         int
+	MaxHeaderBytes     int
 	Refresh            time.Duration
+	HTTP2              HTTP2
+	SocketMode         os.FileMode
+	Socket             SocketOpts
+	IPMode             string
+	GRPCWeb            bool
+}
+
+// SocketOpts contains the socket options of a TCP listener.
+type SocketOpts struct {
+	BindToDevice string
+	FreeBind     bool
+	TTL          int
+	TOS          int
+}
+
+type HTTP2 struct {
+	Disabled             bool
+	MaxConcurrentStreams uint32
+	MaxReadFrameSize     uint32
+	MaxConnWindow        int32
+	MaxStreamWindow      int32
+	IdleTimeout          time.Duration
 }
 
 type UI struct {
-	Listen Listen
-	Color  string
-	Title  string
-	Access string
+	Listen    Listen
+	Color     string
+	Title     string
+	Access    string
+	LocalOnly bool
+	Metrics   bool
+	PageSize  int
+	PagesDir  string
+
+	// Mount is the path prefix under which the admin API is
+	// also served on the HTTP proxy listeners.
+	Mount string
+
+	// MountRW enables the read-write API under Mount. Otherwise
+	// only the read-only API is served on the proxy listeners.
+	MountRW bool
+
+	// Group is the option or tag key by which the routes
+	// are grouped and colored, e.g. 'env'.
+	Group       string
+	GroupColors map[string]string
+
+	// Peers are the admin URLs of the other fabio instances
+	// whose routing tables are compared in the fleet view.
+	Peers []string
 }
 
 type Proxy struct {
 	Strategy              string
+	HashKey               string
+	HashVirtualNodes      int
 	Matcher               string
 	NoRouteStatus         int
+	NoRouteHTMLDir        string
+	NoRouteJSON           string
 	MaxConn               int
 	ShutdownWait          time.Duration
+	DrainGrace            time.Duration
+	UpgradeTimeout        time.Duration
 	DialTimeout           time.Duration
 	ResponseHeaderTimeout time.Duration
+	WSIdleTimeout         time.Duration
 	KeepAliveTimeout      time.Duration
 	IdleConnTimeout       time.Duration
 	FlushInterval         time.Duration
 	GlobalFlushInterval   time.Duration
+	TimeoutHeaders        []string
+	TimeoutMax            time.Duration
+	UpstreamHTTP2         string
 	LocalIP               string
 	ClientIPHeader        string
 	TLSHeader             string
 	TLSHeaderValue        string
+	TLSCertPrefer         string
+	TLSNoSNI              string
 	GZIPContentTypes      *regexp.Regexp
+	GZIPMinSize           int
 	RequestID             string
 	STSHeader             STSHeader
 	AuthSchemes           map[string]AuthScheme
+	Capture               Capture
+	Cache                 Cache
+	BodyRewriteMaxBody    int
+	MaxBody               int64
+	RateLimit             RateLimit
+	StickySecret          string `json:"-"`
+	DebugHeader           string
+	DebugSecret           string `json:"-"`
+	DebugMaxTTL           time.Duration
+	TraceHeader           string
+	TraceSecret           string `json:"-"`
+	TraceMaxTTL           time.Duration
+	KillSwitch            KillSwitch
+	Outlier               Outlier
+	Failover              Failover
+	Mirror                Mirror
+	Middleware            []string
+}
+
+// Mirror configures the defaults for the copies of the requests
+// which the routes with the mirror option send to a shadow
+// environment.
+type Mirror struct {
+	// Header is the name of the header which marks the copies.
+	Header string
+
+	// Strip are the names of the headers which are removed from
+	// the copies. 'auth' removes the Authorization and the
+	// Proxy-Authorization header and 'cookie' the Cookie header.
+	Strip []string
+
+	// Timeout is the time after which a copy is cancelled.
+	Timeout time.Duration
+
+	// MaxBody is the maximum size of the request bodies which are
+	// copied. Requests with larger bodies are not mirrored.
+	MaxBody int64
+
+	// MaxConn is the maximum number of copies in flight.
+	// Further copies are dropped.
+	MaxConn int
+}
+
+// Failover configures when the traffic of a service moves between
+// the regions of its targets.
+type Failover struct {
+	// Threshold is the share of healthy targets of the active region
+	// below which the traffic moves to the next region. Recover is the
+	// share of healthy targets a more preferred region must have for
+	// Hold before the traffic moves back.
+	Threshold float64
+	Recover   float64
+	Hold      time.Duration
+}
+
+// Outlier configures the passive health checks which eject targets
+// from the selection when too many of their requests fail.
+type Outlier struct {
+	// Threshold is the share of failed requests above which a
+	// target is ejected. Zero disables the outlier detection.
+	Threshold   float64
+	MinRequests int
+	Window      time.Duration
+
+	// Ejection is the time a target is ejected for the first time.
+	Ejection time.Duration
+
+	// MaxEjected is the maximum share of the targets
+	// of a route which are ejected.
+	MaxEjected float64
+	RampUp     time.Duration
+
+	// Latency is the response time above which a request
+	// counts as failed. Zero disables the latency check.
+	Latency time.Duration
+}
+
+// KillSwitch configures the defaults for the static responses of
+// the kill switches which are enabled via the admin API.
+type KillSwitch struct {
+	Status      int
+	ContentType string
+	Body        string
+	TTL         time.Duration
+}
+
+type RateLimit struct {
+	Rate  float64
+	Burst int
+	Body  string
+}
+
+type Capture struct {
+	Target  string
+	Format  string
+	Rate    int
+	MaxBody int
+	Redact  []string
+}
+
+// Cache configures the store for the responses of
+// the routes with the cache option.
+type Cache struct {
+	Store    string
+	Dir      string
+	MaxSize  int
+	MaxEntry int
 }
 
 type STSHeader struct {
@@ -85,9 +263,42 @@ type STSHeader struct {
 	Preload    bool
 }
 
+// Snapshot configures the periodic export of the configuration and
+// the state of fabio into archives.
+type Snapshot struct {
+	// Target is a local directory or an S3 URL like
+	// s3://bucket/prefix. An empty target disables the snapshots.
+	Target   string
+	Interval time.Duration
+
+	// Keep is the number of archives which are kept in a local
+	// directory. Zero keeps all archives.
+	Keep int
+}
+
+// HA configures the active-standby mode in which fabio instances
+// compete for a lock in consul and the standby takes over when the
+// active instance fails.
+type HA struct {
+	// Mode is either empty which disables the active-standby
+	// mode or consul.
+	Mode string
+
+	LockKey string
+	LockTTL time.Duration
+
+	// Promote and Demote are the commands which are run when the
+	// instance becomes active or goes back to standby.
+	Promote     string
+	Demote      string
+	HookTimeout time.Duration
+}
+
 type Runtime struct {
-	GOGC       int
-	GOMAXPROCS int
+	GOGC        int
+	GOMAXPROCS  int
+	NoFileRaise bool
+	NoFileWarn  float64
 }
 
 type Circonus struct {
@@ -99,11 +310,63 @@ type Circonus struct {
 	SubmissionURL string
 }
 
+type Prometheus struct {
+	Addr      string
+	Namespace string
+	Labels    map[string]string
+	Buckets   []float64
+}
+
 type Log struct {
-	AccessFormat string
-	AccessTarget string
-	RoutesFormat string
-	Level        string
+	AccessFormat     string
+	AccessJSONFields []string
+	AccessTarget     string
+	AccessFile       AccessFile
+	RoutesFormat     string
+	RoutesHook       string
+	RoutesMax        int
+	Level            string
+	Target           string
+	Format           string
+	Banner           bool
+	Syslog           Syslog
+	Redact           Redact
+	NoRoute          NoRouteLog
+}
+
+// AccessFile configures the rotation of the access log file.
+type AccessFile struct {
+	MaxSize    int
+	Interval   time.Duration
+	MaxBackups int
+	Compress   bool
+}
+
+// Syslog configures the syslog server for the application
+// log and the access log.
+type Syslog struct {
+	Addr      string
+	Facility  string
+	Tag       string
+	TLSCAFile string
+}
+
+// NoRouteLog configures the log and the statistics of the
+// requests for which no route was found.
+type NoRouteLog struct {
+	Target string
+	Sample float64
+	TopK   int
+}
+
+// Redact contains the names of the headers, query parameters and
+// cookies whose values are removed from the access log, the trace
+// tags and the capture file. Names are case-insensitive and can
+// contain '*' wildcards.
+type Redact struct {
+	Headers []string
+	Query   []string
+	Cookies []string
 }
 
 type Metrics struct {
@@ -113,19 +376,53 @@ type Metrics struct {
 	Interval     time.Duration
 	Timeout      time.Duration
 	Retry        time.Duration
+	GCGrace      time.Duration
+	TLSSNITopK   int
+	UserAgent    bool
 	GraphiteAddr string
 	StatsDAddr   string
 	Circonus     Circonus
+	Prometheus   Prometheus
 }
 
 type Registry struct {
-	Backend string
-	Static  Static
-	File    File
-	Consul  Consul
-	Custom  Custom
-	Timeout time.Duration
-	Retry   time.Duration
+	Backend    string
+	Static     Static
+	File       File
+	Consul     Consul
+	Custom     Custom
+	Kubernetes Kubernetes
+	Nomad      Nomad
+	Etcd       Etcd
+	Zookeeper  Zookeeper
+	Replay     Replay
+	Flap       Flap
+	Debounce   Debounce
+	RecordPath string
+	Timeout    time.Duration
+	Retry      time.Duration
+}
+
+// Replay configures the backend which replays a
+// recording of registry updates.
+type Replay struct {
+	Path  string
+	Speed float64
+}
+
+// Flap configures the detection of flapping targets.
+type Flap struct {
+	Threshold int
+	Window    time.Duration
+	Hold      time.Duration
+	Weight    float64
+}
+
+// Debounce configures how long the routing table is not rebuilt
+// while registry updates keep arriving.
+type Debounce struct {
+	Window time.Duration
+	Max    time.Duration
 }
 
 type Static struct {
@@ -136,12 +433,17 @@ type Static struct {
 type File struct {
 	NoRouteHTMLPath string
 	RoutesPath      string
+	Refresh         time.Duration
 }
 
 type Consul struct {
 	Addr               string
 	Scheme             string
 	Token              string
+	TokenServices      string
+	TokenKV            string
+	TokenRegister      string
+	TokenRefresh       time.Duration
 	KVPath             string
 	NoRouteHTMLPath    string
 	TagPrefix          string
@@ -158,6 +460,68 @@ type Consul struct {
 	ServiceMonitors    int
 	TLS                ConsulTlS
 	PollInterval       time.Duration
+	AliasDomain        string
+	RolloutPath        string
+	RolloutDuration    time.Duration
+	RolloutStep        time.Duration
+	FlagsPath          string
+}
+
+type Kubernetes struct {
+	Kubeconfig   string
+	Context      string
+	Namespace    string
+	Selector     string
+	Prefix       string
+	Ingress      bool
+	IngressClass string
+}
+
+type Nomad struct {
+	Addr      string
+	Token     string `json:"-"`
+	Namespace string
+	Region    string
+	TagPrefix string
+	WaitTime  time.Duration
+	TLS       NomadTLS
+}
+
+type NomadTLS struct {
+	KeyFile            string
+	CertFile           string
+	CAFile             string
+	InsecureSkipVerify bool
+}
+
+type Etcd struct {
+	Addr            string
+	Username        string
+	Password        string `json:"-"`
+	RoutesPath      string
+	KVPath          string
+	NoRouteHTMLPath string
+	TLS             EtcdTLS
+}
+
+type EtcdTLS struct {
+	KeyFile            string
+	CertFile           string
+	CAFile             string
+	InsecureSkipVerify bool
+}
+
+type Zookeeper struct {
+	Addr            string
+	SessionTimeout  time.Duration
+	ServicesPath    string
+	TagPrefix       string
+	RoutesPath      string
+	KVPath          string
+	NoRouteHTMLPath string
+	Register        bool
+	ServiceName     string
+	ServiceAddr     string
 }
 
 type Custom struct {
@@ -181,12 +545,23 @@ type Tracing struct {
 	SpanHost       string
 	SpanName       string
 	TraceID128Bit  bool
+	OTLP           OTLP
+}
+
+type OTLP struct {
+	Protocol string
+	Endpoint string
+	Sampler  string
+	Resource map[string]string
+	Headers  map[string]string `json:"-"`
 }
 
 type AuthScheme struct {
-	Name  string
-	Type  string
-	Basic BasicAuth
+	Name     string
+	Type     string
+	Basic    BasicAuth
+	JWT      JWTAuth
+	ExtAuthz ExtAuthzAuth
 }
 
 type BasicAuth struct {
@@ -194,6 +569,73 @@ type BasicAuth struct {
 	File    string
 	Refresh time.Duration
 	ModTime time.Time // the htpasswd file last modification time
+
+	// LDAP verifies the credentials with an LDAP
+	// server instead of an htpasswd file.
+	LDAP LDAPAuth
+}
+
+type LDAPAuth struct {
+	// URL is the address of the LDAP server with
+	// the scheme ldap or ldaps.
+	URL                string
+	StartTLS           bool
+	CAFile             string
+	InsecureSkipVerify bool
+	Timeout            time.Duration
+
+	// BindDN and BindPassword are the credentials for searching
+	// the user. The search is anonymous if BindDN is empty.
+	BindDN       string
+	BindPassword string `json:"-"`
+
+	// BaseDN is the base of the search for the user with Filter
+	// in which {user} is replaced with the user name.
+	BaseDN string
+	Filter string
+
+	// Group is the DN of a group of which the user must be a member.
+	Group string
+
+	// CacheTTL is the time for which successful logins are cached.
+	CacheTTL time.Duration
+}
+
+type JWTAuth struct {
+	Realm    string
+	JWKS     string
+	Issuer   string
+	Audience []string
+	Refresh  time.Duration
+	Leeway   time.Duration
+
+	// Claims maps the names of claims to the names
+	// of the request headers they are copied to.
+	Claims map[string]string
+}
+
+type ExtAuthzAuth struct {
+	// URL is the address of the authorization service. The schemes
+	// http and https use the HTTP protocol and the schemes grpc and
+	// grpcs the gRPC protocol of the Envoy external authorization
+	// service.
+	URL     string
+	Timeout time.Duration
+
+	// Headers are the request headers which are sent to the
+	// authorization service. If empty all headers are sent.
+	Headers []string
+
+	// Upstream are the headers of an HTTP authorization response
+	// which are copied to the request for the upstream service and
+	// Downstream the ones which are copied to the response of an
+	// allowed request.
+	Upstream   []string
+	Downstream []string
+
+	// FailOpen allows requests when the authorization
+	// service cannot be reached.
+	FailOpen bool
 }
 
 type ConsulTlS struct {
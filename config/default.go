@@ -24,33 +24,111 @@ var defaultConfig = &Config{
 	ProfilePath: os.TempDir(),
 	Log: Log{
 		AccessFormat: "common",
+		AccessJSONFields: []string{
+			"time_rfc3339_ms", "remote_addr", "request_method", "request_host", "request_uri", "route",
+			"upstream_service", "upstream_addr", "response_status", "response_body_size", "response_time_ms", "trace_id",
+		},
 		RoutesFormat: "delta",
+		RoutesMax:    10000,
 		Level:        "INFO",
+		Target:       "stderr",
+		Format:       "text",
+		Banner:       true,
+		Syslog: Syslog{
+			Facility: "local0",
+			Tag:      "fabio",
+		},
+		NoRoute: NoRouteLog{
+			Sample: 1,
+			TopK:   100,
+		},
 	},
 	Metrics: Metrics{
-		Prefix:   "{{clean .Hostname}}.{{clean .Exec}}",
-		Names:    "{{clean .Service}}.{{clean .Host}}.{{clean .Path}}.{{clean .TargetURL.Host}}",
-		Interval: 30 * time.Second,
-		Timeout:  10 * time.Second,
-		Retry:    500 * time.Millisecond,
+		Prefix:     "{{clean .Hostname}}.{{clean .Exec}}",
+		Names:      "{{clean .Service}}.{{clean .Host}}.{{clean .Path}}.{{clean .TargetURL.Host}}",
+		Interval:   30 * time.Second,
+		Timeout:    10 * time.Second,
+		Retry:      500 * time.Millisecond,
+		GCGrace:    time.Minute,
+		TLSSNITopK: 100,
 		Circonus: Circonus{
 			APIApp: "fabio",
 		},
+		Prometheus: Prometheus{
+			Namespace: "fabio",
+		},
 	},
 	Proxy: Proxy{
 		MaxConn:             10000,
 		Strategy:            "rnd",
+		HashKey:             "ip",
+		HashVirtualNodes:    100,
 		Matcher:             "prefix",
 		NoRouteStatus:       404,
 		DialTimeout:         30 * time.Second,
+		DrainGrace:          10 * time.Second,
+		UpgradeTimeout:      time.Minute,
 		FlushInterval:       time.Second,
 		GlobalFlushInterval: 0,
+		UpstreamHTTP2:       "off",
 		LocalIP:             LocalIPString(),
 		AuthSchemes:         map[string]AuthScheme{},
 		IdleConnTimeout:     15 * time.Second,
+		TLSCertPrefer:       "exact",
+		Middleware:          []string{"ratelimit", "access", "auth", "sticky", "headers"},
+		DebugMaxTTL:         time.Hour,
+		TraceMaxTTL:         time.Hour,
+		BodyRewriteMaxBody:  1024 * 1024,
+		KillSwitch: KillSwitch{
+			Status:      503,
+			ContentType: "application/json",
+			Body:        `{"error":"service unavailable"}`,
+			TTL:         15 * time.Minute,
+		},
+		Outlier: Outlier{
+			MinRequests: 20,
+			Window:      10 * time.Second,
+			Ejection:    30 * time.Second,
+			MaxEjected:  0.5,
+			RampUp:      30 * time.Second,
+		},
+		Failover: Failover{
+			Threshold: 0.5,
+			Recover:   0.8,
+			Hold:      time.Minute,
+		},
+		Mirror: Mirror{
+			Header:  "X-Fabio-Mirror",
+			Strip:   []string{"cookie", "auth"},
+			Timeout: 10 * time.Second,
+			MaxBody: 1024 * 1024,
+			MaxConn: 100,
+		},
+		Capture: Capture{
+			Format:  "json",
+			Rate:    10,
+			MaxBody: 64 * 1024,
+			Redact:  []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-Api-Key", "password", "token", "secret"},
+		},
+		Cache: Cache{
+			Store:    "memory",
+			MaxSize:  64 * 1024 * 1024,
+			MaxEntry: 1024 * 1024,
+		},
 	},
 	Registry: Registry{
 		Backend: "consul",
+		Replay: Replay{
+			Speed: 1,
+		},
+		Debounce: Debounce{
+			Max: 5 * time.Second,
+		},
+		Flap: Flap{
+			Window: 5 * time.Minute,
+			Hold:   5 * time.Minute,
+			Weight: 0.1,
+		},
 		Consul: Consul{
 			Addr:            "localhost:8500",
 			Scheme:          "http",
@@ -67,6 +145,9 @@ var defaultConfig = &Config{
 			CheckScheme:     "http",
 			ChecksRequired:  "one",
 			PollInterval:    0,
+			TokenRefresh:    time.Minute,
+			RolloutDuration: 5 * time.Minute,
+			RolloutStep:     5 * time.Second,
 		},
 		Custom: Custom{
 			Host:               "",
@@ -78,20 +159,60 @@ var defaultConfig = &Config{
 			Path:               "",
 			QueryParams:        "",
 		},
+		Kubernetes: Kubernetes{
+			Prefix:       "fabio.io/urlprefix",
+			IngressClass: "fabio",
+		},
+		Nomad: Nomad{
+			Addr:      "http://localhost:4646",
+			Namespace: "default",
+			TagPrefix: "urlprefix-",
+			WaitTime:  5 * time.Minute,
+		},
+		Etcd: Etcd{
+			Addr:            "http://localhost:2379",
+			RoutesPath:      "/fabio/routes",
+			KVPath:          "/fabio/config",
+			NoRouteHTMLPath: "/fabio/noroute.html",
+		},
+		Zookeeper: Zookeeper{
+			Addr:            "localhost:2181",
+			SessionTimeout:  10 * time.Second,
+			ServicesPath:    "/fabio/services",
+			TagPrefix:       "urlprefix-",
+			RoutesPath:      "/fabio/routes",
+			KVPath:          "/fabio/config",
+			NoRouteHTMLPath: "/fabio/noroute.html",
+			ServiceName:     "fabio",
+			ServiceAddr:     ":9998",
+		},
 		Timeout: 10 * time.Second,
 		Retry:   500 * time.Millisecond,
 	},
 	Runtime: Runtime{
-		GOGC:       100,
-		GOMAXPROCS: runtime.NumCPU(),
+		GOGC:        100,
+		GOMAXPROCS:  runtime.NumCPU(),
+		NoFileRaise: true,
+		NoFileWarn:  0.8,
 	},
 	UI: UI{
 		Listen: Listen{
 			Addr:  ":9998",
 			Proto: "http",
 		},
-		Color:  "light-green",
-		Access: "rw",
+		Color:    "light-green",
+		Access:   "rw",
+		PageSize: 1000,
+	},
+
+	Snapshot: Snapshot{
+		Interval: time.Hour,
+	},
+
+	HA: HA{
+		LockKey:     "fabio/ha/lock",
+		LockTTL:     15 * time.Second,
+		HookTimeout: 30 * time.Second,
 	},
 
 	Tracing: Tracing{
@@ -104,6 +225,10 @@ var defaultConfig = &Config{
 		SpanHost:       "localhost:9998",
 		SpanName:       "",
 		TraceID128Bit:  true,
+		OTLP: OTLP{
+			Protocol: "http",
+			Sampler:  "parentbased_traceidratio",
+		},
 	},
 
 	GlobCacheSize: 1000,
@@ -2,19 +2,26 @@ package config
 
 import (
 	"crypto/tls"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"path"
 	"regexp"
 	"runtime"
 	"strconv"
 	"strings"
 	"time"
+	"unicode"
 
 	gs "github.com/hashicorp/go-sockaddr/template"
 	"github.com/magiconair/properties"
+	"golang.org/x/net/http/httpguts"
 )
 
 func Load(args, environ []string) (cfg *Config, err error) {
@@ -117,28 +124,86 @@ func load(cmdline, environ, envprefix []string, props *properties.Properties) (c
 	var authSchemesValue string
 	var readTimeout, writeTimeout time.Duration
 	var gzipContentTypesValue string
+	var promLabelsValue, promBucketsValue string
+	var otlpResourceValue, otlpHeadersValue string
+	var uiGroupColorsValue string
+	var rateLimitValue string
 
 	var obsoleteStr string
 
 	f.BoolVar(&cfg.Insecure, "insecure", defaultConfig.Insecure, "allow fabio to run as root when set to true")
 	f.IntVar(&cfg.Proxy.MaxConn, "proxy.maxconn", defaultConfig.Proxy.MaxConn, "maximum number of cached connections")
 	f.StringVar(&cfg.Proxy.Strategy, "proxy.strategy", defaultConfig.Proxy.Strategy, "load balancing strategy")
+	f.StringVar(&cfg.Proxy.HashKey, "proxy.hash.key", defaultConfig.Proxy.HashKey, "key of the hash strategy: ip, header:<name> or cookie:<name>")
+	f.IntVar(&cfg.Proxy.HashVirtualNodes, "proxy.hash.vnodes", defaultConfig.Proxy.HashVirtualNodes, "number of points per target on the ring of the hash strategy")
 	f.StringVar(&cfg.Proxy.Matcher, "proxy.matcher", defaultConfig.Proxy.Matcher, "path matching algorithm")
 	f.IntVar(&cfg.Proxy.NoRouteStatus, "proxy.noroutestatus", defaultConfig.Proxy.NoRouteStatus, "status code for invalid route. Must be three digits")
+	f.StringVar(&cfg.Proxy.NoRouteHTMLDir, "proxy.noroute.htmldir", defaultConfig.Proxy.NoRouteHTMLDir, "directory with <lang>.html pages returned when no route is found")
+	f.StringVar(&cfg.Proxy.NoRouteJSON, "proxy.noroute.json", defaultConfig.Proxy.NoRouteJSON, "JSON body returned to API clients when no route is found")
 	f.DurationVar(&cfg.Proxy.ShutdownWait, "proxy.shutdownwait", defaultConfig.Proxy.ShutdownWait, "time for graceful shutdown")
+	f.DurationVar(&cfg.Proxy.DrainGrace, "proxy.drain.grace", defaultConfig.Proxy.DrainGrace, "time new connections are accepted after draining was started via the admin API")
+	f.DurationVar(&cfg.Proxy.UpgradeTimeout, "proxy.upgrade.timeout", defaultConfig.Proxy.UpgradeTimeout, "time the new process has to take over the listeners on SIGUSR2")
 	f.DurationVar(&cfg.Proxy.DialTimeout, "proxy.dialtimeout", defaultConfig.Proxy.DialTimeout, "connection timeout for backend connections")
+	f.StringSliceVar(&cfg.Proxy.TimeoutHeaders, "proxy.timeout.headers", defaultConfig.Proxy.TimeoutHeaders, "headers with a timeout requested by the client, e.g. X-Request-Timeout,grpc-timeout")
+	f.DurationVar(&cfg.Proxy.TimeoutMax, "proxy.timeout.max", defaultConfig.Proxy.TimeoutMax, "maximum timeout a client can request with proxy.timeout.headers. 0 means no limit")
 	f.DurationVar(&cfg.Proxy.ResponseHeaderTimeout, "proxy.responseheadertimeout", defaultConfig.Proxy.ResponseHeaderTimeout, "response header timeout")
+	f.DurationVar(&cfg.Proxy.WSIdleTimeout, "proxy.ws.idletimeout", defaultConfig.Proxy.WSIdleTimeout, "time after which websocket connections without data in either direction are closed. 0 means no timeout")
 	f.DurationVar(&cfg.Proxy.KeepAliveTimeout, "proxy.keepalivetimeout", defaultConfig.Proxy.KeepAliveTimeout, "keep-alive timeout")
 	f.DurationVar(&cfg.Proxy.IdleConnTimeout, "proxy.idleconntimeout", defaultConfig.Proxy.IdleConnTimeout, "idle timeout, when to close (keep-alive) connections")
+	f.StringVar(&cfg.Proxy.UpstreamHTTP2, "proxy.upstream.http2", defaultConfig.Proxy.UpstreamHTTP2, "HTTP/2 for upstream connections: off, h2 or h2c")
 	f.StringVar(&cfg.Proxy.LocalIP, "proxy.localip", defaultConfig.Proxy.LocalIP, "fabio address in Forward headers")
 	f.StringVar(&cfg.Proxy.ClientIPHeader, "proxy.header.clientip", defaultConfig.Proxy.ClientIPHeader, "header for the request ip")
 	f.StringVar(&cfg.Proxy.TLSHeader, "proxy.header.tls", defaultConfig.Proxy.TLSHeader, "header for TLS connections")
 	f.StringVar(&cfg.Proxy.TLSHeaderValue, "proxy.header.tls.value", defaultConfig.Proxy.TLSHeaderValue, "value for TLS connection header")
+	f.StringVar(&cfg.Proxy.TLSCertPrefer, "proxy.tls.certprefer", defaultConfig.Proxy.TLSCertPrefer, "certificate preference when an exact and a wildcard certificate match: exact or wildcard")
+	f.StringVar(&cfg.Proxy.TLSNoSNI, "proxy.tls.nosni", defaultConfig.Proxy.TLSNoSNI, "behavior for TLS connections without SNI: default, fail or empty to follow strictmatch")
 	f.StringVar(&cfg.Proxy.RequestID, "proxy.header.requestid", defaultConfig.Proxy.RequestID, "header for reqest id")
 	f.IntVar(&cfg.Proxy.STSHeader.MaxAge, "proxy.header.sts.maxage", defaultConfig.Proxy.STSHeader.MaxAge, "enable and set the max-age value for HSTS")
 	f.BoolVar(&cfg.Proxy.STSHeader.Subdomains, "proxy.header.sts.subdomains", defaultConfig.Proxy.STSHeader.Subdomains, "direct HSTS to include subdomains")
 	f.BoolVar(&cfg.Proxy.STSHeader.Preload, "proxy.header.sts.preload", defaultConfig.Proxy.STSHeader.Preload, "direct HSTS to pass the preload directive")
 	f.StringVar(&gzipContentTypesValue, "proxy.gzip.contenttype", defaultValues.GZIPContentTypesValue, "regexp of content types to compress")
+	f.IntVar(&cfg.Proxy.GZIPMinSize, "proxy.gzip.minsize", defaultConfig.Proxy.GZIPMinSize, "minimum size in bytes of the responses with a Content-Length which are compressed")
+	f.StringVar(&cfg.Proxy.Capture.Target, "proxy.capture.target", defaultConfig.Proxy.Capture.Target, "path of the traffic capture file")
+	f.StringVar(&cfg.Proxy.Capture.Format, "proxy.capture.format", defaultConfig.Proxy.Capture.Format, "format of the traffic capture file, one of [json, har]")
+	f.IntVar(&cfg.Proxy.Capture.Rate, "proxy.capture.rate", defaultConfig.Proxy.Capture.Rate, "max number of captured requests per second, 0 for no limit")
+	f.IntVar(&cfg.Proxy.Capture.MaxBody, "proxy.capture.maxbody", defaultConfig.Proxy.Capture.MaxBody, "max size of the captured body samples in bytes")
+	f.StringVar(&cfg.Proxy.Cache.Store, "proxy.cache.store", defaultConfig.Proxy.Cache.Store, "store for the responses of routes with the cache option, one of [memory, disk]")
+	f.StringVar(&cfg.Proxy.Cache.Dir, "proxy.cache.dir", defaultConfig.Proxy.Cache.Dir, "directory of the disk cache store")
+	f.IntVar(&cfg.Proxy.Cache.MaxSize, "proxy.cache.maxsize", defaultConfig.Proxy.Cache.MaxSize, "max size of the cached responses in bytes")
+	f.IntVar(&cfg.Proxy.Cache.MaxEntry, "proxy.cache.maxentry", defaultConfig.Proxy.Cache.MaxEntry, "max size of a cached response body in bytes")
+	f.IntVar(&cfg.Proxy.BodyRewriteMaxBody, "proxy.bodyrewrite.maxbody", defaultConfig.Proxy.BodyRewriteMaxBody, "max size of the response bodies in bytes which are rewritten by the bodyrewrite option")
+	f.Int64Var(&cfg.Proxy.MaxBody, "proxy.maxbody", defaultConfig.Proxy.MaxBody, "max size of the request bodies in bytes. 0 means no limit")
+	f.StringVar(&cfg.Proxy.Mirror.Header, "proxy.mirror.header", defaultConfig.Proxy.Mirror.Header, "header which marks the mirrored requests")
+	f.StringSliceVar(&cfg.Proxy.Mirror.Strip, "proxy.mirror.strip", defaultConfig.Proxy.Mirror.Strip, "headers which are removed from the mirrored requests. auth and cookie remove the credentials and the cookies")
+	f.DurationVar(&cfg.Proxy.Mirror.Timeout, "proxy.mirror.timeout", defaultConfig.Proxy.Mirror.Timeout, "timeout for the mirrored requests")
+	f.Int64Var(&cfg.Proxy.Mirror.MaxBody, "proxy.mirror.maxbody", defaultConfig.Proxy.Mirror.MaxBody, "max size of the request bodies in bytes which are mirrored")
+	f.IntVar(&cfg.Proxy.Mirror.MaxConn, "proxy.mirror.maxconn", defaultConfig.Proxy.Mirror.MaxConn, "max number of mirrored requests in flight")
+	f.StringVar(&rateLimitValue, "proxy.ratelimit", "", "global rate limit for HTTP requests, e.g. 1000r/s")
+	f.IntVar(&cfg.Proxy.RateLimit.Burst, "proxy.ratelimit.burst", defaultConfig.Proxy.RateLimit.Burst, "max number of requests above the global rate limit which are allowed at once")
+	f.StringVar(&cfg.Proxy.RateLimit.Body, "proxy.ratelimit.body", defaultConfig.Proxy.RateLimit.Body, "body of the response for rate limited requests")
+	f.StringVar(&cfg.Proxy.StickySecret, "proxy.sticky.secret", defaultConfig.Proxy.StickySecret, "key which signs the sticky session cookies. A random key is used if empty")
+	f.StringVar(&cfg.Proxy.DebugHeader, "proxy.debug.header", defaultConfig.Proxy.DebugHeader, "header with a debug token which routes a request to a specific target. Disabled if empty")
+	f.StringVar(&cfg.Proxy.DebugSecret, "proxy.debug.secret", defaultConfig.Proxy.DebugSecret, "key which signs the debug tokens")
+	f.DurationVar(&cfg.Proxy.DebugMaxTTL, "proxy.debug.maxttl", defaultConfig.Proxy.DebugMaxTTL, "maximum lifetime of a debug token")
+	f.StringVar(&cfg.Proxy.TraceHeader, "proxy.trace.header", defaultConfig.Proxy.TraceHeader, "header with a trace token which enables the tracing of the route lookup. Disabled if empty")
+	f.StringVar(&cfg.Proxy.TraceSecret, "proxy.trace.secret", defaultConfig.Proxy.TraceSecret, "key which signs the trace tokens")
+	f.DurationVar(&cfg.Proxy.TraceMaxTTL, "proxy.trace.maxttl", defaultConfig.Proxy.TraceMaxTTL, "maximum lifetime of a trace token")
+	f.IntVar(&cfg.Proxy.KillSwitch.Status, "proxy.killswitch.status", defaultConfig.Proxy.KillSwitch.Status, "default status code of the response for services with an enabled kill switch")
+	f.StringVar(&cfg.Proxy.KillSwitch.ContentType, "proxy.killswitch.contenttype", defaultConfig.Proxy.KillSwitch.ContentType, "default content type of the response for services with an enabled kill switch")
+	f.StringVar(&cfg.Proxy.KillSwitch.Body, "proxy.killswitch.body", defaultConfig.Proxy.KillSwitch.Body, "default body of the response for services with an enabled kill switch")
+	f.DurationVar(&cfg.Proxy.KillSwitch.TTL, "proxy.killswitch.ttl", defaultConfig.Proxy.KillSwitch.TTL, "default time after which a kill switch expires")
+	f.Float64Var(&cfg.Proxy.Outlier.Threshold, "proxy.outlier.threshold", defaultConfig.Proxy.Outlier.Threshold, "share of failed requests above which a target is ejected. 0 disables the outlier detection")
+	f.IntVar(&cfg.Proxy.Outlier.MinRequests, "proxy.outlier.minrequests", defaultConfig.Proxy.Outlier.MinRequests, "minimum number of requests within proxy.outlier.window before a target can be ejected")
+	f.DurationVar(&cfg.Proxy.Outlier.Window, "proxy.outlier.window", defaultConfig.Proxy.Outlier.Window, "time window in which the requests to a target are counted")
+	f.DurationVar(&cfg.Proxy.Outlier.Ejection, "proxy.outlier.ejection", defaultConfig.Proxy.Outlier.Ejection, "time a target is ejected for the first time")
+	f.Float64Var(&cfg.Proxy.Outlier.MaxEjected, "proxy.outlier.maxejected", defaultConfig.Proxy.Outlier.MaxEjected, "maximum share of the targets of a route which are ejected")
+	f.DurationVar(&cfg.Proxy.Outlier.RampUp, "proxy.outlier.rampup", defaultConfig.Proxy.Outlier.RampUp, "time in which the weight of an ejected target is restored")
+	f.DurationVar(&cfg.Proxy.Outlier.Latency, "proxy.outlier.latency", defaultConfig.Proxy.Outlier.Latency, "response time above which a request counts as failed. 0 disables the latency check")
+	f.Float64Var(&cfg.Proxy.Failover.Threshold, "proxy.failover.threshold", defaultConfig.Proxy.Failover.Threshold, "share of healthy targets of the active region below which the traffic moves to the next region")
+	f.Float64Var(&cfg.Proxy.Failover.Recover, "proxy.failover.recover", defaultConfig.Proxy.Failover.Recover, "share of healthy targets a more preferred region needs before the traffic moves back")
+	f.DurationVar(&cfg.Proxy.Failover.Hold, "proxy.failover.hold", defaultConfig.Proxy.Failover.Hold, "time a more preferred region must be healthy before the traffic moves back")
+	f.StringSliceVar(&cfg.Proxy.Middleware, "proxy.middleware", defaultConfig.Proxy.Middleware, "ordered list of middlewares which process the requests before they are forwarded, or none")
+	f.StringSliceVar(&cfg.Proxy.Capture.Redact, "proxy.capture.redact", defaultConfig.Proxy.Capture.Redact, "names of headers, cookies, query parameters and body fields which are redacted in the capture file")
 	f.StringVar(&listenerValue, "proxy.addr", defaultValues.ListenerValue, "listener config")
 	f.StringVar(&certSourcesValue, "proxy.cs", defaultValues.CertSourcesValue, "certificate sources")
 	f.DurationVar(&readTimeout, "proxy.readtimeout", defaultValues.ReadTimeout, "read timeout for incoming requests")
@@ -147,17 +212,44 @@ func load(cmdline, environ, envprefix []string, props *properties.Properties) (c
 	f.DurationVar(&cfg.Proxy.GlobalFlushInterval, "proxy.globalflushinterval", defaultConfig.Proxy.GlobalFlushInterval, "flush interval for non-streaming responses")
 	f.StringVar(&authSchemesValue, "proxy.auth", defaultValues.AuthSchemesValue, "auth schemes")
 	f.StringVar(&cfg.Log.AccessFormat, "log.access.format", defaultConfig.Log.AccessFormat, "access log format")
+	f.StringSliceVar(&cfg.Log.AccessJSONFields, "log.access.json.fields", defaultConfig.Log.AccessJSONFields, "fields of the access log if log.access.format is json")
 	f.StringVar(&cfg.Log.AccessTarget, "log.access.target", defaultConfig.Log.AccessTarget, "access log target")
+	f.IntVar(&cfg.Log.AccessFile.MaxSize, "log.access.file.maxsize", defaultConfig.Log.AccessFile.MaxSize, "size in MB after which the access log file is rotated. 0 disables the size-based rotation")
+	f.DurationVar(&cfg.Log.AccessFile.Interval, "log.access.file.interval", defaultConfig.Log.AccessFile.Interval, "time after which the access log file is rotated. 0 disables the time-based rotation")
+	f.IntVar(&cfg.Log.AccessFile.MaxBackups, "log.access.file.maxbackups", defaultConfig.Log.AccessFile.MaxBackups, "number of rotated access log files which are kept. 0 keeps all files")
+	f.BoolVar(&cfg.Log.AccessFile.Compress, "log.access.file.compress", defaultConfig.Log.AccessFile.Compress, "compress rotated access log files with gzip")
 	f.StringVar(&cfg.Log.RoutesFormat, "log.routes.format", defaultConfig.Log.RoutesFormat, "log format of routing table updates")
+	f.StringVar(&cfg.Log.RoutesHook, "log.routes.webhook", defaultConfig.Log.RoutesHook, "URL which receives routing table updates as JSON")
+	f.IntVar(&cfg.Log.RoutesMax, "log.routes.max", defaultConfig.Log.RoutesMax, "maximum number of routes for which routing table updates are logged in detail. 0 means no limit")
 	f.StringVar(&cfg.Log.Level, "log.level", defaultConfig.Log.Level, "log level: TRACE, DEBUG, INFO, WARN, ERROR, FATAL")
+	f.StringVar(&cfg.Log.Target, "log.target", defaultConfig.Log.Target, "application log target: stderr, stdout or syslog")
+	f.StringVar(&cfg.Log.Format, "log.format", defaultConfig.Log.Format, "application log format: text or json")
+	f.BoolVar(&cfg.Log.Banner, "log.banner", defaultConfig.Log.Banner, "log the runtime config at startup")
+	f.StringVar(&cfg.Log.Syslog.Addr, "log.syslog.addr", defaultConfig.Log.Syslog.Addr, "address of the syslog server: udp://host:port, tcp://host:port, tls://host:port or unix:///path. The local syslog socket is used if empty")
+	f.StringVar(&cfg.Log.Syslog.Facility, "log.syslog.facility", defaultConfig.Log.Syslog.Facility, "syslog facility")
+	f.StringVar(&cfg.Log.Syslog.Tag, "log.syslog.tag", defaultConfig.Log.Syslog.Tag, "syslog tag")
+	f.StringVar(&cfg.Log.Syslog.TLSCAFile, "log.syslog.tls.cafile", defaultConfig.Log.Syslog.TLSCAFile, "path to the CA certificates of the syslog server for tls:// addresses")
+	f.StringSliceVar(&cfg.Log.Redact.Headers, "log.redact.headers", defaultConfig.Log.Redact.Headers, "names of headers which are redacted in logs, traces and captures")
+	f.StringSliceVar(&cfg.Log.Redact.Query, "log.redact.query", defaultConfig.Log.Redact.Query, "names of query parameters which are redacted in logs, traces and captures")
+	f.StringSliceVar(&cfg.Log.Redact.Cookies, "log.redact.cookies", defaultConfig.Log.Redact.Cookies, "names of cookies which are redacted in logs, traces and captures")
+	f.StringVar(&cfg.Log.NoRoute.Target, "log.noroute.target", defaultConfig.Log.NoRoute.Target, "log target for requests without a route: stdout or stderr")
+	f.Float64Var(&cfg.Log.NoRoute.Sample, "log.noroute.sample", defaultConfig.Log.NoRoute.Sample, "share of the requests without a route which are logged")
+	f.IntVar(&cfg.Log.NoRoute.TopK, "log.noroute.topk", defaultConfig.Log.NoRoute.TopK, "number of hosts and paths without a route which are counted. 0 disables the statistics")
 	f.StringVar(&cfg.Metrics.Target, "metrics.target", defaultConfig.Metrics.Target, "metrics backend")
 	f.StringVar(&cfg.Metrics.Prefix, "metrics.prefix", defaultConfig.Metrics.Prefix, "prefix for reported metrics")
 	f.StringVar(&cfg.Metrics.Names, "metrics.names", defaultConfig.Metrics.Names, "route metric name template")
 	f.DurationVar(&cfg.Metrics.Interval, "metrics.interval", defaultConfig.Metrics.Interval, "metrics reporting interval")
 	f.DurationVar(&cfg.Metrics.Timeout, "metrics.timeout", defaultConfig.Metrics.Timeout, "timeout for metrics to become available")
 	f.DurationVar(&cfg.Metrics.Retry, "metrics.retry", defaultConfig.Metrics.Retry, "retry interval during startup")
+	f.DurationVar(&cfg.Metrics.GCGrace, "metrics.gc.grace", defaultConfig.Metrics.GCGrace, "time to keep the metrics of removed targets before unregistering them")
+	f.IntVar(&cfg.Metrics.TLSSNITopK, "metrics.tls.sni.topk", defaultConfig.Metrics.TLSSNITopK, "number of SNI host names with a TLS handshake counter per listener")
+	f.BoolVar(&cfg.Metrics.UserAgent, "metrics.useragent", defaultConfig.Metrics.UserAgent, "count the HTTP requests per route by user agent family")
 	f.StringVar(&cfg.Metrics.GraphiteAddr, "metrics.graphite.addr", defaultConfig.Metrics.GraphiteAddr, "graphite server address")
 	f.StringVar(&cfg.Metrics.StatsDAddr, "metrics.statsd.addr", defaultConfig.Metrics.StatsDAddr, "statsd server address")
+	f.StringVar(&cfg.Metrics.Prometheus.Addr, "metrics.prometheus.addr", defaultConfig.Metrics.Prometheus.Addr, "listen address for the Prometheus scrape endpoint")
+	f.StringVar(&cfg.Metrics.Prometheus.Namespace, "metrics.prometheus.namespace", defaultConfig.Metrics.Prometheus.Namespace, "namespace of the Prometheus metrics")
+	f.StringVar(&promLabelsValue, "metrics.prometheus.labels", "", "constant labels of the Prometheus metrics, e.g. 'env=prod,dc=dc1'")
+	f.StringVar(&promBucketsValue, "metrics.prometheus.buckets", "", "histogram buckets in seconds for Prometheus timers. Timers are summaries if empty")
 	f.StringVar(&cfg.Metrics.Circonus.APIKey, "metrics.circonus.apikey", defaultConfig.Metrics.Circonus.APIKey, "Circonus API token key")
 	f.StringVar(&cfg.Metrics.Circonus.APIApp, "metrics.circonus.apiapp", defaultConfig.Metrics.Circonus.APIApp, "Circonus API token app")
 	f.StringVar(&cfg.Metrics.Circonus.APIURL, "metrics.circonus.apiurl", defaultConfig.Metrics.Circonus.APIURL, "Circonus API URL")
@@ -169,12 +261,30 @@ func load(cmdline, environ, envprefix []string, props *properties.Properties) (c
 	f.DurationVar(&cfg.Registry.Retry, "registry.retry", defaultConfig.Registry.Retry, "retry interval during startup")
 	f.StringVar(&cfg.Registry.File.RoutesPath, "registry.file.path", defaultConfig.Registry.File.RoutesPath, "path to file based routing table")
 	f.StringVar(&cfg.Registry.File.NoRouteHTMLPath, "registry.file.noroutehtmlpath", defaultConfig.Registry.File.NoRouteHTMLPath, "path to file for HTML returned when no route is found")
+	f.DurationVar(&cfg.Registry.File.Refresh, "registry.file.refresh", defaultConfig.Registry.File.Refresh, "reload interval for the file based routing table")
+	f.StringVar(&cfg.Registry.Replay.Path, "registry.replay.path", defaultConfig.Registry.Replay.Path, "path to the recording of registry updates to replay")
+	f.Float64Var(&cfg.Registry.Replay.Speed, "registry.replay.speed", defaultConfig.Registry.Replay.Speed, "speed factor of the replay. 0 replays without delays")
+	f.DurationVar(&cfg.Registry.Debounce.Window, "registry.debounce", defaultConfig.Registry.Debounce.Window, "quiet period after a registry update before the routing table is rebuilt. 0 disables the debounce")
+	f.DurationVar(&cfg.Registry.Debounce.Max, "registry.debounce.max", defaultConfig.Registry.Debounce.Max, "maximum delay of a routing table rebuild by the debounce")
+	f.IntVar(&cfg.Registry.Flap.Threshold, "registry.flap.threshold", defaultConfig.Registry.Flap.Threshold, "number of changes within registry.flap.window after which a target is flapping. 0 disables the detection")
+	f.DurationVar(&cfg.Registry.Flap.Window, "registry.flap.window", defaultConfig.Registry.Flap.Window, "time window in which the changes of a target are counted")
+	f.DurationVar(&cfg.Registry.Flap.Hold, "registry.flap.hold", defaultConfig.Registry.Flap.Hold, "time a flapping target must not change before its weight is restored")
+	f.Float64Var(&cfg.Registry.Flap.Weight, "registry.flap.weight", defaultConfig.Registry.Flap.Weight, "factor by which the weight of flapping targets is reduced")
+	f.StringVar(&cfg.Registry.RecordPath, "registry.record.path", defaultConfig.Registry.RecordPath, "path to the file the registry updates are recorded to")
 	f.StringVar(&cfg.Registry.Static.Routes, "registry.static.routes", defaultConfig.Registry.Static.Routes, "static routes")
 	f.StringVar(&cfg.Registry.Static.NoRouteHTML, "registry.static.noroutehtml", defaultConfig.Registry.Static.NoRouteHTML, "HTML which is returned when no route is found")
 	f.StringVar(&cfg.Registry.Consul.Addr, "registry.consul.addr", defaultConfig.Registry.Consul.Addr, "address of the consul agent")
 	f.StringVar(&cfg.Registry.Consul.Token, "registry.consul.token", defaultConfig.Registry.Consul.Token, "token for consul agent")
+	f.StringVar(&cfg.Registry.Consul.TokenServices, "registry.consul.token.services", defaultConfig.Registry.Consul.TokenServices, "token for the consul service watch")
+	f.StringVar(&cfg.Registry.Consul.TokenKV, "registry.consul.token.kv", defaultConfig.Registry.Consul.TokenKV, "token for the consul KV watches")
+	f.StringVar(&cfg.Registry.Consul.TokenRegister, "registry.consul.token.register", defaultConfig.Registry.Consul.TokenRegister, "token for registering fabio in consul")
+	f.DurationVar(&cfg.Registry.Consul.TokenRefresh, "registry.consul.token.refresh", defaultConfig.Registry.Consul.TokenRefresh, "interval for re-reading consul tokens from env, file or vault")
 	f.StringVar(&cfg.Registry.Consul.KVPath, "registry.consul.kvpath", defaultConfig.Registry.Consul.KVPath, "consul KV path for manual overrides")
 	f.StringVar(&cfg.Registry.Consul.NoRouteHTMLPath, "registry.consul.noroutehtmlpath", defaultConfig.Registry.Consul.NoRouteHTMLPath, "consul KV path for HTML returned when no route is found")
+	f.StringVar(&cfg.Registry.Consul.RolloutPath, "registry.consul.rolloutpath", defaultConfig.Registry.Consul.RolloutPath, "consul KV path for gradual rollouts")
+	f.DurationVar(&cfg.Registry.Consul.RolloutDuration, "registry.consul.rollout.duration", defaultConfig.Registry.Consul.RolloutDuration, "default duration of a gradual rollout")
+	f.DurationVar(&cfg.Registry.Consul.RolloutStep, "registry.consul.rollout.step", defaultConfig.Registry.Consul.RolloutStep, "interval for updating the weights during a rollout")
+	f.StringVar(&cfg.Registry.Consul.FlagsPath, "registry.consul.flagspath", defaultConfig.Registry.Consul.FlagsPath, "consul KV path for feature flags")
 	f.StringVar(&cfg.Registry.Consul.TagPrefix, "registry.consul.tagprefix", defaultConfig.Registry.Consul.TagPrefix, "prefix for consul tags")
 	f.StringVar(&cfg.Registry.Consul.TLS.KeyFile, "registry.consul.tls.keyfile", defaultConfig.Registry.Consul.TLS.KeyFile, "path to consul key file")
 	f.StringVar(&cfg.Registry.Consul.TLS.CertFile, "registry.consul.tls.certfile", defaultConfig.Registry.Consul.TLS.CertFile, "path to consul cert file")
@@ -193,12 +303,33 @@ func load(cmdline, environ, envprefix []string, props *properties.Properties) (c
 	f.StringVar(&cfg.Registry.Consul.ChecksRequired, "registry.consul.checksRequired", defaultConfig.Registry.Consul.ChecksRequired, "number of checks which must pass: one or all")
 	f.IntVar(&cfg.Registry.Consul.ServiceMonitors, "registry.consul.serviceMonitors", defaultConfig.Registry.Consul.ServiceMonitors, "concurrency for route updates")
 	f.DurationVar(&cfg.Registry.Consul.PollInterval, "registry.consul.pollinterval", defaultConfig.Registry.Consul.PollInterval, "poll interval for route updates")
+	f.StringVar(&cfg.Registry.Consul.AliasDomain, "registry.consul.register.aliasDomain", defaultConfig.Registry.Consul.AliasDomain, "DNS domain for validating the hosts of routes with the register option")
 	f.IntVar(&cfg.Runtime.GOGC, "runtime.gogc", defaultConfig.Runtime.GOGC, "sets runtime.GOGC")
 	f.IntVar(&cfg.Runtime.GOMAXPROCS, "runtime.gomaxprocs", defaultConfig.Runtime.GOMAXPROCS, "sets runtime.GOMAXPROCS")
+	f.BoolVar(&cfg.Runtime.NoFileRaise, "runtime.nofile.raise", defaultConfig.Runtime.NoFileRaise, "raise the soft limit of open files to the hard limit at startup")
+	f.Float64Var(&cfg.Runtime.NoFileWarn, "runtime.nofile.warn", defaultConfig.Runtime.NoFileWarn, "share of the open file limit in use above which a warning is logged. 0 disables the check")
+	f.StringVar(&cfg.Snapshot.Target, "snapshot.target", defaultConfig.Snapshot.Target, "directory or s3://bucket/prefix URL which receives the state snapshots. Empty disables the snapshots")
+	f.DurationVar(&cfg.Snapshot.Interval, "snapshot.interval", defaultConfig.Snapshot.Interval, "interval of the state snapshots")
+	f.IntVar(&cfg.Snapshot.Keep, "snapshot.keep", defaultConfig.Snapshot.Keep, "number of snapshots kept in a local directory. 0 keeps all")
+	f.StringVar(&cfg.HA.Mode, "ha.mode", defaultConfig.HA.Mode, "active-standby mode: consul. Empty disables the active-standby mode")
+	f.StringVar(&cfg.HA.LockKey, "ha.lock.key", defaultConfig.HA.LockKey, "consul key of the lock which is held by the active instance")
+	f.DurationVar(&cfg.HA.LockTTL, "ha.lock.ttl", defaultConfig.HA.LockTTL, "TTL of the lock session after which the standby takes over")
+	f.StringVar(&cfg.HA.Promote, "ha.hook.promote", defaultConfig.HA.Promote, "command which is run when the instance becomes active")
+	f.StringVar(&cfg.HA.Demote, "ha.hook.demote", defaultConfig.HA.Demote, "command which is run when the instance goes back to standby")
+	f.DurationVar(&cfg.HA.HookTimeout, "ha.hook.timeout", defaultConfig.HA.HookTimeout, "maximum duration of the ha hooks")
 	f.StringVar(&cfg.UI.Access, "ui.access", defaultConfig.UI.Access, "access mode, one of [ro, rw]")
 	f.StringVar(&uiListenerValue, "ui.addr", defaultValues.UIListenerValue, "Address the UI/API is listening on")
+	f.BoolVar(&cfg.UI.Metrics, "ui.metrics", defaultConfig.UI.Metrics, "serve the metrics in the Prometheus exposition format on /metrics of the UI")
+	f.BoolVar(&cfg.UI.LocalOnly, "ui.localonly", defaultConfig.UI.LocalOnly, "serve the UI/API only on a unix socket or on loopback with TLS client auth")
 	f.StringVar(&cfg.UI.Color, "ui.color", defaultConfig.UI.Color, "background color of the UI")
 	f.StringVar(&cfg.UI.Title, "ui.title", defaultConfig.UI.Title, "optional title for the UI")
+	f.StringVar(&cfg.UI.PagesDir, "ui.pages.dir", defaultConfig.UI.PagesDir, "directory with custom markdown and HTML pages for the UI")
+	f.StringVar(&cfg.UI.Mount, "ui.mount", defaultConfig.UI.Mount, "path prefix under which the admin API is also served on the HTTP proxy listeners, e.g. '/_fabio'. Disabled if empty")
+	f.BoolVar(&cfg.UI.MountRW, "ui.mount.rw", defaultConfig.UI.MountRW, "serve the read-write API under ui.mount. Requires ui.access = rw")
+	f.IntVar(&cfg.UI.PageSize, "ui.pagesize", defaultConfig.UI.PageSize, "number of routes per page of the UI and API when the routing table is larger")
+	f.StringVar(&cfg.UI.Group, "ui.group", defaultConfig.UI.Group, "option or tag key by which the routes are grouped and colored in the UI, e.g. 'env'")
+	f.StringSliceVar(&cfg.UI.Peers, "ui.peers", defaultConfig.UI.Peers, "admin URLs of the other fabio instances whose routing tables are compared in the fleet view, e.g. http://10.0.0.2:9998")
+	f.StringVar(&uiGroupColorsValue, "ui.group.colors", "", "colors of the route groups in the UI, e.g. 'prod=red,staging=amber'")
 	f.StringVar(&cfg.ProfileMode, "profile.mode", defaultConfig.ProfileMode, "enable profiling mode, one of [cpu, mem, mutex, block, trace]")
 	f.StringVar(&cfg.ProfilePath, "profile.path", defaultConfig.ProfilePath, "path to profile dump file")
 	f.BoolVar(&cfg.Tracing.TracingEnabled, "tracing.TracingEnabled", defaultConfig.Tracing.TracingEnabled, "Enable/Disable OpenTrace, one of [true, false]")
@@ -210,6 +341,11 @@ func load(cmdline, environ, envprefix []string, props *properties.Properties) (c
 	f.Float64Var(&cfg.Tracing.SamplerRate, "tracing.SamplerRate", defaultConfig.Tracing.SamplerRate, "OpenTrace sample rate percentage in decimal form")
 	f.StringVar(&cfg.Tracing.SpanHost, "tracing.SpanHost", defaultConfig.Tracing.SpanHost, "Host:Port info to add to spans")
 	f.BoolVar(&cfg.Tracing.TraceID128Bit, "tracing.TraceID128Bit", defaultConfig.Tracing.TraceID128Bit, "Generate 128 bit trace IDs")
+	f.StringVar(&cfg.Tracing.OTLP.Protocol, "tracing.otlp.protocol", defaultConfig.Tracing.OTLP.Protocol, "OTLP protocol, one of [http, grpc]")
+	f.StringVar(&cfg.Tracing.OTLP.Endpoint, "tracing.otlp.endpoint", defaultConfig.Tracing.OTLP.Endpoint, "OTLP collector endpoint URL")
+	f.StringVar(&cfg.Tracing.OTLP.Sampler, "tracing.otlp.sampler", defaultConfig.Tracing.OTLP.Sampler, "OTLP sampler, one of [always_on, always_off, traceidratio, parentbased_traceidratio]")
+	f.StringVar(&otlpResourceValue, "tracing.otlp.resource", "", "OTLP resource attributes, e.g. 'deployment.environment=prod,host.name=lb1'")
+	f.StringVar(&otlpHeadersValue, "tracing.otlp.headers", "", "headers for the requests to the OTLP collector, e.g. 'api-key=secret'")
 	f.BoolVar(&cfg.GlobMatchingDisabled, "glob.matching.disabled", defaultConfig.GlobMatchingDisabled, "Disable Glob Matching on routes, one of [true, false]")
 	f.IntVar(&cfg.GlobCacheSize, "glob.cache.size", defaultConfig.GlobCacheSize, "sets the size of the glob cache")
 
@@ -221,6 +357,43 @@ func load(cmdline, environ, envprefix []string, props *properties.Properties) (c
 	f.DurationVar(&cfg.Registry.Custom.PollInterval, "registry.custom.pollinterval", defaultConfig.Registry.Custom.PollInterval, "poll interval for API request to custom back end")
 	f.StringVar(&cfg.Registry.Custom.Path, "registry.custom.path", defaultConfig.Registry.Custom.Path, "custom back end path in the URL")
 	f.StringVar(&cfg.Registry.Custom.QueryParams, "registry.custom.queryparams", defaultConfig.Registry.Custom.QueryParams, "custom back end query parameters in the URL")
+	f.StringVar(&cfg.Registry.Kubernetes.Kubeconfig, "registry.kubernetes.kubeconfig", defaultConfig.Registry.Kubernetes.Kubeconfig, "path to the kubeconfig file. Uses the service account of the pod if empty")
+	f.StringVar(&cfg.Registry.Kubernetes.Context, "registry.kubernetes.context", defaultConfig.Registry.Kubernetes.Context, "kubeconfig context. Uses the current context if empty")
+	f.StringVar(&cfg.Registry.Kubernetes.Namespace, "registry.kubernetes.namespace", defaultConfig.Registry.Kubernetes.Namespace, "namespace to watch. Watches all namespaces if empty")
+	f.StringVar(&cfg.Registry.Kubernetes.Selector, "registry.kubernetes.selector", defaultConfig.Registry.Kubernetes.Selector, "label selector for the services, e.g. 'app=web,tier!=db'")
+	f.StringVar(&cfg.Registry.Kubernetes.Prefix, "registry.kubernetes.prefix", defaultConfig.Registry.Kubernetes.Prefix, "prefix of the service annotations which contain the routes")
+	f.BoolVar(&cfg.Registry.Kubernetes.Ingress, "registry.kubernetes.ingress", defaultConfig.Registry.Kubernetes.Ingress, "build routes from ingress rules")
+	f.StringVar(&cfg.Registry.Kubernetes.IngressClass, "registry.kubernetes.ingressclass", defaultConfig.Registry.Kubernetes.IngressClass, "ingress class of the ingresses which are routed by fabio. Routes all ingresses if empty")
+	f.StringVar(&cfg.Registry.Nomad.Addr, "registry.nomad.addr", defaultConfig.Registry.Nomad.Addr, "URL of the nomad agent")
+	f.StringVar(&cfg.Registry.Nomad.Token, "registry.nomad.token", defaultConfig.Registry.Nomad.Token, "ACL token for the nomad agent")
+	f.StringVar(&cfg.Registry.Nomad.Namespace, "registry.nomad.namespace", defaultConfig.Registry.Nomad.Namespace, "nomad namespace of the services. Use '*' for all namespaces")
+	f.StringVar(&cfg.Registry.Nomad.Region, "registry.nomad.region", defaultConfig.Registry.Nomad.Region, "nomad region of the services. Uses the region of the agent if empty")
+	f.StringVar(&cfg.Registry.Nomad.TagPrefix, "registry.nomad.tagprefix", defaultConfig.Registry.Nomad.TagPrefix, "prefix for nomad service tags which contain the routes")
+	f.DurationVar(&cfg.Registry.Nomad.WaitTime, "registry.nomad.waittime", defaultConfig.Registry.Nomad.WaitTime, "max time a blocking query waits for changes")
+	f.StringVar(&cfg.Registry.Nomad.TLS.KeyFile, "registry.nomad.tls.keyfile", defaultConfig.Registry.Nomad.TLS.KeyFile, "path to nomad client key file")
+	f.StringVar(&cfg.Registry.Nomad.TLS.CertFile, "registry.nomad.tls.certfile", defaultConfig.Registry.Nomad.TLS.CertFile, "path to nomad client cert file")
+	f.StringVar(&cfg.Registry.Nomad.TLS.CAFile, "registry.nomad.tls.cafile", defaultConfig.Registry.Nomad.TLS.CAFile, "path to nomad CA file")
+	f.BoolVar(&cfg.Registry.Nomad.TLS.InsecureSkipVerify, "registry.nomad.tls.insecureskipverify", defaultConfig.Registry.Nomad.TLS.InsecureSkipVerify, "skip verification of the nomad server certificate")
+	f.StringVar(&cfg.Registry.Etcd.Addr, "registry.etcd.addr", defaultConfig.Registry.Etcd.Addr, "comma separated list of etcd endpoint URLs")
+	f.StringVar(&cfg.Registry.Etcd.Username, "registry.etcd.username", defaultConfig.Registry.Etcd.Username, "etcd user name. Authentication is disabled if empty")
+	f.StringVar(&cfg.Registry.Etcd.Password, "registry.etcd.password", defaultConfig.Registry.Etcd.Password, "etcd password")
+	f.StringVar(&cfg.Registry.Etcd.RoutesPath, "registry.etcd.routespath", defaultConfig.Registry.Etcd.RoutesPath, "etcd key prefix for the routes")
+	f.StringVar(&cfg.Registry.Etcd.KVPath, "registry.etcd.kvpath", defaultConfig.Registry.Etcd.KVPath, "etcd key prefix for the manual overrides")
+	f.StringVar(&cfg.Registry.Etcd.NoRouteHTMLPath, "registry.etcd.noroutehtmlpath", defaultConfig.Registry.Etcd.NoRouteHTMLPath, "etcd key for the HTML returned when no route is found")
+	f.StringVar(&cfg.Registry.Etcd.TLS.KeyFile, "registry.etcd.tls.keyfile", defaultConfig.Registry.Etcd.TLS.KeyFile, "path to etcd client key file")
+	f.StringVar(&cfg.Registry.Etcd.TLS.CertFile, "registry.etcd.tls.certfile", defaultConfig.Registry.Etcd.TLS.CertFile, "path to etcd client cert file")
+	f.StringVar(&cfg.Registry.Etcd.TLS.CAFile, "registry.etcd.tls.cafile", defaultConfig.Registry.Etcd.TLS.CAFile, "path to etcd CA file")
+	f.BoolVar(&cfg.Registry.Etcd.TLS.InsecureSkipVerify, "registry.etcd.tls.insecureskipverify", defaultConfig.Registry.Etcd.TLS.InsecureSkipVerify, "skip verification of the etcd server certificate")
+	f.StringVar(&cfg.Registry.Zookeeper.Addr, "registry.zookeeper.addr", defaultConfig.Registry.Zookeeper.Addr, "comma separated list of zookeeper servers as host:port")
+	f.DurationVar(&cfg.Registry.Zookeeper.SessionTimeout, "registry.zookeeper.sessiontimeout", defaultConfig.Registry.Zookeeper.SessionTimeout, "zookeeper session timeout")
+	f.StringVar(&cfg.Registry.Zookeeper.ServicesPath, "registry.zookeeper.servicespath", defaultConfig.Registry.Zookeeper.ServicesPath, "znode of the service registrations")
+	f.StringVar(&cfg.Registry.Zookeeper.TagPrefix, "registry.zookeeper.tagprefix", defaultConfig.Registry.Zookeeper.TagPrefix, "prefix for the tags of the service registrations which contain the routes")
+	f.StringVar(&cfg.Registry.Zookeeper.RoutesPath, "registry.zookeeper.routespath", defaultConfig.Registry.Zookeeper.RoutesPath, "znode of the routes")
+	f.StringVar(&cfg.Registry.Zookeeper.KVPath, "registry.zookeeper.kvpath", defaultConfig.Registry.Zookeeper.KVPath, "znode of the manual overrides")
+	f.StringVar(&cfg.Registry.Zookeeper.NoRouteHTMLPath, "registry.zookeeper.noroutehtmlpath", defaultConfig.Registry.Zookeeper.NoRouteHTMLPath, "znode of the HTML returned when no route is found")
+	f.BoolVar(&cfg.Registry.Zookeeper.Register, "registry.zookeeper.register.enabled", defaultConfig.Registry.Zookeeper.Register, "register fabio in zookeeper")
+	f.StringVar(&cfg.Registry.Zookeeper.ServiceName, "registry.zookeeper.register.name", defaultConfig.Registry.Zookeeper.ServiceName, "service registration name")
+	f.StringVar(&cfg.Registry.Zookeeper.ServiceAddr, "registry.zookeeper.register.addr", defaultConfig.Registry.Zookeeper.ServiceAddr, "service registration address")
 
 	// deprecated flags
 	var proxyLogRoutes string
@@ -254,6 +427,13 @@ func load(cmdline, environ, envprefix []string, props *properties.Properties) (c
 
 	cfg.Proxy.AuthSchemes = authSchemes
 
+	if err := validCertPrefer(cfg.Proxy.TLSCertPrefer); err != nil {
+		return nil, err
+	}
+	if err := validNoSNI(cfg.Proxy.TLSNoSNI); err != nil {
+		return nil, err
+	}
+
 	if uiListenerValue != "" {
 		kvs, err := parseKVSlice(uiListenerValue)
 		if err != nil {
@@ -267,6 +447,11 @@ func load(cmdline, environ, envprefix []string, props *properties.Properties) (c
 			return nil, err
 		}
 	}
+	if cfg.UI.LocalOnly {
+		if err := checkLocalListener(cfg.UI.Listen); err != nil {
+			return nil, fmt.Errorf("ui.localonly: %s", err)
+		}
+	}
 
 	cfg.Listen, err = parseListeners(listenerValue, certSources, readTimeout, writeTimeout)
 	if err != nil {
@@ -282,6 +467,10 @@ func load(cmdline, environ, envprefix []string, props *properties.Properties) (c
 	// Unless registry.consul.register.addr has been set explicitly it should
 	// be the same as ui.addr. See issue 657.
 	if !f.IsSet("registry.consul.register.addr") {
+		// consul cannot check the health of fabio via a unix socket
+		if strings.HasPrefix(cfg.UI.Listen.Addr, "unix:") && cfg.Registry.Backend == "consul" && cfg.Registry.Consul.Register {
+			return nil, fmt.Errorf("ui.addr is a unix socket. Set registry.consul.register.addr or disable registry.consul.register.enabled")
+		}
 		cfg.Registry.Consul.ServiceAddr = cfg.UI.Listen.Addr
 	}
 	if cfg.Registry.Consul.ServiceAddr != "" {
@@ -299,6 +488,193 @@ func load(cmdline, environ, envprefix []string, props *properties.Properties) (c
 		cfg.Registry.Consul.ServiceMonitors = 1
 	}
 
+	if promLabelsValue != "" {
+		if cfg.Metrics.Prometheus.Labels, err = parsePrometheusLabels(promLabelsValue); err != nil {
+			return nil, err
+		}
+	}
+
+	if promBucketsValue != "" {
+		if cfg.Metrics.Prometheus.Buckets, err = parsePrometheusBuckets(promBucketsValue); err != nil {
+			return nil, err
+		}
+	}
+
+	if otlpResourceValue != "" {
+		if cfg.Tracing.OTLP.Resource, err = parseKeyValues(otlpResourceValue); err != nil {
+			return nil, fmt.Errorf("invalid tracing.otlp.resource: %s", err)
+		}
+	}
+
+	if uiGroupColorsValue != "" {
+		if cfg.UI.GroupColors, err = parseGroupColors(uiGroupColorsValue); err != nil {
+			return nil, fmt.Errorf("invalid ui.group.colors: %s", err)
+		}
+	}
+
+	if otlpHeadersValue != "" {
+		if cfg.Tracing.OTLP.Headers, err = parseKeyValues(otlpHeadersValue); err != nil {
+			return nil, fmt.Errorf("invalid tracing.otlp.headers: %s", err)
+		}
+	}
+
+	switch cfg.Proxy.Capture.Format {
+	case "json", "har":
+	default:
+		return nil, fmt.Errorf("invalid proxy.capture.format: %s", cfg.Proxy.Capture.Format)
+	}
+
+	switch cfg.Proxy.Cache.Store {
+	case "memory":
+	case "disk":
+		if cfg.Proxy.Cache.Dir == "" {
+			return nil, fmt.Errorf("invalid proxy.cache.store: disk. Missing proxy.cache.dir")
+		}
+	default:
+		return nil, fmt.Errorf("invalid proxy.cache.store: %s", cfg.Proxy.Cache.Store)
+	}
+
+	if cfg.Proxy.Cache.MaxSize < 0 {
+		return nil, fmt.Errorf("invalid proxy.cache.maxsize: %d", cfg.Proxy.Cache.MaxSize)
+	}
+
+	if cfg.Proxy.Cache.MaxEntry < 0 {
+		return nil, fmt.Errorf("invalid proxy.cache.maxentry: %d", cfg.Proxy.Cache.MaxEntry)
+	}
+
+	if cfg.Proxy.Capture.Rate < 0 {
+		return nil, fmt.Errorf("invalid proxy.capture.rate: %d", cfg.Proxy.Capture.Rate)
+	}
+
+	if rateLimitValue != "" {
+		if cfg.Proxy.RateLimit.Rate, err = parseRate(rateLimitValue); err != nil {
+			return nil, fmt.Errorf("invalid proxy.ratelimit: %s", rateLimitValue)
+		}
+	}
+
+	if cfg.Registry.Replay.Speed < 0 {
+		return nil, fmt.Errorf("invalid registry.replay.speed: %g", cfg.Registry.Replay.Speed)
+	}
+	if cfg.Registry.Debounce.Window < 0 {
+		return nil, fmt.Errorf("invalid registry.debounce: %s", cfg.Registry.Debounce.Window)
+	}
+	if cfg.Registry.Debounce.Window > 0 && cfg.Registry.Debounce.Max < cfg.Registry.Debounce.Window {
+		return nil, fmt.Errorf("invalid registry.debounce.max: %s. Must not be less than registry.debounce", cfg.Registry.Debounce.Max)
+	}
+	if cfg.Registry.Flap.Threshold < 0 || cfg.Registry.Flap.Threshold == 1 {
+		return nil, fmt.Errorf("invalid registry.flap.threshold: %d. Must be 0 or at least 2", cfg.Registry.Flap.Threshold)
+	}
+	if cfg.Registry.Flap.Window <= 0 {
+		return nil, fmt.Errorf("invalid registry.flap.window: %s", cfg.Registry.Flap.Window)
+	}
+	if cfg.Registry.Flap.Hold <= 0 {
+		return nil, fmt.Errorf("invalid registry.flap.hold: %s", cfg.Registry.Flap.Hold)
+	}
+	if cfg.Registry.Flap.Weight < 0 || cfg.Registry.Flap.Weight >= 1 {
+		return nil, fmt.Errorf("invalid registry.flap.weight: %g. Must be in [0,1)", cfg.Registry.Flap.Weight)
+	}
+
+	if cfg.Registry.RecordPath != "" && cfg.Registry.Backend == "custom" {
+		return nil, fmt.Errorf("registry.record.path is not supported for the custom backend")
+	}
+
+	if cfg.Proxy.RateLimit.Burst < 0 {
+		return nil, fmt.Errorf("invalid proxy.ratelimit.burst: %d", cfg.Proxy.RateLimit.Burst)
+	}
+
+	if cfg.Proxy.Capture.MaxBody < 0 {
+		return nil, fmt.Errorf("invalid proxy.capture.maxbody: %d", cfg.Proxy.Capture.MaxBody)
+	}
+
+	if cfg.Proxy.BodyRewriteMaxBody < 0 {
+		return nil, fmt.Errorf("invalid proxy.bodyrewrite.maxbody: %d", cfg.Proxy.BodyRewriteMaxBody)
+	}
+
+	if cfg.Proxy.MaxBody < 0 {
+		return nil, fmt.Errorf("invalid proxy.maxbody: %d", cfg.Proxy.MaxBody)
+	}
+
+	if !httpguts.ValidHeaderFieldName(cfg.Proxy.Mirror.Header) {
+		return nil, fmt.Errorf("invalid proxy.mirror.header: %s", cfg.Proxy.Mirror.Header)
+	}
+
+	for _, h := range cfg.Proxy.Mirror.Strip {
+		if !httpguts.ValidHeaderFieldName(h) {
+			return nil, fmt.Errorf("invalid proxy.mirror.strip: %s", strings.Join(cfg.Proxy.Mirror.Strip, ","))
+		}
+	}
+
+	if cfg.Proxy.Mirror.Timeout <= 0 {
+		return nil, fmt.Errorf("invalid proxy.mirror.timeout: %s", cfg.Proxy.Mirror.Timeout)
+	}
+
+	if cfg.Proxy.Mirror.MaxBody < 0 {
+		return nil, fmt.Errorf("invalid proxy.mirror.maxbody: %d", cfg.Proxy.Mirror.MaxBody)
+	}
+
+	if cfg.Proxy.Mirror.MaxConn <= 0 {
+		return nil, fmt.Errorf("invalid proxy.mirror.maxconn: %d", cfg.Proxy.Mirror.MaxConn)
+	}
+
+	if u, err := url.Parse(cfg.Registry.Nomad.Addr); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid registry.nomad.addr: %s", cfg.Registry.Nomad.Addr)
+	}
+
+	if cfg.Registry.Nomad.WaitTime <= 0 {
+		return nil, fmt.Errorf("invalid registry.nomad.waittime: %s", cfg.Registry.Nomad.WaitTime)
+	}
+
+	for _, addr := range strings.Split(cfg.Registry.Etcd.Addr, ",") {
+		if u, err := url.Parse(strings.TrimSpace(addr)); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid registry.etcd.addr: %s", cfg.Registry.Etcd.Addr)
+		}
+	}
+
+	for _, addr := range strings.Split(cfg.Registry.Zookeeper.Addr, ",") {
+		if host, port, err := net.SplitHostPort(strings.TrimSpace(addr)); err != nil || host == "" || port == "" {
+			return nil, fmt.Errorf("invalid registry.zookeeper.addr: %s", cfg.Registry.Zookeeper.Addr)
+		}
+	}
+
+	if cfg.Registry.Zookeeper.SessionTimeout <= 0 {
+		return nil, fmt.Errorf("invalid registry.zookeeper.sessiontimeout: %s", cfg.Registry.Zookeeper.SessionTimeout)
+	}
+
+	for name, p := range map[string]string{
+		"registry.zookeeper.servicespath":    cfg.Registry.Zookeeper.ServicesPath,
+		"registry.zookeeper.routespath":      cfg.Registry.Zookeeper.RoutesPath,
+		"registry.zookeeper.kvpath":          cfg.Registry.Zookeeper.KVPath,
+		"registry.zookeeper.noroutehtmlpath": cfg.Registry.Zookeeper.NoRouteHTMLPath,
+	} {
+		if !strings.HasPrefix(p, "/") || (p != "/" && strings.HasSuffix(p, "/")) {
+			return nil, fmt.Errorf("invalid %s: %s", name, p)
+		}
+	}
+
+	for name, patterns := range map[string][]string{
+		"log.redact.headers": cfg.Log.Redact.Headers,
+		"log.redact.query":   cfg.Log.Redact.Query,
+		"log.redact.cookies": cfg.Log.Redact.Cookies,
+	} {
+		for _, p := range patterns {
+			if _, err := path.Match(p, ""); err != nil {
+				return nil, fmt.Errorf("invalid %s: %q is not a valid pattern", name, p)
+			}
+		}
+	}
+
+	switch cfg.Tracing.OTLP.Protocol {
+	case "http", "grpc":
+	default:
+		return nil, fmt.Errorf("invalid tracing.otlp.protocol: %s", cfg.Tracing.OTLP.Protocol)
+	}
+
+	switch cfg.Tracing.OTLP.Sampler {
+	case "always_on", "always_off", "traceidratio", "parentbased_traceidratio":
+	default:
+		return nil, fmt.Errorf("invalid tracing.otlp.sampler: %s", cfg.Tracing.OTLP.Sampler)
+	}
+
 	if gzipContentTypesValue != "" {
 		cfg.Proxy.GZIPContentTypes, err = regexp.Compile(gzipContentTypesValue)
 		if err != nil {
@@ -306,23 +682,230 @@ func load(cmdline, environ, envprefix []string, props *properties.Properties) (c
 		}
 	}
 
-	if cfg.Proxy.Strategy != "rr" && cfg.Proxy.Strategy != "rnd" {
+	if cfg.Proxy.GZIPMinSize < 0 {
+		return nil, fmt.Errorf("invalid proxy.gzip.minsize: %d", cfg.Proxy.GZIPMinSize)
+	}
+
+	if cfg.Proxy.Strategy != "rr" && cfg.Proxy.Strategy != "rnd" && cfg.Proxy.Strategy != "hash" {
 		return nil, fmt.Errorf("invalid proxy.strategy: %s", cfg.Proxy.Strategy)
 	}
 
+	if !validHashKey(cfg.Proxy.HashKey) {
+		return nil, fmt.Errorf("invalid proxy.hash.key: %s", cfg.Proxy.HashKey)
+	}
+
+	if cfg.Proxy.HashVirtualNodes < 1 {
+		return nil, fmt.Errorf("invalid proxy.hash.vnodes: %d", cfg.Proxy.HashVirtualNodes)
+	}
+
 	if cfg.Proxy.Matcher != "prefix" && cfg.Proxy.Matcher != "glob" && cfg.Proxy.Matcher != "iprefix" {
 		return nil, fmt.Errorf("invalid proxy.matcher: %s", cfg.Proxy.Matcher)
 	}
 
+	switch cfg.Proxy.UpstreamHTTP2 {
+	case "off", "h2", "h2c":
+		// ok
+	default:
+		return nil, fmt.Errorf("invalid proxy.upstream.http2: %s", cfg.Proxy.UpstreamHTTP2)
+	}
+
 	if cfg.UI.Access != "ro" && cfg.UI.Access != "rw" {
 		return nil, fmt.Errorf("invalid ui.access: %s", cfg.UI.Access)
 	}
 
+	if cfg.UI.PageSize < 1 {
+		return nil, fmt.Errorf("invalid ui.pagesize: %d", cfg.UI.PageSize)
+	}
+
+	if cfg.UI.Mount != "" {
+		cfg.UI.Mount = strings.TrimSuffix(cfg.UI.Mount, "/")
+		if !strings.HasPrefix(cfg.UI.Mount, "/") {
+			return nil, fmt.Errorf("invalid ui.mount: %s", cfg.UI.Mount)
+		}
+		if cfg.UI.LocalOnly {
+			return nil, fmt.Errorf("ui.mount cannot be used with ui.localonly")
+		}
+	}
+	if cfg.UI.MountRW && cfg.UI.Access != "rw" {
+		return nil, fmt.Errorf("ui.mount.rw requires ui.access = rw")
+	}
+
+	for i, p := range cfg.UI.Peers {
+		u, err := url.Parse(p)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid ui.peers: %s", p)
+		}
+		cfg.UI.Peers[i] = strings.TrimSuffix(p, "/")
+	}
+
+	if cfg.Metrics.GCGrace < 0 {
+		return nil, fmt.Errorf("invalid metrics.gc.grace: %s", cfg.Metrics.GCGrace)
+	}
+	if cfg.Metrics.TLSSNITopK < 0 {
+		return nil, fmt.Errorf("invalid metrics.tls.sni.topk: %d", cfg.Metrics.TLSSNITopK)
+	}
+
+	if cfg.Proxy.DrainGrace < 0 {
+		return nil, fmt.Errorf("invalid proxy.drain.grace: %s", cfg.Proxy.DrainGrace)
+	}
+	if cfg.Proxy.UpgradeTimeout <= 0 {
+		return nil, fmt.Errorf("invalid proxy.upgrade.timeout: %s", cfg.Proxy.UpgradeTimeout)
+	}
+	if cfg.Proxy.DebugHeader != "" && cfg.Proxy.DebugSecret == "" {
+		return nil, fmt.Errorf("invalid proxy.debug.header: %s. Missing proxy.debug.secret", cfg.Proxy.DebugHeader)
+	}
+	if cfg.Proxy.DebugMaxTTL <= 0 {
+		return nil, fmt.Errorf("invalid proxy.debug.maxttl: %s", cfg.Proxy.DebugMaxTTL)
+	}
+	if cfg.Proxy.TraceHeader != "" && cfg.Proxy.TraceSecret == "" {
+		return nil, fmt.Errorf("invalid proxy.trace.header: %s. Missing proxy.trace.secret", cfg.Proxy.TraceHeader)
+	}
+	if cfg.Proxy.TraceMaxTTL <= 0 {
+		return nil, fmt.Errorf("invalid proxy.trace.maxttl: %s", cfg.Proxy.TraceMaxTTL)
+	}
+	if cfg.Proxy.KillSwitch.Status < 100 || cfg.Proxy.KillSwitch.Status > 999 {
+		return nil, fmt.Errorf("invalid proxy.killswitch.status: %d", cfg.Proxy.KillSwitch.Status)
+	}
+	if cfg.Proxy.KillSwitch.TTL <= 0 {
+		return nil, fmt.Errorf("invalid proxy.killswitch.ttl: %s", cfg.Proxy.KillSwitch.TTL)
+	}
+	if cfg.Proxy.Outlier.Threshold < 0 || cfg.Proxy.Outlier.Threshold >= 1 {
+		return nil, fmt.Errorf("invalid proxy.outlier.threshold: %g. Must be in [0,1)", cfg.Proxy.Outlier.Threshold)
+	}
+	if cfg.Proxy.Outlier.MinRequests < 1 {
+		return nil, fmt.Errorf("invalid proxy.outlier.minrequests: %d", cfg.Proxy.Outlier.MinRequests)
+	}
+	if cfg.Proxy.Outlier.Window <= 0 {
+		return nil, fmt.Errorf("invalid proxy.outlier.window: %s", cfg.Proxy.Outlier.Window)
+	}
+	if cfg.Proxy.Outlier.Ejection <= 0 {
+		return nil, fmt.Errorf("invalid proxy.outlier.ejection: %s", cfg.Proxy.Outlier.Ejection)
+	}
+	if cfg.Proxy.Outlier.MaxEjected < 0 || cfg.Proxy.Outlier.MaxEjected > 1 {
+		return nil, fmt.Errorf("invalid proxy.outlier.maxejected: %g. Must be in [0,1]", cfg.Proxy.Outlier.MaxEjected)
+	}
+	if cfg.Proxy.Outlier.RampUp < 0 {
+		return nil, fmt.Errorf("invalid proxy.outlier.rampup: %s", cfg.Proxy.Outlier.RampUp)
+	}
+	if cfg.Proxy.Outlier.Latency < 0 {
+		return nil, fmt.Errorf("invalid proxy.outlier.latency: %s", cfg.Proxy.Outlier.Latency)
+	}
+	if cfg.Proxy.Failover.Threshold <= 0 || cfg.Proxy.Failover.Threshold > 1 {
+		return nil, fmt.Errorf("invalid proxy.failover.threshold: %g. Must be in (0,1]", cfg.Proxy.Failover.Threshold)
+	}
+	if cfg.Proxy.Failover.Recover < cfg.Proxy.Failover.Threshold || cfg.Proxy.Failover.Recover > 1 {
+		return nil, fmt.Errorf("invalid proxy.failover.recover: %g. Must be in [proxy.failover.threshold,1]", cfg.Proxy.Failover.Recover)
+	}
+	if cfg.Proxy.Failover.Hold < 0 {
+		return nil, fmt.Errorf("invalid proxy.failover.hold: %s", cfg.Proxy.Failover.Hold)
+	}
+	if cfg.Proxy.TimeoutMax < 0 {
+		return nil, fmt.Errorf("invalid proxy.timeout.max: %s", cfg.Proxy.TimeoutMax)
+	}
+	if cfg.Proxy.WSIdleTimeout < 0 {
+		return nil, fmt.Errorf("invalid proxy.ws.idletimeout: %s", cfg.Proxy.WSIdleTimeout)
+	}
+
+	if cfg.Log.RoutesMax < 0 {
+		return nil, fmt.Errorf("invalid log.routes.max: %d", cfg.Log.RoutesMax)
+	}
+
+	switch cfg.Log.NoRoute.Target {
+	case "", "stdout", "stderr":
+	default:
+		return nil, fmt.Errorf("invalid log.noroute.target: %s. Must be stdout or stderr", cfg.Log.NoRoute.Target)
+	}
+
+	if cfg.Runtime.NoFileWarn < 0 || cfg.Runtime.NoFileWarn > 1 {
+		return nil, fmt.Errorf("invalid runtime.nofile.warn: %g. Must be in [0,1]", cfg.Runtime.NoFileWarn)
+	}
+
+	if cfg.Snapshot.Target != "" && cfg.Snapshot.Interval <= 0 {
+		return nil, fmt.Errorf("invalid snapshot.interval: %s", cfg.Snapshot.Interval)
+	}
+
+	if cfg.Snapshot.Keep < 0 {
+		return nil, fmt.Errorf("invalid snapshot.keep: %d", cfg.Snapshot.Keep)
+	}
+
+	if strings.HasPrefix(cfg.Snapshot.Target, "s3://") {
+		if u, err := url.Parse(cfg.Snapshot.Target); err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid snapshot.target: %s. Must be a directory or s3://bucket/prefix", cfg.Snapshot.Target)
+		}
+	}
+
+	switch cfg.HA.Mode {
+	case "", "consul":
+	default:
+		return nil, fmt.Errorf("invalid ha.mode: %s. Must be consul", cfg.HA.Mode)
+	}
+
+	if cfg.HA.Mode != "" && cfg.HA.LockKey == "" {
+		return nil, errors.New("missing ha.lock.key")
+	}
+
+	// consul accepts session TTLs between 10s and 24h
+	if cfg.HA.LockTTL < 10*time.Second || cfg.HA.LockTTL > 24*time.Hour {
+		return nil, fmt.Errorf("invalid ha.lock.ttl: %s. Must be between 10s and 24h", cfg.HA.LockTTL)
+	}
+
+	if cfg.HA.HookTimeout <= 0 {
+		return nil, fmt.Errorf("invalid ha.hook.timeout: %s", cfg.HA.HookTimeout)
+	}
+
+	if cfg.Log.NoRoute.Sample <= 0 || cfg.Log.NoRoute.Sample > 1 {
+		return nil, fmt.Errorf("invalid log.noroute.sample: %g. Must be in (0,1]", cfg.Log.NoRoute.Sample)
+	}
+
+	switch cfg.Log.Target {
+	case "stderr", "stdout", "syslog":
+	default:
+		return nil, fmt.Errorf("invalid log.target: %s. Must be stderr, stdout or syslog", cfg.Log.Target)
+	}
+
+	switch cfg.Log.Format {
+	case "text", "json":
+	default:
+		return nil, fmt.Errorf("invalid log.format: %s. Must be text or json", cfg.Log.Format)
+	}
+
+	if cfg.Log.Syslog.Addr != "" && !hasSyslogScheme(cfg.Log.Syslog.Addr) {
+		return nil, fmt.Errorf("invalid log.syslog.addr: %s. Must start with udp://, tcp://, tls:// or unix://", cfg.Log.Syslog.Addr)
+	}
+
+	if cfg.Log.AccessTarget == "file:" {
+		return nil, fmt.Errorf("invalid log.access.target: %s. Missing path", cfg.Log.AccessTarget)
+	}
+
+	if cfg.Log.AccessFile.MaxSize < 0 {
+		return nil, fmt.Errorf("invalid log.access.file.maxsize: %d", cfg.Log.AccessFile.MaxSize)
+	}
+
+	if cfg.Log.AccessFile.Interval < 0 {
+		return nil, fmt.Errorf("invalid log.access.file.interval: %s", cfg.Log.AccessFile.Interval)
+	}
+
+	if cfg.Log.AccessFile.MaxBackups < 0 {
+		return nil, fmt.Errorf("invalid log.access.file.maxbackups: %d", cfg.Log.AccessFile.MaxBackups)
+	}
+
+	if cfg.Log.NoRoute.TopK < 0 {
+		return nil, fmt.Errorf("invalid log.noroute.topk: %d", cfg.Log.NoRoute.TopK)
+	}
+
 	// go1.10 will not accept a non-three digit status code
 	if cfg.Proxy.NoRouteStatus < 100 || cfg.Proxy.NoRouteStatus > 999 {
 		return nil, fmt.Errorf("proxy.noroutestatus must be between 100 and 999")
 	}
 
+	if len(cfg.Proxy.Middleware) == 1 && cfg.Proxy.Middleware[0] == "none" {
+		cfg.Proxy.Middleware = []string{}
+	}
+
+	if cfg.Proxy.NoRouteJSON != "" && !json.Valid([]byte(cfg.Proxy.NoRouteJSON)) {
+		return nil, fmt.Errorf("invalid proxy.noroute.json: %s", cfg.Proxy.NoRouteJSON)
+	}
+
 	// handle deprecations
 	deprecate := func(name, msg string) {
 		if f.IsSet(name) {
@@ -358,6 +941,29 @@ func parseScheme(s string) (scheme, addr string) {
 	return
 }
 
+// parseRate parses a rate like '100r/s', '600r/m' or '1000r/h'
+// and returns the number of requests per second.
+func parseRate(s string) (float64, error) {
+	p := strings.SplitN(s, "r/", 2)
+	if len(p) != 2 {
+		return 0, fmt.Errorf("invalid rate %q", s)
+	}
+	n, err := strconv.ParseFloat(p[0], 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid rate %q", s)
+	}
+	switch p[1] {
+	case "s":
+		return n, nil
+	case "m":
+		return n / 60, nil
+	case "h":
+		return n / 3600, nil
+	default:
+		return 0, fmt.Errorf("invalid rate %q", s)
+	}
+}
+
 func parseListeners(cfgs string, cs map[string]CertSource, readTimeout, writeTimeout time.Duration) (listen []Listen, err error) {
 	kvs, err := parseKVSlice(cfgs)
 	for _, cfg := range kvs {
@@ -386,7 +992,7 @@ func parseListen(cfg map[string]string, cs map[string]CertSource, readTimeout, w
 		case "proto":
 			l.Proto = v
 			switch l.Proto {
-			case "tcp", "tcp+sni", "tcp-dynamic", "http", "https", "grpc", "grpcs", "https+tcp+sni":
+			case "tcp", "tcp+sni", "tcp-dynamic", "udp", "http", "https", "grpc", "grpcs", "https+tcp+sni":
 				// ok
 			default:
 				return Listen{}, fmt.Errorf("unknown protocol %q", v)
@@ -421,6 +1027,16 @@ func parseListen(cfg map[string]string, cs map[string]CertSource, readTimeout, w
 			}
 		case "strictmatch":
 			l.StrictMatch = (v == "true")
+		case "certprefer":
+			if err := validCertPrefer(v); err != nil {
+				return Listen{}, err
+			}
+			l.CertPrefer = v
+		case "nosni":
+			if err := validNoSNI(v); err != nil {
+				return Listen{}, err
+			}
+			l.NoSNI = v
 		case "tlsmin":
 			n, err := parseTLSVersion(v)
 			if err != nil {
@@ -441,18 +1057,117 @@ func parseListen(cfg map[string]string, cs map[string]CertSource, readTimeout, w
 			l.TLSCiphers = c
 		case "pxyproto":
 			l.ProxyProto = (v == "true")
+		case "pxytrusted":
+			n, err := parseCIDRs(v)
+			if err != nil {
+				return Listen{}, err
+			}
+			l.ProxyTrusted = n
+		case "pxyout":
+			switch v {
+			case "v1":
+				l.ProxyOut = 1
+			case "v2":
+				l.ProxyOut = 2
+			default:
+				return Listen{}, fmt.Errorf("invalid pxyout %q. Must be v1 or v2", v)
+			}
+		case "maxheaders":
+			n, err := strconv.ParseUint(v, 10, 31)
+			if err != nil || n == 0 {
+				return Listen{}, fmt.Errorf("invalid maxheaders %q", v)
+			}
+			l.MaxHeaders = int(n)
+		case "maxheaderbytes":
+			n, err := strconv.ParseUint(v, 10, 31)
+			if err != nil || n == 0 {
+				return Listen{}, fmt.Errorf("invalid maxheaderbytes %q", v)
+			}
+			l.MaxHeaderBytes = int(n)
 		case "pxytimeout":
 			d, err := time.ParseDuration(v)
 			if err != nil {
 				return Listen{}, err
 			}
 			l.ProxyHeaderTimeout = d
+		case "grpcweb":
+			l.GRPCWeb = (v == "true")
 		case "refresh":
 			d, err := time.ParseDuration(v)
 			if err != nil {
 				return Listen{}, err
 			}
 			l.Refresh = d
+		case "sockmode":
+			n, err := strconv.ParseUint(v, 8, 32)
+			if err != nil || n > 0777 {
+				return Listen{}, fmt.Errorf("invalid sockmode %q", v)
+			}
+			l.SocketMode = os.FileMode(n)
+		case "ipmode":
+			switch v {
+			case "v4", "v6", "dual":
+				l.IPMode = v
+			default:
+				return Listen{}, fmt.Errorf("invalid ipmode %q. Must be v4, v6 or dual", v)
+			}
+		case "bindtodevice":
+			l.Socket.BindToDevice = v
+		case "freebind":
+			l.Socket.FreeBind = (v == "true")
+		case "ttl":
+			n, err := strconv.ParseUint(v, 10, 8)
+			if err != nil || n == 0 {
+				return Listen{}, fmt.Errorf("ttl must be between 1 and 255")
+			}
+			l.Socket.TTL = int(n)
+		case "tos":
+			n, err := strconv.ParseUint(v, 0, 8)
+			if err != nil {
+				return Listen{}, fmt.Errorf("tos must be between 0 and 255")
+			}
+			l.Socket.TOS = int(n)
+		case "dscp":
+			n, err := strconv.ParseUint(v, 0, 8)
+			if err != nil || n > 63 {
+				return Listen{}, fmt.Errorf("dscp must be between 0 and 63")
+			}
+			l.Socket.TOS = int(n) << 2
+		case "h2":
+			l.HTTP2.Disabled = (v == "false")
+		case "h2maxstreams":
+			n, err := strconv.ParseUint(v, 10, 32)
+			if err != nil {
+				return Listen{}, fmt.Errorf("invalid h2maxstreams %q", v)
+			}
+			l.HTTP2.MaxConcurrentStreams = uint32(n)
+		case "h2maxframesize":
+			n, err := strconv.ParseUint(v, 10, 32)
+			if err != nil || n < 16384 || n > 16777215 {
+				return Listen{}, fmt.Errorf("h2maxframesize must be between 16384 and 16777215")
+			}
+			l.HTTP2.MaxReadFrameSize = uint32(n)
+		case "h2connwindow":
+			n, err := strconv.ParseInt(v, 10, 32)
+			if err != nil || n < 65535 {
+				return Listen{}, fmt.Errorf("h2connwindow must be between 65535 and 2147483647")
+			}
+			l.HTTP2.MaxConnWindow = int32(n)
+		case "h2streamwindow":
+			n, err := strconv.ParseInt(v, 10, 32)
+			if err != nil || n < 65535 {
+				return Listen{}, fmt.Errorf("h2streamwindow must be between 65535 and 2147483647")
+			}
+			l.HTTP2.MaxStreamWindow = int32(n)
+		case "h2idletimeout":
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return Listen{}, err
+			}
+			if d < 0 {
+				return Listen{}, fmt.Errorf("h2idletimeout must not be negative")
+			}
+			l.HTTP2.IdleTimeout = d
 		}
 	}
 
@@ -462,6 +1177,26 @@ func parseListen(cfg map[string]string, cs map[string]CertSource, readTimeout, w
 	if l.Addr == "" {
 		return Listen{}, fmt.Errorf("need listening host:port")
 	}
+	if l.Socket != (SocketOpts{}) && strings.HasPrefix(l.Addr, "unix:") {
+		return Listen{}, fmt.Errorf("socket options are not supported for unix sockets")
+	}
+	if l.IPMode != "" {
+		if err := validIPMode(l.Addr, l.IPMode); err != nil {
+			return Listen{}, err
+		}
+	}
+	if l.Proto == "udp" && strings.HasPrefix(l.Addr, "unix:") {
+		return Listen{}, fmt.Errorf("proto 'udp' requires host:port")
+	}
+	if l.Proto == "udp" && l.ProxyProto {
+		return Listen{}, fmt.Errorf("proto 'udp' does not support pxyproto")
+	}
+	if l.ProxyOut > 0 && (l.Proto == "udp" || l.Proto == "grpc" || l.Proto == "grpcs") {
+		return Listen{}, fmt.Errorf("proto '%s' does not support pxyout", l.Proto)
+	}
+	if l.GRPCWeb && l.Proto != "grpc" && l.Proto != "grpcs" {
+		return Listen{}, fmt.Errorf("grpcweb requires proto 'grpc' or 'grpcs'")
+	}
 	if csName != "" && l.Proto != "https" && l.Proto != "tcp" && l.Proto != "tcp-dynamic" && l.Proto != "grpcs" && l.Proto != "https+tcp+sni" {
 		return Listen{}, fmt.Errorf("cert source requires proto 'https', 'tcp', 'tcp-dynamic', 'https+tcp+sni', or 'grpcs'")
 	}
@@ -471,12 +1206,18 @@ func parseListen(cfg map[string]string, cs map[string]CertSource, readTimeout, w
 	if csName == "" && l.Proto == "grpcs" {
 		return Listen{}, fmt.Errorf("proto 'grpcs' requires cert source")
 	}
-	if cs[csName].Type == "vault-pki" && !l.StrictMatch {
+	if typ := cs[csName].Type; (typ == "vault-pki" || typ == "acme") && !l.StrictMatch {
 		// Without StrictMatch the first issued certificate is used for all
 		// subsequent requests, even if the common name doesn't match.
-		log.Printf("[INFO] vault-pki requires strictmatch; enabling strictmatch for listener %s", l.Addr)
+		log.Printf("[INFO] %s requires strictmatch; enabling strictmatch for listener %s", typ, l.Addr)
 		l.StrictMatch = true
 	}
+	if len(l.ProxyTrusted) > 0 && !l.ProxyProto {
+		return Listen{}, fmt.Errorf("pxytrusted requires pxyproto=true")
+	}
+	if l.ProxyProto && len(l.ProxyTrusted) == 0 {
+		return Listen{}, fmt.Errorf("pxyproto=true requires pxytrusted")
+	}
 	if l.ProxyProto && l.ProxyHeaderTimeout == 0 {
 		// We should define a safe default if proxy-protocol was enabled but no header timeout was set.
 		// See https://github.com/fabiolb/fabio/issues/524 for more information.
@@ -485,6 +1226,121 @@ func parseListen(cfg map[string]string, cs map[string]CertSource, readTimeout, w
 	return
 }
 
+// validIPMode checks that the address of a listener can be bound
+// with the address family of the ipmode option. Dual-stack listeners
+// need a wildcard address since a specific address belongs to a
+// single address family.
+func validIPMode(addr, mode string) error {
+	if strings.HasPrefix(addr, "unix:") {
+		return fmt.Errorf("ipmode is not supported for unix sockets")
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid address %q. %s", addr, err)
+	}
+	ip := net.ParseIP(host)
+	switch mode {
+	case "v4":
+		if ip != nil && ip.To4() == nil {
+			return fmt.Errorf("ipmode 'v4' requires an IPv4 address or a hostname. Got %s", addr)
+		}
+	case "v6":
+		if ip != nil && ip.To4() != nil {
+			return fmt.Errorf("ipmode 'v6' requires an IPv6 address or a hostname. Got %s", addr)
+		}
+	case "dual":
+		if host != "" && (ip == nil || !ip.IsUnspecified()) {
+			return fmt.Errorf("ipmode 'dual' requires a wildcard address like :9999 or [::]:9999. Got %s", addr)
+		}
+	}
+	return nil
+}
+
+// parseKeyValues parses a comma separated list of key=value pairs.
+func parseKeyValues(s string) (map[string]string, error) {
+	m := map[string]string{}
+	for _, kv := range strings.Split(s, ",") {
+		p := strings.SplitN(kv, "=", 2)
+		k := strings.TrimSpace(p[0])
+		if len(p) != 2 || k == "" {
+			return nil, fmt.Errorf("%q is not a key=value pair", strings.TrimSpace(kv))
+		}
+		m[k] = strings.TrimSpace(p[1])
+	}
+	return m, nil
+}
+
+// reColor matches the color names of the UI, e.g. 'red' or 'deep-orange darken-2'.
+var reColor = regexp.MustCompile(`^[a-z]+(-[a-z]+)*( (lighten|darken|accent)-[1-5])?$`)
+
+// parseGroupColors parses a comma separated list of group=color pairs.
+func parseGroupColors(s string) (map[string]string, error) {
+	colors, err := parseKeyValues(s)
+	if err != nil {
+		return nil, err
+	}
+	for g, c := range colors {
+		if !reColor.MatchString(c) {
+			return nil, fmt.Errorf("%q is not a color for %q", c, g)
+		}
+	}
+	return colors, nil
+}
+
+var reLabelName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// parsePrometheusLabels parses a comma separated list of name=value pairs.
+func parsePrometheusLabels(s string) (map[string]string, error) {
+	labels := map[string]string{}
+	for _, kv := range strings.Split(s, ",") {
+		p := strings.SplitN(strings.TrimSpace(kv), "=", 2)
+		if len(p) != 2 || !reLabelName.MatchString(p[0]) || strings.HasPrefix(p[0], "__") {
+			return nil, fmt.Errorf("invalid prometheus label %q", kv)
+		}
+		labels[p[0]] = p[1]
+	}
+	return labels, nil
+}
+
+// parsePrometheusBuckets parses a comma separated list of
+// increasing upper bounds of histogram buckets.
+func parsePrometheusBuckets(s string) ([]float64, error) {
+	var buckets []float64
+	for _, v := range strings.Split(s, ",") {
+		b, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil || b <= 0 || len(buckets) > 0 && b <= buckets[len(buckets)-1] {
+			return nil, fmt.Errorf("invalid prometheus buckets %q. Must be increasing positive numbers", s)
+		}
+		buckets = append(buckets, b)
+	}
+	return buckets, nil
+}
+
+// splitList splits a list of values which are
+// separated by commas or whitespace.
+func splitList(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool { return r == ',' || unicode.IsSpace(r) })
+}
+
+// checkLocalListener returns an error unless the listener is a unix socket
+// or listens on a loopback address and requires TLS client certificates.
+func checkLocalListener(l Listen) error {
+	if strings.HasPrefix(l.Addr, "unix:") {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(l.Addr)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return fmt.Errorf("%s is neither a unix socket nor a loopback address", l.Addr)
+	}
+	if l.CertSource.ClientCAPath == "" {
+		return fmt.Errorf("%s requires a certificate source with client authentication", l.Addr)
+	}
+	return nil
+}
+
 var tlsver = map[string]uint16{
 	"ssl30": tls.VersionSSL30,
 	"tls10": tls.VersionTLS10,
@@ -542,6 +1398,31 @@ func parseTLSCiphers(s string) ([]uint16, error) {
 	return c, nil
 }
 
+// parseCIDRs parses a comma separated list of networks in CIDR
+// notation. A plain IP address is a network with a single address.
+func parseCIDRs(s string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if ip := net.ParseIP(v); ip != nil {
+			bits := 8 * net.IPv4len
+			if ip.To4() == nil {
+				bits = 8 * net.IPv6len
+			} else {
+				ip = ip.To4()
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q", v)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
 func parseUint16(s string) (uint16, error) {
 	n, err := strconv.ParseUint(s, 0, 32)
 	if err != nil {
@@ -575,6 +1456,7 @@ func parseCertSource(cfg map[string]string) (c CertSource, err error) {
 	}
 
 	c.Refresh = 3 * time.Second
+	refreshSet := false
 
 	for k, v := range cfg {
 		switch k {
@@ -596,8 +1478,19 @@ func parseCertSource(cfg map[string]string) (c CertSource, err error) {
 				return CertSource{}, err
 			}
 			c.Refresh = d
+			refreshSet = true
 		case "vaultfetchtoken":
 			c.VaultFetchToken = v
+		case "keypass":
+			c.KeyPassphrase = v
+		case "acmedir":
+			c.ACMEDirectory = v
+		case "email":
+			c.ACMEEmail = v
+		case "domains":
+			c.ACMEDomains = splitList(v)
+		case "challenge":
+			c.ACMEChallenges = splitList(v)
 		case "hdr":
 			p := strings.SplitN(v, ": ", 2)
 			if len(p) != 2 {
@@ -618,10 +1511,30 @@ func parseCertSource(cfg map[string]string) (c CertSource, err error) {
 	switch c.Type {
 	case "":
 		return CertSource{}, fmt.Errorf("missing 'type' in %s", cfg)
-	case "file", "consul":
+	case "file":
+		// the file source is only reloaded when asked for
+		if !refreshSet {
+			c.Refresh = 0
+		}
+	case "consul":
 		c.Refresh = 0
 	case "path", "http", "vault", "vault-pki":
 		// no-op
+	case "acme":
+		if !refreshSet {
+			c.Refresh = 30 * 24 * time.Hour
+		}
+		if len(c.ACMEDomains) == 0 {
+			return CertSource{}, fmt.Errorf("missing 'domains' in %s", cfg)
+		}
+		if len(c.ACMEChallenges) == 0 {
+			c.ACMEChallenges = []string{"http-01", "tls-alpn-01"}
+		}
+		for _, ch := range c.ACMEChallenges {
+			if ch != "http-01" && ch != "tls-alpn-01" {
+				return CertSource{}, fmt.Errorf("invalid challenge %s in %s", ch, cfg)
+			}
+		}
 	default:
 		return CertSource{}, fmt.Errorf("unknown cert source type %s", c.Type)
 	}
@@ -673,12 +1586,22 @@ func parseAuthScheme(cfg map[string]string) (a AuthScheme, err error) {
 			Refresh: 0, // the htpasswd file refresh is disabled by default
 		}
 
-		if a.Basic.File == "" {
-			return AuthScheme{}, fmt.Errorf("missing 'file' in auth '%s'", a.Name)
-		}
 		if a.Basic.Realm == "" {
 			a.Basic.Realm = a.Name
 		}
+		if cfg["ldap"] != "" {
+			if a.Basic.File != "" {
+				return AuthScheme{}, fmt.Errorf("'file' and 'ldap' are mutually exclusive in auth '%s'", a.Name)
+			}
+			a.Basic.LDAP, err = parseLDAPAuth(a.Name, cfg)
+			if err != nil {
+				return AuthScheme{}, err
+			}
+			break
+		}
+		if a.Basic.File == "" {
+			return AuthScheme{}, fmt.Errorf("missing 'file' in auth '%s'", a.Name)
+		}
 
 		if cfg["refresh"] != "" {
 			d, err := time.ParseDuration(cfg["refresh"])
@@ -691,9 +1614,202 @@ func parseAuthScheme(cfg map[string]string) (a AuthScheme, err error) {
 			a.Basic.Refresh = d
 		}
 
+	case "jwt":
+		a.JWT = JWTAuth{
+			Realm:   cfg["realm"],
+			JWKS:    cfg["jwks"],
+			Issuer:  cfg["issuer"],
+			Refresh: time.Hour,
+			Leeway:  time.Minute,
+		}
+
+		if a.JWT.JWKS == "" {
+			return AuthScheme{}, fmt.Errorf("missing 'jwks' in auth '%s'", a.Name)
+		}
+		if a.JWT.Realm == "" {
+			a.JWT.Realm = a.Name
+		}
+		a.JWT.Audience = strings.Fields(cfg["audience"])
+
+		if cfg["refresh"] != "" {
+			d, err := time.ParseDuration(cfg["refresh"])
+			if err != nil {
+				return AuthScheme{}, err
+			}
+			if d < time.Minute {
+				d = time.Minute
+			}
+			a.JWT.Refresh = d
+		}
+
+		if cfg["leeway"] != "" {
+			d, err := time.ParseDuration(cfg["leeway"])
+			if err != nil || d < 0 {
+				return AuthScheme{}, fmt.Errorf("invalid 'leeway' in auth '%s'", a.Name)
+			}
+			a.JWT.Leeway = d
+		}
+
+		for _, c := range strings.Fields(cfg["claims"]) {
+			p := strings.SplitN(c, ":", 2)
+			if len(p) != 2 || p[0] == "" || p[1] == "" {
+				return AuthScheme{}, fmt.Errorf("invalid 'claims' in auth '%s'. Must be 'claim:header ...'", a.Name)
+			}
+			if a.JWT.Claims == nil {
+				a.JWT.Claims = map[string]string{}
+			}
+			a.JWT.Claims[p[0]] = p[1]
+		}
+
+	case "extauthz":
+		a.ExtAuthz = ExtAuthzAuth{
+			URL:        cfg["url"],
+			Timeout:    time.Second,
+			Headers:    strings.Fields(cfg["headers"]),
+			Upstream:   strings.Fields(cfg["upstream"]),
+			Downstream: strings.Fields(cfg["downstream"]),
+		}
+
+		if a.ExtAuthz.URL == "" {
+			return AuthScheme{}, fmt.Errorf("missing 'url' in auth '%s'", a.Name)
+		}
+		u, err := url.Parse(a.ExtAuthz.URL)
+		if err != nil || u.Host == "" {
+			return AuthScheme{}, fmt.Errorf("invalid 'url' in auth '%s'", a.Name)
+		}
+		switch u.Scheme {
+		case "http", "https", "grpc", "grpcs":
+		default:
+			return AuthScheme{}, fmt.Errorf("invalid 'url' in auth '%s'. Scheme must be http, https, grpc or grpcs", a.Name)
+		}
+
+		if cfg["timeout"] != "" {
+			d, err := time.ParseDuration(cfg["timeout"])
+			if err != nil || d <= 0 {
+				return AuthScheme{}, fmt.Errorf("invalid 'timeout' in auth '%s'", a.Name)
+			}
+			a.ExtAuthz.Timeout = d
+		}
+
+		switch cfg["failopen"] {
+		case "", "false":
+		case "true":
+			a.ExtAuthz.FailOpen = true
+		default:
+			return AuthScheme{}, fmt.Errorf("invalid 'failopen' in auth '%s'. Must be true or false", a.Name)
+		}
+
 	default:
 		return AuthScheme{}, fmt.Errorf("unknown auth type '%s'", a.Type)
 	}
 
 	return
 }
+
+// parseLDAPAuth parses the options of a basic auth scheme
+// which verifies the credentials with an LDAP server.
+func parseLDAPAuth(name string, cfg map[string]string) (LDAPAuth, error) {
+	l := LDAPAuth{
+		URL:          cfg["ldap"],
+		CAFile:       cfg["cafile"],
+		Timeout:      5 * time.Second,
+		BindDN:       cfg["binddn"],
+		BindPassword: cfg["bindpw"],
+		BaseDN:       cfg["basedn"],
+		Filter:       cfg["filter"],
+		Group:        cfg["group"],
+		CacheTTL:     time.Minute,
+	}
+
+	u, err := url.Parse(l.URL)
+	if err != nil || u.Host == "" || (u.Scheme != "ldap" && u.Scheme != "ldaps") {
+		return LDAPAuth{}, fmt.Errorf("invalid 'ldap' in auth '%s'. Must be ldap://host[:port] or ldaps://host[:port]", name)
+	}
+	if l.BaseDN == "" {
+		return LDAPAuth{}, fmt.Errorf("missing 'basedn' in auth '%s'", name)
+	}
+	if l.Filter == "" {
+		l.Filter = "(uid={user})"
+	}
+	if !strings.Contains(l.Filter, "{user}") {
+		return LDAPAuth{}, fmt.Errorf("invalid 'filter' in auth '%s'. Must contain {user}", name)
+	}
+
+	for _, opt := range []struct {
+		key string
+		val *bool
+	}{
+		{"starttls", &l.StartTLS},
+		{"insecureskipverify", &l.InsecureSkipVerify},
+	} {
+		switch cfg[opt.key] {
+		case "", "false":
+		case "true":
+			*opt.val = true
+		default:
+			return LDAPAuth{}, fmt.Errorf("invalid '%s' in auth '%s'. Must be true or false", opt.key, name)
+		}
+	}
+	if l.StartTLS && u.Scheme == "ldaps" {
+		return LDAPAuth{}, fmt.Errorf("invalid 'starttls' in auth '%s'. Cannot be used with ldaps", name)
+	}
+
+	if cfg["timeout"] != "" {
+		d, err := time.ParseDuration(cfg["timeout"])
+		if err != nil || d <= 0 {
+			return LDAPAuth{}, fmt.Errorf("invalid 'timeout' in auth '%s'", name)
+		}
+		l.Timeout = d
+	}
+	if cfg["cachettl"] != "" {
+		d, err := time.ParseDuration(cfg["cachettl"])
+		if err != nil || d < 0 {
+			return LDAPAuth{}, fmt.Errorf("invalid 'cachettl' in auth '%s'", name)
+		}
+		l.CacheTTL = d
+	}
+	return l, nil
+}
+
+func validCertPrefer(v string) error {
+	switch v {
+	case "exact", "wildcard":
+		return nil
+	default:
+		return fmt.Errorf("invalid certificate preference %q. Must be one of 'exact' or 'wildcard'", v)
+	}
+}
+
+func validNoSNI(v string) error {
+	switch v {
+	case "", "default", "fail":
+		return nil
+	default:
+		return fmt.Errorf("invalid nosni value %q. Must be one of 'default' or 'fail'", v)
+	}
+}
+
+// validHashKey returns true if v is 'ip', 'header:<name>'
+// or 'cookie:<name>'.
+func validHashKey(v string) bool {
+	if v == "ip" {
+		return true
+	}
+	for _, p := range []string{"header:", "cookie:"} {
+		if strings.HasPrefix(v, p) && len(v) > len(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasSyslogScheme returns true if the syslog address v starts
+// with 'udp://', 'tcp://', 'tls://' or 'unix://'.
+func hasSyslogScheme(v string) bool {
+	for _, p := range []string{"udp://", "tcp://", "tls://", "unix://"} {
+		if strings.HasPrefix(v, p) && len(v) > len(p) {
+			return true
+		}
+	}
+	return false
+}
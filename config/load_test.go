@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -112,6 +113,67 @@ func TestLoad(t *testing.T) {
 				return cfg
 			},
 		},
+		{
+			args: []string{"-proxy.addr", ":5353;proto=udp;it=30s"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Listen = []Listen{{Addr: ":5353", Proto: "udp", IdleTimeout: 30 * time.Second}}
+				return cfg
+			},
+		},
+		{
+			desc: "-proxy.addr with pxytrusted",
+			args: []string{"-proxy.addr", `:5555;pxyproto=true;pxytrusted="10.0.0.0/8, 192.168.1.1,::1"`},
+			cfg: func(cfg *Config) *Config {
+				cfg.Listen = []Listen{{
+					Addr:               ":5555",
+					Proto:              "http",
+					ProxyProto:         true,
+					ProxyHeaderTimeout: 250 * time.Millisecond,
+					ProxyTrusted: []*net.IPNet{
+						{IP: net.IP{10, 0, 0, 0}, Mask: net.CIDRMask(8, 32)},
+						{IP: net.IP{192, 168, 1, 1}, Mask: net.CIDRMask(32, 32)},
+						{IP: net.ParseIP("::1"), Mask: net.CIDRMask(128, 128)},
+					},
+				}}
+				return cfg
+			},
+		},
+		{
+			desc: "-proxy.addr with pxyout",
+			args: []string{"-proxy.addr", ":5555;proto=tcp;pxyout=v2"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Listen = []Listen{{Addr: ":5555", Proto: "tcp", ProxyOut: 2}}
+				return cfg
+			},
+		},
+		{
+			desc: "-proxy.addr with header limits",
+			args: []string{"-proxy.addr", ":5555;maxheaders=50;maxheaderbytes=16384"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Listen = []Listen{{Addr: ":5555", Proto: "http", MaxHeaders: 50, MaxHeaderBytes: 16384}}
+				return cfg
+			},
+		},
+		{
+			desc: "-proxy.addr with ipmode",
+			args: []string{"-proxy.addr", "0.0.0.0:5555;ipmode=v4,[::]:5556;ipmode=v6,:5557;proto=udp;ipmode=dual"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Listen = []Listen{
+					{Addr: "0.0.0.0:5555", Proto: "http", IPMode: "v4"},
+					{Addr: "[::]:5556", Proto: "http", IPMode: "v6"},
+					{Addr: ":5557", Proto: "udp", IPMode: "dual"},
+				}
+				return cfg
+			},
+		},
+		{
+			desc: "-proxy.addr with grpcweb",
+			args: []string{"-proxy.addr", ":5555;proto=grpc;grpcweb=true"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Listen = []Listen{{Addr: ":5555", Proto: "grpc", GRPCWeb: true}}
+				return cfg
+			},
+		},
 		{
 			desc: "-proxy.addr with tls configs",
 			args: []string{"-proxy.addr", `:5555;rt=1s;wt=2s;it=3s;tlsmin=0x0300;tlsmax=0x305;tlsciphers="0x123,0x456"`},
@@ -159,6 +221,24 @@ func TestLoad(t *testing.T) {
 				return cfg
 			},
 		},
+		{
+			desc: "-proxy.addr with path cert source and key passphrase",
+			args: []string{"-proxy.addr", ":5555;cs=name", "-proxy.cs", "cs=name;type=path;cert=value;keypass=env:KEYPASS"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Listen = []Listen{{Addr: ":5555", Proto: "https"}}
+				cfg.Listen[0].CertSource = CertSource{Name: "name", Type: "path", CertPath: "value", Refresh: 3 * time.Second, KeyPassphrase: "env:KEYPASS"}
+				return cfg
+			},
+		},
+		{
+			desc: "-proxy.addr with file cert source and refresh",
+			args: []string{"-proxy.addr", ":5555;cs=name", "-proxy.cs", "cs=name;type=file;cert=value;refresh=5s"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Listen = []Listen{{Addr: ":5555", Proto: "https"}}
+				cfg.Listen[0].CertSource = CertSource{Name: "name", Type: "file", CertPath: "value", Refresh: 5 * time.Second}
+				return cfg
+			},
+		},
 		{
 			desc: "-proxy.addr with path cert source",
 			args: []string{"-proxy.addr", ":5555;cs=name", "-proxy.cs", "cs=name;type=path;cert=value"},
@@ -221,6 +301,48 @@ func TestLoad(t *testing.T) {
 				return cfg
 			},
 		},
+		{
+			desc: "-proxy.addr with acme cert source",
+			args: []string{
+				"-proxy.addr", ":443;cs=name",
+				"-proxy.cs", `cs=name;type=acme;cert=/var/lib/fabio/acme;email=ops@example.com;domains="example.com,*.example.org"`,
+			},
+			cfg: func(cfg *Config) *Config {
+				cfg.Listen = []Listen{{Addr: ":443", Proto: "https"}}
+				cfg.Listen[0].CertSource = CertSource{
+					Name:           "name",
+					Type:           "acme",
+					CertPath:       "/var/lib/fabio/acme",
+					Refresh:        30 * 24 * time.Hour,
+					ACMEEmail:      "ops@example.com",
+					ACMEDomains:    []string{"example.com", "*.example.org"},
+					ACMEChallenges: []string{"http-01", "tls-alpn-01"},
+				}
+				cfg.Listen[0].StrictMatch = true // implicit
+				return cfg
+			},
+		},
+		{
+			desc: "-proxy.addr with acme cert source and options",
+			args: []string{
+				"-proxy.addr", ":443;cs=name",
+				"-proxy.cs", "cs=name;type=acme;cert=http://localhost:8500/v1/kv/fabio/acme;acmedir=https://acme.example.com/dir;domains=example.com;challenge=tls-alpn-01;refresh=240h",
+			},
+			cfg: func(cfg *Config) *Config {
+				cfg.Listen = []Listen{{Addr: ":443", Proto: "https"}}
+				cfg.Listen[0].CertSource = CertSource{
+					Name:           "name",
+					Type:           "acme",
+					CertPath:       "http://localhost:8500/v1/kv/fabio/acme",
+					Refresh:        240 * time.Hour,
+					ACMEDirectory:  "https://acme.example.com/dir",
+					ACMEDomains:    []string{"example.com"},
+					ACMEChallenges: []string{"tls-alpn-01"},
+				}
+				cfg.Listen[0].StrictMatch = true // implicit
+				return cfg
+			},
+		},
 		{
 			desc: "-proxy.addr with cert source",
 			args: []string{"-proxy.addr", ":5555;cs=name;strictmatch=true", "-proxy.cs", "cs=name;type=path;cert=foo;clientca=bar;refresh=2s;hdr=a: b;caupgcn=furb"},
@@ -301,6 +423,76 @@ func TestLoad(t *testing.T) {
 				return cfg
 			},
 		},
+		{
+			desc: "-proxy.auth with source basic and ldap",
+			args: []string{"-proxy.auth", `name=foo;type=basic;ldap=ldap://ldap:389;starttls=true;binddn="cn=fabio,dc=example,dc=org";bindpw=secret;basedn="ou=people,dc=example,dc=org";filter=(&(objectClass=person)(uid={user}));group="cn=ops,ou=groups,dc=example,dc=org";timeout=2s;cachettl=30s`},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.AuthSchemes = map[string]AuthScheme{
+					"foo": {
+						Name: "foo",
+						Type: "basic",
+						Basic: BasicAuth{
+							Realm: "foo",
+							LDAP: LDAPAuth{
+								URL:          "ldap://ldap:389",
+								StartTLS:     true,
+								Timeout:      2 * time.Second,
+								BindDN:       "cn=fabio,dc=example,dc=org",
+								BindPassword: "secret",
+								BaseDN:       "ou=people,dc=example,dc=org",
+								Filter:       "(&(objectClass=person)(uid={user}))",
+								Group:        "cn=ops,ou=groups,dc=example,dc=org",
+								CacheTTL:     30 * time.Second,
+							},
+						},
+					},
+				}
+				return cfg
+			},
+		},
+		{
+			desc: "-proxy.auth with source jwt",
+			args: []string{"-proxy.auth", "name=foo;type=jwt;jwks=https://idp/keys;issuer=https://idp/;audience=api web;refresh=10m;leeway=30s;claims=sub:X-User email:X-Email"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.AuthSchemes = map[string]AuthScheme{
+					"foo": {
+						Name: "foo",
+						Type: "jwt",
+						JWT: JWTAuth{
+							Realm:    "foo",
+							JWKS:     "https://idp/keys",
+							Issuer:   "https://idp/",
+							Audience: []string{"api", "web"},
+							Refresh:  10 * time.Minute,
+							Leeway:   30 * time.Second,
+							Claims:   map[string]string{"sub": "X-User", "email": "X-Email"},
+						},
+					},
+				}
+				return cfg
+			},
+		},
+		{
+			desc: "-proxy.auth with source extauthz",
+			args: []string{"-proxy.auth", "name=foo;type=extauthz;url=grpc://opa:9191;timeout=200ms;headers=Authorization Cookie;upstream=X-User;downstream=Set-Cookie X-Auth;failopen=true"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.AuthSchemes = map[string]AuthScheme{
+					"foo": {
+						Name: "foo",
+						Type: "extauthz",
+						ExtAuthz: ExtAuthzAuth{
+							URL:        "grpc://opa:9191",
+							Timeout:    200 * time.Millisecond,
+							Headers:    []string{"Authorization", "Cookie"},
+							Upstream:   []string{"X-User"},
+							Downstream: []string{"Set-Cookie", "X-Auth"},
+							FailOpen:   true,
+						},
+					},
+				}
+				return cfg
+			},
+		},
 		{
 			desc: "issue 305",
 			args: []string{
@@ -342,6 +534,15 @@ func TestLoad(t *testing.T) {
 				return cfg
 			},
 		},
+		{
+			args: []string{"-proxy.strategy", "hash", "-proxy.hash.key", "cookie:session", "-proxy.hash.vnodes", "50"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.Strategy = "hash"
+				cfg.Proxy.HashKey = "cookie:session"
+				cfg.Proxy.HashVirtualNodes = 50
+				return cfg
+			},
+		},
 		{
 			args: []string{"-proxy.matcher", "prefix"},
 			cfg: func(cfg *Config) *Config {
@@ -370,6 +571,14 @@ func TestLoad(t *testing.T) {
 				return cfg
 			},
 		},
+		{
+			args: []string{"-proxy.noroute.htmldir", "/etc/fabio/noroute", "-proxy.noroute.json", `{"error":"no route"}`},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.NoRouteHTMLDir = "/etc/fabio/noroute"
+				cfg.Proxy.NoRouteJSON = `{"error":"no route"}`
+				return cfg
+			},
+		},
 		{
 			args: []string{"-proxy.shutdownwait", "5ms"},
 			cfg: func(cfg *Config) *Config {
@@ -377,6 +586,20 @@ func TestLoad(t *testing.T) {
 				return cfg
 			},
 		},
+		{
+			args: []string{"-proxy.drain.grace", "1m"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.DrainGrace = time.Minute
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.upgrade.timeout", "30s"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.UpgradeTimeout = 30 * time.Second
+				return cfg
+			},
+		},
 		{
 			args: []string{"-proxy.responseheadertimeout", "5ms"},
 			cfg: func(cfg *Config) *Config {
@@ -384,6 +607,13 @@ func TestLoad(t *testing.T) {
 				return cfg
 			},
 		},
+		{
+			args: []string{"-proxy.ws.idletimeout", "5m"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.WSIdleTimeout = 5 * time.Minute
+				return cfg
+			},
+		},
 		{
 			args: []string{"-proxy.keepalivetimeout", "5ms"},
 			cfg: func(cfg *Config) *Config {
@@ -426,6 +656,13 @@ func TestLoad(t *testing.T) {
 				return cfg
 			},
 		},
+		{
+			args: []string{"-proxy.upstream.http2", "h2c"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.UpstreamHTTP2 = "h2c"
+				return cfg
+			},
+		},
 		{
 			args: []string{"-proxy.maxconn", "555"},
 			cfg: func(cfg *Config) *Config {
@@ -454,6 +691,115 @@ func TestLoad(t *testing.T) {
 				return cfg
 			},
 		},
+		{
+			args: []string{"-proxy.tls.certprefer", "wildcard"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.TLSCertPrefer = "wildcard"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.tls.nosni", "fail"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.TLSNoSNI = "fail"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.addr", ":5555;cs=name;certprefer=wildcard;nosni=default", "-proxy.cs", "cs=name;type=path;cert=foo"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Listen = []Listen{
+					{
+						Addr:       ":5555",
+						Proto:      "https",
+						CertPrefer: "wildcard",
+						NoSNI:      "default",
+						CertSource: CertSource{
+							Name:     "name",
+							Type:     "path",
+							CertPath: "foo",
+							Refresh:  3 * time.Second,
+						},
+					},
+				}
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.addr", ":5555;cs=name;h2maxstreams=1000;h2maxframesize=32768;h2connwindow=1048576;h2streamwindow=65535;h2idletimeout=30s", "-proxy.cs", "cs=name;type=path;cert=foo"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Listen = []Listen{
+					{
+						Addr:  ":5555",
+						Proto: "https",
+						HTTP2: HTTP2{
+							MaxConcurrentStreams: 1000,
+							MaxReadFrameSize:     32768,
+							MaxConnWindow:        1048576,
+							MaxStreamWindow:      65535,
+							IdleTimeout:          30 * time.Second,
+						},
+						CertSource: CertSource{
+							Name:     "name",
+							Type:     "path",
+							CertPath: "foo",
+							Refresh:  3 * time.Second,
+						},
+					},
+				}
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.addr", "10.0.0.1:5555;bindtodevice=eth1;freebind=true;ttl=32;dscp=46"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Listen = []Listen{
+					{
+						Addr:  "10.0.0.1:5555",
+						Proto: "http",
+						Socket: SocketOpts{
+							BindToDevice: "eth1",
+							FreeBind:     true,
+							TTL:          32,
+							TOS:          184,
+						},
+					},
+				}
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.addr", ":5555;proto=tcp;tos=0x10"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Listen = []Listen{
+					{
+						Addr:   ":5555",
+						Proto:  "tcp",
+						Socket: SocketOpts{TOS: 16},
+					},
+				}
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.addr", ":5555;cs=name;h2=false", "-proxy.cs", "cs=name;type=path;cert=foo"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Listen = []Listen{
+					{
+						Addr:  ":5555",
+						Proto: "https",
+						HTTP2: HTTP2{Disabled: true},
+						CertSource: CertSource{
+							Name:     "name",
+							Type:     "path",
+							CertPath: "foo",
+							Refresh:  3 * time.Second,
+						},
+					},
+				}
+				return cfg
+			},
+		},
 		{
 			args: []string{"-proxy.header.requestid", "value"},
 			cfg: func(cfg *Config) *Config {
@@ -496,6 +842,13 @@ func TestLoad(t *testing.T) {
 				return cfg
 			},
 		},
+		{
+			args: []string{"-proxy.gzip.minsize", "1024"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.GZIPMinSize = 1024
+				return cfg
+			},
+		},
 		{
 			args: []string{"-proxy.log.routes", "foobar"},
 			cfg: func(cfg *Config) *Config {
@@ -538,6 +891,41 @@ func TestLoad(t *testing.T) {
 				return cfg
 			},
 		},
+		{
+			args: []string{"-registry.file.refresh", "5s"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Registry.File.Refresh = 5 * time.Second
+				return cfg
+			},
+		},
+		{
+			args: []string{"-registry.replay.path", "/tmp/registry.rec", "-registry.replay.speed", "10"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Registry.Replay = Replay{Path: "/tmp/registry.rec", Speed: 10}
+				return cfg
+			},
+		},
+		{
+			args: []string{"-registry.debounce", "500ms", "-registry.debounce.max", "2s"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Registry.Debounce = Debounce{Window: 500 * time.Millisecond, Max: 2 * time.Second}
+				return cfg
+			},
+		},
+		{
+			args: []string{"-registry.flap.threshold", "4", "-registry.flap.window", "1m", "-registry.flap.hold", "2m", "-registry.flap.weight", "0"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Registry.Flap = Flap{Threshold: 4, Window: time.Minute, Hold: 2 * time.Minute, Weight: 0}
+				return cfg
+			},
+		},
+		{
+			args: []string{"-registry.record.path", "/tmp/registry.rec"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Registry.RecordPath = "/tmp/registry.rec"
+				return cfg
+			},
+		},
 		{
 			args: []string{"-registry.static.routes", "value"},
 			cfg: func(cfg *Config) *Config {
@@ -591,6 +979,22 @@ func TestLoad(t *testing.T) {
 				return cfg
 			},
 		},
+		{
+			args: []string{"-registry.consul.token.services", "file:/run/svc-token", "-registry.consul.token.kv", "env:KV_TOKEN", "-registry.consul.token.register", "reg-token"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Registry.Consul.TokenServices = "file:/run/svc-token"
+				cfg.Registry.Consul.TokenKV = "env:KV_TOKEN"
+				cfg.Registry.Consul.TokenRegister = "reg-token"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-registry.consul.token.refresh", "5s"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Registry.Consul.TokenRefresh = 5 * time.Second
+				return cfg
+			},
+		},
 		{
 			args: []string{"-registry.consul.kvpath", "/some/path"},
 			cfg: func(cfg *Config) *Config {
@@ -676,425 +1080,1877 @@ func TestLoad(t *testing.T) {
 			},
 		},
 		{
-			args: []string{"-registry.custom.host", "localhost:8080"},
+			args: []string{"-registry.kubernetes.kubeconfig", "/root/.kube/config"},
 			cfg: func(cfg *Config) *Config {
-				cfg.Registry.Custom.Host = "localhost:8080"
+				cfg.Registry.Kubernetes.Kubeconfig = "/root/.kube/config"
 				return cfg
 			},
 		},
 		{
-			args: []string{"-registry.custom.scheme", "https"},
+			args: []string{"-registry.kubernetes.context", "prod"},
 			cfg: func(cfg *Config) *Config {
-				cfg.Registry.Custom.Scheme = "https"
+				cfg.Registry.Kubernetes.Context = "prod"
 				return cfg
 			},
 		},
 		{
-			args: []string{"-registry.custom.checkTLSSkipVerify", "true"},
+			args: []string{"-registry.kubernetes.namespace", "web"},
 			cfg: func(cfg *Config) *Config {
-				cfg.Registry.Custom.CheckTLSSkipVerify = true
+				cfg.Registry.Kubernetes.Namespace = "web"
 				return cfg
 			},
 		},
 		{
-			args: []string{"-registry.custom.timeout", "5s"},
+			args: []string{"-registry.kubernetes.selector", "app=web,tier!=db"},
 			cfg: func(cfg *Config) *Config {
-				cfg.Registry.Custom.Timeout = 5 * time.Second
+				cfg.Registry.Kubernetes.Selector = "app=web,tier!=db"
 				return cfg
 			},
 		},
 		{
-			args: []string{"-registry.custom.pollinterval", "5s"},
+			args: []string{"-registry.kubernetes.prefix", "example.com/route"},
 			cfg: func(cfg *Config) *Config {
-				cfg.Registry.Custom.PollInterval = 5 * time.Second
+				cfg.Registry.Kubernetes.Prefix = "example.com/route"
 				return cfg
 			},
 		},
 		{
-			args: []string{"-registry.custom.path", "test"},
+			args: []string{"-registry.kubernetes.ingress", "true"},
 			cfg: func(cfg *Config) *Config {
-				cfg.Registry.Custom.Path = "test"
+				cfg.Registry.Kubernetes.Ingress = true
 				return cfg
 			},
 		},
 		{
-			args: []string{"-registry.custom.queryparams", "test=1"},
+			args: []string{"-registry.kubernetes.ingressclass", ""},
 			cfg: func(cfg *Config) *Config {
-				cfg.Registry.Custom.QueryParams = "test=1"
+				cfg.Registry.Kubernetes.IngressClass = ""
 				return cfg
 			},
 		},
 		{
-			args: []string{"-registry.consul.pollinterval", "5s"},
+			args: []string{"-registry.nomad.addr", "https://nomad.example.com:4646"},
 			cfg: func(cfg *Config) *Config {
-				cfg.Registry.Consul.PollInterval = 5 * time.Second
+				cfg.Registry.Nomad.Addr = "https://nomad.example.com:4646"
 				return cfg
 			},
 		},
 		{
-			args: []string{"-log.access.format", "foobar"},
+			args: []string{"-registry.nomad.token", "secret"},
 			cfg: func(cfg *Config) *Config {
-				cfg.Log.AccessFormat = "foobar"
+				cfg.Registry.Nomad.Token = "secret"
 				return cfg
 			},
 		},
 		{
-			args: []string{"-log.access.target", "foobar"},
+			args: []string{"-registry.nomad.namespace", "*"},
 			cfg: func(cfg *Config) *Config {
-				cfg.Log.AccessTarget = "foobar"
+				cfg.Registry.Nomad.Namespace = "*"
 				return cfg
 			},
 		},
 		{
-			args: []string{"-log.routes.format", "foobar"},
+			args: []string{"-registry.nomad.region", "eu"},
 			cfg: func(cfg *Config) *Config {
-				cfg.Log.RoutesFormat = "foobar"
+				cfg.Registry.Nomad.Region = "eu"
 				return cfg
 			},
 		},
 		{
-			args: []string{"-log.level", "foobar"},
+			args: []string{"-registry.nomad.tagprefix", "fabio-"},
 			cfg: func(cfg *Config) *Config {
-				cfg.Log.Level = "foobar"
+				cfg.Registry.Nomad.TagPrefix = "fabio-"
 				return cfg
 			},
 		},
 		{
-			args: []string{"-metrics.target", "some-target"},
+			args: []string{"-registry.nomad.waittime", "1m"},
 			cfg: func(cfg *Config) *Config {
-				cfg.Metrics.Target = "some-target"
+				cfg.Registry.Nomad.WaitTime = time.Minute
 				return cfg
 			},
 		},
 		{
-			args: []string{"-metrics.prefix", "some-prefix"},
+			args: []string{"-registry.nomad.tls.keyfile", "/tmp/key"},
 			cfg: func(cfg *Config) *Config {
-				cfg.Metrics.Prefix = "some-prefix"
+				cfg.Registry.Nomad.TLS.KeyFile = "/tmp/key"
 				return cfg
 			},
 		},
 		{
-			args: []string{"-metrics.names", "some names"},
+			args: []string{"-registry.nomad.tls.certfile", "/tmp/cert"},
 			cfg: func(cfg *Config) *Config {
-				cfg.Metrics.Names = "some names"
+				cfg.Registry.Nomad.TLS.CertFile = "/tmp/cert"
 				return cfg
 			},
 		},
 		{
-			args: []string{"-metrics.interval", "5ms"},
+			args: []string{"-registry.nomad.tls.cafile", "/tmp/ca"},
 			cfg: func(cfg *Config) *Config {
-				cfg.Metrics.Interval = 5 * time.Millisecond
+				cfg.Registry.Nomad.TLS.CAFile = "/tmp/ca"
 				return cfg
 			},
 		},
 		{
-			args: []string{"-metrics.timeout", "5s"},
+			args: []string{"-registry.nomad.tls.insecureskipverify", "true"},
 			cfg: func(cfg *Config) *Config {
-				cfg.Metrics.Timeout = 5 * time.Second
+				cfg.Registry.Nomad.TLS.InsecureSkipVerify = true
 				return cfg
 			},
 		},
 		{
-			args: []string{"-metrics.retry", "500ms"},
+			args: []string{"-registry.etcd.addr", "https://10.0.0.1:2379,https://10.0.0.2:2379"},
 			cfg: func(cfg *Config) *Config {
-				cfg.Metrics.Retry = 500 * time.Millisecond
+				cfg.Registry.Etcd.Addr = "https://10.0.0.1:2379,https://10.0.0.2:2379"
 				return cfg
 			},
 		},
 		{
-			args: []string{"-metrics.graphite.addr", "1.2.3.4:5555"},
+			args: []string{"-registry.etcd.username", "fabio"},
 			cfg: func(cfg *Config) *Config {
-				cfg.Metrics.GraphiteAddr = "1.2.3.4:5555"
+				cfg.Registry.Etcd.Username = "fabio"
 				return cfg
 			},
 		},
 		{
-			args: []string{"-metrics.statsd.addr", "1.2.3.4:5555"},
+			args: []string{"-registry.etcd.password", "secret"},
 			cfg: func(cfg *Config) *Config {
-				cfg.Metrics.StatsDAddr = "1.2.3.4:5555"
+				cfg.Registry.Etcd.Password = "secret"
 				return cfg
 			},
 		},
 		{
-			args: []string{"-metrics.circonus.apiapp", "value"},
+			args: []string{"-registry.etcd.routespath", "/lb/routes"},
 			cfg: func(cfg *Config) *Config {
-				cfg.Metrics.Circonus.APIApp = "value"
+				cfg.Registry.Etcd.RoutesPath = "/lb/routes"
 				return cfg
 			},
 		},
 		{
-			args: []string{"-metrics.circonus.apikey", "value"},
+			args: []string{"-registry.etcd.kvpath", "/lb/config"},
 			cfg: func(cfg *Config) *Config {
-				cfg.Metrics.Circonus.APIKey = "value"
+				cfg.Registry.Etcd.KVPath = "/lb/config"
 				return cfg
 			},
 		},
 		{
-			args: []string{"-metrics.circonus.apiurl", "value"},
+			args: []string{"-registry.etcd.noroutehtmlpath", "/lb/noroute.html"},
 			cfg: func(cfg *Config) *Config {
-				cfg.Metrics.Circonus.APIURL = "value"
+				cfg.Registry.Etcd.NoRouteHTMLPath = "/lb/noroute.html"
 				return cfg
 			},
 		},
 		{
-			args: []string{"-metrics.circonus.brokerid", "value"},
+			args: []string{"-registry.etcd.tls.keyfile", "/tmp/key"},
 			cfg: func(cfg *Config) *Config {
-				cfg.Metrics.Circonus.BrokerID = "value"
+				cfg.Registry.Etcd.TLS.KeyFile = "/tmp/key"
 				return cfg
 			},
 		},
 		{
-			args: []string{"-metrics.circonus.checkid", "value"},
+			args: []string{"-registry.etcd.tls.certfile", "/tmp/cert"},
 			cfg: func(cfg *Config) *Config {
-				cfg.Metrics.Circonus.CheckID = "value"
+				cfg.Registry.Etcd.TLS.CertFile = "/tmp/cert"
 				return cfg
 			},
 		},
 		{
-			args: []string{"-metrics.circonus.submissionurl", "value"},
+			args: []string{"-registry.etcd.tls.cafile", "/tmp/ca"},
 			cfg: func(cfg *Config) *Config {
-				cfg.Metrics.Circonus.SubmissionURL = "value"
+				cfg.Registry.Etcd.TLS.CAFile = "/tmp/ca"
 				return cfg
 			},
 		},
 		{
-			args: []string{"-runtime.gogc", "555"},
+			args: []string{"-registry.etcd.tls.insecureskipverify", "true"},
 			cfg: func(cfg *Config) *Config {
-				cfg.Runtime.GOGC = 555
+				cfg.Registry.Etcd.TLS.InsecureSkipVerify = true
 				return cfg
 			},
 		},
 		{
-			args: []string{"-runtime.gomaxprocs", "555"},
+			args: []string{"-registry.zookeeper.addr", "zk1:2181,zk2:2181"},
 			cfg: func(cfg *Config) *Config {
-				cfg.Runtime.GOMAXPROCS = 555
+				cfg.Registry.Zookeeper.Addr = "zk1:2181,zk2:2181"
 				return cfg
 			},
 		},
 		{
-			args: []string{"-ui.access", "ro"},
+			args: []string{"-registry.zookeeper.sessiontimeout", "30s"},
 			cfg: func(cfg *Config) *Config {
-				cfg.UI.Access = "ro"
+				cfg.Registry.Zookeeper.SessionTimeout = 30 * time.Second
 				return cfg
 			},
 		},
 		{
-			args: []string{"-ui.access", "rw"},
+			args: []string{"-registry.zookeeper.servicespath", "/lb/services"},
 			cfg: func(cfg *Config) *Config {
-				cfg.UI.Access = "rw"
+				cfg.Registry.Zookeeper.ServicesPath = "/lb/services"
 				return cfg
 			},
 		},
 		{
-			args: []string{"-ui.addr", "1.2.3.4:5555"},
+			args: []string{"-registry.zookeeper.tagprefix", "route-"},
 			cfg: func(cfg *Config) *Config {
-				cfg.UI.Listen.Addr = "1.2.3.4:5555"
-				cfg.UI.Listen.Proto = "http"
-				cfg.Registry.Consul.ServiceAddr = "1.2.3.4:5555"
+				cfg.Registry.Zookeeper.TagPrefix = "route-"
 				return cfg
 			},
 		},
 		{
-			args: []string{"-ui.addr", ":9998;cs=ui", "-proxy.cs", "cs=ui;type=file;cert=value"},
+			args: []string{"-registry.zookeeper.routespath", "/lb/routes"},
 			cfg: func(cfg *Config) *Config {
-				cfg.UI.Listen.Addr = ":9998"
-				cfg.UI.Listen.Proto = "https"
-				cfg.UI.Listen.CertSource.Name = "ui"
-				cfg.UI.Listen.CertSource.Type = "file"
-				cfg.UI.Listen.CertSource.CertPath = "value"
-				cfg.Registry.Consul.CheckScheme = "https"
-				cfg.Registry.Consul.ServiceAddr = ":9998"
+				cfg.Registry.Zookeeper.RoutesPath = "/lb/routes"
 				return cfg
 			},
 		},
 		{
-			args: []string{"-ui.color", "value"},
+			args: []string{"-registry.zookeeper.kvpath", "/lb/config"},
 			cfg: func(cfg *Config) *Config {
-				cfg.UI.Color = "value"
+				cfg.Registry.Zookeeper.KVPath = "/lb/config"
 				return cfg
 			},
 		},
 		{
-			args: []string{"-ui.title", "value"},
+			args: []string{"-registry.zookeeper.noroutehtmlpath", "/lb/noroute.html"},
 			cfg: func(cfg *Config) *Config {
-				cfg.UI.Title = "value"
+				cfg.Registry.Zookeeper.NoRouteHTMLPath = "/lb/noroute.html"
 				return cfg
 			},
 		},
 		{
-			desc: "ignore aws.apigw.cert.cn",
-			args: []string{"-aws.apigw.cert.cn", "value"},
-			cfg:  func(cfg *Config) *Config { return cfg },
+			args: []string{"-registry.zookeeper.register.enabled", "true"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Registry.Zookeeper.Register = true
+				return cfg
+			},
 		},
-
-		// config file
 		{
-			desc:    "config from environ",
-			environ: []string{"FABIO_proxy_addr=:6666"},
+			args: []string{"-registry.zookeeper.register.name", "lb"},
 			cfg: func(cfg *Config) *Config {
-				cfg.Listen = []Listen{{Addr: ":6666", Proto: "http"}}
+				cfg.Registry.Zookeeper.ServiceName = "lb"
 				return cfg
 			},
 		},
 		{
-			desc: "config from url",
-			args: []string{"-cfg", "URL"},
-			path: "http",
-			data: "proxy.addr = :5555",
+			args: []string{"-registry.zookeeper.register.addr", "10.0.0.1:9998"},
 			cfg: func(cfg *Config) *Config {
-				cfg.Listen = []Listen{{Addr: ":5555", Proto: "http"}}
+				cfg.Registry.Zookeeper.ServiceAddr = "10.0.0.1:9998"
 				return cfg
 			},
 		},
 		{
-			desc: "config from file I",
-			args: []string{"-cfg", "/tmp/fabio-config-test"},
-			path: "/tmp/fabio-config-test",
-			data: "proxy.addr = :5555",
+			args: []string{"-registry.custom.host", "localhost:8080"},
 			cfg: func(cfg *Config) *Config {
-				cfg.Listen = []Listen{{Addr: ":5555", Proto: "http"}}
+				cfg.Registry.Custom.Host = "localhost:8080"
 				return cfg
 			},
 		},
 		{
-			desc: "config from file II",
-			args: []string{"-cfg=/tmp/fabio-config-test"},
-			path: "/tmp/fabio-config-test",
-			data: "proxy.addr = :5555",
+			args: []string{"-registry.custom.scheme", "https"},
 			cfg: func(cfg *Config) *Config {
-				cfg.Listen = []Listen{{Addr: ":5555", Proto: "http"}}
+				cfg.Registry.Custom.Scheme = "https"
 				return cfg
 			},
 		},
 		{
-			desc: "config from file III",
-			args: []string{"-cfg='/tmp/fabio-config-test'"},
-			path: "/tmp/fabio-config-test",
-			data: "proxy.addr = :5555",
+			args: []string{"-registry.custom.checkTLSSkipVerify", "true"},
 			cfg: func(cfg *Config) *Config {
-				cfg.Listen = []Listen{{Addr: ":5555", Proto: "http"}}
+				cfg.Registry.Custom.CheckTLSSkipVerify = true
 				return cfg
 			},
 		},
 		{
-			desc: "config from file IV",
-			args: []string{"-cfg=\"/tmp/fabio-config-test\""},
-			path: "/tmp/fabio-config-test",
-			data: "proxy.addr = :5555",
+			args: []string{"-registry.custom.timeout", "5s"},
 			cfg: func(cfg *Config) *Config {
-				cfg.Listen = []Listen{{Addr: ":5555", Proto: "http"}}
+				cfg.Registry.Custom.Timeout = 5 * time.Second
 				return cfg
 			},
 		},
-
-		// precedence rules
 		{
-			desc: "cmdline over config file I",
-			args: []string{"-cfg", "/tmp/fabio-config-test", "-proxy.addr", ":6666"},
-			path: "/tmp/fabio-config-test",
-			data: "proxy.addr = :5555",
+			args: []string{"-registry.custom.pollinterval", "5s"},
 			cfg: func(cfg *Config) *Config {
-				cfg.Listen = []Listen{{Addr: ":6666", Proto: "http"}}
+				cfg.Registry.Custom.PollInterval = 5 * time.Second
 				return cfg
 			},
 		},
 		{
-			desc: "cmdline over config file II",
-			args: []string{"-proxy.addr", ":6666", "-cfg", "/tmp/fabio-config-test"},
-			path: "/tmp/fabio-config-test",
-			data: "proxy.addr = :5555",
+			args: []string{"-registry.custom.path", "test"},
 			cfg: func(cfg *Config) *Config {
-				cfg.Listen = []Listen{{Addr: ":6666", Proto: "http"}}
+				cfg.Registry.Custom.Path = "test"
 				return cfg
 			},
 		},
 		{
-			desc:    "environ over config file",
-			args:    []string{"-cfg", "/tmp/fabio-config-test"},
-			environ: []string{"FABIO_proxy_addr=:6666"},
-			path:    "/tmp/fabio-config-test",
-			data:    "proxy.addr = :5555",
+			args: []string{"-registry.custom.queryparams", "test=1"},
 			cfg: func(cfg *Config) *Config {
-				cfg.Listen = []Listen{{Addr: ":6666", Proto: "http"}}
+				cfg.Registry.Custom.QueryParams = "test=1"
 				return cfg
 			},
 		},
 		{
-			desc:    "cmdline over environ",
-			args:    []string{"-proxy.addr", ":5555"},
-			environ: []string{"FABIO_proxy_addr=:6666"},
+			args: []string{"-registry.consul.pollinterval", "5s"},
 			cfg: func(cfg *Config) *Config {
-				cfg.Listen = []Listen{{Addr: ":5555", Proto: "http"}}
+				cfg.Registry.Consul.PollInterval = 5 * time.Second
 				return cfg
 			},
 		},
-
-		// errors
 		{
-			desc: "-proxy.addr with unknown cert source 'foo'",
-			args: []string{"-proxy.addr", ":5555;cs=foo"},
-			cfg:  func(cfg *Config) *Config { return nil },
-			err:  errors.New("unknown certificate source \"foo\""),
+			args: []string{"-registry.consul.register.aliasDomain", "service.consul"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Registry.Consul.AliasDomain = "service.consul"
+				return cfg
+			},
 		},
 		{
-			desc: "-proxy.addr with unknown proto 'foo'",
-			args: []string{"-proxy.addr", ":5555;proto=foo"},
-			cfg:  func(cfg *Config) *Config { return nil },
-			err:  errors.New("unknown protocol \"foo\""),
+			args: []string{"-registry.consul.rolloutpath", "/fabio/rollout"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Registry.Consul.RolloutPath = "/fabio/rollout"
+				return cfg
+			},
 		},
 		{
-			desc: "-proxy.addr with proto 'https' requires cert source",
-			args: []string{"-proxy.addr", ":5555;proto=https"},
-			cfg:  func(cfg *Config) *Config { return nil },
-			err:  errors.New("proto 'https' requires cert source"),
+			args: []string{"-registry.consul.rollout.duration", "10m", "-registry.consul.rollout.step", "1s"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Registry.Consul.RolloutDuration = 10 * time.Minute
+				cfg.Registry.Consul.RolloutStep = time.Second
+				return cfg
+			},
 		},
 		{
-			desc: "-proxy.addr with proto 'grpcs' requires cert source",
-			args: []string{"-proxy.addr", ":5555;proto=grpcs"},
-			cfg:  func(cfg *Config) *Config { return nil },
-			err:  errors.New("proto 'grpcs' requires cert source"),
+			args: []string{"-registry.consul.flagspath", "/fabio/flags"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Registry.Consul.FlagsPath = "/fabio/flags"
+				return cfg
+			},
 		},
 		{
-			desc: "-proxy.addr with cert source and proto 'http' requires proto 'https', 'tcp', or 'grpcs'",
-			args: []string{"-proxy.addr", ":5555;cs=name;proto=http", "-proxy.cs", "cs=name;type=path;cert=value"},
-			cfg:  func(cfg *Config) *Config { return nil },
-			err:  errors.New("cert source requires proto 'https', 'tcp', 'tcp-dynamic', 'https+tcp+sni', or 'grpcs'"),
+			args: []string{"-proxy.timeout.headers", "X-Request-Timeout, grpc-timeout", "-proxy.timeout.max", "30s"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.TimeoutHeaders = []string{"X-Request-Timeout", "grpc-timeout"}
+				cfg.Proxy.TimeoutMax = 30 * time.Second
+				return cfg
+			},
 		},
 		{
-			desc: "-proxy.addr with cert source and proto 'tcp+sni' requires proto 'https', 'tcp' or 'grpcs'",
-			args: []string{"-proxy.addr", ":5555;cs=name;proto=tcp+sni", "-proxy.cs", "cs=name;type=path;cert=value"},
-			cfg:  func(cfg *Config) *Config { return nil },
-			err:  errors.New("cert source requires proto 'https', 'tcp', 'tcp-dynamic', 'https+tcp+sni', or 'grpcs'"),
+			args: []string{"-log.access.format", "foobar"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Log.AccessFormat = "foobar"
+				return cfg
+			},
 		},
 		{
-			desc: "-proxy.noroutestatus too small",
-			args: []string{"-proxy.noroutestatus", "10"},
-			cfg:  func(cfg *Config) *Config { return nil },
-			err:  errors.New("proxy.noroutestatus must be between 100 and 999"),
+			args: []string{"-log.access.format", "json", "-log.access.json.fields", "time_rfc3339, route ,trace_id"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Log.AccessFormat = "json"
+				cfg.Log.AccessJSONFields = []string{"time_rfc3339", "route", "trace_id"}
+				return cfg
+			},
 		},
 		{
-			desc: "-proxy.noroutestatus too big",
-			args: []string{"-proxy.noroutestatus", "1000"},
-			cfg:  func(cfg *Config) *Config { return nil },
-			err:  errors.New("proxy.noroutestatus must be between 100 and 999"),
+			args: []string{"-log.access.target", "foobar"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Log.AccessTarget = "foobar"
+				return cfg
+			},
 		},
 		{
-			desc: "-proxy.auth with unknown auth type 'foo'",
-			args: []string{"-proxy.auth", "name=myauth;type=foo"},
-			cfg:  func(cfg *Config) *Config { return nil },
-			err:  errors.New("unknown auth type 'foo'"),
+			args: []string{"-log.redact.headers", "Authorization,X-*-Token"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Log.Redact.Headers = []string{"Authorization", "X-*-Token"}
+				return cfg
+			},
 		},
 		{
-			desc: "-proxy.auth with missing name",
-			args: []string{"-proxy.auth", "type=basic;file=/some/file;realm=realm"},
-			cfg:  func(cfg *Config) *Config { return nil },
-			err:  errors.New("missing 'name' in auth"),
+			args: []string{"-log.redact.query", "token,api_key"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Log.Redact.Query = []string{"token", "api_key"}
+				return cfg
+			},
 		},
 		{
-			desc: "-proxy.auth basic with missing file",
-			args: []string{"-proxy.auth", "name=foo;type=basic;realm=realm"},
-			cfg:  func(cfg *Config) *Config { return nil },
-			err:  errors.New("missing 'file' in auth 'foo'"),
+			args: []string{"-log.redact.cookies", "session*"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Log.Redact.Cookies = []string{"session*"}
+				return cfg
+			},
+		},
+		{
+			args: []string{"-log.routes.format", "foobar"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Log.RoutesFormat = "foobar"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-log.routes.webhook", "http://foo.com/hook"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Log.RoutesHook = "http://foo.com/hook"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-log.routes.max", "0"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Log.RoutesMax = 0
+				return cfg
+			},
+		},
+		{
+			args: []string{"-log.noroute.target", "stderr", "-log.noroute.sample", "0.1", "-log.noroute.topk", "0"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Log.NoRoute = NoRouteLog{Target: "stderr", Sample: 0.1, TopK: 0}
+				return cfg
+			},
+		},
+		{
+			args: []string{"-log.access.target", "file:/var/log/fabio/access.log", "-log.access.file.maxsize", "100", "-log.access.file.interval", "24h", "-log.access.file.maxbackups", "7", "-log.access.file.compress"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Log.AccessTarget = "file:/var/log/fabio/access.log"
+				cfg.Log.AccessFile = AccessFile{MaxSize: 100, Interval: 24 * time.Hour, MaxBackups: 7, Compress: true}
+				return cfg
+			},
+		},
+		{
+			args: []string{"-log.target", "syslog", "-log.syslog.addr", "tls://logs.example.com:6514", "-log.syslog.facility", "daemon", "-log.syslog.tag", "lb", "-log.syslog.tls.cafile", "/etc/ssl/syslog.pem"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Log.Target = "syslog"
+				cfg.Log.Syslog = Syslog{Addr: "tls://logs.example.com:6514", Facility: "daemon", Tag: "lb", TLSCAFile: "/etc/ssl/syslog.pem"}
+				return cfg
+			},
+		},
+		{
+			args: []string{"-log.format", "json", "-log.banner=false"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Log.Format = "json"
+				cfg.Log.Banner = false
+				return cfg
+			},
+		},
+		{
+			args: []string{"-log.level", "foobar"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Log.Level = "foobar"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-metrics.target", "some-target"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Metrics.Target = "some-target"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-metrics.prefix", "some-prefix"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Metrics.Prefix = "some-prefix"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-metrics.names", "some names"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Metrics.Names = "some names"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-metrics.interval", "5ms"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Metrics.Interval = 5 * time.Millisecond
+				return cfg
+			},
+		},
+		{
+			args: []string{"-metrics.timeout", "5s"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Metrics.Timeout = 5 * time.Second
+				return cfg
+			},
+		},
+		{
+			args: []string{"-metrics.retry", "500ms"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Metrics.Retry = 500 * time.Millisecond
+				return cfg
+			},
+		},
+		{
+			args: []string{"-metrics.gc.grace", "5m"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Metrics.GCGrace = 5 * time.Minute
+				return cfg
+			},
+		},
+		{
+			args: []string{"-metrics.tls.sni.topk", "10"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Metrics.TLSSNITopK = 10
+				return cfg
+			},
+		},
+		{
+			args: []string{"-metrics.useragent=true"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Metrics.UserAgent = true
+				return cfg
+			},
+		},
+		{
+			args: []string{"-metrics.graphite.addr", "1.2.3.4:5555"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Metrics.GraphiteAddr = "1.2.3.4:5555"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-metrics.statsd.addr", "1.2.3.4:5555"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Metrics.StatsDAddr = "1.2.3.4:5555"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-metrics.circonus.apiapp", "value"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Metrics.Circonus.APIApp = "value"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-metrics.circonus.apikey", "value"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Metrics.Circonus.APIKey = "value"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-metrics.circonus.apiurl", "value"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Metrics.Circonus.APIURL = "value"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-metrics.circonus.brokerid", "value"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Metrics.Circonus.BrokerID = "value"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-metrics.prometheus.addr", ":9100"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Metrics.Prometheus.Addr = ":9100"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-metrics.prometheus.namespace", "lb"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Metrics.Prometheus.Namespace = "lb"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-metrics.prometheus.labels", "env=prod, dc=dc1"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Metrics.Prometheus.Labels = map[string]string{"env": "prod", "dc": "dc1"}
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.capture.target", "/var/log/fabio/capture.har"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.Capture.Target = "/var/log/fabio/capture.har"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.capture.format", "har"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.Capture.Format = "har"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.capture.rate", "0"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.Capture.Rate = 0
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.capture.maxbody", "1024"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.Capture.MaxBody = 1024
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.cache.store", "disk", "-proxy.cache.dir", "/var/cache/fabio", "-proxy.cache.maxsize", "1048576", "-proxy.cache.maxentry", "65536"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.Cache = Cache{Store: "disk", Dir: "/var/cache/fabio", MaxSize: 1048576, MaxEntry: 65536}
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.bodyrewrite.maxbody", "4096"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.BodyRewriteMaxBody = 4096
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.mirror.header", "X-Shadow", "-proxy.mirror.strip", "auth,X-Api-Key", "-proxy.mirror.timeout", "5s", "-proxy.mirror.maxbody", "65536", "-proxy.mirror.maxconn", "10"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.Mirror = Mirror{Header: "X-Shadow", Strip: []string{"auth", "X-Api-Key"}, Timeout: 5 * time.Second, MaxBody: 65536, MaxConn: 10}
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.maxbody", "10485760"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.MaxBody = 10485760
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.ratelimit", "600r/m", "-proxy.ratelimit.burst", "20", "-proxy.ratelimit.body", "slow down"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.RateLimit = RateLimit{Rate: 10, Burst: 20, Body: "slow down"}
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.sticky.secret", "s3cr3t"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.StickySecret = "s3cr3t"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.debug.header", "X-Fabio-Debug", "-proxy.debug.secret", "s3cr3t", "-proxy.debug.maxttl", "10m"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.DebugHeader = "X-Fabio-Debug"
+				cfg.Proxy.DebugSecret = "s3cr3t"
+				cfg.Proxy.DebugMaxTTL = 10 * time.Minute
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.trace.header", "X-Fabio-Trace", "-proxy.trace.secret", "s3cr3t", "-proxy.trace.maxttl", "10m"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.TraceHeader = "X-Fabio-Trace"
+				cfg.Proxy.TraceSecret = "s3cr3t"
+				cfg.Proxy.TraceMaxTTL = 10 * time.Minute
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.killswitch.status", "502", "-proxy.killswitch.contenttype", "text/plain", "-proxy.killswitch.body", "down", "-proxy.killswitch.ttl", "1h"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.KillSwitch = KillSwitch{Status: 502, ContentType: "text/plain", Body: "down", TTL: time.Hour}
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.outlier.threshold", "0.3", "-proxy.outlier.minrequests", "50", "-proxy.outlier.window", "30s", "-proxy.outlier.ejection", "1m", "-proxy.outlier.maxejected", "0.2", "-proxy.outlier.rampup", "2m", "-proxy.outlier.latency", "2s"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.Outlier = Outlier{
+					Threshold:   0.3,
+					MinRequests: 50,
+					Window:      30 * time.Second,
+					Ejection:    time.Minute,
+					MaxEjected:  0.2,
+					RampUp:      2 * time.Minute,
+					Latency:     2 * time.Second,
+				}
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.middleware", "auth, headers"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.Middleware = []string{"auth", "headers"}
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.middleware", "none"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.Middleware = []string{}
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.capture.redact", "Authorization,ssn"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.Capture.Redact = []string{"Authorization", "ssn"}
+				return cfg
+			},
+		},
+		{
+			args: []string{"-tracing.otlp.protocol", "grpc"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Tracing.OTLP.Protocol = "grpc"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-tracing.otlp.endpoint", "https://otel:4318"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Tracing.OTLP.Endpoint = "https://otel:4318"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-tracing.otlp.sampler", "always_on"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Tracing.OTLP.Sampler = "always_on"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-tracing.otlp.resource", "deployment.environment=prod, host.name=lb1"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Tracing.OTLP.Resource = map[string]string{"deployment.environment": "prod", "host.name": "lb1"}
+				return cfg
+			},
+		},
+		{
+			args: []string{"-tracing.otlp.headers", "api-key=a=b"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Tracing.OTLP.Headers = map[string]string{"api-key": "a=b"}
+				return cfg
+			},
+		},
+		{
+			args: []string{"-metrics.prometheus.buckets", "0.01,0.1,1"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Metrics.Prometheus.Buckets = []float64{0.01, 0.1, 1}
+				return cfg
+			},
+		},
+		{
+			args: []string{"-metrics.circonus.checkid", "value"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Metrics.Circonus.CheckID = "value"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-metrics.circonus.submissionurl", "value"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Metrics.Circonus.SubmissionURL = "value"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-runtime.gogc", "555"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Runtime.GOGC = 555
+				return cfg
+			},
+		},
+		{
+			args: []string{"-runtime.gomaxprocs", "555"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Runtime.GOMAXPROCS = 555
+				return cfg
+			},
+		},
+		{
+			args: []string{"-snapshot.target", "s3://backups/fabio?region=eu-west-1", "-snapshot.interval", "15m", "-snapshot.keep", "10"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Snapshot.Target = "s3://backups/fabio?region=eu-west-1"
+				cfg.Snapshot.Interval = 15 * time.Minute
+				cfg.Snapshot.Keep = 10
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.failover.threshold", "0.3", "-proxy.failover.recover", "1", "-proxy.failover.hold", "5m"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.Failover = Failover{Threshold: 0.3, Recover: 1, Hold: 5 * time.Minute}
+				return cfg
+			},
+		},
+		{
+			args: []string{"-ha.mode", "consul", "-ha.lock.key", "lb/ha", "-ha.lock.ttl", "20s", "-ha.hook.promote", "/usr/local/bin/vip up", "-ha.hook.demote", "/usr/local/bin/vip down", "-ha.hook.timeout", "5s"},
+			cfg: func(cfg *Config) *Config {
+				cfg.HA.Mode = "consul"
+				cfg.HA.LockKey = "lb/ha"
+				cfg.HA.LockTTL = 20 * time.Second
+				cfg.HA.Promote = "/usr/local/bin/vip up"
+				cfg.HA.Demote = "/usr/local/bin/vip down"
+				cfg.HA.HookTimeout = 5 * time.Second
+				return cfg
+			},
+		},
+		{
+			args: []string{"-runtime.nofile.raise=false", "-runtime.nofile.warn", "0.9"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Runtime.NoFileRaise = false
+				cfg.Runtime.NoFileWarn = 0.9
+				return cfg
+			},
+		},
+		{
+			args: []string{"-ui.access", "ro"},
+			cfg: func(cfg *Config) *Config {
+				cfg.UI.Access = "ro"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-ui.access", "rw"},
+			cfg: func(cfg *Config) *Config {
+				cfg.UI.Access = "rw"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-ui.addr", "1.2.3.4:5555"},
+			cfg: func(cfg *Config) *Config {
+				cfg.UI.Listen.Addr = "1.2.3.4:5555"
+				cfg.UI.Listen.Proto = "http"
+				cfg.Registry.Consul.ServiceAddr = "1.2.3.4:5555"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-ui.addr", ":9998;cs=ui", "-proxy.cs", "cs=ui;type=file;cert=value"},
+			cfg: func(cfg *Config) *Config {
+				cfg.UI.Listen.Addr = ":9998"
+				cfg.UI.Listen.Proto = "https"
+				cfg.UI.Listen.CertSource.Name = "ui"
+				cfg.UI.Listen.CertSource.Type = "file"
+				cfg.UI.Listen.CertSource.CertPath = "value"
+				cfg.Registry.Consul.CheckScheme = "https"
+				cfg.Registry.Consul.ServiceAddr = ":9998"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-ui.addr", "unix:/var/run/fabio.sock;sockmode=660", "-registry.consul.register.addr", ":9998"},
+			cfg: func(cfg *Config) *Config {
+				cfg.UI.Listen.Addr = "unix:/var/run/fabio.sock"
+				cfg.UI.Listen.Proto = "http"
+				cfg.UI.Listen.SocketMode = 0660
+				cfg.Registry.Consul.ServiceAddr = ":9998"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-ui.localonly", "-ui.addr", "unix:/var/run/fabio.sock", "-registry.consul.register.enabled=false"},
+			cfg: func(cfg *Config) *Config {
+				cfg.UI.LocalOnly = true
+				cfg.UI.Listen.Addr = "unix:/var/run/fabio.sock"
+				cfg.UI.Listen.Proto = "http"
+				cfg.Registry.Consul.Register = false
+				cfg.Registry.Consul.ServiceAddr = "unix:/var/run/fabio.sock"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-ui.localonly", "-ui.addr", "127.0.0.1:9998;cs=ui", "-proxy.cs", "cs=ui;type=file;cert=value;clientca=ca"},
+			cfg: func(cfg *Config) *Config {
+				cfg.UI.LocalOnly = true
+				cfg.UI.Listen.Addr = "127.0.0.1:9998"
+				cfg.UI.Listen.Proto = "https"
+				cfg.UI.Listen.CertSource.Name = "ui"
+				cfg.UI.Listen.CertSource.Type = "file"
+				cfg.UI.Listen.CertSource.CertPath = "value"
+				cfg.UI.Listen.CertSource.ClientCAPath = "ca"
+				cfg.Registry.Consul.CheckScheme = "https"
+				cfg.Registry.Consul.ServiceAddr = "127.0.0.1:9998"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-ui.metrics"},
+			cfg: func(cfg *Config) *Config {
+				cfg.UI.Metrics = true
+				return cfg
+			},
+		},
+		{
+			args: []string{"-ui.color", "value"},
+			cfg: func(cfg *Config) *Config {
+				cfg.UI.Color = "value"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-ui.title", "value"},
+			cfg: func(cfg *Config) *Config {
+				cfg.UI.Title = "value"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-ui.pages.dir", "/etc/fabio/pages"},
+			cfg: func(cfg *Config) *Config {
+				cfg.UI.PagesDir = "/etc/fabio/pages"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-ui.pagesize", "50"},
+			cfg: func(cfg *Config) *Config {
+				cfg.UI.PageSize = 50
+				return cfg
+			},
+		},
+		{
+			args: []string{"-ui.mount", "/_fabio/"},
+			cfg: func(cfg *Config) *Config {
+				cfg.UI.Mount = "/_fabio"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-ui.mount", "/_fabio", "-ui.mount.rw"},
+			cfg: func(cfg *Config) *Config {
+				cfg.UI.Mount = "/_fabio"
+				cfg.UI.MountRW = true
+				return cfg
+			},
+		},
+		{
+			args: []string{"-ui.peers", "http://10.0.0.2:9998/,https://fabio-3:9998"},
+			cfg: func(cfg *Config) *Config {
+				cfg.UI.Peers = []string{"http://10.0.0.2:9998", "https://fabio-3:9998"}
+				return cfg
+			},
+		},
+		{
+			args: []string{"-ui.group", "env"},
+			cfg: func(cfg *Config) *Config {
+				cfg.UI.Group = "env"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-ui.group.colors", "prod=red, staging=deep-orange darken-2"},
+			cfg: func(cfg *Config) *Config {
+				cfg.UI.GroupColors = map[string]string{"prod": "red", "staging": "deep-orange darken-2"}
+				return cfg
+			},
+		},
+		{
+			desc: "ignore aws.apigw.cert.cn",
+			args: []string{"-aws.apigw.cert.cn", "value"},
+			cfg:  func(cfg *Config) *Config { return cfg },
+		},
+
+		// config file
+		{
+			desc:    "config from environ",
+			environ: []string{"FABIO_proxy_addr=:6666"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Listen = []Listen{{Addr: ":6666", Proto: "http"}}
+				return cfg
+			},
+		},
+		{
+			desc: "config from url",
+			args: []string{"-cfg", "URL"},
+			path: "http",
+			data: "proxy.addr = :5555",
+			cfg: func(cfg *Config) *Config {
+				cfg.Listen = []Listen{{Addr: ":5555", Proto: "http"}}
+				return cfg
+			},
+		},
+		{
+			desc: "config from file I",
+			args: []string{"-cfg", "/tmp/fabio-config-test"},
+			path: "/tmp/fabio-config-test",
+			data: "proxy.addr = :5555",
+			cfg: func(cfg *Config) *Config {
+				cfg.Listen = []Listen{{Addr: ":5555", Proto: "http"}}
+				return cfg
+			},
+		},
+		{
+			desc: "config from file II",
+			args: []string{"-cfg=/tmp/fabio-config-test"},
+			path: "/tmp/fabio-config-test",
+			data: "proxy.addr = :5555",
+			cfg: func(cfg *Config) *Config {
+				cfg.Listen = []Listen{{Addr: ":5555", Proto: "http"}}
+				return cfg
+			},
+		},
+		{
+			desc: "config from file III",
+			args: []string{"-cfg='/tmp/fabio-config-test'"},
+			path: "/tmp/fabio-config-test",
+			data: "proxy.addr = :5555",
+			cfg: func(cfg *Config) *Config {
+				cfg.Listen = []Listen{{Addr: ":5555", Proto: "http"}}
+				return cfg
+			},
+		},
+		{
+			desc: "config from file IV",
+			args: []string{"-cfg=\"/tmp/fabio-config-test\""},
+			path: "/tmp/fabio-config-test",
+			data: "proxy.addr = :5555",
+			cfg: func(cfg *Config) *Config {
+				cfg.Listen = []Listen{{Addr: ":5555", Proto: "http"}}
+				return cfg
+			},
+		},
+
+		// precedence rules
+		{
+			desc: "cmdline over config file I",
+			args: []string{"-cfg", "/tmp/fabio-config-test", "-proxy.addr", ":6666"},
+			path: "/tmp/fabio-config-test",
+			data: "proxy.addr = :5555",
+			cfg: func(cfg *Config) *Config {
+				cfg.Listen = []Listen{{Addr: ":6666", Proto: "http"}}
+				return cfg
+			},
+		},
+		{
+			desc: "cmdline over config file II",
+			args: []string{"-proxy.addr", ":6666", "-cfg", "/tmp/fabio-config-test"},
+			path: "/tmp/fabio-config-test",
+			data: "proxy.addr = :5555",
+			cfg: func(cfg *Config) *Config {
+				cfg.Listen = []Listen{{Addr: ":6666", Proto: "http"}}
+				return cfg
+			},
+		},
+		{
+			desc:    "environ over config file",
+			args:    []string{"-cfg", "/tmp/fabio-config-test"},
+			environ: []string{"FABIO_proxy_addr=:6666"},
+			path:    "/tmp/fabio-config-test",
+			data:    "proxy.addr = :5555",
+			cfg: func(cfg *Config) *Config {
+				cfg.Listen = []Listen{{Addr: ":6666", Proto: "http"}}
+				return cfg
+			},
+		},
+		{
+			desc:    "cmdline over environ",
+			args:    []string{"-proxy.addr", ":5555"},
+			environ: []string{"FABIO_proxy_addr=:6666"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Listen = []Listen{{Addr: ":5555", Proto: "http"}}
+				return cfg
+			},
+		},
+
+		// errors
+		{
+			desc: "-proxy.tls.certprefer with invalid value",
+			args: []string{"-proxy.tls.certprefer", "foo"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid certificate preference \"foo\". Must be one of 'exact' or 'wildcard'"),
+		},
+		{
+			desc: "-proxy.addr with invalid nosni value",
+			args: []string{"-proxy.addr", ":5555;nosni=foo"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid nosni value \"foo\". Must be one of 'default' or 'fail'"),
+		},
+		{
+			desc: "-proxy.addr with invalid h2maxframesize",
+			args: []string{"-proxy.addr", ":5555;h2maxframesize=1024"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("h2maxframesize must be between 16384 and 16777215"),
+		},
+		{
+			desc: "-proxy.addr with invalid h2streamwindow",
+			args: []string{"-proxy.addr", ":5555;h2streamwindow=foo"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("h2streamwindow must be between 65535 and 2147483647"),
+		},
+		{
+			desc: "-proxy.addr with invalid h2connwindow",
+			args: []string{"-proxy.addr", ":5555;h2connwindow=65534"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("h2connwindow must be between 65535 and 2147483647"),
+		},
+		{
+			desc: "-proxy.addr with negative h2idletimeout",
+			args: []string{"-proxy.addr", ":5555;h2idletimeout=-1s"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("h2idletimeout must not be negative"),
+		},
+		{
+			desc: "-metrics.prometheus.labels with invalid label name",
+			args: []string{"-metrics.prometheus.labels", "1env=prod"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid prometheus label \"1env=prod\""),
+		},
+		{
+			desc: "-proxy.capture.format with unknown format",
+			args: []string{"-proxy.capture.format", "pcap"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid proxy.capture.format: pcap"),
+		},
+		{
+			desc: "-proxy.ratelimit invalid",
+			args: []string{"-proxy.ratelimit", "100/s"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid proxy.ratelimit: 100/s"),
+		},
+		{
+			desc: "-proxy.ratelimit.burst negative",
+			args: []string{"-proxy.ratelimit.burst", "-1"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid proxy.ratelimit.burst: -1"),
+		},
+		{
+			desc: "-proxy.capture.rate negative",
+			args: []string{"-proxy.capture.rate", "-1"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid proxy.capture.rate: -1"),
+		},
+		{
+			desc: "-registry.nomad.addr without scheme",
+			args: []string{"-registry.nomad.addr", "localhost:4646"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid registry.nomad.addr: localhost:4646"),
+		},
+		{
+			desc: "-registry.nomad.waittime not positive",
+			args: []string{"-registry.nomad.waittime", "0s"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid registry.nomad.waittime: 0s"),
+		},
+		{
+			desc: "-registry.etcd.addr with invalid endpoint",
+			args: []string{"-registry.etcd.addr", "http://10.0.0.1:2379,10.0.0.2:2379"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid registry.etcd.addr: http://10.0.0.1:2379,10.0.0.2:2379"),
+		},
+		{
+			desc: "-registry.zookeeper.addr without port",
+			args: []string{"-registry.zookeeper.addr", "zk1:2181,zk2"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid registry.zookeeper.addr: zk1:2181,zk2"),
+		},
+		{
+			desc: "-registry.zookeeper.sessiontimeout zero",
+			args: []string{"-registry.zookeeper.sessiontimeout", "0s"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid registry.zookeeper.sessiontimeout: 0s"),
+		},
+		{
+			desc: "-registry.zookeeper.kvpath with trailing slash",
+			args: []string{"-registry.zookeeper.kvpath", "/fabio/config/"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid registry.zookeeper.kvpath: /fabio/config/"),
+		},
+		{
+			desc: "-registry.zookeeper.routespath relative",
+			args: []string{"-registry.zookeeper.routespath", "fabio/routes"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid registry.zookeeper.routespath: fabio/routes"),
+		},
+		{
+			desc: "-log.redact.headers with invalid pattern",
+			args: []string{"-log.redact.headers", "X-[Token"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New(`invalid log.redact.headers: "X-[Token" is not a valid pattern`),
+		},
+		{
+			desc: "-proxy.mirror.header invalid",
+			args: []string{"-proxy.mirror.header", "X Shadow"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid proxy.mirror.header: X Shadow"),
+		},
+		{
+			desc: "-proxy.mirror.strip invalid",
+			args: []string{"-proxy.mirror.strip", "auth,a:b"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid proxy.mirror.strip: auth,a:b"),
+		},
+		{
+			desc: "-proxy.mirror.timeout zero",
+			args: []string{"-proxy.mirror.timeout", "0"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid proxy.mirror.timeout: 0s"),
+		},
+		{
+			desc: "-proxy.mirror.maxbody negative",
+			args: []string{"-proxy.mirror.maxbody", "-1"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid proxy.mirror.maxbody: -1"),
+		},
+		{
+			desc: "-proxy.mirror.maxconn zero",
+			args: []string{"-proxy.mirror.maxconn", "0"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid proxy.mirror.maxconn: 0"),
+		},
+		{
+			desc: "-proxy.capture.maxbody negative",
+			args: []string{"-proxy.capture.maxbody", "-1"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid proxy.capture.maxbody: -1"),
+		},
+		{
+			desc: "-proxy.gzip.minsize negative",
+			args: []string{"-proxy.gzip.minsize", "-1"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid proxy.gzip.minsize: -1"),
+		},
+		{
+			desc: "-proxy.cache.store with unknown store",
+			args: []string{"-proxy.cache.store", "redis"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid proxy.cache.store: redis"),
+		},
+		{
+			desc: "-proxy.cache.store disk without directory",
+			args: []string{"-proxy.cache.store", "disk"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid proxy.cache.store: disk. Missing proxy.cache.dir"),
+		},
+		{
+			desc: "-proxy.cache.maxsize negative",
+			args: []string{"-proxy.cache.maxsize", "-1"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid proxy.cache.maxsize: -1"),
+		},
+		{
+			desc: "-proxy.cache.maxentry negative",
+			args: []string{"-proxy.cache.maxentry", "-1"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid proxy.cache.maxentry: -1"),
+		},
+		{
+			desc: "-proxy.bodyrewrite.maxbody negative",
+			args: []string{"-proxy.bodyrewrite.maxbody", "-1"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid proxy.bodyrewrite.maxbody: -1"),
+		},
+		{
+			desc: "-proxy.maxbody negative",
+			args: []string{"-proxy.maxbody", "-1"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid proxy.maxbody: -1"),
+		},
+		{
+			desc: "-tracing.otlp.protocol with unknown protocol",
+			args: []string{"-tracing.otlp.protocol", "thrift"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid tracing.otlp.protocol: thrift"),
+		},
+		{
+			desc: "-tracing.otlp.sampler with unknown sampler",
+			args: []string{"-tracing.otlp.sampler", "sometimes"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid tracing.otlp.sampler: sometimes"),
+		},
+		{
+			desc: "-tracing.otlp.resource without value",
+			args: []string{"-tracing.otlp.resource", "env"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid tracing.otlp.resource: \"env\" is not a key=value pair"),
+		},
+		{
+			desc: "-metrics.prometheus.buckets not increasing",
+			args: []string{"-metrics.prometheus.buckets", "1,0.1"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid prometheus buckets \"1,0.1\". Must be increasing positive numbers"),
+		},
+		{
+			desc: "-ui.addr with invalid sockmode",
+			args: []string{"-ui.addr", "unix:/var/run/fabio.sock;sockmode=999"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid sockmode \"999\""),
+		},
+		{
+			desc: "-proxy.addr with invalid ttl",
+			args: []string{"-proxy.addr", ":5555;ttl=256"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("ttl must be between 1 and 255"),
+		},
+		{
+			desc: "-proxy.addr with invalid dscp",
+			args: []string{"-proxy.addr", ":5555;dscp=64"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("dscp must be between 0 and 63"),
+		},
+		{
+			desc: "-proxy.addr with socket options on unix socket",
+			args: []string{"-proxy.addr", "unix:/var/run/fabio.sock;freebind=true"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("socket options are not supported for unix sockets"),
+		},
+		{
+			desc: "-proxy.addr with proto=udp on unix socket",
+			args: []string{"-proxy.addr", "unix:/var/run/fabio.sock;proto=udp"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("proto 'udp' requires host:port"),
+		},
+		{
+			desc: "-proxy.addr with proto=udp and pxyproto",
+			args: []string{"-proxy.addr", ":5353;proto=udp;pxyproto=true"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("proto 'udp' does not support pxyproto"),
+		},
+		{
+			desc: "-registry.replay.speed negative",
+			args: []string{"-registry.replay.speed", "-1"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid registry.replay.speed: -1"),
+		},
+		{
+			desc: "-registry.debounce.max less than window",
+			args: []string{"-registry.debounce", "2s", "-registry.debounce.max", "1s"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid registry.debounce.max: 1s. Must not be less than registry.debounce"),
+		},
+		{
+			desc: "-registry.flap.threshold 1",
+			args: []string{"-registry.flap.threshold", "1"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid registry.flap.threshold: 1. Must be 0 or at least 2"),
+		},
+		{
+			desc: "-registry.flap.hold zero",
+			args: []string{"-registry.flap.hold", "0"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid registry.flap.hold: 0s"),
+		},
+		{
+			desc: "-registry.flap.weight 1",
+			args: []string{"-registry.flap.weight", "1"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid registry.flap.weight: 1. Must be in [0,1)"),
+		},
+		{
+			desc: "-registry.record.path with custom backend",
+			args: []string{"-registry.backend", "custom", "-registry.record.path", "/tmp/registry.rec"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("registry.record.path is not supported for the custom backend"),
+		},
+		{
+			desc: "-proxy.addr with invalid pxytrusted",
+			args: []string{"-proxy.addr", ":5555;pxyproto=true;pxytrusted=10.0.0.0/33"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New(`invalid network "10.0.0.0/33"`),
+		},
+		{
+			desc: "-proxy.addr with pxytrusted without pxyproto",
+			args: []string{"-proxy.addr", ":5555;pxytrusted=10.0.0.0/8"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("pxytrusted requires pxyproto=true"),
+		},
+		{
+			desc: "-proxy.addr with pxyproto without pxytrusted",
+			args: []string{"-proxy.addr", ":5555;pxyproto=true"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("pxyproto=true requires pxytrusted"),
+		},
+		{
+			desc: "-proxy.addr with invalid pxyout",
+			args: []string{"-proxy.addr", ":5555;pxyout=true"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New(`invalid pxyout "true". Must be v1 or v2`),
+		},
+		{
+			desc: "-proxy.addr with invalid maxheaders",
+			args: []string{"-proxy.addr", ":5555;maxheaders=0"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New(`invalid maxheaders "0"`),
+		},
+		{
+			desc: "-proxy.addr with invalid maxheaderbytes",
+			args: []string{"-proxy.addr", ":5555;maxheaderbytes=16KB"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New(`invalid maxheaderbytes "16KB"`),
+		},
+		{
+			desc: "-proxy.addr with invalid ipmode",
+			args: []string{"-proxy.addr", ":5555;ipmode=v5"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New(`invalid ipmode "v5". Must be v4, v6 or dual`),
+		},
+		{
+			desc: "-proxy.addr with ipmode=v4 and IPv6 address",
+			args: []string{"-proxy.addr", "[::1]:5555;ipmode=v4"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("ipmode 'v4' requires an IPv4 address or a hostname. Got [::1]:5555"),
+		},
+		{
+			desc: "-proxy.addr with ipmode=v6 and IPv4 address",
+			args: []string{"-proxy.addr", "127.0.0.1:5555;ipmode=v6"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("ipmode 'v6' requires an IPv6 address or a hostname. Got 127.0.0.1:5555"),
+		},
+		{
+			desc: "-proxy.addr with ipmode=dual and specific address",
+			args: []string{"-proxy.addr", "[::1]:5555;ipmode=dual"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("ipmode 'dual' requires a wildcard address like :9999 or [::]:9999. Got [::1]:5555"),
+		},
+		{
+			desc: "-proxy.addr with ipmode and unix socket",
+			args: []string{"-proxy.addr", "unix:/var/run/fabio.sock;ipmode=v4"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("ipmode is not supported for unix sockets"),
+		},
+		{
+			desc: "-proxy.addr with grpcweb and proto=http",
+			args: []string{"-proxy.addr", ":5555;grpcweb=true"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("grpcweb requires proto 'grpc' or 'grpcs'"),
+		},
+		{
+			desc: "-proxy.addr with pxyout and proto=grpc",
+			args: []string{"-proxy.addr", ":5555;proto=grpc;pxyout=v1"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("proto 'grpc' does not support pxyout"),
+		},
+		{
+			desc: "-ui.addr with unix socket and consul registration",
+			args: []string{"-ui.addr", "unix:/var/run/fabio.sock"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("ui.addr is a unix socket. Set registry.consul.register.addr or disable registry.consul.register.enabled"),
+		},
+		{
+			desc: "-ui.mount without leading slash",
+			args: []string{"-ui.mount", "_fabio"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid ui.mount: _fabio"),
+		},
+		{
+			desc: "-ui.mount with -ui.localonly",
+			args: []string{"-ui.mount", "/_fabio", "-ui.localonly", "-ui.addr", "unix:/var/run/fabio.sock", "-registry.consul.register.enabled=false"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("ui.mount cannot be used with ui.localonly"),
+		},
+		{
+			desc: "-ui.mount.rw with -ui.access ro",
+			args: []string{"-ui.mount", "/_fabio", "-ui.mount.rw", "-ui.access", "ro"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("ui.mount.rw requires ui.access = rw"),
+		},
+		{
+			desc: "-ui.peers without scheme",
+			args: []string{"-ui.peers", "10.0.0.2:9998"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid ui.peers: 10.0.0.2:9998"),
+		},
+		{
+			desc: "-ui.localonly with public address",
+			args: []string{"-ui.localonly", "-ui.addr", ":9998"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("ui.localonly: :9998 is neither a unix socket nor a loopback address"),
+		},
+		{
+			desc: "-ui.localonly with loopback address without client auth",
+			args: []string{"-ui.localonly", "-ui.addr", "127.0.0.1:9998"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("ui.localonly: 127.0.0.1:9998 requires a certificate source with client authentication"),
+		},
+		{
+			desc: "-proxy.addr with unknown cert source 'foo'",
+			args: []string{"-proxy.addr", ":5555;cs=foo"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("unknown certificate source \"foo\""),
+		},
+		{
+			desc: "-proxy.cs acme without domains",
+			args: []string{"-proxy.cs", "cs=name;type=acme;cert=/tmp/acme"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("missing 'domains' in map[cert:/tmp/acme cs:name type:acme]"),
+		},
+		{
+			desc: "-proxy.cs acme with invalid challenge",
+			args: []string{"-proxy.cs", "cs=name;type=acme;cert=/tmp/acme;domains=example.com;challenge=dns-01"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid challenge dns-01 in map[cert:/tmp/acme challenge:dns-01 cs:name domains:example.com type:acme]"),
+		},
+		{
+			desc: "-proxy.addr with unknown proto 'foo'",
+			args: []string{"-proxy.addr", ":5555;proto=foo"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("unknown protocol \"foo\""),
+		},
+		{
+			desc: "-proxy.addr with proto 'https' requires cert source",
+			args: []string{"-proxy.addr", ":5555;proto=https"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("proto 'https' requires cert source"),
+		},
+		{
+			desc: "-proxy.addr with proto 'grpcs' requires cert source",
+			args: []string{"-proxy.addr", ":5555;proto=grpcs"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("proto 'grpcs' requires cert source"),
+		},
+		{
+			desc: "-proxy.addr with cert source and proto 'http' requires proto 'https', 'tcp', or 'grpcs'",
+			args: []string{"-proxy.addr", ":5555;cs=name;proto=http", "-proxy.cs", "cs=name;type=path;cert=value"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("cert source requires proto 'https', 'tcp', 'tcp-dynamic', 'https+tcp+sni', or 'grpcs'"),
+		},
+		{
+			desc: "-proxy.addr with cert source and proto 'tcp+sni' requires proto 'https', 'tcp' or 'grpcs'",
+			args: []string{"-proxy.addr", ":5555;cs=name;proto=tcp+sni", "-proxy.cs", "cs=name;type=path;cert=value"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("cert source requires proto 'https', 'tcp', 'tcp-dynamic', 'https+tcp+sni', or 'grpcs'"),
+		},
+		{
+			desc: "-proxy.noroutestatus too small",
+			args: []string{"-proxy.noroutestatus", "10"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("proxy.noroutestatus must be between 100 and 999"),
+		},
+		{
+			desc: "-proxy.upstream.http2 invalid",
+			args: []string{"-proxy.upstream.http2", "http3"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid proxy.upstream.http2: http3"),
+		},
+		{
+			desc: "-ui.pagesize zero",
+			args: []string{"-ui.pagesize", "0"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid ui.pagesize: 0"),
+		},
+		{
+			desc: "-ui.group.colors invalid color",
+			args: []string{"-ui.group.colors", "prod=<b>"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New(`invalid ui.group.colors: "<b>" is not a color for "prod"`),
+		},
+		{
+			desc: "-proxy.drain.grace negative",
+			args: []string{"-proxy.drain.grace", "-1s"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid proxy.drain.grace: -1s"),
+		},
+		{
+			desc: "-proxy.upgrade.timeout zero",
+			args: []string{"-proxy.upgrade.timeout", "0s"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid proxy.upgrade.timeout: 0s"),
+		},
+		{
+			desc: "-log.routes.max negative",
+			args: []string{"-log.routes.max", "-1"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid log.routes.max: -1"),
+		},
+		{
+			desc: "-log.target invalid",
+			args: []string{"-log.target", "file"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid log.target: file. Must be stderr, stdout or syslog"),
+		},
+		{
+			desc: "-log.format invalid",
+			args: []string{"-log.format", "logfmt"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid log.format: logfmt. Must be text or json"),
+		},
+		{
+			desc: "-log.syslog.addr without scheme",
+			args: []string{"-log.syslog.addr", "logs.example.com:514"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid log.syslog.addr: logs.example.com:514. Must start with udp://, tcp://, tls:// or unix://"),
+		},
+		{
+			desc: "-log.noroute.target invalid",
+			args: []string{"-log.noroute.target", "file"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid log.noroute.target: file. Must be stdout or stderr"),
+		},
+		{
+			desc: "-proxy.ws.idletimeout negative",
+			args: []string{"-proxy.ws.idletimeout", "-1s"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid proxy.ws.idletimeout: -1s"),
+		},
+		{
+			desc: "-snapshot.interval zero",
+			args: []string{"-snapshot.target", "/var/lib/fabio", "-snapshot.interval", "0s"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid snapshot.interval: 0s"),
+		},
+		{
+			desc: "-snapshot.keep negative",
+			args: []string{"-snapshot.keep", "-1"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid snapshot.keep: -1"),
+		},
+		{
+			desc: "-snapshot.target without bucket",
+			args: []string{"-snapshot.target", "s3:///fabio"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid snapshot.target: s3:///fabio. Must be a directory or s3://bucket/prefix"),
+		},
+		{
+			desc: "-ha.mode invalid",
+			args: []string{"-ha.mode", "etcd"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid ha.mode: etcd. Must be consul"),
+		},
+		{
+			desc: "-ha.lock.key empty",
+			args: []string{"-ha.mode", "consul", "-ha.lock.key", ""},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("missing ha.lock.key"),
+		},
+		{
+			desc: "-ha.lock.ttl too short",
+			args: []string{"-ha.lock.ttl", "5s"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid ha.lock.ttl: 5s. Must be between 10s and 24h"),
+		},
+		{
+			desc: "-ha.hook.timeout zero",
+			args: []string{"-ha.hook.timeout", "0s"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid ha.hook.timeout: 0s"),
+		},
+		{
+			desc: "-runtime.nofile.warn above one",
+			args: []string{"-runtime.nofile.warn", "1.5"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid runtime.nofile.warn: 1.5. Must be in [0,1]"),
+		},
+		{
+			desc: "-log.noroute.sample zero",
+			args: []string{"-log.noroute.sample", "0"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid log.noroute.sample: 0. Must be in (0,1]"),
+		},
+		{
+			desc: "-log.noroute.topk negative",
+			args: []string{"-log.noroute.topk", "-1"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid log.noroute.topk: -1"),
+		},
+		{
+			desc: "-log.access.target file without path",
+			args: []string{"-log.access.target", "file:"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid log.access.target: file:. Missing path"),
+		},
+		{
+			desc: "-log.access.file.maxsize negative",
+			args: []string{"-log.access.file.maxsize", "-1"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid log.access.file.maxsize: -1"),
+		},
+		{
+			desc: "-log.access.file.interval negative",
+			args: []string{"-log.access.file.interval", "-1h"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid log.access.file.interval: -1h0m0s"),
+		},
+		{
+			desc: "-log.access.file.maxbackups negative",
+			args: []string{"-log.access.file.maxbackups", "-1"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid log.access.file.maxbackups: -1"),
+		},
+		{
+			desc: "-proxy.noroute.json invalid",
+			args: []string{"-proxy.noroute.json", "{"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid proxy.noroute.json: {"),
+		},
+		{
+			desc: "-proxy.hash.key invalid",
+			args: []string{"-proxy.hash.key", "header:"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid proxy.hash.key: header:"),
+		},
+		{
+			desc: "-proxy.hash.vnodes zero",
+			args: []string{"-proxy.hash.vnodes", "0"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid proxy.hash.vnodes: 0"),
+		},
+		{
+			desc: "-proxy.timeout.max negative",
+			args: []string{"-proxy.timeout.max", "-1s"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid proxy.timeout.max: -1s"),
+		},
+		{
+			desc: "-proxy.killswitch.status invalid",
+			args: []string{"-proxy.killswitch.status", "0"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid proxy.killswitch.status: 0"),
+		},
+		{
+			desc: "-proxy.outlier.threshold one",
+			args: []string{"-proxy.outlier.threshold", "1"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid proxy.outlier.threshold: 1. Must be in [0,1)"),
+		},
+		{
+			desc: "-proxy.outlier.minrequests zero",
+			args: []string{"-proxy.outlier.minrequests", "0"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid proxy.outlier.minrequests: 0"),
+		},
+		{
+			desc: "-proxy.outlier.maxejected above one",
+			args: []string{"-proxy.outlier.maxejected", "1.5"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid proxy.outlier.maxejected: 1.5. Must be in [0,1]"),
+		},
+		{
+			desc: "-proxy.outlier.rampup negative",
+			args: []string{"-proxy.outlier.rampup", "-1s"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid proxy.outlier.rampup: -1s"),
+		},
+		{
+			desc: "-proxy.failover.threshold zero",
+			args: []string{"-proxy.failover.threshold", "0"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid proxy.failover.threshold: 0. Must be in (0,1]"),
+		},
+		{
+			desc: "-proxy.failover.recover below threshold",
+			args: []string{"-proxy.failover.threshold", "0.6", "-proxy.failover.recover", "0.4"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid proxy.failover.recover: 0.4. Must be in [proxy.failover.threshold,1]"),
+		},
+		{
+			desc: "-proxy.failover.hold negative",
+			args: []string{"-proxy.failover.hold", "-1s"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid proxy.failover.hold: -1s"),
+		},
+		{
+			desc: "-proxy.killswitch.ttl zero",
+			args: []string{"-proxy.killswitch.ttl", "0"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid proxy.killswitch.ttl: 0s"),
+		},
+		{
+			desc: "-proxy.debug.header without secret",
+			args: []string{"-proxy.debug.header", "X-Fabio-Debug"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid proxy.debug.header: X-Fabio-Debug. Missing proxy.debug.secret"),
+		},
+		{
+			desc: "-proxy.trace.header without secret",
+			args: []string{"-proxy.trace.header", "X-Fabio-Trace"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid proxy.trace.header: X-Fabio-Trace. Missing proxy.trace.secret"),
+		},
+		{
+			desc: "-proxy.trace.maxttl zero",
+			args: []string{"-proxy.trace.maxttl", "0"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid proxy.trace.maxttl: 0s"),
+		},
+		{
+			desc: "-proxy.debug.maxttl zero",
+			args: []string{"-proxy.debug.maxttl", "0"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid proxy.debug.maxttl: 0s"),
+		},
+		{
+			desc: "-metrics.gc.grace negative",
+			args: []string{"-metrics.gc.grace", "-1s"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid metrics.gc.grace: -1s"),
+		},
+		{
+			desc: "-metrics.tls.sni.topk negative",
+			args: []string{"-metrics.tls.sni.topk", "-1"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid metrics.tls.sni.topk: -1"),
+		},
+		{
+			desc: "-proxy.noroutestatus too big",
+			args: []string{"-proxy.noroutestatus", "1000"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("proxy.noroutestatus must be between 100 and 999"),
+		},
+		{
+			desc: "-proxy.auth with unknown auth type 'foo'",
+			args: []string{"-proxy.auth", "name=myauth;type=foo"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("unknown auth type 'foo'"),
+		},
+		{
+			desc: "-proxy.auth with missing name",
+			args: []string{"-proxy.auth", "type=basic;file=/some/file;realm=realm"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("missing 'name' in auth"),
+		},
+		{
+			desc: "-proxy.auth basic with missing file",
+			args: []string{"-proxy.auth", "name=foo;type=basic;realm=realm"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("missing 'file' in auth 'foo'"),
+		},
+		{
+			desc: "-proxy.auth basic with file and ldap",
+			args: []string{"-proxy.auth", "name=foo;type=basic;file=/some/file;ldap=ldap://ldap;basedn=dc=org"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("'file' and 'ldap' are mutually exclusive in auth 'foo'"),
+		},
+		{
+			desc: "-proxy.auth basic with invalid ldap url",
+			args: []string{"-proxy.auth", "name=foo;type=basic;ldap=http://ldap;basedn=dc=org"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid 'ldap' in auth 'foo'. Must be ldap://host[:port] or ldaps://host[:port]"),
+		},
+		{
+			desc: "-proxy.auth basic with ldap and missing basedn",
+			args: []string{"-proxy.auth", "name=foo;type=basic;ldap=ldap://ldap"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("missing 'basedn' in auth 'foo'"),
+		},
+		{
+			desc: "-proxy.auth basic with ldap filter without user",
+			args: []string{"-proxy.auth", "name=foo;type=basic;ldap=ldap://ldap;basedn=dc=org;filter=(uid=*)"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid 'filter' in auth 'foo'. Must contain {user}"),
+		},
+		{
+			desc: "-proxy.auth jwt with missing jwks",
+			args: []string{"-proxy.auth", "name=foo;type=jwt;issuer=https://idp/"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("missing 'jwks' in auth 'foo'"),
+		},
+		{
+			desc: "-proxy.auth jwt with invalid claims",
+			args: []string{"-proxy.auth", "name=foo;type=jwt;jwks=https://idp/keys;claims=sub"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid 'claims' in auth 'foo'. Must be 'claim:header ...'"),
+		},
+		{
+			desc: "-proxy.auth extauthz with missing url",
+			args: []string{"-proxy.auth", "name=foo;type=extauthz"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("missing 'url' in auth 'foo'"),
+		},
+		{
+			desc: "-proxy.auth extauthz with invalid url scheme",
+			args: []string{"-proxy.auth", "name=foo;type=extauthz;url=tcp://opa:9191"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid 'url' in auth 'foo'. Scheme must be http, https, grpc or grpcs"),
+		},
+		{
+			desc: "-proxy.auth extauthz with invalid timeout",
+			args: []string{"-proxy.auth", "name=foo;type=extauthz;url=http://opa/;timeout=0s"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid 'timeout' in auth 'foo'"),
 		},
 		{
 			args: []string{"-glob.cache.size", "1000"},
@@ -0,0 +1,84 @@
+package config
+
+import (
+	"net/http"
+	"net/url"
+)
+
+// redacted replaces the value of a credential.
+const redacted = "<redacted>"
+
+// Redacted returns a copy of the configuration without credentials
+// which can be exposed via the API and in snapshots. The credentials
+// which are omitted by the JSON encoding are removed as well so that
+// the copy is safe with other encodings.
+func (c *Config) Redacted() *Config {
+	r := *c
+
+	r.Proxy.StickySecret = ""
+	r.Proxy.DebugSecret = ""
+	r.Proxy.TraceSecret = ""
+	if c.Proxy.AuthSchemes != nil {
+		r.Proxy.AuthSchemes = map[string]AuthScheme{}
+		for name, a := range c.Proxy.AuthSchemes {
+			a.Basic.LDAP.BindPassword = ""
+			a.JWT.JWKS = redactURL(a.JWT.JWKS)
+			a.ExtAuthz.URL = redactURL(a.ExtAuthz.URL)
+			r.Proxy.AuthSchemes[name] = a
+		}
+	}
+
+	r.Listen = make([]Listen, len(c.Listen))
+	for i, l := range c.Listen {
+		r.Listen[i] = l.redacted()
+	}
+	r.UI.Listen = c.UI.Listen.redacted()
+
+	r.Registry.Consul.Token = redact(c.Registry.Consul.Token)
+	r.Registry.Consul.TokenServices = redact(c.Registry.Consul.TokenServices)
+	r.Registry.Consul.TokenKV = redact(c.Registry.Consul.TokenKV)
+	r.Registry.Consul.TokenRegister = redact(c.Registry.Consul.TokenRegister)
+	r.Registry.Nomad.Token = ""
+	r.Registry.Etcd.Password = ""
+
+	r.Metrics.Circonus.APIKey = redact(c.Metrics.Circonus.APIKey)
+	r.Tracing.OTLP.Headers = nil
+
+	return &r
+}
+
+func (l Listen) redacted() Listen {
+	cs := &l.CertSource
+	cs.CertPath = redactURL(cs.CertPath)
+	cs.KeyPath = redactURL(cs.KeyPath)
+	cs.ClientCAPath = redactURL(cs.ClientCAPath)
+	cs.KeyPassphrase = redact(cs.KeyPassphrase)
+	if cs.Header != nil {
+		h := http.Header{}
+		for k := range cs.Header {
+			h.Set(k, redacted)
+		}
+		cs.Header = h
+	}
+	return l
+}
+
+// redact returns the placeholder for a non-empty credential.
+func redact(s string) string {
+	if s == "" {
+		return ""
+	}
+	return redacted
+}
+
+// redactURL removes the password from a URL with user info.
+func redactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.User == nil {
+		return s
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), redacted)
+	}
+	return u.String()
+}
@@ -16,24 +16,19 @@ var quit = make(chan bool)
 
 // Listen registers an exit handler which is called on
 // SIGINT/SIGTERM or when Exit/Fatal/Fatalf is called.
-// SIGHUP is ignored since that is usually used for
-// triggering a reload of configuration which isn't
-// supported but shouldn't kill the process either.
+// SIGHUP is handled by the configuration reload.
 func Listen(fn func(os.Signal)) {
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
 		for {
 			sigchan := make(chan os.Signal, 1)
-			signal.Notify(sigchan, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+			signal.Notify(sigchan, os.Interrupt, syscall.SIGTERM)
 
 			var sig os.Signal
 			select {
 			case sig = <-sigchan:
 				switch sig {
-				case syscall.SIGHUP:
-					log.Print("[INFO] Caught SIGHUP. Ignoring")
-					continue
 				case os.Interrupt:
 					log.Print("[INFO] Caught SIGINT. Exiting")
 				case syscall.SIGTERM:
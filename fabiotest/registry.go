@@ -0,0 +1,198 @@
+package fabiotest
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/fabiolb/fabio/registry"
+	"github.com/fabiolb/fabio/route"
+)
+
+// ManualPath is the path of the manual overrides of the in-memory registry.
+const ManualPath = "/fabio/config"
+
+// Registry is an in-memory registry backend. Services register their
+// routes with the commands of the fabio config language, e.g.
+//
+//	route add my-svc example.com/ http://127.0.0.1:8080/
+//
+// The routes of all services followed by the manual overrides make up
+// the routing table. Registry implements registry.Backend and is safe
+// for concurrent use.
+type Registry struct {
+	mu         sync.Mutex
+	services   map[string]string
+	manual     map[string]string
+	versions   map[string]uint64
+	registered []string
+
+	svc  *registry.Watch
+	man  *registry.Watch
+	html *registry.Watch
+}
+
+// NewRegistry creates an empty in-memory registry.
+func NewRegistry() *Registry {
+	r := &Registry{
+		services: map[string]string{},
+		manual:   map[string]string{},
+		versions: map[string]uint64{},
+		svc:      registry.NewWatch(),
+		man:      registry.NewWatch(),
+		html:     registry.NewWatch(),
+	}
+	r.svc.Publish("")
+	r.man.Publish("")
+	return r
+}
+
+// SetService replaces the routes of the service with the given route
+// commands. It returns an error if a command cannot be parsed. Without
+// routes the service is removed.
+func (r *Registry) SetService(name string, routes ...string) error {
+	value := strings.Join(routes, "\n")
+	if _, err := route.Parse(bytes.NewBufferString(value)); err != nil {
+		return fmt.Errorf("fabiotest: invalid routes for %s. %s", name, err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(routes) == 0 {
+		delete(r.services, name)
+	} else {
+		r.services[name] = value
+	}
+	r.svc.Publish(r.serviceRoutes())
+	return nil
+}
+
+// RemoveService removes all routes of the service.
+func (r *Registry) RemoveService(name string) {
+	r.SetService(name)
+}
+
+// SetManual replaces the manual overrides which are appended to the
+// routes of the services. It returns an error if a command cannot be
+// parsed.
+func (r *Registry) SetManual(value string) error {
+	if _, err := route.Parse(bytes.NewBufferString(value)); err != nil {
+		return fmt.Errorf("fabiotest: invalid manual overrides. %s", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.manual[ManualPath] = value
+	r.versions[ManualPath]++
+	r.man.Publish(r.manualRoutes())
+	return nil
+}
+
+// Registered returns the service names fabio registered itself with.
+func (r *Registry) Registered() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.registered...)
+}
+
+// serviceRoutes returns the routes of all services sorted
+// by the name of the service. The caller must hold the lock.
+func (r *Registry) serviceRoutes() string {
+	var names []string
+	for name := range r.services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var routes []string
+	for _, name := range names {
+		routes = append(routes, r.services[name])
+	}
+	return strings.Join(routes, "\n")
+}
+
+// manualRoutes returns the manual overrides sorted
+// by their path. The caller must hold the lock.
+func (r *Registry) manualRoutes() string {
+	var paths []string
+	for path := range r.manual {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var routes []string
+	for _, path := range paths {
+		routes = append(routes, r.manual[path])
+	}
+	return strings.Join(routes, "\n")
+}
+
+func (r *Registry) Register(services []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.registered = append([]string(nil), services...)
+	return nil
+}
+
+func (r *Registry) Deregister(service string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var registered []string
+	for _, s := range r.registered {
+		if s != service {
+			registered = append(registered, s)
+		}
+	}
+	r.registered = registered
+	return nil
+}
+
+func (r *Registry) DeregisterAll() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.registered = nil
+	return nil
+}
+
+func (r *Registry) ManualPaths() ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var paths []string
+	for path := range r.manual {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+func (r *Registry) ReadManual(path string) (value string, version uint64, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.manual[path], r.versions[path], nil
+}
+
+func (r *Registry) WriteManual(path string, value string, version uint64) (ok bool, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.versions[path] != version {
+		return false, nil
+	}
+	r.manual[path] = value
+	r.versions[path]++
+	r.man.Publish(r.manualRoutes())
+	return true, nil
+}
+
+func (r *Registry) WatchServices() *registry.Watch {
+	return r.svc
+}
+
+func (r *Registry) WatchManual() *registry.Watch {
+	return r.man
+}
+
+func (r *Registry) WatchNoRouteHTML() *registry.Watch {
+	return r.html
+}
@@ -0,0 +1,146 @@
+// Package fabiotest provides an in-process fabio for integration tests
+// of routing rules.
+//
+// A Server routes requests with the routing table built from the routes
+// in its in-memory registry. Routes can be changed at any time and are
+// used for the next request.
+//
+//	srv, err := fabiotest.NewServer("-proxy.strategy=rr")
+//	if err != nil {
+//	    t.Fatal(err)
+//	}
+//	defer srv.Close()
+//
+//	srv.Registry.SetService("my-svc", "route add my-svc example.com/ "+upstream.URL)
+//	resp, err := srv.Client().Get("http://example.com/foo")
+package fabiotest
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+
+	"github.com/fabiolb/fabio/config"
+	"github.com/fabiolb/fabio/proxy"
+	"github.com/fabiolb/fabio/route"
+)
+
+// Server is an in-process fabio HTTP proxy.
+type Server struct {
+	// URL is the base URL of the proxy, e.g. http://127.0.0.1:1234.
+	URL string
+
+	// Registry contains the routes of the proxy.
+	Registry *Registry
+
+	// Config is the configuration of the proxy.
+	Config *config.Config
+
+	srv *httptest.Server
+
+	mu    sync.Mutex
+	seq   [2]uint64
+	table route.Table
+}
+
+// NewServer starts a proxy with an empty routing table. The arguments
+// are the command line flags of fabio, e.g. "-proxy.strategy=rr". Only
+// the proxy options apply. The caller must call Close when finished.
+func NewServer(args ...string) (*Server, error) {
+	cfg, err := config.Load(append([]string{"fabiotest"}, args...), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		Registry: NewRegistry(),
+		Config:   cfg,
+		table:    route.Table{},
+	}
+
+	pick := route.Picker[cfg.Proxy.Strategy]
+	match := route.Matcher[cfg.Proxy.Matcher]
+	globCache := route.NewGlobCache(cfg.GlobCacheSize)
+
+	newTransport := func(tlscfg *tls.Config) *http.Transport {
+		return &http.Transport{
+			ResponseHeaderTimeout: cfg.Proxy.ResponseHeaderTimeout,
+			IdleConnTimeout:       cfg.Proxy.IdleConnTimeout,
+			MaxIdleConnsPerHost:   cfg.Proxy.MaxConn,
+			DialContext: (&net.Dialer{
+				Timeout:   cfg.Proxy.DialTimeout,
+				KeepAlive: cfg.Proxy.KeepAliveTimeout,
+			}).DialContext,
+			TLSClientConfig: tlscfg,
+		}
+	}
+
+	var rateLimit *route.RateLimiter
+	if cfg.Proxy.RateLimit.Rate > 0 {
+		rateLimit = route.NewRateLimiter(cfg.Proxy.RateLimit.Rate, cfg.Proxy.RateLimit.Burst)
+	}
+
+	s.srv = httptest.NewServer(&proxy.HTTPProxy{
+		Config:            cfg.Proxy,
+		Transport:         newTransport(nil),
+		InsecureTransport: newTransport(&tls.Config{InsecureSkipVerify: true}),
+		RateLimit:         rateLimit,
+		Lookup: func(r *http.Request) *route.Target {
+			return s.Table().Lookup(r, route.TraceID(r), pick, match, globCache, cfg.GlobMatchingDisabled)
+		},
+	})
+	s.URL = s.srv.URL
+	return s, nil
+}
+
+// Table returns the current routing table of the proxy.
+func (s *Server) Table() route.Table {
+	svc := s.Registry.WatchServices().Latest()
+	man := s.Registry.WatchManual().Latest()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.seq == [2]uint64{svc.Seq, man.Seq} {
+		return s.table
+	}
+
+	// manual config overrides service config - order matters
+	s.seq = [2]uint64{svc.Seq, man.Seq}
+	t, err := route.NewTable(bytes.NewBufferString(svc.Value + "\n" + man.Value))
+	if err != nil {
+		log.Printf("[WARN] fabiotest: Keeping the last routing table. %s", err)
+		return s.table
+	}
+	s.table = t
+	return t
+}
+
+// Client returns an HTTP client which sends all requests to the proxy
+// independent of the host in the request URL. The client does not
+// follow redirects so that they can be tested. Requests must use the
+// http scheme.
+func (s *Server) Client() *http.Client {
+	addr := s.srv.Listener.Addr().String()
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, addr)
+			},
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// Close shuts down the proxy and blocks until all
+// outstanding requests have completed.
+func (s *Server) Close() {
+	s.srv.Close()
+}
@@ -0,0 +1,98 @@
+package fabiotest
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestServer(t *testing.T) {
+	newUpstream := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(name + " " + r.Host + r.URL.Path))
+		}))
+	}
+	a, b := newUpstream("a"), newUpstream("b")
+	defer a.Close()
+	defer b.Close()
+
+	srv, err := NewServer("-proxy.strategy=rr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	get := func(url string) (int, string) {
+		t.Helper()
+		resp, err := srv.Client().Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, string(body)
+	}
+
+	check := func(url string, wantStatus int, wantBody string) {
+		t.Helper()
+		status, body := get(url)
+		if status != wantStatus {
+			t.Fatalf("%s: got status %d want %d", url, status, wantStatus)
+		}
+		if wantBody != "" && body != wantBody {
+			t.Fatalf("%s: got body %q want %q", url, body, wantBody)
+		}
+	}
+
+	// empty routing table
+	check("http://example.com/foo", http.StatusNotFound, "")
+
+	if err := srv.Registry.SetService("a", "route add a example.com/ "+a.URL); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Registry.SetService("b", "route add b example.com/b "+b.URL, "route add b /b "+b.URL); err != nil {
+		t.Fatal(err)
+	}
+	check("http://example.com/foo", http.StatusOK, "a example.com/foo")
+	check("http://example.com/b/x", http.StatusOK, "b example.com/b/x")
+	check("http://other.com/b", http.StatusOK, "b other.com/b")
+	check("http://other.com/a", http.StatusNotFound, "")
+
+	// manual overrides are applied after the services
+	if err := srv.Registry.SetManual("route del b example.com/b"); err != nil {
+		t.Fatal(err)
+	}
+	check("http://example.com/b/x", http.StatusOK, "a example.com/b/x")
+
+	srv.Registry.RemoveService("a")
+	check("http://example.com/foo", http.StatusNotFound, "")
+
+	if err := srv.Registry.SetService("c", "route add c"); err == nil {
+		t.Fatal("got nil want error for invalid route")
+	}
+}
+
+func TestRegistryManual(t *testing.T) {
+	r := NewRegistry()
+	value, version, err := r.ReadManual(ManualPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != "" || version != 0 {
+		t.Fatalf("got %q, %d want empty value", value, version)
+	}
+
+	if ok, err := r.WriteManual(ManualPath, "route del a", 0); !ok || err != nil {
+		t.Fatalf("got %v, %v want true, nil", ok, err)
+	}
+	if ok, _ := r.WriteManual(ManualPath, "route del b", 0); ok {
+		t.Fatal("got true want false for stale version")
+	}
+	if got, want := r.WatchManual().Latest().Value, "route del a"; got != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+}
@@ -0,0 +1,212 @@
+// Package ha implements an active-standby mode in which fabio instances
+// compete for a distributed lock. The instance which holds the lock is
+// active and the others are on standby. Hooks which assign a virtual IP
+// or update DNS records are run when the state of an instance changes.
+package ha
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fabiolb/fabio/metrics"
+)
+
+// The states of an instance.
+const (
+	Active  = "active"
+	Standby = "standby"
+)
+
+// Lock is a distributed lock. The lock of the consul api
+// implements the interface.
+type Lock interface {
+	// Lock blocks until the lock is acquired or stop is closed. The
+	// returned channel is closed when the lock is lost.
+	Lock(stop <-chan struct{}) (<-chan struct{}, error)
+
+	// Unlock releases the lock.
+	Unlock() error
+}
+
+// Config configures the active-standby mode.
+type Config struct {
+	// Promote and Demote are the commands which are run when the
+	// instance becomes active or goes back to standby. The commands
+	// are split on white space and not run in a shell.
+	Promote, Demote string
+
+	// HookTimeout is the maximum duration of a hook.
+	HookTimeout time.Duration
+
+	// Retry is the time to wait after an error before the lock
+	// is acquired again.
+	Retry time.Duration
+}
+
+// Status is the active-standby state of the instance.
+type Status struct {
+	Enabled bool      `json:"enabled"`
+	State   string    `json:"state,omitempty"`
+	Since   time.Time `json:"since,omitempty"`
+}
+
+var (
+	mu     sync.Mutex
+	status Status
+
+	// release stops Run and releases the lock.
+	release chan struct{}
+	stopped chan struct{}
+)
+
+// GetStatus returns the active-standby state of the instance.
+func GetStatus() Status {
+	mu.Lock()
+	defer mu.Unlock()
+	return status
+}
+
+func setState(state string) {
+	mu.Lock()
+	status = Status{Enabled: true, State: state, Since: time.Now().UTC()}
+	mu.Unlock()
+
+	var v int64
+	if state == Active {
+		v = 1
+	}
+	metrics.DefaultRegistry.GetGauge("ha.active").Update(v)
+}
+
+// Run competes for the lock until Release is called. The instance is
+// active while it holds the lock. If the promote hook fails the lock
+// is released so that another instance can take over.
+func Run(l Lock, cfg Config) {
+	mu.Lock()
+	release, stopped = make(chan struct{}), make(chan struct{})
+	quit, done := release, stopped
+	mu.Unlock()
+	defer close(done)
+
+	setState(Standby)
+	log.Print("[INFO] ha: Standing by")
+	for {
+		lost, err := l.Lock(quit)
+		if err != nil {
+			log.Printf("[ERROR] ha: Cannot acquire the lock. %s", err)
+			if !wait(quit, cfg.Retry) {
+				return
+			}
+			continue
+		}
+		if lost == nil {
+			// Release was called
+			return
+		}
+
+		log.Print("[INFO] ha: Acquired the lock. Becoming active")
+		setState(Active)
+		metrics.DefaultRegistry.GetCounter("ha.takeover").Inc(1)
+		if err := runHook(cfg.Promote, Active, cfg.HookTimeout); err != nil {
+			log.Printf("[ERROR] ha: Promote hook failed. Releasing the lock. %s", err)
+			demote(l, cfg)
+			if !wait(quit, cfg.Retry) {
+				return
+			}
+			continue
+		}
+
+		select {
+		case <-lost:
+			log.Print("[WARN] ha: Lost the lock. Going back to standby")
+			demote(l, cfg)
+		case <-quit:
+			log.Print("[INFO] ha: Releasing the lock")
+			demote(l, cfg)
+			return
+		}
+	}
+}
+
+// demote runs the demote hook and releases the lock. The hook runs
+// first so that the other instance takes over after the virtual IP
+// has been released.
+func demote(l Lock, cfg Config) {
+	setState(Standby)
+	if err := runHook(cfg.Demote, Standby, cfg.HookTimeout); err != nil {
+		log.Printf("[ERROR] ha: Demote hook failed. %s", err)
+	}
+	if err := l.Unlock(); err != nil {
+		log.Printf("[WARN] ha: Cannot release the lock. %s", err)
+	}
+}
+
+// Release stops Run and releases the lock if the instance is active.
+// It waits until the demote hook has completed or the timeout expires.
+func Release(timeout time.Duration) {
+	mu.Lock()
+	quit, done := release, stopped
+	release = nil
+	mu.Unlock()
+	if quit == nil {
+		return
+	}
+	close(quit)
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		log.Print("[WARN] ha: Timeout while releasing the lock")
+	}
+}
+
+// wait waits for d and returns false if quit was closed.
+func wait(quit <-chan struct{}, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-quit:
+		return false
+	}
+}
+
+// runHook runs the hook command with the new state in the
+// FABIO_HA_STATE environment variable.
+func runHook(hook, state string, timeout time.Duration) error {
+	args := strings.Fields(hook)
+	if len(args) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	start := time.Now()
+	err := execCommand(ctx, args, append(os.Environ(), "FABIO_HA_STATE="+state))
+	if ctx.Err() == context.DeadlineExceeded {
+		err = errors.New("timeout after " + timeout.String())
+	}
+	if err != nil {
+		metrics.DefaultRegistry.GetCounter("ha.hook.failed").Inc(1)
+		return err
+	}
+	log.Printf("[INFO] ha: Ran %s hook in %s", state, time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// execCommand runs the command and logs its output. It is
+// replaced in tests.
+var execCommand = func(ctx context.Context, args []string, env []string) error {
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Env = env
+	out, err := cmd.CombinedOutput()
+	if s := strings.TrimSpace(string(out)); s != "" {
+		log.Printf("[INFO] ha: %s: %s", args[0], s)
+	}
+	return err
+}
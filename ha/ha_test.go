@@ -0,0 +1,185 @@
+package ha
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeLock is granted when a value is sent on acquire
+// and lost when the returned channel is closed.
+type fakeLock struct {
+	acquire chan chan struct{}
+
+	mu       sync.Mutex
+	unlocked int
+}
+
+func (l *fakeLock) Lock(stop <-chan struct{}) (<-chan struct{}, error) {
+	select {
+	case lost := <-l.acquire:
+		return lost, nil
+	case <-stop:
+		return nil, nil
+	}
+}
+
+func (l *fakeLock) Unlock() error {
+	l.mu.Lock()
+	l.unlocked++
+	l.mu.Unlock()
+	return nil
+}
+
+func (l *fakeLock) Unlocked() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.unlocked
+}
+
+// recordHooks replaces execCommand with a function which records the
+// hooks and the value of FABIO_HA_STATE and fails the hooks in fail.
+func recordHooks(fail string) func() []string {
+	var mu sync.Mutex
+	var calls []string
+	execCommand = func(ctx context.Context, args []string, env []string) error {
+		var state string
+		for _, e := range env {
+			if strings.HasPrefix(e, "FABIO_HA_STATE=") {
+				state = strings.TrimPrefix(e, "FABIO_HA_STATE=")
+			}
+		}
+		mu.Lock()
+		calls = append(calls, strings.Join(args, " ")+" "+state)
+		mu.Unlock()
+		if args[0] == fail {
+			return errors.New("exit status 1")
+		}
+		return nil
+	}
+	return func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), calls...)
+	}
+}
+
+func waitFor(t *testing.T, desc string, f func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !f() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", desc)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestRun(t *testing.T) {
+	defer func(f func(context.Context, []string, []string) error) { execCommand = f }(execCommand)
+	calls := recordHooks("")
+
+	l := &fakeLock{acquire: make(chan chan struct{})}
+	cfg := Config{Promote: "vip up", Demote: "vip down", HookTimeout: time.Second, Retry: time.Millisecond}
+	done := make(chan struct{})
+	go func() { Run(l, cfg); close(done) }()
+
+	waitFor(t, "standby", func() bool { return GetStatus().State == Standby })
+
+	// take over
+	lost := make(chan struct{})
+	l.acquire <- lost
+	waitFor(t, "active", func() bool { return GetStatus().State == Active && len(calls()) == 1 })
+
+	// lose the lock and take over again
+	close(lost)
+	waitFor(t, "unlock", func() bool { return l.Unlocked() == 1 })
+	if got, want := GetStatus().State, Standby; got != want {
+		t.Fatalf("got state %q want %q", got, want)
+	}
+	l.acquire <- make(chan struct{})
+	waitFor(t, "active", func() bool { return GetStatus().State == Active && len(calls()) == 3 })
+
+	// release on shutdown
+	Release(time.Second)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return")
+	}
+
+	want := []string{"vip up active", "vip down standby", "vip up active", "vip down standby"}
+	if got := calls(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("got hooks %q want %q", got, want)
+	}
+	if got, want := l.Unlocked(), 2; got != want {
+		t.Fatalf("got %d unlocks want %d", got, want)
+	}
+	if got, want := GetStatus().State, Standby; got != want {
+		t.Fatalf("got state %q want %q", got, want)
+	}
+}
+
+func TestRunPromoteFails(t *testing.T) {
+	defer func(f func(context.Context, []string, []string) error) { execCommand = f }(execCommand)
+	calls := recordHooks("up")
+
+	l := &fakeLock{acquire: make(chan chan struct{})}
+	cfg := Config{Promote: "up", Demote: "down", HookTimeout: time.Second, Retry: time.Millisecond}
+	done := make(chan struct{})
+	go func() { Run(l, cfg); close(done) }()
+
+	// a failed promote hook releases the lock for the other instance
+	l.acquire <- make(chan struct{})
+	waitFor(t, "unlock", func() bool { return l.Unlocked() == 1 })
+	if got, want := strings.Join(calls(), ","), "up active,down standby"; got != want {
+		t.Fatalf("got hooks %q want %q", got, want)
+	}
+	if got, want := GetStatus().State, Standby; got != want {
+		t.Fatalf("got state %q want %q", got, want)
+	}
+
+	// releasing a standby instance runs no hooks
+	Release(time.Second)
+	<-done
+	if got, want := len(calls()), 2; got != want {
+		t.Fatalf("got %d hooks want %d", got, want)
+	}
+}
+
+func TestRunHook(t *testing.T) {
+	if err := runHook("", Active, time.Second); err != nil {
+		t.Fatalf("got %v want nil for empty hook", err)
+	}
+	if _, err := os.Stat("/bin/sh"); err != nil {
+		t.Skip("no /bin/sh")
+	}
+	dir, err := ioutil.TempDir("", "fabio-ha")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	script := func(name, body string) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte("#!/bin/sh\n"+body+"\n"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	if err := runHook(script("state", `test "$FABIO_HA_STATE" = "$1"`)+" active", Active, time.Second); err != nil {
+		t.Fatalf("got %v want nil", err)
+	}
+	if err := runHook(script("fail", "exit 1"), Active, time.Second); err == nil {
+		t.Fatal("got nil want error")
+	}
+	if err, want := runHook(script("sleep", "exec sleep 5"), Active, 50*time.Millisecond), "timeout after 50ms"; err == nil || err.Error() != want {
+		t.Fatalf("got %v want %s", err, want)
+	}
+}
@@ -0,0 +1,119 @@
+package health
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+
+	"github.com/fabiolb/fabio/internal/rawpb"
+	"github.com/fabiolb/fabio/route"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// grpcHealthMethod is the method of the standard gRPC health checking
+// protocol. The messages are encoded with the rawpb package since the
+// generated code of the grpc.health.v1 package is not part of the
+// dependencies.
+const grpcHealthMethod = "/grpc.health.v1.Health/Check"
+
+// The values of the status field of the HealthCheckResponse.
+const (
+	grpcUnknown        = 0
+	grpcServing        = 1
+	grpcNotServing     = 2
+	grpcServiceUnknown = 3
+)
+
+var grpcStatusNames = map[uint64]string{
+	grpcUnknown:        "UNKNOWN",
+	grpcServing:        "SERVING",
+	grpcNotServing:     "NOT_SERVING",
+	grpcServiceUnknown: "SERVICE_UNKNOWN",
+}
+
+// grpcProber checks the health of a grpc or grpcs target
+// with the grpc.health.v1 protocol.
+type grpcProber struct {
+	conn    *grpc.ClientConn
+	service string
+}
+
+func newGRPCProber(t *route.Target) (prober, error) {
+	opts := []grpc.DialOption{
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(rawpb.Codec{})),
+	}
+	if t.URL.Scheme == "grpcs" {
+		opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(grpcTLSConfig(t))))
+	} else {
+		opts = append(opts, grpc.WithInsecure())
+	}
+	conn, err := grpc.Dial(t.URL.Host, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &grpcProber{conn: conn, service: t.HealthCheck.Service}, nil
+}
+
+// grpcTLSConfig returns the TLS configuration for the
+// connection to a grpcs target.
+func grpcTLSConfig(t *route.Target) *tls.Config {
+	cfg := &tls.Config{InsecureSkipVerify: t.TLSSkipVerify}
+	if g := t.GRPCTLS; g != nil {
+		cfg.ServerName = g.ServerName
+		cfg.RootCAs = g.RootCAs
+	}
+	return cfg
+}
+
+func (p *grpcProber) Check(ctx context.Context) error {
+	in, out := grpcHealthRequest(p.service), []byte(nil)
+	if err := p.conn.Invoke(ctx, grpcHealthMethod, &in, &out); err != nil {
+		return err
+	}
+	status, err := grpcHealthStatus(out)
+	if err != nil {
+		return err
+	}
+	if status != grpcServing {
+		name := grpcStatusNames[status]
+		if name == "" {
+			name = fmt.Sprint(status)
+		}
+		return fmt.Errorf("status %s", name)
+	}
+	return nil
+}
+
+func (p *grpcProber) Close() error {
+	return p.conn.Close()
+}
+
+// grpcHealthRequest returns the encoded HealthCheckRequest
+// message with the name of the service in field 1.
+func grpcHealthRequest(service string) []byte {
+	if service == "" {
+		return []byte{}
+	}
+	return rawpb.AppendString(nil, 1, service)
+}
+
+// grpcHealthStatus returns the status field of an encoded
+// HealthCheckResponse message. Unknown fields are skipped.
+func grpcHealthStatus(b []byte) (uint64, error) {
+	var status uint64
+	err := rawpb.Walk(b, func(num protowire.Number, v uint64, _ []byte) error {
+		if num == 1 {
+			status = v
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, errInvalidResponse
+	}
+	return status, nil
+}
+
+var errInvalidResponse = errors.New("invalid health check response")
@@ -0,0 +1,213 @@
+// Package health runs the active health checks of the targets in the
+// routing table. Targets which fail their check are removed from the
+// selection of their routes until they pass the check again.
+package health
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fabiolb/fabio/metrics"
+	"github.com/fabiolb/fabio/route"
+)
+
+// prober checks the health of a target.
+type prober interface {
+	// Check returns an error if the target is not healthy.
+	Check(ctx context.Context) error
+
+	// Close releases the resources of the prober.
+	Close() error
+}
+
+// probers contains the constructors of the probers
+// by the type of the health check.
+var probers = map[string]func(t *route.Target) (prober, error){
+	"grpc": newGRPCProber,
+	"http": newHTTPProber,
+	"tcp":  newTCPProber,
+}
+
+// Run starts the health checks of the targets in the routing table and
+// stops the checks of the removed targets. The routing table is
+// inspected every interval. Run does not return.
+func Run(interval time.Duration) {
+	running := map[string]*check{}
+	for {
+		syncChecks(running, route.GetTable())
+		time.Sleep(interval)
+	}
+}
+
+// syncChecks starts the checks of the targets in the table which are
+// not running and stops the running checks which are not needed
+// anymore.
+func syncChecks(running map[string]*check, t route.Table) {
+	targets := map[string][]*route.Target{}
+	for _, routes := range t {
+		for _, r := range routes {
+			for _, tg := range r.Targets {
+				if k := tg.HealthKey(); k != "" {
+					targets[k] = append(targets[k], tg)
+				}
+			}
+		}
+	}
+
+	for k, c := range running {
+		if targets[k] == nil {
+			c.stop()
+			delete(running, k)
+		}
+	}
+	for k, tgs := range targets {
+		if c := running[k]; c != nil {
+			c.setNames(tgs)
+			continue
+		}
+		c, err := newCheck(k, tgs)
+		if err != nil {
+			log.Printf("[ERROR] health: Cannot check %s. %s", k, err)
+			continue
+		}
+		running[k] = c
+	}
+}
+
+// check runs the health check of one service instance.
+type check struct {
+	key   string
+	hc    *route.HealthCheck
+	probe prober
+	state state
+
+	// names contains the metrics names of the targets
+	// which share the check.
+	mu    sync.Mutex
+	names []string
+
+	quit chan struct{}
+	done chan struct{}
+}
+
+func newCheck(key string, targets []*route.Target) (*check, error) {
+	t := targets[0]
+	newProber := probers[t.HealthCheck.Type]
+	if newProber == nil {
+		return nil, fmt.Errorf("unsupported health check %q", t.HealthCheck.Type)
+	}
+	p, err := newProber(t)
+	if err != nil {
+		return nil, err
+	}
+	c := &check{
+		key:   key,
+		hc:    t.HealthCheck,
+		probe: p,
+		state: state{healthy: true},
+		quit:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	c.setNames(targets)
+	log.Printf("[INFO] health: Checking %s every %s", key, c.hc.Interval)
+	go c.run()
+	return c, nil
+}
+
+func (c *check) setNames(targets []*route.Target) {
+	seen := map[string]bool{}
+	var names []string
+	for _, t := range targets {
+		if t.TimerName != "" && !seen[t.TimerName] {
+			seen[t.TimerName] = true
+			names = append(names, t.TimerName)
+		}
+	}
+	sort.Strings(names)
+	c.mu.Lock()
+	c.names = names
+	c.mu.Unlock()
+}
+
+// stop ends the check and clears the state of the target
+// so that the table no longer excludes it.
+func (c *check) stop() {
+	close(c.quit)
+	<-c.done
+	c.probe.Close()
+	route.SetHealthy(c.key, true)
+	log.Printf("[INFO] health: Stopped checking %s", c.key)
+}
+
+func (c *check) run() {
+	defer close(c.done)
+	ticker := time.NewTicker(c.hc.Interval)
+	defer ticker.Stop()
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), c.hc.Timeout)
+		err := c.probe.Check(ctx)
+		cancel()
+		c.record(err)
+
+		select {
+		case <-ticker.C:
+		case <-c.quit:
+			return
+		}
+	}
+}
+
+// record updates the state of the target with the result
+// of a check and publishes it.
+func (c *check) record(err error) {
+	if c.state.record(err == nil, c.hc) {
+		if err != nil {
+			log.Printf("[WARN] health: %s is unhealthy. %s", c.key, err)
+		} else {
+			log.Printf("[INFO] health: %s is healthy again", c.key)
+		}
+		route.SetHealthy(c.key, c.state.healthy)
+	}
+
+	var v int64
+	if c.state.healthy {
+		v = 1
+	}
+	c.mu.Lock()
+	for _, name := range c.names {
+		metrics.DefaultRegistry.GetGauge(name + ".health").Update(v)
+	}
+	c.mu.Unlock()
+}
+
+// state is the health of a target.
+type state struct {
+	healthy bool
+
+	// n is the number of consecutive results which
+	// contradict the current state.
+	n int
+}
+
+// record adds the result of a check and returns true if the
+// target has become healthy or unhealthy.
+func (s *state) record(ok bool, hc *route.HealthCheck) bool {
+	if ok == s.healthy {
+		s.n = 0
+		return false
+	}
+	s.n++
+	limit := hc.Unhealthy
+	if ok {
+		limit = hc.Healthy
+	}
+	if s.n < limit {
+		return false
+	}
+	s.healthy, s.n = ok, 0
+	return true
+}
@@ -0,0 +1,251 @@
+package health
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fabiolb/fabio/internal/rawpb"
+	"github.com/fabiolb/fabio/route"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestStateRecord(t *testing.T) {
+	hc := &route.HealthCheck{Healthy: 2, Unhealthy: 3}
+	s := state{healthy: true}
+
+	results := []struct {
+		ok, changed, healthy bool
+	}{
+		{false, false, true},
+		{false, false, true},
+		{true, false, true}, // resets the count
+		{false, false, true},
+		{false, false, true},
+		{false, true, false},
+		{true, false, false},
+		{false, false, false}, // resets the count
+		{true, false, false},
+		{true, true, true},
+	}
+	for i, r := range results {
+		if got, want := s.record(r.ok, hc), r.changed; got != want {
+			t.Fatalf("%d: got changed %v want %v", i, got, want)
+		}
+		if got, want := s.healthy, r.healthy; got != want {
+			t.Fatalf("%d: got healthy %v want %v", i, got, want)
+		}
+	}
+}
+
+func TestGRPCHealthMessages(t *testing.T) {
+	if got, want := grpcHealthRequest(""), []byte{}; !bytes.Equal(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+	if got, want := grpcHealthRequest("foo"), []byte{0x0a, 3, 'f', 'o', 'o'}; !bytes.Equal(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+
+	tests := []struct {
+		msg    []byte
+		status uint64
+		err    bool
+	}{
+		{msg: []byte{}, status: grpcUnknown},
+		{msg: []byte{0x08, 1}, status: grpcServing},
+		{msg: []byte{0x08, 2}, status: grpcNotServing},
+		// unknown fields before the status are skipped
+		{msg: []byte{0x12, 2, 'x', 'y', 0x1d, 0, 0, 0, 0, 0x08, 1}, status: grpcServing},
+		{msg: []byte{0x08}, err: true},
+		{msg: []byte{0x12, 5, 'x'}, err: true},
+		{msg: []byte{0x0b}, err: true},
+	}
+	for _, tt := range tests {
+		s, err := grpcHealthStatus(tt.msg)
+		if got, want := err != nil, tt.err; got != want {
+			t.Fatalf("%v: got error %v want %v", tt.msg, err, want)
+		}
+		if got, want := s, tt.status; got != want {
+			t.Fatalf("%v: got status %d want %d", tt.msg, got, want)
+		}
+	}
+}
+
+// newHealthServer returns a gRPC server which answers the health
+// checks of the service "svc" with the status stored in st.
+func newHealthServer(st *uint32) *grpc.Server {
+	return grpc.NewServer(
+		grpc.CustomCodec(rawpb.Codec{}),
+		grpc.UnknownServiceHandler(func(srv interface{}, stream grpc.ServerStream) error {
+			if m, _ := grpc.MethodFromServerStream(stream); m != grpcHealthMethod {
+				return status.Error(codes.Unimplemented, "unknown method")
+			}
+			var msg []byte
+			if err := stream.RecvMsg(&msg); err != nil {
+				return err
+			}
+			if !bytes.Equal(msg, grpcHealthRequest("svc")) {
+				return status.Error(codes.NotFound, "unknown service")
+			}
+			resp := []byte{0x08, byte(atomic.LoadUint32(st))}
+			return stream.SendMsg(&resp)
+		}),
+	)
+}
+
+func TestGRPCProber(t *testing.T) {
+	st := uint32(grpcServing)
+	srv := newHealthServer(&st)
+	defer srv.Stop()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(l)
+
+	probe := func(service string) error {
+		p, err := newGRPCProber(&route.Target{
+			URL:         &url.URL{Scheme: "grpc", Host: l.Addr().String()},
+			HealthCheck: &route.HealthCheck{Type: "grpc", Service: service},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer p.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return p.Check(ctx)
+	}
+
+	if err := probe("svc"); err != nil {
+		t.Fatalf("got %v want nil", err)
+	}
+	atomic.StoreUint32(&st, grpcNotServing)
+	if err, want := probe("svc"), "status NOT_SERVING"; err == nil || err.Error() != want {
+		t.Fatalf("got %v want %s", err, want)
+	}
+	if err, want := probe("other"), codes.NotFound; status.Code(err) != want {
+		t.Fatalf("got %v want %v", err, want)
+	}
+}
+
+func TestSyncChecks(t *testing.T) {
+	st := uint32(grpcNotServing)
+	srv := newHealthServer(&st)
+	defer srv.Stop()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(l)
+
+	cfg := `route add svc / grpc://` + l.Addr().String() + `/ opts "healthcheck=grpc healthcheck-service=svc healthcheck-interval=10ms healthcheck-unhealthy=2 healthcheck-healthy=1"
+route add svc / grpc://127.0.0.1:1/`
+	tbl, err := route.NewTable(bytes.NewBufferString(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	route.SetTable(tbl)
+	defer route.SetTable(make(route.Table))
+
+	running := map[string]*check{}
+	syncChecks(running, route.GetTable())
+	if got, want := len(running), 1; got != want {
+		t.Fatalf("got %d checks want %d", got, want)
+	}
+
+	checked := route.GetTable()[""][0].Targets[0]
+	wait := func(unhealthy bool) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for route.GetTable()[""][0].Targets[0].Unhealthy() != unhealthy {
+			if time.Now().After(deadline) {
+				t.Fatalf("timeout waiting for unhealthy=%v", unhealthy)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	wait(true)
+	if got, want := route.GetTable()[""][0].Targets[0].Weight, 0.0; got != want {
+		t.Fatalf("got weight %v want %v", got, want)
+	}
+	atomic.StoreUint32(&st, grpcServing)
+	wait(false)
+
+	// removing the target stops the check and clears the state
+	atomic.StoreUint32(&st, grpcNotServing)
+	wait(true)
+	syncChecks(running, route.Table{})
+	if got, want := len(running), 0; got != want {
+		t.Fatalf("got %d checks want %d", got, want)
+	}
+	if checked.Unhealthy() {
+		t.Fatal("state of stopped check not cleared")
+	}
+}
+
+func TestHTTPProber(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.RequestURI() {
+		case "/health?full=1":
+			w.WriteHeader(http.StatusOK)
+		case "/moved":
+			http.Redirect(w, r, "/fail", http.StatusFound)
+		default:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+	u, _ := url.Parse(server.URL)
+
+	tests := []struct {
+		path string
+		err  string
+	}{
+		{"/health?full=1", ""},
+		{"/moved", ""},
+		{"/fail", "status 503"},
+	}
+	for _, tt := range tests {
+		p, err := newHTTPProber(&route.Target{URL: u, HealthCheck: &route.HealthCheck{Type: "http", Path: tt.path}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = p.Check(context.Background())
+		p.Close()
+		if got, want := fmt.Sprint(err), tt.err; (want == "" && err != nil) || (want != "" && got != want) {
+			t.Fatalf("%s: got %v want %q", tt.path, err, want)
+		}
+	}
+
+	if _, err := newHTTPProber(&route.Target{URL: &url.URL{Scheme: "tcp", Host: u.Host}, HealthCheck: &route.HealthCheck{Type: "http", Path: "/"}}); err == nil {
+		t.Fatal("got nil want error for tcp target")
+	}
+}
+
+func TestTCPProber(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+
+	p, _ := newTCPProber(&route.Target{URL: &url.URL{Scheme: "tcp", Host: addr}})
+	if err := p.Check(context.Background()); err != nil {
+		t.Fatalf("got %v want nil", err)
+	}
+	l.Close()
+	if err := p.Check(context.Background()); err == nil {
+		t.Fatal("got nil want error for closed listener")
+	}
+}
@@ -0,0 +1,65 @@
+package health
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/fabiolb/fabio/route"
+)
+
+// httpProber checks the health of an http or https target with a GET
+// request. Responses with a 2xx or 3xx status code are healthy.
+// Redirects are not followed.
+type httpProber struct {
+	client *http.Client
+	url    string
+}
+
+func newHTTPProber(t *route.Target) (prober, error) {
+	switch t.URL.Scheme {
+	case "http", "https":
+	default:
+		return nil, fmt.Errorf("http health check requires an http or https target. Got: %s", t.URL.Scheme)
+	}
+	tr := &http.Transport{
+		TLSClientConfig:     &tls.Config{InsecureSkipVerify: t.TLSSkipVerify},
+		MaxIdleConnsPerHost: 1,
+	}
+	return &httpProber{
+		client: &http.Client{
+			Transport: tr,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		url: t.URL.Scheme + "://" + t.URL.Host + t.HealthCheck.Path,
+	}, nil
+}
+
+func (p *httpProber) Check(ctx context.Context) error {
+	req, err := http.NewRequest("GET", p.url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "fabio health check")
+	resp, err := p.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	// drain the body so that the connection can be reused
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 4096))
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 399 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (p *httpProber) Close() error {
+	p.client.Transport.(*http.Transport).CloseIdleConnections()
+	return nil
+}
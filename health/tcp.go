@@ -0,0 +1,31 @@
+package health
+
+import (
+	"context"
+	"net"
+
+	"github.com/fabiolb/fabio/route"
+)
+
+// tcpProber checks that a connection to the target
+// can be established.
+type tcpProber struct {
+	addr string
+}
+
+func newTCPProber(t *route.Target) (prober, error) {
+	return &tcpProber{addr: t.URL.Host}, nil
+}
+
+func (p *tcpProber) Check(ctx context.Context) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", p.addr)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func (p *tcpProber) Close() error {
+	return nil
+}
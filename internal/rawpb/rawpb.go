@@ -0,0 +1,86 @@
+// Package rawpb sends protobuf messages over gRPC which are encoded
+// and decoded by hand in the protobuf wire format. It is used for the
+// few messages of external services whose generated code is not part
+// of the dependencies.
+package rawpb
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Codec passes already encoded protobuf messages to gRPC. The request
+// and the response of a call must be of type *[]byte. Use it with
+// grpc.ForceCodec.
+type Codec struct{}
+
+func (Codec) Marshal(v interface{}) ([]byte, error) {
+	b, ok := v.(*[]byte)
+	if !ok {
+		return nil, fmt.Errorf("rawpb: cannot marshal %T", v)
+	}
+	return *b, nil
+}
+
+func (Codec) Unmarshal(data []byte, v interface{}) error {
+	b, ok := v.(*[]byte)
+	if !ok {
+		return fmt.Errorf("rawpb: cannot unmarshal into %T", v)
+	}
+	*b = append((*b)[:0], data...)
+	return nil
+}
+
+func (Codec) Name() string { return "proto" }
+
+// String implements grpc.Codec so that the codec can also be used
+// with grpc.CustomCodec on a server.
+func (Codec) String() string { return "proto" }
+
+// AppendString appends the string field num to b.
+func AppendString(b []byte, num protowire.Number, s string) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+// AppendBytes appends the bytes or the embedded message field num to b.
+func AppendBytes(b []byte, num protowire.Number, v []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, v)
+}
+
+// Walk calls fn for the fields of the encoded message b with the
+// value of varint fields in v and of length-delimited fields in data.
+// Fields of other types are skipped.
+func Walk(b []byte, fn func(num protowire.Number, v uint64, data []byte) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		var v uint64
+		var data []byte
+		switch typ {
+		case protowire.VarintType:
+			v, n = protowire.ConsumeVarint(b)
+		case protowire.BytesType:
+			data, n = protowire.ConsumeBytes(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		if typ == protowire.VarintType || typ == protowire.BytesType {
+			if err := fn(num, v, data); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
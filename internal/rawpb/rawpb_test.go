@@ -0,0 +1,59 @@
+package rawpb
+
+import (
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestWalk(t *testing.T) {
+	type field struct {
+		Num  protowire.Number
+		V    uint64
+		Data string
+	}
+
+	b := AppendString(nil, 1, "svc")
+	b = protowire.AppendTag(b, 2, protowire.VarintType)
+	b = protowire.AppendVarint(b, 7)
+	b = protowire.AppendTag(b, 3, protowire.Fixed32Type)
+	b = protowire.AppendFixed32(b, 1)
+	b = AppendBytes(b, 4, AppendString(nil, 1, "x"))
+
+	var got []field
+	err := Walk(b, func(num protowire.Number, v uint64, data []byte) error {
+		got = append(got, field{num, v, string(data)})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []field{{1, 0, "svc"}, {2, 7, ""}, {4, 0, "\x0a\x01x"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+
+	if err := Walk(b[:len(b)-1], func(protowire.Number, uint64, []byte) error { return nil }); err == nil {
+		t.Fatal("got nil want error for a truncated message")
+	}
+}
+
+func TestCodec(t *testing.T) {
+	var c Codec
+	in := []byte{1, 2, 3}
+	data, err := c.Marshal(&in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []byte
+	if err := c.Unmarshal(data, &out); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := out, in; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+	if _, err := c.Marshal("foo"); err == nil {
+		t.Fatal("got nil want error for a non *[]byte value")
+	}
+}
@@ -0,0 +1,246 @@
+package logger
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupTimeFormat is the format of the timestamp
+// which is appended to the name of rotated files.
+const backupTimeFormat = "2006-01-02T15-04-05.000"
+
+// FileWriter writes to a file which is rotated when it exceeds a
+// maximum size or is older than the rotation interval. Rotated files
+// are renamed to <path>.<timestamp> and optionally compressed. The
+// file can be reopened after it was moved by an external tool like
+// logrotate.
+type FileWriter struct {
+	// Path is the path of the file.
+	Path string
+
+	// MaxSize is the size in bytes after which the file is
+	// rotated. Zero disables the size-based rotation.
+	MaxSize int64
+
+	// Interval is the time after which the file is rotated.
+	// Zero disables the time-based rotation.
+	Interval time.Duration
+
+	// MaxBackups is the number of rotated files which are kept.
+	// Zero keeps all files.
+	MaxBackups int
+
+	// Compress enables the gzip compression of rotated files.
+	Compress bool
+
+	// Now returns the current time. If Now is nil time.Now is used.
+	Now func() time.Time
+
+	// mu guards the fields below.
+	mu     sync.Mutex
+	f      *os.File
+	size   int64
+	opened time.Time
+
+	// wg tracks the compression of the rotated files.
+	wg sync.WaitGroup
+}
+
+// Open opens the file for appending and creates it if necessary.
+func (w *FileWriter) Open() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.open()
+}
+
+func (w *FileWriter) open() error {
+	if err := os.MkdirAll(filepath.Dir(w.Path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(w.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	w.f, w.size, w.opened = f, fi.Size(), w.now()
+	return nil
+}
+
+func (w *FileWriter) now() time.Time {
+	if w.Now != nil {
+		return w.Now()
+	}
+	return time.Now()
+}
+
+// Write writes p to the file and rotates the file
+// before if p would exceed the maximum size or the
+// file is older than the rotation interval.
+func (w *FileWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.f == nil {
+		if err := w.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	full := w.MaxSize > 0 && w.size > 0 && w.size+int64(len(p)) > w.MaxSize
+	old := w.Interval > 0 && w.now().Sub(w.opened) >= w.Interval
+	if full || old {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := w.f.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// Reopen closes and opens the file. It is used after the
+// file was moved, e.g. by logrotate.
+func (w *FileWriter) Reopen() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.f != nil {
+		w.f.Close()
+		w.f = nil
+	}
+	return w.open()
+}
+
+// Close closes the file and waits for the
+// compression of the rotated files to complete.
+func (w *FileWriter) Close() error {
+	w.mu.Lock()
+	var err error
+	if w.f != nil {
+		err = w.f.Close()
+		w.f = nil
+	}
+	w.mu.Unlock()
+	w.wg.Wait()
+	return err
+}
+
+// rotate renames the current file, opens a new one
+// and removes the backups which are no longer kept.
+func (w *FileWriter) rotate() error {
+	if err := w.f.Close(); err != nil {
+		return err
+	}
+	w.f = nil
+
+	backup := w.Path + "." + w.now().Format(backupTimeFormat)
+	for i := 1; exists(backup) || exists(backup+".gz"); i++ {
+		backup = fmt.Sprintf("%s.%s-%d", w.Path, w.now().Format(backupTimeFormat), i)
+	}
+	if err := os.Rename(w.Path, backup); err != nil {
+		return err
+	}
+	if err := w.open(); err != nil {
+		return err
+	}
+
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		if w.Compress {
+			if err := compress(backup); err != nil {
+				log.Printf("[ERROR] Cannot compress %s. %s", backup, err)
+			}
+		}
+		if err := w.prune(); err != nil {
+			log.Printf("[ERROR] Cannot remove old access log files. %s", err)
+		}
+	}()
+	return nil
+}
+
+// Backups returns the names of the rotated files from
+// the oldest to the newest.
+func (w *FileWriter) Backups() ([]string, error) {
+	files, err := filepath.Glob(w.Path + ".*")
+	if err != nil {
+		return nil, err
+	}
+	var backups []string
+	for _, f := range files {
+		if strings.HasSuffix(f, ".tmp") {
+			continue
+		}
+		backups = append(backups, f)
+	}
+	sort.Strings(backups)
+	return backups, nil
+}
+
+// prune removes the oldest rotated files so
+// that at most MaxBackups files are kept.
+func (w *FileWriter) prune() error {
+	if w.MaxBackups <= 0 {
+		return nil
+	}
+	backups, err := w.Backups()
+	if err != nil {
+		return err
+	}
+	for len(backups) > w.MaxBackups {
+		if err := os.Remove(backups[0]); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
+
+// compress replaces the file with a gzip compressed file
+// with the .gz extension.
+func compress(name string) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp := name + ".gz.tmp"
+	dst, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	_, err = io.Copy(zw, src)
+	if err == nil {
+		err = zw.Close()
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, name+".gz"); err != nil {
+		return err
+	}
+	return os.Remove(name)
+}
+
+func exists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
@@ -0,0 +1,148 @@
+package logger
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileWriter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fabio")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	path := filepath.Join(dir, "log", "access.log")
+	w := &FileWriter{
+		Path:       path,
+		MaxSize:    12,
+		Interval:   time.Hour,
+		MaxBackups: 2,
+		Now:        func() time.Time { return now },
+	}
+	defer w.Close()
+
+	write := func(s string) {
+		t.Helper()
+		if _, err := w.Write([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	content := func(name string) string {
+		t.Helper()
+		b, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+	backups := func() []string {
+		t.Helper()
+		w.wg.Wait()
+		b, err := w.Backups()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+
+	// the file is created with the directory on the first write
+	write("12345\n")
+	write("1234\n")
+	if got, want := content(path), "12345\n1234\n"; got != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+	if got, want := len(backups()), 0; got != want {
+		t.Fatalf("got %d backups want %d", got, want)
+	}
+
+	// the maximum size rotates the file
+	now = now.Add(time.Second)
+	write("abc\n")
+	b := backups()
+	if got, want := b, []string{path + ".2020-01-01T00-00-01.000"}; len(got) != 1 || got[0] != want[0] {
+		t.Fatalf("got %v want %v", got, want)
+	}
+	if got, want := content(b[0]), "12345\n1234\n"; got != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+	if got, want := content(path), "abc\n"; got != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+
+	// the interval rotates the file
+	now = now.Add(time.Hour)
+	write("def\n")
+	now = now.Add(time.Hour)
+	write("ghi\n")
+	b = backups()
+	if got, want := len(b), 2; got != want {
+		t.Fatalf("got %d backups want %d", got, want)
+	}
+	if got, want := content(b[0]), "abc\n"; got != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+	if got, want := content(b[1]), "def\n"; got != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+
+	// reopen creates the file after it was moved
+	if err := os.Rename(path, path+".moved"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	write("jkl\n")
+	if got, want := content(path), "jkl\n"; got != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+}
+
+func TestFileWriterCompress(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fabio")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "access.log")
+	w := &FileWriter{Path: path, MaxSize: 4, Compress: true}
+	for _, s := range []string{"abc\n", "def\n"} {
+		if _, err := w.Write([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := w.Backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) != 1 || filepath.Ext(b[0]) != ".gz" {
+		t.Fatalf("got %v want one .gz file", b)
+	}
+	f, err := os.Open(b[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(data), "abc\n"; got != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+}
@@ -0,0 +1,128 @@
+package logger
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// JSONFormat is the name of the access log format which
+// writes one JSON object per request.
+const JSONFormat = "json"
+
+// numeric contains the fields which are written as JSON numbers.
+var numeric = map[string]bool{
+	"$response_body_size": true,
+	"$response_status":    true,
+	"$response_time_ms":   true,
+	"$response_time_us":   true,
+	"$response_time_ns":   true,
+	"$time_unix_ms":       true,
+	"$time_unix_us":       true,
+	"$time_unix_ns":       true,
+}
+
+// NewJSON creates a new logger that writes log events as JSON objects
+// with one line per event to the provided writer. The names are the
+// names of the log fields without the '$' and are used as the keys of
+// the object, e.g. 'request_uri' or 'header.User-Agent'. If no writer
+// was provided no log output is generated.
+func NewJSON(w io.Writer, names []string) (Logger, error) {
+	if w == nil {
+		return &noopLogger{}, nil
+	}
+	if len(names) == 0 {
+		return nil, errors.New("empty log fields")
+	}
+
+	text := func(s string) field {
+		return func(b *bytes.Buffer, e *Event) {
+			b.WriteString(s)
+		}
+	}
+
+	seen := map[string]bool{}
+	p := pattern{text("{")}
+	for i, name := range names {
+		name = strings.TrimSpace(name)
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate field %q", name)
+		}
+		seen[name] = true
+
+		s := []rune("$" + name)
+		if typ, n := lex(s); typ == itemText || n != len(s) {
+			return nil, fmt.Errorf("invalid field %q", name)
+		}
+		f, err := parse(string(s), fields)
+		if err != nil {
+			return nil, err
+		}
+
+		sep := ","
+		if i == 0 {
+			sep = ""
+		}
+		p = append(p, text(sep+`"`+name+`":`))
+		if numeric[string(s)] {
+			p = append(p, jsonNumber(f[0]))
+		} else {
+			p = append(p, jsonString(f[0]))
+		}
+	}
+	p = append(p, text("}"))
+	return &logger{p: p, w: w}, nil
+}
+
+// jsonString renders the value of the field as a JSON string.
+func jsonString(f field) field {
+	return func(b *bytes.Buffer, e *Event) {
+		v := pool.Get().(*bytes.Buffer)
+		v.Reset()
+		f(v, e)
+		b.WriteByte('"')
+		writeEscaped(b, v.Bytes())
+		b.WriteByte('"')
+		pool.Put(v)
+	}
+}
+
+// jsonNumber renders the value of the field as a JSON
+// number or as null if the value is empty.
+func jsonNumber(f field) field {
+	return func(b *bytes.Buffer, e *Event) {
+		n := b.Len()
+		f(b, e)
+		if b.Len() == n {
+			b.WriteString("null")
+		}
+	}
+}
+
+const hex = "0123456789abcdef"
+
+// writeEscaped writes s with the characters escaped
+// which are not allowed in a JSON string.
+func writeEscaped(b *bytes.Buffer, s []byte) {
+	for _, c := range s {
+		switch {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c == '\n':
+			b.WriteString(`\n`)
+		case c == '\r':
+			b.WriteString(`\r`)
+		case c == '\t':
+			b.WriteString(`\t`)
+		case c < 0x20:
+			b.WriteString(`\u00`)
+			b.WriteByte(hex[c>>4])
+			b.WriteByte(hex[c&0xf])
+		default:
+			b.WriteByte(c)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestJSON(t *testing.T) {
+	start := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+	e := &Event{
+		Start: start,
+		End:   start.Add(123456789 * time.Nanosecond),
+		Request: &http.Request{
+			RequestURI: "/?q=x",
+			Header:     http.Header{"User-Agent": {"Mozilla \"Firefox\"\n"}},
+			RemoteAddr: "2.2.2.2:666",
+			Host:       "foo.com",
+			Method:     "GET",
+		},
+		Response:        &http.Response{StatusCode: 200, ContentLength: 1234},
+		UpstreamAddr:    "7.8.9.0:5678",
+		UpstreamService: "svc-a",
+		Route:           "foo.com/",
+		TraceID:         "463ac35c9f6413ad",
+	}
+
+	tests := []struct {
+		desc   string
+		fields []string
+		out    string
+		err    string
+	}{
+		{
+			desc:   "fields",
+			fields: []string{"time_rfc3339_ms", "request_method", "route", "upstream_addr", "response_status", "response_time_ms", "trace_id"},
+			out:    `{"time_rfc3339_ms":"2016-01-01T00:00:00.123Z","request_method":"GET","route":"foo.com/","upstream_addr":"7.8.9.0:5678","response_status":200,"response_time_ms":0.123,"trace_id":"463ac35c9f6413ad"}` + "\n",
+		},
+		{
+			desc:   "escaped header",
+			fields: []string{"header.User-Agent", "annotation.team"},
+			out:    `{"header.User-Agent":"Mozilla \"Firefox\"\n","annotation.team":""}` + "\n",
+		},
+		{desc: "no fields", err: "empty log fields"},
+		{desc: "unknown field", fields: []string{"foo"}, err: `invalid field "$foo"`},
+		{desc: "text", fields: []string{"route x"}, err: `invalid field "route x"`},
+		{desc: "duplicate field", fields: []string{"route", "route"}, err: `duplicate field "route"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			var b bytes.Buffer
+			l, err := NewJSON(&b, tt.fields)
+			if got, want := errString(err), tt.err; got != want {
+				t.Fatalf("got error %q want %q", got, want)
+			}
+			if err != nil {
+				return
+			}
+			l.Log(e)
+			if got, want := b.String(), tt.out; got != want {
+				t.Fatalf("got %s want %s", got, want)
+			}
+			var v map[string]interface{}
+			if err := json.Unmarshal(b.Bytes(), &v); err != nil {
+				t.Fatalf("invalid JSON: %s", err)
+			}
+		})
+	}
+}
+
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
@@ -0,0 +1,83 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JSONWriter writes the lines of the application log as JSON objects
+// with one object per line. It expects the lines in the format of the
+// LevelWriter, i.e. '[LEVEL] message' after a prefix. The prefix is
+// replaced with the time of the write. Messages which are JSON objects
+// are merged into the object of the line so that structured records
+// can be logged with log.Printf("[INFO] %s", obj).
+type JSONWriter struct {
+	w         io.Writer
+	prefixLen int
+
+	// now returns the current time. If nil, time.Now is used.
+	now func() time.Time
+
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+// NewJSONWriter creates a new JSON writer for the given output. Prefix is
+// the string that is expected before the opening bracket of the level as
+// for the LevelWriter.
+func NewJSONWriter(w io.Writer, prefix string) *JSONWriter {
+	return &JSONWriter{w: w, prefixLen: len(prefix)}
+}
+
+type jsonLine struct {
+	Time  string `json:"time"`
+	Level string `json:"level,omitempty"`
+	Msg   string `json:"msg,omitempty"`
+}
+
+func (w *JSONWriter) Write(b []byte) (int, error) {
+	now := time.Now
+	if w.now != nil {
+		now = w.now
+	}
+
+	line := jsonLine{Time: now().UTC().Format(time.RFC3339Nano)}
+	s := strings.TrimRight(string(b), "\n")
+	if len(s) >= w.prefixLen {
+		s = s[w.prefixLen:]
+	}
+	if i := strings.Index(s, "] "); strings.HasPrefix(s, "[") && i > 0 {
+		line.Level, s = s[1:i], s[i+2:]
+	}
+
+	var obj map[string]json.RawMessage
+	merge := strings.HasPrefix(s, "{") && json.Unmarshal([]byte(s), &obj) == nil
+	if !merge {
+		line.Msg = s
+	}
+	data, err := json.Marshal(line)
+	if err != nil {
+		return 0, err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf.Reset()
+	if merge && len(obj) > 0 {
+		// append the fields of the message to the object of the line
+		w.buf.Write(data[:len(data)-1])
+		w.buf.WriteByte(',')
+		w.buf.WriteString(strings.TrimSpace(s)[1:])
+	} else {
+		w.buf.Write(data)
+	}
+	w.buf.WriteByte('\n')
+	if _, err := w.w.Write(w.buf.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
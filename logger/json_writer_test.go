@@ -0,0 +1,38 @@
+package logger
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestJSONWriter(t *testing.T) {
+	tests := []struct {
+		desc, in, out string
+	}{
+		{"message", "2017/01/01 00:00:00 [INFO] Listening on :9999\n", `{"time":"2020-01-01T12:00:00Z","level":"INFO","msg":"Listening on :9999"}`},
+		{"multi-line message", "2017/01/01 00:00:00 [INFO] a\n\"b\"\n", `{"time":"2020-01-01T12:00:00Z","level":"INFO","msg":"a\n\"b\""}`},
+		{"object", `2017/01/01 00:00:00 [INFO] {"msg":"Started","n":1}` + "\n", `{"time":"2020-01-01T12:00:00Z","level":"INFO","msg":"Started","n":1}`},
+		{"empty object", "2017/01/01 00:00:00 [WARN] {}\n", `{"time":"2020-01-01T12:00:00Z","level":"WARN"}`},
+		{"invalid object", "2017/01/01 00:00:00 [INFO] {a}\n", `{"time":"2020-01-01T12:00:00Z","level":"INFO","msg":"{a}"}`},
+		{"no level", "2017/01/01 00:00:00 panic\n", `{"time":"2020-01-01T12:00:00Z","msg":"panic"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			var b bytes.Buffer
+			w := NewJSONWriter(&b, "2017/01/01 00:00:00 ")
+			w.now = func() time.Time { return time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC) }
+			n, err := w.Write([]byte(tt.in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != len(tt.in) {
+				t.Fatalf("got %d bytes want %d", n, len(tt.in))
+			}
+			if got, want := b.String(), tt.out+"\n"; got != want {
+				t.Fatalf("got %s want %s", got, want)
+			}
+		})
+	}
+}
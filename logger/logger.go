@@ -5,6 +5,7 @@
 // takes place. Text between two fields is printed verbatim. See the common
 // log file formats for an example.
 //
+//   $annotation.<name>       - annotation of the route target (name: [a-zA-Z0-9_-]+)
 //   $header.<name>           - request http header (name: [a-zA-Z0-9-]+)
 //   $remote_addr             - host:port of remote client
 //   $remote_host             - host of remote client
@@ -22,6 +23,7 @@
 //   $response_time_ms        - response time in S.sss format
 //   $response_time_us        - response time in S.ssssss format
 //   $response_time_ns        - response time in S.sssssssss format
+//   $route                   - host and path prefix of the matched route
 //   $time_rfc3339            - log timestamp in YYYY-MM-DDTHH:MM:SSZ format
 //   $time_rfc3339_ms         - log timestamp in YYYY-MM-DDTHH:MM:SS.sssZ format
 //   $time_rfc3339_us         - log timestamp in YYYY-MM-DDTHH:MM:SS.ssssssZ format
@@ -30,12 +32,17 @@
 //   $time_unix_us            - log timestamp in unix epoch us
 //   $time_unix_ns            - log timestamp in unix epoch ns
 //   $time_common             - log timestamp in DD/MMM/YYYY:HH:MM:SS -ZZZZ
+//   $trace_id                - id of the trace of the request
 //   $upstream_addr           - host:port of upstream server
 //   $upstream_host           - host of upstream server
 //   $upstream_port           - port of upstream server
 //   $upstream_request_scheme - upstream request scheme
 //   $upstream_request_uri    - upstream request URI
 //   $upstream_request_url    - upstream request URL
+//   $upstream_service        - name of the upstream service
+//
+// The JSON logger writes the same fields without the '$' as
+// the keys of a JSON object per request.
 //
 package logger
 
@@ -86,6 +93,18 @@ type Event struct {
 	// UpstreamURL is the URL which was sent to the upstream server.
 	// It should only be set for HTTP log events.
 	UpstreamURL *url.URL
+
+	// Annotations are the annotations of the route target
+	// which handled the request.
+	Annotations map[string]string
+
+	// Route is the host and path prefix of the route
+	// which matched the request.
+	Route string
+
+	// TraceID is the id of the trace of the request.
+	// It is empty if tracing is disabled.
+	TraceID string
 }
 
 // Logger logs an event.
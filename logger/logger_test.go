@@ -83,12 +83,17 @@ func TestLog(t *testing.T) {
 		UpstreamAddr:    uurl.Host,
 		UpstreamService: "svc-a",
 		UpstreamURL:     uurl,
+		Annotations:     map[string]string{"team": "payments"},
+		Route:           "foo.com/",
+		TraceID:         "463ac35c9f6413ad48485a3953bb6124",
 	}
 
 	tests := []struct {
 		format string
 		out    string
 	}{
+		{"$annotation.team", "payments\n"},
+		{"$annotation.team $annotation.tier.", "payments .\n"},
 		{"$header.Referer", "http://foo.com/\n"},
 		{"$header.X-Forwarded-For", "3.3.3.3\n"},
 		{"$header.user-agent", "Mozilla Firefox\n"},
@@ -108,6 +113,7 @@ func TestLog(t *testing.T) {
 		{"$response_time_ms", "0.123\n"},       // TODO(fs): is this correct?
 		{"$response_time_ns", "0.123456789\n"}, // TODO(fs): is this correct?
 		{"$response_time_us", "0.123456\n"},    // TODO(fs): is this correct?
+		{"$route", "foo.com/\n"},
 		{"$time_common", "01/Jan/2016:00:00:00 +0000\n"},
 		{"$time_rfc3339", "2016-01-01T00:00:00Z\n"},
 		{"$time_rfc3339_ms", "2016-01-01T00:00:00.123Z\n"},
@@ -116,6 +122,7 @@ func TestLog(t *testing.T) {
 		{"$time_unix_ms", "1451606400123\n"},
 		{"$time_unix_ns", "1451606400123456789\n"},
 		{"$time_unix_us", "1451606400123456\n"},
+		{"$trace_id", "463ac35c9f6413ad48485a3953bb6124\n"},
 		{"$upstream_addr", "7.8.9.0:5678\n"},
 		{"$upstream_host", "7.8.9.0\n"},
 		{"$upstream_port", "5678\n"},
@@ -142,6 +142,9 @@ var fields = map[string]field{
 		b.WriteRune('.')
 		atoi(b, ns, 9)
 	},
+	"$route": func(b *bytes.Buffer, e *Event) {
+		b.WriteString(e.Route)
+	},
 	"$time_unix_ms": func(b *bytes.Buffer, e *Event) {
 		atoi(b, e.End.UnixNano()/int64(time.Millisecond), 0)
 	},
@@ -227,6 +230,9 @@ var fields = map[string]field{
 		atoi(b, int64(e.End.Nanosecond()), 9)
 		b.WriteRune('Z')
 	},
+	"$trace_id": func(b *bytes.Buffer, e *Event) {
+		b.WriteString(e.TraceID)
+	},
 	"$upstream_addr": func(b *bytes.Buffer, e *Event) {
 		b.WriteString(e.UpstreamAddr)
 	},
@@ -328,8 +334,9 @@ func atoi(b *bytes.Buffer, i int64, pad int) {
 //
 // The format string consists of text and fields. Field names start with a '$'
 // and consist of ASCII characters [a-zA-Z0-9.-_]. Field names like
-// '$header.name' will render the HTTP header 'name'. All other field names
-// must exist in the fields map.
+// '$header.name' will render the HTTP header 'name' and field names like
+// '$annotation.name' the annotation 'name' of the route target. All other
+// field names must exist in the fields map.
 func parse(format string, fields map[string]field) (p pattern, err error) {
 	// text is a helper to add raw text to the log output.
 	text := func(s string) field {
@@ -348,6 +355,13 @@ func parse(format string, fields map[string]field) (p pattern, err error) {
 		}
 	}
 
+	// annotation is a helper to add an annotation of the target to the log output.
+	annotation := func(name string) field {
+		return func(b *bytes.Buffer, e *Event) {
+			b.WriteString(e.Annotations[name])
+		}
+	}
+
 	s := []rune(format)
 	for {
 		if len(s) == 0 {
@@ -361,6 +375,8 @@ func parse(format string, fields map[string]field) (p pattern, err error) {
 			p = append(p, text(val))
 		case itemHeader:
 			p = append(p, header(val[len("$header."):]))
+		case itemAnnotation:
+			p = append(p, annotation(val[len("$annotation."):]))
 		case itemField:
 			f := fields[val]
 			if f == nil {
@@ -378,6 +394,7 @@ const (
 	itemText itemType = iota
 	itemField
 	itemHeader
+	itemAnnotation
 )
 
 func (t itemType) String() string {
@@ -388,6 +405,8 @@ func (t itemType) String() string {
 		return "FIELD"
 	case itemHeader:
 		return "HEADER"
+	case itemAnnotation:
+		return "ANNOTATION"
 	}
 	panic("invalid")
 }
@@ -408,6 +427,12 @@ func lex(s []rune) (typ itemType, n int) {
 		return 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' || r == '_' || r == '-'
 	}
 
+	// dotted is the type of the '$header.' and '$annotation.' fields.
+	dotted := itemHeader
+	if strings.HasPrefix(string(s), "$annotation.") {
+		dotted = itemAnnotation
+	}
+
 	state := stateStart
 	for i, r := range s {
 		switch state {
@@ -438,7 +463,7 @@ func lex(s []rune) (typ itemType, n int) {
 		case stateField:
 			switch {
 			case r == '.':
-				if string(s[:i]) == "$header" {
+				if f := string(s[:i]); f == "$header" || f == "$annotation" {
 					state = stateDot
 				} else {
 					return itemField, i
@@ -462,7 +487,7 @@ func lex(s []rune) (typ itemType, n int) {
 			case isIDChar(r):
 				// state = stateHeader
 			default:
-				return itemHeader, i
+				return dotted, i
 			}
 		}
 	}
@@ -473,7 +498,7 @@ func lex(s []rune) (typ itemType, n int) {
 	case stateField:
 		return itemField, len(s)
 	case stateHeader:
-		return itemHeader, len(s)
+		return dotted, len(s)
 	default:
 		return itemText, len(s)
 	}
@@ -0,0 +1,237 @@
+package logger
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// facilities maps the names of the syslog facilities to their codes.
+var facilities = map[string]int{
+	"kern":     0,
+	"user":     1,
+	"mail":     2,
+	"daemon":   3,
+	"auth":     4,
+	"syslog":   5,
+	"lpr":      6,
+	"news":     7,
+	"uucp":     8,
+	"cron":     9,
+	"authpriv": 10,
+	"ftp":      11,
+	"local0":   16,
+	"local1":   17,
+	"local2":   18,
+	"local3":   19,
+	"local4":   20,
+	"local5":   21,
+	"local6":   22,
+	"local7":   23,
+}
+
+// syslog severities
+const (
+	sevCrit    = 2
+	sevErr     = 3
+	sevWarning = 4
+	sevInfo    = 6
+	sevDebug   = 7
+)
+
+// syslogSockets are the paths of the local syslog socket
+// which are tried in order if no address is configured.
+var syslogSockets = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+// SyslogWriter writes every call to Write as one message to a syslog
+// server. The address is either empty for the local syslog socket or
+// has the form 'udp://host:port', 'tcp://host:port', 'tls://host:port'
+// or 'unix:///path'. Messages which start with a log level like
+// '[WARN]' are sent with the matching severity. All other messages
+// are sent with severity 'info'. The connection is established on the
+// first write and re-established after an error.
+type SyslogWriter struct {
+	// Addr is the address of the syslog server.
+	Addr string
+
+	// Facility is the name of the facility, e.g. 'local0'.
+	Facility string
+
+	// Tag is the name of the application in the messages.
+	Tag string
+
+	// TLSConfig is the TLS configuration for 'tls://' addresses.
+	TLSConfig *tls.Config
+
+	// Fallback receives the messages which cannot be sent
+	// to the syslog server. It is ignored if nil.
+	Fallback io.Writer
+
+	// mu guards the fields below.
+	mu       sync.Mutex
+	network  string
+	raddr    string
+	local    bool
+	facility int
+	hostname string
+	conn     net.Conn
+}
+
+// Init validates the address and the facility. It does not
+// connect to the syslog server.
+func (w *SyslogWriter) Init() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	f, ok := facilities[strings.ToLower(w.Facility)]
+	if !ok {
+		return fmt.Errorf("invalid syslog facility %q", w.Facility)
+	}
+	w.facility = f
+
+	switch {
+	case w.Addr == "":
+		w.local = true
+	case strings.HasPrefix(w.Addr, "unix://"):
+		w.local, w.raddr = true, strings.TrimPrefix(w.Addr, "unix://")
+	default:
+		p := strings.SplitN(w.Addr, "://", 2)
+		if len(p) != 2 || p[1] == "" {
+			return fmt.Errorf("invalid syslog address %q", w.Addr)
+		}
+		switch p[0] {
+		case "udp", "tcp", "tls":
+			w.network, w.raddr = p[0], p[1]
+		default:
+			return fmt.Errorf("invalid syslog address %q", w.Addr)
+		}
+	}
+
+	if w.Tag == "" {
+		w.Tag = "fabio"
+	}
+	w.hostname, _ = os.Hostname()
+	if w.hostname == "" {
+		w.hostname = "localhost"
+	}
+	return nil
+}
+
+// Write sends b as one message to the syslog server.
+func (w *SyslogWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	msg := w.format(b)
+
+	// retry once with a new connection since the
+	// server may have closed the connection.
+	var err error
+	for i := 0; i < 2; i++ {
+		if w.conn == nil {
+			var c net.Conn
+			if c, err = w.dial(); err != nil {
+				break
+			}
+			w.conn = c
+		}
+		if _, err = w.conn.Write(msg); err == nil {
+			return len(b), nil
+		}
+		w.conn.Close()
+		w.conn = nil
+	}
+	if w.Fallback != nil {
+		w.Fallback.Write(b)
+	}
+	return 0, err
+}
+
+// Close closes the connection to the syslog server.
+func (w *SyslogWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.conn == nil {
+		return nil
+	}
+	err := w.conn.Close()
+	w.conn = nil
+	return err
+}
+
+// format returns the message for b. Messages to the local socket use
+// the short BSD format. Messages to remote servers contain the host
+// name and an RFC3339 timestamp. Messages over TCP are terminated
+// with a newline.
+func (w *SyslogWriter) format(b []byte) []byte {
+	msg := strings.TrimRight(string(b), "\n")
+	pri := w.facility*8 + severity(msg)
+	if w.local {
+		ts := time.Now().Format(time.Stamp)
+		return []byte(fmt.Sprintf("<%d>%s %s[%d]: %s", pri, ts, w.Tag, os.Getpid(), msg))
+	}
+	ts := time.Now().Format(time.RFC3339)
+	msg = fmt.Sprintf("<%d>%s %s %s[%d]: %s", pri, ts, w.hostname, w.Tag, os.Getpid(), msg)
+	if w.network != "udp" {
+		msg += "\n"
+	}
+	return []byte(msg)
+}
+
+func (w *SyslogWriter) dial() (net.Conn, error) {
+	switch {
+	case w.local:
+		return dialLocal(w.raddr)
+	case w.network == "tls":
+		c, err := tls.DialWithDialer(&net.Dialer{Timeout: 10 * time.Second}, "tcp", w.raddr, w.TLSConfig)
+		if err != nil {
+			return nil, err
+		}
+		return c, nil
+	default:
+		return net.DialTimeout(w.network, w.raddr, 10*time.Second)
+	}
+}
+
+// dialLocal connects to the local syslog socket at path
+// or to the first of the well-known sockets if path is empty.
+func dialLocal(path string) (net.Conn, error) {
+	paths := syslogSockets
+	if path != "" {
+		paths = []string{path}
+	}
+	for _, p := range paths {
+		for _, network := range []string{"unixgram", "unix"} {
+			if c, err := net.Dial(network, p); err == nil {
+				return c, nil
+			}
+		}
+	}
+	return nil, errors.New("cannot connect to local syslog socket")
+}
+
+// severity returns the syslog severity for the log level at the
+// beginning of the message.
+func severity(msg string) int {
+	if len(msg) < 2 || msg[0] != '[' {
+		return sevInfo
+	}
+	switch msg[1] {
+	case 'T', 'D':
+		return sevDebug
+	case 'W':
+		return sevWarning
+	case 'E':
+		return sevErr
+	case 'F':
+		return sevCrit
+	default:
+		return sevInfo
+	}
+}
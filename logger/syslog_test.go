@@ -0,0 +1,186 @@
+package logger
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+func TestSyslogWriterInit(t *testing.T) {
+	tests := []struct {
+		addr, facility string
+		err            string
+	}{
+		{"", "local0", ""},
+		{"udp://127.0.0.1:514", "LOCAL7", ""},
+		{"tcp://127.0.0.1:514", "daemon", ""},
+		{"tls://127.0.0.1:6514", "user", ""},
+		{"unix:///dev/log", "local0", ""},
+		{"", "foo", `invalid syslog facility "foo"`},
+		{"http://127.0.0.1:514", "local0", `invalid syslog address "http://127.0.0.1:514"`},
+		{"127.0.0.1:514", "local0", `invalid syslog address "127.0.0.1:514"`},
+		{"udp://", "local0", `invalid syslog address "udp://"`},
+	}
+
+	for _, tt := range tests {
+		w := &SyslogWriter{Addr: tt.addr, Facility: tt.facility}
+		err := w.Init()
+		var got string
+		if err != nil {
+			got = err.Error()
+		}
+		if got != tt.err {
+			t.Errorf("%s %s: got error %q want %q", tt.addr, tt.facility, got, tt.err)
+		}
+	}
+}
+
+func TestSyslogWriterUDP(t *testing.T) {
+	c, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	w := &SyslogWriter{Addr: "udp://" + c.LocalAddr().String(), Facility: "local1", Tag: "fab"}
+	if err := w.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	read := func() string {
+		t.Helper()
+		b := make([]byte, 1024)
+		n, _, err := c.ReadFrom(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b[:n])
+	}
+
+	tests := []struct {
+		msg string
+		pri int
+	}{
+		{"[INFO] foo\n", 17*8 + 6},
+		{"[WARN] foo\n", 17*8 + 4},
+		{"[ERROR] foo\n", 17*8 + 3},
+		{"[FATAL] foo\n", 17*8 + 2},
+		{"[DEBUG] foo\n", 17*8 + 7},
+		{`{"status":200}` + "\n", 17*8 + 6},
+	}
+	for _, tt := range tests {
+		if _, err := w.Write([]byte(tt.msg)); err != nil {
+			t.Fatal(err)
+		}
+		msg := tt.msg[:len(tt.msg)-1]
+		re := regexp.MustCompile(fmt.Sprintf(`^<%d>\S+ \S+ fab\[%d\]: %s$`, tt.pri, os.Getpid(), regexp.QuoteMeta(msg)))
+		if got := read(); !re.MatchString(got) {
+			t.Errorf("got %q want %s", got, re)
+		}
+	}
+}
+
+func TestSyslogWriterTCP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	w := &SyslogWriter{Addr: "tcp://" + l.Addr().String(), Facility: "local0"}
+	if err := w.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	// the writer reconnects after the server closed the connection
+	for i := 0; i < 2; i++ {
+		if _, err := w.Write([]byte("[INFO] foo\n")); err != nil {
+			t.Fatal(err)
+		}
+		c, err := l.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		line, err := bufio.NewReader(c).ReadString('\n')
+		c.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		re := regexp.MustCompile(`^<134>\S+ \S+ fabio\[\d+\]: \[INFO\] foo\n$`)
+		if !re.MatchString(line) {
+			t.Fatalf("got %q want %s", line, re)
+		}
+
+		// wait until the writer notices the closed connection
+		if i == 0 {
+			for {
+				if _, err := w.conn.Write([]byte("x\n")); err != nil {
+					break
+				}
+			}
+		}
+	}
+}
+
+func TestSyslogWriterLocal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fabio")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "log")
+	c, err := net.ListenPacket("unixgram", path)
+	if err != nil {
+		t.Skip(err)
+	}
+	defer c.Close()
+
+	w := &SyslogWriter{Addr: "unix://" + path, Facility: "local0"}
+	if err := w.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	if _, err := w.Write([]byte("[WARN] foo\n")); err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, 1024)
+	n, _, err := c.ReadFrom(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	re := regexp.MustCompile(`^<132>\w{3} [ \d]\d \d\d:\d\d:\d\d fabio\[\d+\]: \[WARN\] foo$`)
+	if got := string(b[:n]); !re.MatchString(got) {
+		t.Fatalf("got %q want %s", got, re)
+	}
+}
+
+func TestSyslogWriterFallback(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	var buf bytes.Buffer
+	w := &SyslogWriter{Addr: "tcp://" + addr, Facility: "local0", Fallback: &buf}
+	if err := w.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("[ERROR] foo\n")); err == nil {
+		t.Fatal("got nil want error")
+	}
+	if got, want := buf.String(), "[ERROR] foo\n"; got != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+}
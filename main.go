@@ -4,9 +4,11 @@ import (
 	"bytes"
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"net"
 	"net/http"
@@ -20,25 +22,39 @@ import (
 
 	"github.com/fabiolb/fabio/admin"
 	"github.com/fabiolb/fabio/auth"
+	"github.com/fabiolb/fabio/cache"
+	"github.com/fabiolb/fabio/capture"
 	"github.com/fabiolb/fabio/cert"
 	"github.com/fabiolb/fabio/config"
 	"github.com/fabiolb/fabio/exit"
+	"github.com/fabiolb/fabio/ha"
+	"github.com/fabiolb/fabio/health"
 	"github.com/fabiolb/fabio/logger"
 	"github.com/fabiolb/fabio/metrics"
 	"github.com/fabiolb/fabio/noroute"
 	"github.com/fabiolb/fabio/proxy"
 	"github.com/fabiolb/fabio/proxy/tcp"
+	"github.com/fabiolb/fabio/proxy/udp"
+	"github.com/fabiolb/fabio/redact"
 	"github.com/fabiolb/fabio/registry"
+	"github.com/fabiolb/fabio/registry/alias"
 	"github.com/fabiolb/fabio/registry/consul"
 	"github.com/fabiolb/fabio/registry/custom"
+	"github.com/fabiolb/fabio/registry/etcd"
 	"github.com/fabiolb/fabio/registry/file"
+	"github.com/fabiolb/fabio/registry/flags"
+	"github.com/fabiolb/fabio/registry/kubernetes"
+	"github.com/fabiolb/fabio/registry/nomad"
+	"github.com/fabiolb/fabio/registry/replay"
+	"github.com/fabiolb/fabio/registry/rollout"
 	"github.com/fabiolb/fabio/registry/static"
+	"github.com/fabiolb/fabio/registry/zookeeper"
 	"github.com/fabiolb/fabio/route"
+	"github.com/fabiolb/fabio/snapshot"
 	"github.com/fabiolb/fabio/trace"
 
 	grpc_proxy "github.com/mwitkow/grpc-proxy/proxy"
 	"github.com/pkg/profile"
-	dmp "github.com/sergi/go-diff/diffmatchpatch"
 	"google.golang.org/grpc"
 )
 
@@ -54,10 +70,20 @@ var version = "1.5.15"
 
 var shuttingDown int32
 
+// upgraded is set when a new process has taken over the listeners.
+var upgraded int32
+
 func main() {
 	logOutput := logger.NewLevelWriter(os.Stderr, "INFO", "2017/01/01 00:00:00 ")
 	log.SetOutput(logOutput)
 
+	if len(os.Args) > 1 && os.Args[1] == "bench" {
+		if err := runBench(os.Args[2:], os.Stdout); err != nil {
+			exit.Fatalf("[FATAL] %s. %s", version, err)
+		}
+		return
+	}
+
 	cfg, err := config.Load(os.Args, os.Environ())
 	if err != nil {
 		exit.Fatalf("[FATAL] %s. %s", version, err)
@@ -67,12 +93,29 @@ func main() {
 		return
 	}
 
+	out, prefix := io.Writer(os.Stderr), "2017/01/01 00:00:00 "
+	switch cfg.Log.Target {
+	case "stdout":
+		out = os.Stdout
+	case "syslog":
+		// syslog adds its own timestamp
+		out, prefix = openSyslog(cfg), ""
+		log.SetFlags(0)
+	}
+	if cfg.Log.Format == "json" {
+		out = logger.NewJSONWriter(out, prefix)
+	}
+	logOutput = logger.NewLevelWriter(out, "INFO", prefix)
+	log.SetOutput(logOutput)
+
 	log.Printf("[INFO] Setting log level to %s", logOutput.Level())
 	if !logOutput.SetLevel(cfg.Log.Level) {
 		log.Printf("[INFO] Cannot set log level to %s", cfg.Log.Level)
 	}
 
-	log.Printf("[INFO] Runtime config\n" + toJSON(cfg))
+	if cfg.Log.Banner {
+		log.Printf("[INFO] Runtime config\n" + toJSON(cfg))
+	}
 	log.Printf("[INFO] Version %s starting", version)
 	log.Printf("[INFO] Go runtime is %s", runtime.Version())
 
@@ -108,13 +151,18 @@ func main() {
 		log.Printf("[INFO] Profile path %q", cfg.ProfilePath)
 	}
 
+	runtimeCfg.Store(cfg)
+	listenReload(logOutput)
+	listenReopen()
+
 	exit.Listen(func(s os.Signal) {
 		atomic.StoreInt32(&shuttingDown, 1)
-		proxy.Shutdown(cfg.Proxy.ShutdownWait)
+		proxy.Shutdown(currentConfig().Proxy.ShutdownWait)
 		if prof != nil {
 			prof.Stop()
 		}
-		if registry.Default == nil {
+		// the new process of an upgrade uses the same registrations
+		if registry.Default == nil || atomic.LoadInt32(&upgraded) > 0 {
 			return
 		}
 		registry.Default.DeregisterAll()
@@ -124,22 +172,60 @@ func main() {
 	// that are used by other parts of the code.
 	initMetrics(cfg)
 	initRuntime(cfg)
+	initNoFile(cfg)
+
+	// the sticky session cookies are signed when the routes are added
+	route.StickySecret = []byte(cfg.Proxy.StickySecret)
+	route.DebugHeader = cfg.Proxy.DebugHeader
+	route.DebugSecret = []byte(cfg.Proxy.DebugSecret)
+	route.TraceHeader = cfg.Proxy.TraceHeader
+	route.TraceSecret = []byte(cfg.Proxy.TraceSecret)
+	route.FlapThreshold = cfg.Registry.Flap.Threshold
+	route.FlapWindow = cfg.Registry.Flap.Window
+	route.FlapHold = cfg.Registry.Flap.Hold
+	route.FlapWeight = cfg.Registry.Flap.Weight
+	route.OutlierThreshold = cfg.Proxy.Outlier.Threshold
+	route.OutlierMinRequests = cfg.Proxy.Outlier.MinRequests
+	route.OutlierWindow = cfg.Proxy.Outlier.Window
+	route.OutlierEjection = cfg.Proxy.Outlier.Ejection
+	route.OutlierMaxEjected = cfg.Proxy.Outlier.MaxEjected
+	route.OutlierRampUp = cfg.Proxy.Outlier.RampUp
+	route.OutlierLatency = cfg.Proxy.Outlier.Latency
+	route.FailoverThreshold = cfg.Proxy.Failover.Threshold
+	route.FailoverRecover = cfg.Proxy.Failover.Recover
+	route.FailoverHold = cfg.Proxy.Failover.Hold
+	initHashPicker(cfg)
 	initBackend(cfg)
+	initRoutesWebhook(cfg)
+
+	// init the redaction rules before anything is logged or traced
+	redact.Default = redact.New(cfg.Log.Redact)
 
 	// init OpenTracing, if enabled
 	trace.InitializeTracer(&cfg.Tracing)
 
 	startAdmin(cfg)
 
+	initNoRoute(cfg)
 	go watchNoRouteHTML(cfg)
 
 	first := make(chan bool)
 	go watchBackend(cfg, first)
 	log.Print("[INFO] Waiting for first routing table")
 	<-first
+	go health.Run(time.Second)
+	initSnapshots(cfg)
 
 	// create proxies after metrics since they use the metrics registry.
-	startServers(cfg)
+	// The timeouts may have been reloaded in the meantime.
+	startServers(currentConfig())
+
+	// the listeners are created by the go routines of startServers
+	proxy.Ready(time.Second)
+	initHA(cfg)
+	listenUpgrade()
+	watchFlags(cfg)
+	logStartupStatus(currentConfig(), time.Second)
 
 	// warn again so that it is visible in the terminal
 	WarnIfRunAsRoot(cfg.Insecure)
@@ -160,9 +246,10 @@ func newGrpcProxy(cfg *config.Config, tlscfg *tls.Config) []grpc.ServerOption {
 	}
 
 	proxyInterceptor := proxy.GrpcProxyInterceptor{
-		Config:       cfg,
-		StatsHandler: statsHandler,
-		GlobCache:    globCache,
+		Config:        cfg,
+		StatsHandler:  statsHandler,
+		GlobCache:     globCache,
+		CurrentConfig: currentConfig,
 	}
 
 	handler := grpc_proxy.TransparentHandler(proxy.GetGRPCDirector(tlscfg))
@@ -175,18 +262,23 @@ func newGrpcProxy(cfg *config.Config, tlscfg *tls.Config) []grpc.ServerOption {
 	}
 }
 
-func newHTTPProxy(cfg *config.Config) http.Handler {
+func newHTTPProxy(cfg *config.Config) *proxy.HTTPProxy {
 	var w io.Writer
 
 	//Init Glob Cache
 	globCache := route.NewGlobCache(cfg.GlobCacheSize)
 
-	switch cfg.Log.AccessTarget {
-	case "":
+	switch target := cfg.Log.AccessTarget; {
+	case target == "":
 		log.Printf("[INFO] Access logging disabled")
-	case "stdout":
+	case target == "stdout":
 		log.Printf("[INFO] Writing access log to stdout")
 		w = os.Stdout
+	case strings.HasPrefix(target, "file:"):
+		w = openAccessLog(cfg)
+	case target == "syslog":
+		log.Printf("[INFO] Writing access log to syslog")
+		w = openSyslog(cfg)
 	default:
 		exit.Fatal("[FATAL] Invalid access log target ", cfg.Log.AccessTarget)
 	}
@@ -199,60 +291,252 @@ func newHTTPProxy(cfg *config.Config) http.Handler {
 		format = logger.CombinedFormat
 	}
 
-	l, err := logger.New(w, format)
+	var (
+		l   logger.Logger
+		err error
+	)
+	if format == logger.JSONFormat {
+		l, err = logger.NewJSON(w, cfg.Log.AccessJSONFields)
+	} else {
+		l, err = logger.New(w, format)
+	}
 	if err != nil {
 		exit.Fatal("[FATAL] Invalid log format: ", err)
 	}
 
-	pick := route.Picker[cfg.Proxy.Strategy]
-	match := route.Matcher[cfg.Proxy.Matcher]
 	notFound := metrics.DefaultRegistry.GetCounter("notfound")
 	log.Printf("[INFO] Using routing strategy %q", cfg.Proxy.Strategy)
 	log.Printf("[INFO] Using route matching %q", cfg.Proxy.Matcher)
 
-	newTransport := func(tlscfg *tls.Config) *http.Transport {
-		return &http.Transport{
-			ResponseHeaderTimeout: cfg.Proxy.ResponseHeaderTimeout,
-			IdleConnTimeout: cfg.Proxy.IdleConnTimeout,
-			MaxIdleConnsPerHost:   cfg.Proxy.MaxConn,
-			Dial: (&net.Dialer{
-				Timeout:   cfg.Proxy.DialTimeout,
-				KeepAlive: cfg.Proxy.KeepAliveTimeout,
-			}).Dial,
-			TLSClientConfig: tlscfg,
-		}
-	}
-
 	authSchemes, err := auth.LoadAuthSchemes(cfg.Proxy.AuthSchemes)
 
 	if err != nil {
 		exit.Fatal("[FATAL] ", err)
 	}
 
+	var rec *capture.Recorder
+	if cfg.Proxy.Capture.Target != "" {
+		rec, err = capture.New(cfg.Proxy.Capture)
+		if err != nil {
+			exit.Fatal("[FATAL] Cannot create capture file. ", err)
+		}
+		log.Printf("[INFO] Capturing requests in %s format to %s", cfg.Proxy.Capture.Format, cfg.Proxy.Capture.Target)
+		exit.Listen(func(os.Signal) { rec.Close() })
+	}
+
+	if err := proxy.CheckMiddleware(cfg.Proxy.Middleware); err != nil {
+		exit.Fatal("[FATAL] Invalid proxy.middleware. ", err)
+	}
+	log.Printf("[INFO] Using middleware %q", cfg.Proxy.Middleware)
+
+	var rateLimit *route.RateLimiter
+	if cfg.Proxy.RateLimit.Rate > 0 {
+		rateLimit = route.NewRateLimiter(cfg.Proxy.RateLimit.Rate, cfg.Proxy.RateLimit.Burst)
+		log.Printf("[INFO] Limiting requests to %g per second with bursts of %d", rateLimit.Rate, rateLimit.Burst)
+	}
+
 	return &proxy.HTTPProxy{
 		Config:            cfg.Proxy,
-		Transport:         newTransport(nil),
-		InsecureTransport: newTransport(&tls.Config{InsecureSkipVerify: true}),
+		RateLimit:         rateLimit,
+		Transport:         newTransport(cfg, nil),
+		InsecureTransport: newTransport(cfg, &tls.Config{InsecureSkipVerify: true}),
 		Lookup: func(r *http.Request) *route.Target {
-			t := route.GetTable().Lookup(r, r.Header.Get("trace"), pick, match, globCache, cfg.GlobMatchingDisabled)
+			// the strategy and the matcher can be reloaded
+			c := currentConfig()
+			pick, match := route.Picker[c.Proxy.Strategy], route.Matcher[c.Proxy.Matcher]
+			t := route.GetTable().Lookup(r, route.TraceID(r), pick, match, globCache, cfg.GlobMatchingDisabled)
 			if t == nil {
 				notFound.Inc(1)
+				noroute.Record(r)
 				log.Print("[WARN] No route for ", r.Host, r.URL)
 			}
 			return t
 		},
-		Requests:    metrics.DefaultRegistry.GetTimer("requests"),
-		Noroute:     metrics.DefaultRegistry.GetCounter("notfound"),
-		Logger:      l,
-		TracerCfg:   cfg.Tracing,
-		AuthSchemes: authSchemes,
+		Requests:         metrics.DefaultRegistry.GetTimer("requests"),
+		Noroute:          metrics.DefaultRegistry.GetCounter("notfound"),
+		Logger:           l,
+		TracerCfg:        cfg.Tracing,
+		AuthSchemes:      authSchemes,
+		Capture:          rec,
+		Cache:            openCache(cfg),
+		Mirror:           proxy.NewMirror(cfg.Proxy.Mirror, newTransport(cfg, nil)),
+		UserAgentMetrics: cfg.Metrics.UserAgent,
+	}
+}
+
+// accessLog is the access log file which is shared by all http proxies.
+var accessLog struct {
+	sync.Mutex
+	w *logger.FileWriter
+}
+
+// openAccessLog returns the access log file of the 'file:<path>' target.
+func openAccessLog(cfg *config.Config) *logger.FileWriter {
+	accessLog.Lock()
+	defer accessLog.Unlock()
+	if accessLog.w != nil {
+		return accessLog.w
+	}
+
+	fc := cfg.Log.AccessFile
+	w := &logger.FileWriter{
+		Path:       strings.TrimPrefix(cfg.Log.AccessTarget, "file:"),
+		MaxSize:    int64(fc.MaxSize) << 20,
+		Interval:   fc.Interval,
+		MaxBackups: fc.MaxBackups,
+		Compress:   fc.Compress,
+	}
+	if err := w.Open(); err != nil {
+		exit.Fatal("[FATAL] Cannot open access log file. ", err)
+	}
+	log.Printf("[INFO] Writing access log to %s", w.Path)
+	exit.Listen(func(os.Signal) { w.Close() })
+	accessLog.w = w
+	return w
+}
+
+// cacheStore is the response cache which is shared by
+// all http proxies and the admin API.
+var cacheStore struct {
+	sync.Mutex
+	s cache.Store
+}
+
+// openCache returns the response cache for the proxy.cache options.
+func openCache(cfg *config.Config) cache.Store {
+	cacheStore.Lock()
+	defer cacheStore.Unlock()
+	if cacheStore.s != nil {
+		return cacheStore.s
+	}
+
+	cc := cfg.Proxy.Cache
+	switch cc.Store {
+	case "disk":
+		s, err := cache.NewDiskStore(cc.Dir, int64(cc.MaxSize))
+		if err != nil {
+			exit.Fatal("[FATAL] Cannot create cache directory. ", err)
+		}
+		log.Printf("[INFO] Caching responses in %s", cc.Dir)
+		cacheStore.s = s
+	default:
+		cacheStore.s = cache.NewMemoryStore(int64(cc.MaxSize))
+	}
+	return cacheStore.s
+}
+
+// syslogOut is the syslog writer which is shared by
+// the application log and the access log.
+var syslogOut struct {
+	sync.Mutex
+	w *logger.SyslogWriter
+}
+
+// openSyslog returns the syslog writer for the log.syslog options.
+func openSyslog(cfg *config.Config) *logger.SyslogWriter {
+	syslogOut.Lock()
+	defer syslogOut.Unlock()
+	if syslogOut.w != nil {
+		return syslogOut.w
+	}
+
+	sc := cfg.Log.Syslog
+	w := &logger.SyslogWriter{Addr: sc.Addr, Facility: sc.Facility, Tag: sc.Tag, Fallback: os.Stderr}
+	if strings.HasPrefix(sc.Addr, "tls://") {
+		w.TLSConfig = &tls.Config{}
+		if sc.TLSCAFile != "" {
+			pem, err := ioutil.ReadFile(sc.TLSCAFile)
+			if err != nil {
+				exit.Fatal("[FATAL] Cannot read syslog CA file. ", err)
+			}
+			w.TLSConfig.RootCAs = x509.NewCertPool()
+			if !w.TLSConfig.RootCAs.AppendCertsFromPEM(pem) {
+				exit.Fatal("[FATAL] Cannot parse syslog CA file ", sc.TLSCAFile)
+			}
+		}
+	}
+	if err := w.Init(); err != nil {
+		exit.Fatal("[FATAL] Invalid syslog configuration. ", err)
+	}
+	exit.Listen(func(os.Signal) { w.Close() })
+	syslogOut.w = w
+	return w
+}
+
+// reopenAccessLog reopens the access log file after
+// it was moved by an external tool like logrotate.
+func reopenAccessLog() {
+	accessLog.Lock()
+	w := accessLog.w
+	accessLog.Unlock()
+	if w == nil {
+		return
+	}
+	if err := w.Reopen(); err != nil {
+		log.Printf("[ERROR] Cannot reopen access log file. %s", err)
+		return
+	}
+	log.Printf("[INFO] Reopened access log file %s", w.Path)
+}
+
+// newTransport returns the connection pool for the upstream
+// connections of the http proxy.
+func newTransport(cfg *config.Config, tlscfg *tls.Config) *http.Transport {
+	return &http.Transport{
+		ResponseHeaderTimeout: cfg.Proxy.ResponseHeaderTimeout,
+		IdleConnTimeout:       cfg.Proxy.IdleConnTimeout,
+		MaxIdleConnsPerHost:   cfg.Proxy.MaxConn,
+		Dial: (&net.Dialer{
+			Timeout:   cfg.Proxy.DialTimeout,
+			KeepAlive: cfg.Proxy.KeepAliveTimeout,
+		}).Dial,
+		TLSClientConfig: tlscfg,
+	}
+}
+
+// reloadHTTPProxy returns a copy of the http proxy which uses the
+// reloadable settings of cfg. The upstream connections of the new
+// proxy use new connection pools.
+func reloadHTTPProxy(hp *proxy.HTTPProxy, cfg *config.Config) *proxy.HTTPProxy {
+	pcfg := hp.Config
+	pcfg.Strategy = cfg.Proxy.Strategy
+	pcfg.Matcher = cfg.Proxy.Matcher
+	pcfg.DialTimeout = cfg.Proxy.DialTimeout
+	pcfg.ResponseHeaderTimeout = cfg.Proxy.ResponseHeaderTimeout
+	pcfg.KeepAliveTimeout = cfg.Proxy.KeepAliveTimeout
+	pcfg.IdleConnTimeout = cfg.Proxy.IdleConnTimeout
+	pcfg.FlushInterval = cfg.Proxy.FlushInterval
+	pcfg.GlobalFlushInterval = cfg.Proxy.GlobalFlushInterval
+
+	var tlscfg *tls.Config
+	if tr, ok := hp.Transport.(*http.Transport); ok {
+		tlscfg = tr.TLSClientConfig
+	}
+	return &proxy.HTTPProxy{
+		Config:            pcfg,
+		Time:              hp.Time,
+		Transport:         newTransport(cfg, tlscfg),
+		InsecureTransport: newTransport(cfg, &tls.Config{InsecureSkipVerify: true}),
+		Lookup:            hp.Lookup,
+		Requests:          hp.Requests,
+		Noroute:           hp.Noroute,
+		Logger:            hp.Logger,
+		TracerCfg:         hp.TracerCfg,
+		UUID:              hp.UUID,
+		AuthSchemes:       hp.AuthSchemes,
+		Capture:           hp.Capture,
+		Cache:             hp.Cache,
+		Mirror:            hp.Mirror,
+		RateLimit:         hp.RateLimit,
+		ProxyProto:        hp.ProxyProto,
+		UserAgentMetrics:  hp.UserAgentMetrics,
 	}
 }
 
 func lookupHostFn(cfg *config.Config) func(string) *route.Target {
-	pick := route.Picker[cfg.Proxy.Strategy]
 	notFound := metrics.DefaultRegistry.GetCounter("notfound")
 	return func(host string) *route.Target {
+		pick := route.Picker[currentConfig().Proxy.Strategy]
 		t := route.GetTable().LookupHost(host, pick)
 		if t == nil {
 			notFound.Inc(1)
@@ -264,8 +548,8 @@ func lookupHostFn(cfg *config.Config) func(string) *route.Target {
 
 // Returns a matcher function compatible with tcpproxy Matcher from github.com/inetaf/tcpproxy
 func lookupHostMatcher(cfg *config.Config) func(context.Context, string) bool {
-	pick := route.Picker[cfg.Proxy.Strategy]
 	return func(ctx context.Context, host string) bool {
+		pick := route.Picker[currentConfig().Proxy.Strategy]
 		t := route.GetTable().LookupHost(host, pick)
 		if t == nil {
 			return false
@@ -284,7 +568,7 @@ func lookupHostMatcher(cfg *config.Config) func(context.Context, string) bool {
 	}
 }
 
-func makeTLSConfig(l config.Listen) (*tls.Config, error) {
+func makeTLSConfig(l config.Listen, p config.Proxy) (*tls.Config, error) {
 	if l.CertSource.Name == "" {
 		return nil, nil
 	}
@@ -292,7 +576,19 @@ func makeTLSConfig(l config.Listen) (*tls.Config, error) {
 	if err != nil {
 		return nil, fmt.Errorf("Failed to create cert source %s. %s", l.CertSource.Name, err)
 	}
-	tlscfg, err := cert.TLSConfig(src, l.StrictMatch, l.TLSMinVersion, l.TLSMaxVersion, l.TLSCiphers)
+	// listener options override the global defaults
+	match := cert.MatchOptions{
+		StrictMatch:    l.StrictMatch,
+		PreferWildcard: p.TLSCertPrefer == "wildcard",
+		NoSNI:          p.TLSNoSNI,
+	}
+	if l.CertPrefer != "" {
+		match.PreferWildcard = l.CertPrefer == "wildcard"
+	}
+	if l.NoSNI != "" {
+		match.NoSNI = l.NoSNI
+	}
+	tlscfg, err := cert.TLSConfig(src, match, l.TLSMinVersion, l.TLSMaxVersion, l.TLSCiphers)
 	if err != nil {
 		return nil, fmt.Errorf("[FATAL] Failed to create TLS config for cert source %s. %s", l.CertSource.Name, err)
 	}
@@ -304,33 +600,63 @@ func startAdmin(cfg *config.Config) {
 	log.Printf("[INFO] Admin server listening on %q", cfg.UI.Listen.Addr)
 	go func() {
 		l := cfg.UI.Listen
-		tlscfg, err := makeTLSConfig(l)
+		tlscfg, err := makeTLSConfig(l, cfg.Proxy)
 		if err != nil {
 			exit.Fatal("[FATAL] ", err)
 		}
-		srv := &admin.Server{
-			Access:   cfg.UI.Access,
-			Color:    cfg.UI.Color,
-			Title:    cfg.UI.Title,
-			Version:  version,
-			Commands: route.Commands,
-			Cfg:      cfg,
+		if cfg.UI.Metrics && cfg.Metrics.Target != "prometheus" {
+			log.Printf("[WARN] ui.metrics requires metrics.target = prometheus. /metrics will be empty")
 		}
-		if err := srv.ListenAndServe(l, tlscfg); err != nil {
+		if err := newAdminServer(cfg).ListenAndServe(l, tlscfg); err != nil {
 			exit.Fatal("[FATAL] ui: ", err)
 		}
 	}()
 }
 
+// newAdminServer returns the server for the admin UI and API.
+func newAdminServer(cfg *config.Config) *admin.Server {
+	srv := &admin.Server{
+		Access:   cfg.UI.Access,
+		Color:    cfg.UI.Color,
+		Title:    cfg.UI.Title,
+		Version:  version,
+		Commands: route.Commands,
+		Cfg:      cfg,
+		Cache:    openCache(cfg),
+	}
+	if cfg.UI.Metrics {
+		srv.Metrics = prometheusHandler()
+	}
+	return srv
+}
+
+// mountAdmin serves the admin API under the ui.mount prefix
+// on a proxy listener.
+func mountAdmin(cfg *config.Config, h http.Handler) http.Handler {
+	if cfg.UI.Mount == "" {
+		return h
+	}
+	return newAdminServer(cfg).Mount(cfg.UI.Mount, h)
+}
+
 func startServers(cfg *config.Config) {
 	for _, l := range cfg.Listen {
 		l := l // capture loop var for go routines below
-		tlscfg, err := makeTLSConfig(l)
+		tlscfg, err := makeTLSConfig(l, cfg.Proxy)
 		if err != nil {
 			exit.Fatal("[FATAL] ", err)
 		}
+		if tlscfg != nil {
+			_, port, _ := net.SplitHostPort(l.Addr)
+			m := &proxy.TLSMetrics{Prefix: "tls." + port, SNITopK: cfg.Metrics.TLSSNITopK}
+			tlscfg = m.TLSConfig(tlscfg)
+		}
 
-		log.Printf("[INFO] %s proxy listening on %s", strings.ToUpper(l.Proto), l.Addr)
+		if l.IPMode != "" {
+			log.Printf("[INFO] %s proxy listening on %s (ipmode=%s)", strings.ToUpper(l.Proto), l.Addr, l.IPMode)
+		} else {
+			log.Printf("[INFO] %s proxy listening on %s", strings.ToUpper(l.Proto), l.Addr)
+		}
 		if tlscfg != nil && tlscfg.ClientAuth == tls.RequireAndVerifyClientCert {
 			log.Printf("[INFO] Client certificate authentication enabled on %s", l.Addr)
 		}
@@ -338,7 +664,9 @@ func startServers(cfg *config.Config) {
 		switch l.Proto {
 		case "http", "https":
 			go func() {
-				h := newHTTPProxy(cfg)
+				hp := newHTTPProxy(cfg)
+				hp.ProxyProto = l.ProxyOut
+				h := cert.HTTPChallengeHandler(mountAdmin(cfg, httpProxies.add(hp)))
 				if err := proxy.ListenAndServeHTTP(l, h, tlscfg); err != nil {
 					exit.Fatal("[FATAL] ", err)
 				}
@@ -346,7 +674,14 @@ func startServers(cfg *config.Config) {
 		case "grpc", "grpcs":
 			go func() {
 				h := newGrpcProxy(cfg, tlscfg)
-				if err := proxy.ListenAndServeGRPC(l, h, tlscfg); err != nil {
+				var err error
+				if l.GRPCWeb {
+					g := proxy.GrpcProxyInterceptor{Config: cfg, GlobCache: route.NewGlobCache(cfg.GlobCacheSize), CurrentConfig: currentConfig}
+					err = proxy.ListenAndServeGRPCWeb(l, h, tlscfg, g.LookupHTTP)
+				} else {
+					err = proxy.ListenAndServeGRPC(l, h, tlscfg)
+				}
+				if err != nil {
 					exit.Fatal("[FATAL] ", err)
 				}
 			}()
@@ -358,6 +693,7 @@ func startServers(cfg *config.Config) {
 					Conn:        metrics.DefaultRegistry.GetCounter("tcp.conn"),
 					ConnFail:    metrics.DefaultRegistry.GetCounter("tcp.connfail"),
 					Noroute:     metrics.DefaultRegistry.GetCounter("tcp.noroute"),
+					ProxyProto:  l.ProxyOut,
 				}
 				if err := proxy.ListenAndServeTCP(l, h, tlscfg); err != nil {
 					exit.Fatal("[FATAL] ", err)
@@ -371,6 +707,7 @@ func startServers(cfg *config.Config) {
 					Conn:        metrics.DefaultRegistry.GetCounter("tcp_sni.conn"),
 					ConnFail:    metrics.DefaultRegistry.GetCounter("tcp_sni.connfail"),
 					Noroute:     metrics.DefaultRegistry.GetCounter("tcp_sni.noroute"),
+					ProxyProto:  l.ProxyOut,
 				}
 				if err := proxy.ListenAndServeTCP(l, h, tlscfg); err != nil {
 					exit.Fatal("[FATAL] ", err)
@@ -378,25 +715,10 @@ func startServers(cfg *config.Config) {
 			}()
 		case "tcp-dynamic":
 			go func() {
-				var buffer strings.Builder
 				lastPorts := []string{}
 				for {
 					time.Sleep(l.Refresh)
-					table := route.GetTable()
-					ports := []string{}
-					for target, rts := range table {
-						if strings.Contains(target, ":") {
-							buffer.WriteString(":")
-							buffer.WriteString(strings.Split(target, ":")[1])
-
-							schemes := tableSchemes(rts)
-							if len(schemes) == 1 && schemes[0] == "tcp" {
-								ports = append(ports, buffer.String())
-							}
-							buffer.Reset()
-						}
-						ports = unique(ports)
-					}
+					ports := dynamicPorts(route.GetTable())
 					for _, port := range difference(lastPorts, ports) {
 						log.Printf("[DEBUG] Dynamic TCP listener on %s eligable for termination", port)
 						proxy.CloseProxy(port)
@@ -404,7 +726,7 @@ func startServers(cfg *config.Config) {
 					for _, port := range ports {
 						l := l
 						port := port
-						conn, err := net.Listen("tcp", port)
+						conn, err := net.Listen(proxy.ListenNetwork("tcp", l.IPMode), port)
 						if err != nil {
 							log.Printf("[DEBUG] Dynamic TCP port %s in use", port)
 							continue
@@ -418,6 +740,7 @@ func startServers(cfg *config.Config) {
 								Conn:        metrics.DefaultRegistry.GetCounter("tcp.conn"),
 								ConnFail:    metrics.DefaultRegistry.GetCounter("tcp.connfail"),
 								Noroute:     metrics.DefaultRegistry.GetCounter("tcp.noroute"),
+								ProxyProto:  l.ProxyOut,
 							}
 							l.Addr = port
 							if err := proxy.ListenAndServeTCP(l, h, tlscfg); err != nil {
@@ -428,17 +751,36 @@ func startServers(cfg *config.Config) {
 					lastPorts = ports
 				}
 			}()
+		case "udp":
+			go func() {
+				_, port, _ := net.SplitHostPort(l.Addr)
+				name := "udp." + port
+				h := &udp.Proxy{
+					DialTimeout: cfg.Proxy.DialTimeout,
+					IdleTimeout: l.IdleTimeout,
+					Lookup:      lookupHostFn(cfg),
+					Session:     metrics.DefaultRegistry.GetCounter(name + ".session"),
+					Sessions:    metrics.DefaultRegistry.GetGauge(name + ".sessions"),
+					ConnFail:    metrics.DefaultRegistry.GetCounter(name + ".connfail"),
+					Noroute:     metrics.DefaultRegistry.GetCounter(name + ".noroute"),
+				}
+				if err := proxy.ListenAndServeUDP(l, h); err != nil {
+					exit.Fatal("[FATAL] ", err)
+				}
+			}()
 		case "https+tcp+sni":
 			go func() {
 				hp := newHTTPProxy(cfg)
+				hp.ProxyProto = l.ProxyOut
 				tp := &tcp.SNIProxy{
 					DialTimeout: cfg.Proxy.DialTimeout,
 					Lookup:      lookupHostFn(cfg),
 					Conn:        metrics.DefaultRegistry.GetCounter("tcp_sni.conn"),
 					ConnFail:    metrics.DefaultRegistry.GetCounter("tcp_sni.connfail"),
 					Noroute:     metrics.DefaultRegistry.GetCounter("tcp_sni.noroute"),
+					ProxyProto:  l.ProxyOut,
 				}
-				if err := proxy.ListenAndServeHTTPSTCPSNI(l, hp, tp, tlscfg, lookupHostMatcher(cfg)); err != nil {
+				if err := proxy.ListenAndServeHTTPSTCPSNI(l, mountAdmin(cfg, httpProxies.add(hp)), tp, tlscfg, lookupHostMatcher(cfg)); err != nil {
 					exit.Fatal("[FATAL] ", err)
 				}
 			}()
@@ -462,6 +804,8 @@ func initMetrics(cfg *config.Config) {
 			route.ServiceRegistry, err = metrics.NewRegistry(cfg.Metrics)
 		}
 		if err == nil {
+			route.MetricsGCGrace = cfg.Metrics.GCGrace
+			startPrometheus(cfg)
 			return
 		}
 		if time.Now().After(deadline) {
@@ -475,6 +819,38 @@ func initMetrics(cfg *config.Config) {
 	}
 }
 
+// startPrometheus serves the Prometheus scrape endpoint
+// on a separate listener if it is configured.
+func startPrometheus(cfg *config.Config) {
+	if cfg.Metrics.Target != "prometheus" {
+		return
+	}
+	addr := cfg.Metrics.Prometheus.Addr
+	if addr == "" {
+		if !cfg.UI.Metrics {
+			log.Printf("[WARN] Neither metrics.prometheus.addr nor ui.metrics are set. Metrics are not exposed")
+		}
+		return
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", prometheusHandler())
+	log.Printf("[INFO] Prometheus metrics listening on %q", addr)
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			exit.Fatal("[FATAL] ", err)
+		}
+	}()
+}
+
+// prometheusHandler returns the handler which serves the metrics
+// of all registries in the Prometheus exposition format.
+func prometheusHandler() http.Handler {
+	return metrics.PrometheusHandler(
+		func() metrics.Registry { return metrics.DefaultRegistry },
+		func() metrics.Registry { return route.ServiceRegistry },
+	)
+}
+
 func initRuntime(cfg *config.Config) {
 	if os.Getenv("GOGC") == "" {
 		log.Print("[INFO] Setting GOGC=", cfg.Runtime.GOGC)
@@ -491,9 +867,27 @@ func initRuntime(cfg *config.Config) {
 	}
 }
 
+// initHashPicker configures the key and the ring size of the hash strategy.
+func initHashPicker(cfg *config.Config) {
+	key, err := route.ParseHashKey(cfg.Proxy.HashKey)
+	if err != nil {
+		exit.Fatal("[FATAL] ", err)
+	}
+	route.HashKey = key
+	route.HashVirtualNodes = cfg.Proxy.HashVirtualNodes
+}
+
+// acmeHostPolicy allows on-demand ACME certificates only for hosts
+// which have a route or which belong to a validated alias.
+func acmeHostPolicy(host string) bool {
+	return route.GetTable().HasHost(host) || alias.Default.Validated(host)
+}
+
 func initBackend(cfg *config.Config) {
 	var deadline = time.Now().Add(cfg.Registry.Timeout)
 	var err error
+	alias.Default.Domain = cfg.Registry.Consul.AliasDomain
+	cert.ACMEHostPolicy = acmeHostPolicy
 	for {
 		switch cfg.Registry.Backend {
 		case "file":
@@ -504,12 +898,25 @@ func initBackend(cfg *config.Config) {
 			registry.Default, err = consul.NewBackend(&cfg.Registry.Consul)
 		case "custom":
 			registry.Default, err = custom.NewBackend(&cfg.Registry.Custom)
+		case "kubernetes":
+			registry.Default, err = kubernetes.NewBackend(&cfg.Registry.Kubernetes)
+		case "nomad":
+			registry.Default, err = nomad.NewBackend(&cfg.Registry.Nomad)
+		case "etcd":
+			registry.Default, err = etcd.NewBackend(&cfg.Registry.Etcd)
+		case "zookeeper":
+			registry.Default, err = zookeeper.NewBackend(&cfg.Registry.Zookeeper)
+		case "replay":
+			registry.Default, err = replay.NewBackend(&cfg.Registry.Replay)
 		default:
 			exit.Fatal("[FATAL] Unknown registry backend ", cfg.Registry.Backend)
 		}
 
 		if err == nil {
 			if err = registry.Default.Register(nil); err == nil {
+				if cfg.Registry.RecordPath != "" {
+					recordBackend(cfg.Registry.RecordPath)
+				}
 				return
 			}
 		}
@@ -526,13 +933,24 @@ func initBackend(cfg *config.Config) {
 	}
 }
 
+// recordBackend records all updates of the registry backend to path.
+func recordBackend(path string) {
+	rec, err := replay.NewRecorder(registry.Default, path)
+	if err != nil {
+		exit.Fatal("[FATAL] ", err)
+	}
+	log.Printf("[INFO] Recording registry updates to %s", path)
+	exit.Listen(func(os.Signal) { rec.Close() })
+	registry.Default = rec
+}
+
 func watchBackend(cfg *config.Config, first chan bool) {
 	var (
 		nextTable   string
 		lastTable   string
 		svccfg      string
 		mancfg      string
-		customBE    string
+		customBE    registry.Snapshot
 		once        sync.Once
 		tableBuffer = new(bytes.Buffer) // fix crash on reset before used (#650)
 	)
@@ -543,9 +961,9 @@ func watchBackend(cfg *config.Config, first chan bool) {
 	case "custom":
 		svc := registry.Default.WatchServices()
 		for {
-			customBE = <-svc
-			if customBE != "OK" {
-				log.Printf("[ERROR] error during update from custom back end - %s", customBE)
+			customBE = svc.Next(customBE.Seq)
+			if customBE.Value != "OK" {
+				log.Printf("[ERROR] error during update from custom back end - %s", customBE.Value)
 			}
 			once.Do(func() { close(first) })
 		}
@@ -553,12 +971,43 @@ func watchBackend(cfg *config.Config, first chan bool) {
 	default:
 		svc := registry.Default.WatchServices()
 		man := registry.Default.WatchManual()
+		roll := watchRollout(cfg)
+
+		// routes with the 'active' option are only part of the routing
+		// table within their time windows which have a resolution of
+		// one minute.
+		schedule := time.NewTicker(time.Minute)
+		defer schedule.Stop()
+
+		suppressed := metrics.DefaultRegistry.GetCounter("registry.debounce.suppressed")
 
 		for {
+			// updates which arrive while the table is built are
+			// coalesced and only the latest state is used.
+			scheduled := false
 			select {
-			case svccfg = <-svc:
-			case mancfg = <-man:
+			case <-svc.C():
+			case <-man.C():
+			case <-roll.C():
+			case <-schedule.C:
+				scheduled = true
+			}
+			// wait for a burst of registry updates to settle so that
+			// it results in one rebuild of the routing table.
+			if !scheduled && lastTable != "" && cfg.Registry.Debounce.Window > 0 {
+				if n := debounce(cfg.Registry.Debounce.Window, cfg.Registry.Debounce.Max, svc.C(), man.C(), roll.C()); n > 0 {
+					suppressed.Inc(int64(n))
+				}
 			}
+			// backends with consistent snapshots publish the services and
+			// the manual overrides with the same index. Wait for the
+			// other value if only one of them has been published yet.
+			svcsnap, mansnap := svc.Latest(), man.Latest()
+			if svcsnap.Index != 0 && mansnap.Index != 0 && svcsnap.Index != mansnap.Index {
+				continue
+			}
+			svccfg, mancfg = svcsnap.Value, mansnap.Value
+			rollcfg := roll.Latest().Value
 			// manual config overrides service config - order matters
 			tableBuffer.Reset()
 			tableBuffer.WriteString(svccfg)
@@ -566,31 +1015,170 @@ func watchBackend(cfg *config.Config, first chan bool) {
 			tableBuffer.WriteString(mancfg)
 			// set nextTable here to preserve the state.  The buffer is altered
 			// when calling route.NewTable and we lose change logging (#737)
-			if nextTable = tableBuffer.String(); nextTable == lastTable {
+			nextTable = tableBuffer.String() + "\n" + rollcfg
+			if nextTable == lastTable && !(scheduled && strings.Contains(nextTable, "active=")) {
 				continue
 			}
-			aliases, err := route.ParseAliases(nextTable)
-			if err != nil {
-				log.Printf("[WARN]: %s", err)
+			// keep the registered aliases if the table cannot be parsed
+			// since registering an empty list deregisters all of them.
+			if nextTable != lastTable {
+				aliases, err := route.ParseAliasDefs(nextTable)
+				if err != nil {
+					log.Printf("[WARN]: %s", err)
+				} else {
+					registry.Default.Register(alias.Default.Update(aliases))
+					alias.Default.Revalidate()
+				}
 			}
-			registry.Default.Register(aliases)
-			t, err := route.NewTable(tableBuffer)
+			t, err := route.NewTableSources(
+				route.ConfigSource{Name: cfg.Registry.Backend, Config: svccfg},
+				route.ConfigSource{Name: "manual", Config: mancfg},
+			)
 			if err != nil {
 				log.Printf("[WARN] %s", err)
 				continue
 			}
+			// the rollout weights override the manual config
+			if err := t.ApplyWeights(rollcfg); err != nil {
+				log.Printf("[WARN] %s", err)
+			}
+			if nextTable == lastTable {
+				if t.String() == route.GetTable().String() {
+					continue
+				}
+				log.Print("[INFO] Time window of a route started or ended")
+			}
 			route.SetTable(t)
-			logRoutes(t, lastTable, nextTable, cfg.Log.RoutesFormat)
+			logRoutes(t, lastTable, nextTable, cfg.Log.RoutesFormat, cfg.Log.RoutesMax)
 			lastTable = nextTable
 			once.Do(func() { close(first) })
 		}
 	}
 }
 
+// debounce waits until no update has arrived on the channels for the
+// window or until max has passed and returns the number of updates
+// which arrived in the meantime.
+func debounce(window, max time.Duration, svc, man, roll <-chan struct{}) int {
+	deadline := time.NewTimer(max)
+	defer deadline.Stop()
+
+	n := 0
+	for {
+		quiet := time.NewTimer(window)
+		select {
+		case <-svc:
+		case <-man:
+		case <-roll:
+		case <-quiet.C:
+			return n
+		case <-deadline.C:
+			quiet.Stop()
+			return n
+		}
+		quiet.Stop()
+		n++
+	}
+}
+
+// watchRollout starts the rollout controller if the backend provides
+// rollout definitions and returns the watch for the generated route
+// weight commands.
+func watchRollout(cfg *config.Config) *registry.Watch {
+	out := registry.NewWatch()
+	rw, ok := registry.Default.(registry.RolloutWatcher)
+	if !ok {
+		return out
+	}
+	defs := rw.WatchRollout()
+	if defs == nil {
+		return out
+	}
+	c := &rollout.Controller{
+		Duration: cfg.Registry.Consul.RolloutDuration,
+		Step:     cfg.Registry.Consul.RolloutStep,
+		Current: func(d rollout.Def) float64 {
+			return route.GetTable().FixedWeight(d.Service, d.Src, d.Tags)
+		},
+	}
+	go c.Run(defs, out)
+	return out
+}
+
+// watchFlags applies the feature flags if the backend provides them.
+func watchFlags(cfg *config.Config) {
+	fw, ok := registry.Default.(registry.FlagWatcher)
+	if !ok {
+		return
+	}
+	w := fw.WatchFlags()
+	if w == nil {
+		return
+	}
+	ks := cfg.Proxy.KillSwitch
+	c := &flags.Controller{
+		Drain: func() {
+			proxy.Drain(cfg.Proxy.DrainGrace, cfg.Proxy.ShutdownWait, func() { registry.Default.DeregisterAll() })
+		},
+		SetKillSwitch: func(k flags.KillSwitch) {
+			status := k.Status
+			if status == 0 {
+				status = ks.Status
+			}
+			proxy.SetKillSwitch(proxy.KillSwitch{
+				Service:     k.Service,
+				Status:      status,
+				ContentType: ks.ContentType,
+				Body:        ks.Body,
+				Reason:      "feature flag",
+				Since:       time.Now().UTC().Truncate(time.Second),
+				Expires:     k.Until,
+			})
+		},
+		ClearKillSwitch: func(service string) { proxy.ClearKillSwitch(service) },
+		SetFault: func(f flags.Fault) {
+			proxy.SetFault(proxy.Fault{Service: f.Service, Delay: f.Delay, Abort: f.Abort, Percent: f.Percent})
+		},
+		ClearFault:  proxy.ClearFault,
+		SetMirror:   proxy.SetMirrorPercent,
+		ClearMirror: proxy.ClearMirrorPercent,
+	}
+	go c.Run(w)
+}
+
+// initNoRoute loads the noroute pages per language and the JSON body
+// and sets up the log and the statistics of requests without a route.
+func initNoRoute(cfg *config.Config) {
+	noroute.SetJSON(cfg.Proxy.NoRouteJSON)
+
+	var w io.Writer
+	switch cfg.Log.NoRoute.Target {
+	case "stdout":
+		w = os.Stdout
+	case "stderr":
+		w = os.Stderr
+	}
+	if w != nil {
+		log.Printf("[INFO] Logging %g of the requests without a route to %s", cfg.Log.NoRoute.Sample, cfg.Log.NoRoute.Target)
+	}
+	noroute.SetTracker(noroute.NewTracker(cfg.Log.NoRoute.TopK, w, cfg.Log.NoRoute.Sample))
+	if cfg.Proxy.NoRouteHTMLDir == "" {
+		return
+	}
+	pages, err := noroute.LoadPages(cfg.Proxy.NoRouteHTMLDir)
+	if err != nil {
+		exit.Fatal("[FATAL] ", err)
+	}
+	noroute.SetPages(pages)
+	log.Printf("[INFO] Loaded %d noroute pages from %s", len(pages), cfg.Proxy.NoRouteHTMLDir)
+}
+
 func watchNoRouteHTML(cfg *config.Config) {
 	html := registry.Default.WatchNoRouteHTML()
+	var s registry.Snapshot
 	for {
-		next := <-html
+		s = html.Next(s.Seq)
+		next := s.Value
 		if next == noroute.GetHTML() {
 			continue
 		}
@@ -603,24 +1191,13 @@ func watchNoRouteHTML(cfg *config.Config) {
 	}
 }
 
-func logRoutes(t route.Table, last, next, format string) {
-	fmtDiff := func(diffs []dmp.Diff) string {
-		var b bytes.Buffer
-		for _, d := range diffs {
-			t := strings.TrimSpace(d.Text)
-			if t == "" {
-				continue
-			}
-			switch d.Type {
-			case dmp.DiffDelete:
-				b.WriteString("- ")
-				b.WriteString(strings.Replace(t, "\n", "\n- ", -1))
-			case dmp.DiffInsert:
-				b.WriteString("+ ")
-				b.WriteString(strings.Replace(t, "\n", "\n+ ", -1))
-			}
-		}
-		return b.String()
+// logRoutes logs the updated routing table in the given format. For
+// tables with more than max routes only the number of routes is logged
+// since dumping or diffing them is expensive.
+func logRoutes(t route.Table, last, next, format string, max int) {
+	if n := t.Len(); max > 0 && n > max {
+		log.Printf("[INFO] Updated config to %d routes. Not logging tables with more than %d routes", n, max)
+		return
 	}
 
 	const defFormat = "delta"
@@ -629,7 +1206,7 @@ func logRoutes(t route.Table, last, next, format string) {
 		log.Printf("[INFO] Updated config to\n%s", t.Dump())
 
 	case "delta":
-		if delta := fmtDiff(dmp.New().DiffMain(last, next, true)); delta != "" {
+		if delta := diffRoutes(last, next); delta != "" {
 			log.Printf("[INFO] Config updates\n%s", delta)
 		}
 
@@ -638,7 +1215,143 @@ func logRoutes(t route.Table, last, next, format string) {
 
 	default:
 		log.Printf("[WARN] Invalid route format %q. Defaulting to %q", format, defFormat)
-		logRoutes(t, last, next, defFormat)
+		logRoutes(t, last, next, defFormat, max)
+	}
+}
+
+// diffRoutes returns the lines which were removed from the last and
+// added to the next routing table prefixed with "- " and "+ ". The
+// lines are compared as a set of route commands so that the cost is
+// linear in the size of the tables and moving a route within the
+// table is not reported as a change.
+func diffRoutes(last, next string) string {
+	lines := func(s string) []string {
+		var l []string
+		for _, line := range strings.Split(s, "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				l = append(l, line)
+			}
+		}
+		return l
+	}
+
+	lastLines, nextLines := lines(last), lines(next)
+	count := make(map[string]int, len(nextLines))
+	for _, line := range nextLines {
+		count[line]++
+	}
+
+	var b strings.Builder
+	for _, line := range lastLines {
+		if count[line] > 0 {
+			count[line]--
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString("- " + line)
+	}
+
+	// count contains the lines of next which are not in last
+	for _, line := range nextLines {
+		if count[line] == 0 {
+			continue
+		}
+		count[line]--
+		if b.Len() > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString("+ " + line)
+	}
+	return b.String()
+}
+
+// initSnapshots writes the configuration and the state of fabio
+// into an archive every snapshot interval.
+func initSnapshots(cfg *config.Config) {
+	if cfg.Snapshot.Target == "" {
+		return
+	}
+	store, err := snapshot.NewStore(cfg.Snapshot.Target, cfg.Snapshot.Keep)
+	if err != nil {
+		exit.Fatalf("[FATAL] %s. %s", version, err)
+	}
+	log.Printf("[INFO] Writing snapshots to %s every %s", store, cfg.Snapshot.Interval)
+	go snapshot.Run(store, cfg.Snapshot.Interval, func() *snapshot.Snapshot {
+		return snapshot.New(version, currentConfig())
+	})
+}
+
+// initHA competes for the lock of the active-standby mode after the
+// listeners have been created so that the promote hook can move the
+// virtual IP to a running instance. The lock is released on shutdown.
+func initHA(cfg *config.Config) {
+	if cfg.HA.Mode == "" {
+		return
+	}
+	lock, err := consul.NewHALock(&cfg.Registry.Consul, cfg.HA.LockKey, cfg.HA.LockTTL)
+	if err != nil {
+		exit.Fatalf("[FATAL] %s. %s", version, err)
+	}
+	log.Printf("[INFO] Active-standby mode with lock %s in consul", cfg.HA.LockKey)
+	go ha.Run(lock, ha.Config{
+		Promote:     cfg.HA.Promote,
+		Demote:      cfg.HA.Demote,
+		HookTimeout: cfg.HA.HookTimeout,
+		Retry:       cfg.HA.LockTTL,
+	})
+	exit.Listen(func(os.Signal) { ha.Release(cfg.HA.HookTimeout + time.Second) })
+}
+
+// initRoutesWebhook sends the changes of the routing table
+// to the configured webhook.
+func initRoutesWebhook(cfg *config.Config) {
+	if cfg.Log.RoutesHook == "" {
+		return
+	}
+	log.Printf("[INFO] Sending routing table updates to %s", cfg.Log.RoutesHook)
+	// the events which are added before the subscription
+	// are sent from the event log
+	last := route.Events.Seq()
+	events, _ := route.Events.Subscribe()
+	go postRouteEvents(cfg.Log.RoutesHook, last, events)
+}
+
+// postRouteEvents sends the events to the webhook. Events which were
+// dropped since the webhook could not keep up are sent from the event
+// log before the next one.
+func postRouteEvents(url string, last uint64, events <-chan route.TableEvent) {
+	client := &http.Client{Timeout: 5 * time.Second}
+	for next := range events {
+		if next.Seq <= last {
+			continue
+		}
+		evs, missed := route.Events.Fill(last, next)
+		if missed > 0 {
+			log.Printf("[WARN] Webhook missed %d routing table events between event %d and %d", missed, last, next.Seq)
+		}
+		last = next.Seq
+		for _, ev := range evs {
+			postRouteEvent(client, url, ev)
+		}
+	}
+}
+
+func postRouteEvent(client *http.Client, url string, ev route.TableEvent) {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		log.Printf("[ERROR] Cannot encode routing table event %d. %s", ev.Seq, err)
+		return
+	}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		log.Printf("[WARN] Cannot send routing table event %d. %s", ev.Seq, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Printf("[WARN] Webhook returned %s for routing table event %d", resp.Status, ev.Seq)
 	}
 }
 
@@ -662,6 +1375,24 @@ func unique(strSlice []string) []string {
 	return list
 }
 
+// dynamicPorts returns the ports of the routes in the table which
+// only have tcp targets in the form ':port'. The host of the route
+// is ignored since the dynamic listeners bind to all addresses.
+func dynamicPorts(t route.Table) []string {
+	ports := []string{}
+	for target, rts := range t {
+		_, port, err := net.SplitHostPort(target)
+		if err != nil || port == "" {
+			continue
+		}
+		schemes := tableSchemes(rts)
+		if len(schemes) == 1 && schemes[0] == "tcp" {
+			ports = append(ports, ":"+port)
+		}
+	}
+	return unique(ports)
+}
+
 // difference returns elements in `a` that aren't in `b`
 func difference(a, b []string) []string {
 	mb := make(map[string]struct{}, len(b))
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/fabiolb/fabio/config"
+	"github.com/fabiolb/fabio/route"
+)
+
+func TestDiffRoutes(t *testing.T) {
+	tests := []struct {
+		desc       string
+		last, next string
+		want       string
+	}{
+		{"no change", "route add a / http://1.2.3.4/", "route add a / http://1.2.3.4/", ""},
+		{"added", "route add a / http://1.2.3.4/", "route add a / http://1.2.3.4/\nroute add b /b http://1.2.3.5/", "+ route add b /b http://1.2.3.5/"},
+		{"removed", "route add a / http://1.2.3.4/\nroute add b /b http://1.2.3.5/", "route add b /b http://1.2.3.5/", "- route add a / http://1.2.3.4/"},
+		{"changed", "route add a / http://1.2.3.4/\nroute add b /b http://1.2.3.5/", "route add a / http://1.2.3.4/ weight 0.5\nroute add b /b http://1.2.3.5/", "- route add a / http://1.2.3.4/\n+ route add a / http://1.2.3.4/ weight 0.5"},
+		{"moved", "route add a / http://1.2.3.4/\nroute add b /b http://1.2.3.5/", "route add b /b http://1.2.3.5/\nroute add a / http://1.2.3.4/", ""},
+		{"whitespace and empty lines", "  route add a / http://1.2.3.4/\n\n", "\nroute add a / http://1.2.3.4/  ", ""},
+		{"duplicate", "route add a / http://1.2.3.4/", "route add a / http://1.2.3.4/\nroute add a / http://1.2.3.4/", "+ route add a / http://1.2.3.4/"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			if got, want := diffRoutes(tt.last, tt.next), tt.want; got != want {
+				t.Fatalf("got\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestDynamicPorts(t *testing.T) {
+	tbl, err := route.NewTable(bytes.NewBufferString(`
+		route add a :1234 tcp://1.2.3.4:5000
+		route add b 10.0.0.1:1234 tcp://1.2.3.4:5001
+		route add c [::1]:2345 tcp://[2001:db8::1]:5002
+		route add d [::]:3456 tcp://1.2.3.4:5003
+		route add e example.com/ http://1.2.3.4:5004/
+		route add f example.com:4567/ http://1.2.3.4:5005/
+	`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := dynamicPorts(tbl)
+	sort.Strings(got)
+	if want := []string{":1234", ":2345", ":3456"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+}
+
+func TestDebounce(t *testing.T) {
+	svc, man, roll := make(chan struct{}, 1), make(chan struct{}), make(chan struct{})
+
+	t.Run("quiet", func(t *testing.T) {
+		if got, want := debounce(10*time.Millisecond, time.Second, svc, man, roll), 0; got != want {
+			t.Fatalf("got %d want %d", got, want)
+		}
+	})
+
+	t.Run("burst", func(t *testing.T) {
+		done := make(chan int)
+		go func() { done <- debounce(50*time.Millisecond, 5*time.Second, svc, man, roll) }()
+		for i := 0; i < 3; i++ {
+			svc <- struct{}{}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if got, want := <-done, 3; got != want {
+			t.Fatalf("got %d want %d", got, want)
+		}
+	})
+
+	t.Run("max", func(t *testing.T) {
+		stop := make(chan bool)
+		defer close(stop)
+		go func() {
+			for {
+				select {
+				case svc <- struct{}{}:
+				case <-stop:
+					return
+				}
+				time.Sleep(5 * time.Millisecond)
+			}
+		}()
+		start := time.Now()
+		if debounce(50*time.Millisecond, 200*time.Millisecond, svc, man, roll) == 0 {
+			t.Fatal("no updates")
+		}
+		if d := time.Since(start); d > time.Second {
+			t.Fatalf("debounce took %s", d)
+		}
+	})
+}
+
+func TestMergeConfig(t *testing.T) {
+	cur := &config.Config{}
+	cur.Log.Level = "INFO"
+	cur.Proxy.Strategy = "rnd"
+	cur.Proxy.MaxConn = 100
+
+	next := &config.Config{}
+	next.Log.Level = "DEBUG"
+	next.Proxy.Strategy = "rnd"
+	next.Proxy.MaxConn = 200
+	next.Proxy.DialTimeout = time.Second
+	next.Metrics.Interval = time.Minute
+
+	cfg, changed, restart := mergeConfig(cur, next)
+	if got, want := changed, []string{"log.level", "proxy.dialtimeout", "metrics.interval"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got changed %v want %v", got, want)
+	}
+	if got, want := restart, []string{"Proxy.MaxConn"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got restart %v want %v", got, want)
+	}
+	if got, want := cfg.Log.Level, "DEBUG"; got != want {
+		t.Fatalf("got log level %q want %q", got, want)
+	}
+	if got, want := cfg.Proxy.MaxConn, 100; got != want {
+		t.Fatalf("got max conn %d want %d", got, want)
+	}
+	if got, want := cur.Log.Level, "INFO"; got != want {
+		t.Fatalf("running config was modified")
+	}
+}
+
+func TestRestartProtos(t *testing.T) {
+	cfg := &config.Config{
+		Listen: []config.Listen{{Proto: "http"}, {Proto: "tcp"}, {Proto: "udp"}, {Proto: "tcp"}},
+	}
+	if got, want := restartProtos(cfg, "proxy.dialtimeout"), []string{"tcp", "udp"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+	if got := restartProtos(cfg, "proxy.strategy"); got != nil {
+		t.Fatalf("got %v want nil", got)
+	}
+}
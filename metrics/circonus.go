@@ -20,7 +20,7 @@ var (
 const serviceName = "fabio"
 
 // circonusRegistry returns a provider that reports to Circonus.
-func circonusRegistry(prefix string, circ config.Circonus, interval time.Duration) (Registry, error) {
+func circonusRegistry(prefix string, circ config.Circonus) (Registry, error) {
 	var initError error
 
 	once.Do(func() {
@@ -47,7 +47,8 @@ func circonusRegistry(prefix string, circ config.Circonus, interval time.Duratio
 		cfg.CheckManager.API.URL = circ.APIURL
 		cfg.CheckManager.Check.ID = circ.CheckID
 		cfg.CheckManager.Broker.ID = circ.BrokerID
-		cfg.Interval = fmt.Sprintf("%.0fs", interval.Seconds())
+		// the metrics are flushed by flushEvery
+		cfg.Interval = "0"
 		cfg.CheckManager.Check.InstanceID = host
 		cfg.CheckManager.Check.DisplayName = fmt.Sprintf("%s /%s", host, serviceName)
 		cfg.CheckManager.Check.SearchTag = fmt.Sprintf("service:%s", serviceName)
@@ -60,7 +61,7 @@ func circonusRegistry(prefix string, circ config.Circonus, interval time.Duratio
 
 		circonus = &cgmRegistry{metrics, prefix}
 
-		metrics.Start()
+		go flushEvery(func(time.Duration) { metrics.Flush() })
 
 		log.Print("[INFO] Sending metrics to Circonus")
 	})
@@ -96,6 +97,12 @@ func (m *cgmRegistry) GetTimer(name string) Timer {
 	return &cgmTimer{m.metrics, metricName}
 }
 
+// GetGauge returns a gauge for the given metric name.
+func (m *cgmRegistry) GetGauge(name string) Gauge {
+	metricName := fmt.Sprintf("%s`%s", m.prefix, name)
+	return &cgmGauge{m.metrics, metricName}
+}
+
 type cgmCounter struct {
 	metrics *cgm.CirconusMetrics
 	name    string
@@ -106,6 +113,16 @@ func (c *cgmCounter) Inc(n int64) {
 	c.metrics.IncrementByValue(c.name, uint64(n))
 }
 
+type cgmGauge struct {
+	metrics *cgm.CirconusMetrics
+	name    string
+}
+
+// Update sets the gauge to n.
+func (g *cgmGauge) Update(n int64) {
+	g.metrics.Gauge(g.name, n)
+}
+
 type cgmTimer struct {
 	metrics *cgm.CirconusMetrics
 	name    string
@@ -73,7 +73,8 @@ func TestAll(t *testing.T) {
 		t.Fatalf("Unable to parse interval %+v", err)
 	}
 
-	circ, err := circonusRegistry("test", cfg, interval)
+	SetFlushInterval(interval)
+	circ, err := circonusRegistry("test", cfg)
 	if err != nil {
 		t.Fatalf("Unable to initialize Circonus +%v", err)
 	}
@@ -0,0 +1,57 @@
+package metrics
+
+import (
+	"sync"
+	"time"
+)
+
+// flushInterval contains the interval in which the go-metrics
+// and Circonus registries report their metrics.
+var flushInterval = struct {
+	sync.Mutex
+	d time.Duration
+
+	// changed is closed when the interval changes.
+	changed chan struct{}
+}{changed: make(chan struct{})}
+
+// SetFlushInterval changes the interval in which the metrics are
+// reported. The running reporters are re-armed with the new interval.
+func SetFlushInterval(d time.Duration) {
+	flushInterval.Lock()
+	defer flushInterval.Unlock()
+	if d == flushInterval.d {
+		return
+	}
+	flushInterval.d = d
+	close(flushInterval.changed)
+	flushInterval.changed = make(chan struct{})
+}
+
+func currentFlushInterval() (time.Duration, chan struct{}) {
+	flushInterval.Lock()
+	defer flushInterval.Unlock()
+	return flushInterval.d, flushInterval.changed
+}
+
+// flushEvery calls flush with the current flush interval whenever
+// the interval has expired. It never returns.
+func flushEvery(flush func(interval time.Duration)) {
+	for {
+		d, changed := currentFlushInterval()
+		if d <= 0 {
+			<-changed
+			continue
+		}
+		t := time.NewTicker(d)
+		for rearm := false; !rearm; {
+			select {
+			case <-t.C:
+				flush(d)
+			case <-changed:
+				rearm = true
+			}
+		}
+		t.Stop()
+	}
+}
@@ -0,0 +1,38 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFlushEvery(t *testing.T) {
+	defer SetFlushInterval(0)
+	SetFlushInterval(10 * time.Millisecond)
+
+	ch := make(chan time.Duration, 1)
+	go flushEvery(func(d time.Duration) {
+		select {
+		case ch <- d:
+		default:
+		}
+	})
+
+	wait := func(want time.Duration) {
+		t.Helper()
+		timeout := time.After(time.Second)
+		for {
+			select {
+			case got := <-ch:
+				if got == want {
+					return
+				}
+			case <-timeout:
+				t.Fatalf("no flush with interval %s", want)
+			}
+		}
+	}
+
+	wait(10 * time.Millisecond)
+	SetFlushInterval(20 * time.Millisecond)
+	wait(20 * time.Millisecond)
+}
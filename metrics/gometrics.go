@@ -10,20 +10,21 @@ import (
 	"time"
 
 	graphite "github.com/cyberdelia/go-metrics-graphite"
-	statsd "github.com/pubnub/go-metrics-statsd"
 	gm "github.com/rcrowley/go-metrics"
 )
 
 // gmStdoutRegistry returns a go-metrics registry that reports to stdout.
-func gmStdoutRegistry(interval time.Duration) (Registry, error) {
+func gmStdoutRegistry() (Registry, error) {
 	logger := log.New(os.Stderr, "localhost: ", log.Lmicroseconds)
 	r := gm.NewRegistry()
-	go gm.Log(r, interval, logger)
+	cue := make(chan interface{})
+	go gm.LogOnCue(r, cue, logger)
+	go flushEvery(func(time.Duration) { cue <- struct{}{} })
 	return &gmRegistry{r}, nil
 }
 
 // gmGraphiteRegistry returns a go-metrics registry that reports to a Graphite server.
-func gmGraphiteRegistry(prefix, addr string, interval time.Duration) (Registry, error) {
+func gmGraphiteRegistry(prefix, addr string) (Registry, error) {
 	if addr == "" {
 		return nil, errors.New(" graphite addr missing")
 	}
@@ -34,12 +35,24 @@ func gmGraphiteRegistry(prefix, addr string, interval time.Duration) (Registry,
 	}
 
 	r := gm.NewRegistry()
-	go graphite.Graphite(r, interval, prefix, a)
+	go flushEvery(func(interval time.Duration) {
+		err := graphite.Once(graphite.Config{
+			Addr:          a,
+			Registry:      r,
+			FlushInterval: interval,
+			DurationUnit:  time.Nanosecond,
+			Prefix:        prefix,
+			Percentiles:   percentiles,
+		})
+		if err != nil {
+			log.Printf("[WARN] metrics: Cannot send metrics to Graphite. %s", err)
+		}
+	})
 	return &gmRegistry{r}, nil
 }
 
 // gmStatsDRegistry returns a go-metrics registry that reports to a StatsD server.
-func gmStatsDRegistry(prefix, addr string, interval time.Duration) (Registry, error) {
+func gmStatsDRegistry(prefix, addr string) (Registry, error) {
 	if addr == "" {
 		return nil, errors.New(" statsd addr missing")
 	}
@@ -50,7 +63,11 @@ func gmStatsDRegistry(prefix, addr string, interval time.Duration) (Registry, er
 	}
 
 	r := gm.NewRegistry()
-	go statsd.StatsD(r, interval, prefix, a)
+	go flushEvery(func(time.Duration) {
+		if err := statsdOnce(r, prefix, a); err != nil {
+			log.Printf("[WARN] metrics: Cannot send metrics to StatsD. %s", err)
+		}
+	})
 	return &gmRegistry{r}, nil
 }
 
@@ -83,3 +100,7 @@ func (p *gmRegistry) GetCounter(name string) Counter {
 func (p *gmRegistry) GetTimer(name string) Timer {
 	return gm.GetOrRegisterTimer(name, p.r)
 }
+
+func (p *gmRegistry) GetGauge(name string) Gauge {
+	return gm.GetOrRegisterGauge(name, p.r)
+}
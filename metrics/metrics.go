@@ -53,21 +53,27 @@ func NewRegistry(cfg config.Metrics) (r Registry, err error) {
 		return nil, fmt.Errorf("metrics: invalid names template. %s", err)
 	}
 
+	SetFlushInterval(cfg.Interval)
+
 	switch cfg.Target {
 	case "stdout":
 		log.Printf("[INFO] Sending metrics to stdout")
-		return gmStdoutRegistry(cfg.Interval)
+		return gmStdoutRegistry()
 
 	case "graphite":
 		log.Printf("[INFO] Sending metrics to Graphite on %s as %q", cfg.GraphiteAddr, prefix)
-		return gmGraphiteRegistry(prefix, cfg.GraphiteAddr, cfg.Interval)
+		return gmGraphiteRegistry(prefix, cfg.GraphiteAddr)
 
 	case "statsd":
 		log.Printf("[INFO] Sending metrics to StatsD on %s as %q", cfg.StatsDAddr, prefix)
-		return gmStatsDRegistry(prefix, cfg.StatsDAddr, cfg.Interval)
+		return gmStatsDRegistry(prefix, cfg.StatsDAddr)
 
 	case "circonus":
-		return circonusRegistry(prefix, cfg.Circonus, cfg.Interval)
+		return circonusRegistry(prefix, cfg.Circonus)
+
+	case "prometheus":
+		log.Printf("[INFO] Exposing metrics for Prometheus in namespace %q", cfg.Prometheus.Namespace)
+		return prometheusRegistry(cfg.Prometheus)
 
 	default:
 		exit.Fatal("[FATAL] Invalid metrics target ", cfg.Target)
@@ -115,14 +121,16 @@ func parseNames(tmpl string) (*template.Template, error) {
 	if err != nil {
 		return nil, err
 	}
-	if _, err := TargetName("testservice", "test.example.com", "/test", testURL); err != nil {
+	if _, err := TargetName("testservice", "test.example.com", "/test", testURL, nil); err != nil {
 		return nil, err
 	}
 	return t, nil
 }
 
 // TargetName returns the metrics name from the given parameters.
-func TargetName(service, host, path string, targetURL *url.URL) (string, error) {
+// The annotations of the target are available in the template
+// as .Annotations, e.g. '{{clean (index .Annotations "team")}}'.
+func TargetName(service, host, path string, targetURL *url.URL, annotations map[string]string) (string, error) {
 	if names == nil {
 		return "", nil
 	}
@@ -132,7 +140,8 @@ func TargetName(service, host, path string, targetURL *url.URL) (string, error)
 	data := struct {
 		Service, Host, Path string
 		TargetURL           *url.URL
-	}{service, host, path, targetURL}
+		Annotations         map[string]string
+	}{service, host, path, targetURL, annotations}
 
 	if err := names.Execute(&name, data); err != nil {
 		return "", err
@@ -150,6 +159,8 @@ func clean(s string) string {
 	}
 	s = strings.Replace(s, ".", "_", -1)
 	s = strings.Replace(s, ":", "_", -1)
+	s = strings.Replace(s, "[", "", -1)
+	s = strings.Replace(s, "]", "", -1)
 	return strings.ToLower(s)
 }
 
@@ -4,6 +4,7 @@ import (
 	"net/url"
 	"os"
 	"testing"
+	"text/template"
 )
 
 func TestParsePrefix(t *testing.T) {
@@ -39,6 +40,7 @@ func TestTargetName(t *testing.T) {
 		{"", "", "", "http://foo.com/bar", "_._._.foo_com"},
 		{"", "", "", "http://foo.com:1234/bar", "_._._.foo_com_1234"},
 		{"", "", "", "http://1.2.3.4:1234/bar", "_._._.1_2_3_4_1234"},
+		{"", "", "", "http://[2001:db8::1]:1234/bar", "_._._.2001_db8__1_1234"},
 	}
 
 	for i, tt := range tests {
@@ -47,7 +49,34 @@ func TestTargetName(t *testing.T) {
 			t.Fatalf("%d: %v", i, err)
 		}
 
-		got, err := TargetName(tt.service, tt.host, tt.path, u)
+		got, err := TargetName(tt.service, tt.host, tt.path, u, nil)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if want := tt.name; got != want {
+			t.Errorf("%d: got %q want %q", i, got, want)
+		}
+	}
+}
+
+func TestTargetNameAnnotations(t *testing.T) {
+	defer func(t *template.Template) { names = t }(names)
+	var err error
+	if names, err = parseNames(`{{clean (index .Annotations "team")}}.{{clean .Service}}`); err != nil {
+		t.Fatal(err)
+	}
+
+	u, _ := url.Parse("http://foo.com/bar")
+	tests := []struct {
+		annotations map[string]string
+		name        string
+	}{
+		{map[string]string{"team": "pay.ments"}, "pay_ments.s"},
+		{map[string]string{"tier": "gold"}, "_.s"},
+		{nil, "_.s"},
+	}
+	for i, tt := range tests {
+		got, err := TargetName("s", "h", "p", u, tt.annotations)
 		if err != nil {
 			t.Fatalf("%d: %v", i, err)
 		}
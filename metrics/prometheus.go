@@ -0,0 +1,402 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fabiolb/fabio/config"
+	gm "github.com/rcrowley/go-metrics"
+)
+
+// quantiles are reported for timers which are exposed as summaries.
+var quantiles = []float64{0.5, 0.9, 0.99}
+
+// promRegistry implements the Registry interface and exposes
+// the metrics in the Prometheus text exposition format.
+type promRegistry struct {
+	namespace string
+	labels    string
+	buckets   []float64
+
+	// constLabels contains the names of the labels
+	// which are added to all metrics.
+	constLabels map[string]bool
+
+	mu      sync.Mutex
+	metrics map[string]promMetric
+
+	// extra contains the formatted labels of
+	// the metrics which have their own labels.
+	extra map[string]string
+}
+
+// promMetric is a metric which can write itself in the
+// Prometheus text exposition format.
+type promMetric interface {
+	write(w io.Writer, name, labels string)
+}
+
+func prometheusRegistry(cfg config.Prometheus) (Registry, error) {
+	if cfg.Namespace != "" && !reMetricName.MatchString(cfg.Namespace) {
+		return nil, fmt.Errorf(" invalid prometheus namespace %q", cfg.Namespace)
+	}
+
+	var names []string
+	for k := range cfg.Labels {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var labels []string
+	constLabels := map[string]bool{}
+	for _, k := range names {
+		labels = append(labels, k+"="+quoteLabel(cfg.Labels[k]))
+		constLabels[k] = true
+	}
+
+	return &promRegistry{
+		namespace:   cfg.Namespace,
+		labels:      strings.Join(labels, ","),
+		buckets:     cfg.Buckets,
+		constLabels: constLabels,
+		metrics:     map[string]promMetric{},
+		extra:       map[string]string{},
+	}, nil
+}
+
+func (p *promRegistry) Names() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	names := make([]string, 0, len(p.metrics))
+	for name := range p.metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (p *promRegistry) Unregister(name string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if t, ok := p.metrics[name].(*promTimer); ok {
+		t.timer.Stop()
+	}
+	delete(p.metrics, name)
+	delete(p.extra, name)
+}
+
+func (p *promRegistry) UnregisterAll() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, m := range p.metrics {
+		if t, ok := m.(*promTimer); ok {
+			t.timer.Stop()
+		}
+	}
+	p.metrics = map[string]promMetric{}
+	p.extra = map[string]string{}
+}
+
+func (p *promRegistry) GetCounter(name string) Counter {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if c, ok := p.metrics[name].(*promCounter); ok {
+		return c
+	}
+	c := &promCounter{}
+	p.register(name, c)
+	return c
+}
+
+func (p *promRegistry) GetGauge(name string) Gauge {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if g, ok := p.metrics[name].(*promGauge); ok {
+		return g
+	}
+	g := &promGauge{}
+	p.register(name, g)
+	return g
+}
+
+func (p *promRegistry) GetTimer(name string) Timer {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.getTimer(name)
+}
+
+// GetTimerWithLabels returns the timer for the given name which is
+// reported with the additional labels. Label names are sanitized and
+// labels which collide with the constant labels are dropped. The
+// labels replace the ones of an earlier call for the same name.
+func (p *promRegistry) GetTimerWithLabels(name string, labels map[string]string) Timer {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	t := p.getTimer(name)
+
+	var keys []string
+	vals := map[string]string{}
+	for k, v := range labels {
+		k = promLabelName(k)
+		if p.constLabels[k] {
+			continue
+		}
+		if _, ok := vals[k]; !ok {
+			keys = append(keys, k)
+		}
+		vals[k] = v
+	}
+	sort.Strings(keys)
+	var extra []string
+	for _, k := range keys {
+		extra = append(extra, k+"="+quoteLabel(vals[k]))
+	}
+	if len(extra) > 0 {
+		p.extra[name] = strings.Join(extra, ",")
+	} else {
+		delete(p.extra, name)
+	}
+	return t
+}
+
+// getTimer returns the timer for the given name.
+// The caller must hold the lock.
+func (p *promRegistry) getTimer(name string) Timer {
+	if t, ok := p.metrics[name].(*promTimer); ok {
+		return t
+	}
+	t := &promTimer{timer: gm.NewTimer(), buckets: p.buckets}
+	if len(p.buckets) > 0 {
+		t.counts = make([]uint64, len(p.buckets))
+	}
+	p.register(name, t)
+	return t
+}
+
+// register adds the metric unless a metric of a different type is
+// already registered under the same name. In that case the metric
+// is returned to the caller but not exposed.
+func (p *promRegistry) register(name string, m promMetric) {
+	if _, ok := p.metrics[name]; ok {
+		log.Printf("[WARN] metrics: %s is already registered with a different type", name)
+		return
+	}
+	p.metrics[name] = m
+}
+
+// WriteTo writes all metrics sorted by name in the Prometheus
+// text exposition format. Metrics whose name collides with an
+// already written metric after sanitizing are skipped.
+func (p *promRegistry) WriteTo(w io.Writer, seen map[string]bool) {
+	p.mu.Lock()
+	names := make([]string, 0, len(p.metrics))
+	for name := range p.metrics {
+		names = append(names, name)
+	}
+	metrics := make(map[string]promMetric, len(p.metrics))
+	for k, v := range p.metrics {
+		metrics[k] = v
+	}
+	extra := make(map[string]string, len(p.extra))
+	for k, v := range p.extra {
+		extra[k] = v
+	}
+	p.mu.Unlock()
+
+	sort.Strings(names)
+	for _, name := range names {
+		n := promName(p.namespace, name)
+		if seen[n] {
+			continue
+		}
+		seen[n] = true
+		labels := p.labels
+		if e := extra[name]; e != "" && labels != "" {
+			labels += "," + e
+		} else if e != "" {
+			labels = e
+		}
+		metrics[name].write(w, n, labels)
+	}
+}
+
+// PrometheusHandler returns a handler which serves the metrics of
+// all Prometheus registries in the Prometheus text exposition
+// format. Registries of other metrics backends are ignored.
+func PrometheusHandler(regs ...func() Registry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		bw := bufio.NewWriter(w)
+		seen := map[string]bool{}
+		for _, reg := range regs {
+			if p, ok := reg().(*promRegistry); ok {
+				p.WriteTo(bw, seen)
+			}
+		}
+		bw.Flush()
+	})
+}
+
+var (
+	reMetricName   = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	reInvalidChars = regexp.MustCompile(`[^a-zA-Z0-9_:]`)
+)
+
+// promName returns a valid Prometheus metric name for
+// the namespace and the name of a fabio metric.
+func promName(namespace, name string) string {
+	if namespace != "" {
+		name = namespace + "_" + name
+	}
+	name = reInvalidChars.ReplaceAllString(name, "_")
+	if name == "" || name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+	return name
+}
+
+var reInvalidLabelChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// promLabelName returns a valid Prometheus label name.
+func promLabelName(name string) string {
+	name = reInvalidLabelChars.ReplaceAllString(name, "_")
+	if name == "" || name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+	return name
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// quoteLabel returns the quoted and escaped label value.
+func quoteLabel(s string) string {
+	return `"` + labelEscaper.Replace(s) + `"`
+}
+
+// withLabels joins the constant labels with extra labels.
+func withLabels(labels, extra string) string {
+	switch {
+	case labels == "" && extra == "":
+		return ""
+	case labels == "":
+		return "{" + extra + "}"
+	case extra == "":
+		return "{" + labels + "}"
+	default:
+		return "{" + labels + "," + extra + "}"
+	}
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+type promCounter struct {
+	n int64
+}
+
+func (c *promCounter) Inc(n int64) {
+	atomic.AddInt64(&c.n, n)
+}
+
+func (c *promCounter) write(w io.Writer, name, labels string) {
+	fmt.Fprintf(w, "# TYPE %s_total counter\n", name)
+	fmt.Fprintf(w, "%s_total%s %d\n", name, withLabels(labels, ""), atomic.LoadInt64(&c.n))
+}
+
+type promGauge struct {
+	n int64
+}
+
+func (g *promGauge) Update(n int64) {
+	atomic.StoreInt64(&g.n, n)
+}
+
+func (g *promGauge) write(w io.Writer, name, labels string) {
+	fmt.Fprintf(w, "# TYPE %s gauge\n", name)
+	fmt.Fprintf(w, "%s%s %d\n", name, withLabels(labels, ""), atomic.LoadInt64(&g.n))
+}
+
+// promTimer is exposed as a histogram if buckets are configured
+// and as a summary otherwise. The durations are reported in seconds.
+// The rate and the percentiles of the Timer interface are provided
+// by a go-metrics timer.
+type promTimer struct {
+	timer gm.Timer
+
+	buckets []float64
+
+	mu     sync.Mutex
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func (t *promTimer) Percentile(nth float64) float64 {
+	return t.timer.Percentile(nth)
+}
+
+func (t *promTimer) Rate1() float64 {
+	return t.timer.Rate1()
+}
+
+func (t *promTimer) Update(d time.Duration) {
+	t.timer.Update(d)
+
+	sec := d.Seconds()
+	t.mu.Lock()
+	t.count++
+	t.sum += sec
+	for i, b := range t.buckets {
+		if sec <= b {
+			t.counts[i]++
+		}
+	}
+	t.mu.Unlock()
+}
+
+func (t *promTimer) UpdateSince(start time.Time) {
+	t.Update(time.Since(start))
+}
+
+func (t *promTimer) write(w io.Writer, name, labels string) {
+	name += "_seconds"
+
+	t.mu.Lock()
+	count, sum := t.count, t.sum
+	counts := append([]uint64(nil), t.counts...)
+	t.mu.Unlock()
+
+	if len(t.buckets) > 0 {
+		fmt.Fprintf(w, "# TYPE %s histogram\n", name)
+		for i, b := range t.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", name, withLabels(labels, "le="+quoteLabel(formatFloat(b))), counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", name, withLabels(labels, `le="+Inf"`), count)
+	} else {
+		fmt.Fprintf(w, "# TYPE %s summary\n", name)
+		for i, v := range t.timer.Percentiles(quantiles) {
+			fmt.Fprintf(w, "%s%s %s\n", name, withLabels(labels, "quantile="+quoteLabel(formatFloat(quantiles[i]))), formatFloat(v/float64(time.Second)))
+		}
+	}
+	fmt.Fprintf(w, "%s_sum%s %s\n", name, withLabels(labels, ""), formatFloat(sum))
+	fmt.Fprintf(w, "%s_count%s %d\n", name, withLabels(labels, ""), count)
+}
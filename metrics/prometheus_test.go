@@ -0,0 +1,139 @@
+package metrics
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fabiolb/fabio/config"
+)
+
+func TestPromName(t *testing.T) {
+	tests := []struct {
+		ns, name, want string
+	}{
+		{"", "requests", "requests"},
+		{"fabio", "requests", "fabio_requests"},
+		{"fabio", "svc.example_com./foo.127_0_0_1_5000", "fabio_svc_example_com__foo_127_0_0_1_5000"},
+		{"", "1xx", "_1xx"},
+	}
+	for _, tt := range tests {
+		if got, want := promName(tt.ns, tt.name), tt.want; got != want {
+			t.Errorf("promName(%q, %q): got %q want %q", tt.ns, tt.name, got, want)
+		}
+	}
+}
+
+func TestPrometheusRegistry(t *testing.T) {
+	tests := []struct {
+		desc string
+		cfg  config.Prometheus
+		want string
+	}{
+		{
+			desc: "summary",
+			cfg:  config.Prometheus{Namespace: "fabio", Labels: map[string]string{"env": "prod", "dc": `a"b`}},
+			want: `# TYPE fabio_conns gauge
+fabio_conns{dc="a\"b",env="prod"} 3
+# TYPE fabio_notfound_total counter
+fabio_notfound_total{dc="a\"b",env="prod"} 2
+# TYPE fabio_requests_seconds summary
+fabio_requests_seconds{dc="a\"b",env="prod",quantile="0.5"} 1.25
+fabio_requests_seconds{dc="a\"b",env="prod",quantile="0.9"} 2
+fabio_requests_seconds{dc="a\"b",env="prod",quantile="0.99"} 2
+fabio_requests_seconds_sum{dc="a\"b",env="prod"} 2.5
+fabio_requests_seconds_count{dc="a\"b",env="prod"} 2
+`,
+		},
+		{
+			desc: "histogram",
+			cfg:  config.Prometheus{Buckets: []float64{0.1, 1}},
+			want: `# TYPE conns gauge
+conns 3
+# TYPE notfound_total counter
+notfound_total 2
+# TYPE requests_seconds histogram
+requests_seconds_bucket{le="0.1"} 0
+requests_seconds_bucket{le="1"} 1
+requests_seconds_bucket{le="+Inf"} 2
+requests_seconds_sum 2.5
+requests_seconds_count 2
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			r, err := prometheusRegistry(tt.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.UnregisterAll()
+
+			r.GetCounter("notfound").Inc(1)
+			r.GetCounter("notfound").Inc(1)
+			r.GetGauge("conns").Update(3)
+			r.GetTimer("requests").Update(500 * time.Millisecond)
+			r.GetTimer("requests").Update(2 * time.Second)
+
+			// metrics of other backends are ignored
+			h := PrometheusHandler(
+				func() Registry { return r },
+				func() Registry { return NoopRegistry{} },
+			)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+			body, _ := ioutil.ReadAll(w.Body)
+			if got, want := string(body), tt.want; got != want {
+				t.Fatalf("got\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestPrometheusRegistryUnregister(t *testing.T) {
+	r, err := prometheusRegistry(config.Prometheus{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.GetCounter("a")
+	r.GetTimer("b")
+	r.Unregister("a")
+	if got, want := r.Names(), []string{"b"}; len(got) != 1 || got[0] != want[0] {
+		t.Fatalf("got %v want %v", got, want)
+	}
+	r.UnregisterAll()
+	if got := r.Names(); len(got) != 0 {
+		t.Fatalf("got %v want []", got)
+	}
+}
+
+func TestPrometheusRegistryLabels(t *testing.T) {
+	r, err := prometheusRegistry(config.Prometheus{Labels: map[string]string{"env": "prod"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.UnregisterAll()
+
+	GetTimer(r, "a", map[string]string{"team": "pay", "slo-tier": "1", "env": "dev"}).Update(time.Second)
+	GetTimer(r, "b", nil).Update(time.Second)
+
+	w := httptest.NewRecorder()
+	PrometheusHandler(func() Registry { return r }).ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := ioutil.ReadAll(w.Body)
+	for _, want := range []string{
+		`a_seconds_count{env="prod",slo_tier="1",team="pay"} 1`,
+		`b_seconds_count{env="prod"} 1`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Fatalf("got\n%s\nwant %s", body, want)
+		}
+	}
+
+	r.Unregister("a")
+	if got := r.(*promRegistry).extra; len(got) != 0 {
+		t.Fatalf("got labels %v after unregister", got)
+	}
+}
@@ -29,6 +29,29 @@ type Registry interface {
 	// If the metric does not exist yet it should be created
 	// otherwise the existing metric should be returned.
 	GetTimer(name string) Timer
+
+	// GetGauge returns a gauge metric for the given name.
+	// If the metric does not exist yet it should be created
+	// otherwise the existing metric should be returned.
+	GetGauge(name string) Gauge
+}
+
+// LabelRegistry is implemented by registries which
+// support labels on individual metrics.
+type LabelRegistry interface {
+	// GetTimerWithLabels returns a timer metric for the given
+	// name which is reported with the additional labels.
+	GetTimerWithLabels(name string, labels map[string]string) Timer
+}
+
+// GetTimer returns a timer metric for the given name from r. The
+// labels are added to the metric if r is a LabelRegistry and are
+// ignored otherwise.
+func GetTimer(r Registry, name string, labels map[string]string) Timer {
+	if lr, ok := r.(LabelRegistry); ok && len(labels) > 0 {
+		return lr.GetTimerWithLabels(name, labels)
+	}
+	return r.GetTimer(name)
 }
 
 // Counter defines a metric for counting events.
@@ -37,6 +60,12 @@ type Counter interface {
 	Inc(n int64)
 }
 
+// Gauge defines a metric for a value which can go up and down.
+type Gauge interface {
+	// Update sets the gauge to 'n'.
+	Update(n int64)
+}
+
 // Timer defines a metric for counting and timing durations for events.
 type Timer interface {
 	// Percentile returns the nth percentile of the duration.
@@ -0,0 +1,82 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+
+	gm "github.com/rcrowley/go-metrics"
+)
+
+// percentiles are the percentiles which are reported
+// for timers and histograms.
+var percentiles = []float64{0.5, 0.75, 0.95, 0.99, 0.999}
+
+// statsdOnce sends the metrics of the registry to a StatsD server
+// in the format of github.com/pubnub/go-metrics-statsd.
+func statsdOnce(r gm.Registry, prefix string, addr *net.UDPAddr) error {
+	conn, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// every metric is sent in a separate datagram
+	w := bufio.NewWriter(conn)
+	r.Each(func(name string, i interface{}) {
+		writeStatsD(w, prefix+"--"+name, i)
+		w.Flush()
+	})
+	return nil
+}
+
+func writeStatsD(w io.Writer, name string, i interface{}) {
+	pct := func(ps []float64) {
+		for k, p := range percentiles {
+			key := strings.Replace(strconv.FormatFloat(p*100.0, 'f', -1, 64), ".", "", 1)
+			fmt.Fprintf(w, "%s.%s-percentile:%.2f|g\n", name, key, ps[k])
+		}
+	}
+
+	switch m := i.(type) {
+	case gm.Counter:
+		fmt.Fprintf(w, "%s.count:%d|c\n", name, m.Count())
+	case gm.Gauge:
+		fmt.Fprintf(w, "%s.value:%d|g\n", name, m.Value())
+	case gm.GaugeFloat64:
+		fmt.Fprintf(w, "%s.value:%f|g\n", name, m.Value())
+	case gm.Histogram:
+		h := m.Snapshot()
+		fmt.Fprintf(w, "%s.count:%d|c\n", name, h.Count())
+		fmt.Fprintf(w, "%s.min:%d|g\n", name, h.Min())
+		fmt.Fprintf(w, "%s.max:%d|g\n", name, h.Max())
+		fmt.Fprintf(w, "%s.mean:%.2f|g\n", name, h.Mean())
+		fmt.Fprintf(w, "%s.std-dev:%.2f|g\n", name, h.StdDev())
+		pct(h.Percentiles(percentiles))
+	case gm.Meter:
+		s := m.Snapshot()
+		fmt.Fprintf(w, "%s.count:%d|c\n", name, s.Count())
+		fmt.Fprintf(w, "%s.one-minute:%.2f|g\n", name, s.Rate1())
+		fmt.Fprintf(w, "%s.five-minute:%.2f|g\n", name, s.Rate5())
+		fmt.Fprintf(w, "%s.fifteen-minute:%.2f|g\n", name, s.Rate15())
+		fmt.Fprintf(w, "%s.mean:%.2f|g\n", name, s.RateMean())
+	case gm.Timer:
+		t := m.Snapshot()
+		fmt.Fprintf(w, "%s.count:%d|c\n", name, t.Count())
+		fmt.Fprintf(w, "%s.min:%d|g\n", name, t.Min())
+		fmt.Fprintf(w, "%s.max:%d|g\n", name, t.Max())
+		fmt.Fprintf(w, "%s.mean:%.2f|g\n", name, t.Mean())
+		fmt.Fprintf(w, "%s.std-dev:%.2f|g\n", name, t.StdDev())
+		pct(t.Percentiles(percentiles))
+		fmt.Fprintf(w, "%s.one-minute:%.2f|g\n", name, t.Rate1())
+		fmt.Fprintf(w, "%s.five-minute:%.2f|g\n", name, t.Rate5())
+		fmt.Fprintf(w, "%s.fifteen-minute:%.2f|g\n", name, t.Rate15())
+		fmt.Fprintf(w, "%s.mean-rate:%.2f|g\n", name, t.RateMean())
+	default:
+		log.Printf("[WARN] metrics: Cannot send %s to StatsD", name)
+	}
+}
@@ -0,0 +1,31 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+
+	gm "github.com/rcrowley/go-metrics"
+)
+
+func TestWriteStatsD(t *testing.T) {
+	c := gm.NewCounter()
+	c.Inc(3)
+	g := gm.NewGauge()
+	g.Update(7)
+
+	tests := []struct {
+		metric interface{}
+		out    string
+	}{
+		{c, "p--foo.count:3|c\n"},
+		{g, "p--foo.value:7|g\n"},
+	}
+
+	for _, tt := range tests {
+		var b bytes.Buffer
+		writeStatsD(&b, "p--foo", tt.metric)
+		if got, want := b.String(), tt.out; got != want {
+			t.Errorf("got %q want %q", got, want)
+		}
+	}
+}
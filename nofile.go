@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"math"
+	"time"
+
+	"github.com/fabiolb/fabio/config"
+	"github.com/fabiolb/fabio/metrics"
+)
+
+// errNoFileUnsupported is returned when the open file limit
+// cannot be determined on the platform.
+var errNoFileUnsupported = errors.New("not supported on this platform")
+
+// noFileInterval is the interval in which the
+// number of open files is checked.
+const noFileInterval = 10 * time.Second
+
+// initNoFile raises the soft limit of open files to the hard limit
+// and warns if the limit is lower than the number of connections to
+// the upstream servers allowed by proxy.maxconn. Then it checks the
+// number of open files periodically.
+func initNoFile(cfg *config.Config) {
+	cur, max, err := noFileLimit()
+	if err == errNoFileUnsupported {
+		return
+	}
+	if err != nil {
+		log.Print("[WARN] Cannot determine the open file limit. ", err)
+		return
+	}
+
+	if cfg.Runtime.NoFileRaise && cur < max {
+		if err := raiseNoFileLimit(); err != nil {
+			log.Printf("[WARN] Cannot raise the open file limit from %d to %d. %s", cur, max, err)
+		} else {
+			log.Printf("[INFO] Raised the open file limit from %d to %d", cur, max)
+			cur = max
+		}
+	}
+	log.Printf("[INFO] Open file limit is %d", cur)
+
+	if cur < uint64(cfg.Proxy.MaxConn) {
+		log.Printf("[WARN] The open file limit of %d is lower than the %d connections allowed by proxy.maxconn. "+
+			"Connections will fail with 'too many open files' under load", cur, cfg.Proxy.MaxConn)
+	}
+
+	go func() {
+		var high bool
+		for {
+			high = checkNoFile(cfg.Runtime.NoFileWarn, high)
+			time.Sleep(noFileInterval)
+		}
+	}()
+}
+
+// checkNoFile updates the runtime.nofile.open and runtime.nofile.limit
+// gauges and returns true if the share of the limit which is in use
+// is above warn. It logs a warning when the usage rises above warn
+// and a message when it drops below again. high is the result of the
+// previous check.
+func checkNoFile(warn float64, high bool) bool {
+	open, err := openFiles()
+	if err != nil {
+		return high
+	}
+	limit, _, err := noFileLimit()
+	if err != nil {
+		return high
+	}
+
+	metrics.DefaultRegistry.GetGauge("runtime.nofile.open").Update(int64(open))
+	if limit > math.MaxInt64 {
+		metrics.DefaultRegistry.GetGauge("runtime.nofile.limit").Update(math.MaxInt64)
+	} else {
+		metrics.DefaultRegistry.GetGauge("runtime.nofile.limit").Update(int64(limit))
+	}
+
+	if warn <= 0 || limit == 0 {
+		return false
+	}
+	above := float64(open) >= warn*float64(limit)
+	switch {
+	case above && !high:
+		log.Printf("[WARN] %d of %d open files are in use. New connections will fail with 'too many open files' "+
+			"when the limit is reached. Raise the limit with 'ulimit -n' or 'LimitNOFILE' of the systemd unit", open, limit)
+	case !above && high:
+		log.Printf("[INFO] %d of %d open files are in use", open, limit)
+	}
+	return above
+}
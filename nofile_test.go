@@ -0,0 +1,19 @@
+package main
+
+import "testing"
+
+func TestCheckNoFile(t *testing.T) {
+	if _, err := openFiles(); err != nil {
+		t.Skip("open files not supported: ", err)
+	}
+
+	if got, want := checkNoFile(1e-9, false), true; got != want {
+		t.Fatalf("got %v want %v", got, want)
+	}
+	if got, want := checkNoFile(1, true), false; got != want {
+		t.Fatalf("got %v want %v", got, want)
+	}
+	if got, want := checkNoFile(0, true), false; got != want {
+		t.Fatalf("got %v want %v", got, want)
+	}
+}
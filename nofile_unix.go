@@ -0,0 +1,54 @@
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"runtime"
+	"syscall"
+)
+
+// fdDirs are the directories with an entry for every open
+// file of the process per operating system.
+var fdDirs = map[string]string{
+	"linux":  "/proc/self/fd",
+	"darwin": "/dev/fd",
+}
+
+// noFileLimit returns the soft and the hard limit of open files.
+func noFileLimit() (cur, max uint64, err error) {
+	var lim syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &lim); err != nil {
+		return 0, 0, err
+	}
+	return uint64(lim.Cur), uint64(lim.Max), nil
+}
+
+// raiseNoFileLimit raises the soft limit of open files to the hard limit.
+func raiseNoFileLimit() error {
+	var lim syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &lim); err != nil {
+		return err
+	}
+	lim.Cur = lim.Max
+	return syscall.Setrlimit(syscall.RLIMIT_NOFILE, &lim)
+}
+
+// openFiles returns the number of open files of the process.
+func openFiles() (uint64, error) {
+	dir := fdDirs[runtime.GOOS]
+	if dir == "" {
+		return 0, errNoFileUnsupported
+	}
+	f, err := os.Open(dir)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	fds, err := f.Readdirnames(-1)
+	if err != nil {
+		return 0, err
+	}
+	// the directory is an open file itself
+	return uint64(len(fds) - 1), nil
+}
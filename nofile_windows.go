@@ -0,0 +1,16 @@
+// +build windows
+
+package main
+
+func noFileLimit() (cur, max uint64, err error) {
+	// windows not supported
+	return 0, 0, errNoFileUnsupported
+}
+
+func raiseNoFileLimit() error {
+	return errNoFileUnsupported
+}
+
+func openFiles() (uint64, error) {
+	return 0, errNoFileUnsupported
+}
@@ -0,0 +1,36 @@
+package noroute
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// errorHTML is the HTML page of the standard error responses.
+const errorHTML = `<!DOCTYPE html>
+<html>
+<head><title>%[1]d %[2]s</title></head>
+<body><h1>%[1]d %[2]s</h1></body>
+</html>
+`
+
+// ErrorBody returns the content type and the body of a standard error
+// response with the status code and text. The format is 'json', 'html'
+// or 'auto' which returns JSON for clients which prefer
+// application/json over text/html in the Accept header and HTML for
+// all others.
+func ErrorBody(format, accept string, status int) (string, []byte) {
+	if format == "json" || (format == "auto" && preferJSON(accept)) {
+		return "application/json; charset=utf-8", statusJSON(status)
+	}
+	return "text/html; charset=utf-8", []byte(fmt.Sprintf(errorHTML, status, http.StatusText(status)))
+}
+
+// statusJSON returns a JSON object with the status code and text.
+func statusJSON(status int) []byte {
+	b, _ := json.Marshal(struct {
+		Status int    `json:"status"`
+		Error  string `json:"error"`
+	}{status, http.StatusText(status)})
+	return b
+}
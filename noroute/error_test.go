@@ -0,0 +1,25 @@
+package noroute
+
+import "testing"
+
+func TestErrorBody(t *testing.T) {
+	const html = "<!DOCTYPE html>\n<html>\n<head><title>502 Bad Gateway</title></head>\n<body><h1>502 Bad Gateway</h1></body>\n</html>\n"
+
+	tests := []struct {
+		format, accept string
+		ctype, body    string
+	}{
+		{"json", "", "application/json; charset=utf-8", `{"status":502,"error":"Bad Gateway"}`},
+		{"json", "text/html", "application/json; charset=utf-8", `{"status":502,"error":"Bad Gateway"}`},
+		{"html", "application/json", "text/html; charset=utf-8", html},
+		{"auto", "", "text/html; charset=utf-8", html},
+		{"auto", "text/html,*/*;q=0.8", "text/html; charset=utf-8", html},
+		{"auto", "application/json", "application/json; charset=utf-8", `{"status":502,"error":"Bad Gateway"}`},
+	}
+	for _, tt := range tests {
+		ctype, body := ErrorBody(tt.format, tt.accept, 502)
+		if ctype != tt.ctype || string(body) != tt.body {
+			t.Errorf("%s %q: got %q %q want %q %q", tt.format, tt.accept, ctype, body, tt.ctype, tt.body)
+		}
+	}
+}
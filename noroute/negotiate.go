@@ -0,0 +1,174 @@
+package noroute
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+var pages atomic.Value    // map[string]string
+var jsonBody atomic.Value // string
+
+func init() {
+	pages.Store(map[string]string{})
+	jsonBody.Store("")
+}
+
+// SetPages sets the HTML for not found routes per language. The keys
+// are language tags like 'de' or 'pt-BR'. Requests whose
+// Accept-Language header matches none of the languages receive the
+// HTML from SetHTML.
+func SetPages(p map[string]string) {
+	m := map[string]string{}
+	for lang, html := range p {
+		m[strings.ToLower(lang)] = html
+	}
+	pages.Store(m)
+}
+
+// SetJSON sets the body for not found routes for clients which prefer
+// JSON. If it is empty the body contains the status code and text.
+func SetJSON(body string) {
+	jsonBody.Store(body)
+}
+
+// LoadPages reads the HTML pages for not found routes per language from
+// the '<lang>.html' files in dir.
+func LoadPages(dir string) (map[string]string, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.html"))
+	if err != nil {
+		return nil, err
+	}
+	p := map[string]string{}
+	for _, f := range files {
+		data, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		p[strings.TrimSuffix(filepath.Base(f), ".html")] = string(data)
+	}
+	return p, nil
+}
+
+// Write writes the response for a request without a route. Clients
+// which prefer application/json over text/html in the Accept header
+// receive JSON. All others receive the HTML page in the language which
+// matches the Accept-Language header best.
+func Write(w http.ResponseWriter, r *http.Request, status int) {
+	w.Header().Add("Vary", "Accept, Accept-Language")
+
+	if preferJSON(r.Header.Get("Accept")) {
+		body := jsonBody.Load().(string)
+		if body == "" {
+			body = string(statusJSON(status))
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(status)
+		io.WriteString(w, body)
+		return
+	}
+
+	lang, html := page(r.Header.Get("Accept-Language"))
+	if lang != "" {
+		w.Header().Set("Content-Language", lang)
+	}
+	if html != "" {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	}
+	w.WriteHeader(status)
+	if html != "" {
+		io.WriteString(w, html)
+	}
+}
+
+// page returns the language and the HTML page which matches the
+// Accept-Language header best. A language range like 'de' matches
+// 'de-CH' and vice versa. The language is empty for the default page.
+func page(accept string) (lang, html string) {
+	p := pages.Load().(map[string]string)
+	if len(p) > 0 {
+		for _, r := range parseAccept(accept) {
+			tag := strings.ToLower(r.value)
+			if html, ok := p[tag]; ok {
+				return tag, html
+			}
+			prefix := tag
+			if i := strings.Index(tag, "-"); i > 0 {
+				prefix = tag[:i]
+			}
+			if html, ok := p[prefix]; ok {
+				return prefix, html
+			}
+			// fall back to the first regional variant in alphabetical order
+			var variants []string
+			for l := range p {
+				if strings.HasPrefix(l, prefix+"-") {
+					variants = append(variants, l)
+				}
+			}
+			if len(variants) > 0 {
+				sort.Strings(variants)
+				return variants[0], p[variants[0]]
+			}
+		}
+	}
+	return "", GetHTML()
+}
+
+// preferJSON returns true if the Accept header prefers
+// application/json over text/html.
+func preferJSON(accept string) bool {
+	var qJSON, qHTML float64 = -1, -1
+	for _, r := range parseAccept(accept) {
+		switch r.value {
+		case "application/json":
+			qJSON = r.q
+		case "text/html":
+			qHTML = r.q
+		case "*/*", "text/*":
+			if qHTML < 0 {
+				qHTML = r.q
+			}
+		}
+	}
+	return qJSON > 0 && qJSON > qHTML
+}
+
+type acceptRange struct {
+	value string
+	q     float64
+}
+
+// parseAccept parses the ranges of an Accept or Accept-Language header
+// and returns the acceptable ones sorted by their quality in
+// descending order.
+func parseAccept(s string) []acceptRange {
+	var ranges []acceptRange
+	for _, part := range strings.Split(s, ",") {
+		fields := strings.Split(part, ";")
+		r := acceptRange{value: strings.ToLower(strings.TrimSpace(fields[0])), q: 1}
+		if r.value == "" {
+			continue
+		}
+		for _, p := range fields[1:] {
+			p = strings.TrimSpace(p)
+			if strings.HasPrefix(p, "q=") {
+				q, err := strconv.ParseFloat(p[2:], 64)
+				if err != nil {
+					q = 0
+				}
+				r.q = q
+			}
+		}
+		if r.q > 0 {
+			ranges = append(ranges, r)
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].q > ranges[j].q })
+	return ranges
+}
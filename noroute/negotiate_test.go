@@ -0,0 +1,113 @@
+package noroute
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	SetHTML("default")
+	SetPages(map[string]string{"de": "deutsch", "pt-BR": "português", "fr-CA": "québécois", "fr-FR": "français"})
+	SetJSON("")
+	defer func() {
+		SetHTML("")
+		SetPages(nil)
+		SetJSON("")
+	}()
+
+	tests := []struct {
+		desc, accept, lang string
+		ctype, clang, body string
+	}{
+		{"no headers", "", "", "text/html; charset=utf-8", "", "default"},
+		{"browser", "text/html,application/xhtml+xml,*/*;q=0.8", "de-DE,de;q=0.9,en;q=0.8", "text/html; charset=utf-8", "de", "deutsch"},
+		{"exact language", "", "pt-BR", "text/html; charset=utf-8", "pt-br", "português"},
+		{"language prefix", "", "pt", "text/html; charset=utf-8", "pt-br", "português"},
+		{"first variant", "", "fr", "text/html; charset=utf-8", "fr-ca", "québécois"},
+		{"quality", "", "en;q=0.9, pt-BR;q=0.5, de;q=0.7", "text/html; charset=utf-8", "de", "deutsch"},
+		{"unknown language", "", "en, es", "text/html; charset=utf-8", "", "default"},
+		{"excluded language", "", "de;q=0, en", "text/html; charset=utf-8", "", "default"},
+		{"json", "application/json", "de", "application/json; charset=utf-8", "", `{"status":404,"error":"Not Found"}`},
+		{"json preferred", "text/html;q=0.5, application/json", "", "application/json; charset=utf-8", "", `{"status":404,"error":"Not Found"}`},
+		{"html preferred", "text/html, application/json;q=0.9", "", "text/html; charset=utf-8", "", "default"},
+		{"json with wildcard", "application/json, */*;q=0.1", "", "application/json; charset=utf-8", "", `{"status":404,"error":"Not Found"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			if tt.lang != "" {
+				req.Header.Set("Accept-Language", tt.lang)
+			}
+			rec := httptest.NewRecorder()
+			Write(rec, req, http.StatusNotFound)
+			if got, want := rec.Code, http.StatusNotFound; got != want {
+				t.Fatalf("got status %d want %d", got, want)
+			}
+			if got, want := rec.Header().Get("Content-Type"), tt.ctype; got != want {
+				t.Fatalf("got content type %q want %q", got, want)
+			}
+			if got, want := rec.Header().Get("Content-Language"), tt.clang; got != want {
+				t.Fatalf("got content language %q want %q", got, want)
+			}
+			if got, want := rec.Body.String(), tt.body; got != want {
+				t.Fatalf("got body %q want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestWriteCustomJSON(t *testing.T) {
+	SetJSON(`{"error":"no route"}`)
+	defer SetJSON("")
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept", "application/json")
+	rec := httptest.NewRecorder()
+	Write(rec, req, http.StatusServiceUnavailable)
+	if got, want := rec.Code, http.StatusServiceUnavailable; got != want {
+		t.Fatalf("got status %d want %d", got, want)
+	}
+	if got, want := rec.Body.String(), `{"error":"no route"}`; got != want {
+		t.Fatalf("got body %q want %q", got, want)
+	}
+}
+
+func TestWriteEmpty(t *testing.T) {
+	rec := httptest.NewRecorder()
+	Write(rec, httptest.NewRequest("GET", "/", nil), http.StatusNotFound)
+	if got := rec.Header().Get("Content-Type"); got != "" {
+		t.Fatalf("got content type %q want none", got)
+	}
+	if got := rec.Body.String(); got != "" {
+		t.Fatalf("got body %q want none", got)
+	}
+}
+
+func TestLoadPages(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fabio-noroute")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for name, data := range map[string]string{"de.html": "deutsch", "en-US.html": "english", "README": "x"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := LoadPages(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"de": "deutsch", "en-US": "english"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+}
@@ -0,0 +1,179 @@
+package noroute
+
+import (
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxKeyLen limits the length of the host and the path which are
+// stored so that long request URIs cannot use up the memory.
+const maxKeyLen = 256
+
+// Entry contains the number of requests without a route for a host
+// and a path.
+type Entry struct {
+	Host string `json:"host"`
+	Path string `json:"path"`
+
+	// Count is the number of requests. It can overestimate the
+	// number by up to Error if the entry replaced another one.
+	Count uint64 `json:"count"`
+	Error uint64 `json:"error"`
+
+	// Source is the address of the client of the last request.
+	Source   string    `json:"source"`
+	LastSeen time.Time `json:"lastSeen"`
+}
+
+// Stats counts the requests without a route per host and path. It
+// keeps the K most frequent entries with the space saving algorithm:
+// when it is full a new entry replaces the least frequent one and
+// inherits its count so that frequent entries are not evicted by
+// a stream of unique paths.
+type Stats struct {
+	k int
+
+	// mu guards the fields below.
+	mu      sync.Mutex
+	total   uint64
+	entries map[[2]string]*Entry
+}
+
+// NewStats returns the statistics for the k most frequent hosts and paths.
+func NewStats(k int) *Stats {
+	return &Stats{k: k, entries: map[[2]string]*Entry{}}
+}
+
+// Add counts a request for host and path from the client at src.
+func (s *Stats) Add(host, path, src string, now time.Time) {
+	key := [2]string{truncate(host), truncate(path)}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.total++
+	e := s.entries[key]
+	if e == nil {
+		e = &Entry{Host: key[0], Path: key[1]}
+		if len(s.entries) >= s.k {
+			var min [2]string
+			for k, v := range s.entries {
+				if e.Count == 0 || v.Count < e.Count {
+					min, e.Count = k, v.Count
+				}
+			}
+			delete(s.entries, min)
+			e.Error = e.Count
+		}
+		s.entries[key] = e
+	}
+	e.Count++
+	e.Source, e.LastSeen = src, now
+}
+
+// Top returns the n most frequent entries and the total number of
+// requests. n <= 0 returns all entries.
+func (s *Stats) Top(n int) ([]Entry, uint64) {
+	s.mu.Lock()
+	entries := make([]Entry, 0, len(s.entries))
+	for _, e := range s.entries {
+		entries = append(entries, *e)
+	}
+	total := s.total
+	s.mu.Unlock()
+
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		if a.Host != b.Host {
+			return a.Host < b.Host
+		}
+		return a.Path < b.Path
+	})
+	if n > 0 && n < len(entries) {
+		entries = entries[:n]
+	}
+	return entries, total
+}
+
+func truncate(s string) string {
+	if len(s) > maxKeyLen {
+		return s[:maxKeyLen]
+	}
+	return s
+}
+
+// Tracker records the requests without a route in the statistics
+// and writes a sample of them to a log.
+type Tracker struct {
+	Stats *Stats
+
+	// Log receives a line per sampled request if it is not nil.
+	Log *log.Logger
+
+	// Sample is the share of the requests which are logged.
+	Sample float64
+}
+
+// NewTracker returns a tracker which keeps the k most frequent hosts
+// and paths and logs the share sample of the requests to w. k == 0
+// disables the statistics and a nil writer the log.
+func NewTracker(k int, w io.Writer, sample float64) *Tracker {
+	t := &Tracker{Sample: sample}
+	if k > 0 {
+		t.Stats = NewStats(k)
+	}
+	if w != nil {
+		t.Log = log.New(w, "", log.LstdFlags)
+	}
+	return t
+}
+
+// Record records a request without a route.
+func (t *Tracker) Record(r *http.Request) {
+	src, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		src = r.RemoteAddr
+	}
+	if t.Stats != nil {
+		t.Stats.Add(r.Host, r.URL.Path, src, time.Now().UTC())
+	}
+	if t.Log != nil && (t.Sample >= 1 || rand.Float64() < t.Sample) {
+		t.Log.Printf("noroute host=%q path=%q src=%q", r.Host, r.URL.Path, src)
+	}
+}
+
+var tracker atomic.Value // *Tracker
+
+func init() {
+	tracker.Store(&Tracker{})
+}
+
+// SetTracker sets the tracker for the requests without a route.
+func SetTracker(t *Tracker) {
+	tracker.Store(t)
+}
+
+// Record records a request without a route with the current tracker.
+func Record(r *http.Request) {
+	tracker.Load().(*Tracker).Record(r)
+}
+
+// Top returns the n most frequent hosts and paths without a route and
+// the total number of requests. It returns nil if the statistics are
+// disabled.
+func Top(n int) ([]Entry, uint64) {
+	s := tracker.Load().(*Tracker).Stats
+	if s == nil {
+		return nil, 0
+	}
+	return s.Top(n)
+}
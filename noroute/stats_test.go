@@ -0,0 +1,64 @@
+package noroute
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStats(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := NewStats(2)
+	s.Add("a.com", "/x", "1.1.1.1", now)
+	s.Add("a.com", "/x", "1.1.1.2", now)
+	s.Add("a.com", "/x", "1.1.1.3", now)
+	s.Add("b.com", "/y", "2.2.2.2", now)
+
+	// replaces b.com/y and inherits its count
+	s.Add("c.com", "/z", "3.3.3.3", now)
+
+	got, total := s.Top(0)
+	want := []Entry{
+		{Host: "a.com", Path: "/x", Count: 3, Source: "1.1.1.3", LastSeen: now},
+		{Host: "c.com", Path: "/z", Count: 2, Error: 1, Source: "3.3.3.3", LastSeen: now},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v want %+v", got, want)
+	}
+	if total != 5 {
+		t.Fatalf("got total %d want 5", total)
+	}
+
+	if got, _ := s.Top(1); len(got) != 1 || got[0].Host != "a.com" {
+		t.Fatalf("got %+v want a.com", got)
+	}
+
+	s.Add("a.com", "/"+strings.Repeat("x", 2*maxKeyLen), "1.1.1.1", now)
+	for _, e := range s.entries {
+		if len(e.Path) > maxKeyLen {
+			t.Fatalf("got path with %d bytes want at most %d", len(e.Path), maxKeyLen)
+		}
+	}
+}
+
+func TestTracker(t *testing.T) {
+	var buf bytes.Buffer
+	tr := NewTracker(10, &buf, 1)
+	r := httptest.NewRequest("GET", "http://a.com/x?secret=1", nil)
+	r.RemoteAddr = "1.2.3.4:5678"
+	tr.Record(r)
+
+	if got, want := buf.String(), `noroute host="a.com" path="/x" src="1.2.3.4"`; !strings.Contains(got, want) {
+		t.Fatalf("got %q want %q", got, want)
+	}
+	entries, total := tr.Stats.Top(0)
+	if total != 1 || len(entries) != 1 || entries[0].Source != "1.2.3.4" {
+		t.Fatalf("got %+v, %d", entries, total)
+	}
+
+	// no stats and no log
+	NewTracker(0, nil, 1).Record(r)
+}
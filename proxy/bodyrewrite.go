@@ -0,0 +1,99 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/fabiolb/fabio/route"
+)
+
+// bodyRewriteTransport rewrites the text responses of targets with
+// the bodyrewrite option. Bodies up to maxBody bytes are rewritten
+// and sent with the new Content-Length. Larger bodies are streamed
+// unmodified.
+type bodyRewriteTransport struct {
+	http.RoundTripper
+	maxBody int
+
+	// target returns the target of the last upstream request.
+	target func() *route.Target
+}
+
+func (bt *bodyRewriteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// let the transport negotiate and decode the compression of
+	// the response so that the body can be rewritten.
+	if req.Header.Get("Accept-Encoding") != "" {
+		r := new(http.Request)
+		*r = *req
+		r.Header = req.Header.Clone()
+		r.Header.Del("Accept-Encoding")
+		req = r
+	}
+
+	resp, err := bt.RoundTripper.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	t := bt.target()
+	if t.BodyRewrite == nil || !rewritableBody(resp) {
+		return resp, nil
+	}
+	if resp.ContentLength > int64(bt.maxBody) {
+		return resp, nil
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, int64(bt.maxBody)+1))
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	if len(body) > bt.maxBody {
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+		return resp, nil
+	}
+	resp.Body.Close()
+
+	body = t.BodyRewrite.Rewrite(body)
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.TransferEncoding = nil
+	resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	return resp, nil
+}
+
+// rewritableBody returns true if the response has an uncompressed
+// text, HTML, JavaScript, JSON or XML body.
+func rewritableBody(resp *http.Response) bool {
+	if resp.Request != nil && resp.Request.Method == http.MethodHead {
+		return false
+	}
+	if resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusNotModified {
+		return false
+	}
+	if ce := resp.Header.Get("Content-Encoding"); ce != "" && ce != "identity" {
+		return false
+	}
+	ct, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	switch {
+	case strings.HasPrefix(ct, "text/"):
+		return true
+	case strings.HasSuffix(ct, "+xml"), strings.HasSuffix(ct, "+json"):
+		return true
+	}
+	switch ct {
+	case "application/javascript", "application/json", "application/xml":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,81 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fabiolb/fabio/config"
+	"github.com/fabiolb/fabio/route"
+)
+
+func TestProxyBodyRewrite(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := `<a href="http://legacy/x">x</a>`
+		switch r.URL.Path {
+		case "/large":
+			body += strings.Repeat(" ", 64)
+		case "/png":
+			w.Header().Set("Content-Type", "image/png")
+		case "/gzip":
+			if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+				break
+			}
+			w.Header().Set("Content-Type", "text/html")
+			w.Header().Set("Content-Encoding", "gzip")
+			gw := gzip.NewWriter(w)
+			gw.Write([]byte(body))
+			gw.Close()
+			return
+		}
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		}
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	proxy := httptest.NewServer(&HTTPProxy{
+		Config:    config.Proxy{BodyRewriteMaxBody: 64},
+		Transport: &http.Transport{},
+		Lookup: func(r *http.Request) *route.Target {
+			tbl, _ := route.NewTable(bytes.NewBufferString("route add mock / " + server.URL + ` opts "bodyrewrite=http://legacy/ /app/"`))
+			return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"], globCache, globEnabled)
+		},
+	})
+	defer proxy.Close()
+
+	tests := []struct {
+		desc, path, body string
+	}{
+		{"html", "/", `<a href="/app/x">x</a>`},
+		{"compressed", "/gzip", `<a href="/app/x">x</a>`},
+		{"not text", "/png", `<a href="http://legacy/x">x</a>`},
+		{"too large", "/large", `<a href="http://legacy/x">x</a>` + strings.Repeat(" ", 64)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", proxy.URL+tt.path, nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+			resp, body := mustDo(req)
+			if resp.Header.Get("Content-Encoding") == "gzip" {
+				gr, err := gzip.NewReader(bytes.NewReader(body))
+				if err != nil {
+					t.Fatal(err)
+				}
+				var b bytes.Buffer
+				b.ReadFrom(gr)
+				body = b.Bytes()
+			}
+			if got, want := string(body), tt.body; got != want {
+				t.Fatalf("got %q want %q", got, want)
+			}
+			if got, want := resp.ContentLength, int64(len(tt.body)); got != want {
+				t.Fatalf("got content length %d want %d", got, want)
+			}
+		})
+	}
+}
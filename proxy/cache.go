@@ -0,0 +1,152 @@
+package proxy
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fabiolb/fabio/cache"
+	"github.com/fabiolb/fabio/metrics"
+	"github.com/fabiolb/fabio/route"
+)
+
+// cacheHandler serves the GET requests to a target with the cache
+// option from the cache and stores the cacheable responses of h.
+func (p *HTTPProxy) cacheHandler(t *route.Target, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lookup, store := cache.Lookup(r)
+		if !lookup && !store {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		key := cache.Key(r, t.Service, t.Cache.Key)
+		if lookup {
+			if e := p.Cache.Get(key); e != nil {
+				countCache(t, "hit")
+				serveCached(w, r, e)
+				return
+			}
+		}
+		countCache(t, "miss")
+
+		// let the transport negotiate and decode the compression of
+		// the response so that all clients can be served from the
+		// same entry.
+		if r.Header.Get("Accept-Encoding") != "" {
+			r = r.Clone(r.Context())
+			r.Header.Del("Accept-Encoding")
+		}
+		w.Header().Set("X-Cache", "MISS")
+		rec := &cacheRecorder{ResponseWriter: w, maxBody: p.Config.Cache.MaxEntry}
+		h.ServeHTTP(rec, r)
+		if rec.header == nil || rec.skip {
+			return
+		}
+		ttl := cache.TTL(rec.code, rec.header, t.Cache.TTL)
+		if ttl <= 0 {
+			return
+		}
+		now := time.Now()
+		p.Cache.Set(key, &cache.Entry{
+			Service: t.Service,
+			Path:    r.URL.Path,
+			Status:  rec.code,
+			Header:  rec.header,
+			Body:    rec.body.Bytes(),
+			Stored:  now,
+			Expires: now.Add(ttl),
+		})
+	})
+}
+
+// serveCached writes the cached response. Requests with an
+// If-None-Match header which matches the ETag of the response
+// get a 304 Not Modified response.
+func serveCached(w http.ResponseWriter, r *http.Request, e *cache.Entry) {
+	h := w.Header()
+	for k, v := range e.Header {
+		h[k] = append([]string(nil), v...)
+	}
+	h.Set("Age", strconv.Itoa(int(time.Since(e.Stored)/time.Second)))
+	h.Set("X-Cache", "HIT")
+
+	if etag := e.Header.Get("ETag"); etag != "" && etagMatch(r.Header.Get("If-None-Match"), etag) {
+		h.Del("Content-Length")
+		h.Del("Content-Type")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	h.Set("Content-Length", strconv.Itoa(len(e.Body)))
+	w.WriteHeader(e.Status)
+	w.Write(e.Body)
+}
+
+// etagMatch returns true if the If-None-Match header matches
+// the ETag with the weak comparison.
+func etagMatch(ifNoneMatch, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, v := range strings.Split(ifNoneMatch, ",") {
+		v = strings.TrimSpace(v)
+		if v == "*" || strings.TrimPrefix(v, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// countCache increments the global and the per-route
+// counter of the cache hits or misses.
+func countCache(t *route.Target, result string) {
+	metrics.DefaultRegistry.GetCounter("cache." + result).Inc(1)
+	if t.TimerName != "" {
+		metrics.DefaultRegistry.GetCounter(t.TimerName + ".cache." + result).Inc(1)
+	}
+}
+
+// cacheRecorder records the status, the headers and up to
+// maxBody bytes of the body of a response.
+type cacheRecorder struct {
+	http.ResponseWriter
+	maxBody int
+
+	code   int
+	header http.Header
+	body   bytes.Buffer
+
+	// skip is set if the body is too large for the cache.
+	skip bool
+}
+
+func (rec *cacheRecorder) WriteHeader(code int) {
+	if rec.header == nil && code >= 200 {
+		rec.code = code
+		rec.header = rec.Header().Clone()
+		rec.header.Del("X-Cache")
+		rec.header.Del("Age")
+	}
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *cacheRecorder) Write(b []byte) (int, error) {
+	if rec.header == nil {
+		rec.WriteHeader(http.StatusOK)
+	}
+	if !rec.skip {
+		if rec.body.Len()+len(b) > rec.maxBody {
+			rec.skip = true
+			rec.body = bytes.Buffer{}
+		} else {
+			rec.body.Write(b)
+		}
+	}
+	return rec.ResponseWriter.Write(b)
+}
+
+func (rec *cacheRecorder) Flush() {
+	if fl, ok := rec.ResponseWriter.(http.Flusher); ok {
+		fl.Flush()
+	}
+}
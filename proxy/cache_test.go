@@ -0,0 +1,78 @@
+package proxy
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/fabiolb/fabio/cache"
+	"github.com/fabiolb/fabio/config"
+	"github.com/fabiolb/fabio/route"
+)
+
+func TestProxyCache(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&requests, 1)
+		switch r.URL.Path {
+		case "/private":
+			w.Header().Set("Cache-Control", "private")
+		case "/large":
+			w.Write(bytes.Repeat([]byte("x"), 64))
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte("response " + strconv.Itoa(int(n))))
+	}))
+	defer server.Close()
+
+	proxy := httptest.NewServer(&HTTPProxy{
+		Config:    config.Proxy{Cache: config.Cache{MaxEntry: 32}},
+		Transport: &http.Transport{},
+		Cache:     cache.NewMemoryStore(1024),
+		Lookup: func(r *http.Request) *route.Target {
+			tbl, _ := route.NewTable(bytes.NewBufferString("route add mock / " + server.URL + ` opts "cache=1m cachekey=path"`))
+			return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"], globCache, globEnabled)
+		},
+	})
+	defer proxy.Close()
+
+	get := func(method, path string, header http.Header) (*http.Response, string) {
+		t.Helper()
+		req, _ := http.NewRequest(method, proxy.URL+path, nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		resp, body := mustDo(req)
+		return resp, string(body)
+	}
+	check := func(desc, method, path string, header http.Header, status int, xcache, body string, upstream int32) {
+		t.Helper()
+		atomic.StoreInt32(&requests, 0)
+		resp, gotBody := get(method, path, header)
+		if resp.StatusCode != status || resp.Header.Get("X-Cache") != xcache || gotBody != body {
+			t.Fatalf("%s: got %d %q %q want %d %q %q", desc, resp.StatusCode, resp.Header.Get("X-Cache"), gotBody, status, xcache, body)
+		}
+		if got := atomic.LoadInt32(&requests); got != upstream {
+			t.Fatalf("%s: got %d upstream requests want %d", desc, got, upstream)
+		}
+	}
+
+	check("miss", "GET", "/a?x=1", nil, 200, "MISS", "response 1", 1)
+	check("hit", "GET", "/a?x=2", nil, 200, "HIT", "response 1", 0)
+	check("no-cache request", "GET", "/a", http.Header{"Cache-Control": {"no-cache"}}, 200, "MISS", "response 1", 1)
+	check("etag", "GET", "/a", http.Header{"If-None-Match": {`W/"v1"`}}, 304, "HIT", "", 0)
+	check("post", "POST", "/a", nil, 200, "", "response 1", 1)
+	check("authorization", "GET", "/a", http.Header{"Authorization": {"Bearer x"}}, 200, "", "response 1", 1)
+	check("private", "GET", "/private", nil, 200, "MISS", "response 1", 1)
+	check("private again", "GET", "/private", nil, 200, "MISS", "response 1", 1)
+	check("too large", "GET", "/large", nil, 200, "MISS", string(bytes.Repeat([]byte("x"), 64)), 1)
+	check("too large again", "GET", "/large", nil, 200, "MISS", string(bytes.Repeat([]byte("x"), 64)), 1)
+
+	if resp, _ := get("GET", "/a", nil); resp.Header.Get("Age") == "" {
+		t.Fatal("cached response has no Age header")
+	}
+}
@@ -0,0 +1,24 @@
+package proxy
+
+import (
+	"net/http"
+
+	"github.com/fabiolb/fabio/route"
+)
+
+// corsPreflight answers the CORS preflight requests to targets with the
+// cors options. It returns true if the request has been answered.
+// Preflight requests which are not allowed by the policy are rejected
+// with 403 Forbidden.
+func corsPreflight(w http.ResponseWriter, r *http.Request, c *route.CORS) bool {
+	origin, method := r.Header.Get("Origin"), r.Header.Get("Access-Control-Request-Method")
+	if r.Method != "OPTIONS" || origin == "" || method == "" {
+		return false
+	}
+	if !c.Preflight(origin, method, r.Header.Get("Access-Control-Request-Headers"), w.Header()) {
+		http.Error(w, "cors request not allowed", http.StatusForbidden)
+		return true
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
@@ -0,0 +1,75 @@
+package proxy
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fabiolb/fabio/route"
+)
+
+func TestProxyCORS(t *testing.T) {
+	var upstream int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstream++
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Write([]byte("OK"))
+	}))
+	defer server.Close()
+
+	proxy := httptest.NewServer(&HTTPProxy{
+		Transport: http.DefaultTransport,
+		Lookup: func(r *http.Request) *route.Target {
+			tbl, _ := route.NewTable(bytes.NewBufferString("route add mock / " + server.URL + ` opts "cors-origins=https://app.example.com cors-methods=PUT cors-maxage=10m"`))
+			return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"], globCache, globEnabled)
+		},
+	})
+	defer proxy.Close()
+
+	do := func(method, origin, reqMethod string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(method, proxy.URL+"/", nil)
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		if reqMethod != "" {
+			req.Header.Set("Access-Control-Request-Method", reqMethod)
+		}
+		resp, _ := mustDo(req)
+		return resp
+	}
+
+	tests := []struct {
+		desc                      string
+		method, origin, reqMethod string
+		status                    int
+		allowOrigin, maxAge       string
+		upstream                  int
+	}{
+		{"preflight", "OPTIONS", "https://app.example.com", "PUT", 204, "https://app.example.com", "600", 0},
+		{"preflight other method", "OPTIONS", "https://app.example.com", "DELETE", 403, "", "", 0},
+		{"preflight other origin", "OPTIONS", "https://evil.com", "PUT", 403, "", "", 0},
+		{"options without preflight", "OPTIONS", "", "", 200, "", "", 1},
+		{"request", "GET", "https://app.example.com", "", 200, "https://app.example.com", "", 1},
+		{"request from other origin", "GET", "https://evil.com", "", 200, "", "", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			upstream = 0
+			resp := do(tt.method, tt.origin, tt.reqMethod)
+			if got, want := resp.StatusCode, tt.status; got != want {
+				t.Fatalf("got status %d want %d", got, want)
+			}
+			if got, want := resp.Header.Get("Access-Control-Allow-Origin"), tt.allowOrigin; got != want {
+				t.Fatalf("got allowed origin %q want %q", got, want)
+			}
+			if got, want := resp.Header.Get("Access-Control-Max-Age"), tt.maxAge; got != want {
+				t.Fatalf("got max age %q want %q", got, want)
+			}
+			if got, want := upstream, tt.upstream; got != want {
+				t.Fatalf("got %d upstream requests want %d", got, want)
+			}
+		})
+	}
+}
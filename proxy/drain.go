@@ -0,0 +1,83 @@
+package proxy
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// The phases of draining.
+const (
+	DrainDeregister = "deregister"
+	DrainGrace      = "grace"
+	DrainShutdown   = "shutdown"
+	DrainDone       = "drained"
+)
+
+// DrainStatus describes the progress of draining.
+type DrainStatus struct {
+	Draining bool      `json:"draining"`
+	Phase    string    `json:"phase,omitempty"`
+	Since    time.Time `json:"since"`
+}
+
+var (
+	// drainMu guards drain.
+	drainMu sync.Mutex
+	drain   DrainStatus
+)
+
+// Drain takes fabio out of service without stopping it. It calls
+// deregister to remove fabio from the registry, waits for the grace
+// period so that load balancers which check the health endpoint stop
+// sending new connections and then shuts down the proxy servers. The
+// in-flight requests are served for up to wait. The admin servers keep
+// running. Drain returns immediately and false if fabio is already
+// draining.
+func Drain(grace, wait time.Duration, deregister func()) bool {
+	drainMu.Lock()
+	defer drainMu.Unlock()
+	if drain.Draining {
+		return false
+	}
+	drain = DrainStatus{Draining: true, Phase: DrainDeregister, Since: time.Now().UTC()}
+
+	go func() {
+		log.Print("[INFO] Draining. Deregistering from the registry")
+		if deregister != nil {
+			deregister()
+		}
+
+		setDrainPhase(DrainGrace)
+		log.Printf("[INFO] Draining. Accepting new connections for %s", grace)
+		time.Sleep(grace)
+
+		setDrainPhase(DrainShutdown)
+		log.Printf("[INFO] Draining. Waiting up to %s for in-flight requests", wait)
+		shutdown(wait, false)
+
+		setDrainPhase(DrainDone)
+		log.Print("[INFO] Drained")
+	}()
+	return true
+}
+
+func setDrainPhase(phase string) {
+	drainMu.Lock()
+	drain.Phase = phase
+	drainMu.Unlock()
+}
+
+// Draining returns true if fabio is being or has been drained.
+func Draining() bool {
+	drainMu.Lock()
+	defer drainMu.Unlock()
+	return drain.Draining
+}
+
+// GetDrainStatus returns the progress of draining.
+func GetDrainStatus() DrainStatus {
+	drainMu.Lock()
+	defer drainMu.Unlock()
+	return drain
+}
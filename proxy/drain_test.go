@@ -0,0 +1,111 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fabiolb/fabio/config"
+)
+
+func TestDrain(t *testing.T) {
+	defer func() { drain = DrainStatus{} }()
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	proxyAddr, adminAddr := "127.0.0.1:57781", "127.0.0.1:57782"
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		ListenAndServeHTTP(config.Listen{Addr: proxyAddr}, ok, nil)
+	}()
+	go func() {
+		defer wg.Done()
+		ListenAndServeAdmin(config.Listen{Addr: adminAddr}, ok, nil)
+	}()
+	defer Shutdown(time.Second)
+
+	get := func(addr string) error {
+		resp, err := http.Get("http://" + addr + "/")
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+	waitFor := func(cond func() bool) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for !cond() {
+			if time.Now().After(deadline) {
+				t.Fatal("timeout")
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	waitFor(func() bool { return get(proxyAddr) == nil && get(adminAddr) == nil })
+
+	deregistered := make(chan bool, 1)
+	if !Drain(50*time.Millisecond, time.Second, func() { deregistered <- true }) {
+		t.Fatal("drain not started")
+	}
+	if Drain(0, 0, nil) {
+		t.Fatal("drain started twice")
+	}
+	if !Draining() {
+		t.Fatal("not draining")
+	}
+	<-deregistered
+
+	// new connections are accepted during the grace period
+	if err := get(proxyAddr); err != nil {
+		t.Fatalf("got %v during the grace period", err)
+	}
+
+	waitFor(func() bool { return GetDrainStatus().Phase == DrainDone })
+	if err := get(proxyAddr); err == nil {
+		t.Fatal("proxy still accepts connections")
+	}
+	if err := get(adminAddr); err != nil {
+		t.Fatalf("admin server stopped: %v", err)
+	}
+}
+
+func TestShutdownEndsAdminStreams(t *testing.T) {
+	started := make(chan bool)
+	stream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+		w.(http.Flusher).Flush()
+		started <- true
+		<-r.Context().Done()
+	})
+	adminAddr := "127.0.0.1:57783"
+	go ListenAndServeAdmin(config.Listen{Addr: adminAddr}, stream, nil)
+
+	// keep the stream open until the server has shut down
+	bodies := make(chan io.Closer, 1)
+	go func() {
+		for {
+			resp, err := http.Get("http://" + adminAddr + "/")
+			if err == nil {
+				bodies <- resp.Body
+				return
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}()
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+	}
+	defer func() { (<-bodies).Close() }()
+
+	start := time.Now()
+	Shutdown(5 * time.Second)
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("shutdown took %s", d)
+	}
+}
@@ -0,0 +1,51 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/fabiolb/fabio/noroute"
+	"github.com/fabiolb/fabio/route"
+)
+
+// errorBodyTransport replaces the bodies of the 5xx responses of
+// targets with the errorbody option with a standard error response
+// so that error details like stack traces do not reach the client.
+// The status code of the response is preserved.
+type errorBodyTransport struct {
+	http.RoundTripper
+
+	// target returns the target of the last upstream request.
+	target func() *route.Target
+}
+
+func (et *errorBodyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := et.RoundTripper.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	format := et.target().ErrorBody
+	if format == "" || resp.StatusCode < 500 {
+		return resp, nil
+	}
+
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 4096))
+	resp.Body.Close()
+
+	ctype, body := noroute.ErrorBody(format, req.Header.Get("Accept"), resp.StatusCode)
+	for _, h := range []string{"Content-Encoding", "Content-Language", "Content-Disposition", "Content-Range", "Etag", "Last-Modified"} {
+		resp.Header.Del(h)
+	}
+	if format == "auto" {
+		resp.Header.Add("Vary", "Accept")
+	}
+	resp.Header.Set("Content-Type", ctype)
+	resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.TransferEncoding = nil
+	return resp, nil
+}
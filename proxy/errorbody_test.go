@@ -0,0 +1,77 @@
+package proxy
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/fabiolb/fabio/route"
+)
+
+func TestProxyErrorBody(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status, _ := strconv.Atoi(r.URL.Query().Get("status"))
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Content-Language", "en")
+		w.Header().Set("X-Upstream", "1")
+		w.WriteHeader(status)
+		w.Write([]byte("panic: runtime error\ngoroutine 1 [running]:\nmain.main()"))
+	}))
+	defer server.Close()
+
+	proxy := httptest.NewServer(&HTTPProxy{
+		Transport: http.DefaultTransport,
+		Lookup: func(r *http.Request) *route.Target {
+			tbl, _ := route.NewTable(bytes.NewBufferString(
+				"route add json /json " + server.URL + ` opts "errorbody=json"` + "\n" +
+					"route add auto /auto " + server.URL + ` opts "errorbody=auto"` + "\n" +
+					"route add none /none " + server.URL))
+			return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"], globCache, globEnabled)
+		},
+	})
+	defer proxy.Close()
+
+	const stack = "panic: runtime error\ngoroutine 1 [running]:\nmain.main()"
+	const html = "<!DOCTYPE html>\n<html>\n<head><title>503 Service Unavailable</title></head>\n<body><h1>503 Service Unavailable</h1></body>\n</html>\n"
+
+	tests := []struct {
+		desc, path, accept string
+		status             int
+		ctype, body        string
+	}{
+		{"json", "/json?status=500", "", 500, "application/json; charset=utf-8", `{"status":500,"error":"Internal Server Error"}`},
+		{"auto with json", "/auto?status=502", "application/json", 502, "application/json; charset=utf-8", `{"status":502,"error":"Bad Gateway"}`},
+		{"auto with html", "/auto?status=503", "text/html", 503, "text/html; charset=utf-8", html},
+		{"not an error", "/json?status=404", "", 404, "text/plain", stack},
+		{"no option", "/none?status=500", "", 500, "text/plain", stack},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", proxy.URL+tt.path, nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			resp, body := mustDo(req)
+			if got, want := resp.StatusCode, tt.status; got != want {
+				t.Fatalf("got status %d want %d", got, want)
+			}
+			if got, want := resp.Header.Get("Content-Type"), tt.ctype; got != want {
+				t.Fatalf("got content type %q want %q", got, want)
+			}
+			if got, want := string(body), tt.body; got != want {
+				t.Fatalf("got body %q want %q", got, want)
+			}
+			if got, want := resp.ContentLength, int64(len(tt.body)); got != want {
+				t.Fatalf("got content length %d want %d", got, want)
+			}
+			if got, want := resp.Header.Get("X-Upstream"), "1"; got != want {
+				t.Fatalf("got header %q want %q", got, want)
+			}
+			if tt.ctype != "text/plain" && resp.Header.Get("Content-Language") != "" {
+				t.Fatal("got Content-Language want none")
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package proxy
+
+import (
+	"log"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fabiolb/fabio/metrics"
+)
+
+// Fault delays or aborts a percentage of the HTTP requests to a
+// service to test how the clients handle a slow or failing service.
+type Fault struct {
+	Service string
+
+	// Delay is the time the requests are delayed before
+	// they are forwarded or aborted.
+	Delay time.Duration
+
+	// Abort is the status code of the response to the aborted
+	// requests. If it is zero the requests are forwarded.
+	Abort int
+
+	// Percent is the percentage of the requests which are
+	// delayed or aborted.
+	Percent float64
+}
+
+var (
+	// faultMu guards faults.
+	faultMu sync.Mutex
+	faults  = map[string]Fault{}
+
+	// sample returns a random number in [0,100) which
+	// selects the requests for faults and mirroring.
+	sample = func() float64 { return rand.Float64() * 100 }
+)
+
+// SetFault enables the fault injection for f.Service and replaces
+// the fault which is already enabled for the service.
+func SetFault(f Fault) {
+	faultMu.Lock()
+	faults[f.Service] = f
+	faultMu.Unlock()
+	log.Printf("[INFO] Fault for service %q enabled for %g%% of the requests with delay %s and abort status %d", f.Service, f.Percent, f.Delay, f.Abort)
+}
+
+// ClearFault disables the fault injection for the service.
+func ClearFault(service string) {
+	faultMu.Lock()
+	_, ok := faults[service]
+	delete(faults, service)
+	faultMu.Unlock()
+	if ok {
+		log.Printf("[INFO] Fault for service %q disabled", service)
+	}
+}
+
+// injectFault delays the request if a fault is enabled for the
+// service and selects the request. It writes the response and
+// returns true if the request is aborted.
+func injectFault(w http.ResponseWriter, r *http.Request, service string) bool {
+	faultMu.Lock()
+	f, ok := faults[service]
+	faultMu.Unlock()
+	if !ok || sample() >= f.Percent {
+		return false
+	}
+
+	metrics.DefaultRegistry.GetCounter("fault." + strings.Replace(service, ".", "_", -1)).Inc(1)
+	if f.Delay > 0 {
+		t := time.NewTimer(f.Delay)
+		select {
+		case <-t.C:
+		case <-r.Context().Done():
+			t.Stop()
+			return true
+		}
+	}
+	if f.Abort == 0 {
+		return false
+	}
+	http.Error(w, http.StatusText(f.Abort), f.Abort)
+	return true
+}
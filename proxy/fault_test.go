@@ -0,0 +1,77 @@
+package proxy
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fabiolb/fabio/route"
+)
+
+func TestFault(t *testing.T) {
+	defer func(f func() float64) { sample = f }(sample)
+	defer func() { faults = map[string]Fault{} }()
+	sample = func() float64 { return 50 }
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	}))
+	defer server.Close()
+
+	proxy := httptest.NewServer(&HTTPProxy{
+		Transport: http.DefaultTransport,
+		Lookup: func(r *http.Request) *route.Target {
+			tbl, _ := route.NewTable(bytes.NewBufferString("route add foo /foo " + server.URL))
+			return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"], globCache, globEnabled)
+		},
+	})
+	defer proxy.Close()
+
+	get := func() (int, string, time.Duration) {
+		t.Helper()
+		start := time.Now()
+		resp, err := http.Get(proxy.URL + "/foo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(body), time.Since(start)
+	}
+
+	tests := []struct {
+		desc  string
+		fault *Fault
+		code  int
+		body  string
+		delay time.Duration
+	}{
+		{"no fault", nil, 200, "OK", 0},
+		{"abort", &Fault{Service: "foo", Abort: 503, Percent: 100}, 503, "Service Unavailable\n", 0},
+		{"delay", &Fault{Service: "foo", Delay: 50 * time.Millisecond, Percent: 100}, 200, "OK", 50 * time.Millisecond},
+		{"not selected", &Fault{Service: "foo", Abort: 503, Percent: 50}, 200, "OK", 0},
+		{"other service", &Fault{Service: "bar", Abort: 503, Percent: 100}, 200, "OK", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			ClearFault("foo")
+			ClearFault("bar")
+			if tt.fault != nil {
+				SetFault(*tt.fault)
+			}
+			code, body, d := get()
+			if got, want := code, tt.code; got != want {
+				t.Fatalf("got status %d want %d", got, want)
+			}
+			if got, want := body, tt.body; got != want {
+				t.Fatalf("got body %q want %q", got, want)
+			}
+			if got, want := d, tt.delay; got < want {
+				t.Fatalf("got delay %s want at least %s", got, want)
+			}
+		})
+	}
+}
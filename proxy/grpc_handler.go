@@ -14,8 +14,11 @@ import (
 
 	"github.com/fabiolb/fabio/config"
 	"github.com/fabiolb/fabio/metrics"
+	"github.com/fabiolb/fabio/proxy/sockopt"
 	"github.com/fabiolb/fabio/route"
+	"github.com/fabiolb/fabio/trace"
 	grpc_proxy "github.com/mwitkow/grpc-proxy/proxy"
+	"github.com/opentracing/opentracing-go/ext"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/connectivity"
@@ -75,6 +78,18 @@ type GrpcProxyInterceptor struct {
 	Config       *config.Config
 	StatsHandler *GrpcStatsHandler
 	GlobCache    *route.GlobCache
+
+	// CurrentConfig returns the running configuration with the
+	// reloaded strategy and matcher. If it is nil Config is used.
+	CurrentConfig func() *config.Config
+}
+
+// currentConfig returns the running configuration.
+func (g GrpcProxyInterceptor) currentConfig() *config.Config {
+	if g.CurrentConfig != nil {
+		return g.CurrentConfig()
+	}
+	return g.Config
 }
 
 type targetKey struct{}
@@ -104,6 +119,18 @@ func (g GrpcProxyInterceptor) Stream(srv interface{}, stream grpc.ServerStream,
 		return status.Error(codes.NotFound, "no route found")
 	}
 
+	span, ctx := trace.CreateGRPCSpan(ctx, info.FullMethod)
+	defer span.Finish()
+
+	// the gRPC server sets the deadline of the grpc-timeout header
+	if _, ok := ctx.Deadline(); ok && honorsGRPCTimeout(g.Config.Proxy.TimeoutHeaders) {
+		if max := maxTimeout(target, g.Config.Proxy.TimeoutMax); max > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, max)
+			defer cancel()
+		}
+	}
+
 	ctx = context.WithValue(ctx, targetKey{}, target)
 
 	proxyStream := proxyStream{
@@ -120,12 +147,18 @@ func (g GrpcProxyInterceptor) Stream(srv interface{}, stream grpc.ServerStream,
 
 	target.Timer.Update(dur)
 
+	if err != nil {
+		ext.Error.Set(span, true)
+		span.SetTag("grpc.code", status.Code(err).String())
+	}
+
 	return err
 }
 
 func (g GrpcProxyInterceptor) lookup(ctx context.Context, fullMethodName string) (*route.Target, error) {
-	pick := route.Picker[g.Config.Proxy.Strategy]
-	match := route.Matcher[g.Config.Proxy.Matcher]
+	cfg := g.currentConfig()
+	pick := route.Picker[cfg.Proxy.Strategy]
+	match := route.Matcher[cfg.Proxy.Matcher]
 
 	md, ok := metadata.FromIncomingContext(ctx)
 
@@ -154,7 +187,24 @@ func (g GrpcProxyInterceptor) lookup(ctx context.Context, fullMethodName string)
 		Header: headers,
 	}
 
-	return route.GetTable().Lookup(req, req.Header.Get("trace"), pick, match, g.GlobCache, g.Config.GlobMatchingDisabled), nil
+	return route.GetTable().Lookup(req, route.TraceID(req), pick, match, g.GlobCache, g.Config.GlobMatchingDisabled), nil
+}
+
+// LookupHTTP returns the target for the gRPC method of an HTTP request
+// like the CORS preflight of a gRPC-Web request. Like gRPC requests the
+// request is matched without the host.
+func (g GrpcProxyInterceptor) LookupHTTP(r *http.Request) *route.Target {
+	cfg := g.currentConfig()
+	pick := route.Picker[cfg.Proxy.Strategy]
+	match := route.Matcher[cfg.Proxy.Matcher]
+
+	req := &http.Request{
+		Host:   "",
+		URL:    &url.URL{Path: r.URL.Path},
+		Header: r.Header,
+	}
+
+	return route.GetTable().Lookup(req, route.TraceID(req), pick, match, g.GlobCache, g.Config.GlobMatchingDisabled)
 }
 
 type GrpcStatsHandler struct {
@@ -235,20 +285,19 @@ func (p *grpcConnectionPool) newConnection(ctx context.Context, target *route.Ta
 		grpc.WithDefaultCallOptions(grpc.CallCustomCodec(grpc_proxy.Codec())),
 	}
 
-	if target.URL.Scheme == "grpcs" && p.tlscfg != nil {
-		opts = append(opts, grpc.WithTransportCredentials(
-			credentials.NewTLS(&tls.Config{
-				ClientCAs:          p.tlscfg.ClientCAs,
-				InsecureSkipVerify: target.TLSSkipVerify,
-				// as per the http/2 spec, the host header isn't required, so if your
-				// target service doesn't have IP SANs in it's certificate
-				// then you will need to override the servername
-				ServerName: target.Opts["grpcservername"],
-			})))
+	if target.URL.Scheme == "grpcs" {
+		opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(p.tlsConfig(target))))
 	} else {
 		opts = append(opts, grpc.WithInsecure())
 	}
 
+	if target.DSCP > 0 {
+		d := &net.Dialer{Control: sockopt.Control(config.SocketOpts{TOS: target.DSCP << 2})}
+		opts = append(opts, grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+			return d.DialContext(ctx, "tcp", addr)
+		}))
+	}
+
 	conn, err := grpc.DialContext(ctx, target.URL.Host, opts...)
 
 	if err == nil {
@@ -258,6 +307,34 @@ func (p *grpcConnectionPool) newConnection(ctx context.Context, target *route.Ta
 	return conn, err
 }
 
+// tlsConfig returns the TLS configuration for the connections to
+// a grpcs target.
+func (p *grpcConnectionPool) tlsConfig(target *route.Target) *tls.Config {
+	cfg := &tls.Config{InsecureSkipVerify: target.TLSSkipVerify}
+	if p.tlscfg != nil {
+		cfg.ClientCAs = p.tlscfg.ClientCAs
+	}
+
+	g := target.GRPCTLS
+	if g == nil {
+		return cfg
+	}
+	// as per the http/2 spec, the host header isn't required, so if your
+	// target service doesn't have IP SANs in it's certificate
+	// then you will need to override the servername
+	cfg.ServerName = g.ServerName
+	cfg.RootCAs = g.RootCAs
+	if g.RequireALPN {
+		cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			if cs.NegotiatedProtocol != "h2" {
+				return fmt.Errorf("grpc: %s did not negotiate h2 with ALPN", target.URL.Host)
+			}
+			return nil
+		}
+	}
+	return cfg
+}
+
 func (p *grpcConnectionPool) Set(target *route.Target, conn *grpc.ClientConn) {
 	p.lock.Lock()
 	defer p.lock.Unlock()
@@ -0,0 +1,112 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/tls"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fabiolb/fabio/config"
+	"github.com/fabiolb/fabio/proxy/internal"
+	"github.com/fabiolb/fabio/route"
+)
+
+func TestGRPCTLSConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fabio")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ca := filepath.Join(dir, "ca.pem")
+	if err := ioutil.WriteFile(ca, internal.LocalhostCert, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := tls.X509KeyPair(internal.LocalhostCert, internal.LocalhostKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listen := func(protos ...string) net.Listener {
+		l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}, NextProtos: protos})
+		if err != nil {
+			t.Fatal(err)
+		}
+		go func() {
+			for {
+				c, err := l.Accept()
+				if err != nil {
+					return
+				}
+				c.(*tls.Conn).Handshake()
+				c.Close()
+			}
+		}()
+		return l
+	}
+	h2, noALPN := listen("h2"), listen()
+	defer h2.Close()
+	defer noALPN.Close()
+
+	tests := []struct {
+		desc, opts string
+		l          net.Listener
+		ok         bool
+	}{
+		{"unknown CA", "", h2, false},
+		{"root CA", "grpcrootca=" + ca, h2, true},
+		{"server name", "grpcrootca=" + ca + " grpcservername=example.com", h2, true},
+		{"wrong server name", "grpcrootca=" + ca + " grpcservername=example.org", h2, false},
+		{"skip verify", "tlsskipverify=true", h2, true},
+		{"optional alpn", "grpcrootca=" + ca, noALPN, true},
+		{"required alpn", "grpcrootca=" + ca + " grpcalpn=required", h2, true},
+		{"missing alpn", "grpcrootca=" + ca + " grpcalpn=required", noALPN, false},
+	}
+
+	p := &grpcConnectionPool{}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			addr := tt.l.Addr().String()
+			tbl, err := route.NewTable(bytes.NewBufferString("route add svc / grpcs://" + addr + ` opts "proto=grpcs ` + tt.opts + `"`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			target := tbl[""][0].Targets[0]
+
+			cfg := p.tlsConfig(target)
+			cfg.NextProtos = []string{"h2"}
+			c, err := tls.Dial("tcp", addr, cfg)
+			if got, want := err == nil, tt.ok; got != want {
+				t.Fatalf("got error %v want ok %v", err, want)
+			}
+			if c != nil {
+				c.Close()
+			}
+		})
+	}
+}
+
+func TestGRPCLookupCurrentConfig(t *testing.T) {
+	tbl, err := route.NewTable(bytes.NewBufferString("route add svc /Foo http://1.2.3.4/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	route.SetTable(tbl)
+	defer route.SetTable(make(route.Table))
+
+	cfg := &config.Config{Proxy: config.Proxy{Strategy: "rr", Matcher: "prefix"}}
+	cur := &config.Config{Proxy: config.Proxy{Strategy: "rr", Matcher: "iprefix"}}
+	g := GrpcProxyInterceptor{Config: cfg, GlobCache: route.NewGlobCache(10)}
+	r, _ := http.NewRequest("POST", "http://example.com/foo.Service/Method", nil)
+
+	if got := g.LookupHTTP(r); got != nil {
+		t.Fatalf("got target %v want nil", got.URL)
+	}
+	g.CurrentConfig = func() *config.Config { return cur }
+	if got := g.LookupHTTP(r); got == nil {
+		t.Fatal("got nil want target of the reloaded matcher")
+	}
+}
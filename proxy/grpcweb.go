@@ -0,0 +1,346 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fabiolb/fabio/metrics"
+	"github.com/fabiolb/fabio/route"
+	"golang.org/x/net/http2"
+	"google.golang.org/grpc"
+)
+
+// Content types of gRPC-Web requests. The text variant encodes the
+// request and response bodies in base64 for clients which cannot
+// handle binary streams.
+const (
+	grpcWebContentType     = "application/grpc-web"
+	grpcWebTextContentType = "application/grpc-web-text"
+)
+
+// grpcWebTrailerFlag marks the frame in a gRPC-Web response body
+// which contains the trailers of the gRPC response.
+const grpcWebTrailerFlag = 0x80
+
+// gRPCWebServer serves gRPC-Web and native gRPC requests on the same
+// listener. The gRPC requests are handled by the gRPC server through
+// its HTTP handler since the native gRPC transport cannot serve
+// HTTP/1.1 requests from browsers.
+type gRPCWebServer struct {
+	http *http.Server
+	grpc *grpc.Server
+}
+
+func (s *gRPCWebServer) Serve(ln net.Listener) error {
+	return s.http.Serve(ln)
+}
+
+func (s *gRPCWebServer) Close() error {
+	err := s.http.Close()
+	s.grpc.Stop()
+	return err
+}
+
+// Shutdown waits for the active requests until the context is done.
+// GracefulStop of the gRPC server does not support streams which are
+// served through its HTTP handler and therefore the remaining streams
+// are cancelled afterwards.
+func (s *gRPCWebServer) Shutdown(ctx context.Context) error {
+	err := s.http.Shutdown(ctx)
+	s.grpc.Stop()
+	return err
+}
+
+// newGRPCWebHandler returns a handler which translates gRPC-Web requests
+// into gRPC requests for the gRPC server and passes native gRPC requests
+// over HTTP/2 to the gRPC server unchanged. CORS preflight requests are
+// answered with the cors option of the route. lookup returns the target
+// for the request or nil.
+func newGRPCWebHandler(srv *grpc.Server, lookup func(*http.Request) *route.Target) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctype := r.Header.Get("Content-Type")
+		switch {
+		case r.Method == "OPTIONS":
+			if t := lookup(r); t != nil && t.CORS != nil && corsPreflight(w, r, t.CORS) {
+				return
+			}
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+
+		case r.Method == "POST" && strings.HasPrefix(ctype, grpcWebContentType):
+			var cors *route.CORS
+			if r.Header.Get("Origin") != "" {
+				if t := lookup(r); t != nil {
+					cors = t.CORS
+				}
+			}
+			metrics.DefaultRegistry.GetCounter("grpc.web.requests").Inc(1)
+			serveGRPCWeb(w, r, srv, cors)
+
+		case r.ProtoMajor == 2 && strings.HasPrefix(ctype, "application/grpc"):
+			srv.ServeHTTP(w, r)
+
+		default:
+			http.Error(w, http.StatusText(http.StatusUnsupportedMediaType), http.StatusUnsupportedMediaType)
+		}
+	})
+}
+
+// serveGRPCWeb translates the gRPC-Web request into a gRPC request for
+// the gRPC server and the gRPC response into a gRPC-Web response. The
+// messages are framed the same way in both protocols but gRPC-Web
+// sends the trailers as the last frame of the response body.
+func serveGRPCWeb(w http.ResponseWriter, r *http.Request, srv *grpc.Server, cors *route.CORS) {
+	ctype := r.Header.Get("Content-Type")
+	text := strings.HasPrefix(ctype, grpcWebTextContentType)
+	var subtype string
+	if text {
+		subtype = strings.TrimPrefix(ctype, grpcWebTextContentType)
+	} else {
+		subtype = strings.TrimPrefix(ctype, grpcWebContentType)
+	}
+
+	req := r.WithContext(r.Context())
+	req.ProtoMajor, req.ProtoMinor, req.Proto = 2, 0, "HTTP/2.0"
+	req.Header = r.Header.Clone()
+	req.Header.Set("Content-Type", "application/grpc"+subtype)
+	req.Header.Del("Content-Length")
+	req.ContentLength = -1
+	if text {
+		req.Body = &grpcWebTextBody{r: bufio.NewReader(r.Body), ReadCloser: r.Body}
+	}
+
+	rw := &grpcWebResponseWriter{w: w, header: http.Header{}, ctype: ctype, text: text}
+	if cors != nil {
+		origin := r.Header.Get("Origin")
+		rw.rewrite = func(h http.Header) { cors.Apply(origin, h) }
+	}
+	srv.ServeHTTP(rw, req)
+	rw.finish()
+}
+
+// grpcWebResponseWriter converts the gRPC response of the gRPC server
+// into a gRPC-Web response.
+type grpcWebResponseWriter struct {
+	w http.ResponseWriter
+
+	// header is the header of the gRPC response which also contains
+	// the trailers after the gRPC server has written the status.
+	header http.Header
+
+	// ctype is the content type of the gRPC-Web response.
+	ctype string
+
+	// text is true if the response body is encoded in base64.
+	text bool
+
+	// rewrite modifies the header of the gRPC-Web response.
+	rewrite func(http.Header)
+
+	code int
+}
+
+func (rw *grpcWebResponseWriter) Header() http.Header {
+	return rw.header
+}
+
+func (rw *grpcWebResponseWriter) WriteHeader(code int) {
+	if rw.code != 0 {
+		return
+	}
+	rw.code = code
+
+	trailers := rw.trailerNames()
+	h := rw.w.Header()
+	for k, v := range rw.header {
+		if k == "Trailer" || trailers[k] || strings.HasPrefix(k, http2.TrailerPrefix) {
+			continue
+		}
+		h[k] = v
+	}
+	h.Del("Content-Length")
+	if code == http.StatusOK {
+		h.Set("Content-Type", rw.ctype)
+	}
+	if rw.rewrite != nil {
+		rw.rewrite(h)
+	}
+	rw.w.WriteHeader(code)
+}
+
+func (rw *grpcWebResponseWriter) Write(p []byte) (int, error) {
+	if rw.code == 0 {
+		rw.WriteHeader(http.StatusOK)
+	}
+	if !rw.text {
+		return rw.w.Write(p)
+	}
+	// the base64 encoded chunks are padded separately
+	// which the gRPC-Web clients support.
+	b := make([]byte, base64.StdEncoding.EncodedLen(len(p)))
+	base64.StdEncoding.Encode(b, p)
+	if _, err := rw.w.Write(b); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (rw *grpcWebResponseWriter) Flush() {
+	if rw.code == 0 {
+		rw.WriteHeader(http.StatusOK)
+	}
+	if f, ok := rw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// finish writes the trailers of the gRPC response as the last frame
+// of the gRPC-Web response. Error responses of the gRPC server which
+// are not gRPC responses are passed through.
+func (rw *grpcWebResponseWriter) finish() {
+	if rw.code == 0 {
+		rw.WriteHeader(http.StatusOK)
+	}
+	if rw.code != http.StatusOK {
+		return
+	}
+	b := rw.trailers()
+	frame := make([]byte, 5, 5+len(b))
+	frame[0] = grpcWebTrailerFlag
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(b)))
+	rw.Write(append(frame, b...))
+	rw.Flush()
+}
+
+// trailerNames returns the canonical names of the announced trailers.
+func (rw *grpcWebResponseWriter) trailerNames() map[string]bool {
+	names := map[string]bool{}
+	for _, v := range rw.header["Trailer"] {
+		for _, k := range strings.Split(v, ",") {
+			names[http.CanonicalHeaderKey(strings.TrimSpace(k))] = true
+		}
+	}
+	return names
+}
+
+// trailers returns the trailers of the gRPC response in the format of
+// an HTTP/1 header block with lower case names.
+func (rw *grpcWebResponseWriter) trailers() []byte {
+	t := map[string][]string{}
+	for k := range rw.trailerNames() {
+		if v := rw.header[k]; len(v) > 0 && v[0] != "" {
+			t[strings.ToLower(k)] = v
+		}
+	}
+	for k, v := range rw.header {
+		if strings.HasPrefix(k, http2.TrailerPrefix) {
+			k = strings.ToLower(strings.TrimPrefix(k, http2.TrailerPrefix))
+			t[k] = append(t[k], v...)
+		}
+	}
+
+	var keys []string
+	for k := range t {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b bytes.Buffer
+	for _, k := range keys {
+		for _, v := range t[k] {
+			b.WriteString(k + ": " + v + "\r\n")
+		}
+	}
+	return b.Bytes()
+}
+
+// grpcWebTextBody decodes a base64 encoded request body. The clients
+// may send the body in separately padded chunks and therefore the body
+// is decoded in blocks of four characters.
+type grpcWebTextBody struct {
+	io.ReadCloser
+	r *bufio.Reader
+
+	quantum [4]byte
+	buf     [3]byte
+	out     []byte
+	err     error
+}
+
+func (b *grpcWebTextBody) Read(p []byte) (int, error) {
+	for len(b.out) == 0 {
+		if b.err != nil {
+			return 0, b.err
+		}
+		if _, err := io.ReadFull(b.r, b.quantum[:]); err != nil {
+			b.err = err
+			continue
+		}
+		n, err := base64.StdEncoding.Decode(b.buf[:], b.quantum[:])
+		if err != nil {
+			b.err = err
+			continue
+		}
+		b.out = b.buf[:n]
+	}
+	n := copy(p, b.out)
+	b.out = b.out[n:]
+	return n, nil
+}
+
+// h2cHandler serves HTTP/2 connections with prior knowledge on a
+// listener without TLS which native gRPC clients use. The HTTP/1 server
+// reads the first line of the client preface as a PRI request which is
+// hijacked and handed to the HTTP/2 server together with the preface.
+func h2cHandler(h http.Handler, h2s *http2.Server, srv *http.Server) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PRI" || r.RequestURI != "*" || r.ProtoMajor != 2 {
+			h.ServeHTTP(w, r)
+			return
+		}
+		hj, ok := w.(http.Hijacker)
+		if !ok {
+			http.Error(w, "not a hijacker", http.StatusInternalServerError)
+			return
+		}
+		conn, rw, err := hj.Hijack()
+		if err != nil {
+			log.Printf("[ERROR] grpc: Hijack error for h2c connection. %s", err)
+			return
+		}
+
+		// the request line and the empty line are the first part of
+		// the preface and the HTTP/1 server did not read the rest.
+		const rest = "SM\r\n\r\n"
+		b := make([]byte, len(rest))
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		if _, err := io.ReadFull(rw, b); err != nil || string(b) != rest {
+			conn.Close()
+			return
+		}
+		conn.SetDeadline(time.Time{})
+
+		c := &prefaceConn{Conn: conn, r: io.MultiReader(strings.NewReader(http2.ClientPreface), rw)}
+		h2s.ServeConn(c, &http2.ServeConnOpts{Handler: h, BaseConfig: srv})
+	})
+}
+
+// prefaceConn replays the client preface of a hijacked
+// connection before the remaining data.
+type prefaceConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *prefaceConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
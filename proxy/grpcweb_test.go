@@ -0,0 +1,175 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fabiolb/fabio/internal/rawpb"
+	"github.com/fabiolb/fabio/route"
+	"golang.org/x/net/http2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// newGRPCEchoServer returns a gRPC server which echoes the messages
+// of all methods except for /test.Echo/Fail which returns an error.
+func newGRPCEchoServer() *grpc.Server {
+	return grpc.NewServer(
+		grpc.CustomCodec(rawpb.Codec{}),
+		grpc.UnknownServiceHandler(func(srv interface{}, stream grpc.ServerStream) error {
+			if m, _ := grpc.MethodFromServerStream(stream); m == "/test.Echo/Fail" {
+				return status.Error(codes.NotFound, "nope")
+			}
+			var msg []byte
+			if err := stream.RecvMsg(&msg); err != nil {
+				return err
+			}
+			return stream.SendMsg(&msg)
+		}),
+	)
+}
+
+func grpcFrame(flag byte, msg string) []byte {
+	b := make([]byte, 5, 5+len(msg))
+	b[0] = flag
+	binary.BigEndian.PutUint32(b[1:], uint32(len(msg)))
+	return append(b, msg...)
+}
+
+func TestGRPCWeb(t *testing.T) {
+	gs := newGRPCEchoServer()
+	defer gs.Stop()
+
+	tbl, err := route.NewTable(bytes.NewBufferString(`route add svc /test.Echo/ grpc://127.0.0.1:1 opts "proto=grpc cors-origins=https://app.example.com cors-headers=* cors-expose=grpc-status"`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lookup := func(r *http.Request) *route.Target {
+		return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"], globCache, globEnabled)
+	}
+	server := httptest.NewServer(newGRPCWebHandler(gs, lookup))
+	defer server.Close()
+
+	post := func(t *testing.T, path, ctype string, body []byte) (*http.Response, []byte) {
+		t.Helper()
+		req, _ := http.NewRequest("POST", server.URL+path, bytes.NewReader(body))
+		req.Header.Set("Content-Type", ctype)
+		req.Header.Set("Origin", "https://app.example.com")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp, b
+	}
+
+	ok := append(grpcFrame(0, "hello"), grpcFrame(grpcWebTrailerFlag, "grpc-status: 0\r\n")...)
+	fail := grpcFrame(grpcWebTrailerFlag, "grpc-message: nope\r\ngrpc-status: 5\r\n")
+
+	t.Run("binary", func(t *testing.T) {
+		resp, body := post(t, "/test.Echo/Say", "application/grpc-web+proto", grpcFrame(0, "hello"))
+		if got, want := resp.Header.Get("Content-Type"), "application/grpc-web+proto"; got != want {
+			t.Fatalf("got content type %q want %q", got, want)
+		}
+		if got, want := resp.Header.Get("Access-Control-Allow-Origin"), "https://app.example.com"; got != want {
+			t.Fatalf("got allow origin %q want %q", got, want)
+		}
+		if got, want := body, ok; !bytes.Equal(got, want) {
+			t.Fatalf("got body %q want %q", got, want)
+		}
+	})
+
+	t.Run("text", func(t *testing.T) {
+		// the request is sent in two separately padded chunks
+		msg := grpcFrame(0, "hello")
+		req := base64.StdEncoding.EncodeToString(msg[:4]) + base64.StdEncoding.EncodeToString(msg[4:])
+		resp, body := post(t, "/test.Echo/Say", "application/grpc-web-text", []byte(req))
+		if got, want := resp.Header.Get("Content-Type"), "application/grpc-web-text"; got != want {
+			t.Fatalf("got content type %q want %q", got, want)
+		}
+		b, err := ioutil.ReadAll(&grpcWebTextBody{r: bufio.NewReader(bytes.NewReader(body))})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := b, ok; !bytes.Equal(got, want) {
+			t.Fatalf("got body %q want %q", got, want)
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		resp, body := post(t, "/test.Echo/Fail", "application/grpc-web", grpcFrame(0, "hello"))
+		if got, want := resp.StatusCode, 200; got != want {
+			t.Fatalf("got status %d want %d", got, want)
+		}
+		if got, want := body, fail; !bytes.Equal(got, want) {
+			t.Fatalf("got body %q want %q", got, want)
+		}
+	})
+
+	t.Run("preflight", func(t *testing.T) {
+		req, _ := http.NewRequest("OPTIONS", server.URL+"/test.Echo/Say", nil)
+		req.Header.Set("Origin", "https://app.example.com")
+		req.Header.Set("Access-Control-Request-Method", "POST")
+		req.Header.Set("Access-Control-Request-Headers", "content-type,x-grpc-web")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if got, want := resp.StatusCode, http.StatusNoContent; got != want {
+			t.Fatalf("got status %d want %d", got, want)
+		}
+	})
+
+	t.Run("unsupported content type", func(t *testing.T) {
+		resp, _ := post(t, "/test.Echo/Say", "application/json", []byte("{}"))
+		if got, want := resp.StatusCode, http.StatusUnsupportedMediaType; got != want {
+			t.Fatalf("got status %d want %d", got, want)
+		}
+	})
+}
+
+func TestGRPCWebH2C(t *testing.T) {
+	gs := newGRPCEchoServer()
+	defer gs.Stop()
+
+	server := httptest.NewUnstartedServer(nil)
+	server.Config.Handler = h2cHandler(newGRPCWebHandler(gs, func(*http.Request) *route.Target { return nil }), &http2.Server{}, server.Config)
+	server.Start()
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := grpc.DialContext(ctx, strings.TrimPrefix(server.URL, "http://"), grpc.WithInsecure(), grpc.WithBlock(), grpc.WithDefaultCallOptions(grpc.ForceCodec(rawpb.Codec{})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	in, out := []byte("hello"), []byte(nil)
+	if err := conn.Invoke(ctx, "/test.Echo/Say", &in, &out); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(out), "hello"; got != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+
+	err = conn.Invoke(ctx, "/test.Echo/Fail", &in, &out)
+	if got, want := status.Code(err), codes.NotFound; got != want {
+		t.Fatalf("got code %v want %v", got, want)
+	}
+}
@@ -15,6 +15,7 @@ import (
 	"net"
 	"net/http"
 	"regexp"
+	"strconv"
 	"strings"
 	"sync"
 )
@@ -39,11 +40,19 @@ var gzipWriterPool = sync.Pool{
 // body if the client supports it (via the Accept-Encoding header) and the
 // response Content-Type matches the contentTypes expression.
 func NewGzipHandler(h http.Handler, contentTypes *regexp.Regexp) http.Handler {
+	return NewGzipHandlerMinSize(h, contentTypes, 0)
+}
+
+// NewGzipHandlerMinSize is like NewGzipHandler but does not compress
+// responses with a Content-Length below minSize bytes. Responses
+// without a Content-Length are always compressed.
+func NewGzipHandlerMinSize(h http.Handler, contentTypes *regexp.Regexp, minSize int64) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Add(headerVary, headerAcceptEncoding)
 
 		if acceptsGzip(r) {
 			gzWriter := NewGzipResponseWriter(w, contentTypes)
+			gzWriter.minSize = minSize
 			defer gzWriter.Close()
 			h.ServeHTTP(gzWriter, r)
 		} else {
@@ -56,6 +65,7 @@ type GzipResponseWriter struct {
 	writer       io.Writer
 	gzipWriter   *gzip.Writer
 	contentTypes *regexp.Regexp
+	minSize      int64
 	http.ResponseWriter
 }
 
@@ -64,8 +74,14 @@ func NewGzipResponseWriter(w http.ResponseWriter, contentTypes *regexp.Regexp) *
 }
 
 func (grw *GzipResponseWriter) WriteHeader(code int) {
+	// informational responses are followed by the final response
+	// which determines whether the body is compressed.
+	if code >= 100 && code < 200 && code != http.StatusSwitchingProtocols {
+		grw.ResponseWriter.WriteHeader(code)
+		return
+	}
 	if grw.writer == nil {
-		if isCompressable(grw.Header(), grw.contentTypes) {
+		if isCompressable(grw.Header(), grw.contentTypes) && !tooSmall(grw.Header(), grw.minSize) {
 			grw.Header().Del(headerContentLength)
 			grw.Header().Set(headerContentEncoding, encodingGzip)
 			grw.gzipWriter = gzipWriterPool.Get().(*gzip.Writer)
@@ -112,6 +128,16 @@ func isCompressable(header http.Header, contentTypes *regexp.Regexp) bool {
 	return contentTypes.MatchString(header.Get(headerContentType))
 }
 
+// tooSmall returns true if the Content-Length of the
+// response is below minSize.
+func tooSmall(header http.Header, minSize int64) bool {
+	if minSize <= 0 {
+		return false
+	}
+	n, err := strconv.ParseInt(header.Get(headerContentLength), 10, 64)
+	return err == nil && n < minSize
+}
+
 func acceptsGzip(r *http.Request) bool {
 	accept := r.Header.Get(headerAccept)
 	for _, contentType := range blacklistedAcceptContentTypes {
@@ -109,6 +109,32 @@ func Test_GzipHandler_NonCompressableType(t *testing.T) {
 	assertEqual(bytes, []byte{42})
 }
 
+func Test_GzipHandler_MinSize(t *testing.T) {
+	assertEqual := assert.Equal(t)
+
+	for _, tt := range []struct {
+		minSize  int64
+		encoding string
+	}{
+		{0, "gzip"},
+		{11, "gzip"},
+		{12, ""},
+	} {
+		server := httptest.NewServer(NewGzipHandlerMinSize(test_text_handler(), contentTypes, tt.minSize))
+
+		r, err := http.NewRequest("GET", server.URL, nil)
+		assertEqual(err, nil)
+		r.Header.Set("Accept-Encoding", "gzip")
+
+		resp, err := http.DefaultClient.Do(r)
+		assertEqual(err, nil)
+		resp.Body.Close()
+		server.Close()
+
+		assertEqual(resp.Header.Get("Content-Encoding"), tt.encoding)
+	}
+}
+
 func test_text_handler() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		b := []byte("Hello World")
@@ -0,0 +1,252 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The environment variables which pass the listening sockets and the
+// pipe for the readiness notification to the new process on upgrade.
+const (
+	listenFDsEnv = "FABIO_LISTEN_FDS"
+	readyFDEnv   = "FABIO_READY_FD"
+)
+
+// socket is a listener or packet conn whose file
+// descriptor can be passed to another process.
+type socket interface {
+	File() (*os.File, error)
+}
+
+var (
+	// hmu guards the fields below.
+	hmu sync.Mutex
+
+	// inherited contains the sockets which were passed by the
+	// previous process and which have not been claimed yet. The
+	// keys are the network and the address, e.g. 'tcp::9999'.
+	inherited map[string]*os.File
+
+	// ready is the pipe for the readiness notification
+	// of the previous process.
+	ready *os.File
+
+	// sockets contains the sockets of the listeners which are
+	// passed to the new process on upgrade.
+	sockets = map[string]socket{}
+
+	// upgrading is true while a new process is started.
+	upgrading bool
+)
+
+func init() {
+	var err error
+	inherited, ready, err = parseHandover(os.Getenv(listenFDsEnv), os.Getenv(readyFDEnv))
+	if err != nil {
+		log.Printf("[WARN] Ignoring inherited listeners. %s", err)
+	}
+	os.Unsetenv(listenFDsEnv)
+	os.Unsetenv(readyFDEnv)
+}
+
+// parseHandover returns the sockets and the readiness pipe
+// from the environment variables set by the previous process.
+func parseHandover(fds, readyFD string) (map[string]*os.File, *os.File, error) {
+	files := map[string]*os.File{}
+	var r *os.File
+	if readyFD != "" {
+		fd, err := strconv.Atoi(readyFD)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid %s %q", readyFDEnv, readyFD)
+		}
+		r = os.NewFile(uintptr(fd), "ready")
+	}
+	for _, s := range strings.Split(fds, ";") {
+		if s == "" {
+			continue
+		}
+		i := strings.LastIndex(s, "=")
+		if i < 0 {
+			return nil, r, fmt.Errorf("invalid %s %q", listenFDsEnv, s)
+		}
+		fd, err := strconv.Atoi(s[i+1:])
+		if err != nil {
+			return nil, r, fmt.Errorf("invalid %s %q", listenFDsEnv, s)
+		}
+		files[s[:i]] = os.NewFile(uintptr(fd), s[:i])
+	}
+	return files, r, nil
+}
+
+// inheritedListener returns the listener for key which was passed
+// by the previous process or nil if there is none.
+func inheritedListener(key string) (net.Listener, error) {
+	f := claim(key)
+	if f == nil {
+		return nil, nil
+	}
+	defer f.Close()
+	log.Printf("[INFO] Using inherited listener for %s", key)
+	return net.FileListener(f)
+}
+
+// inheritedPacketConn is like inheritedListener for packet conns.
+func inheritedPacketConn(key string) (net.PacketConn, error) {
+	f := claim(key)
+	if f == nil {
+		return nil, nil
+	}
+	defer f.Close()
+	log.Printf("[INFO] Using inherited listener for %s", key)
+	return net.FilePacketConn(f)
+}
+
+func claim(key string) *os.File {
+	hmu.Lock()
+	defer hmu.Unlock()
+	f := inherited[key]
+	delete(inherited, key)
+	return f
+}
+
+// addSocket registers the socket of a listener for the next upgrade.
+func addSocket(key string, s socket) {
+	hmu.Lock()
+	sockets[key] = s
+	hmu.Unlock()
+}
+
+// Ready notifies the previous process that this process has taken
+// over all listeners. It waits up to timeout for the inherited
+// listeners to be claimed and closes the ones which are not
+// configured anymore.
+func Ready(timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for {
+		hmu.Lock()
+		n := len(inherited)
+		hmu.Unlock()
+		if n == 0 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	hmu.Lock()
+	defer hmu.Unlock()
+	for key, f := range inherited {
+		log.Printf("[INFO] Closing unused inherited listener for %s", key)
+		f.Close()
+	}
+	inherited = nil
+	if ready != nil {
+		ready.Write([]byte{1})
+		ready.Close()
+		ready = nil
+		log.Print("[INFO] Notified the previous process")
+	}
+}
+
+// Upgrade starts a new process of the same executable with the same
+// arguments and passes the sockets of all listeners to it. It returns
+// nil when the new process has called Ready and an error if the
+// new process exits or is not ready within timeout. The caller is
+// expected to shut down gracefully after a successful upgrade.
+func Upgrade(timeout time.Duration) error {
+	hmu.Lock()
+	if upgrading {
+		hmu.Unlock()
+		return errors.New("upgrade in progress")
+	}
+	upgrading = true
+
+	var keys []string
+	var files []*os.File
+	for key, s := range sockets {
+		f, err := s.File()
+		if err != nil {
+			// the listener was closed
+			delete(sockets, key)
+			continue
+		}
+		keys = append(keys, key)
+		files = append(files, f)
+	}
+	hmu.Unlock()
+
+	ok := false
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+		hmu.Lock()
+		upgrading = false
+		hmu.Unlock()
+	}()
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	// the extra files of the new process start at fd 3
+	var fds []string
+	for i, key := range keys {
+		fds = append(fds, fmt.Sprintf("%s=%d", key, i+4))
+	}
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.Env = append(os.Environ(), readyFDEnv+"=3", listenFDsEnv+"="+strings.Join(fds, ";"))
+	cmd.ExtraFiles = append([]*os.File{w}, files...)
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		return err
+	}
+	log.Printf("[INFO] Started new process %d with %d listeners", cmd.Process.Pid, len(files))
+
+	readyc, exitc := make(chan error, 1), make(chan error, 1)
+	go func() {
+		b := make([]byte, 1)
+		_, err := r.Read(b)
+		readyc <- err
+	}()
+	go func() { exitc <- cmd.Wait() }()
+
+	select {
+	case err := <-readyc:
+		if err == nil {
+			ok = true
+		}
+	case err := <-exitc:
+		return fmt.Errorf("new process exited. %v", err)
+	case <-time.After(timeout):
+	}
+	if !ok {
+		cmd.Process.Kill()
+		return fmt.Errorf("new process %d was not ready within %s", cmd.Process.Pid, timeout)
+	}
+
+	// the unix socket files belong to the new process now
+	hmu.Lock()
+	for _, s := range sockets {
+		if ul, isUnix := s.(*net.UnixListener); isUnix {
+			ul.SetUnlinkOnClose(false)
+		}
+	}
+	hmu.Unlock()
+	return nil
+}
@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"net"
+	"os"
+	"testing"
+
+	"github.com/fabiolb/fabio/config"
+)
+
+func TestParseHandover(t *testing.T) {
+	// use descriptors which are not open since the files close
+	// them when they are closed or garbage collected.
+	files, r, err := parseHandover("tcp::9999=1004;udp:127.0.0.1:2000=1005;unix:/tmp/fabio.sock=1006", "1003")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		r.Close()
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	if r == nil || r.Fd() != 1003 {
+		t.Fatalf("got ready fd %v want 1003", r)
+	}
+	want := map[string]uintptr{"tcp::9999": 1004, "udp:127.0.0.1:2000": 1005, "unix:/tmp/fabio.sock": 1006}
+	if got, want := len(files), len(want); got != want {
+		t.Fatalf("got %d files want %d", got, want)
+	}
+	for key, fd := range want {
+		if f := files[key]; f == nil || f.Fd() != fd {
+			t.Fatalf("got %v for %s want fd %d", f, key, fd)
+		}
+	}
+
+	if _, _, err := parseHandover("tcp::9999", ""); err == nil {
+		t.Fatal("expected error for missing fd")
+	}
+	if _, _, err := parseHandover("", "x"); err == nil {
+		t.Fatal("expected error for invalid ready fd")
+	}
+}
+
+func TestListenTCPInherited(t *testing.T) {
+	l := config.Listen{Addr: "127.0.0.1:57783"}
+	ln, err := ListenTCP(l, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// pass the socket of the listener as if it was inherited
+	addr := ln.Addr().String()
+	key := "tcp:" + addr
+	hmu.Lock()
+	f, err := sockets[key].File()
+	inherited = map[string]*os.File{key: f}
+	hmu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ln2, err := ListenTCP(config.Listen{Addr: addr}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln2.Close()
+	if got, want := len(inherited), 0; got != want {
+		t.Fatalf("got %d inherited listeners want %d", got, want)
+	}
+
+	// the first listener must not be required to accept connections
+	ln.Close()
+	go func() {
+		c, err := ln2.Accept()
+		if err == nil {
+			c.Close()
+		}
+	}()
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	Ready(0)
+}
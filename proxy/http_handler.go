@@ -1,6 +1,7 @@
 package proxy
 
 import (
+	"bufio"
 	"context"
 	"io"
 	"log"
@@ -15,7 +16,7 @@ import (
 const StatusClientClosedRequest = 499
 
 func newHTTPProxy(target *url.URL, tr http.RoundTripper, flush time.Duration) http.Handler {
-	return &httputil.ReverseProxy{
+	rp := &httputil.ReverseProxy{
 		// this is a simplified director function based on the
 		// httputil.NewSingleHostReverseProxy() which does not
 		// mangle the request and target URL since the target
@@ -34,6 +35,88 @@ func newHTTPProxy(target *url.URL, tr http.RoundTripper, flush time.Duration) ht
 		Transport:     tr,
 		ErrorHandler:  httpProxyErrorHandler,
 	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rp.ServeHTTP(&informationalWriter{ResponseWriter: w}, r)
+	})
+}
+
+// informationalWriter forwards informational (1xx) responses like
+// 103 Early Hints from the upstream to the client. The reverse proxy
+// copies the headers of an informational response into the header map
+// and clears it afterwards which would send the response headers added
+// by fabio with the informational response and then drop them from the
+// final response. Therefore, the upstream headers are collected in a
+// separate map until the final response is written.
+type informationalWriter struct {
+	http.ResponseWriter
+	header      http.Header
+	wroteHeader bool
+}
+
+func (w *informationalWriter) Header() http.Header {
+	if w.wroteHeader {
+		return w.ResponseWriter.Header()
+	}
+	if w.header == nil {
+		w.header = http.Header{}
+	}
+	return w.header
+}
+
+func (w *informationalWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+
+	h := w.ResponseWriter.Header()
+	if code >= 100 && code < 200 && code != http.StatusSwitchingProtocols {
+		// send only the upstream headers with the informational response
+		saved := make(http.Header, len(h))
+		for k, v := range h {
+			saved[k] = v
+			delete(h, k)
+		}
+		for k, v := range w.header {
+			h[k] = v
+		}
+		w.ResponseWriter.WriteHeader(code)
+		for k := range h {
+			delete(h, k)
+		}
+		for k, v := range saved {
+			h[k] = v
+		}
+		return
+	}
+
+	for k, v := range w.header {
+		for _, vv := range v {
+			h.Add(k, vv)
+		}
+	}
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *informationalWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *informationalWriter) Flush() {
+	if fl, ok := w.ResponseWriter.(http.Flusher); ok {
+		fl.Flush()
+	}
+}
+
+func (w *informationalWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hj, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return hj.Hijack()
+	}
+	return nil, nil, errNoHijacker
 }
 
 func httpProxyErrorHandler(w http.ResponseWriter, r *http.Request, err error) {
@@ -43,7 +126,9 @@ func httpProxyErrorHandler(w http.ResponseWriter, r *http.Request, err error) {
 
 	statusCode := http.StatusInternalServerError
 
-	if e, ok := err.(net.Error); ok {
+	if b, ok := r.Body.(*maxBodyReader); ok && b.tooLarge() {
+		statusCode = http.StatusRequestEntityTooLarge
+	} else if e, ok := err.(net.Error); ok {
 		if e.Timeout() {
 			statusCode = http.StatusGatewayTimeout
 		} else {
@@ -58,7 +143,8 @@ func httpProxyErrorHandler(w http.ResponseWriter, r *http.Request, err error) {
 	w.WriteHeader(statusCode)
 	// Theres nothing we can do if the client closes the connection and logging the "context canceled" errors will just add noise to the error log
 	// Note: The access_log will still log the 499 response status codes
-	if statusCode != StatusClientClosedRequest {
+	// Request bodies which exceed the limit of the route are client errors as well
+	if statusCode != StatusClientClosedRequest && statusCode != http.StatusRequestEntityTooLarge {
 		log.Print("[ERROR] ", err)
 	}
 
@@ -464,6 +464,9 @@ func TestLocalPort(t *testing.T) {
 		{&http.Request{Host: "1.2.3.4", TLS: &tls.ConnectionState{}}, "443"},
 		{&http.Request{Host: "1.2.3.4:"}, "80"},
 		{&http.Request{Host: "1.2.3.4:", TLS: &tls.ConnectionState{}}, "443"},
+		{&http.Request{Host: "[::1]:5678"}, "5678"},
+		{&http.Request{Host: "[::1]"}, "80"},
+		{&http.Request{Host: "[2001:db8::1]", TLS: &tls.ConnectionState{}}, "443"},
 	}
 
 	for i, tt := range tests {
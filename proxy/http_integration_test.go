@@ -5,26 +5,36 @@ import (
 	"compress/gzip"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/http/httptrace"
+	"net/textproto"
 	"net/url"
 	"os"
+	"path/filepath"
+	"reflect"
 	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/fabiolb/fabio/capture"
 	"github.com/fabiolb/fabio/config"
 	"github.com/fabiolb/fabio/logger"
 	"github.com/fabiolb/fabio/noroute"
 	"github.com/fabiolb/fabio/proxy/internal"
+	"github.com/fabiolb/fabio/proxy/proxyproto"
+	"github.com/fabiolb/fabio/redact"
 	"github.com/fabiolb/fabio/route"
 	"github.com/pascaldekloe/goe/verify"
+	"golang.org/x/net/http2"
 )
 
 const (
@@ -33,7 +43,7 @@ const (
 	globDisabled = true
 )
 
-//Global GlobCache for Testing
+// Global GlobCache for Testing
 var globCache = route.NewGlobCache(1000)
 
 func TestProxyProducesCorrectXForwardedSomethingHeader(t *testing.T) {
@@ -125,6 +135,70 @@ func TestProxySTSHeader(t *testing.T) {
 	}
 }
 
+func TestProxyEarlyHints(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Link", "</style.css>; rel=preload; as=style")
+		w.WriteHeader(http.StatusEarlyHints)
+		w.Header().Del("Link")
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprint(w, "OK")
+	}))
+	defer server.Close()
+
+	proxy := httptest.NewTLSServer(&HTTPProxy{
+		Config: config.Proxy{
+			STSHeader:        config.STSHeader{MaxAge: 31536000},
+			GZIPContentTypes: regexp.MustCompile("^text/plain(;.*)?$"),
+		},
+		Transport: http.DefaultTransport,
+		Lookup: func(r *http.Request) *route.Target {
+			return &route.Target{URL: mustParse(server.URL)}
+		},
+	})
+	defer proxy.Close()
+
+	var hints []http.Header
+	trace := &httptrace.ClientTrace{
+		Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+			if code == http.StatusEarlyHints {
+				hints = append(hints, http.Header(header))
+			}
+			return nil
+		},
+	}
+	req, _ := http.NewRequest("GET", proxy.URL, nil)
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+	client := http.Client{Transport: &http.Transport{TLSClientConfig: tlsInsecureConfig()}}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	if got, want := len(hints), 1; got != want {
+		t.Fatalf("got %d early hints want %d", got, want)
+	}
+	want := http.Header{"Link": []string{"</style.css>; rel=preload; as=style"}}
+	if got := hints[0]; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got early hints %v want %v", got, want)
+	}
+	if got, want := resp.StatusCode, 200; got != want {
+		t.Fatalf("got status %d want %d", got, want)
+	}
+	if got, want := string(body), "OK"; got != want {
+		t.Fatalf("got body %q want %q", got, want)
+	}
+	for _, h := range []string{"Strict-Transport-Security", "Vary", "Content-Type"} {
+		if resp.Header.Get(h) == "" {
+			t.Errorf("header %s missing in %v", h, resp.Header)
+		}
+	}
+	if got := resp.Header.Get("Link"); got != "" {
+		t.Errorf("got Link header %q want none", got)
+	}
+}
+
 func TestProxyChecksHeaderForAccessRules(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprintln(w, "OK")
@@ -239,6 +313,376 @@ func TestProxyPrependsPath(t *testing.T) {
 	}
 }
 
+func TestProxyRewritesPath(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.RequestURI))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		opts, uri, want string
+	}{
+		{`opts "rewrite=^/old/(.*) /new/$1"`, "/old/a/b?x=1", "/new/a/b?x=1"},
+		{`opts "strip=/old rewrite=^/(.*) /v2/$1"`, "/old/a", "/v2/a"},
+		{`opts "rewrite=^/old/([^/]+)\?id=(\d+)$ /new/$1/$2?src=old rewritequery=true"`, "/old/user?id=42", "/new/user/42?src=old"},
+		{`opts "rewrite=^/nomatch /x"`, "/old/a", "/old/a"},
+	}
+
+	for _, tt := range tests {
+		proxy := httptest.NewServer(&HTTPProxy{
+			Transport: http.DefaultTransport,
+			Lookup: func(r *http.Request) *route.Target {
+				tbl, _ := route.NewTable(bytes.NewBufferString("route add mock /old " + server.URL + " " + tt.opts))
+				return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"], globCache, globEnabled)
+			},
+		})
+
+		_, body := mustGet(proxy.URL + tt.uri)
+		proxy.Close()
+		if got, want := string(body), tt.want; got != want {
+			t.Errorf("%s: got %q want %q", tt.opts, got, want)
+		}
+	}
+}
+
+func TestProxyRewriteResponse(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Set-Cookie", "session=1; Path=/v1; Domain=app.internal")
+		http.Redirect(w, r, server.URL+"/v1/login", http.StatusFound)
+	}))
+	defer server.Close()
+
+	for _, tt := range []struct {
+		opts, location, cookie string
+	}{
+		{
+			`opts "prepend=/v1"`,
+			server.URL + "/v1/login",
+			"session=1; Path=/v1; Domain=app.internal",
+		},
+		{
+			`opts "prepend=/v1 strip=/app rewritelocation=true cookiepath=true cookiedomain=example.com"`,
+			"http://example.com/app/login",
+			"session=1; Path=/app; Domain=example.com",
+		},
+	} {
+		t.Run(tt.opts, func(t *testing.T) {
+			proxy := httptest.NewServer(&HTTPProxy{
+				Transport: http.DefaultTransport,
+				Lookup: func(r *http.Request) *route.Target {
+					tbl, _ := route.NewTable(bytes.NewBufferString("route add mock /app " + server.URL + " " + tt.opts))
+					return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"], globCache, globEnabled)
+				},
+			})
+			defer proxy.Close()
+
+			req, _ := http.NewRequest("GET", proxy.URL+"/app/", nil)
+			req.Host = "example.com"
+			resp, err := http.DefaultTransport.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if got, want := resp.StatusCode, http.StatusFound; got != want {
+				t.Fatalf("got status %d want %d", got, want)
+			}
+			if got, want := resp.Header.Get("Location"), tt.location; got != want {
+				t.Fatalf("got location %q want %q", got, want)
+			}
+			if got, want := resp.Header.Get("Set-Cookie"), tt.cookie; got != want {
+				t.Fatalf("got cookie %q want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestProxyRetry(t *testing.T) {
+	var failed, ok int32
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&failed, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&ok, 1)
+		w.Write([]byte("OK " + r.RequestURI))
+	}))
+	defer server.Close()
+
+	// an address nobody listens on
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	down := "http://" + l.Addr().String()
+	l.Close()
+
+	tests := []struct {
+		desc   string
+		routes string
+		method string
+		status int
+		body   string
+		failed int32
+	}{
+		{
+			desc: "retry on 5xx",
+			routes: `
+				route add mock /foo ` + failing.URL + ` opts "retries=1 retryon=5xx"
+				route add mock /foo ` + server.URL + ` opts "retries=1 retryon=5xx prepend=/x"`,
+			method: "GET",
+			status: http.StatusOK,
+			body:   "OK /x/foo",
+			failed: 1,
+		},
+		{
+			desc: "retry on connect failure",
+			routes: `
+				route add mock /foo ` + down + ` opts "retries=2 retrybackoff=1ms"
+				route add mock /foo ` + server.URL + ` opts "retries=2 retrybackoff=1ms"`,
+			method: "GET",
+			status: http.StatusOK,
+			body:   "OK /foo",
+		},
+		{
+			desc: "no retry for status not in retryon",
+			routes: `
+				route add mock /foo ` + failing.URL + ` opts "retries=1 retryon=502"
+				route add mock /foo ` + server.URL + ` opts "retries=1 retryon=502"`,
+			method: "GET",
+			status: http.StatusServiceUnavailable,
+			failed: 1,
+		},
+		{
+			desc: "no retry for non-idempotent requests",
+			routes: `
+				route add mock /foo ` + failing.URL + ` opts "retries=1 retryon=5xx"
+				route add mock /foo ` + server.URL + ` opts "retries=1 retryon=5xx"`,
+			method: "POST",
+			status: http.StatusServiceUnavailable,
+			failed: 1,
+		},
+		{
+			desc: "no other target",
+			routes: `
+				route add mock /foo ` + failing.URL + ` opts "retries=3 retryon=5xx"`,
+			method: "GET",
+			status: http.StatusServiceUnavailable,
+			failed: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			atomic.StoreInt32(&failed, 0)
+			tbl, err := route.NewTable(bytes.NewBufferString(tt.routes))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			proxy := httptest.NewServer(&HTTPProxy{
+				Config:    config.Proxy{Strategy: "rr"},
+				Transport: http.DefaultTransport,
+				Lookup: func(r *http.Request) *route.Target {
+					// always start with the first target
+					return tbl[""][0].Targets[0]
+				},
+			})
+			defer proxy.Close()
+
+			req, _ := http.NewRequest(tt.method, proxy.URL+"/foo", nil)
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+
+			if got, want := resp.StatusCode, tt.status; got != want {
+				t.Fatalf("got status %d want %d", got, want)
+			}
+			if tt.body != "" {
+				if got, want := string(body), tt.body; got != want {
+					t.Fatalf("got body %q want %q", got, want)
+				}
+			}
+			if got, want := atomic.LoadInt32(&failed), tt.failed; got != want {
+				t.Fatalf("got %d failed requests want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestProxyRateLimit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	}))
+	defer server.Close()
+
+	tbl, err := route.NewTable(bytes.NewBufferString(`
+		route add mock /limited ` + server.URL + ` opts "ratelimit=1r/h burst=2"
+		route add mock / ` + server.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	proxy := httptest.NewServer(&HTTPProxy{
+		Config:    config.Proxy{RateLimit: config.RateLimit{Body: "slow down"}},
+		Transport: http.DefaultTransport,
+		RateLimit: route.NewRateLimiter(1.0/3600, 4),
+		Lookup: func(r *http.Request) *route.Target {
+			return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"], globCache, globEnabled)
+		},
+	})
+	defer proxy.Close()
+
+	tests := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/limited", http.StatusOK, "OK"},
+		{"/limited", http.StatusOK, "OK"},
+		{"/limited", http.StatusTooManyRequests, "slow down\n"},
+		{"/other", http.StatusOK, "OK"},
+		// global limit
+		{"/other", http.StatusTooManyRequests, "slow down\n"},
+	}
+
+	for i, tt := range tests {
+		resp, body := mustGet(proxy.URL + tt.path)
+		if got, want := resp.StatusCode, tt.status; got != want {
+			t.Fatalf("%d: got status %d want %d", i, got, want)
+		}
+		if got, want := string(body), tt.body; got != want {
+			t.Fatalf("%d: got body %q want %q", i, got, want)
+		}
+	}
+}
+
+func TestProxyCapture(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"echo":%q,"token":"abc"}`, body)
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "capture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "capture.log")
+
+	rec, err := capture.New(config.Capture{Target: path, Format: "json", MaxBody: 1024, Redact: []string{"token"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	routes := "route add mock /capture " + server.URL + ` opts "capturebody=64"` + "\n"
+	routes += "route add mock /other " + server.URL
+	tbl, _ := route.NewTable(bytes.NewBufferString(routes))
+
+	proxy := httptest.NewServer(&HTTPProxy{
+		Transport: http.DefaultTransport,
+		Lookup: func(r *http.Request) *route.Target {
+			return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"], globCache, globEnabled)
+		},
+		Capture: rec,
+	})
+	defer proxy.Close()
+
+	for _, p := range []string{"/other", "/capture?token=xyz"} {
+		resp, err := http.Post(proxy.URL+p, "text/plain", strings.NewReader("hello"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+	}
+	rec.Close()
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if got, want := len(lines), 1; got != want {
+		t.Fatalf("got %d captured requests want %d", got, want)
+	}
+	var ex capture.Exchange
+	if err := json.Unmarshal([]byte(lines[0]), &ex); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := ex.Request.URL, "http://"+proxy.Listener.Addr().String()+"/capture?token=%5BREDACTED%5D"; got != want {
+		t.Fatalf("got url %q want %q", got, want)
+	}
+	if got, want := ex.Request.Body.Sample, "hello"; got != want {
+		t.Fatalf("got request body %q want %q", got, want)
+	}
+	if got, want := ex.Response.Status, 200; got != want {
+		t.Fatalf("got status %d want %d", got, want)
+	}
+	if got, want := ex.Response.Body.Sample, `{"echo":"hello","token":"[REDACTED]"}`; got != want {
+		t.Fatalf("got response body %q want %q", got, want)
+	}
+}
+
+func TestProxyLogRedact(t *testing.T) {
+	redact.Default = redact.New(config.Redact{
+		Headers: []string{"Authorization"},
+		Query:   []string{"token"},
+		Cookies: []string{"session"},
+	})
+	defer func() { redact.Default = nil }()
+
+	var upstream *http.Request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstream = r
+	}))
+	defer server.Close()
+
+	var b bytes.Buffer
+	l, err := logger.New(&b, "$request_uri $upstream_request_uri $header.Authorization $header.Cookie")
+	if err != nil {
+		t.Fatal("logger.New: ", err)
+	}
+
+	proxy := httptest.NewServer(&HTTPProxy{
+		Transport: http.DefaultTransport,
+		Lookup: func(r *http.Request) *route.Target {
+			return &route.Target{URL: mustParse(server.URL)}
+		},
+		Logger: l,
+	})
+	defer proxy.Close()
+
+	req, _ := http.NewRequest("GET", proxy.URL+"/foo?a=b&token=abc", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("Cookie", "session=abc; theme=dark")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	want := "/foo?a=b&token=[REDACTED] /foo?a=b&token=[REDACTED] [REDACTED] session=[REDACTED]; theme=dark\n"
+	if got := b.String(); got != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+
+	// the upstream request must not be redacted
+	if got, want := upstream.URL.RawQuery, "a=b&token=abc"; got != want {
+		t.Fatalf("got upstream query %q want %q", got, want)
+	}
+	if got, want := upstream.Header.Get("Authorization"), "Bearer secret"; got != want {
+		t.Fatalf("got upstream Authorization %q want %q", got, want)
+	}
+}
+
 func TestProxyHost(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprint(w, r.Host)
@@ -479,6 +923,7 @@ func testProxyLogOutput(t *testing.T, bodySize int, cfg config.Proxy) {
 		"response_time_ms:1.111",
 		"response_time_ns:1.111111111",
 		"response_time_us:1.111111",
+		"route:",
 		"time_common:01/Jan/2016:00:00:01 +0000",
 		"time_rfc3339:2016-01-01T00:00:01Z",
 		"time_rfc3339_ms:2016-01-01T00:00:01.123Z",
@@ -487,6 +932,7 @@ func testProxyLogOutput(t *testing.T, bodySize int, cfg config.Proxy) {
 		"time_unix_ms:1451606401123",
 		"time_unix_ns:1451606401123456789",
 		"time_unix_us:1451606401123456",
+		"trace_id:",
 		"upstream_addr:" + upstreamURL.Host,
 		"upstream_host:" + upstreamHost,
 		"upstream_port:" + upstreamPort,
@@ -556,6 +1002,120 @@ func TestProxyHTTPSUpstreamSkipVerify(t *testing.T) {
 	}
 }
 
+func TestProxyProtoUpstream(t *testing.T) {
+	// the server returns the client address from the PROXY protocol header
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.RemoteAddr)
+	}))
+	server.Listener = &proxyproto.Listener{Listener: server.Listener, ProxyHeaderTimeout: time.Second, Trusted: loopback}
+	server.Start()
+	defer server.Close()
+
+	tests := []struct {
+		desc     string
+		opts     string
+		listener int
+	}{
+		{"route v1", `opts "pxyproto=v1"`, 0},
+		{"route v2", `opts "pxyproto=v2"`, 0},
+		{"listener v2", "", 2},
+		{"route overrides listener", `opts "pxyproto=v1"`, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			proxy := httptest.NewServer(&HTTPProxy{
+				Transport:  &http.Transport{},
+				ProxyProto: tt.listener,
+				Lookup: func(r *http.Request) *route.Target {
+					tbl, _ := route.NewTable(bytes.NewBufferString("route add srv / " + server.URL + " " + tt.opts))
+					return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"], globCache, globEnabled)
+				},
+			})
+			defer proxy.Close()
+
+			var clientAddr string
+			trace := &httptrace.ClientTrace{
+				GotConn: func(info httptrace.GotConnInfo) { clientAddr = info.Conn.LocalAddr().String() },
+			}
+			req, _ := http.NewRequest("GET", proxy.URL, nil)
+			req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+			resp, body := mustDo(req)
+			if got, want := resp.StatusCode, http.StatusOK; got != want {
+				t.Fatalf("got status %d want %d", got, want)
+			}
+			if got, want := string(body), clientAddr; got != want {
+				t.Fatalf("got client addr %q want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestProxyUpstreamHTTP2(t *testing.T) {
+	proto := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Proto)
+	})
+
+	// h2c server with prior knowledge
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go (&http2.Server{}).ServeConn(c, &http2.ServeConnOpts{Handler: proto})
+		}
+	}()
+	h2cURL := "http://" + ln.Addr().String()
+
+	tlsServer := httptest.NewUnstartedServer(proto)
+	tlsServer.EnableHTTP2 = true
+	tlsServer.StartTLS()
+	defer tlsServer.Close()
+
+	plainServer := httptest.NewServer(proto)
+	defer plainServer.Close()
+
+	tests := []struct {
+		desc, url, opts, global, want string
+	}{
+		{"route h2c", h2cURL, `opts "proto=h2c"`, "off", "HTTP/2.0"},
+		{"global h2c", h2cURL, "", "h2c", "HTTP/2.0"},
+		{"global h2 keeps http", plainServer.URL, "", "h2", "HTTP/1.1"},
+		{"off", plainServer.URL, "", "off", "HTTP/1.1"},
+		{"route h2", tlsServer.URL, `opts "proto=h2"`, "off", "HTTP/2.0"},
+		{"global h2", tlsServer.URL, "", "h2", "HTTP/2.0"},
+		{"tls off", tlsServer.URL, "", "off", "HTTP/1.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			proxy := httptest.NewServer(&HTTPProxy{
+				Config:    config.Proxy{UpstreamHTTP2: tt.global},
+				Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+				Lookup: func(r *http.Request) *route.Target {
+					tbl, _ := route.NewTable(bytes.NewBufferString("route add srv / " + tt.url + " " + tt.opts))
+					return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"], globCache, globEnabled)
+				},
+			})
+			defer proxy.Close()
+
+			resp, body := mustGet(proxy.URL)
+			if got, want := resp.StatusCode, http.StatusOK; got != want {
+				t.Fatalf("got status %d want %d", got, want)
+			}
+			if got, want := string(body), tt.want; got != want {
+				t.Fatalf("got upstream proto %q want %q", got, want)
+			}
+		})
+	}
+}
+
 func TestProxyGzipHandler(t *testing.T) {
 	tests := []struct {
 		desc            string
@@ -563,6 +1123,7 @@ func TestProxyGzipHandler(t *testing.T) {
 		acceptEncoding  string
 		contentEncoding string
 		wantResponse    []byte
+		compression     *route.Compression
 	}{
 		{
 			desc:            "plain body - compressed response",
@@ -606,6 +1167,30 @@ func TestProxyGzipHandler(t *testing.T) {
 			contentEncoding: "",
 			wantResponse:    plainContent,
 		},
+		{
+			desc:            "plain body - plain response (disabled for route)",
+			content:         plainHandler("text/plain"),
+			acceptEncoding:  "gzip",
+			contentEncoding: "",
+			wantResponse:    plainContent,
+			compression:     &route.Compression{Disabled: true, MinSize: -1},
+		},
+		{
+			desc:            "plain body - compressed response (route content types)",
+			content:         plainHandler("text/javascript"),
+			acceptEncoding:  "gzip",
+			contentEncoding: "gzip",
+			wantResponse:    gzipContent,
+			compression:     &route.Compression{ContentTypes: regexp.MustCompile("^text/javascript$"), MinSize: -1},
+		},
+		{
+			desc:            "plain body - plain response (below route min size)",
+			content:         plainHandler("text/plain"),
+			acceptEncoding:  "gzip",
+			contentEncoding: "",
+			wantResponse:    plainContent,
+			compression:     &route.Compression{MinSize: 100},
+		},
 	}
 
 	for _, tt := range tests {
@@ -620,7 +1205,7 @@ func TestProxyGzipHandler(t *testing.T) {
 				},
 				Transport: http.DefaultTransport,
 				Lookup: func(r *http.Request) *route.Target {
-					return &route.Target{URL: mustParse(server.URL)}
+					return &route.Target{URL: mustParse(server.URL), Compression: tt.compression}
 				},
 			})
 			defer proxy.Close()
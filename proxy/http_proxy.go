@@ -2,25 +2,33 @@ package proxy
 
 import (
 	"bufio"
+	"context"
 	"crypto/tls"
 	"errors"
 	"io"
 	"net"
 	"net/http"
 	"net/url"
+	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/fabiolb/fabio/auth"
+	"github.com/fabiolb/fabio/cache"
+	"github.com/fabiolb/fabio/capture"
 	"github.com/fabiolb/fabio/config"
 	"github.com/fabiolb/fabio/logger"
 	"github.com/fabiolb/fabio/metrics"
 	"github.com/fabiolb/fabio/noroute"
 	"github.com/fabiolb/fabio/proxy/gzip"
+	"github.com/fabiolb/fabio/proxy/sockopt"
+	"github.com/fabiolb/fabio/redact"
 	"github.com/fabiolb/fabio/route"
 	"github.com/fabiolb/fabio/trace"
 	"github.com/fabiolb/fabio/uuid"
+	"golang.org/x/net/http2"
 )
 
 // HTTPProxy is a dynamic reverse proxy for HTTP and HTTPS protocols.
@@ -64,6 +72,36 @@ type HTTPProxy struct {
 
 	// Auth schemes registered with the server
 	AuthSchemes map[string]auth.AuthScheme
+
+	// Capture records the requests to targets with the capture
+	// option. If Capture is nil no requests are recorded.
+	Capture *capture.Recorder
+
+	// RateLimit limits the number of all requests.
+	// If RateLimit is nil the requests are not limited.
+	RateLimit *route.RateLimiter
+
+	// ProxyProto is the version of the PROXY protocol header which is
+	// sent to targets without the pxyproto option. Zero disables it.
+	ProxyProto int
+
+	// Cache stores the responses of targets with the cache
+	// option. If Cache is nil no responses are cached.
+	Cache cache.Store
+
+	// Mirror sends copies of the requests to targets with the mirror
+	// option to a shadow environment. If Mirror is nil the requests
+	// are not mirrored.
+	Mirror *Mirror
+
+	// UserAgentMetrics enables counting the requests per route by
+	// the family of the user agent.
+	UserAgentMetrics bool
+
+	// marked contains the connection pools for upstream connections
+	// with a DSCP mark, a PROXY protocol header or HTTP/2 per
+	// transport, mark, version and protocol.
+	marked sync.Map
 }
 
 func (p *HTTPProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -83,29 +121,27 @@ func (p *HTTPProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	span := trace.CreateSpan(r, &p.TracerCfg)
 	defer span.Finish()
 
-	t := p.Lookup(r)
+	if p.RateLimit != nil && p.rateLimited(w, p.RateLimit, "") {
+		return
+	}
+
+	t := selectTarget(r, p.Lookup(r))
 
 	if t == nil {
 		status := p.Config.NoRouteStatus
 		if status < 100 || status > 999 {
 			status = http.StatusNotFound
 		}
-		w.WriteHeader(status)
-		html := noroute.GetHTML()
-		if html != "" {
-			io.WriteString(w, html)
-		}
+		noroute.Write(w, r, status)
 		return
 	}
 
-	if t.AccessDeniedHTTP(r) {
-		http.Error(w, "access denied", http.StatusForbidden)
+	if killed(w, t.Service) || injectFault(w, r, t.Service) {
 		return
 	}
 
-	if !t.Authorized(r, w, p.AuthSchemes) {
-		http.Error(w, "authorization failed", http.StatusUnauthorized)
-		return
+	for k, v := range t.Annotations {
+		span.SetTag("annotation."+k, v)
 	}
 
 	// build the request url since r.URL will get modified
@@ -117,63 +153,76 @@ func (p *HTTPProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		RawQuery: r.URL.RawQuery,
 	}
 
-	if t.RedirectCode != 0 && t.RedirectURL != nil {
-		http.Redirect(w, r, t.RedirectURL.String(), t.RedirectCode)
-		if t.Timer != nil {
-			t.Timer.Update(0)
-		}
-		metrics.DefaultRegistry.GetTimer(key(t.RedirectCode)).Update(0)
-		return
+	// build the real target url that is passed to the proxy
+	host := r.Host
+	targetURL := buildTargetURL(t, r)
+	r.Host = targetHost(t, targetURL, host)
+
+	// keep the header as received from the client for the capture
+	// since the middlewares may modify it
+	var header http.Header
+	if p.Capture != nil && t.Capture {
+		header = r.Header.Clone()
 	}
 
-	// build the real target url that is passed to the proxy
-	targetURL := &url.URL{
-		Scheme: t.URL.Scheme,
-		Host:   t.URL.Host,
-		Path:   r.URL.Path,
+	// preflight requests have no credentials and
+	// are answered before the middlewares
+	if t.CORS != nil && corsPreflight(w, r, t.CORS) {
+		return
 	}
-	if t.URL.RawQuery == "" || r.URL.RawQuery == "" {
-		targetURL.RawQuery = t.URL.RawQuery + r.URL.RawQuery
-	} else {
-		targetURL.RawQuery = t.URL.RawQuery + "&" + r.URL.RawQuery
+
+	// reject oversized requests before the body
+	// is streamed to the upstream
+	if !limitBody(w, r, maxBody(t, p.Config.MaxBody)) {
+		return
 	}
 
-	if t.Host == "dst" {
-		r.Host = targetURL.Host
-	} else if t.Host != "" {
-		r.Host = t.Host
+	chain := p.chain(t)
+	if !p.middleware(chain, t, w, r) {
+		return
 	}
 
-	// TODO(fs): The HasPrefix check seems redundant since the lookup function should
-	// TODO(fs): have found the target based on the prefix but there may be other
-	// TODO(fs): matchers which may have different rules. I'll keep this for
-	// TODO(fs): a defensive approach.
-	if t.StripPath != "" && strings.HasPrefix(r.URL.Path, t.StripPath) {
-		targetURL.Path = targetURL.Path[len(t.StripPath):]
-		// ensure absolute path after stripping to maintain compliance with
-		// section 5.3 of RFC7230 (https://tools.ietf.org/html/rfc7230#section-5.3)
-		if !strings.HasPrefix(targetURL.Path, "/") {
-			targetURL.Path = "/" + targetURL.Path
+	if t.RedirectCode != 0 && t.RedirectURL != nil {
+		http.Redirect(w, r, t.RedirectURL.String(), t.RedirectCode)
+		if t.Timer != nil {
+			t.Timer.Update(0)
 		}
+		metrics.DefaultRegistry.GetTimer(key(t.RedirectCode)).Update(0)
+		return
 	}
 
-	if t.PrependPath != "" {
-		targetURL.Path = t.PrependPath + targetURL.Path
-		// ensure absolute path after stripping to maintain compliance with
-		// section 5.3 of RFC7230 (https://tools.ietf.org/html/rfc7230#section-5.3)
-		if !strings.HasPrefix(targetURL.Path, "/") {
-			targetURL.Path = "/" + targetURL.Path
+	// record the request as received from the client
+	var ex *capture.Exchange
+	var reqBody, respBody *capture.Sample
+	if header != nil && p.Capture.Allow() {
+		ex = &capture.Exchange{
+			Service:  t.Service,
+			Upstream: targetURL.String(),
+			Request: capture.Request{
+				Method:     r.Method,
+				URL:        requestURL.String(),
+				Proto:      r.Proto,
+				RemoteAddr: r.RemoteAddr,
+				Header:     header,
+			},
+		}
+		n := p.Capture.SampleSize(t.CaptureBody)
+		reqBody, respBody = capture.NewSample(n), capture.NewSample(n)
+		if r.Body != nil && r.Body != http.NoBody {
+			r.Body = capture.TeeBody(r.Body, reqBody)
 		}
 	}
 
-	if err := addHeaders(r, p.Config, t.StripPath); err != nil {
-		http.Error(w, "cannot parse "+r.RemoteAddr, http.StatusInternalServerError)
-		return
+	// websocket connections are not mirrored
+	if p.Mirror != nil && t.Mirror != nil && r.Header.Get("Upgrade") == "" {
+		p.Mirror.Send(t, r, targetURL)
 	}
 
-	if err := addResponseHeaders(w, r, p.Config); err != nil {
-		http.Error(w, "cannot add response headers", http.StatusInternalServerError)
-		return
+	// honor the timeout requested by the client
+	if d := requestTimeout(r.Header, p.Config.TimeoutHeaders, maxTimeout(t, p.Config.TimeoutMax)); d > 0 {
+		ctx, cancel := context.WithTimeout(r.Context(), d)
+		defer cancel()
+		r = r.WithContext(ctx)
 	}
 
 	//Add OpenTrace Headers to response
@@ -181,34 +230,81 @@ func (p *HTTPProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	upgrade, accept := r.Header.Get("Upgrade"), r.Header.Get("Accept")
 
-	tr := p.Transport
-	if t.TLSSkipVerify {
-		tr = p.InsecureTransport
+	pxy := p.proxyProto(t)
+	if pxy > 0 {
+		r = withProxyProtoAddrs(r)
 	}
+	tr := observeOutliers(p.transport(t), t)
 
+	var retry *retryTransport
+	target := func() *route.Target {
+		if retry != nil {
+			return retry.target
+		}
+		return t
+	}
+	filter := func(rt http.RoundTripper) http.RoundTripper {
+		if t.BodyRewrite != nil {
+			rt = &bodyRewriteTransport{rt, p.Config.BodyRewriteMaxBody, target}
+		}
+		if t.ErrorBody != "" {
+			rt = &errorBodyTransport{rt, target}
+		}
+		filters := responseFilters(chain)
+		if len(filters) == 0 {
+			return rt
+		}
+		return &filterTransport{rt, filters, target}
+	}
 	var h http.Handler
 	switch {
 	case upgrade == "websocket" || upgrade == "Websocket":
 		r.URL = targetURL
+		dial := net.Dial
+		if t.DSCP > 0 {
+			dial = p.dialer(t).Dial
+		}
+		if pxy > 0 {
+			ctx, pdial := r.Context(), proxyProtoDial(p.dialer(t).DialContext, pxy)
+			dial = func(network, address string) (net.Conn, error) {
+				return pdial(ctx, network, address)
+			}
+		}
 		if targetURL.Scheme == "https" || targetURL.Scheme == "wss" {
+			tlscfg := p.wsTLSConfig(t)
 			h = newWSHandler(targetURL.Host, func(network, address string) (net.Conn, error) {
-				return tls.Dial(network, address, tr.(*http.Transport).TLSClientConfig)
-			})
+				if pxy > 0 {
+					return tlsDial(dial, network, address, tlscfg)
+				}
+				if t.DSCP > 0 {
+					return tls.DialWithDialer(p.dialer(t), network, address, tlscfg)
+				}
+				return tls.Dial(network, address, tlscfg)
+			}, t.TimerName, p.Config.WSIdleTimeout)
 		} else {
-			h = newWSHandler(targetURL.Host, net.Dial)
+			h = newWSHandler(targetURL.Host, dial, t.TimerName, p.Config.WSIdleTimeout)
 		}
 
 	case accept == "text/event-stream":
 		// use the flush interval for SSE (server-sent events)
 		// must be > 0s to be effective
-		h = newHTTPProxy(targetURL, tr, p.Config.FlushInterval)
+		h = newHTTPProxy(targetURL, filter(tr), p.Config.FlushInterval)
+
+	case t.Retry.Retries > 0:
+		retry = &retryTransport{p: p, r: r, host: host, target: t, targetURL: targetURL}
+		h = newHTTPProxy(targetURL, filter(retry), p.Config.GlobalFlushInterval)
 
 	default:
-		h = newHTTPProxy(targetURL, tr, p.Config.GlobalFlushInterval)
+		h = newHTTPProxy(targetURL, filter(tr), p.Config.GlobalFlushInterval)
 	}
 
-	if p.Config.GZIPContentTypes != nil {
-		h = gzip.NewGzipHandler(h, p.Config.GZIPContentTypes)
+	// websocket and SSE responses are streams which are not cached
+	if p.Cache != nil && t.Cache != nil && upgrade == "" && accept != "text/event-stream" {
+		h = p.cacheHandler(t, h)
+	}
+
+	if types, minSize := p.gzipConfig(t); types != nil {
+		h = gzip.NewGzipHandlerMinSize(h, types, int64(minSize))
 	}
 
 	timeNow := p.Time
@@ -218,16 +314,51 @@ func (p *HTTPProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	start := timeNow()
 	rw := &responseWriter{w: w}
+	if respBody != nil {
+		rw.body = respBody
+	}
+	if t.LocationRewrite != nil || t.CookieRewrite != nil || t.ResponseHeaders != nil || t.CORS != nil {
+		origin := r.Header.Get("Origin")
+		rw.rewrite = func(code int, h http.Header) {
+			if t.CORS != nil {
+				t.CORS.Apply(origin, h)
+			}
+			if retry != nil {
+				retry.target.RewriteResponse(code, h, requestURL)
+				return
+			}
+			t.RewriteResponse(code, h, requestURL)
+		}
+	}
 	h.ServeHTTP(rw, r)
 	end := timeNow()
 	dur := end.Sub(start)
 
+	// the request may have been retried on another target
+	if retry != nil {
+		t, targetURL = retry.target, retry.targetURL
+	}
+
+	if ex != nil {
+		ex.Start, ex.Duration = start, dur
+		ex.Request.Body = reqBody.Body()
+		ex.Response = capture.Response{
+			Status: rw.code,
+			Header: rw.Header().Clone(),
+			Body:   respBody.Body(),
+		}
+		p.Capture.Record(ex)
+	}
+
 	if p.Requests != nil {
 		p.Requests.Update(dur)
 	}
 	if t.Timer != nil {
 		t.Timer.Update(dur)
 	}
+	if p.UserAgentMetrics {
+		countUserAgent(t, r.UserAgent())
+	}
 	if rw.code <= 0 {
 		return
 	}
@@ -239,19 +370,234 @@ func (p *HTTPProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		p.Logger.Log(&logger.Event{
 			Start:   start,
 			End:     end,
-			Request: r,
+			Request: redact.Default.Request(r),
 			Response: &http.Response{
 				StatusCode:    rw.code,
 				ContentLength: int64(rw.size),
 			},
-			RequestURL:      requestURL,
+			RequestURL:      redact.Default.URL(requestURL),
 			UpstreamAddr:    targetURL.Host,
 			UpstreamService: t.Service,
-			UpstreamURL:     targetURL,
+			UpstreamURL:     redact.Default.URL(targetURL),
+			Annotations:     t.Annotations,
+			Route:           t.Route(),
+			TraceID:         trace.TraceID(span),
 		})
 	}
 }
 
+// gzipConfig returns the content types and the minimum size of the
+// responses from t which are compressed. The content types are nil
+// if the responses are not compressed.
+func (p *HTTPProxy) gzipConfig(t *route.Target) (*regexp.Regexp, int) {
+	types, minSize := p.Config.GZIPContentTypes, p.Config.GZIPMinSize
+	if c := t.Compression; c != nil {
+		if c.Disabled {
+			return nil, 0
+		}
+		if c.ContentTypes != nil {
+			types = c.ContentTypes
+		}
+		if c.MinSize >= 0 {
+			minSize = c.MinSize
+		}
+	}
+	return types, minSize
+}
+
+// rateLimited returns true and responds with '429 Too Many Requests'
+// if the request exceeds the rate limit l. The result is recorded in
+// the global metrics and in the metrics of the route with the given
+// name if it is not empty.
+func (p *HTTPProxy) rateLimited(w http.ResponseWriter, l *route.RateLimiter, name string) bool {
+	if l.Allow() {
+		metrics.DefaultRegistry.GetCounter("ratelimit.allowed").Inc(1)
+		return false
+	}
+	metrics.DefaultRegistry.GetCounter("ratelimit.limited").Inc(1)
+	if name != "" {
+		metrics.DefaultRegistry.GetCounter(name + ".ratelimit.limited").Inc(1)
+	}
+
+	body := p.Config.RateLimit.Body
+	if body == "" {
+		body = http.StatusText(http.StatusTooManyRequests)
+	}
+	w.Header().Set("Retry-After", "1")
+	http.Error(w, body, http.StatusTooManyRequests)
+	return true
+}
+
+// transport returns the connection pool for upstream requests to t.
+// Connections with a DSCP mark use a separate pool per mark.
+// Connections with a PROXY protocol header are not reused since the
+// header describes a single client connection. HTTP/2 connections
+// use a separate pool per protocol.
+func (p *HTTPProxy) transport(t *route.Target) http.RoundTripper {
+	tr := p.Transport
+	if t.TLSSkipVerify {
+		tr = p.InsecureTransport
+	}
+	pxy := p.proxyProto(t)
+	h2 := p.http2(t, pxy)
+	if t.DSCP == 0 && pxy == 0 && h2 == "" {
+		return tr
+	}
+	htr, ok := tr.(*http.Transport)
+	if !ok {
+		return tr
+	}
+
+	type key struct {
+		tr        *http.Transport
+		dscp, pxy int
+		h2        string
+	}
+	k := key{htr, t.DSCP, pxy, h2}
+	if mtr, ok := p.marked.Load(k); ok {
+		return mtr.(http.RoundTripper)
+	}
+
+	var mtr http.RoundTripper
+	if h2 == "h2c" {
+		d := p.dialer(t)
+		mtr = &http2.Transport{
+			AllowHTTP: true,
+			DialTLS: func(network, addr string, _ *tls.Config) (net.Conn, error) {
+				return d.Dial(network, addr)
+			},
+		}
+	} else {
+		ctr := htr.Clone()
+		ctr.Dial, ctr.DialContext = nil, p.dialer(t).DialContext
+		if pxy > 0 {
+			ctr.DisableKeepAlives = true
+			ctr.DialContext = proxyProtoDial(ctr.DialContext, pxy)
+		}
+		ctr.ForceAttemptHTTP2 = h2 == "h2"
+		mtr = ctr
+	}
+	v, _ := p.marked.LoadOrStore(k, mtr)
+	return v.(http.RoundTripper)
+}
+
+// CloseIdleConnections closes the idle upstream connections of all
+// connection pools of the proxy.
+func (p *HTTPProxy) CloseIdleConnections() {
+	type closer interface {
+		CloseIdleConnections()
+	}
+	for _, tr := range []http.RoundTripper{p.Transport, p.InsecureTransport} {
+		if c, ok := tr.(closer); ok {
+			c.CloseIdleConnections()
+		}
+	}
+	p.marked.Range(func(_, tr interface{}) bool {
+		if c, ok := tr.(closer); ok {
+			c.CloseIdleConnections()
+		}
+		return true
+	})
+}
+
+// http2 returns how requests to t use HTTP/2: "h2" for HTTP/2 over
+// TLS, "h2c" for HTTP/2 without TLS or "" for HTTP/1.1. Connections
+// with a PROXY protocol header use HTTP/1.1 since HTTP/2 connections
+// are shared by the requests of all clients.
+func (p *HTTPProxy) http2(t *route.Target, pxy int) string {
+	mode := p.Config.UpstreamHTTP2
+	if t.HTTP2 {
+		mode = "h2c"
+	}
+	switch {
+	case pxy > 0 || mode == "" || mode == "off":
+		return ""
+	case t.URL.Scheme == "https":
+		return "h2"
+	case t.URL.Scheme == "http" && mode == "h2c":
+		return "h2c"
+	default:
+		return ""
+	}
+}
+
+// wsTLSConfig returns the TLS config for websocket connections
+// to t which always use HTTP/1.1.
+func (p *HTTPProxy) wsTLSConfig(t *route.Target) *tls.Config {
+	tr := p.Transport
+	if t.TLSSkipVerify {
+		tr = p.InsecureTransport
+	}
+	if htr, ok := tr.(*http.Transport); ok {
+		return htr.TLSClientConfig
+	}
+	return nil
+}
+
+// dialer returns the dialer for upstream connections to t.
+func (p *HTTPProxy) dialer(t *route.Target) *net.Dialer {
+	return &net.Dialer{
+		Timeout:   p.Config.DialTimeout,
+		KeepAlive: p.Config.KeepAliveTimeout,
+		Control:   sockopt.Control(config.SocketOpts{TOS: t.DSCP << 2}),
+	}
+}
+
+// buildTargetURL returns the url of the upstream request
+// to the target t for the request r.
+func buildTargetURL(t *route.Target, r *http.Request) *url.URL {
+	targetURL := &url.URL{
+		Scheme: t.URL.Scheme,
+		Host:   t.URL.Host,
+		Path:   r.URL.Path,
+	}
+	if t.URL.RawQuery == "" || r.URL.RawQuery == "" {
+		targetURL.RawQuery = t.URL.RawQuery + r.URL.RawQuery
+	} else {
+		targetURL.RawQuery = t.URL.RawQuery + "&" + r.URL.RawQuery
+	}
+
+	// TODO(fs): The HasPrefix check seems redundant since the lookup function should
+	// TODO(fs): have found the target based on the prefix but there may be other
+	// TODO(fs): matchers which may have different rules. I'll keep this for
+	// TODO(fs): a defensive approach.
+	if t.StripPath != "" && strings.HasPrefix(r.URL.Path, t.StripPath) {
+		targetURL.Path = targetURL.Path[len(t.StripPath):]
+		// ensure absolute path after stripping to maintain compliance with
+		// section 5.3 of RFC7230 (https://tools.ietf.org/html/rfc7230#section-5.3)
+		if !strings.HasPrefix(targetURL.Path, "/") {
+			targetURL.Path = "/" + targetURL.Path
+		}
+	}
+
+	if t.PrependPath != "" {
+		targetURL.Path = t.PrependPath + targetURL.Path
+		// ensure absolute path after stripping to maintain compliance with
+		// section 5.3 of RFC7230 (https://tools.ietf.org/html/rfc7230#section-5.3)
+		if !strings.HasPrefix(targetURL.Path, "/") {
+			targetURL.Path = "/" + targetURL.Path
+		}
+	}
+
+	if t.PathRewrite != nil {
+		targetURL.Path, targetURL.RawQuery = t.PathRewrite.Rewrite(targetURL.Path, targetURL.RawQuery)
+	}
+	return targetURL
+}
+
+// targetHost returns the Host header of the upstream request
+// to the target t with the url targetURL.
+func targetHost(t *route.Target, targetURL *url.URL, host string) string {
+	switch {
+	case t.Host == "dst":
+		return targetURL.Host
+	case t.Host != "":
+		return t.Host
+	default:
+		return host
+	}
+}
+
 func key(code int) string {
 	b := []byte("http.status.")
 	b = strconv.AppendInt(b, int64(code), 10)
@@ -265,6 +611,12 @@ type responseWriter struct {
 	w    http.ResponseWriter
 	code int
 	size int
+
+	// body receives a copy of the response body if set.
+	body io.Writer
+
+	// rewrite rewrites the headers of the response if set.
+	rewrite func(code int, h http.Header)
 }
 
 func (rw *responseWriter) Header() http.Header {
@@ -274,12 +626,21 @@ func (rw *responseWriter) Header() http.Header {
 func (rw *responseWriter) Write(b []byte) (int, error) {
 	n, err := rw.w.Write(b)
 	rw.size += n
+	if rw.body != nil {
+		rw.body.Write(b[:n])
+	}
 	return n, err
 }
 
 func (rw *responseWriter) WriteHeader(statusCode int) {
+	if rw.rewrite != nil && statusCode >= 200 {
+		rw.rewrite(statusCode, rw.Header())
+	}
 	rw.w.WriteHeader(statusCode)
-	rw.code = statusCode
+	// informational responses are followed by the final response
+	if statusCode >= 200 || statusCode == http.StatusSwitchingProtocols {
+		rw.code = statusCode
+	}
 }
 
 func (rw *responseWriter) Flush() {
@@ -0,0 +1,83 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/fabiolb/fabio/proxy/proxyproto"
+	"github.com/fabiolb/fabio/route"
+)
+
+// proxyProtoKey is the context key for the addresses of the client
+// connection which are sent in the PROXY protocol header.
+type proxyProtoKey struct{}
+
+type proxyProtoAddrs struct {
+	src, dst net.Addr
+}
+
+// proxyProto returns the version of the PROXY protocol header for
+// upstream connections to t. The pxyproto option of the route
+// overrides the default of the listener.
+func (p *HTTPProxy) proxyProto(t *route.Target) int {
+	if t.ProxyProto > 0 {
+		return t.ProxyProto
+	}
+	return p.ProxyProto
+}
+
+// withProxyProtoAddrs returns a copy of r whose context contains the
+// remote and local address of the client connection.
+func withProxyProtoAddrs(r *http.Request) *http.Request {
+	var a proxyProtoAddrs
+	if host, port, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		n, _ := strconv.Atoi(port)
+		if ip := net.ParseIP(host); ip != nil {
+			a.src = &net.TCPAddr{IP: ip, Port: n}
+		}
+	}
+	a.dst, _ = r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	return r.WithContext(context.WithValue(r.Context(), proxyProtoKey{}, a))
+}
+
+// proxyProtoDial returns a dial function which writes a PROXY protocol
+// header with the addresses of the client connection from the context
+// after the connection has been established.
+func proxyProtoDial(dial func(ctx context.Context, network, addr string) (net.Conn, error), version int) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		c, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		a, _ := ctx.Value(proxyProtoKey{}).(proxyProtoAddrs)
+		if err := proxyproto.WriteHeader(c, version, a.src, a.dst); err != nil {
+			c.Close()
+			return nil, err
+		}
+		return c, nil
+	}
+}
+
+// tlsDial establishes a TLS connection over a connection from dial.
+func tlsDial(dial dialFunc, network, addr string, cfg *tls.Config) (net.Conn, error) {
+	c, err := dial(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	if cfg == nil {
+		cfg = &tls.Config{}
+	}
+	if cfg.ServerName == "" {
+		cfg = cfg.Clone()
+		cfg.ServerName, _, _ = net.SplitHostPort(addr)
+	}
+	tc := tls.Client(c, cfg)
+	if err := tc.Handshake(); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return tc, nil
+}
@@ -0,0 +1,101 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/fabiolb/fabio/metrics"
+	"github.com/fabiolb/fabio/route"
+)
+
+// retryTransport retries failed upstream requests on other targets
+// of the same route according to the retry policy of the first target.
+// Only idempotent requests without a body are retried.
+type retryTransport struct {
+	p *HTTPProxy
+
+	// r is the request from the client.
+	r *http.Request
+
+	// host is the Host header of the request from the client.
+	host string
+
+	// target and targetURL are the target and the url
+	// of the last upstream request.
+	target    *route.Target
+	targetURL *url.URL
+}
+
+func (rt *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	policy := rt.target.Retry
+	retryable := idempotent(req.Method) && (req.Body == nil || req.Body == http.NoBody)
+
+	var failed []*route.Target
+	for n := 1; ; n++ {
+		resp, err := observeOutliers(rt.p.transport(rt.target), rt.target).RoundTrip(req)
+		if !retryable || n > policy.Retries || !retry(policy, resp, err) {
+			return resp, err
+		}
+
+		failed = append(failed, rt.target)
+		next := rt.target.RetryTarget(rt.p.Config.Strategy, failed)
+		if next == nil {
+			return resp, err
+		}
+		if resp != nil {
+			io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 4096))
+			resp.Body.Close()
+		}
+
+		metrics.DefaultRegistry.GetCounter("http.retry").Inc(1)
+		if rt.target.TimerName != "" {
+			metrics.DefaultRegistry.GetCounter(rt.target.TimerName + ".retry").Inc(1)
+		}
+
+		if d := policy.Wait(n); d > 0 {
+			select {
+			case <-time.After(d):
+			case <-req.Context().Done():
+				return nil, req.Context().Err()
+			}
+		}
+
+		rt.target, rt.targetURL = next, buildTargetURL(next, rt.r)
+		req = req.Clone(req.Context())
+		req.URL.Scheme = rt.targetURL.Scheme
+		req.URL.Host = rt.targetURL.Host
+		req.URL.Path = rt.targetURL.Path
+		req.URL.RawQuery = rt.targetURL.RawQuery
+		req.Host = targetHost(next, rt.targetURL, rt.host)
+	}
+}
+
+// retry returns true if the result of an upstream request
+// should be retried according to the retry policy.
+func retry(policy route.RetryPolicy, resp *http.Response, err error) bool {
+	if err == nil {
+		return policy.RetryStatus(resp.StatusCode)
+	}
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	var opErr *net.OpError
+	connect := errors.As(err, &opErr) && opErr.Op == "dial"
+	return policy.RetryError(connect)
+}
+
+// idempotent returns true for the request methods
+// which can be safely sent more than once.
+func idempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,116 @@
+package proxy
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fabiolb/fabio/metrics"
+)
+
+// KillSwitch replaces the responses of all HTTP routes to a service
+// with a static response until it expires. It is used to take a
+// failing service out of the traffic during an incident without
+// changing the routes. A kill switch with a zero expiry time does
+// not expire.
+type KillSwitch struct {
+	Service     string    `json:"service"`
+	Status      int       `json:"status"`
+	ContentType string    `json:"content_type"`
+	Body        string    `json:"body"`
+	Reason      string    `json:"reason,omitempty"`
+	Since       time.Time `json:"since"`
+	Expires     time.Time `json:"expires"`
+}
+
+var (
+	// killMu guards kills.
+	killMu sync.Mutex
+	kills  = map[string]KillSwitch{}
+)
+
+// SetKillSwitch enables the kill switch for k.Service and replaces
+// a kill switch which is already enabled for the service.
+func SetKillSwitch(k KillSwitch) {
+	killMu.Lock()
+	kills[k.Service] = k
+	killMu.Unlock()
+	until := "it is disabled"
+	if !k.Expires.IsZero() {
+		until = k.Expires.Format(time.RFC3339)
+	}
+	log.Printf("[INFO] Kill switch for service %q enabled until %s with status %d. %s", k.Service, until, k.Status, k.Reason)
+}
+
+// ClearKillSwitch disables the kill switch for the service. It
+// returns false if the kill switch was not enabled.
+func ClearKillSwitch(service string) bool {
+	killMu.Lock()
+	_, ok := kills[service]
+	delete(kills, service)
+	killMu.Unlock()
+	if ok {
+		log.Printf("[INFO] Kill switch for service %q disabled", service)
+	}
+	return ok
+}
+
+// KillSwitches returns the enabled kill switches sorted by service.
+func KillSwitches() []KillSwitch {
+	killMu.Lock()
+	defer killMu.Unlock()
+	now := time.Now()
+	ks := []KillSwitch{}
+	for _, k := range kills {
+		if !expired(k, now) {
+			ks = append(ks, k)
+		}
+	}
+	sort.Slice(ks, func(i, j int) bool { return ks[i].Service < ks[j].Service })
+	return ks
+}
+
+// killSwitch returns the kill switch for the service if it is enabled.
+// Expired kill switches are removed.
+func killSwitch(service string) (KillSwitch, bool) {
+	killMu.Lock()
+	defer killMu.Unlock()
+	if len(kills) == 0 {
+		return KillSwitch{}, false
+	}
+	k, ok := kills[service]
+	if !ok {
+		return KillSwitch{}, false
+	}
+	if expired(k, time.Now()) {
+		delete(kills, service)
+		log.Printf("[INFO] Kill switch for service %q expired", service)
+		return KillSwitch{}, false
+	}
+	return k, true
+}
+
+func expired(k KillSwitch, now time.Time) bool {
+	return !k.Expires.IsZero() && !now.Before(k.Expires)
+}
+
+// killed writes the static response of the kill switch for the
+// service and returns true if it is enabled.
+func killed(w http.ResponseWriter, service string) bool {
+	k, ok := killSwitch(service)
+	if !ok {
+		return false
+	}
+	metrics.DefaultRegistry.GetCounter("killswitch." + strings.Replace(service, ".", "_", -1)).Inc(1)
+	if k.ContentType != "" {
+		w.Header().Set("Content-Type", k.ContentType)
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(k.Body)))
+	w.WriteHeader(k.Status)
+	w.Write([]byte(k.Body))
+	return true
+}
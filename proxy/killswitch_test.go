@@ -0,0 +1,86 @@
+package proxy
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fabiolb/fabio/route"
+)
+
+func TestKillSwitch(t *testing.T) {
+	defer func() { kills = map[string]KillSwitch{} }()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	}))
+	defer server.Close()
+
+	proxy := httptest.NewServer(&HTTPProxy{
+		Transport: http.DefaultTransport,
+		Lookup: func(r *http.Request) *route.Target {
+			tbl, _ := route.NewTable(bytes.NewBufferString("route add foo /foo " + server.URL + "\nroute add bar /bar " + server.URL))
+			return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"], globCache, globEnabled)
+		},
+	})
+	defer proxy.Close()
+
+	get := func(path string) (int, string, string) {
+		t.Helper()
+		resp, err := http.Get(proxy.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, resp.Header.Get("Content-Type"), string(body)
+	}
+	check := func(path string, status int, contentType, body string) {
+		t.Helper()
+		gotStatus, gotType, gotBody := get(path)
+		if gotStatus != status || gotBody != body || (contentType != "" && gotType != contentType) {
+			t.Fatalf("got %d %q %q want %d %q %q", gotStatus, gotType, gotBody, status, contentType, body)
+		}
+	}
+
+	now := time.Now()
+	SetKillSwitch(KillSwitch{
+		Service:     "foo",
+		Status:      503,
+		ContentType: "application/json",
+		Body:        `{"error":"down"}`,
+		Since:       now,
+		Expires:     now.Add(time.Hour),
+	})
+	check("/foo", 503, "application/json", `{"error":"down"}`)
+	check("/bar", 200, "", "OK")
+	if got := KillSwitches(); len(got) != 1 || got[0].Service != "foo" {
+		t.Fatalf("got kill switches %v want foo", got)
+	}
+
+	if !ClearKillSwitch("foo") {
+		t.Fatal("got false want true")
+	}
+	if ClearKillSwitch("foo") {
+		t.Fatal("got true want false")
+	}
+	check("/foo", 200, "", "OK")
+
+	// kill switches without expiry time do not expire
+	SetKillSwitch(KillSwitch{Service: "foo", Status: 502, Since: now})
+	check("/foo", 502, "", "")
+	ClearKillSwitch("foo")
+
+	// expired kill switches are ignored and removed
+	SetKillSwitch(KillSwitch{Service: "foo", Status: 503, Since: now, Expires: now.Add(-time.Second)})
+	if got := KillSwitches(); len(got) != 0 {
+		t.Fatalf("got kill switches %v want none", got)
+	}
+	check("/foo", 200, "", "OK")
+	if _, ok := kills["foo"]; ok {
+		t.Fatal("expired kill switch was not removed")
+	}
+}
@@ -0,0 +1,103 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/fabiolb/fabio/metrics"
+	"github.com/fabiolb/fabio/route"
+)
+
+// errBodyTooLarge is returned when a request body
+// exceeds the limit of the target.
+var errBodyTooLarge = errors.New("request body too large")
+
+// maxBody returns the maximum size of the request bodies to the
+// target. The option of the route overrides the global default.
+// Zero means that the size is not limited.
+func maxBody(t *route.Target, def int64) int64 {
+	if t.MaxBody > 0 {
+		return t.MaxBody
+	}
+	return def
+}
+
+// limitBody limits the size of the request body to n bytes. Requests
+// with a larger Content-Length are rejected with 413 before the body
+// is streamed to the upstream and limitBody returns false. Bodies
+// without a Content-Length are cut off after n bytes which fails the
+// upstream request with 413 as well.
+func limitBody(w http.ResponseWriter, r *http.Request, n int64) bool {
+	if n <= 0 || r.Body == nil || r.Body == http.NoBody {
+		return true
+	}
+	if r.ContentLength > n {
+		metrics.DefaultRegistry.GetCounter("http.maxbody").Inc(1)
+		w.Header().Set("Connection", "close")
+		http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+		return false
+	}
+	r.Body = &maxBodyReader{ReadCloser: r.Body, n: n}
+	return true
+}
+
+// maxBodyReader returns errBodyTooLarge when more than n bytes
+// are read from the body. The body is read by the transport in
+// a separate goroutine and therefore the error handler checks
+// whether the limit was exceeded with tooLarge.
+type maxBodyReader struct {
+	io.ReadCloser
+
+	// n is the number of bytes which can still be read.
+	n int64
+
+	// exceeded is set to 1 when the limit was exceeded.
+	exceeded int32
+}
+
+func (b *maxBodyReader) Read(p []byte) (int, error) {
+	if b.tooLarge() {
+		return 0, errBodyTooLarge
+	}
+	if int64(len(p)) > b.n+1 {
+		p = p[:b.n+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	if int64(n) <= b.n {
+		b.n -= int64(n)
+		return n, err
+	}
+	n, b.n = int(b.n), 0
+	atomic.StoreInt32(&b.exceeded, 1)
+	metrics.DefaultRegistry.GetCounter("http.maxbody").Inc(1)
+	return n, errBodyTooLarge
+}
+
+// tooLarge returns true if the body exceeded the limit.
+func (b *maxBodyReader) tooLarge() bool {
+	return atomic.LoadInt32(&b.exceeded) == 1
+}
+
+// limitHeaders rejects requests with more than n header fields
+// with 431 before they are handled by h. It returns h if n is
+// not greater than zero.
+func limitHeaders(h http.Handler, n int) http.Handler {
+	if n <= 0 {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		count := 0
+		for _, v := range r.Header {
+			count += len(v)
+		}
+		if count > n {
+			metrics.DefaultRegistry.GetCounter("http.maxheaders").Inc(1)
+			w.Header().Set("Connection", "close")
+			http.Error(w, http.StatusText(http.StatusRequestHeaderFieldsTooLarge), http.StatusRequestHeaderFieldsTooLarge)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,114 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/fabiolb/fabio/config"
+	"github.com/fabiolb/fabio/route"
+)
+
+func TestProxyMaxBody(t *testing.T) {
+	var received int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&received, 1)
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return
+		}
+		w.Write([]byte(strings.ToUpper(string(b))))
+	}))
+	defer server.Close()
+
+	proxy := httptest.NewServer(&HTTPProxy{
+		Config:    config.Proxy{MaxBody: 16},
+		Transport: http.DefaultTransport,
+		Lookup: func(r *http.Request) *route.Target {
+			tbl, _ := route.NewTable(bytes.NewBufferString("route add foo /foo " + server.URL + "\nroute add bar /bar " + server.URL + ` opts "maxbody=1KB"`))
+			return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"], globCache, globEnabled)
+		},
+	})
+	defer proxy.Close()
+
+	post := func(path string, body io.Reader) (int, string) {
+		t.Helper()
+		resp, err := http.Post(proxy.URL+path, "text/plain", body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(b)
+	}
+
+	tests := []struct {
+		desc     string
+		path     string
+		body     io.Reader
+		status   int
+		want     string
+		upstream string
+	}{
+		{"small body", "/foo", strings.NewReader("hello"), 200, "HELLO", "yes"},
+		{"body with the size of the limit", "/foo", strings.NewReader(strings.Repeat("a", 16)), 200, strings.Repeat("A", 16), "yes"},
+		{"content length above the limit", "/foo", strings.NewReader(strings.Repeat("a", 17)), 413, "Request Entity Too Large\n", "no"},
+		// a reader without a known size is sent chunked and the
+		// upstream may or may not have received the request
+		{"chunked body above the limit", "/foo", ioutil.NopCloser(strings.NewReader(strings.Repeat("a", 4096))), 413, "", ""},
+		{"route option overrides the default", "/bar", strings.NewReader(strings.Repeat("a", 1024)), 200, strings.Repeat("A", 1024), "yes"},
+		{"route option above the limit", "/bar", strings.NewReader(strings.Repeat("a", 1025)), 413, "Request Entity Too Large\n", "no"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			atomic.StoreInt32(&received, 0)
+			status, body := post(tt.path, tt.body)
+			if status != tt.status || body != tt.want {
+				t.Fatalf("got %d %q want %d %q", status, body, tt.status, tt.want)
+			}
+			if tt.upstream == "" {
+				return
+			}
+			if got, want := atomic.LoadInt32(&received) > 0, tt.upstream == "yes"; got != want {
+				t.Fatalf("got upstream request %v want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestLimitHeaders(t *testing.T) {
+	h := limitHeaders(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	}), 2)
+
+	tests := []struct {
+		header http.Header
+		status int
+	}{
+		{http.Header{"A": {"1"}}, 200},
+		{http.Header{"A": {"1"}, "B": {"2"}}, 200},
+		{http.Header{"A": {"1", "2"}, "B": {"3"}}, 431},
+	}
+	for i, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header = tt.header
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if got, want := w.Code, tt.status; got != want {
+			t.Fatalf("%d: got %d want %d", i, got, want)
+		}
+	}
+
+	var called bool
+	next := http.HandlerFunc(func(http.ResponseWriter, *http.Request) { called = true })
+	limitHeaders(next, 0).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if !called {
+		t.Fatal("got no call want call without limit")
+	}
+}
@@ -1,26 +1,122 @@
 package proxy
 
 import (
+	"context"
 	"crypto/tls"
 	"fmt"
 	"github.com/fabiolb/fabio/config"
 	"net"
+	"os"
+	"strings"
+	"sync"
 	"time"
 
-	proxyproto "github.com/armon/go-proxyproto"
+	"github.com/fabiolb/fabio/proxy/proxyproto"
+	"github.com/fabiolb/fabio/proxy/sockopt"
 )
 
+// Listen creates a listener for the address of the listener config.
+// Addresses of the form 'unix:<path>' create a unix socket listener
+// and all other addresses a TCP listener.
+func Listen(l config.Listen, cfg *tls.Config) (net.Listener, error) {
+	if strings.HasPrefix(l.Addr, "unix:") {
+		return ListenUnix(l, cfg)
+	}
+	return ListenTCP(l, cfg)
+}
+
+// umaskMu serializes the changes of the umask in ListenUnix.
+var umaskMu sync.Mutex
+
+// ListenUnix creates a listener on the unix socket of the listener
+// config. A stale socket file is removed before the socket is created
+// and the permissions of the socket are set to l.SocketMode which
+// defaults to 0600 so that only the owner can connect.
+func ListenUnix(l config.Listen, cfg *tls.Config) (net.Listener, error) {
+	path := strings.TrimPrefix(l.Addr, "unix:")
+	if path == "" {
+		return nil, fmt.Errorf("listen: Missing path for unix socket")
+	}
+
+	key := "unix:" + path
+	ln, err := inheritedListener(key)
+	if err != nil {
+		return nil, fmt.Errorf("listen: Fail to use inherited listener. %s", err)
+	}
+	if ln != nil {
+		addSocket(key, ln.(*net.UnixListener))
+		if cfg != nil {
+			ln = tls.NewListener(ln, cfg)
+		}
+		return ln, nil
+	}
+
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("listen: Fail to remove stale socket %s. %s", path, err)
+		}
+	}
+
+	mode := l.SocketMode
+	if mode == 0 {
+		mode = 0600
+	}
+
+	// create the socket with its final permissions so that it is not
+	// accessible with the default permissions until the chmod. The
+	// umask is process wide and restored right after the bind.
+	umaskMu.Lock()
+	old := umask(int(0777 &^ mode.Perm()))
+	ln, err = net.Listen("unix", path)
+	umask(old)
+	umaskMu.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("listen: Fail to listen. %s", err)
+	}
+
+	// the umask cannot grant permissions and is not supported on
+	// all platforms.
+	if err := os.Chmod(path, mode); err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("listen: Fail to set permissions of %s. %s", path, err)
+	}
+
+	addSocket(key, ln.(*net.UnixListener))
+
+	// enable TLS
+	if cfg != nil {
+		ln = tls.NewListener(ln, cfg)
+	}
+	return ln, nil
+}
+
+// ListenTCP creates a TCP listener for the listener config and
+// applies the configured socket options before the socket is bound.
 func ListenTCP(l config.Listen, cfg *tls.Config) (net.Listener, error) {
-	addr, err := net.ResolveTCPAddr("tcp", l.Addr)
+	network := ListenNetwork("tcp", l.IPMode)
+	addr, err := net.ResolveTCPAddr(network, l.Addr)
 	if err != nil {
 		return nil, fmt.Errorf("listen: Fail to resolve tcp addr. %s", l.Addr)
 	}
 
-	var ln net.Listener
-	ln, err = net.ListenTCP("tcp", addr)
+	// use the listener of the previous process after an upgrade
+	key := "tcp:" + addr.String()
+	ln, err := inheritedListener(key)
 	if err != nil {
-		return nil, fmt.Errorf("listen: Fail to listen. %s", err)
+		return nil, fmt.Errorf("listen: Fail to use inherited listener. %s", err)
+	}
+	if ln == nil {
+		lc := net.ListenConfig{Control: sockopt.Control(l.Socket)}
+		ln, err = lc.Listen(context.Background(), network, addr.String())
+		if err != nil {
+			return nil, fmt.Errorf("listen: Fail to listen. %s", err)
+		}
 	}
+	addSocket(key, ln.(*net.TCPListener))
+
+	// report the bound address which contains the address
+	// family of the socket and the port for ':0'
+	bound := ln.Addr()
 
 	// enable TCPKeepAlive support
 	ln = tcpKeepAliveListener{ln.(*net.TCPListener)}
@@ -30,6 +126,7 @@ func ListenTCP(l config.Listen, cfg *tls.Config) (net.Listener, error) {
 		ln = &proxyproto.Listener{
 			Listener:           ln,
 			ProxyHeaderTimeout: l.ProxyHeaderTimeout,
+			Trusted:            l.ProxyTrusted,
 		}
 	}
 
@@ -38,7 +135,42 @@ func ListenTCP(l config.Listen, cfg *tls.Config) (net.Listener, error) {
 		ln = tls.NewListener(ln, cfg)
 	}
 
-	return &tcpListener{ln, addr, cfg}, nil
+	return &tcpListener{ln, bound, cfg}, nil
+}
+
+// ListenUDP creates a UDP socket for the listener config and
+// applies the configured socket options before the socket is bound.
+func ListenUDP(l config.Listen) (net.PacketConn, error) {
+	key := "udp:" + l.Addr
+	conn, err := inheritedPacketConn(key)
+	if err != nil {
+		return nil, fmt.Errorf("listen: Fail to use inherited listener. %s", err)
+	}
+	if conn == nil {
+		lc := net.ListenConfig{Control: sockopt.Control(l.Socket)}
+		conn, err = lc.ListenPacket(context.Background(), ListenNetwork("udp", l.IPMode), l.Addr)
+		if err != nil {
+			return nil, fmt.Errorf("listen: Fail to listen. %s", err)
+		}
+	}
+	addSocket(key, conn.(*net.UDPConn))
+	return conn, nil
+}
+
+// ListenNetwork returns the network for net.Listen for the "tcp" or
+// "udp" base network and the ipmode of a listener. "v4" and "v6" bind
+// to a single address family and "v6" disables IPv4-mapped addresses
+// on the socket. "dual" and no ipmode use the base network which binds
+// wildcard addresses to both address families.
+func ListenNetwork(base, mode string) string {
+	switch mode {
+	case "v4":
+		return base + "4"
+	case "v6":
+		return base + "6"
+	default:
+		return base
+	}
 }
 
 type tcpListener struct {
@@ -1,9 +1,16 @@
 package proxy
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"testing"
@@ -82,3 +89,246 @@ func TestGracefulShutdown(t *testing.T) {
 	// note that the actual listeners have not returned yet
 	wg.Wait()
 }
+
+func TestListenHTTP2Settings(t *testing.T) {
+	tests := []struct {
+		desc  string
+		addr  string
+		h2    config.HTTP2
+		proto string
+	}{
+		{"default", "127.0.0.1:57778", config.HTTP2{}, "HTTP/2.0"},
+		{"settings", "127.0.0.1:57779", config.HTTP2{MaxConcurrentStreams: 10, MaxReadFrameSize: 32768, IdleTimeout: time.Second}, "HTTP/2.0"},
+		{"disabled", "127.0.0.1:57780", config.HTTP2{Disabled: true}, "HTTP/1.1"},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.desc, func(t *testing.T) {
+			h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(r.Proto))
+			})
+			cfg := tlsServerConfig()
+			cfg.NextProtos = []string{"h2", "http/1.1"}
+			l := config.Listen{Addr: tt.addr, Proto: "https", HTTP2: tt.h2}
+			go func() {
+				if err := ListenAndServeHTTP(l, h, cfg); err != nil {
+					t.Log("ListenAndServeHTTP: ", err)
+				}
+			}()
+			defer CloseProxy(tt.addr)
+
+			client := &http.Client{Transport: &http.Transport{
+				TLSClientConfig:   tlsInsecureConfig(),
+				ForceAttemptHTTP2: true,
+			}}
+			var resp *http.Response
+			var err error
+			for i := 0; i < 20; i++ {
+				if resp, err = client.Get("https://" + tt.addr + "/"); err == nil {
+					break
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body, _ := ioutil.ReadAll(resp.Body)
+			if got, want := string(body), tt.proto; got != want {
+				t.Fatalf("got %s want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestListenUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fabio")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// a stale socket of a previous run must not prevent the start
+	path := filepath.Join(dir, "fabio.sock")
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	})
+	l := config.Listen{Addr: "unix:" + path, Proto: "http"}
+	go func() {
+		if err := ListenAndServeHTTP(l, h, nil); err != nil {
+			t.Log("ListenAndServeHTTP: ", err)
+		}
+	}()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		},
+	}}
+	var resp *http.Response
+	for i := 0; i < 20; i++ {
+		if resp, err = client.Get("http://fabio/"); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if got, want := string(body), "OK"; got != want {
+		t.Fatalf("got %s want %s", got, want)
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fi.Mode().Perm(), os.FileMode(0600); got != want {
+		t.Fatalf("got mode %v want %v", got, want)
+	}
+
+	// the server is registered with the address of the listener
+	// which is the path of the socket.
+	if err := CloseProxy(path); err != nil {
+		t.Fatal(err)
+	}
+	for _, addr := range Addrs() {
+		if addr == path {
+			t.Fatalf("server for %s still running", path)
+		}
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("got %v want socket removed", err)
+	}
+}
+
+func TestListenProxyProtoTrusted(t *testing.T) {
+	_, localhost, _ := net.ParseCIDR("127.0.0.0/8")
+	_, other, _ := net.ParseCIDR("10.0.0.0/8")
+
+	tests := []struct {
+		desc    string
+		addr    string
+		trusted []*net.IPNet
+		header  string
+		remote  string
+	}{
+		{"trusted", "127.0.0.1:57781", []*net.IPNet{localhost}, "PROXY TCP4 1.2.3.4 5.6.7.8 1234 80\r\n", "1.2.3.4"},
+		// the header of an untrusted client would be
+		// treated as the start of the request.
+		{"untrusted", "127.0.0.1:57782", []*net.IPNet{other}, "", "127.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.desc, func(t *testing.T) {
+			h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				host, _, _ := net.SplitHostPort(r.RemoteAddr)
+				w.Write([]byte(host))
+			})
+			l := config.Listen{Addr: tt.addr, ProxyProto: true, ProxyHeaderTimeout: time.Second, ProxyTrusted: tt.trusted}
+			go func() {
+				if err := ListenAndServeHTTP(l, h, nil); err != nil {
+					t.Log("ListenAndServeHTTP: ", err)
+				}
+			}()
+			defer CloseProxy(tt.addr)
+
+			var c net.Conn
+			var err error
+			for i := 0; i < 20; i++ {
+				if c, err = net.Dial("tcp", tt.addr); err == nil {
+					break
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+
+			req, _ := http.NewRequest("GET", "http://"+tt.addr+"/", nil)
+			c.Write([]byte(tt.header))
+			if err := req.Write(c); err != nil {
+				t.Fatal(err)
+			}
+			resp, err := http.ReadResponse(bufio.NewReader(c), req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body, _ := ioutil.ReadAll(resp.Body)
+			if got, want := string(body), tt.remote; got != want {
+				t.Fatalf("got %s want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestListenIPMode(t *testing.T) {
+	if ln, err := net.Listen("tcp6", "[::1]:0"); err != nil {
+		t.Skip("IPv6 not supported: ", err)
+	} else {
+		ln.Close()
+	}
+
+	tests := []struct {
+		mode   string
+		v4, v6 bool
+		ip     string
+	}{
+		{"v4", true, false, "0.0.0.0"},
+		{"v6", false, true, "::"},
+		{"dual", true, true, "::"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			ln, err := ListenTCP(config.Listen{Addr: ":0", IPMode: tt.mode}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ln.Close()
+
+			addr := ln.Addr().(*net.TCPAddr)
+			if got, want := addr.IP.String(), tt.ip; got != want {
+				t.Fatalf("got addr %s want %s", got, want)
+			}
+			go func() {
+				for {
+					c, err := ln.Accept()
+					if err != nil {
+						return
+					}
+					c.Close()
+				}
+			}()
+
+			dial := func(host string) bool {
+				c, err := net.DialTimeout("tcp", net.JoinHostPort(host, strconv.Itoa(addr.Port)), time.Second)
+				if err != nil {
+					return false
+				}
+				c.Close()
+				return true
+			}
+			if got, want := dial("127.0.0.1"), tt.v4; got != want {
+				t.Errorf("got IPv4 connect %v want %v", got, want)
+			}
+			if got, want := dial("::1"), tt.v6; got != want {
+				t.Errorf("got IPv6 connect %v want %v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,214 @@
+package proxy
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/fabiolb/fabio/route"
+)
+
+// Middleware processes an HTTP request to the target t before it is
+// forwarded. It returns false if it has responded to the request
+// which is then not forwarded and the remaining middlewares of the
+// chain are skipped.
+type Middleware func(p *HTTPProxy, t *route.Target, w http.ResponseWriter, r *http.Request) bool
+
+// DefaultMiddleware is the middleware chain of routes if neither
+// the proxy nor the route configure one.
+var DefaultMiddleware = []string{"ratelimit", "access", "auth", "sticky", "headers"}
+
+// middlewares contains the registered middlewares, response
+// filters and target selectors.
+var middlewares = struct {
+	sync.RWMutex
+	m         map[string]Middleware
+	resp      map[string]ResponseFilter
+	selectors []namedSelector
+}{
+	m: map[string]Middleware{
+		"ratelimit": rateLimitMiddleware,
+		"access":    accessMiddleware,
+		"auth":      authMiddleware,
+		"sticky":    stickyMiddleware,
+		"headers":   headersMiddleware,
+	},
+	resp: map[string]ResponseFilter{},
+}
+
+// RegisterMiddleware registers a middleware under a name so that it
+// can be used in the middleware chain of the proxy and of the routes.
+// It panics if the name is empty or already registered.
+func RegisterMiddleware(name string, m Middleware) {
+	middlewares.Lock()
+	defer middlewares.Unlock()
+	if name == "" || m == nil {
+		panic("proxy: invalid middleware")
+	}
+	if _, ok := middlewares.m[name]; ok {
+		panic("proxy: middleware " + name + " already registered")
+	}
+	middlewares.m[name] = m
+}
+
+// CheckMiddleware returns an error if one of the names is neither
+// a registered middleware nor a response filter.
+func CheckMiddleware(names []string) error {
+	middlewares.RLock()
+	defer middlewares.RUnlock()
+	var unknown []string
+	for _, name := range names {
+		if !knownMiddleware(name) {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		known := map[string]bool{}
+		for name := range middlewares.m {
+			known[name] = true
+		}
+		for name := range middlewares.resp {
+			known[name] = true
+		}
+		var valid []string
+		for name := range known {
+			valid = append(valid, name)
+		}
+		sort.Strings(valid)
+		return fmt.Errorf("unknown middleware %s. Valid middlewares are %s", strings.Join(unknown, ","), strings.Join(valid, ","))
+	}
+	return nil
+}
+
+// knownMiddleware returns true if name is a registered middleware or
+// response filter. The caller must hold the lock.
+func knownMiddleware(name string) bool {
+	return middlewares.m[name] != nil || middlewares.resp[name] != nil
+}
+
+// chain returns the names of the middlewares for requests to t. The
+// chain of the route takes precedence over the chain of the proxy.
+// The access and auth middlewares are always part of the chain if
+// the target has access rules or an auth scheme so that a chain
+// without them does not disable the protection of the route.
+func (p *HTTPProxy) chain(t *route.Target) []string {
+	var chain []string
+	switch {
+	case t.Middleware != nil:
+		chain = t.Middleware
+	case p.Config.Middleware != nil:
+		chain = p.Config.Middleware
+	default:
+		chain = DefaultMiddleware
+	}
+
+	var required []string
+	if t.HasAccessRules() && !hasMiddleware(chain, "access") {
+		required = append(required, "access")
+	}
+	if t.AuthScheme != "" && !hasMiddleware(chain, "auth") {
+		required = append(required, "auth")
+	}
+	if len(required) == 0 {
+		return chain
+	}
+	return append(required, chain...)
+}
+
+func hasMiddleware(chain []string, name string) bool {
+	for _, n := range chain {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// middleware runs the middlewares of the chain for a request to t and
+// returns false if the request must not be forwarded. Requests to
+// routes with an unknown middleware are rejected.
+func (p *HTTPProxy) middleware(chain []string, t *route.Target, w http.ResponseWriter, r *http.Request) bool {
+	for _, name := range chain {
+		middlewares.RLock()
+		m, known := middlewares.m[name], knownMiddleware(name)
+		middlewares.RUnlock()
+		if !known {
+			log.Printf("[ERROR] Unknown middleware %q for route to %s", name, t.URL)
+			http.Error(w, "unknown middleware", http.StatusInternalServerError)
+			return false
+		}
+		if m != nil && !m(p, t, w, r) {
+			return false
+		}
+	}
+	return true
+}
+
+// rateLimitMiddleware enforces the rate limit of the route.
+// The global rate limit applies before the route lookup.
+func rateLimitMiddleware(p *HTTPProxy, t *route.Target, w http.ResponseWriter, r *http.Request) bool {
+	return t.RateLimit == nil || !p.rateLimited(w, t.RateLimit, t.TimerName)
+}
+
+// accessMiddleware enforces the allow and deny rules of the route.
+func accessMiddleware(p *HTTPProxy, t *route.Target, w http.ResponseWriter, r *http.Request) bool {
+	if t.AccessDeniedHTTP(r) {
+		http.Error(w, "access denied", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// authMiddleware enforces the auth scheme of the route.
+func authMiddleware(p *HTTPProxy, t *route.Target, w http.ResponseWriter, r *http.Request) bool {
+	aw := &authResponseWriter{ResponseWriter: w}
+	if !t.Authorized(r, aw, p.AuthSchemes) {
+		if !aw.written {
+			http.Error(w, "authorization failed", http.StatusUnauthorized)
+		}
+		return false
+	}
+	return true
+}
+
+// authResponseWriter records whether an auth scheme
+// has responded to an unauthorized request.
+type authResponseWriter struct {
+	http.ResponseWriter
+	written bool
+}
+
+func (w *authResponseWriter) WriteHeader(code int) {
+	w.written = true
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *authResponseWriter) Write(b []byte) (int, error) {
+	w.written = true
+	return w.ResponseWriter.Write(b)
+}
+
+// stickyMiddleware sets the sticky session cookie of the route.
+func stickyMiddleware(p *HTTPProxy, t *route.Target, w http.ResponseWriter, r *http.Request) bool {
+	t.SetStickyCookie(w, r)
+	return true
+}
+
+// headersMiddleware adds the forwarding headers and the headers
+// of the route to the request and the configured headers to the
+// response.
+func headersMiddleware(p *HTTPProxy, t *route.Target, w http.ResponseWriter, r *http.Request) bool {
+	if err := addHeaders(r, p.Config, t.StripPath); err != nil {
+		http.Error(w, "cannot parse "+r.RemoteAddr, http.StatusInternalServerError)
+		return false
+	}
+	t.RequestHeaders.Apply(r.Header)
+	if err := addResponseHeaders(w, r, p.Config); err != nil {
+		http.Error(w, "cannot add response headers", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
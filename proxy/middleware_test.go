@@ -0,0 +1,252 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fabiolb/fabio/auth"
+	"github.com/fabiolb/fabio/config"
+	"github.com/fabiolb/fabio/route"
+)
+
+func TestCheckMiddleware(t *testing.T) {
+	if err := CheckMiddleware(DefaultMiddleware); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckMiddleware([]string{"auth", "nope"}); err == nil {
+		t.Fatal("got nil want error")
+	}
+}
+
+func TestProxyMiddleware(t *testing.T) {
+	RegisterMiddleware("test-block", func(p *HTTPProxy, t *route.Target, w http.ResponseWriter, r *http.Request) bool {
+		if r.Header.Get("X-Block") != "" {
+			http.Error(w, "blocked", http.StatusTeapot)
+			return false
+		}
+		r.Header.Set("X-Checked", "true")
+		return true
+	})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get("X-Checked") + " " + r.Header.Get("X-Forwarded-Proto")))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		desc       string
+		middleware []string
+		opts       string
+		block      bool
+		code       int
+		body       string
+	}{
+		{"default chain", nil, "", true, 200, " http"},
+		{"proxy chain", []string{"test-block", "headers"}, "", false, 200, "true http"},
+		{"proxy chain blocks", []string{"test-block", "headers"}, "", true, 418, "blocked\n"},
+		{"no middleware", []string{}, "", false, 200, " "},
+		{"route chain", nil, `opts "middleware=test-block"`, false, 200, "true "},
+		{"route chain overrides proxy chain", []string{"test-block"}, `opts "middleware=none"`, true, 200, " "},
+		{"unknown middleware", nil, `opts "middleware=nope"`, false, 500, "unknown middleware\n"},
+		{"access rules without access middleware", nil, `opts "middleware=none allow=ip:10.0.0.0/8"`, false, 403, "access denied\n"},
+		{"access rules without proxy access middleware", []string{"headers"}, `opts "deny=ip:127.0.0.0/8"`, false, 403, "access denied\n"},
+		{"auth scheme without auth middleware", nil, `opts "middleware=headers auth=nope"`, false, 401, "authorization failed\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			proxy := httptest.NewServer(&HTTPProxy{
+				Config:    config.Proxy{Middleware: tt.middleware},
+				Transport: http.DefaultTransport,
+				Lookup: func(r *http.Request) *route.Target {
+					tbl, _ := route.NewTable(bytes.NewBufferString("route add mock / " + server.URL + " " + tt.opts))
+					return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"], globCache, globEnabled)
+				},
+			})
+			defer proxy.Close()
+
+			req, _ := http.NewRequest("GET", proxy.URL, nil)
+			if tt.block {
+				req.Header.Set("X-Block", "true")
+			}
+			resp, body := mustDo(req)
+			if got, want := resp.StatusCode, tt.code; got != want {
+				t.Fatalf("got status %d want %d", got, want)
+			}
+			if got, want := string(body), tt.body; got != want {
+				t.Fatalf("got body %q want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestProxyHeaderOps(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "backend")
+		w.Header().Set("X-Internal", "secret")
+		w.Write([]byte(r.Header.Get("X-Env") + " " + r.Header.Get("X-Tenant") + " " + r.Header.Get("X-Debug")))
+	}))
+	defer server.Close()
+
+	opts := `opts "reqhdr-add=X-Env:prod reqhdr-set=X-Tenant:42 reqhdr-del=X-Debug resphdr-del=Server,X-Internal resphdr-add=X-Served-By:fabio"`
+	proxy := httptest.NewServer(&HTTPProxy{
+		Transport: http.DefaultTransport,
+		Lookup: func(r *http.Request) *route.Target {
+			tbl, _ := route.NewTable(bytes.NewBufferString("route add mock / " + server.URL + " " + opts))
+			return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"], globCache, globEnabled)
+		},
+	})
+	defer proxy.Close()
+
+	req, _ := http.NewRequest("GET", proxy.URL, nil)
+	req.Header.Set("X-Tenant", "1")
+	req.Header.Set("X-Debug", "true")
+	resp, body := mustDo(req)
+	if got, want := string(body), "prod 42 "; got != want {
+		t.Fatalf("got body %q want %q", got, want)
+	}
+	if got := resp.Header.Get("Server") + resp.Header.Get("X-Internal"); got != "" {
+		t.Fatalf("got removed headers %q", got)
+	}
+	if got, want := resp.Header.Get("X-Served-By"), "fabio"; got != want {
+		t.Fatalf("got X-Served-By %q want %q", got, want)
+	}
+}
+
+type testAuth struct{}
+
+func (testAuth) Authorized(r *http.Request, w http.ResponseWriter) bool {
+	switch r.Header.Get("X-Auth") {
+	case "ok":
+		return true
+	case "redirect":
+		http.Redirect(w, r, "https://login/", http.StatusFound)
+		return false
+	default:
+		return false
+	}
+}
+
+func TestProxyAuthResponse(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	proxy := httptest.NewServer(&HTTPProxy{
+		Transport:   http.DefaultTransport,
+		AuthSchemes: map[string]auth.AuthScheme{"test": testAuth{}},
+		Lookup: func(r *http.Request) *route.Target {
+			tbl, _ := route.NewTable(bytes.NewBufferString("route add mock / " + server.URL + ` opts "auth=test"`))
+			return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"], globCache, globEnabled)
+		},
+	})
+	defer proxy.Close()
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	tests := []struct {
+		auth string
+		code int
+	}{
+		{"ok", 200},
+		{"redirect", 302},
+		{"", 401},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest("GET", proxy.URL, nil)
+		req.Header.Set("X-Auth", tt.auth)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if got, want := resp.StatusCode, tt.code; got != want {
+			t.Fatalf("%q: got status %d want %d", tt.auth, got, want)
+		}
+	}
+}
+
+type testPlugin struct{}
+
+func (testPlugin) FilterRequest(w http.ResponseWriter, r *http.Request, t *route.Target) bool {
+	r.Header.Set("X-Plugin", t.Service)
+	return true
+}
+
+func (testPlugin) FilterResponse(resp *http.Response, t *route.Target) error {
+	if resp.Header.Get("X-Fail") != "" {
+		return errors.New("fail")
+	}
+	resp.Header.Set("X-Plugin-Target", t.URL.Host)
+	return nil
+}
+
+func (testPlugin) SelectTarget(r *http.Request, t *route.Target) *route.Target {
+	if u := r.Header.Get("X-Plugin-Select"); u != "" {
+		return &route.Target{Service: "selected", URL: mustParse(u)}
+	}
+	return t
+}
+
+func TestProxyPlugin(t *testing.T) {
+	RegisterRequestFilter("test-plugin", testPlugin{})
+	RegisterResponseFilter("test-plugin", testPlugin{})
+	RegisterTargetSelector("test-plugin", testPlugin{})
+
+	if err := CheckMiddleware([]string{"test-plugin"}); err != nil {
+		t.Fatal(err)
+	}
+
+	handler := func(name string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/fail" {
+				w.Header().Set("X-Fail", "true")
+			}
+			w.Write([]byte(name + " " + r.Header.Get("X-Plugin")))
+		})
+	}
+	server := httptest.NewServer(handler("server"))
+	defer server.Close()
+	other := httptest.NewServer(handler("other"))
+	defer other.Close()
+
+	proxy := httptest.NewServer(&HTTPProxy{
+		Config:    config.Proxy{Middleware: []string{"test-plugin"}},
+		Transport: http.DefaultTransport,
+		Lookup: func(r *http.Request) *route.Target {
+			tbl, _ := route.NewTable(bytes.NewBufferString("route add mock / " + server.URL))
+			return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"], globCache, globEnabled)
+		},
+	})
+	defer proxy.Close()
+
+	tests := []struct {
+		desc, path, selected string
+		code                 int
+		body, target         string
+	}{
+		{"filters", "/", "", 200, "server mock", mustParse(server.URL).Host},
+		{"selector", "/", other.URL, 200, "other selected", mustParse(other.URL).Host},
+		{"response filter error", "/fail", "", 500, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", proxy.URL+tt.path, nil)
+			if tt.selected != "" {
+				req.Header.Set("X-Plugin-Select", tt.selected)
+			}
+			resp, body := mustDo(req)
+			if got, want := resp.StatusCode, tt.code; got != want {
+				t.Fatalf("got status %d want %d", got, want)
+			}
+			if got, want := string(body), tt.body; got != want {
+				t.Fatalf("got body %q want %q", got, want)
+			}
+			if got, want := resp.Header.Get("X-Plugin-Target"), tt.target; got != want {
+				t.Fatalf("got target %q want %q", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,211 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/fabiolb/fabio/config"
+	"github.com/fabiolb/fabio/metrics"
+	"github.com/fabiolb/fabio/route"
+)
+
+// Mirror sends copies of the requests to targets with the mirror
+// option to a shadow environment. The copies are sent in the
+// background and their responses are discarded so that the shadow
+// environment cannot affect the original requests.
+type Mirror struct {
+	cfg    config.Mirror
+	client *http.Client
+
+	// sem limits the number of copies in flight.
+	sem chan struct{}
+}
+
+var (
+	// mirrorMu guards mirrorPercents.
+	mirrorMu       sync.Mutex
+	mirrorPercents = map[string]float64{}
+)
+
+// SetMirrorPercent limits the copies of the requests to the targets
+// of the service to a percentage of the requests.
+func SetMirrorPercent(service string, percent float64) {
+	mirrorMu.Lock()
+	mirrorPercents[service] = percent
+	mirrorMu.Unlock()
+	log.Printf("[INFO] Mirroring %g%% of the requests to service %q", percent, service)
+}
+
+// ClearMirrorPercent mirrors all requests to the targets
+// of the service again.
+func ClearMirrorPercent(service string) {
+	mirrorMu.Lock()
+	_, ok := mirrorPercents[service]
+	delete(mirrorPercents, service)
+	mirrorMu.Unlock()
+	if ok {
+		log.Printf("[INFO] Mirroring all requests to service %q", service)
+	}
+}
+
+// mirrored returns true if the request to the service is selected
+// for mirroring.
+func mirrored(service string) bool {
+	mirrorMu.Lock()
+	p, ok := mirrorPercents[service]
+	mirrorMu.Unlock()
+	return !ok || sample() < p
+}
+
+// NewMirror creates a mirror which sends the copies via the transport.
+func NewMirror(cfg config.Mirror, tr http.RoundTripper) *Mirror {
+	return &Mirror{
+		cfg: cfg,
+		client: &http.Client{
+			Transport: tr,
+			Timeout:   cfg.Timeout,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		sem: make(chan struct{}, cfg.MaxConn),
+	}
+}
+
+// Send sends a copy of the request to the target to the shadow
+// environment of the target. The body of the request is buffered
+// and replaced so that it can still be sent to the target. Requests
+// with a body which is larger than the limit are not mirrored.
+func (m *Mirror) Send(t *route.Target, r *http.Request, targetURL *url.URL) {
+	if !mirrored(t.Service) {
+		return
+	}
+
+	body, ok := m.body(r)
+	if !ok {
+		metrics.DefaultRegistry.GetCounter("mirror.dropped").Inc(1)
+		return
+	}
+
+	select {
+	case m.sem <- struct{}{}:
+	default:
+		metrics.DefaultRegistry.GetCounter("mirror.dropped").Inc(1)
+		return
+	}
+
+	req := m.request(t, r, targetURL, body)
+	go func() {
+		defer func() { <-m.sem }()
+		metrics.DefaultRegistry.GetCounter("mirror.requests").Inc(1)
+		resp, err := m.client.Do(req)
+		if err != nil {
+			metrics.DefaultRegistry.GetCounter("mirror.failed").Inc(1)
+			log.Printf("[WARN] mirror: Cannot send request to %s. %s", req.URL.Host, err)
+			return
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+}
+
+// body reads the request body up to the limit and replaces the body
+// of the request with a copy. It returns false if the body is larger
+// than the limit or cannot be read.
+func (m *Mirror) body(r *http.Request) ([]byte, bool) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, true
+	}
+	if r.ContentLength > m.cfg.MaxBody {
+		return nil, false
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(r.Body, m.cfg.MaxBody+1))
+	if err != nil || int64(len(b)) > m.cfg.MaxBody {
+		// pass the part which has been read and the rest to the target
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(b), r.Body), r.Body}
+		return nil, false
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(b))
+	return b, true
+}
+
+// request builds the copy of the request. The path of the mirror URL
+// is prepended to the path of the target URL. The copy is marked with
+// the mirror header and the headers which are configured to be
+// stripped are removed.
+func (m *Mirror) request(t *route.Target, r *http.Request, targetURL *url.URL, body []byte) *http.Request {
+	u := &url.URL{
+		Scheme:   t.Mirror.URL.Scheme,
+		Host:     t.Mirror.URL.Host,
+		Path:     t.Mirror.URL.Path + targetURL.Path,
+		RawQuery: targetURL.RawQuery,
+	}
+	if targetURL.RawPath != "" {
+		u.RawPath = t.Mirror.URL.EscapedPath() + targetURL.RawPath
+	}
+
+	header := r.Header.Clone()
+	for _, h := range hopHeaders {
+		header.Del(h)
+	}
+	strip := t.Mirror.Strip
+	if strip == nil {
+		strip = m.cfg.Strip
+	}
+	for _, h := range strip {
+		switch strings.ToLower(h) {
+		case "auth":
+			header.Del("Authorization")
+			header.Del("Proxy-Authorization")
+		case "cookie", "cookies":
+			header.Del("Cookie")
+		default:
+			header.Del(h)
+		}
+	}
+	name := t.Mirror.Header
+	if name == "" {
+		name = m.cfg.Header
+	}
+	header.Set(name, "true")
+
+	req := &http.Request{
+		Method:        r.Method,
+		URL:           u,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Host:          u.Host,
+		ContentLength: int64(len(body)),
+	}
+	if body != nil {
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		req.GetBody = func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(body)), nil
+		}
+	}
+	return req.WithContext(context.Background())
+}
+
+// hopHeaders are the headers which are removed from the copies since
+// they only apply to the connection of the client.
+var hopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
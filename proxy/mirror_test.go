@@ -0,0 +1,146 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fabiolb/fabio/config"
+	"github.com/fabiolb/fabio/route"
+)
+
+func TestProxyMirror(t *testing.T) {
+	type request struct {
+		path, body string
+		header     http.Header
+	}
+	mirrored := make(chan request, 10)
+	shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		mirrored <- request{r.URL.RequestURI(), string(b), r.Header}
+		w.WriteHeader(500)
+	}))
+	defer shadow.Close()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		w.Write([]byte(r.Header.Get("Authorization") + " " + r.Header.Get("Cookie") + " " + string(b)))
+	}))
+	defer server.Close()
+
+	routes := "route add foo /foo " + server.URL + ` opts "mirror=` + shadow.URL + `/shadow"` + "\n" +
+		"route add bar /bar " + server.URL + ` opts "mirror=` + shadow.URL + ` mirrorheader=X-Shadow mirrorstrip=X-Api-Key"` + "\n" +
+		"route add baz /baz " + server.URL
+	cfg := config.Mirror{Header: "X-Fabio-Mirror", Strip: []string{"cookie", "auth"}, Timeout: time.Second, MaxBody: 16, MaxConn: 10}
+	proxy := httptest.NewServer(&HTTPProxy{
+		Transport: http.DefaultTransport,
+		Mirror:    NewMirror(cfg, http.DefaultTransport),
+		Lookup: func(r *http.Request) *route.Target {
+			tbl, _ := route.NewTable(bytes.NewBufferString(routes))
+			return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"], globCache, globEnabled)
+		},
+	})
+	defer proxy.Close()
+
+	send := func(path string, body io.Reader) string {
+		t.Helper()
+		req, _ := http.NewRequest("POST", proxy.URL+path, body)
+		req.Header.Set("Authorization", "Bearer secret")
+		req.Header.Set("Cookie", "session=abc")
+		req.Header.Set("X-Api-Key", "key")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != 200 {
+			t.Fatalf("got status %d want 200", resp.StatusCode)
+		}
+		b, _ := ioutil.ReadAll(resp.Body)
+		return string(b)
+	}
+	receive := func() request {
+		t.Helper()
+		select {
+		case r := <-mirrored:
+			return r
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for the mirrored request")
+		}
+		return request{}
+	}
+
+	// the original request is not modified
+	if got, want := send("/foo?a=b", strings.NewReader("hello")), "Bearer secret session=abc hello"; got != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+	r := receive()
+	if got, want := r.path+" "+r.body, "/shadow/foo?a=b hello"; got != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+	if got, want := r.header.Get("X-Fabio-Mirror"), "true"; got != want {
+		t.Fatalf("got mirror header %q want %q", got, want)
+	}
+	for _, h := range []string{"Authorization", "Cookie"} {
+		if v := r.header.Get(h); v != "" {
+			t.Fatalf("got %s %q want none", h, v)
+		}
+	}
+	if got, want := r.header.Get("X-Api-Key"), "key"; got != want {
+		t.Fatalf("got X-Api-Key %q want %q", got, want)
+	}
+
+	// the options of the route override the defaults
+	send("/bar", nil)
+	r = receive()
+	if got, want := r.header.Get("X-Shadow"), "true"; got != want {
+		t.Fatalf("got mirror header %q want %q", got, want)
+	}
+	if r.header.Get("X-Api-Key") != "" || r.header.Get("Authorization") != "Bearer secret" {
+		t.Fatalf("got headers %v want X-Api-Key stripped", r.header)
+	}
+
+	// bodies above the limit and routes without the option are not mirrored
+	body := strings.Repeat("a", 17)
+	if got, want := send("/foo", strings.NewReader(body)), "Bearer secret session=abc "+body; got != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+	// without a content length
+	if got, want := send("/foo", ioutil.NopCloser(strings.NewReader(body))), "Bearer secret session=abc "+body; got != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+	send("/baz", strings.NewReader("hello"))
+	select {
+	case r := <-mirrored:
+		t.Fatalf("got mirrored request %+v want none", r)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestMirrorPercent(t *testing.T) {
+	defer func(f func() float64) { sample = f }(sample)
+	defer func() { mirrorPercents = map[string]float64{} }()
+
+	sample = func() float64 { return 20 }
+	if !mirrored("foo") {
+		t.Fatal("got not mirrored want mirrored without percentage")
+	}
+	SetMirrorPercent("foo", 10)
+	if mirrored("foo") {
+		t.Fatal("got mirrored want not mirrored above percentage")
+	}
+	SetMirrorPercent("foo", 30)
+	if !mirrored("foo") {
+		t.Fatal("got not mirrored want mirrored below percentage")
+	}
+	SetMirrorPercent("foo", 0)
+	ClearMirrorPercent("foo")
+	if !mirrored("foo") {
+		t.Fatal("got not mirrored want mirrored after clear")
+	}
+}
@@ -0,0 +1,42 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/fabiolb/fabio/route"
+)
+
+// outlierTransport reports the result of the upstream requests to the
+// outlier detection of the target. Connection errors, 5xx responses
+// and responses slower than route.OutlierLatency count as failed.
+// Requests which the client has canceled are not counted.
+type outlierTransport struct {
+	rt http.RoundTripper
+	t  *route.Target
+}
+
+// observeOutliers wraps the transport if the outlier detection is enabled.
+func observeOutliers(rt http.RoundTripper, t *route.Target) http.RoundTripper {
+	if route.OutlierThreshold <= 0 {
+		return rt
+	}
+	return &outlierTransport{rt, t}
+}
+
+func (o *outlierTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := o.rt.RoundTrip(req)
+	switch {
+	case err != nil:
+		if !errors.Is(err, context.Canceled) {
+			o.t.RecordOutcome(true)
+		}
+	default:
+		slow := route.OutlierLatency > 0 && time.Since(start) > route.OutlierLatency
+		o.t.RecordOutcome(resp.StatusCode >= 500 || slow)
+	}
+	return resp, err
+}
@@ -0,0 +1,64 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/fabiolb/fabio/route"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+func TestOutlierTransport(t *testing.T) {
+	route.OutlierThreshold, route.OutlierMinRequests = 0.5, 4
+	defer func() { route.OutlierThreshold, route.OutlierMinRequests = 0, 20 }()
+
+	tests := []struct {
+		desc    string
+		resp    *http.Response
+		err     error
+		ejected bool
+	}{
+		{"ok", &http.Response{StatusCode: 404}, nil, false},
+		{"canceled", nil, context.Canceled, false},
+		{"5xx", &http.Response{StatusCode: 502}, nil, true},
+		{"conn error", nil, errors.New("connection refused"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			tg := &route.Target{Service: "outlier-" + tt.desc, URL: &url.URL{Scheme: "http", Host: "1.2.3.4:80"}}
+			rt := observeOutliers(roundTripFunc(func(*http.Request) (*http.Response, error) {
+				return tt.resp, tt.err
+			}), tg)
+			req, _ := http.NewRequest("GET", "http://1.2.3.4/", nil)
+			for i := 0; i < 4; i++ {
+				rt.RoundTrip(req)
+			}
+			if got, want := tg.Ejected(), tt.ejected; got != want {
+				t.Fatalf("got ejected %v want %v", got, want)
+			}
+		})
+	}
+
+	// slow responses count as failed
+	route.OutlierLatency = time.Millisecond
+	defer func() { route.OutlierLatency = 0 }()
+	tg := &route.Target{Service: "outlier-slow", URL: &url.URL{Scheme: "http", Host: "1.2.3.4:80"}}
+	rt := observeOutliers(roundTripFunc(func(*http.Request) (*http.Response, error) {
+		time.Sleep(5 * time.Millisecond)
+		return &http.Response{StatusCode: 200}, nil
+	}), tg)
+	req, _ := http.NewRequest("GET", "http://1.2.3.4/", nil)
+	for i := 0; i < 4; i++ {
+		rt.RoundTrip(req)
+	}
+	if !tg.Ejected() {
+		t.Fatal("slow target not ejected")
+	}
+}
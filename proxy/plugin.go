@@ -0,0 +1,139 @@
+package proxy
+
+import (
+	"net/http"
+
+	"github.com/fabiolb/fabio/route"
+)
+
+// The interfaces in this file are the extension points for custom
+// builds of fabio. A package registers its implementations in an init
+// function and is compiled into fabio with a blank import in the main
+// package. The interfaces do not change within a major version.
+
+// RequestFilter processes an HTTP request to the target t before it is
+// forwarded. FilterRequest returns false if it has responded to the
+// request which is then not forwarded. It may modify the request.
+type RequestFilter interface {
+	FilterRequest(w http.ResponseWriter, r *http.Request, t *route.Target) bool
+}
+
+// ResponseFilter processes the response of the target t before it is
+// sent to the client. FilterResponse may modify the header and replace
+// the body of the response. resp.Request is the upstream request. If
+// it returns an error the client receives a '500 Internal Server Error'
+// response.
+type ResponseFilter interface {
+	FilterResponse(resp *http.Response, t *route.Target) error
+}
+
+// TargetSelector selects the target of an HTTP request. SelectTarget
+// receives the target which the routing table has selected or nil if
+// there is no route and returns the target which receives the request
+// or nil if there is none.
+type TargetSelector interface {
+	SelectTarget(r *http.Request, t *route.Target) *route.Target
+}
+
+// RegisterRequestFilter registers a request filter as middleware under
+// a name so that it can be used in the middleware chain of the proxy
+// and of the routes. It panics if the name is already registered as
+// middleware.
+func RegisterRequestFilter(name string, f RequestFilter) {
+	if f == nil {
+		panic("proxy: invalid request filter")
+	}
+	RegisterMiddleware(name, func(_ *HTTPProxy, t *route.Target, w http.ResponseWriter, r *http.Request) bool {
+		return f.FilterRequest(w, r, t)
+	})
+}
+
+// RegisterResponseFilter registers a response filter under a name so
+// that it can be used in the middleware chain of the proxy and of the
+// routes. A request and a response filter can share the same name. The
+// response filters of a chain run in the order of the chain. It panics
+// if the name is empty or already registered as response filter.
+func RegisterResponseFilter(name string, f ResponseFilter) {
+	middlewares.Lock()
+	defer middlewares.Unlock()
+	if name == "" || f == nil {
+		panic("proxy: invalid response filter")
+	}
+	if _, ok := middlewares.resp[name]; ok {
+		panic("proxy: response filter " + name + " already registered")
+	}
+	middlewares.resp[name] = f
+}
+
+type namedSelector struct {
+	name string
+	TargetSelector
+}
+
+// RegisterTargetSelector registers a target selector under a name.
+// All registered selectors run in the order of their registration
+// after the lookup in the routing table. It panics if the name is
+// empty or already registered.
+func RegisterTargetSelector(name string, s TargetSelector) {
+	middlewares.Lock()
+	defer middlewares.Unlock()
+	if name == "" || s == nil {
+		panic("proxy: invalid target selector")
+	}
+	for _, sel := range middlewares.selectors {
+		if sel.name == name {
+			panic("proxy: target selector " + name + " already registered")
+		}
+	}
+	middlewares.selectors = append(middlewares.selectors, namedSelector{name, s})
+}
+
+// selectTarget returns the target for the request
+// after running the registered target selectors.
+func selectTarget(r *http.Request, t *route.Target) *route.Target {
+	middlewares.RLock()
+	selectors := middlewares.selectors
+	middlewares.RUnlock()
+	for _, s := range selectors {
+		t = s.SelectTarget(r, t)
+	}
+	return t
+}
+
+// responseFilters returns the response filters of the chain.
+func responseFilters(chain []string) []ResponseFilter {
+	middlewares.RLock()
+	defer middlewares.RUnlock()
+	var filters []ResponseFilter
+	for _, name := range chain {
+		if f := middlewares.resp[name]; f != nil {
+			filters = append(filters, f)
+		}
+	}
+	return filters
+}
+
+// filterTransport runs the response filters
+// on the responses of the upstream requests.
+type filterTransport struct {
+	http.RoundTripper
+	filters []ResponseFilter
+
+	// target returns the target of the last upstream request.
+	target func() *route.Target
+}
+
+func (ft *filterTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := ft.RoundTripper.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	t := ft.target()
+	for _, f := range ft.filters {
+		if err := f.FilterResponse(resp, t); err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+	}
+	return resp, nil
+}
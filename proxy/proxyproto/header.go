@@ -0,0 +1,101 @@
+package proxyproto
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+)
+
+// WriteHeader writes a version 1 or 2 PROXY protocol header for a
+// connection from src to dst to w. If the addresses are not TCP
+// addresses the header announces a connection with an unknown source,
+// e.g. for connections over unix sockets.
+func WriteHeader(w io.Writer, version int, src, dst net.Addr) error {
+	var b []byte
+	switch version {
+	case 1:
+		b = headerV1(src, dst)
+	case 2:
+		b = headerV2(src, dst)
+	default:
+		return fmt.Errorf("unsupported version %d", version)
+	}
+	_, err := w.Write(b)
+	return err
+}
+
+// ParseVersion parses the PROXY protocol version of a config value.
+// 'true' is an alias for 'v1'.
+func ParseVersion(s string) (int, error) {
+	switch s {
+	case "true", "v1", "1":
+		return 1, nil
+	case "v2", "2":
+		return 2, nil
+	default:
+		return 0, fmt.Errorf("invalid PROXY protocol version %q", s)
+	}
+}
+
+// addrs returns the IP addresses and ports of src and dst. Both
+// addresses are in the same 4 or 16 byte representation. ok is false
+// if the addresses are not TCP addresses.
+func addrs(src, dst net.Addr) (srcIP, dstIP net.IP, srcPort, dstPort int, ok bool) {
+	s, ok1 := src.(*net.TCPAddr)
+	d, ok2 := dst.(*net.TCPAddr)
+	if !ok1 || !ok2 || s.IP == nil || d.IP == nil {
+		return nil, nil, 0, 0, false
+	}
+	srcIP, dstIP = s.IP.To4(), d.IP.To4()
+	if srcIP == nil || dstIP == nil {
+		srcIP, dstIP = s.IP.To16(), d.IP.To16()
+	}
+	return srcIP, dstIP, s.Port, d.Port, true
+}
+
+func headerV1(src, dst net.Addr) []byte {
+	srcIP, dstIP, srcPort, dstPort, ok := addrs(src, dst)
+	if !ok {
+		return []byte("PROXY UNKNOWN\r\n")
+	}
+	proto, s, d := "TCP4", srcIP.String(), dstIP.String()
+	if len(srcIP) == net.IPv6len {
+		proto, s, d = "TCP6", ipv6String(srcIP), ipv6String(dstIP)
+	}
+	return []byte("PROXY " + proto + " " + s + " " + d + " " + strconv.Itoa(srcPort) + " " + strconv.Itoa(dstPort) + "\r\n")
+}
+
+// ipv6String returns ip in IPv6 notation. net.IP.String returns
+// IPv4-mapped addresses in IPv4 notation which a TCP6 header must
+// not contain.
+func ipv6String(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return "::ffff:" + ip4.String()
+	}
+	return ip.String()
+}
+
+func headerV2(src, dst net.Addr) []byte {
+	b := append([]byte{}, v2Signature...)
+	srcIP, dstIP, srcPort, dstPort, ok := addrs(src, dst)
+	if !ok {
+		// LOCAL command with AF_UNSPEC
+		return append(b, 0x20, 0x00, 0, 0)
+	}
+
+	fam := byte(0x11) // AF_INET, STREAM
+	if len(srcIP) == net.IPv6len {
+		fam = 0x21 // AF_INET6, STREAM
+	}
+	b = append(b, 0x21, fam)
+	b = append(b, 0, 0)
+	binary.BigEndian.PutUint16(b[len(b)-2:], uint16(2*len(srcIP)+4))
+	b = append(b, srcIP...)
+	b = append(b, dstIP...)
+	b = append(b, 0, 0, 0, 0)
+	binary.BigEndian.PutUint16(b[len(b)-4:], uint16(srcPort))
+	binary.BigEndian.PutUint16(b[len(b)-2:], uint16(dstPort))
+	return b
+}
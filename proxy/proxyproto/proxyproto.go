@@ -0,0 +1,264 @@
+// Package proxyproto implements the PROXY protocol version 1 and 2
+// which load balancers like HAProxy and Amazon ELB use to transmit the
+// address of the client. fabio reads the header from connections of
+// load balancers in front of it and writes it to upstream connections.
+//
+// See http://www.haproxy.org/download/1.8/doc/proxy-protocol.txt
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// v1Prefix starts a version 1 header.
+	v1Prefix = []byte("PROXY ")
+
+	// v2Signature starts a version 2 header.
+	v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+// v1MaxLen is the maximum length of a version 1 header including CRLF.
+const v1MaxLen = 107
+
+// Listener wraps a listener whose connections may start with a PROXY
+// protocol header. Connections without a header are passed through.
+type Listener struct {
+	Listener net.Listener
+
+	// ProxyHeaderTimeout is the maximum time to wait for the header.
+	// Connections which do not send a header within that time are
+	// treated as connections without a header. Zero means no timeout.
+	ProxyHeaderTimeout time.Duration
+
+	// Trusted contains the networks of the load balancers which are
+	// allowed to send a header. The headers of connections from other
+	// addresses are not parsed. If Trusted is empty no headers are
+	// parsed.
+	Trusted []*net.IPNet
+}
+
+// Accept waits for and returns the next connection to the listener.
+func (l *Listener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if !l.trusted(c.RemoteAddr()) {
+		return c, nil
+	}
+	return NewConn(c, l.ProxyHeaderTimeout), nil
+}
+
+func (l *Listener) trusted(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, n := range l.Trusted {
+		if n.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// Close closes the underlying listener.
+func (l *Listener) Close() error {
+	return l.Listener.Close()
+}
+
+// Addr returns the address of the underlying listener.
+func (l *Listener) Addr() net.Addr {
+	return l.Listener.Addr()
+}
+
+// Conn wraps a connection which may start with a PROXY protocol
+// header. The header is read with the first call to Read or
+// RemoteAddr which may therefore block until the header has been
+// received or the header timeout has expired.
+type Conn struct {
+	net.Conn
+	r       *bufio.Reader
+	timeout time.Duration
+
+	once sync.Once
+	src  net.Addr
+	err  error
+}
+
+// NewConn returns a connection which reads the
+// PROXY protocol header from c with the given timeout.
+func NewConn(c net.Conn, timeout time.Duration) *Conn {
+	return &Conn{Conn: c, r: bufio.NewReader(c), timeout: timeout}
+}
+
+// Read reads data from the connection after the header.
+func (c *Conn) Read(b []byte) (int, error) {
+	c.once.Do(c.readHeader)
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.r.Read(b)
+}
+
+// RemoteAddr returns the address of the client from the header.
+// If there is no header or the header does not contain an address
+// it returns the address of the peer.
+func (c *Conn) RemoteAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.src != nil {
+		return c.src
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *Conn) readHeader() {
+	if c.timeout > 0 {
+		c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+		defer c.Conn.SetReadDeadline(time.Time{})
+	}
+	c.src, c.err = ReadHeader(c.r)
+	if c.err != nil {
+		if c.err != io.EOF {
+			log.Printf("[WARN] Invalid PROXY protocol header from %s. %s", c.Conn.RemoteAddr(), c.err)
+		}
+		c.Conn.Close()
+	}
+}
+
+// ReadHeader reads a version 1 or 2 PROXY protocol header from r and
+// returns the source address. It returns nil and no error if r does
+// not start with a header or the header has no address, e.g. for
+// health checks of the load balancer.
+func ReadHeader(r *bufio.Reader) (net.Addr, error) {
+	switch {
+	case hasPrefix(r, v1Prefix):
+		return readV1(r)
+	case hasPrefix(r, v2Signature):
+		return readV2(r)
+	default:
+		return nil, nil
+	}
+}
+
+// hasPrefix returns true if r starts with prefix. The prefix is
+// checked byte by byte so that protocols where the server sends
+// first do not block until the read timeout.
+func hasPrefix(r *bufio.Reader, prefix []byte) bool {
+	for i := 1; i <= len(prefix); i++ {
+		b, err := r.Peek(i)
+		if err != nil || !bytes.Equal(b, prefix[:i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// readV1 parses a header of the form
+//
+//	PROXY TCP4 <src ip> <dst ip> <src port> <dst port>\r\n
+func readV1(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for len(line) < v1MaxLen {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, fmt.Errorf("header too long")
+	}
+
+	parts := strings.Split(string(line[:len(line)-2]), " ")
+	if len(parts) >= 2 && parts[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(parts) != 6 {
+		return nil, fmt.Errorf("invalid header %q", line)
+	}
+	switch parts[1] {
+	case "TCP4", "TCP6":
+		// ok
+	default:
+		return nil, fmt.Errorf("unknown protocol %q", parts[1])
+	}
+	ip := net.ParseIP(parts[2])
+	if ip == nil {
+		return nil, fmt.Errorf("invalid source address %q", parts[2])
+	}
+	port, err := strconv.ParseUint(parts[4], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid source port %q", parts[4])
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// readV2 parses a binary header.
+func readV2(r *bufio.Reader) (net.Addr, error) {
+	if _, err := r.Discard(len(v2Signature)); err != nil {
+		return nil, err
+	}
+	hdr := make([]byte, 4)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, err
+	}
+	if v := hdr[0] >> 4; v != 2 {
+		return nil, fmt.Errorf("unsupported version %d", v)
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(hdr[2:]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+
+	switch cmd := hdr[0] & 0xf; cmd {
+	case 0x0: // LOCAL
+		return nil, nil
+	case 0x1: // PROXY
+		// ok
+	default:
+		return nil, fmt.Errorf("unknown command %d", cmd)
+	}
+
+	var ip net.IP
+	var port uint16
+	switch fam := hdr[1] >> 4; fam {
+	case 0x1: // AF_INET
+		if len(payload) < 12 {
+			return nil, fmt.Errorf("short IPv4 address block")
+		}
+		ip = net.IP(payload[0:4])
+		port = binary.BigEndian.Uint16(payload[8:10])
+	case 0x2: // AF_INET6
+		if len(payload) < 36 {
+			return nil, fmt.Errorf("short IPv6 address block")
+		}
+		ip = net.IP(payload[0:16])
+		port = binary.BigEndian.Uint16(payload[32:34])
+	default: // AF_UNSPEC, AF_UNIX
+		return nil, nil
+	}
+
+	switch proto := hdr[1] & 0xf; proto {
+	case 0x1: // STREAM
+		return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+	case 0x2: // DGRAM
+		return &net.UDPAddr{IP: ip, Port: int(port)}, nil
+	default:
+		return nil, nil
+	}
+}
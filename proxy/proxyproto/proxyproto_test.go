@@ -0,0 +1,196 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// v2 returns a version 2 header with the given command,
+// address family and address block.
+func v2(cmd, fam byte, addr []byte) []byte {
+	b := append([]byte{}, v2Signature...)
+	b = append(b, 0x20|cmd, fam, 0, 0)
+	binary.BigEndian.PutUint16(b[len(b)-2:], uint16(len(addr)))
+	return append(b, addr...)
+}
+
+func TestReadHeader(t *testing.T) {
+	ipv4 := []byte{1, 2, 3, 4, 5, 6, 7, 8, 0x04, 0xd2, 0x00, 0x50}
+	ipv6 := append(append(net.ParseIP("2001:db8::1").To16(), net.ParseIP("2001:db8::2").To16()...), 0x04, 0xd2, 0x00, 0x50)
+	tlv := []byte{0x04, 0x00, 0x01, 0x00}
+
+	tests := []struct {
+		desc string
+		in   []byte
+		addr net.Addr
+		rest string
+		err  string
+	}{
+		{"no header", []byte("GET / HTTP/1.1\r\n"), nil, "GET / HTTP/1.1\r\n", ""},
+		{"partial prefix", []byte("PROX"), nil, "PROX", ""},
+		{"v1 tcp4", []byte("PROXY TCP4 1.2.3.4 5.6.7.8 1234 80\r\nfoo"), &net.TCPAddr{IP: net.ParseIP("1.2.3.4"), Port: 1234}, "foo", ""},
+		{"v1 tcp6", []byte("PROXY TCP6 2001:db8::1 2001:db8::2 1234 80\r\nfoo"), &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 1234}, "foo", ""},
+		{"v1 unknown", []byte("PROXY UNKNOWN\r\nfoo"), nil, "foo", ""},
+		{"v1 invalid", []byte("PROXY TCP4 1.2.3.4\r\n"), nil, "", `invalid header "PROXY TCP4 1.2.3.4\r\n"`},
+		{"v1 invalid ip", []byte("PROXY TCP4 x 5.6.7.8 1234 80\r\n"), nil, "", `invalid source address "x"`},
+		{"v1 invalid port", []byte("PROXY TCP4 1.2.3.4 5.6.7.8 x 80\r\n"), nil, "", `invalid source port "x"`},
+		{"v1 too long", []byte("PROXY " + strings.Repeat("x", 200) + "\r\n"), nil, "", "header too long"},
+		{"v2 tcp4", append(v2(1, 0x11, ipv4), "foo"...), &net.TCPAddr{IP: net.IP{1, 2, 3, 4}, Port: 1234}, "foo", ""},
+		{"v2 udp4", append(v2(1, 0x12, ipv4), "foo"...), &net.UDPAddr{IP: net.IP{1, 2, 3, 4}, Port: 1234}, "foo", ""},
+		{"v2 tcp6", append(v2(1, 0x21, ipv6), "foo"...), &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 1234}, "foo", ""},
+		{"v2 tcp4 with tlv", append(v2(1, 0x11, append(ipv4, tlv...)), "foo"...), &net.TCPAddr{IP: net.IP{1, 2, 3, 4}, Port: 1234}, "foo", ""},
+		{"v2 local", append(v2(0, 0x00, nil), "foo"...), nil, "foo", ""},
+		{"v2 unspec", append(v2(1, 0x00, nil), "foo"...), nil, "foo", ""},
+		{"v2 short ipv4", v2(1, 0x11, ipv4[:8]), nil, "", "short IPv4 address block"},
+		{"v2 unknown command", v2(2, 0x11, ipv4), nil, "", "unknown command 2"},
+		{"v2 bad version", append(append([]byte{}, v2Signature...), 0x11, 0x11, 0, 0), nil, "", "unsupported version 1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			r := bufio.NewReader(bytes.NewReader(tt.in))
+			addr, err := ReadHeader(r)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("got error %v want %s", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := addr, tt.addr; !reflect.DeepEqual(got, want) {
+				t.Fatalf("got addr %v want %v", got, want)
+			}
+			rest, _ := ioutil.ReadAll(r)
+			if got, want := string(rest), tt.rest; got != want {
+				t.Fatalf("got rest %q want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestListener(t *testing.T) {
+	_, localhost, _ := net.ParseCIDR("127.0.0.0/8")
+	_, other, _ := net.ParseCIDR("10.0.0.0/8")
+
+	tests := []struct {
+		desc    string
+		trusted []*net.IPNet
+		addr    string
+		data    string
+	}{
+		{"nothing trusted", nil, "127.0.0.1", "PROXY TCP4 1.2.3.4 5.6.7.8 1234 80\r\nfoo"},
+		{"trusted source", []*net.IPNet{other, localhost}, "1.2.3.4:1234", "foo"},
+		{"untrusted source", []*net.IPNet{other}, "127.0.0.1", "PROXY TCP4 1.2.3.4 5.6.7.8 1234 80\r\nfoo"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			l := &Listener{Listener: ln, ProxyHeaderTimeout: time.Second, Trusted: tt.trusted}
+			defer l.Close()
+
+			c, err := net.Dial("tcp", l.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			c.Write([]byte("PROXY TCP4 1.2.3.4 5.6.7.8 1234 80\r\nfoo"))
+			c.Close()
+
+			in, err := l.Accept()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer in.Close()
+
+			addr := in.RemoteAddr().String()
+			if !strings.HasPrefix(addr, tt.addr) {
+				t.Fatalf("got addr %s want %s", addr, tt.addr)
+			}
+			data, err := ioutil.ReadAll(in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := string(data), tt.data; got != want {
+				t.Fatalf("got data %q want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestConnHeaderTimeout(t *testing.T) {
+	in, out := net.Pipe()
+	defer in.Close()
+	defer out.Close()
+
+	// the server speaks first and the client sends no header
+	c := NewConn(in, 50*time.Millisecond)
+	if got, want := c.RemoteAddr(), in.RemoteAddr(); got != want {
+		t.Fatalf("got addr %v want %v", got, want)
+	}
+}
+
+func TestWriteHeader(t *testing.T) {
+	tcp4 := func(ip string, port int) net.Addr { return &net.TCPAddr{IP: net.ParseIP(ip), Port: port} }
+	unix := &net.UnixAddr{Name: "/tmp/fabio.sock", Net: "unix"}
+
+	tests := []struct {
+		desc     string
+		src, dst net.Addr
+		v1       string
+		addr     net.Addr
+	}{
+		{"ipv4", tcp4("1.2.3.4", 1234), tcp4("5.6.7.8", 80), "PROXY TCP4 1.2.3.4 5.6.7.8 1234 80\r\n", &net.TCPAddr{IP: net.IP{1, 2, 3, 4}, Port: 1234}},
+		{"ipv6", tcp4("2001:db8::1", 1234), tcp4("2001:db8::2", 80), "PROXY TCP6 2001:db8::1 2001:db8::2 1234 80\r\n", &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 1234}},
+		{"mixed", tcp4("1.2.3.4", 1234), tcp4("2001:db8::2", 80), "PROXY TCP6 ::ffff:1.2.3.4 2001:db8::2 1234 80\r\n", &net.TCPAddr{IP: net.ParseIP("1.2.3.4"), Port: 1234}},
+		{"unix", tcp4("1.2.3.4", 1234), unix, "PROXY UNKNOWN\r\n", nil},
+		{"no source", nil, tcp4("5.6.7.8", 80), "PROXY UNKNOWN\r\n", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			var b bytes.Buffer
+			if err := WriteHeader(&b, 1, tt.src, tt.dst); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := b.String(), tt.v1; got != want {
+				t.Fatalf("got v1 header %q want %q", got, want)
+			}
+
+			// the v2 header must contain the same source address
+			for _, v := range []int{1, 2} {
+				b.Reset()
+				if err := WriteHeader(&b, v, tt.src, tt.dst); err != nil {
+					t.Fatal(err)
+				}
+				b.WriteString("foo")
+				r := bufio.NewReader(&b)
+				addr, err := ReadHeader(r)
+				if err != nil {
+					t.Fatalf("v%d: %s", v, err)
+				}
+				if got, want := addr, tt.addr; (got == nil) != (want == nil) || (got != nil && got.String() != want.String()) {
+					t.Fatalf("v%d: got addr %v want %v", v, got, want)
+				}
+				if rest, _ := ioutil.ReadAll(r); string(rest) != "foo" {
+					t.Fatalf("v%d: got rest %q want %q", v, rest, "foo")
+				}
+			}
+		})
+	}
+
+	if err := WriteHeader(ioutil.Discard, 3, nil, nil); err == nil {
+		t.Fatal("got no error for version 3")
+	}
+}
@@ -7,15 +7,19 @@ import (
 	"log"
 	"net"
 	"net/http"
+	"sort"
 	"sync"
 	"time"
 
+	"golang.org/x/net/http2"
 	"google.golang.org/grpc"
 
 	"github.com/fabiolb/fabio/config"
+	"github.com/fabiolb/fabio/proxy/proxyproto"
 	"github.com/fabiolb/fabio/proxy/tcp"
+	"github.com/fabiolb/fabio/proxy/udp"
+	"github.com/fabiolb/fabio/route"
 
-	"github.com/armon/go-proxyproto"
 	"github.com/inetaf/tcpproxy"
 )
 
@@ -25,11 +29,19 @@ type Server interface {
 	Shutdown(ctx context.Context) error
 }
 
+// stopper stops a running proxy server.
+type stopper interface {
+	Close() error
+	Shutdown(ctx context.Context) error
+}
+
 var (
 	// mu guards servers which contains the list
-	// of running proxy servers.
+	// of running proxy servers and admin which contains
+	// the addresses of the servers which are not drained.
 	mu      sync.Mutex
-	servers = make(map[string]Server)
+	servers = make(map[string]stopper)
+	admin   = make(map[string]bool)
 )
 
 func CloseProxy(address string) error {
@@ -46,31 +58,73 @@ func CloseProxy(address string) error {
 	return nil
 }
 
+// Addrs returns the sorted addresses of the running
+// proxy and admin servers.
+func Addrs() []string {
+	mu.Lock()
+	defer mu.Unlock()
+	addrs := make([]string, 0, len(servers))
+	for addr := range servers {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	return addrs
+}
+
 func Close() {
 	mu.Lock()
 	for _, srv := range servers {
 		srv.Close()
 	}
-	servers = make(map[string]Server)
+	servers = make(map[string]stopper)
 	mu.Unlock()
 }
 
 func Shutdown(timeout time.Duration) {
+	shutdown(timeout, true)
+}
+
+// shutdown gracefully stops the running servers. The admin
+// servers are only stopped if withAdmin is true. They are stopped
+// after the proxy servers so that the health check and the drain
+// status are available while the in-flight requests are served.
+func shutdown(timeout time.Duration, withAdmin bool) {
 	mu.Lock()
-	srvs := make(map[string]Server, len(servers))
+	var srvs, admins []stopper
 	for k, v := range servers {
-		srvs[k] = v
+		switch {
+		case !admin[k]:
+			srvs = append(srvs, v)
+		case withAdmin:
+			admins = append(admins, v)
+		default:
+			continue
+		}
+		delete(servers, k)
 	}
-	servers = make(map[string]Server)
 	mu.Unlock()
 
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	// hijacked websocket connections are not closed by the servers
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		closeWebSockets(ctx)
+	}()
+	shutdownAll(ctx, srvs)
+	wg.Wait()
+	shutdownAll(ctx, admins)
+}
+
+// shutdownAll gracefully stops the servers in parallel.
+func shutdownAll(ctx context.Context, srvs []stopper) {
 	var wg sync.WaitGroup
 	for _, srv := range srvs {
 		wg.Add(1)
-		go func(srv Server) {
+		go func(srv stopper) {
 			defer wg.Done()
-			ctx, cancel := context.WithTimeout(context.Background(), timeout)
-			defer cancel()
 			srv.Shutdown(ctx)
 		}(srv)
 	}
@@ -78,23 +132,103 @@ func Shutdown(timeout time.Duration) {
 }
 
 func ListenAndServeHTTP(l config.Listen, h http.Handler, cfg *tls.Config) error {
-	ln, err := ListenTCP(l, cfg)
+	return listenAndServeHTTP(l, h, cfg, false)
+}
+
+// ListenAndServeAdmin is like ListenAndServeHTTP but the server
+// keeps running while fabio is drained.
+func ListenAndServeAdmin(l config.Listen, h http.Handler, cfg *tls.Config) error {
+	return listenAndServeHTTP(l, h, cfg, true)
+}
+
+func listenAndServeHTTP(l config.Listen, h http.Handler, cfg *tls.Config, isAdmin bool) error {
+	cfg = http2TLSConfig(l.HTTP2, cfg)
+	ln, err := Listen(l, cfg)
 	if err != nil {
 		return err
 	}
 
 	srv := &http.Server{
-		Addr:         l.Addr,
-		Handler:      h,
-		ReadTimeout:  l.ReadTimeout,
-		WriteTimeout: l.WriteTimeout,
-		IdleTimeout:  l.IdleTimeout,
-		TLSConfig:    cfg,
+		Addr:           l.Addr,
+		Handler:        limitHeaders(h, l.MaxHeaders),
+		ReadTimeout:    l.ReadTimeout,
+		WriteTimeout:   l.WriteTimeout,
+		IdleTimeout:    l.IdleTimeout,
+		MaxHeaderBytes: l.MaxHeaderBytes,
+		TLSConfig:      cfg,
+	}
+	if err := configureHTTP2(srv, l.HTTP2); err != nil {
+		ln.Close()
+		return err
+	}
+	if isAdmin {
+		// end long-running requests like the streams of the route
+		// events when the server shuts down since they would
+		// otherwise delay the shutdown until the timeout.
+		ctx, cancel := context.WithCancel(context.Background())
+		srv.BaseContext = func(net.Listener) context.Context { return ctx }
+		srv.RegisterOnShutdown(cancel)
+
+		mu.Lock()
+		admin[ln.Addr().String()] = true
+		mu.Unlock()
 	}
 	return serve(ln, srv)
 }
 
+// http2TLSConfig removes HTTP/2 from the protocols offered
+// via ALPN if HTTP/2 is disabled for the listener.
+func http2TLSConfig(h2 config.HTTP2, cfg *tls.Config) *tls.Config {
+	if cfg == nil || !h2.Disabled {
+		return cfg
+	}
+	cfg = cfg.Clone()
+	var protos []string
+	for _, p := range cfg.NextProtos {
+		if p != "h2" {
+			protos = append(protos, p)
+		}
+	}
+	cfg.NextProtos = protos
+	return cfg
+}
+
+// configureHTTP2 applies the HTTP/2 settings of the listener to the
+// server. Settings which are not set use the defaults of the http2
+// package.
+func configureHTTP2(srv *http.Server, h2 config.HTTP2) error {
+	if h2.Disabled {
+		// a non-nil map disables the automatic HTTP/2 support
+		srv.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+		return nil
+	}
+	if h2 == (config.HTTP2{}) {
+		return nil
+	}
+	return http2.ConfigureServer(srv, &http2.Server{
+		MaxConcurrentStreams:         h2.MaxConcurrentStreams,
+		MaxReadFrameSize:             h2.MaxReadFrameSize,
+		MaxUploadBufferPerConnection: h2.MaxConnWindow,
+		MaxUploadBufferPerStream:     h2.MaxStreamWindow,
+		IdleTimeout:                  h2.IdleTimeout,
+	})
+}
+
 func ListenAndServeHTTPSTCPSNI(l config.Listen, h http.Handler, p tcp.Handler, cfg *tls.Config, m tcpproxy.Matcher) error {
+	cfg = http2TLSConfig(l.HTTP2, cfg)
+	srv := &http.Server{
+		Addr:           l.Addr,
+		Handler:        limitHeaders(h, l.MaxHeaders),
+		ReadTimeout:    l.ReadTimeout,
+		WriteTimeout:   l.WriteTimeout,
+		IdleTimeout:    l.IdleTimeout,
+		MaxHeaderBytes: l.MaxHeaderBytes,
+		TLSConfig:      cfg,
+	}
+	if err := configureHTTP2(srv, l.HTTP2); err != nil {
+		return err
+	}
+
 	// we only want proxy proto enabled on tcp proxies
 	pxyProto := l.ProxyProto
 	l.ProxyProto = false
@@ -126,6 +260,7 @@ func ListenAndServeHTTPSTCPSNI(l config.Listen, h http.Handler, p tcp.Handler, c
 		tln = &proxyproto.Listener{
 			Listener:           tln,
 			ProxyHeaderTimeout: l.ProxyHeaderTimeout,
+			Trusted:            l.ProxyTrusted,
 		}
 	}
 	tps.ServeLater(tln, &tcp.Server{
@@ -136,14 +271,7 @@ func ListenAndServeHTTPSTCPSNI(l config.Listen, h http.Handler, p tcp.Handler, c
 	})
 
 	// wrap TargetListener in a tls terminating version for HTTPS
-	tps.ServeLater(tls.NewListener(httpsListener, cfg), &http.Server{
-		Addr:         l.Addr,
-		Handler:      h,
-		ReadTimeout:  l.ReadTimeout,
-		WriteTimeout: l.WriteTimeout,
-		IdleTimeout:  l.IdleTimeout,
-		TLSConfig:    cfg,
-	})
+	tps.ServeLater(tls.NewListener(httpsListener, cfg), srv)
 
 	// tcpproxy creates its own listener from the configuration above so we can
 	// safely pass nil here, nonetheless we are passing `httpsListener` to
@@ -164,6 +292,44 @@ func ListenAndServeGRPC(l config.Listen, opts []grpc.ServerOption, cfg *tls.Conf
 	return serve(ln, srv)
 }
 
+// ListenAndServeGRPCWeb is like ListenAndServeGRPC but also translates
+// gRPC-Web requests from browsers into gRPC requests. lookup returns
+// the target of a request for the CORS preflight requests.
+func ListenAndServeGRPCWeb(l config.Listen, opts []grpc.ServerOption, cfg *tls.Config, lookup func(*http.Request) *route.Target) error {
+	ln, err := ListenTCP(l, cfg)
+	if err != nil {
+		return err
+	}
+
+	gs := grpc.NewServer(opts...)
+	srv := &http.Server{
+		Addr:           l.Addr,
+		ReadTimeout:    l.ReadTimeout,
+		WriteTimeout:   l.WriteTimeout,
+		IdleTimeout:    l.IdleTimeout,
+		MaxHeaderBytes: l.MaxHeaderBytes,
+		TLSConfig:      cfg,
+	}
+	h2s := &http2.Server{
+		MaxConcurrentStreams:         l.HTTP2.MaxConcurrentStreams,
+		MaxReadFrameSize:             l.HTTP2.MaxReadFrameSize,
+		MaxUploadBufferPerConnection: l.HTTP2.MaxConnWindow,
+		MaxUploadBufferPerStream:     l.HTTP2.MaxStreamWindow,
+		IdleTimeout:                  l.HTTP2.IdleTimeout,
+	}
+	if err := http2.ConfigureServer(srv, h2s); err != nil {
+		ln.Close()
+		return err
+	}
+	h := newGRPCWebHandler(gs, lookup)
+	if cfg == nil {
+		h = h2cHandler(h, h2s, srv)
+	}
+	srv.Handler = h
+
+	return serve(ln, &gRPCWebServer{http: srv, grpc: gs})
+}
+
 func ListenAndServeTCP(l config.Listen, h tcp.Handler, cfg *tls.Config) error {
 	ln, err := ListenTCP(l, cfg)
 	if err != nil {
@@ -178,6 +344,21 @@ func ListenAndServeTCP(l config.Listen, h tcp.Handler, cfg *tls.Config) error {
 	return serve(ln, srv)
 }
 
+func ListenAndServeUDP(l config.Listen, p *udp.Proxy) error {
+	conn, err := ListenUDP(l)
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	servers[conn.LocalAddr().String()] = p
+	mu.Unlock()
+	err = p.Serve(conn)
+	if err == udp.ErrClosed {
+		err = nil
+	}
+	return err
+}
+
 func serve(ln net.Listener, srv Server) error {
 	mu.Lock()
 	servers[ln.Addr().String()] = srv
@@ -0,0 +1,63 @@
+// Package sockopt sets socket options on listeners and upstream connections.
+package sockopt
+
+import (
+	"fmt"
+	"syscall"
+
+	"github.com/fabiolb/fabio/config"
+)
+
+// Control returns a function for net.ListenConfig and net.Dialer
+// which sets the socket options before the socket is bound or
+// connected. It returns nil if no options are set.
+func Control(o config.SocketOpts) func(network, address string, c syscall.RawConn) error {
+	if o == (config.SocketOpts{}) {
+		return nil
+	}
+	return func(network, address string, c syscall.RawConn) error {
+		ipv6 := network == "tcp6" || network == "udp6"
+		var err error
+		cerr := c.Control(func(fd uintptr) {
+			s := int(fd)
+			if o.BindToDevice != "" {
+				if err = syscall.BindToDevice(s, o.BindToDevice); err != nil {
+					err = fmt.Errorf("SO_BINDTODEVICE %s: %s", o.BindToDevice, err)
+					return
+				}
+			}
+			if o.FreeBind {
+				if err = syscall.SetsockoptInt(s, syscall.SOL_IP, syscall.IP_FREEBIND, 1); err != nil {
+					err = fmt.Errorf("IP_FREEBIND: %s", err)
+					return
+				}
+			}
+			if o.TTL > 0 {
+				if ipv6 {
+					err = syscall.SetsockoptInt(s, syscall.IPPROTO_IPV6, syscall.IPV6_UNICAST_HOPS, o.TTL)
+				} else {
+					err = syscall.SetsockoptInt(s, syscall.IPPROTO_IP, syscall.IP_TTL, o.TTL)
+				}
+				if err != nil {
+					err = fmt.Errorf("TTL %d: %s", o.TTL, err)
+					return
+				}
+			}
+			if o.TOS > 0 {
+				if ipv6 {
+					err = syscall.SetsockoptInt(s, syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS, o.TOS)
+				} else {
+					err = syscall.SetsockoptInt(s, syscall.IPPROTO_IP, syscall.IP_TOS, o.TOS)
+				}
+				if err != nil {
+					err = fmt.Errorf("TOS %d: %s", o.TOS, err)
+					return
+				}
+			}
+		})
+		if cerr != nil {
+			return cerr
+		}
+		return err
+	}
+}
@@ -0,0 +1,21 @@
+// +build !linux
+
+package sockopt
+
+import (
+	"errors"
+	"syscall"
+
+	"github.com/fabiolb/fabio/config"
+)
+
+// Control returns a function which fails if any socket options
+// are set since they are only supported on Linux.
+func Control(o config.SocketOpts) func(network, address string, c syscall.RawConn) error {
+	if o == (config.SocketOpts{}) {
+		return nil
+	}
+	return func(network, address string, c syscall.RawConn) error {
+		return errors.New("socket options are only supported on linux")
+	}
+}
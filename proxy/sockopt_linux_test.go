@@ -0,0 +1,99 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/fabiolb/fabio/config"
+	"github.com/fabiolb/fabio/route"
+)
+
+func TestListenTCPSocketOpts(t *testing.T) {
+	l := config.Listen{
+		Addr:   "127.0.0.1:0",
+		Socket: config.SocketOpts{FreeBind: true, TTL: 32, TOS: 46 << 2},
+	}
+	ln, err := ListenTCP(l, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	tl := ln.(*tcpListener).l.(tcpKeepAliveListener).TCPListener
+	if got, want := getsockopt(t, tl, syscall.SOL_IP, syscall.IP_FREEBIND), 1; got != want {
+		t.Errorf("got IP_FREEBIND %d want %d", got, want)
+	}
+	if got, want := getsockopt(t, tl, syscall.IPPROTO_IP, syscall.IP_TTL), 32; got != want {
+		t.Errorf("got IP_TTL %d want %d", got, want)
+	}
+	if got, want := getsockopt(t, tl, syscall.IPPROTO_IP, syscall.IP_TOS), 184; got != want {
+		t.Errorf("got IP_TOS %d want %d", got, want)
+	}
+}
+
+func TestListenTCPBindToDeviceError(t *testing.T) {
+	l := config.Listen{
+		Addr:   "127.0.0.1:0",
+		Socket: config.SocketOpts{BindToDevice: "does-not-exist0"},
+	}
+	ln, err := ListenTCP(l, nil)
+	if err == nil {
+		ln.Close()
+		t.Fatal("got nil want error")
+	}
+	if !strings.Contains(err.Error(), "SO_BINDTODEVICE does-not-exist0") {
+		t.Fatalf("got %q want SO_BINDTODEVICE error", err)
+	}
+}
+
+func TestHTTPProxyTransportDSCP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	p := &HTTPProxy{Transport: &http.Transport{}}
+	plain := &route.Target{}
+	marked := &route.Target{DSCP: 46}
+
+	if got, want := p.transport(plain), p.Transport; got != want {
+		t.Fatalf("got %v want the default transport", got)
+	}
+	tr := p.transport(marked)
+	if tr == p.Transport {
+		t.Fatal("got the default transport want a separate pool")
+	}
+	if got := p.transport(&route.Target{DSCP: 46}); got != tr {
+		t.Fatal("got a new transport want the same pool for the same mark")
+	}
+
+	conn, err := tr.(*http.Transport).DialContext(context.Background(), "tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if got, want := getsockopt(t, conn.(*net.TCPConn), syscall.IPPROTO_IP, syscall.IP_TOS), 184; got != want {
+		t.Errorf("got IP_TOS %d want %d", got, want)
+	}
+}
+
+func getsockopt(t *testing.T, c syscall.Conn, level, opt int) (n int) {
+	rc, err := c.SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cerr := rc.Control(func(fd uintptr) {
+		n, err = syscall.GetsockoptInt(int(fd), level, opt)
+	}); cerr != nil {
+		t.Fatal(cerr)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
@@ -0,0 +1,45 @@
+package tcp
+
+import (
+	"log"
+	"net"
+	"time"
+
+	"github.com/fabiolb/fabio/config"
+	"github.com/fabiolb/fabio/metrics"
+	"github.com/fabiolb/fabio/proxy/sockopt"
+	"github.com/fabiolb/fabio/route"
+)
+
+// dialTarget connects to the target. If the connection fails the
+// backup targets of the route are tried in order. It returns the
+// connection and the target it is connected to. Every failed
+// connection attempt is counted in fail and all attempts are
+// reported to the outlier detection.
+func dialTarget(proto string, t *route.Target, timeout time.Duration, fail metrics.Counter) (net.Conn, *route.Target, error) {
+	targets := append([]*route.Target{t}, t.Backups...)
+
+	var err error
+	for i, t := range targets {
+		if i > 0 {
+			log.Printf("[INFO] %s: failing over to backup upstream %s", proto, t.URL.Host)
+		}
+
+		d := &net.Dialer{
+			Timeout: timeout,
+			Control: sockopt.Control(config.SocketOpts{TOS: t.DSCP << 2}),
+		}
+		var out net.Conn
+		out, err = d.Dial("tcp", t.URL.Host)
+		t.RecordOutcome(err != nil)
+		if err == nil {
+			return out, t, nil
+		}
+
+		log.Print("[WARN] ", proto, ": cannot connect to upstream ", t.URL.Host)
+		if fail != nil {
+			fail.Inc(1)
+		}
+	}
+	return nil, nil, err
+}
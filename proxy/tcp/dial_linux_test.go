@@ -0,0 +1,41 @@
+package tcp
+
+import (
+	"net"
+	"net/url"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/fabiolb/fabio/route"
+)
+
+func TestDialTargetDSCP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	tg := &route.Target{URL: &url.URL{Host: l.Addr().String()}, DSCP: 10}
+	out, _, err := dialTarget("tcp", tg, time.Second, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+
+	rc, err := out.(*net.TCPConn).SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tos int
+	rc.Control(func(fd uintptr) {
+		tos, err = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TOS)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := tos, 40; got != want {
+		t.Fatalf("got IP_TOS %d want %d", got, want)
+	}
+}
@@ -0,0 +1,76 @@
+package tcp
+
+import (
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/fabiolb/fabio/route"
+)
+
+func TestDialTarget(t *testing.T) {
+	// an address nobody listens on
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	down := l.Addr().String()
+	l.Close()
+
+	up, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer up.Close()
+
+	target := func(addr string) *route.Target {
+		return &route.Target{URL: &url.URL{Host: addr}}
+	}
+
+	tests := []struct {
+		desc    string
+		primary string
+		backups []string
+		want    string
+		fails   int64
+	}{
+		{"primary up", up.Addr().String(), []string{down}, up.Addr().String(), 0},
+		{"primary down", down, []string{down, up.Addr().String()}, up.Addr().String(), 2},
+		{"all down", down, []string{down}, "", 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			tg := target(tt.primary)
+			for _, b := range tt.backups {
+				tg.Backups = append(tg.Backups, target(b))
+			}
+			var n int64
+			counter := countFunc(func(i int64) { n += i })
+
+			out, got, err := dialTarget("tcp", tg, time.Second, counter)
+			if tt.want == "" {
+				if err == nil {
+					out.Close()
+					t.Fatal("got nil want error")
+				}
+			} else {
+				if err != nil {
+					t.Fatal(err)
+				}
+				out.Close()
+				if got.URL.Host != tt.want {
+					t.Fatalf("got target %s want %s", got.URL.Host, tt.want)
+				}
+			}
+			if n != tt.fails {
+				t.Fatalf("got %d failed connections want %d", n, tt.fails)
+			}
+		})
+	}
+}
+
+type countFunc func(int64)
+
+func (f countFunc) Inc(n int64) { f(n) }
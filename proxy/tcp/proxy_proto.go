@@ -2,23 +2,24 @@ package tcp
 
 import (
 	"net"
+
+	"github.com/fabiolb/fabio/proxy/proxyproto"
+	"github.com/fabiolb/fabio/route"
 )
 
-// WriteProxyHeader extracts remote and local IP address and port
-// combinations from incoming connection and writes the PROXY proto
-// header to the outgoing connection
-func WriteProxyHeader(out, in net.Conn) error {
-	clientAddr, clientPort, _ := net.SplitHostPort(in.RemoteAddr().String())
-	serverAddr, serverPort, _ := net.SplitHostPort(in.LocalAddr().String())
+// WriteProxyHeader writes a PROXY protocol header with the given
+// version and the remote and local address of the incoming
+// connection to the outgoing connection.
+func WriteProxyHeader(out, in net.Conn, version int) error {
+	return proxyproto.WriteHeader(out, version, in.RemoteAddr(), in.LocalAddr())
+}
 
-	var proto string
-	if net.ParseIP(clientAddr).To4() != nil {
-		proto = "TCP4"
-	} else {
-		proto = "TCP6"
+// proxyProto returns the version of the PROXY protocol header for
+// upstream connections to t. The pxyproto option of the route
+// overrides the default of the listener.
+func proxyProto(t *route.Target, def int) int {
+	if t.ProxyProto > 0 {
+		return t.ProxyProto
 	}
-
-	header := "PROXY " + proto + " " + clientAddr + " " + serverAddr + " " + clientPort + " " + serverPort + "\r\n"
-	_, err := out.Write([]byte(header))
-	return err
+	return def
 }
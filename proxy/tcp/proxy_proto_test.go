@@ -0,0 +1,80 @@
+package tcp
+
+import (
+	"bufio"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/fabiolb/fabio/proxy/proxyproto"
+	"github.com/fabiolb/fabio/route"
+)
+
+func TestProxyProtoUpstream(t *testing.T) {
+	tests := []struct {
+		desc    string
+		handler func(lookup func(string) *route.Target, version int) Handler
+		route   int
+	}{
+		{"tcp", func(lookup func(string) *route.Target, v int) Handler { return &Proxy{Lookup: lookup, ProxyProto: v} }, 0},
+		{"tcp-dynamic", func(lookup func(string) *route.Target, v int) Handler {
+			return &DynamicProxy{Lookup: lookup, ProxyProto: v}
+		}, 0},
+		{"tcp route option", func(lookup func(string) *route.Target, v int) Handler { return &Proxy{Lookup: lookup} }, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			// upstream returns the source address from the header
+			up, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer up.Close()
+			addrc := make(chan net.Addr, 1)
+			go func() {
+				c, err := up.Accept()
+				if err != nil {
+					return
+				}
+				defer c.Close()
+				c.SetReadDeadline(time.Now().Add(time.Second))
+				addr, _ := proxyproto.ReadHeader(bufio.NewReader(c))
+				addrc <- addr
+			}()
+
+			lookup := func(string) *route.Target {
+				return &route.Target{URL: &url.URL{Host: up.Addr().String()}, ProxyProto: tt.route}
+			}
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ln.Close()
+			h := tt.handler(lookup, 2)
+			go func() {
+				c, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				h.ServeTCP(c)
+			}()
+
+			c, err := net.Dial("tcp", ln.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+
+			select {
+			case addr := <-addrc:
+				if addr == nil || addr.String() != c.LocalAddr().String() {
+					t.Fatalf("got source addr %v want %v", addr, c.LocalAddr())
+				}
+			case <-time.After(time.Second):
+				t.Fatal("timeout")
+			}
+		})
+	}
+}
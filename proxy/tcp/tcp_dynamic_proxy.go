@@ -28,6 +28,10 @@ type DynamicProxy struct {
 
 	// Noroute counts the failed Lookup() calls.
 	Noroute metrics.Counter
+
+	// ProxyProto is the version of the PROXY protocol header which is
+	// sent to targets without the pxyproto option. Zero disables it.
+	ProxyProto int
 }
 
 func (p *DynamicProxy) ServeTCP(in net.Conn) error {
@@ -56,16 +60,24 @@ func (p *DynamicProxy) ServeTCP(in net.Conn) error {
 		return nil
 	}
 
-	out, err := net.DialTimeout("tcp", addr, p.DialTimeout)
+	out, t, err := dialTarget("tcp", t, p.DialTimeout, p.ConnFail)
 	if err != nil {
-		log.Print("[WARN] tcp: cannot connect to upstream ", addr)
-		if p.ConnFail != nil {
-			p.ConnFail.Inc(1)
-		}
 		return err
 	}
 	defer out.Close()
 
+	// enable PROXY protocol support on outbound connection
+	if v := proxyProto(t, p.ProxyProto); v > 0 {
+		err := WriteProxyHeader(out, in, v)
+		if err != nil {
+			log.Print("[WARN] tcp: write proxy protocol header failed. ", err)
+			if p.ConnFail != nil {
+				p.ConnFail.Inc(1)
+			}
+			return err
+		}
+	}
+
 	errc := make(chan error, 2)
 	cp := func(dst io.Writer, src io.Reader, c metrics.Counter) {
 		errc <- copyBuffer(dst, src, c)
@@ -28,6 +28,10 @@ type Proxy struct {
 
 	// Noroute counts the failed Lookup() calls.
 	Noroute metrics.Counter
+
+	// ProxyProto is the version of the PROXY protocol header which is
+	// sent to targets without the pxyproto option. Zero disables it.
+	ProxyProto int
 }
 
 func (p *Proxy) ServeTCP(in net.Conn) error {
@@ -46,25 +50,19 @@ func (p *Proxy) ServeTCP(in net.Conn) error {
 		}
 		return nil
 	}
-	addr := t.URL.Host
-
 	if t.AccessDeniedTCP(in) {
 		return nil
 	}
 
-	out, err := net.DialTimeout("tcp", addr, p.DialTimeout)
+	out, t, err := dialTarget("tcp", t, p.DialTimeout, p.ConnFail)
 	if err != nil {
-		log.Print("[WARN] tcp: cannot connect to upstream ", addr)
-		if p.ConnFail != nil {
-			p.ConnFail.Inc(1)
-		}
 		return err
 	}
 	defer out.Close()
 
 	// enable PROXY protocol support on outbound connection
-	if t.ProxyProto {
-		err := WriteProxyHeader(out, in)
+	if v := proxyProto(t, p.ProxyProto); v > 0 {
+		err := WriteProxyHeader(out, in, v)
 		if err != nil {
 			log.Print("[WARN] tcp: write proxy protocol header failed. ", err)
 			if p.ConnFail != nil {
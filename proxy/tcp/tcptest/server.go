@@ -6,8 +6,8 @@ import (
 	"net"
 	"time"
 
-	proxyproto "github.com/armon/go-proxyproto"
 	"github.com/fabiolb/fabio/proxy/internal"
+	"github.com/fabiolb/fabio/proxy/proxyproto"
 	"github.com/fabiolb/fabio/proxy/tcp"
 )
 
@@ -100,6 +100,13 @@ func NewUnstartedServer(h tcp.Handler) *Server {
 	}
 }
 
+// loopback contains the networks of the local clients which
+// are allowed to send a PROXY protocol header.
+var loopback = []*net.IPNet{
+	{IP: net.IP{127, 0, 0, 0}, Mask: net.CIDRMask(8, 32)},
+	{IP: net.IPv6loopback, Mask: net.CIDRMask(128, 128)},
+}
+
 func newLocalListener() net.Listener {
 	l, err := net.Listen("tcp", "127.0.0.1:0")
 	if err != nil {
@@ -128,6 +135,7 @@ func NewUnstartedServerWithProxyProto(h tcp.Handler) *Server {
 		Listener: &proxyproto.Listener{
 			Listener:           newLocalListener(),
 			ProxyHeaderTimeout: time.Duration(100 * time.Millisecond),
+			Trusted:            loopback,
 		},
 		Config: &tcp.Server{Handler: h},
 	}
@@ -127,7 +127,7 @@ func TestTCPProxyWithTLS(t *testing.T) {
 		if err != nil {
 			t.Fatal("cert.NewSource: ", err)
 		}
-		cfg, err := cert.TLSConfig(src, false, 0, 0, nil)
+		cfg, err := cert.TLSConfig(src, cert.MatchOptions{}, 0, 0, nil)
 		if err != nil {
 			t.Fatal("cert.TLSConfig: ", err)
 		}
@@ -245,6 +245,9 @@ var proxyHandler tcp.HandlerFunc = func(c net.Conn) error {
 	return err
 }
 
+// loopback trusts the PROXY protocol headers of the local clients.
+var loopback = []*net.IPNet{{IP: net.IP{127, 0, 0, 0}, Mask: net.CIDRMask(8, 32)}}
+
 // TestTCPProxyWithProxyProtoEnables tests proxying an unencrypted TCP connection
 // to a TCP upstream server with proxy protocol enabed on upstream connection
 func TestTCPProxyWithProxyProto(t *testing.T) {
@@ -261,7 +264,7 @@ func TestTCPProxyWithProxyProto(t *testing.T) {
 				return tgt
 			},
 		}
-		l := config.Listen{Addr: proxyAddr, ProxyProto: true}
+		l := config.Listen{Addr: proxyAddr, ProxyProto: true, ProxyTrusted: loopback}
 		if err := ListenAndServeTCP(l, h, nil); err != nil {
 			t.Log("ListenAndServeTCP: ", err)
 		}
@@ -311,18 +314,18 @@ func TestTCPProxyWithTLSWithProxyProto(t *testing.T) {
 		if err != nil {
 			t.Fatal("cert.NewSource: ", err)
 		}
-		cfg, err := cert.TLSConfig(src, false, 0, 0, nil)
+		cfg, err := cert.TLSConfig(src, cert.MatchOptions{}, 0, 0, nil)
 		if err != nil {
 			t.Fatal("cert.TLSConfig: ", err)
 		}
 
 		h := &tcp.Proxy{
 			Lookup: func(string) *route.Target {
-				return &route.Target{URL: &url.URL{Host: srv.Addr}, ProxyProto: true}
+				return &route.Target{URL: &url.URL{Host: srv.Addr}, ProxyProto: 1}
 			},
 		}
 
-		l := config.Listen{Addr: proxyAddr, ProxyProto: true}
+		l := config.Listen{Addr: proxyAddr, ProxyProto: true, ProxyTrusted: loopback}
 		if err := ListenAndServeTCP(l, h, cfg); err != nil {
 			// closing the listener returns this error from the accept loop
 			// which we can ignore.
@@ -369,10 +372,10 @@ func TestTCPSNIProxyWithProxyProto(t *testing.T) {
 	go func() {
 		h := &tcp.SNIProxy{
 			Lookup: func(string) *route.Target {
-				return &route.Target{URL: &url.URL{Host: srv.Addr}, ProxyProto: true}
+				return &route.Target{URL: &url.URL{Host: srv.Addr}, ProxyProto: 1}
 			},
 		}
-		l := config.Listen{Addr: proxyAddr, ProxyProto: true}
+		l := config.Listen{Addr: proxyAddr, ProxyProto: true, ProxyTrusted: loopback}
 		if err := ListenAndServeTCP(l, h, nil); err != nil {
 			t.Log("ListenAndServeTCP: ", err)
 		}
@@ -0,0 +1,103 @@
+package proxy
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fabiolb/fabio/route"
+)
+
+// grpcTimeoutHeader is the header with the deadline of a gRPC request.
+const grpcTimeoutHeader = "Grpc-Timeout"
+
+// requestTimeout returns the timeout which the client requested with
+// the first of the headers which has a valid value. The timeout is
+// bounded by max if max is greater than zero. It returns zero if the
+// client did not request a timeout.
+func requestTimeout(h http.Header, headers []string, max time.Duration) time.Duration {
+	for _, name := range headers {
+		v := h.Get(name)
+		if v == "" {
+			continue
+		}
+		d, ok := parseTimeout(name, v)
+		if !ok || d <= 0 {
+			continue
+		}
+		if max > 0 && d > max {
+			d = max
+		}
+		return d
+	}
+	return 0
+}
+
+// maxTimeout returns the maximum timeout a client can request for
+// the target. The option of the route overrides the global default.
+func maxTimeout(t *route.Target, def time.Duration) time.Duration {
+	if t.MaxTimeout > 0 {
+		return t.MaxTimeout
+	}
+	return def
+}
+
+// parseTimeout parses the value of a timeout header. The grpc-timeout
+// header uses the gRPC format. All other headers contain a duration
+// like '1.5s' or '250ms' or a number of seconds.
+func parseTimeout(name, v string) (time.Duration, bool) {
+	if http.CanonicalHeaderKey(name) == grpcTimeoutHeader {
+		return parseGRPCTimeout(v)
+	}
+	if d, err := time.ParseDuration(v); err == nil {
+		return d, true
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, false
+	}
+	return time.Duration(f * float64(time.Second)), true
+}
+
+// parseGRPCTimeout parses a timeout in the format of the grpc-timeout
+// header which is a positive integer of at most eight digits followed
+// by one of the units H, M, S, m, u or n.
+func parseGRPCTimeout(v string) (time.Duration, bool) {
+	if len(v) < 2 || len(v) > 9 {
+		return 0, false
+	}
+	var unit time.Duration
+	switch v[len(v)-1] {
+	case 'H':
+		unit = time.Hour
+	case 'M':
+		unit = time.Minute
+	case 'S':
+		unit = time.Second
+	case 'm':
+		unit = time.Millisecond
+	case 'u':
+		unit = time.Microsecond
+	case 'n':
+		unit = time.Nanosecond
+	default:
+		return 0, false
+	}
+	n, err := strconv.ParseInt(v[:len(v)-1], 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return time.Duration(n) * unit, true
+}
+
+// honorsGRPCTimeout returns true if the grpc-timeout header is one
+// of the timeout headers which are honored.
+func honorsGRPCTimeout(headers []string) bool {
+	for _, h := range headers {
+		if strings.EqualFold(h, grpcTimeoutHeader) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,75 @@
+package proxy
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fabiolb/fabio/config"
+	"github.com/fabiolb/fabio/route"
+)
+
+func TestRequestTimeout(t *testing.T) {
+	headers := []string{"X-Request-Timeout", "grpc-timeout"}
+	tests := []struct {
+		desc   string
+		header http.Header
+		max    time.Duration
+		d      time.Duration
+	}{
+		{"no header", http.Header{}, 0, 0},
+		{"duration", http.Header{"X-Request-Timeout": {"250ms"}}, 0, 250 * time.Millisecond},
+		{"seconds", http.Header{"X-Request-Timeout": {"1.5"}}, 0, 1500 * time.Millisecond},
+		{"bounded", http.Header{"X-Request-Timeout": {"1m"}}, 5 * time.Second, 5 * time.Second},
+		{"grpc", http.Header{"Grpc-Timeout": {"100m"}}, 0, 100 * time.Millisecond},
+		{"first valid header", http.Header{"X-Request-Timeout": {"x"}, "Grpc-Timeout": {"2S"}}, 0, 2 * time.Second},
+		{"negative", http.Header{"X-Request-Timeout": {"-1s"}}, 0, 0},
+		{"invalid grpc unit", http.Header{"Grpc-Timeout": {"10s"}}, 0, 0},
+		{"grpc too long", http.Header{"Grpc-Timeout": {"123456789S"}}, 0, 0},
+		{"header not honored", http.Header{"X-Timeout": {"1s"}}, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			if got, want := requestTimeout(tt.header, headers, tt.max), tt.d; got != want {
+				t.Fatalf("got %s want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestProxyRequestTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(time.Second):
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+
+	proxy := httptest.NewServer(&HTTPProxy{
+		Config:    config.Proxy{TimeoutHeaders: []string{"X-Request-Timeout"}, TimeoutMax: time.Minute},
+		Transport: http.DefaultTransport,
+		Lookup: func(r *http.Request) *route.Target {
+			tbl, _ := route.NewTable(bytes.NewBufferString("route add mock / " + server.URL + ` opts "maxtimeout=50ms"`))
+			return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"], globCache, globEnabled)
+		},
+	})
+	defer proxy.Close()
+
+	req, _ := http.NewRequest("GET", proxy.URL, nil)
+	req.Header.Set("X-Request-Timeout", "10s")
+	start := time.Now()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got, want := resp.StatusCode, http.StatusGatewayTimeout; got != want {
+		t.Fatalf("got status %d want %d", got, want)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Fatalf("got response after %s want it after the route maximum of 50ms", d)
+	}
+}
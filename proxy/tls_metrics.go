@@ -0,0 +1,98 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"strings"
+	"sync"
+
+	"github.com/fabiolb/fabio/metrics"
+)
+
+// TLSMetrics counts the TLS handshakes of a listener by version, cipher
+// suite, resumption and SNI host name. The counters are reported as
+//
+//	<prefix>.handshake
+//	<prefix>.resumed
+//	<prefix>.version.<version>
+//	<prefix>.cipher.<cipher suite>
+//	<prefix>.sni.<host name>
+//
+// The first SNITopK distinct host names get their own counter. The
+// handshakes for all other host names and without SNI are counted as
+// 'other' and 'none' to keep the number of metrics bounded.
+type TLSMetrics struct {
+	Prefix  string
+	SNITopK int
+
+	mu  sync.Mutex
+	sni map[string]bool
+}
+
+// TLSConfig returns a copy of cfg which counts the handshakes.
+// It returns nil if cfg is nil.
+func (m *TLSMetrics) TLSConfig(cfg *tls.Config) *tls.Config {
+	if cfg == nil {
+		return nil
+	}
+	c := cfg.Clone()
+	verify := c.VerifyConnection
+	c.VerifyConnection = func(cs tls.ConnectionState) error {
+		if verify != nil {
+			if err := verify(cs); err != nil {
+				return err
+			}
+		}
+		m.Count(cs)
+		return nil
+	}
+	return c
+}
+
+// Count updates the counters for a completed handshake.
+func (m *TLSMetrics) Count(cs tls.ConnectionState) {
+	r := metrics.DefaultRegistry
+	r.GetCounter(m.Prefix + ".handshake").Inc(1)
+	if cs.DidResume {
+		r.GetCounter(m.Prefix + ".resumed").Inc(1)
+	}
+	r.GetCounter(m.Prefix + ".version." + tlsVersionName(cs.Version)).Inc(1)
+	r.GetCounter(m.Prefix + ".cipher." + tls.CipherSuiteName(cs.CipherSuite)).Inc(1)
+	r.GetCounter(m.Prefix + ".sni." + m.sniName(cs.ServerName)).Inc(1)
+}
+
+// sniName returns the metric name for the host name.
+func (m *TLSMetrics) sniName(host string) string {
+	if host == "" {
+		return "none"
+	}
+	host = strings.Replace(strings.ToLower(host), ".", "_", -1)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.sni == nil {
+		m.sni = map[string]bool{}
+	}
+	if m.sni[host] {
+		return host
+	}
+	if len(m.sni) >= m.SNITopK {
+		return "other"
+	}
+	m.sni[host] = true
+	return host
+}
+
+func tlsVersionName(v uint16) string {
+	switch v {
+	case tls.VersionTLS10:
+		return "tls1_0"
+	case tls.VersionTLS11:
+		return "tls1_1"
+	case tls.VersionTLS12:
+		return "tls1_2"
+	case tls.VersionTLS13:
+		return "tls1_3"
+	default:
+		return "unknown"
+	}
+}
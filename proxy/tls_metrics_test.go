@@ -0,0 +1,88 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/fabiolb/fabio/metrics"
+)
+
+func TestTLSMetrics(t *testing.T) {
+	reg := &countingRegistry{n: map[string]int64{}}
+	defer func(r metrics.Registry) { metrics.DefaultRegistry = r }(metrics.DefaultRegistry)
+	metrics.DefaultRegistry = reg
+
+	m := &TLSMetrics{Prefix: "tls.443", SNITopK: 1}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.TLS = m.TLSConfig(&tls.Config{
+		MaxVersion:   tls.VersionTLS12,
+		CipherSuites: []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+	})
+	srv.StartTLS()
+	defer srv.Close()
+
+	for _, host := range []string{"example.com", "example.com", "www.example.com"} {
+		client := srv.Client()
+		client.Transport.(*http.Transport).TLSClientConfig.ServerName = host
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		client.CloseIdleConnections()
+	}
+	m.Count(tls.ConnectionState{Version: tls.VersionTLS13, CipherSuite: tls.TLS_AES_128_GCM_SHA256, DidResume: true})
+
+	want := map[string]int64{
+		"tls.443.handshake":                                    4,
+		"tls.443.resumed":                                      1,
+		"tls.443.version.tls1_2":                               3,
+		"tls.443.version.tls1_3":                               1,
+		"tls.443.cipher.TLS_AES_128_GCM_SHA256":                1,
+		"tls.443.cipher.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256": 3,
+		"tls.443.sni.example_com":                              2,
+		"tls.443.sni.other":                                    1,
+		"tls.443.sni.none":                                     1,
+	}
+	got := reg.counts()
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+}
+
+// countingRegistry counts the increments of the counters.
+type countingRegistry struct {
+	metrics.NoopRegistry
+
+	mu sync.Mutex
+	n  map[string]int64
+}
+
+func (r *countingRegistry) GetCounter(name string) metrics.Counter {
+	return countingCounter{r, name}
+}
+
+func (r *countingRegistry) counts() map[string]int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m := map[string]int64{}
+	for name, n := range r.n {
+		m[name] = n
+	}
+	return m
+}
+
+type countingCounter struct {
+	r    *countingRegistry
+	name string
+}
+
+func (c countingCounter) Inc(n int64) {
+	c.r.mu.Lock()
+	c.r.n[c.name] += n
+	c.r.mu.Unlock()
+}
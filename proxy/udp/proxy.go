@@ -0,0 +1,286 @@
+// Package udp implements a UDP proxy which forwards datagrams to
+// the targets of the routing table.
+//
+// Every client address is a session with its own upstream socket so
+// that the replies of the target can be sent back to the client.
+// Sessions without traffic in either direction are closed after the
+// idle timeout.
+package udp
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fabiolb/fabio/config"
+	"github.com/fabiolb/fabio/metrics"
+	"github.com/fabiolb/fabio/proxy/sockopt"
+	"github.com/fabiolb/fabio/route"
+)
+
+// DefaultIdleTimeout is the idle timeout of a session
+// if the proxy has no idle timeout.
+const DefaultIdleTimeout = time.Minute
+
+// maxDatagramSize is the maximum size of a UDP payload.
+const maxDatagramSize = 65535
+
+// ErrClosed is returned by Serve if the proxy has been closed.
+var ErrClosed = errors.New("udp: proxy closed")
+
+// Proxy forwards the datagrams of a UDP listener to the targets
+// which are registered for the port of the listener.
+type Proxy struct {
+	// DialTimeout sets the timeout for resolving the target address.
+	DialTimeout time.Duration
+
+	// IdleTimeout sets the time after which a session without traffic
+	// is closed. Defaults to DefaultIdleTimeout.
+	IdleTimeout time.Duration
+
+	// Lookup returns a target host for the given listener port.
+	// The proxy will panic if this value is nil.
+	Lookup func(host string) *route.Target
+
+	// Session counts the number of sessions.
+	Session metrics.Counter
+
+	// Sessions reports the number of active sessions.
+	Sessions metrics.Gauge
+
+	// ConnFail counts the failed upstream connection attempts.
+	ConnFail metrics.Counter
+
+	// Noroute counts the failed Lookup() calls.
+	Noroute metrics.Counter
+
+	// mu guards the fields below.
+	mu       sync.Mutex
+	conn     net.PacketConn
+	sessions map[string]*session
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+// session contains the upstream socket of a client.
+type session struct {
+	client net.Addr
+	out    net.Conn
+	target *route.Target
+
+	// last is the time of the last datagram in either
+	// direction in nanoseconds. It is accessed atomically.
+	last int64
+
+	rx, tx metrics.Counter
+}
+
+func (s *session) touch() {
+	atomic.StoreInt64(&s.last, time.Now().UnixNano())
+}
+
+func (s *session) idleSince() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&s.last))
+}
+
+// Serve forwards the datagrams received on conn until the proxy is
+// closed or conn returns an error. It always closes conn.
+func (p *Proxy) Serve(conn net.PacketConn) error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		conn.Close()
+		return ErrClosed
+	}
+	p.conn = conn
+	p.sessions = map[string]*session{}
+	p.mu.Unlock()
+	defer p.Close()
+
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if p.isClosed() {
+				return nil
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			return err
+		}
+
+		s := p.session(conn, addr)
+		if s == nil {
+			continue
+		}
+		s.touch()
+		if _, err := s.out.Write(buf[:n]); err != nil {
+			log.Printf("[WARN] udp: Cannot forward datagram from %s to %s. %s", addr, s.target.URL.Host, err)
+			continue
+		}
+		s.tx.Inc(int64(n))
+	}
+}
+
+// session returns the session of the client and creates a new
+// one if necessary. It returns nil if there is no route for the
+// listener or the upstream socket cannot be created.
+func (p *Proxy) session(conn net.PacketConn, client net.Addr) *session {
+	key := client.String()
+	p.mu.Lock()
+	s := p.sessions[key]
+	p.mu.Unlock()
+	if s != nil {
+		return s
+	}
+
+	_, port, _ := net.SplitHostPort(conn.LocalAddr().String())
+	t := p.Lookup(":" + port)
+	if t == nil {
+		if p.Noroute != nil {
+			p.Noroute.Inc(1)
+		}
+		return nil
+	}
+	if t.AccessDeniedUDP(client) {
+		return nil
+	}
+
+	d := net.Dialer{
+		Timeout: p.DialTimeout,
+		Control: sockopt.Control(config.SocketOpts{TOS: t.DSCP << 2}),
+	}
+	out, err := d.Dial("udp", t.URL.Host)
+	if err != nil {
+		log.Printf("[WARN] udp: Cannot connect to %s. %s", t.URL.Host, err)
+		if p.ConnFail != nil {
+			p.ConnFail.Inc(1)
+		}
+		return nil
+	}
+
+	s = &session{
+		client: client,
+		out:    out,
+		target: t,
+		rx:     metrics.DefaultRegistry.GetCounter(t.TimerName + ".rx"),
+		tx:     metrics.DefaultRegistry.GetCounter(t.TimerName + ".tx"),
+	}
+	s.touch()
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		out.Close()
+		return nil
+	}
+	p.sessions[key] = s
+	p.wg.Add(1)
+	p.updateSessions()
+	p.mu.Unlock()
+
+	if p.Session != nil {
+		p.Session.Inc(1)
+	}
+	go p.reply(conn, s)
+	return s
+}
+
+// reply sends the datagrams of the target back to the client
+// until the session is idle or the upstream socket fails.
+func (p *Proxy) reply(conn net.PacketConn, s *session) {
+	defer p.wg.Done()
+	defer p.remove(s)
+
+	idle := p.IdleTimeout
+	if idle <= 0 {
+		idle = DefaultIdleTimeout
+	}
+
+	buf := make([]byte, maxDatagramSize)
+	for {
+		s.out.SetReadDeadline(s.idleSince().Add(idle))
+		n, err := s.out.Read(buf)
+		if err != nil {
+			// the client may have sent datagrams in the meantime
+			if ne, ok := err.(net.Error); ok && ne.Timeout() && time.Since(s.idleSince()) < idle {
+				continue
+			}
+			if !p.isClosed() {
+				log.Printf("[DEBUG] udp: Closing session %s -> %s. %s", s.client, s.target.URL.Host, err)
+			}
+			return
+		}
+		s.touch()
+		if _, err := conn.WriteTo(buf[:n], s.client); err != nil {
+			log.Printf("[WARN] udp: Cannot send datagram to %s. %s", s.client, err)
+			continue
+		}
+		s.rx.Inc(int64(n))
+	}
+}
+
+func (p *Proxy) remove(s *session) {
+	s.out.Close()
+	p.mu.Lock()
+	if p.sessions[s.client.String()] == s {
+		delete(p.sessions, s.client.String())
+		p.updateSessions()
+	}
+	p.mu.Unlock()
+}
+
+// updateSessions reports the number of active sessions.
+// p.mu must be held.
+func (p *Proxy) updateSessions() {
+	if p.Sessions != nil {
+		p.Sessions.Update(int64(len(p.sessions)))
+	}
+}
+
+func (p *Proxy) isClosed() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.closed
+}
+
+// Close closes the listener and all sessions.
+func (p *Proxy) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	var err error
+	if p.conn != nil {
+		err = p.conn.Close()
+	}
+	for _, s := range p.sessions {
+		s.out.Close()
+	}
+	return err
+}
+
+// Shutdown closes the proxy and waits until all sessions have been
+// closed or the context is done. Since UDP has no notion of a
+// request, pending replies of the targets are dropped.
+func (p *Proxy) Shutdown(ctx context.Context) error {
+	err := p.Close()
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,185 @@
+package udp
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fabiolb/fabio/route"
+)
+
+// echoServer starts a UDP server
+// which sends every datagram back to the sender.
+func echoServer(t *testing.T) net.PacketConn {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		buf := make([]byte, maxDatagramSize)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			conn.WriteTo(buf[:n], addr)
+		}
+	}()
+	return conn
+}
+
+// startProxy starts the proxy on a random port
+// and returns the address of the listener.
+func startProxy(t *testing.T, p *Proxy) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go p.Serve(conn)
+	return conn.LocalAddr().String()
+}
+
+func roundtrip(t *testing.T, c net.Conn, msg string) string {
+	if _, err := c.Write([]byte(msg)); err != nil {
+		t.Fatal(err)
+	}
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 64)
+	n, err := c.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(buf[:n])
+}
+
+func TestProxy(t *testing.T) {
+	srv := echoServer(t)
+	defer srv.Close()
+
+	var lookups, sessions int64
+	var active gauge
+	p := &Proxy{
+		Lookup: func(host string) *route.Target {
+			atomic.AddInt64(&lookups, 1)
+			return &route.Target{URL: &url.URL{Scheme: "udp", Host: srv.LocalAddr().String()}}
+		},
+		Session:  countFunc(func(n int64) { atomic.AddInt64(&sessions, n) }),
+		Sessions: &active,
+	}
+	defer p.Close()
+	addr := startProxy(t, p)
+
+	for _, msg := range []string{"foo", "bar"} {
+		c, err := net.Dial("udp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+
+		for i := 0; i < 3; i++ {
+			if got, want := roundtrip(t, c, msg), msg; got != want {
+				t.Fatalf("got %q want %q", got, want)
+			}
+		}
+	}
+
+	if got, want := atomic.LoadInt64(&lookups), int64(2); got != want {
+		t.Fatalf("got %d lookups want %d", got, want)
+	}
+	if got, want := atomic.LoadInt64(&sessions), int64(2); got != want {
+		t.Fatalf("got %d sessions want %d", got, want)
+	}
+	if got, want := active.value(), int64(2); got != want {
+		t.Fatalf("got %d active sessions want %d", got, want)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := p.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := active.value(), int64(0); got != want {
+		t.Fatalf("got %d active sessions after shutdown want %d", got, want)
+	}
+}
+
+func TestProxyIdleTimeout(t *testing.T) {
+	srv := echoServer(t)
+	defer srv.Close()
+
+	var active gauge
+	p := &Proxy{
+		IdleTimeout: 50 * time.Millisecond,
+		Lookup: func(host string) *route.Target {
+			return &route.Target{URL: &url.URL{Scheme: "udp", Host: srv.LocalAddr().String()}}
+		},
+		Sessions: &active,
+	}
+	defer p.Close()
+	addr := startProxy(t, p)
+
+	c, err := net.Dial("udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// traffic within the idle timeout keeps the session open
+	for i := 0; i < 4; i++ {
+		roundtrip(t, c, "foo")
+		time.Sleep(25 * time.Millisecond)
+		if got, want := active.value(), int64(1); got != want {
+			t.Fatalf("got %d active sessions want %d", got, want)
+		}
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for active.value() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("session not closed after idle timeout")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// the next datagram creates a new session
+	if got, want := roundtrip(t, c, "bar"), "bar"; got != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+}
+
+func TestProxyNoroute(t *testing.T) {
+	var noroute int64
+	p := &Proxy{
+		Lookup:  func(host string) *route.Target { return nil },
+		Noroute: countFunc(func(n int64) { atomic.AddInt64(&noroute, n) }),
+	}
+	defer p.Close()
+	addr := startProxy(t, p)
+
+	c, err := net.Dial("udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.Write([]byte("foo"))
+
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt64(&noroute) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("datagram without route not counted")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+type countFunc func(int64)
+
+func (f countFunc) Inc(n int64) { f(n) }
+
+type gauge struct{ n int64 }
+
+func (g *gauge) Update(n int64) { atomic.StoreInt64(&g.n, n) }
+func (g *gauge) value() int64   { return atomic.LoadInt64(&g.n) }
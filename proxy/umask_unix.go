@@ -0,0 +1,11 @@
+// +build !windows
+
+package proxy
+
+import "syscall"
+
+// umask sets the file mode creation mask of the process
+// and returns the previous mask.
+func umask(mask int) int {
+	return syscall.Umask(mask)
+}
@@ -0,0 +1,8 @@
+// +build windows
+
+package proxy
+
+func umask(mask int) int {
+	// windows not supported
+	return 0
+}
@@ -0,0 +1,50 @@
+package proxy
+
+import (
+	"strings"
+
+	"github.com/fabiolb/fabio/metrics"
+	"github.com/fabiolb/fabio/route"
+)
+
+// uaFamilies contains the substrings of lower case user agents which
+// identify a client family. The families are checked in order since
+// many crawlers and apps also claim to be a browser.
+var uaFamilies = []struct {
+	family string
+	match  []string
+}{
+	{"bot", []string{"bot", "crawl", "spider", "slurp", "facebookexternalhit", "headlesschrome"}},
+	{"cli", []string{"curl/", "wget/", "httpie/", "powershell"}},
+	{"mobile", []string{"okhttp", "cfnetwork", "alamofire", "dalvik"}},
+	{"library", []string{"go-http-client", "python-requests", "python-urllib", "aiohttp", "java/", "apache-httpclient", "axios", "node-fetch"}},
+	{"browser", []string{"mozilla/", "opera/"}},
+}
+
+// uaFamily returns the client family of the user agent which is one of
+// 'bot', 'cli', 'mobile', 'library', 'browser', 'other' or 'none'. The
+// fixed set of families keeps the number of metrics per route low.
+func uaFamily(ua string) string {
+	if ua == "" {
+		return "none"
+	}
+	ua = strings.ToLower(ua)
+	for _, f := range uaFamilies {
+		for _, s := range f.match {
+			if strings.Contains(ua, s) {
+				return f.family
+			}
+		}
+	}
+	return "other"
+}
+
+// countUserAgent updates the request counters of the user agent
+// family for all routes and for the route of the target.
+func countUserAgent(t *route.Target, ua string) {
+	f := uaFamily(ua)
+	metrics.DefaultRegistry.GetCounter("ua." + f).Inc(1)
+	if t.TimerName != "" {
+		metrics.DefaultRegistry.GetCounter(t.TimerName + ".ua." + f).Inc(1)
+	}
+}
@@ -0,0 +1,54 @@
+package proxy
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/fabiolb/fabio/metrics"
+	"github.com/fabiolb/fabio/route"
+)
+
+func TestUAFamily(t *testing.T) {
+	tests := []struct {
+		ua, family string
+	}{
+		{"", "none"},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0 Safari/537.36", "browser"},
+		{"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)", "bot"},
+		{"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) HeadlessChrome/120.0 Safari/537.36", "bot"},
+		{"curl/8.4.0", "cli"},
+		{"Wget/1.21", "cli"},
+		{"okhttp/4.12.0", "mobile"},
+		{"MyApp/1.2 CFNetwork/1410.0.3 Darwin/22.6.0", "mobile"},
+		{"Go-http-client/1.1", "library"},
+		{"python-requests/2.31.0", "library"},
+		{"Java/17.0.2", "library"},
+		{"something", "other"},
+	}
+	for _, tt := range tests {
+		if got, want := uaFamily(tt.ua), tt.family; got != want {
+			t.Errorf("%q: got %q want %q", tt.ua, got, want)
+		}
+	}
+}
+
+func TestCountUserAgent(t *testing.T) {
+	reg := &countingRegistry{n: map[string]int64{}}
+	defer func(r metrics.Registry) { metrics.DefaultRegistry = r }(metrics.DefaultRegistry)
+	metrics.DefaultRegistry = reg
+
+	tg := &route.Target{TimerName: "svc"}
+	countUserAgent(tg, "curl/8.4.0")
+	countUserAgent(tg, "curl/7.0")
+	countUserAgent(tg, "")
+
+	want := map[string]int64{
+		"ua.cli":      2,
+		"ua.none":     1,
+		"svc.ua.cli":  2,
+		"svc.ua.none": 1,
+	}
+	if got := reg.counts(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+}
@@ -2,30 +2,75 @@ package proxy
 
 import (
 	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
 	"io"
 	"log"
 	"net"
 	"net/http"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/fabiolb/fabio/metrics"
 )
 
-// conn measures the number of open web socket connections
-var conn = metrics.DefaultRegistry.GetCounter("ws.conn")
+// wsCloseGoingAway is the status code of the close frames which are
+// sent when a websocket connection is closed by the proxy.
+const wsCloseGoingAway = 1001
+
+// wsConns contains the active websocket connections
+// which are closed when the proxy shuts down.
+var wsConns = struct {
+	sync.Mutex
+	m map[*wsConn]bool
+}{m: map[*wsConn]bool{}}
+
+// wsConn is an active websocket connection.
+type wsConn struct {
+	// closing is closed to end the connection with a close frame.
+	closing chan struct{}
+
+	// done is closed when the connection has been closed.
+	done chan struct{}
+}
+
+// closeWebSockets ends the active websocket connections with a close
+// frame and waits until they are closed or the context is done.
+func closeWebSockets(ctx context.Context) {
+	wsConns.Lock()
+	var conns []*wsConn
+	for c := range wsConns.m {
+		close(c.closing)
+		conns = append(conns, c)
+		delete(wsConns.m, c)
+	}
+	wsConns.Unlock()
+
+	if len(conns) > 0 {
+		log.Printf("[INFO] Closing %d websocket connections", len(conns))
+	}
+	for _, c := range conns {
+		select {
+		case <-c.done:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
 
 type dialFunc func(network, address string) (net.Conn, error)
 
 // newWSHandler returns an HTTP handler which forwards data between
 // an incoming and outgoing websocket connection. It checks whether
 // the handshake was completed successfully before forwarding data
-// between the client and server.
-func newWSHandler(host string, dial dialFunc) http.Handler {
+// between the client and server. Connections without data in either
+// direction for longer than idle are closed. name is the prefix of
+// the metrics of the target.
+func newWSHandler(host string, dial dialFunc, name string, idle time.Duration) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		conn.Inc(1)
-		defer func() { conn.Inc(-1) }()
-
 		hj, ok := w.(http.Hijacker)
 		if !ok {
 			http.Error(w, "not a hijacker", http.StatusInternalServerError)
@@ -89,17 +134,198 @@ func newWSHandler(host string, dial dialFunc) http.Handler {
 
 		out.SetReadDeadline(time.Time{})
 
+		conn := metrics.DefaultRegistry.GetCounter("ws.conn")
+		conn.Inc(1)
+		defer conn.Inc(-1)
+		if name != "" {
+			routeConn := metrics.DefaultRegistry.GetCounter(name + ".ws.conn")
+			routeConn.Inc(1)
+			defer routeConn.Inc(-1)
+		}
+
+		wc := &wsConn{closing: make(chan struct{}), done: make(chan struct{})}
+		wsConns.Lock()
+		wsConns.m[wc] = true
+		wsConns.Unlock()
+		defer func() {
+			wsConns.Lock()
+			delete(wsConns.m, wc)
+			wsConns.Unlock()
+			close(wc.done)
+		}()
+
+		last := time.Now().UnixNano()
+		up := &wsWriter{w: out, last: &last}
+		down := &wsWriter{w: in, last: &last}
+
+		// the response of the handshake may contain the first frames
+		if i := bytes.Index(b, []byte("\r\n\r\n")); i >= 0 {
+			down.frames.track(b[i+4:])
+		} else {
+			down.frames.lost = true
+		}
+
 		errc := make(chan error, 2)
-		cp := func(dst io.Writer, src io.Reader) {
+		cp := func(dst *wsWriter, src io.Reader) {
 			_, err := io.Copy(dst, src)
 			errc <- err
 		}
 
-		go cp(out, in)
-		go cp(in, out)
-		err = <-errc
-		if err != nil && err != io.EOF {
-			log.Printf("[INFO] WS error for %s. %s", r.URL, err)
+		go cp(up, in)
+		go cp(down, out)
+
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if idle > 0 {
+			timer = time.NewTimer(idle)
+			defer timer.Stop()
+			timeout = timer.C
+		}
+		for {
+			select {
+			case err := <-errc:
+				if err != nil && err != io.EOF {
+					log.Printf("[INFO] WS error for %s. %s", r.URL, err)
+				}
+				return
+
+			case <-timeout:
+				if d := time.Since(time.Unix(0, atomic.LoadInt64(&last))); d < idle {
+					timer.Reset(idle - d)
+					continue
+				}
+				metrics.DefaultRegistry.GetCounter("ws.idle").Inc(1)
+				wsGoingAway(in, out, up, down, errc, "idle timeout")
+				return
+
+			case <-wc.closing:
+				wsGoingAway(in, out, up, down, errc, "shutdown")
+				return
+			}
 		}
 	})
 }
+
+// wsGoingAway stops forwarding data between the client and the server
+// and sends a close frame with the reason to both sides if the stream
+// is between two frames.
+func wsGoingAway(in, out net.Conn, up, down *wsWriter, errc chan error, reason string) {
+	now := time.Now()
+	in.SetDeadline(now)
+	out.SetDeadline(now)
+	<-errc
+	<-errc
+
+	deadline := now.Add(time.Second)
+	if down.frames.boundary() {
+		in.SetWriteDeadline(deadline)
+		in.Write(wsCloseFrame(wsCloseGoingAway, reason, false))
+	}
+	if up.frames.boundary() {
+		out.SetWriteDeadline(deadline)
+		out.Write(wsCloseFrame(wsCloseGoingAway, reason, true))
+	}
+}
+
+// wsCloseFrame returns a close frame with the status code and the
+// reason. Frames from the client to the server must be masked.
+func wsCloseFrame(code int, reason string, mask bool) []byte {
+	payload := append([]byte{byte(code >> 8), byte(code)}, reason...)
+	b := []byte{0x88, byte(len(payload))}
+	if !mask {
+		return append(b, payload...)
+	}
+	key := make([]byte, 4)
+	rand.Read(key)
+	b[1] |= 0x80
+	b = append(b, key...)
+	for i, c := range payload {
+		b = append(b, c^key[i%4])
+	}
+	return b
+}
+
+// wsWriter forwards the data of one direction of a websocket
+// connection and tracks the frame boundaries and the time of
+// the last activity.
+type wsWriter struct {
+	w      io.Writer
+	frames wsFrames
+
+	// last is the time of the last write in nanoseconds
+	// since the epoch. It is shared by both directions.
+	last *int64
+}
+
+func (w *wsWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.frames.track(p[:n])
+	atomic.StoreInt64(w.last, time.Now().UnixNano())
+	return n, err
+}
+
+// wsFrames tracks the frame boundaries of a websocket stream
+// so that a close frame can be sent between two frames.
+type wsFrames struct {
+	// hdr is the header of the current frame until it is complete.
+	hdr []byte
+
+	// left is the number of payload bytes of the current frame
+	// which have not been seen yet.
+	left uint64
+
+	// lost is true if the position in the stream is unknown.
+	lost bool
+}
+
+func (f *wsFrames) track(p []byte) {
+	for len(p) > 0 {
+		if f.left > 0 {
+			n := uint64(len(p))
+			if n > f.left {
+				n = f.left
+			}
+			f.left -= n
+			p = p[n:]
+			continue
+		}
+		f.hdr = append(f.hdr, p[0])
+		p = p[1:]
+		if n, ok := wsPayloadLen(f.hdr); ok {
+			f.left, f.hdr = n, f.hdr[:0]
+		}
+	}
+}
+
+// boundary returns true if the stream is between two frames.
+func (f *wsFrames) boundary() bool {
+	return !f.lost && f.left == 0 && len(f.hdr) == 0
+}
+
+// wsPayloadLen returns the payload length of a frame
+// if the header h of the frame is complete.
+func wsPayloadLen(h []byte) (uint64, bool) {
+	if len(h) < 2 {
+		return 0, false
+	}
+	n, size := uint64(h[1]&0x7f), 2
+	switch n {
+	case 126:
+		size += 2
+	case 127:
+		size += 8
+	}
+	if h[1]&0x80 != 0 {
+		size += 4
+	}
+	if len(h) < size {
+		return 0, false
+	}
+	switch n {
+	case 126:
+		n = uint64(binary.BigEndian.Uint16(h[2:4]))
+	case 127:
+		n = binary.BigEndian.Uint64(h[2:10])
+	}
+	return n, true
+}
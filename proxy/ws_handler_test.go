@@ -0,0 +1,172 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fabiolb/fabio/config"
+	"github.com/fabiolb/fabio/route"
+
+	"golang.org/x/net/websocket"
+)
+
+func TestWSFrames(t *testing.T) {
+	frame := func(n int, mask bool) []byte {
+		var b []byte
+		switch {
+		case n < 126:
+			b = []byte{0x82, byte(n)}
+		case n < 65536:
+			b = []byte{0x82, 126, 0, 0}
+			binary.BigEndian.PutUint16(b[2:], uint16(n))
+		default:
+			b = []byte{0x82, 127, 0, 0, 0, 0, 0, 0, 0, 0}
+			binary.BigEndian.PutUint64(b[2:], uint64(n))
+		}
+		if mask {
+			b[1] |= 0x80
+			b = append(b, 1, 2, 3, 4)
+		}
+		return append(b, make([]byte, n)...)
+	}
+
+	var stream []byte
+	for _, f := range [][]byte{frame(0, false), frame(5, true), frame(300, false), frame(70000, true), frame(1, false)} {
+		stream = append(stream, f...)
+	}
+
+	// split the stream into chunks of different sizes so
+	// that the headers are split across writes
+	for _, size := range []int{1, 2, 7, 100, 4096, len(stream)} {
+		var f wsFrames
+		for p := stream; len(p) > 0; {
+			n := size
+			if n > len(p) {
+				n = len(p)
+			}
+			f.track(p[:n])
+			p = p[n:]
+		}
+		if !f.boundary() {
+			t.Fatalf("%d: got no boundary want boundary", size)
+		}
+		f.track(stream[:3])
+		if f.boundary() {
+			t.Fatalf("%d: got boundary want none", size)
+		}
+	}
+
+	f := wsFrames{lost: true}
+	if f.boundary() {
+		t.Fatal("got boundary for lost stream want none")
+	}
+}
+
+func TestWSCloseFrame(t *testing.T) {
+	if got, want := wsCloseFrame(1001, "bye", false), []byte{0x88, 5, 0x03, 0xe9, 'b', 'y', 'e'}; !bytes.Equal(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+
+	b := wsCloseFrame(1001, "bye", true)
+	if len(b) != 11 || b[0] != 0x88 || b[1] != 0x80|5 {
+		t.Fatalf("got %v want a masked close frame", b)
+	}
+	key, payload := b[2:6], b[6:]
+	for i := range payload {
+		payload[i] ^= key[i%4]
+	}
+	if got, want := payload, []byte{0x03, 0xe9, 'b', 'y', 'e'}; !bytes.Equal(got, want) {
+		t.Fatalf("got payload %v want %v", got, want)
+	}
+}
+
+func TestProxyWSGoingAway(t *testing.T) {
+	server := httptest.NewServer(websocket.Handler(wsEchoHandler))
+	defer server.Close()
+
+	proxy := httptest.NewServer(&HTTPProxy{
+		Config:    config.Proxy{WSIdleTimeout: 200 * time.Millisecond},
+		Transport: http.DefaultTransport,
+		Lookup: func(r *http.Request) *route.Target {
+			tbl, _ := route.NewTable(bytes.NewBufferString("route add ws /ws " + server.URL))
+			return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"], globCache, globEnabled)
+		},
+	})
+	defer proxy.Close()
+
+	// dial opens a websocket connection through the proxy
+	// and checks that a text frame is echoed.
+	dial := func(t *testing.T) (net.Conn, *bufio.Reader) {
+		t.Helper()
+		c, err := net.Dial("tcp", strings.TrimPrefix(proxy.URL, "http://"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := "GET /ws HTTP/1.1\r\nHost: example.com\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+			"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\nOrigin: http://localhost/\r\n\r\n"
+		if _, err := io.WriteString(c, req); err != nil {
+			t.Fatal(err)
+		}
+		br := bufio.NewReader(c)
+		resp, err := http.ReadResponse(br, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != 101 {
+			t.Fatalf("got status %d want 101", resp.StatusCode)
+		}
+
+		// masked text frame with 'foo'
+		if _, err := c.Write([]byte{0x81, 0x83, 0, 0, 0, 0, 'f', 'o', 'o'}); err != nil {
+			t.Fatal(err)
+		}
+		b := make([]byte, 5)
+		if _, err := io.ReadFull(br, b); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := b, []byte{0x81, 3, 'f', 'o', 'o'}; !bytes.Equal(got, want) {
+			t.Fatalf("got %v want %v", got, want)
+		}
+		return c, br
+	}
+
+	// closed checks that the proxy sends a close frame with
+	// the reason and closes the connection.
+	closed := func(t *testing.T, c net.Conn, br *bufio.Reader, reason string) {
+		t.Helper()
+		c.SetReadDeadline(time.Now().Add(5 * time.Second))
+		b, err := ioutil.ReadAll(br)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := b, wsCloseFrame(1001, reason, false); !bytes.Equal(got, want) {
+			t.Fatalf("got %v want %v", got, want)
+		}
+	}
+
+	t.Run("idle timeout", func(t *testing.T) {
+		c, br := dial(t)
+		defer c.Close()
+		closed(t, c, br, "idle timeout")
+	})
+
+	t.Run("shutdown", func(t *testing.T) {
+		c, br := dial(t)
+		defer c.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		closeWebSockets(ctx)
+		closed(t, c, br, "shutdown")
+	})
+}
@@ -0,0 +1,240 @@
+// Package redact removes the values of sensitive headers, query
+// parameters and cookies before requests are written to the access
+// log, the trace tags or the capture file.
+package redact
+
+import (
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/fabiolb/fabio/config"
+)
+
+// Value replaces the values of redacted fields.
+const Value = "[REDACTED]"
+
+// Default is the redactor for the access log, the tracing
+// and the capture file. It is set on startup.
+var Default *Redactor
+
+// Redactor redacts the values of the headers, query parameters and
+// cookies which match one of the configured patterns. Patterns are
+// matched case-insensitive and can contain '*' wildcards. A nil
+// Redactor does not redact anything.
+type Redactor struct {
+	headers []string
+	query   []string
+	cookies []string
+}
+
+// New returns a redactor for the given rules or nil if there are none.
+func New(cfg config.Redact) *Redactor {
+	r := &Redactor{
+		headers: patterns(cfg.Headers),
+		query:   patterns(cfg.Query),
+		cookies: patterns(cfg.Cookies),
+	}
+	if len(r.headers) == 0 && len(r.query) == 0 && len(r.cookies) == 0 {
+		return nil
+	}
+	return r
+}
+
+func patterns(names []string) []string {
+	var p []string
+	for _, n := range names {
+		if n = strings.ToLower(strings.TrimSpace(n)); n != "" {
+			p = append(p, n)
+		}
+	}
+	return p
+}
+
+func match(patterns []string, name string) bool {
+	name = strings.ToLower(name)
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Header returns a copy of h with the values of the matching headers
+// and cookies redacted. h is returned if nothing was redacted.
+func (r *Redactor) Header(h http.Header) http.Header {
+	c, _ := r.header(h)
+	return c
+}
+
+func (r *Redactor) header(h http.Header) (http.Header, bool) {
+	if r == nil || len(h) == 0 {
+		return h, false
+	}
+
+	var c http.Header
+	set := func(k string, vals []string) {
+		if c == nil {
+			c = h.Clone()
+		}
+		c[k] = vals
+	}
+
+	for k, vals := range h {
+		if match(r.headers, k) {
+			rv := make([]string, len(vals))
+			for i := range rv {
+				rv[i] = Value
+			}
+			set(k, rv)
+			continue
+		}
+		if len(r.cookies) == 0 {
+			continue
+		}
+		var rv []string
+		switch http.CanonicalHeaderKey(k) {
+		case "Cookie":
+			rv = mapValues(vals, r.Cookie)
+		case "Set-Cookie":
+			rv = mapValues(vals, r.SetCookie)
+		}
+		if rv != nil {
+			set(k, rv)
+		}
+	}
+	if c == nil {
+		return h, false
+	}
+	return c, true
+}
+
+// mapValues returns the values converted with fn or nil
+// if none of the values has changed.
+func mapValues(vals []string, fn func(string) string) []string {
+	changed := false
+	rv := make([]string, len(vals))
+	for i, v := range vals {
+		rv[i] = fn(v)
+		changed = changed || rv[i] != v
+	}
+	if !changed {
+		return nil
+	}
+	return rv
+}
+
+// Cookie redacts the values of the matching cookies
+// in the value of a Cookie header.
+func (r *Redactor) Cookie(v string) string {
+	if r == nil || len(r.cookies) == 0 {
+		return v
+	}
+	p := strings.Split(v, ";")
+	changed := false
+	for i, c := range p {
+		kv := strings.SplitN(strings.TrimSpace(c), "=", 2)
+		if len(kv) == 2 && match(r.cookies, kv[0]) {
+			p[i] = kv[0] + "=" + Value
+			if i > 0 {
+				p[i] = " " + p[i]
+			}
+			changed = true
+		}
+	}
+	if !changed {
+		return v
+	}
+	return strings.Join(p, ";")
+}
+
+// SetCookie redacts the value of a Set-Cookie header
+// if the cookie matches.
+func (r *Redactor) SetCookie(v string) string {
+	if r == nil || len(r.cookies) == 0 {
+		return v
+	}
+	p := strings.SplitN(v, ";", 2)
+	kv := strings.SplitN(strings.TrimSpace(p[0]), "=", 2)
+	if len(kv) != 2 || !match(r.cookies, kv[0]) {
+		return v
+	}
+	p[0] = kv[0] + "=" + Value
+	return strings.Join(p, ";")
+}
+
+// Query returns the raw query with the values of the matching
+// parameters redacted. The order of the parameters is preserved.
+func (r *Redactor) Query(raw string) string {
+	if r == nil || len(r.query) == 0 || raw == "" {
+		return raw
+	}
+	p := strings.Split(raw, "&")
+	changed := false
+	for i, kv := range p {
+		k := kv
+		if n := strings.IndexByte(kv, '='); n >= 0 {
+			k = kv[:n]
+		}
+		name, err := url.QueryUnescape(k)
+		if err != nil {
+			name = k
+		}
+		if match(r.query, name) {
+			p[i] = k + "=" + Value
+			changed = true
+		}
+	}
+	if !changed {
+		return raw
+	}
+	return strings.Join(p, "&")
+}
+
+// URL returns a copy of u with the matching query parameters
+// redacted. u is returned if nothing was redacted.
+func (r *Redactor) URL(u *url.URL) *url.URL {
+	if r == nil || u == nil {
+		return u
+	}
+	q := r.Query(u.RawQuery)
+	if q == u.RawQuery {
+		return u
+	}
+	c := *u
+	c.RawQuery = q
+	return &c
+}
+
+// RequestURI returns the request URI with the matching
+// query parameters redacted.
+func (r *Redactor) RequestURI(uri string) string {
+	if r == nil {
+		return uri
+	}
+	n := strings.IndexByte(uri, '?')
+	if n < 0 {
+		return uri
+	}
+	return uri[:n+1] + r.Query(uri[n+1:])
+}
+
+// Request returns a shallow copy of req with the matching headers,
+// cookies and query parameters redacted. req is returned if nothing
+// was redacted.
+func (r *Redactor) Request(req *http.Request) *http.Request {
+	if r == nil || req == nil {
+		return req
+	}
+	h, changed := r.header(req.Header)
+	u := r.URL(req.URL)
+	uri := r.RequestURI(req.RequestURI)
+	if !changed && u == req.URL && uri == req.RequestURI {
+		return req
+	}
+	c := *req
+	c.Header, c.URL, c.RequestURI = h, u, uri
+	return &c
+}
@@ -0,0 +1,128 @@
+package redact
+
+import (
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/fabiolb/fabio/config"
+)
+
+func TestNew(t *testing.T) {
+	if r := New(config.Redact{Headers: []string{" ", ""}}); r != nil {
+		t.Fatalf("got %v want nil", r)
+	}
+	if r := New(config.Redact{Query: []string{"token"}}); r == nil {
+		t.Fatal("got nil want redactor")
+	}
+}
+
+func TestHeader(t *testing.T) {
+	r := New(config.Redact{
+		Headers: []string{"Authorization", "X-*-Token"},
+		Cookies: []string{"session*"},
+	})
+
+	h := http.Header{
+		"Authorization":  {"Bearer secret"},
+		"X-Api-Token":    {"a", "b"},
+		"X-Foo":          {"bar"},
+		"Cookie":         {"sessionid=abc; theme=dark"},
+		"Set-Cookie":     {"SessionID=abc; Path=/; HttpOnly", "theme=dark"},
+		"X-Token-Suffix": {"keep"},
+	}
+	orig := h.Clone()
+
+	got := r.Header(h)
+	want := http.Header{
+		"Authorization":  {Value},
+		"X-Api-Token":    {Value, Value},
+		"X-Foo":          {"bar"},
+		"Cookie":         {"sessionid=" + Value + "; theme=dark"},
+		"Set-Cookie":     {"SessionID=" + Value + "; Path=/; HttpOnly", "theme=dark"},
+		"X-Token-Suffix": {"keep"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+	if !reflect.DeepEqual(h, orig) {
+		t.Fatalf("header modified: got %v want %v", h, orig)
+	}
+}
+
+func TestHeaderUnchanged(t *testing.T) {
+	r := New(config.Redact{Headers: []string{"Authorization"}})
+	h := http.Header{"X-Foo": {"bar"}}
+	if _, changed := r.header(h); changed {
+		t.Fatal("header copied without redacted values")
+	}
+}
+
+func TestQuery(t *testing.T) {
+	r := New(config.Redact{Query: []string{"token", "api_*"}})
+
+	tests := []struct {
+		in, out string
+	}{
+		{"", ""},
+		{"a=b", "a=b"},
+		{"token=abc", "token=" + Value},
+		{"a=1&TOKEN=abc&api_key=x&b=2", "a=1&TOKEN=" + Value + "&api_key=" + Value + "&b=2"},
+		{"token", "token=" + Value},
+		{"api%5Fkey=x", "api%5Fkey=" + Value},
+	}
+	for _, tt := range tests {
+		if got, want := r.Query(tt.in), tt.out; got != want {
+			t.Errorf("Query(%q): got %q want %q", tt.in, got, want)
+		}
+	}
+}
+
+func TestRequest(t *testing.T) {
+	r := New(config.Redact{Headers: []string{"Authorization"}, Query: []string{"token"}})
+
+	req, _ := http.NewRequest("GET", "http://example.com/foo?token=abc&a=b", nil)
+	req.RequestURI = "/foo?token=abc&a=b"
+	req.Header.Set("Authorization", "secret")
+
+	got := r.Request(req)
+	if got == req {
+		t.Fatal("request not copied")
+	}
+	if got, want := got.URL.String(), "http://example.com/foo?token="+Value+"&a=b"; got != want {
+		t.Errorf("URL: got %q want %q", got, want)
+	}
+	if got, want := got.RequestURI, "/foo?token="+Value+"&a=b"; got != want {
+		t.Errorf("RequestURI: got %q want %q", got, want)
+	}
+	if got, want := got.Header.Get("Authorization"), Value; got != want {
+		t.Errorf("Authorization: got %q want %q", got, want)
+	}
+	if got, want := req.URL.RawQuery, "token=abc&a=b"; got != want {
+		t.Errorf("original query modified: got %q want %q", got, want)
+	}
+	if got, want := req.Header.Get("Authorization"), "secret"; got != want {
+		t.Errorf("original header modified: got %q want %q", got, want)
+	}
+
+	plain, _ := http.NewRequest("GET", "http://example.com/foo?a=b", nil)
+	if r.Request(plain) != plain {
+		t.Error("request copied without redacted values")
+	}
+}
+
+func TestNilRedactor(t *testing.T) {
+	var r *Redactor
+	u, _ := url.Parse("http://example.com/?token=abc")
+	if r.URL(u) != u {
+		t.Error("URL copied")
+	}
+	if got, want := r.Query("token=abc"), "token=abc"; got != want {
+		t.Errorf("got %q want %q", got, want)
+	}
+	h := http.Header{"Authorization": {"secret"}}
+	if got := r.Header(h); !reflect.DeepEqual(got, h) {
+		t.Errorf("got %v want %v", got, h)
+	}
+}
@@ -0,0 +1,236 @@
+// Package alias tracks the service names which fabio registers for
+// routes with the "register" option and validates that the hosts of
+// these routes point to fabio via DNS.
+package alias
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fabiolb/fabio/route"
+)
+
+// State describes the current state of an alias.
+type State struct {
+	// Name is the service name registered in the registry.
+	Name string `json:"name"`
+
+	// Services and Hosts contain the upstream services and the
+	// hosts of the routes which reference the alias.
+	Services []string `json:"services"`
+	Hosts    []string `json:"hosts"`
+
+	// Registered is the time the alias was first seen.
+	Registered time.Time `json:"registered"`
+
+	// Validated is true when all hosts have a CNAME record
+	// which points to the alias.
+	Validated bool `json:"validated"`
+
+	// Checked is the time of the last DNS validation.
+	Checked time.Time `json:"checked,omitempty"`
+
+	// Error contains the reason why the validation failed.
+	Error string `json:"error,omitempty"`
+}
+
+// Manager keeps track of the registered aliases.
+type Manager struct {
+	// Domain is the DNS domain under which the registry publishes
+	// the services, e.g. "service.consul". A host is considered to
+	// be owned by fabio if it is a CNAME for <alias>.<domain>.
+	// If Domain is empty no validation is performed.
+	Domain string
+
+	// LookupCNAME resolves the canonical name of a host.
+	// Defaults to net.LookupCNAME.
+	LookupCNAME func(host string) (string, error)
+
+	mu      sync.Mutex
+	aliases map[string]*State
+
+	// run serializes the validation runs. running and pending are
+	// guarded by mu and coalesce the requests from Revalidate.
+	run     sync.Mutex
+	running bool
+	pending bool
+}
+
+// Default is the alias manager used by fabio.
+var Default = &Manager{}
+
+// Update replaces the set of aliases with the ones in defs and returns
+// the sorted list of service names which should be registered. Aliases
+// which are no longer referenced by any route are removed.
+func (m *Manager) Update(defs []route.AliasDef) []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	next := map[string]*State{}
+	for _, d := range defs {
+		s := next[d.Name]
+		if s == nil {
+			s = &State{Name: d.Name, Registered: time.Now()}
+			if old := m.aliases[d.Name]; old != nil {
+				s.Registered = old.Registered
+			}
+			next[d.Name] = s
+		}
+		s.Services = appendUnique(s.Services, d.Service)
+		if d.Host != "" {
+			s.Hosts = appendUnique(s.Hosts, d.Host)
+		}
+	}
+
+	for name := range m.aliases {
+		if next[name] == nil {
+			log.Printf("[INFO] alias: Removing %q", name)
+		}
+	}
+	for name := range next {
+		if m.aliases[name] == nil {
+			log.Printf("[INFO] alias: Adding %q", name)
+		}
+	}
+	m.aliases = next
+
+	names := make([]string, 0, len(next))
+	for name := range next {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Revalidate starts a validation run in the background. Runs never
+// overlap. Requests which arrive while a run is in progress are
+// coalesced into a single run which starts when the current one
+// has completed since only the latest set of aliases matters.
+func (m *Manager) Revalidate() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.running {
+		m.pending = true
+		return
+	}
+	m.running = true
+	go func() {
+		for {
+			m.Validate()
+			m.mu.Lock()
+			if !m.pending {
+				m.running = false
+				m.mu.Unlock()
+				return
+			}
+			m.pending = false
+			m.mu.Unlock()
+		}
+	}()
+}
+
+// Validate checks the DNS records of all hosts of all aliases.
+func (m *Manager) Validate() {
+	m.run.Lock()
+	defer m.run.Unlock()
+
+	m.mu.Lock()
+	var states []State
+	for _, s := range m.aliases {
+		states = append(states, *s)
+	}
+	m.mu.Unlock()
+
+	// do the DNS lookups without holding the lock
+	for i := range states {
+		states[i].Error = ""
+		if err := m.validate(&states[i]); err != nil {
+			states[i].Error = err.Error()
+			log.Printf("[WARN] alias: %s", err)
+		}
+		states[i].Validated = states[i].Error == ""
+		states[i].Checked = time.Now()
+	}
+
+	m.mu.Lock()
+	for _, s := range states {
+		if cur := m.aliases[s.Name]; cur != nil {
+			cur.Validated, cur.Checked, cur.Error = s.Validated, s.Checked, s.Error
+		}
+	}
+	m.mu.Unlock()
+}
+
+func (m *Manager) validate(s *State) error {
+	if m.Domain == "" {
+		return nil
+	}
+	if len(s.Hosts) == 0 {
+		return fmt.Errorf("%q has no routes with a host", s.Name)
+	}
+
+	lookup := m.LookupCNAME
+	if lookup == nil {
+		lookup = net.LookupCNAME
+	}
+
+	want := s.Name + "." + strings.Trim(m.Domain, ".")
+	for _, h := range s.Hosts {
+		cname, err := lookup(h)
+		if err != nil {
+			return fmt.Errorf("cannot resolve %q for %q. %s", h, s.Name, err)
+		}
+		if got := strings.TrimSuffix(cname, "."); !strings.EqualFold(got, want) {
+			return fmt.Errorf("%q is a CNAME for %q and not %q", h, got, want)
+		}
+	}
+	return nil
+}
+
+// Validated returns true if the host belongs to an alias whose DNS
+// records have been validated. It should be checked before requesting
+// certificates for the host.
+func (m *Manager) Validated(host string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, s := range m.aliases {
+		if !s.Validated {
+			continue
+		}
+		for _, h := range s.Hosts {
+			if strings.EqualFold(h, host) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// States returns the state of all aliases sorted by name.
+func (m *Manager) States() []State {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	states := make([]State, 0, len(m.aliases))
+	for _, s := range m.aliases {
+		c := *s
+		c.Services = append([]string(nil), s.Services...)
+		c.Hosts = append([]string(nil), s.Hosts...)
+		states = append(states, c)
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].Name < states[j].Name })
+	return states
+}
+
+func appendUnique(list []string, s string) []string {
+	for _, v := range list {
+		if v == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
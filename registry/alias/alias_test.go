@@ -0,0 +1,139 @@
+package alias
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fabiolb/fabio/route"
+)
+
+func TestManagerUpdate(t *testing.T) {
+	m := &Manager{}
+
+	names := m.Update([]route.AliasDef{
+		{Name: "b", Service: "svc-b", Host: "b.com"},
+		{Name: "a", Service: "svc-a", Host: "a.com"},
+		{Name: "a", Service: "svc-a2", Host: "www.a.com"},
+	})
+	if got, want := names, []string{"a", "b"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+	registered := m.States()[0].Registered
+
+	// b disappears, a keeps its registration time
+	names = m.Update([]route.AliasDef{{Name: "a", Service: "svc-a", Host: "a.com"}})
+	if got, want := names, []string{"a"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+	states := m.States()
+	if got, want := len(states), 1; got != want {
+		t.Fatalf("got %d aliases want %d", got, want)
+	}
+	if got, want := states[0].Registered, registered; !got.Equal(want) {
+		t.Fatalf("got registered %v want %v", got, want)
+	}
+}
+
+func TestManagerValidate(t *testing.T) {
+	m := &Manager{
+		Domain: "service.consul",
+		LookupCNAME: func(host string) (string, error) {
+			switch host {
+			case "a.com":
+				return "a.service.consul.", nil
+			case "b.com":
+				return "other.example.com.", nil
+			}
+			return "", errors.New("no such host")
+		},
+	}
+
+	m.Update([]route.AliasDef{
+		{Name: "a", Service: "svc-a", Host: "a.com"},
+		{Name: "b", Service: "svc-b", Host: "b.com"},
+		{Name: "c", Service: "svc-c", Host: "c.com"},
+		{Name: "d", Service: "svc-d"},
+	})
+	m.Validate()
+
+	valid := map[string]bool{}
+	for _, s := range m.States() {
+		valid[s.Name] = s.Validated
+		if !s.Validated && s.Error == "" {
+			t.Errorf("%s: got no error for invalid alias", s.Name)
+		}
+	}
+	if got, want := valid, map[string]bool{"a": true, "b": false, "c": false, "d": false}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+	if !m.Validated("A.com") {
+		t.Fatal("a.com should be validated")
+	}
+	if m.Validated("b.com") {
+		t.Fatal("b.com should not be validated")
+	}
+}
+
+func TestManagerRevalidate(t *testing.T) {
+	var (
+		mu              sync.Mutex
+		active, maxSeen int
+		runs            int
+	)
+	release := make(chan bool)
+	m := &Manager{
+		Domain: "service.consul",
+		LookupCNAME: func(host string) (string, error) {
+			mu.Lock()
+			active++
+			runs++
+			if active > maxSeen {
+				maxSeen = active
+			}
+			mu.Unlock()
+			<-release
+			mu.Lock()
+			active--
+			mu.Unlock()
+			return "a.service.consul.", nil
+		},
+	}
+	m.Update([]route.AliasDef{{Name: "a", Service: "svc-a", Host: "a.com"}})
+
+	// the first call starts a run and the others are coalesced
+	// into a single follow-up run.
+	for i := 0; i < 5; i++ {
+		m.Revalidate()
+	}
+	release <- true
+	release <- true
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		m.mu.Lock()
+		running := m.running
+		m.mu.Unlock()
+		if !running {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for validation")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if got, want := runs, 2; got != want {
+		t.Fatalf("got %d runs want %d", got, want)
+	}
+	if got, want := maxSeen, 1; got != want {
+		t.Fatalf("got %d concurrent runs want %d", got, want)
+	}
+	if !m.Validated("a.com") {
+		t.Fatal("a.com should be validated")
+	}
+}
@@ -1,5 +1,10 @@
 package registry
 
+import (
+	"errors"
+	"time"
+)
+
 type Backend interface {
 	// Register registers fabio as a service in the registry.
 	Register(services []string) error
@@ -23,16 +28,61 @@ type Backend interface {
 	WriteManual(path string, value string, version uint64) (ok bool, err error)
 
 	// WatchServices watches the registry for changes in service
-	// registration and health and pushes them if there is a difference.
-	WatchServices() chan string
+	// registration and health and publishes them if there is a difference.
+	WatchServices() *Watch
 
 	// WatchManual watches the registry for changes in the manual
-	// overrides and pushes them if there is a difference.
-	WatchManual() chan string
+	// overrides and publishes them if there is a difference.
+	WatchManual() *Watch
 
 	// WatchNoRouteHTML watches the registry for changes in the html returned
 	// when a requested route is not found
-	WatchNoRouteHTML() chan string
+	WatchNoRouteHTML() *Watch
 }
 
 var Default Backend
+
+// StatusReporter is implemented by backends which can report
+// details about their internal state, e.g. when the routing
+// table was last loaded.
+type StatusReporter interface {
+	Status() interface{}
+}
+
+// RolloutWatcher is implemented by backends which provide
+// definitions for gradual rollouts.
+type RolloutWatcher interface {
+	// WatchRollout watches the registry for changes in the rollout
+	// definitions. It returns nil if rollouts are not configured.
+	WatchRollout() *Watch
+}
+
+// FlagWatcher is implemented by backends which provide
+// feature flags.
+type FlagWatcher interface {
+	// WatchFlags watches the registry for changes in the feature
+	// flags. It returns nil if feature flags are not configured.
+	WatchFlags() *Watch
+}
+
+// ManualDeleter is implemented by backends which can delete
+// single manual overrides. The admin API stores each route
+// which is managed through it as a separate override.
+type ManualDeleter interface {
+	// DeleteManual deletes the override at path if the version
+	// of the stored document still matches version.
+	DeleteManual(path string, version uint64) (ok bool, err error)
+}
+
+// Poller is implemented by backends which poll the registry
+// for changes and can change the poll interval at runtime.
+type Poller interface {
+	// SetPollInterval sets the time between two requests to the
+	// registry. Zero disables polling if the backend supports
+	// watching the registry instead.
+	SetPollInterval(d time.Duration)
+}
+
+// ErrNotSupported is returned by wrapping backends if the
+// wrapped backend does not support an optional operation.
+var ErrNotSupported = errors.New("not supported by the registry backend")
@@ -3,6 +3,8 @@ package consul
 import (
 	"errors"
 	"log"
+	"sync"
+	"time"
 
 	"github.com/fabiolb/fabio/config"
 	"github.com/fabiolb/fabio/registry"
@@ -16,21 +18,36 @@ type be struct {
 	dc    string
 	cfg   *config.Consul
 	dereg map[string](chan bool)
+
+	// svc, kv and reg are the clients for the service watch,
+	// the KV watches and the self-registration which can
+	// use different ACL tokens.
+	svc *api.Client
+	kv  *api.Client
+	reg *api.Client
+
+	// snap builds the routes of the services and the
+	// manual overrides from the same state. It is guarded
+	// by mu.
+	mu   sync.Mutex
+	snap *snapshotBuilder
 }
 
 func NewBackend(cfg *config.Consul) (registry.Backend, error) {
-
-	consulCfg := &api.Config{Address: cfg.Addr, Scheme: cfg.Scheme, Token: cfg.Token}
-	if cfg.Scheme == "https" {
-		consulCfg.TLSConfig.KeyFile = cfg.TLS.KeyFile
-		consulCfg.TLSConfig.CertFile = cfg.TLS.CertFile
-		consulCfg.TLSConfig.CAFile = cfg.TLS.CAFile
-		consulCfg.TLSConfig.CAPath = cfg.TLS.CAPath
-		consulCfg.TLSConfig.InsecureSkipVerify = cfg.TLS.InsecureSkipVerify
+	// create reusable clients
+	c, err := newClient(cfg, "default", cfg.Token)
+	if err != nil {
+		return nil, err
 	}
-
-	// create a reusable client
-	c, err := api.NewClient(consulCfg)
+	svc, err := newClient(cfg, "service watch", cfg.TokenServices)
+	if err != nil {
+		return nil, err
+	}
+	kv, err := newClient(cfg, "KV watch", cfg.TokenKV)
+	if err != nil {
+		return nil, err
+	}
+	reg, err := newClient(cfg, "registration", cfg.TokenRegister)
 	if err != nil {
 		return nil, err
 	}
@@ -43,7 +60,7 @@ func NewBackend(cfg *config.Consul) (registry.Backend, error) {
 
 	// we're good
 	log.Printf("[INFO] consul: Connecting to %q in datacenter %q", cfg.Addr, dc)
-	return &be{c: c, dc: dc, cfg: cfg}, nil
+	return &be{c: c, dc: dc, cfg: cfg, svc: svc, kv: kv, reg: reg}, nil
 }
 
 func (b *be) Register(services []string) error {
@@ -78,7 +95,7 @@ func (b *be) Register(services []string) error {
 			return err
 		}
 
-		b.dereg[service] = register(b.c, serviceReg)
+		b.dereg[service] = register(b.reg, serviceReg)
 	}
 
 	return nil
@@ -110,52 +127,112 @@ func (b *be) DeregisterAll() error {
 }
 
 func (b *be) ManualPaths() ([]string, error) {
-	keys, _, err := listKeys(b.c, b.cfg.KVPath, 0)
+	keys, _, err := listKeys(b.kv, b.cfg.KVPath, 0)
 	return keys, err
 }
 
 func (b *be) ReadManual(path string) (value string, version uint64, err error) {
 	// we cannot rely on the value provided by WatchManual() since
 	// someone has to call that method first to kick off the go routine.
-	return getKV(b.c, b.cfg.KVPath+path, 0)
+	return getKV(b.kv, b.cfg.KVPath+path, 0)
 }
 
 func (b *be) WriteManual(path string, value string, version uint64) (ok bool, err error) {
 	// try to create the key first by using version 0
-	if ok, err = putKV(b.c, b.cfg.KVPath+path, value, 0); ok {
+	if ok, err = putKV(b.kv, b.cfg.KVPath+path, value, 0); ok {
 		return
 	}
 
 	// then try the CAS update
-	return putKV(b.c, b.cfg.KVPath+path, value, version)
+	return putKV(b.kv, b.cfg.KVPath+path, value, version)
+}
+
+func (b *be) DeleteManual(path string, version uint64) (ok bool, err error) {
+	return deleteKV(b.kv, b.cfg.KVPath+path, version)
 }
 
-func (b *be) WatchServices() chan string {
+func (b *be) WatchServices() *registry.Watch {
 	log.Printf("[INFO] consul: Using dynamic routes")
 	log.Printf("[INFO] consul: Using tag prefix %q", b.cfg.TagPrefix)
 
-	m := NewServiceMonitor(b.c, b.cfg, b.dc)
-	svc := make(chan string)
-	go m.Watch(svc)
-	return svc
+	s := b.snapshot()
+	go s.mon.Watch(s.setHealth)
+	return s.svc
 }
 
-func (b *be) WatchManual() chan string {
+func (b *be) WatchManual() *registry.Watch {
 	log.Printf("[INFO] consul: Watching KV path %q", b.cfg.KVPath)
 
-	kv := make(chan string)
-	go watchKV(b.c, b.cfg.KVPath, kv, true)
-	return kv
+	s := b.snapshot()
+	s.mu.Lock()
+	s.watchKV = true
+	s.mu.Unlock()
+	go watchKV(b.kv, b.cfg.KVPath, s.setManual, true)
+	return s.man
 }
 
-func (b *be) WatchNoRouteHTML() chan string {
+// snapshot returns the builder for the routes of the
+// services and the manual overrides.
+func (b *be) snapshot() *snapshotBuilder {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.snap == nil {
+		b.snap = newSnapshotBuilder(NewServiceMonitor(b.svc, b.cfg, b.dc))
+	}
+	return b.snap
+}
+
+// Status returns the indexes of the last snapshot.
+func (b *be) Status() interface{} {
+	b.mu.Lock()
+	s := b.snap
+	b.mu.Unlock()
+	if s == nil {
+		return Status{}
+	}
+	return s.Status()
+}
+
+// SetPollInterval sets the poll interval of the service watch.
+func (b *be) SetPollInterval(d time.Duration) {
+	b.snapshot().mon.SetPollInterval(d)
+}
+
+func (b *be) WatchNoRouteHTML() *registry.Watch {
 	log.Printf("[INFO] consul: Watching KV path %q", b.cfg.NoRouteHTMLPath)
 
-	html := make(chan string)
-	go watchKV(b.c, b.cfg.NoRouteHTMLPath, html, false)
+	html := registry.NewWatch()
+	go watchKV(b.kv, b.cfg.NoRouteHTMLPath, publishTo(html), false)
 	return html
 }
 
+func (b *be) WatchRollout() *registry.Watch {
+	if b.cfg.RolloutPath == "" {
+		return nil
+	}
+	log.Printf("[INFO] consul: Watching KV path %q", b.cfg.RolloutPath)
+
+	defs := registry.NewWatch()
+	go watchKV(b.kv, b.cfg.RolloutPath, publishTo(defs), false)
+	return defs
+}
+
+func (b *be) WatchFlags() *registry.Watch {
+	if b.cfg.FlagsPath == "" {
+		return nil
+	}
+	log.Printf("[INFO] consul: Watching KV path %q", b.cfg.FlagsPath)
+
+	flags := registry.NewWatch()
+	go watchKV(b.kv, b.cfg.FlagsPath, publishTo(flags), false)
+	return flags
+}
+
+// publishTo returns a function which publishes the values of a KV watch.
+func publishTo(w *registry.Watch) func(string, uint64) {
+	return func(v string, index uint64) { w.PublishIndex(v, index) }
+}
+
 // datacenter returns the datacenter of the local agent
 func datacenter(c *api.Client) (string, error) {
 	self, err := c.Agent().Self()
@@ -0,0 +1,52 @@
+package consul
+
+import (
+	"os"
+	"time"
+
+	"github.com/fabiolb/fabio/config"
+	"github.com/hashicorp/consul/api"
+)
+
+// HALock is the lock of the active-standby mode in consul.
+type HALock struct {
+	lock *api.Lock
+}
+
+// NewHALock creates the consul lock for the active-standby mode. The
+// session of the lock is invalidated when the instance does not renew
+// it within the TTL so that the standby can take over. The value of
+// the key is the hostname of the active instance.
+func NewHALock(cfg *config.Consul, key string, ttl time.Duration) (*HALock, error) {
+	client, err := newClient(cfg, "ha", "")
+	if err != nil {
+		return nil, err
+	}
+	hostname, _ := os.Hostname()
+	l, err := client.LockOpts(&api.LockOptions{
+		Key:          key,
+		Value:        []byte(hostname),
+		SessionName:  "fabio-ha",
+		SessionTTL:   ttl.String(),
+		LockWaitTime: ttl,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &HALock{lock: l}, nil
+}
+
+// Lock blocks until the lock is acquired or stop is closed. The
+// returned channel is closed when the lock is lost.
+func (l *HALock) Lock(stop <-chan struct{}) (<-chan struct{}, error) {
+	return l.lock.Lock(stop)
+}
+
+// Unlock releases the lock. A lock which has already
+// been lost is not an error.
+func (l *HALock) Unlock() error {
+	if err := l.lock.Unlock(); err != nil && err != api.ErrLockNotHeld {
+		return err
+	}
+	return nil
+}
@@ -8,9 +8,10 @@ import (
 	"github.com/hashicorp/consul/api"
 )
 
-// watchKV monitors a key in the KV store for changes.
-// The intended use case is to add additional route commands to the routing table.
-func watchKV(client *api.Client, path string, config chan string, separator bool) {
+// watchKV monitors a key in the KV store for changes and calls publish
+// with the value and its index. The intended use case is to add
+// additional route commands to the routing table.
+func watchKV(client *api.Client, path string, publish func(value string, index uint64), separator bool) {
 	var lastIndex uint64
 	var lastValue string
 
@@ -24,7 +25,7 @@ func watchKV(client *api.Client, path string, config chan string, separator bool
 
 		if value != lastValue || index != lastIndex {
 			log.Printf("[DEBUG] consul: Manual config changed to #%d", index)
-			config <- value
+			publish(value, index)
 			lastValue, lastIndex = value, index
 		}
 	}
@@ -86,3 +87,9 @@ func putKV(client *api.Client, key, value string, index uint64) (bool, error) {
 	}
 	return ok, nil
 }
+
+func deleteKV(client *api.Client, key string, index uint64) (bool, error) {
+	p := &api.KVPair{Key: key[1:], ModifyIndex: index}
+	ok, _, err := client.KV().DeleteCAS(p, nil)
+	return ok, err
+}
@@ -3,11 +3,11 @@ package consul
 import (
 	"fmt"
 	"log"
-	"sort"
-	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/fabiolb/fabio/config"
+	"github.com/fabiolb/fabio/registry"
 	"github.com/hashicorp/consul/api"
 )
 
@@ -17,6 +17,9 @@ type ServiceMonitor struct {
 	config *config.Consul
 	dc     string
 	strict bool
+
+	// poll is the poll interval which can be changed at runtime.
+	poll int64 // time.Duration, atomic
 }
 
 func NewServiceMonitor(client *api.Client, config *config.Consul, dc string) *ServiceMonitor {
@@ -25,43 +28,62 @@ func NewServiceMonitor(client *api.Client, config *config.Consul, dc string) *Se
 		config: config,
 		dc:     dc,
 		strict: config.ChecksRequired == "all",
+		poll:   int64(config.PollInterval),
 	}
 }
 
-// Watch monitors the consul health checks and sends a new
-// configuration to the updates channel on every change.
-func (w *ServiceMonitor) Watch(updates chan string) {
+// SetPollInterval sets the time between two requests for the health
+// state. Zero uses blocking queries which return on every change. The
+// new interval applies after the current request has returned.
+func (w *ServiceMonitor) SetPollInterval(d time.Duration) {
+	atomic.StoreInt64(&w.poll, int64(d))
+}
+
+func (w *ServiceMonitor) pollInterval() time.Duration {
+	return time.Duration(atomic.LoadInt64(&w.poll))
+}
+
+// Watch monitors the consul health checks and calls changed with
+// the health state and its index on every change.
+func (w *ServiceMonitor) Watch(changed func(checks []*api.HealthCheck, index uint64)) {
 	var lastIndex uint64
-	var q *api.QueryOptions
 	for {
-		if w.config.PollInterval != 0 {
-			q = &api.QueryOptions{RequireConsistent: true}
-			time.Sleep(w.config.PollInterval)
-		} else {
-			q = &api.QueryOptions{RequireConsistent: true, WaitIndex: lastIndex}
+		if d := w.pollInterval(); d != 0 {
+			time.Sleep(d)
 		}
-		checks, meta, err := w.client.Health().State("any", q)
+		checks, index, err := w.health(lastIndex)
 		if err != nil {
 			log.Printf("[WARN] consul: Error fetching health state. %v", err)
 			time.Sleep(time.Second)
 			continue
 		}
-		log.Printf("[DEBUG] consul: Health changed to #%d", meta.LastIndex)
-
-		// determine which services have passing health checks
-		passing := passingServices(checks, w.config.ServiceStatus, w.strict)
-
-		// build the config for the passing services
-		updates <- w.makeConfig(passing)
+		log.Printf("[DEBUG] consul: Health changed to #%d", index)
+		changed(checks, index)
 
 		// remember the last state and wait for the next change
-		lastIndex = meta.LastIndex
+		lastIndex = index
+	}
+}
+
+// health returns the state of all health checks and its index. If
+// waitIndex is not zero and polling is disabled it blocks until
+// the state has changed.
+func (w *ServiceMonitor) health(waitIndex uint64) ([]*api.HealthCheck, uint64, error) {
+	q := &api.QueryOptions{RequireConsistent: true}
+	if w.pollInterval() == 0 {
+		q.WaitIndex = waitIndex
+	}
+	checks, meta, err := w.client.Health().State("any", q)
+	if err != nil {
+		return nil, 0, err
 	}
+	return checks, meta.LastIndex, nil
 }
 
 // makeConfig determines which service instances have passing health checks
 // and then finds the ones which have tags with the right prefix to build the config from.
-func (w *ServiceMonitor) makeConfig(checks []*api.HealthCheck) string {
+// It returns the config and the highest index of the catalog entries it was built from.
+func (w *ServiceMonitor) makeConfig(checks []*api.HealthCheck) (string, uint64) {
 	// map service name to list of service passing for which the health check is ok
 	m := map[string]map[string]bool{}
 	for _, check := range checks {
@@ -82,39 +104,46 @@ func (w *ServiceMonitor) makeConfig(checks []*api.HealthCheck) string {
 	}
 
 	sem := make(chan int, n)
-	cfgs := make(chan []string, len(m))
+	type result struct {
+		config []string
+		index  uint64
+	}
+	results := make(chan result, len(m))
 	for name, passing := range m {
 		name, passing := name, passing
 		go func() {
 			sem <- 1
-			cfgs <- w.serviceConfig(name, passing)
+			cfg, index := w.serviceConfig(name, passing)
+			results <- result{cfg, index}
 			<-sem
 		}()
 	}
 
 	var config []string
+	var index uint64
 	for i := 0; i < len(m); i++ {
-		cfg := <-cfgs
-		config = append(config, cfg...)
+		r := <-results
+		config = append(config, r.config...)
+		if r.index > index {
+			index = r.index
+		}
 	}
 
-	// sort config in reverse order to sort most specific config to the top
-	sort.Sort(sort.Reverse(sort.StringSlice(config)))
-
-	return strings.Join(config, "\n")
+	return registry.SortRoutes(config), index
 }
 
-// serviceConfig constructs the config for all good instances of a single service.
-func (w *ServiceMonitor) serviceConfig(name string, passing map[string]bool) (config []string) {
+// serviceConfig constructs the config for all good instances of a single
+// service and returns the index of the catalog entries.
+func (w *ServiceMonitor) serviceConfig(name string, passing map[string]bool) (config []string, index uint64) {
 	if name == "" || len(passing) == 0 {
-		return nil
+		return nil, 0
 	}
 
 	q := &api.QueryOptions{RequireConsistent: true}
-	svcs, _, err := w.client.Catalog().Service(name, "", q)
+	svcs, meta, err := w.client.Catalog().Service(name, "", q)
 	if err != nil {
 		log.Printf("[WARN] consul: Error getting catalog service %s. %v", name, err)
-		return nil
+		return nil, 0
 	}
 
 	env := map[string]string{
@@ -136,5 +165,5 @@ func (w *ServiceMonitor) serviceConfig(name string, passing map[string]bool) (co
 
 		config = append(config, cmds...)
 	}
-	return config
+	return config, meta.LastIndex
 }
@@ -0,0 +1,179 @@
+package consul
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/fabiolb/fabio/registry"
+	"github.com/hashicorp/consul/api"
+)
+
+// maxSnapshotRetries is the number of times a snapshot is built again
+// because the state changed while it was read before it is published
+// anyway so that a constantly changing cluster still gets updates.
+const maxSnapshotRetries = 3
+
+// Status describes the state of the consul backend.
+type Status struct {
+	// Index is the index of the last published snapshot. The routes
+	// of the services and the manual overrides are published with
+	// the same index.
+	Index uint64 `json:"index"`
+
+	// HealthIndex, CatalogIndex and KVIndex are the indexes of the
+	// health state, the catalog entries and the manual overrides the
+	// last snapshot was built from.
+	HealthIndex  uint64 `json:"healthIndex"`
+	CatalogIndex uint64 `json:"catalogIndex"`
+	KVIndex      uint64 `json:"kvIndex"`
+
+	// LastUpdate is the time the last snapshot was published.
+	LastUpdate time.Time `json:"lastUpdate"`
+
+	// Retries counts the snapshots which were built again because
+	// the state changed while they were read.
+	Retries uint64 `json:"retries"`
+}
+
+// snapshotBuilder publishes the routes of the services and the manual
+// overrides which were read from the same state of the cluster. The
+// health and KV watches store their latest state and notify the
+// builder which builds the routes from the catalog. A snapshot is
+// built again if a catalog entry is newer than the health state or
+// if one of the watches has received a newer state in the meantime.
+type snapshotBuilder struct {
+	mon      *ServiceMonitor
+	svc, man *registry.Watch
+	changed  chan struct{}
+
+	// mu guards the fields below.
+	mu         sync.Mutex
+	checks     []*api.HealthCheck
+	health     uint64
+	haveHealth bool
+	manual     string
+	kv         uint64
+	watchKV    bool
+	status     Status
+}
+
+func newSnapshotBuilder(mon *ServiceMonitor) *snapshotBuilder {
+	s := &snapshotBuilder{
+		mon:     mon,
+		svc:     registry.NewWatch(),
+		man:     registry.NewWatch(),
+		changed: make(chan struct{}, 1),
+	}
+	go s.run()
+	return s
+}
+
+func (s *snapshotBuilder) notify() {
+	select {
+	case s.changed <- struct{}{}:
+	default:
+	}
+}
+
+// setHealth stores the latest health state.
+func (s *snapshotBuilder) setHealth(checks []*api.HealthCheck, index uint64) {
+	s.mu.Lock()
+	if !s.haveHealth || index >= s.health {
+		s.checks, s.health, s.haveHealth = checks, index, true
+	}
+	s.mu.Unlock()
+	s.notify()
+}
+
+// setManual stores the latest manual overrides.
+func (s *snapshotBuilder) setManual(value string, index uint64) {
+	s.mu.Lock()
+	s.manual, s.kv = value, index
+	s.mu.Unlock()
+	s.notify()
+}
+
+// Status returns the state of the last snapshot.
+func (s *snapshotBuilder) Status() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status
+}
+
+func (s *snapshotBuilder) run() {
+	retries := 0
+	for range s.changed {
+		s.mu.Lock()
+		checks, health, haveHealth := s.checks, s.health, s.haveHealth
+		manual, kv, watchKV := s.manual, s.kv, s.watchKV
+		s.mu.Unlock()
+
+		var svccfg string
+		var catalog uint64
+		if haveHealth {
+			passing := passingServices(checks, s.mon.config.ServiceStatus, s.mon.strict)
+			svccfg, catalog = s.mon.makeConfig(passing)
+		}
+
+		if retries < maxSnapshotRetries {
+			// a catalog entry which is newer than the health state
+			// was changed after the health state was read.
+			if catalog > health {
+				log.Printf("[DEBUG] consul: Catalog #%d is newer than health #%d. Reading the health state again", catalog, health)
+				retries++
+				s.countRetry()
+				if checks, index, err := s.mon.health(0); err != nil {
+					log.Printf("[WARN] consul: Error fetching health state. %v", err)
+				} else {
+					s.setHealth(checks, index)
+				}
+				s.notify()
+				continue
+			}
+
+			// one of the watches has received a newer state
+			s.mu.Lock()
+			newer := s.health != health || s.kv != kv
+			s.mu.Unlock()
+			if newer {
+				retries++
+				s.countRetry()
+				continue
+			}
+		} else {
+			log.Printf("[WARN] consul: State changed during the last %d snapshots. Publishing the current one", retries)
+		}
+		retries = 0
+
+		index := health
+		for _, i := range []uint64{catalog, kv} {
+			if i > index {
+				index = i
+			}
+		}
+
+		// publish the manual overrides first so that both values
+		// have the same index when the reader sees the services.
+		if watchKV {
+			s.man.PublishIndex(manual, index)
+		}
+		if haveHealth {
+			s.svc.PublishIndex(svccfg, index)
+		}
+
+		s.mu.Lock()
+		s.status.Index = index
+		s.status.HealthIndex = health
+		s.status.CatalogIndex = catalog
+		s.status.KVIndex = kv
+		s.status.LastUpdate = time.Now().UTC()
+		s.mu.Unlock()
+	}
+}
+
+func (s *snapshotBuilder) countRetry() {
+	s.mu.Lock()
+	s.status.Retries++
+	s.mu.Unlock()
+}
@@ -0,0 +1,80 @@
+package consul
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fabiolb/fabio/config"
+	"github.com/hashicorp/consul/api"
+)
+
+func TestSnapshotBuilder(t *testing.T) {
+	// the catalog entry of the service changes after the first
+	// read of the health state which has index 10.
+	var mu sync.Mutex
+	healthIndex, catalogIndex := 10, 12
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		var v interface{}
+		switch r.URL.Path {
+		case "/v1/health/state/any":
+			healthIndex = catalogIndex
+			w.Header().Set("X-Consul-Index", strconv.Itoa(healthIndex))
+			v = []*api.HealthCheck{{Node: "n1", CheckID: "c1", ServiceID: "web-1", ServiceName: "web", Status: "passing"}}
+		case "/v1/catalog/service/web":
+			w.Header().Set("X-Consul-Index", strconv.Itoa(catalogIndex))
+			v = []*api.CatalogService{{Node: "n1", ServiceID: "web-1", ServiceName: "web", ServiceAddress: "1.2.3.4", ServicePort: 80, ServiceTags: []string{"urlprefix-/web"}}}
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(v)
+	}))
+	defer srv.Close()
+
+	client, err := api.NewClient(&api.Config{Address: srv.URL[len("http://"):]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Consul{TagPrefix: "urlprefix-", ServiceStatus: []string{"passing"}, ServiceMonitors: 1}
+	s := newSnapshotBuilder(NewServiceMonitor(client, cfg, "dc1"))
+	s.mu.Lock()
+	s.watchKV = true
+	s.mu.Unlock()
+
+	s.setManual("route weight web /web weight 0.5", 11)
+	s.setHealth([]*api.HealthCheck{{Node: "n1", CheckID: "c1", ServiceID: "web-1", ServiceName: "web", Status: "passing"}}, 10)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for s.svc.Latest().Seq == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	svc, man := s.svc.Latest(), s.man.Latest()
+	if got, want := svc.Value, "route add web /web http://1.2.3.4:80/"; got != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+	if got, want := man.Value, "route weight web /web weight 0.5"; got != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+	if svc.Index != 12 || man.Index != 12 {
+		t.Fatalf("got indexes %d and %d want 12", svc.Index, man.Index)
+	}
+
+	st := s.Status()
+	if st.Index != 12 || st.HealthIndex != 12 || st.CatalogIndex != 12 || st.KVIndex != 11 {
+		t.Fatalf("got status %+v", st)
+	}
+	if st.Retries == 0 {
+		t.Fatal("snapshot was not built again")
+	}
+}
@@ -0,0 +1,126 @@
+package consul
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fabiolb/fabio/cert"
+	"github.com/fabiolb/fabio/config"
+	"github.com/hashicorp/consul/api"
+)
+
+// tokenSource provides the ACL token for a consul client. Tokens which
+// are read from a file, an environment variable or Vault are re-read
+// after the refresh interval so that they can be rotated without
+// restarting the watches.
+type tokenSource struct {
+	name    string
+	read    func() ([]byte, error)
+	refresh time.Duration
+
+	mu      sync.Mutex
+	token   string
+	fetched time.Time
+}
+
+// newTokenSource creates a token source for the given token spec which
+// is either a literal token or one of 'env:NAME', 'file:/path' or
+// 'vault:secret/path#field'.
+func newTokenSource(name, spec string, refresh time.Duration) (*tokenSource, error) {
+	t := &tokenSource{name: name, refresh: refresh}
+	switch {
+	case strings.HasPrefix(spec, "env:"), strings.HasPrefix(spec, "file:"), strings.HasPrefix(spec, "vault:"):
+		read, err := cert.NewPassphraseProvider(spec, cert.NewVaultClient(""))
+		if err != nil {
+			return nil, err
+		}
+		t.read = read
+		if _, err := t.fetch(); err != nil {
+			return nil, err
+		}
+	default:
+		t.token = spec
+	}
+	return t, nil
+}
+
+// Token returns the current token.
+func (t *tokenSource) Token() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.read == nil || time.Since(t.fetched) < t.refresh {
+		return t.token
+	}
+	if _, err := t.fetchLocked(); err != nil {
+		// keep using the last token until the source is fixed
+		log.Printf("[WARN] consul: Cannot refresh %s token. %s", t.name, err)
+		t.fetched = time.Now()
+	}
+	return t.token
+}
+
+func (t *tokenSource) fetch() (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.fetchLocked()
+}
+
+func (t *tokenSource) fetchLocked() (string, error) {
+	b, err := t.read()
+	if err != nil {
+		return "", err
+	}
+	token := strings.TrimSpace(string(b))
+	if t.token != "" && token != t.token {
+		log.Printf("[INFO] consul: Rotated %s token", t.name)
+	}
+	t.token, t.fetched = token, time.Now()
+	return token, nil
+}
+
+// tokenTransport sets the consul ACL token on every request.
+type tokenTransport struct {
+	tokens *tokenSource
+	rt     http.RoundTripper
+}
+
+func (t *tokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if token := t.tokens.Token(); token != "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("X-Consul-Token", token)
+	}
+	return t.rt.RoundTrip(req)
+}
+
+// newClient creates a consul client which uses the token from the
+// given spec. An empty spec uses the default token.
+func newClient(cfg *config.Consul, name, spec string) (*api.Client, error) {
+	if spec == "" {
+		spec = cfg.Token
+	}
+	tokens, err := newTokenSource(name, spec, cfg.TokenRefresh)
+	if err != nil {
+		return nil, err
+	}
+
+	consulCfg := &api.Config{Address: cfg.Addr, Scheme: cfg.Scheme}
+	if cfg.Scheme == "https" {
+		consulCfg.TLSConfig.KeyFile = cfg.TLS.KeyFile
+		consulCfg.TLSConfig.CertFile = cfg.TLS.CertFile
+		consulCfg.TLSConfig.CAFile = cfg.TLS.CAFile
+		consulCfg.TLSConfig.CAPath = cfg.TLS.CAPath
+		consulCfg.TLSConfig.InsecureSkipVerify = cfg.TLS.InsecureSkipVerify
+	}
+
+	httpClient, err := api.NewHttpClient(api.DefaultConfig().Transport, consulCfg.TLSConfig)
+	if err != nil {
+		return nil, err
+	}
+	httpClient.Transport = &tokenTransport{tokens: tokens, rt: httpClient.Transport}
+	consulCfg.HttpClient = httpClient
+
+	return api.NewClient(consulCfg)
+}
@@ -0,0 +1,69 @@
+package consul
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fabiolb/fabio/config"
+)
+
+func TestTokenRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fabio-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tokenFile := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(tokenFile, []byte("one\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.Header.Get("X-Consul-Token"))
+		w.Write([]byte("[]"))
+	}))
+	defer srv.Close()
+
+	cfg := &config.Consul{Addr: srv.Listener.Addr().String(), Scheme: "http", Token: "default", TokenRefresh: time.Millisecond}
+	c, err := newClient(cfg, "test", "file:"+tokenFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := listKeys(c, "fabio", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(tokenFile, []byte("two\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if _, _, err := listKeys(c, "fabio", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// an empty spec uses the default token
+	d, err := newClient(cfg, "test", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := listKeys(d, "fabio", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"one", "two", "default"}
+	if len(got) != len(want) {
+		t.Fatalf("got tokens %v want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got tokens %v want %v", got, want)
+		}
+	}
+}
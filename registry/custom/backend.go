@@ -38,20 +38,20 @@ func (b *be) WriteManual(path string, value string, version uint64) (ok bool, er
 	return false, nil
 }
 
-func (b *be) WatchServices() chan string {
+func (b *be) WatchServices() *registry.Watch {
 
 	log.Printf("[INFO] custom: Using custom routes from %s", b.cfg.Host)
-	ch := make(chan string, 1)
-	go customRoutes(b.cfg, ch)
-	return ch
+	w := registry.NewWatch()
+	go customRoutes(b.cfg, w)
+	return w
 }
 
-func (b *be) WatchManual() chan string {
-	return make(chan string)
+func (b *be) WatchManual() *registry.Watch {
+	return registry.NewWatch()
 }
 
-func (b *be) WatchNoRouteHTML() chan string {
-	ch := make(chan string, 1)
-	ch <- b.cfg.NoRouteHTML
-	return ch
+func (b *be) WatchNoRouteHTML() *registry.Watch {
+	w := registry.NewWatch()
+	w.Publish(b.cfg.NoRouteHTML)
+	return w
 }
@@ -5,13 +5,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/fabiolb/fabio/config"
+	"github.com/fabiolb/fabio/registry"
 	"github.com/fabiolb/fabio/route"
 	"log"
 	"net/http"
 	"time"
 )
 
-func customRoutes(cfg *config.Custom, ch chan string) {
+func customRoutes(cfg *config.Custom, w *registry.Watch) {
 
 	var Routes *[]route.RouteDef
 	var trans *http.Transport
@@ -47,13 +48,13 @@ func customRoutes(cfg *config.Custom, ch chan string) {
 		log.Printf("[DEBUG] Custom Registry starting request %s \n", time.Now())
 		resp, err := client.Do(req)
 		if err != nil {
-			ch <- fmt.Sprintf("Error Sending HTTPs Request To Custom be - %s -%s", URL, err.Error())
+			w.Publish(fmt.Sprintf("Error Sending HTTPs Request To Custom be - %s -%s", URL, err.Error()))
 			time.Sleep(cfg.PollInterval)
 			continue
 		}
 
 		if resp.StatusCode != 200 {
-			ch <- fmt.Sprintf("Error Non-200 return (%v) from  -%s", resp.StatusCode, URL)
+			w.Publish(fmt.Sprintf("Error Non-200 return (%v) from  -%s", resp.StatusCode, URL))
 			time.Sleep(cfg.PollInterval)
 			continue
 		}
@@ -61,7 +62,7 @@ func customRoutes(cfg *config.Custom, ch chan string) {
 		decoder := json.NewDecoder(resp.Body)
 		err = decoder.Decode(&Routes)
 		if err != nil {
-			ch <- fmt.Sprintf("Error decoding request - %s -%s", URL, err.Error())
+			w.Publish(fmt.Sprintf("Error decoding request - %s -%s", URL, err.Error()))
 			time.Sleep(cfg.PollInterval)
 			continue
 		}
@@ -69,12 +70,12 @@ func customRoutes(cfg *config.Custom, ch chan string) {
 		log.Printf("[DEBUG] Custom Registry building table %s \n", time.Now())
 		t, err := route.NewTableCustom(Routes)
 		if err != nil {
-			ch <- fmt.Sprintf("Error generating new table - %s", err.Error())
+			w.Publish(fmt.Sprintf("Error generating new table - %s", err.Error()))
 		}
 		log.Printf("[DEBUG] Custom Registry building table complete %s \n", time.Now())
 		route.SetTable(t)
 		log.Printf("[DEBUG] Custom Registry table set complete %s \n", time.Now())
-		ch <- "OK"
+		w.Publish("OK")
 		time.Sleep(cfg.PollInterval)
 
 	}
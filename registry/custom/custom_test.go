@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/fabiolb/fabio/config"
+	"github.com/fabiolb/fabio/registry"
 	"github.com/fabiolb/fabio/route"
 	"net/http"
 	"testing"
@@ -22,7 +23,7 @@ func TestCustomRoutes(t *testing.T) {
 		Timeout:            3 * time.Second,
 	}
 
-	ch := make(chan string, 1)
+	w := registry.NewWatch()
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/test", handleTest)
@@ -34,9 +35,9 @@ func TestCustomRoutes(t *testing.T) {
 	time.Sleep(3 * time.Second)
 	defer server.Close()
 
-	go customRoutes(&cfg, ch)
+	go customRoutes(&cfg, w)
 
-	resp = <-ch
+	resp = w.Next(0).Value
 
 	if resp != "OK" {
 		fmt.Printf("Failed to get routes for custom backend - %s", resp)
@@ -0,0 +1,178 @@
+// Package etcd implements a registry backend which reads the routes,
+// the manual overrides and the noroute HTML page from keys in etcd.
+package etcd
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/fabiolb/fabio/config"
+	"github.com/fabiolb/fabio/registry"
+)
+
+// retryInterval is the time to wait after a failed request.
+const retryInterval = time.Second
+
+// requestTimeout limits the duration of a single request.
+const requestTimeout = 10 * time.Second
+
+// watchTimeout limits the duration of a watch to detect
+// connections which have silently gone away.
+const watchTimeout = 5 * time.Minute
+
+type be struct {
+	c   *client
+	cfg *config.Etcd
+}
+
+func NewBackend(cfg *config.Etcd) (registry.Backend, error) {
+	c, err := newClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// ping the cluster
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	version, err := c.status(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("[INFO] etcd: Connecting to %s (version %s)", cfg.Addr, version)
+	return &be{c: c, cfg: cfg}, nil
+}
+
+func (b *be) Register(services []string) error {
+	return nil
+}
+
+func (b *be) Deregister(serviceName string) error {
+	return nil
+}
+
+func (b *be) DeregisterAll() error {
+	return nil
+}
+
+func (b *be) ManualPaths() ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	kvs, _, err := b.c.get(ctx, b.cfg.KVPath, true, true)
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for _, kv := range kvs {
+		keys = append(keys, string(kv.Key))
+	}
+	return keys, nil
+}
+
+func (b *be) ReadManual(path string) (value string, version uint64, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	kvs, _, err := b.c.get(ctx, b.cfg.KVPath+path, false, false)
+	if err != nil || len(kvs) == 0 {
+		return "", 0, err
+	}
+	return strings.TrimSpace(string(kvs[0].Value)), uint64(kvs[0].ModRevision), nil
+}
+
+func (b *be) WriteManual(path string, value string, version uint64) (ok bool, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	// try to create the key first by using version 0
+	if ok, err = b.c.put(ctx, b.cfg.KVPath+path, value, 0); ok || err != nil {
+		return
+	}
+
+	// then try the CAS update
+	return b.c.put(ctx, b.cfg.KVPath+path, value, int64(version))
+}
+
+func (b *be) DeleteManual(path string, version uint64) (ok bool, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	return b.c.del(ctx, b.cfg.KVPath+path, int64(version))
+}
+
+func (b *be) WatchServices() *registry.Watch {
+	log.Printf("[INFO] etcd: Watching routes in %q", b.cfg.RoutesPath)
+
+	svc := registry.NewWatch()
+	go b.watchKV(b.cfg.RoutesPath, svc, true)
+	return svc
+}
+
+func (b *be) WatchManual() *registry.Watch {
+	log.Printf("[INFO] etcd: Watching KV path %q", b.cfg.KVPath)
+
+	kv := registry.NewWatch()
+	go b.watchKV(b.cfg.KVPath, kv, true)
+	return kv
+}
+
+func (b *be) WatchNoRouteHTML() *registry.Watch {
+	log.Printf("[INFO] etcd: Watching KV path %q", b.cfg.NoRouteHTMLPath)
+
+	html := registry.NewWatch()
+	go b.watchKV(b.cfg.NoRouteHTMLPath, html, false)
+	return html
+}
+
+// watchKV publishes the value of a key and watches it for changes.
+// If prefix is true the values of all keys with the path as prefix
+// are published separated by a comment with the key.
+func (b *be) watchKV(path string, w *registry.Watch, prefix bool) {
+	var lastValue string
+	var published bool
+
+	for {
+		value, rev, err := b.read(path, prefix)
+		if err != nil {
+			log.Printf("[WARN] etcd: Error fetching config from %s. %v", path, err)
+			time.Sleep(retryInterval)
+			continue
+		}
+
+		if !published || value != lastValue {
+			log.Printf("[DEBUG] etcd: Config in %s changed to #%d", path, rev)
+			w.Publish(value)
+			lastValue, published = value, true
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), watchTimeout)
+		err = b.c.watch(ctx, path, prefix, rev+1)
+		expired := ctx.Err() == context.DeadlineExceeded
+		cancel()
+		if err != nil && !expired {
+			log.Printf("[WARN] etcd: Error watching %s. %v", path, err)
+			time.Sleep(retryInterval)
+		}
+	}
+}
+
+// read returns the value of the key or the values of all keys with
+// the path as prefix and the revision of the store.
+func (b *be) read(path string, prefix bool) (string, int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	kvs, rev, err := b.c.get(ctx, path, prefix, false)
+	if err != nil {
+		return "", 0, err
+	}
+
+	var s []string
+	for _, kv := range kvs {
+		val := strings.TrimSpace(string(kv.Value))
+		if prefix {
+			val = "# --- " + string(kv.Key) + "\n" + val
+		}
+		s = append(s, val)
+	}
+	return strings.Join(s, "\n\n"), rev, nil
+}
@@ -0,0 +1,361 @@
+package etcd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fabiolb/fabio/config"
+	"github.com/fabiolb/fabio/registry"
+)
+
+// fakeEtcd implements the parts of the JSON gateway of the
+// etcd v3 API which are used by the backend.
+type fakeEtcd struct {
+	mu      sync.Mutex
+	rev     int64
+	kvs     map[string]keyValue
+	changed chan struct{}
+	done    chan struct{}
+	srv     *httptest.Server
+
+	// user and password enable authentication
+	user, password string
+	tokens         int
+	token          string
+}
+
+func newFakeEtcd() *fakeEtcd {
+	return &fakeEtcd{rev: 1, kvs: map[string]keyValue{}, changed: make(chan struct{}), done: make(chan struct{})}
+}
+
+// start starts the server and returns its URL.
+func (f *fakeEtcd) start() string {
+	f.srv = httptest.NewServer(f)
+	return f.srv.URL
+}
+
+// stop terminates the open watches and stops the server.
+func (f *fakeEtcd) stop() {
+	close(f.done)
+	f.srv.Close()
+}
+
+func (f *fakeEtcd) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/v3/auth/authenticate" {
+		var req struct{ Name, Password string }
+		json.NewDecoder(r.Body).Decode(&req)
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if req.Name != f.user || req.Password != f.password {
+			http.Error(w, `{"error":"authentication failed","code":3,"message":"authentication failed"}`, http.StatusBadRequest)
+			return
+		}
+		f.tokens++
+		f.token = fmt.Sprintf("token-%d", f.tokens)
+		fmt.Fprintf(w, `{"token":%q}`, f.token)
+		return
+	}
+
+	f.mu.Lock()
+	authorized := f.user == "" || r.Header.Get("Authorization") == f.token
+	f.mu.Unlock()
+	if !authorized {
+		http.Error(w, `{"error":"invalid auth token","code":16,"message":"invalid auth token"}`, http.StatusUnauthorized)
+		return
+	}
+
+	switch r.URL.Path {
+	case "/v3/maintenance/status":
+		fmt.Fprint(w, `{"version":"3.5.0"}`)
+
+	case "/v3/kv/range":
+		var req rangeRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		resp := rangeResponse{Header: responseHeader{Revision: f.rev}, Kvs: f.match(req.Key, req.RangeEnd)}
+		json.NewEncoder(w).Encode(resp)
+
+	case "/v3/kv/txn":
+		var req txnRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		f.mu.Lock()
+		cmp := req.Compare[0]
+		ok := f.kvs[string(cmp.Key)].ModRevision == cmp.ModRevision
+		f.mu.Unlock()
+		if ok {
+			if put := req.Success[0].RequestPut; put != nil {
+				f.put(string(put.Key), string(put.Value))
+			} else {
+				f.del(string(req.Success[0].RequestDeleteRange.Key))
+			}
+		}
+		json.NewEncoder(w).Encode(txnResponse{Succeeded: ok})
+
+	case "/v3/watch":
+		var req watchRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		fmt.Fprint(w, `{"result":{"created":true}}`+"\n")
+		w.(http.Flusher).Flush()
+		for {
+			f.mu.Lock()
+			var events []keyValue
+			for _, kv := range f.match(req.CreateRequest.Key, req.CreateRequest.RangeEnd) {
+				if kv.ModRevision >= req.CreateRequest.StartRevision {
+					events = append(events, kv)
+				}
+			}
+			changed := f.changed
+			f.mu.Unlock()
+
+			if len(events) > 0 {
+				b, _ := json.Marshal(events)
+				fmt.Fprintf(w, `{"result":{"events":%s}}`+"\n", b)
+				return
+			}
+			select {
+			case <-changed:
+			case <-r.Context().Done():
+				return
+			case <-f.done:
+				return
+			}
+		}
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// match returns the keys in the range sorted by key.
+func (f *fakeEtcd) match(key, end []byte) []keyValue {
+	var kvs []keyValue
+	for _, kv := range f.kvs {
+		if bytes.Equal(kv.Key, key) || (len(end) > 0 && bytes.Compare(kv.Key, key) >= 0 && bytes.Compare(kv.Key, end) < 0) {
+			kvs = append(kvs, kv)
+		}
+	}
+	sort.Slice(kvs, func(i, j int) bool { return bytes.Compare(kvs[i].Key, kvs[j].Key) < 0 })
+	return kvs
+}
+
+// put stores the value and unblocks the watches.
+func (f *fakeEtcd) put(key, value string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rev++
+	f.kvs[key] = keyValue{Key: []byte(key), Value: []byte(value), ModRevision: f.rev}
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+// del removes the key and unblocks the watches.
+func (f *fakeEtcd) del(key string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rev++
+	delete(f.kvs, key)
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+func newTestBackend(t *testing.T, addr string) *be {
+	t.Helper()
+	cfg := &config.Etcd{
+		Addr:            addr,
+		RoutesPath:      "/fabio/routes",
+		KVPath:          "/fabio/config",
+		NoRouteHTMLPath: "/fabio/noroute.html",
+	}
+	b, err := NewBackend(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b.(*be)
+}
+
+func TestNewBackend(t *testing.T) {
+	etcd := newFakeEtcd()
+	addr := etcd.start()
+	defer etcd.stop()
+
+	if _, err := NewBackend(&config.Etcd{Addr: addr}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewBackend(&config.Etcd{Addr: addr + "/missing"}); err == nil {
+		t.Fatal("got nil want error")
+	}
+}
+
+func TestFailover(t *testing.T) {
+	etcd := newFakeEtcd()
+	addr := etcd.start()
+	defer etcd.stop()
+
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	b := newTestBackend(t, down.URL+","+addr)
+	if got, want := b.c.cur, 1; got != want {
+		t.Fatalf("got endpoint %d want %d", got, want)
+	}
+}
+
+func TestWatchServices(t *testing.T) {
+	etcd := newFakeEtcd()
+	etcd.put("/fabio/routes/web", "route add web / http://10.0.0.1:8080/\n")
+	addr := etcd.start()
+	defer etcd.stop()
+
+	w := newTestBackend(t, addr).WatchServices()
+	snap := waitFor(t, w.Next, 0, "# --- /fabio/routes/web\nroute add web / http://10.0.0.1:8080/")
+
+	etcd.put("/fabio/routes/api", "route add api /api http://10.0.0.2:8080/")
+	waitFor(t, w.Next, snap, "# --- /fabio/routes/api\nroute add api /api http://10.0.0.2:8080/\n\n# --- /fabio/routes/web\nroute add web / http://10.0.0.1:8080/")
+}
+
+func TestWatchNoRouteHTML(t *testing.T) {
+	etcd := newFakeEtcd()
+	addr := etcd.start()
+	defer etcd.stop()
+
+	w := newTestBackend(t, addr).WatchNoRouteHTML()
+	snap := waitFor(t, w.Next, 0, "")
+
+	// keys with the path as prefix are ignored
+	etcd.put("/fabio/noroute.html.bak", "old")
+	etcd.put("/fabio/noroute.html", "<h1>no route</h1>")
+	waitFor(t, w.Next, snap, "<h1>no route</h1>")
+}
+
+func TestManual(t *testing.T) {
+	etcd := newFakeEtcd()
+	addr := etcd.start()
+	defer etcd.stop()
+
+	b := newTestBackend(t, addr)
+	w := b.WatchManual()
+	snap := waitFor(t, w.Next, 0, "")
+
+	ok, err := b.WriteManual("", "route del web", 0)
+	if err != nil || !ok {
+		t.Fatalf("got %v, %v want true, nil", ok, err)
+	}
+	value, version, err := b.ReadManual("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := value, "route del web"; got != want {
+		t.Fatalf("got value %q want %q", got, want)
+	}
+	snap = waitFor(t, w.Next, snap, "# --- /fabio/config\nroute del web")
+
+	// updates with a stale version fail
+	if ok, err := b.WriteManual("", "route del api", version-1); err != nil || ok {
+		t.Fatalf("got %v, %v want false, nil", ok, err)
+	}
+	if ok, err := b.WriteManual("", "route del api", version); err != nil || !ok {
+		t.Fatalf("got %v, %v want true, nil", ok, err)
+	}
+	if ok, err := b.WriteManual("/canary", "route weight web / weight 0.1", 0); err != nil || !ok {
+		t.Fatalf("got %v, %v want true, nil", ok, err)
+	}
+	waitFor(t, w.Next, snap, "# --- /fabio/config\nroute del api\n\n# --- /fabio/config/canary\nroute weight web / weight 0.1")
+
+	paths, err := b.ManualPaths()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := paths, []string{"/fabio/config", "/fabio/config/canary"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got paths %q want %q", got, want)
+	}
+
+	// deletes with a stale version fail
+	_, version, err = b.ReadManual("/canary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := b.DeleteManual("/canary", version-1); err != nil || ok {
+		t.Fatalf("got %v, %v want false, nil", ok, err)
+	}
+	if ok, err := b.DeleteManual("/canary", version); err != nil || !ok {
+		t.Fatalf("got %v, %v want true, nil", ok, err)
+	}
+	if value, _, err := b.ReadManual("/canary"); err != nil || value != "" {
+		t.Fatalf("got %q, %v want empty value", value, err)
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	etcd := newFakeEtcd()
+	etcd.user, etcd.password = "fabio", "secret"
+	addr := etcd.start()
+	defer etcd.stop()
+
+	if _, err := NewBackend(&config.Etcd{Addr: addr, Username: "fabio", Password: "wrong"}); err == nil {
+		t.Fatal("got nil want error")
+	}
+
+	b, err := NewBackend(&config.Etcd{Addr: addr, Username: "fabio", Password: "secret", KVPath: "/fabio/config"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// expire the token
+	etcd.mu.Lock()
+	etcd.token = "expired"
+	etcd.mu.Unlock()
+
+	if _, _, err := b.ReadManual(""); err != nil {
+		t.Fatal(err)
+	}
+	etcd.mu.Lock()
+	defer etcd.mu.Unlock()
+	if got, want := etcd.tokens, 2; got != want {
+		t.Fatalf("got %d tokens want %d", got, want)
+	}
+}
+
+func TestPrefixEnd(t *testing.T) {
+	tests := []struct {
+		prefix string
+		end    []byte
+	}{
+		{"/fabio", []byte("/fabip")},
+		{"a\xff", []byte("b")},
+		{"\xff\xff", []byte{0}},
+	}
+	for _, tt := range tests {
+		if got, want := prefixEnd(tt.prefix), tt.end; !bytes.Equal(got, want) {
+			t.Errorf("%q: got %q want %q", tt.prefix, got, want)
+		}
+	}
+}
+
+// waitFor waits until the watch publishes the wanted value and
+// returns its sequence number.
+func waitFor(t *testing.T, next func(uint64) registry.Snapshot, seq uint64, want string) uint64 {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		c := make(chan registry.Snapshot, 1)
+		go func() { c <- next(seq) }()
+		select {
+		case s := <-c:
+			if s.Value == want {
+				return s.Seq
+			}
+			seq = s.Seq
+		case <-timeout:
+			t.Fatalf("timeout waiting for %q", want)
+		}
+	}
+}
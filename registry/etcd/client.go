@@ -0,0 +1,389 @@
+package etcd
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fabiolb/fabio/config"
+)
+
+// client is a minimal client for the JSON gateway of the etcd v3 API.
+// Requests which fail with a connection error are retried with the
+// next endpoint.
+type client struct {
+	endpoints []string
+	username  string
+	password  string
+	http      *http.Client
+
+	mu    sync.Mutex
+	cur   int
+	token string
+}
+
+func newClient(cfg *config.Etcd) (*client, error) {
+	tlscfg := &tls.Config{InsecureSkipVerify: cfg.TLS.InsecureSkipVerify}
+	if cfg.TLS.CAFile != "" {
+		ca, err := ioutil.ReadFile(cfg.TLS.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("etcd: invalid CA file %s", cfg.TLS.CAFile)
+		}
+		tlscfg.RootCAs = pool
+	}
+	if cfg.TLS.CertFile != "" || cfg.TLS.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlscfg.Certificates = []tls.Certificate{cert}
+	}
+
+	var endpoints []string
+	for _, a := range strings.Split(cfg.Addr, ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			endpoints = append(endpoints, strings.TrimSuffix(a, "/"))
+		}
+	}
+	if len(endpoints) == 0 {
+		return nil, fmt.Errorf("etcd: no endpoints")
+	}
+
+	return &client{
+		endpoints: endpoints,
+		username:  cfg.Username,
+		password:  cfg.Password,
+		http: &http.Client{
+			Transport: &http.Transport{
+				Proxy:               http.ProxyFromEnvironment,
+				TLSClientConfig:     tlscfg,
+				TLSHandshakeTimeout: 10 * time.Second,
+			},
+		},
+	}, nil
+}
+
+// statusError is returned for responses with an error status.
+type statusError struct {
+	code int
+	msg  string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("etcd: %d %s", e.code, e.msg)
+}
+
+type responseHeader struct {
+	Revision int64 `json:"revision,string"`
+}
+
+type keyValue struct {
+	Key         []byte `json:"key"`
+	Value       []byte `json:"value"`
+	ModRevision int64  `json:"mod_revision,string"`
+}
+
+type rangeRequest struct {
+	Key      []byte `json:"key"`
+	RangeEnd []byte `json:"range_end,omitempty"`
+	KeysOnly bool   `json:"keys_only,omitempty"`
+}
+
+type rangeResponse struct {
+	Header responseHeader `json:"header"`
+	Kvs    []keyValue     `json:"kvs"`
+}
+
+type compare struct {
+	Target      string `json:"target"`
+	Result      string `json:"result"`
+	Key         []byte `json:"key"`
+	ModRevision int64  `json:"mod_revision,string"`
+}
+
+type putRequest struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+}
+
+type deleteRangeRequest struct {
+	Key []byte `json:"key"`
+}
+
+type requestOp struct {
+	RequestPut         *putRequest         `json:"request_put,omitempty"`
+	RequestDeleteRange *deleteRangeRequest `json:"request_delete_range,omitempty"`
+}
+
+type txnRequest struct {
+	Compare []compare   `json:"compare"`
+	Success []requestOp `json:"success"`
+}
+
+type txnResponse struct {
+	Succeeded bool `json:"succeeded"`
+}
+
+type watchCreateRequest struct {
+	Key            []byte `json:"key"`
+	RangeEnd       []byte `json:"range_end,omitempty"`
+	StartRevision  int64  `json:"start_revision,string"`
+	ProgressNotify bool   `json:"progress_notify,omitempty"`
+}
+
+type watchRequest struct {
+	CreateRequest watchCreateRequest `json:"create_request"`
+}
+
+type watchResponse struct {
+	Result struct {
+		Canceled        bool              `json:"canceled"`
+		CancelReason    string            `json:"cancel_reason"`
+		CompactRevision int64             `json:"compact_revision,string"`
+		Events          []json.RawMessage `json:"events"`
+	} `json:"result"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// status returns the version of the etcd server.
+func (c *client) status(ctx context.Context) (string, error) {
+	var resp struct {
+		Version string `json:"version"`
+	}
+	err := c.call(ctx, "/v3/maintenance/status", struct{}{}, &resp)
+	return resp.Version, err
+}
+
+// get returns the values of the key, or all keys with the key as
+// prefix, and the revision of the store.
+func (c *client) get(ctx context.Context, key string, prefix, keysOnly bool) ([]keyValue, int64, error) {
+	req := rangeRequest{Key: []byte(key), KeysOnly: keysOnly}
+	if prefix {
+		req.RangeEnd = prefixEnd(key)
+	}
+	var resp rangeResponse
+	if err := c.call(ctx, "/v3/kv/range", req, &resp); err != nil {
+		return nil, 0, err
+	}
+	return resp.Kvs, resp.Header.Revision, nil
+}
+
+// put stores the value if the key was last modified in the given
+// revision. A revision of zero means that the key must not exist.
+func (c *client) put(ctx context.Context, key, value string, rev int64) (bool, error) {
+	req := txnRequest{
+		Compare: []compare{{Target: "MOD", Result: "EQUAL", Key: []byte(key), ModRevision: rev}},
+		Success: []requestOp{{RequestPut: &putRequest{Key: []byte(key), Value: []byte(value)}}},
+	}
+	var resp txnResponse
+	if err := c.call(ctx, "/v3/kv/txn", req, &resp); err != nil {
+		return false, err
+	}
+	return resp.Succeeded, nil
+}
+
+// del deletes the key if it was last modified in the given revision.
+func (c *client) del(ctx context.Context, key string, rev int64) (bool, error) {
+	req := txnRequest{
+		Compare: []compare{{Target: "MOD", Result: "EQUAL", Key: []byte(key), ModRevision: rev}},
+		Success: []requestOp{{RequestDeleteRange: &deleteRangeRequest{Key: []byte(key)}}},
+	}
+	var resp txnResponse
+	if err := c.call(ctx, "/v3/kv/txn", req, &resp); err != nil {
+		return false, err
+	}
+	return resp.Succeeded, nil
+}
+
+// watch blocks until the key, or one of the keys with the key as
+// prefix, has changed after the given revision.
+func (c *client) watch(ctx context.Context, key string, prefix bool, rev int64) error {
+	req := watchRequest{watchCreateRequest{Key: []byte(key), StartRevision: rev, ProgressNotify: true}}
+	if prefix {
+		req.CreateRequest.RangeEnd = prefixEnd(key)
+	}
+	resp, err := c.do(ctx, "/v3/watch", req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var w watchResponse
+		if err := dec.Decode(&w); err != nil {
+			return err
+		}
+		switch {
+		case w.Error != nil:
+			return fmt.Errorf("etcd: %s", w.Error.Message)
+		case len(w.Result.Events) > 0:
+			return nil
+		case w.Result.CompactRevision > 0:
+			// the revision has been compacted and the
+			// caller has to read the current values.
+			return nil
+		case w.Result.Canceled:
+			return fmt.Errorf("etcd: watch canceled. %s", w.Result.CancelReason)
+		}
+	}
+}
+
+// call posts the request and decodes the response into v.
+func (c *client) call(ctx context.Context, path string, in, v interface{}) error {
+	resp, err := c.do(ctx, path, in)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// do posts the request to the current endpoint and tries the other
+// endpoints if the connection fails. If authentication is enabled
+// the client authenticates before the first request and again when
+// the token has expired.
+func (c *client) do(ctx context.Context, path string, in interface{}) (*http.Response, error) {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.username != "" && c.getToken() == "" {
+		if err := c.authenticate(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	reauth := c.username != ""
+	for {
+		resp, err := c.post(ctx, path, body, c.getToken())
+		if se, ok := err.(*statusError); ok && se.code == http.StatusUnauthorized && reauth {
+			reauth = false
+			if err := c.authenticate(ctx); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		return resp, err
+	}
+}
+
+// authenticate fetches a new token for the user.
+func (c *client) authenticate(ctx context.Context) error {
+	body, err := json.Marshal(map[string]string{"name": c.username, "password": c.password})
+	if err != nil {
+		return err
+	}
+	resp, err := c.post(ctx, "/v3/auth/authenticate", body, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var auth struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&auth); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.token = auth.Token
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *client) getToken() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.token
+}
+
+// post sends the request to the endpoints starting with the current
+// one until a connection succeeds.
+func (c *client) post(ctx context.Context, path string, body []byte, token string) (*http.Response, error) {
+	c.mu.Lock()
+	cur := c.cur
+	c.mu.Unlock()
+
+	var err error
+	for i := 0; i < len(c.endpoints); i++ {
+		n := (cur + i) % len(c.endpoints)
+
+		var req *http.Request
+		req, err = http.NewRequest("POST", c.endpoints[n]+path, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", token)
+		}
+
+		var resp *http.Response
+		resp, err = c.http.Do(req)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		c.mu.Lock()
+		c.cur = n
+		c.mu.Unlock()
+
+		if resp.StatusCode != http.StatusOK {
+			defer resp.Body.Close()
+			return nil, &statusError{resp.StatusCode, errorMessage(resp)}
+		}
+		return resp, nil
+	}
+	return nil, err
+}
+
+// errorMessage returns the message of an error response.
+func errorMessage(resp *http.Response) string {
+	b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	var e struct {
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(b, &e) == nil && e.Message != "" {
+		return e.Message
+	}
+	if msg := strings.TrimSpace(string(b)); msg != "" {
+		return msg
+	}
+	return resp.Status
+}
+
+// prefixEnd returns the end of the key range which contains
+// all keys with the given prefix.
+func prefixEnd(prefix string) []byte {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	// the prefix consists only of 0xff bytes and the
+	// range contains all keys starting with the prefix.
+	return []byte{0}
+}
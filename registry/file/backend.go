@@ -1,30 +1,361 @@
 // Package file implements a simple file based registry
-// backend which reads the routes from a file once.
+// backend which reads the routes from a file and optionally
+// reloads them when the file changes.
 package file
 
 import (
+	"bytes"
+	"fmt"
 	"io/ioutil"
 	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/fabiolb/fabio/config"
+	"github.com/fabiolb/fabio/metrics"
 	"github.com/fabiolb/fabio/registry"
-	"github.com/fabiolb/fabio/registry/static"
+	"github.com/fabiolb/fabio/route"
 )
 
+// Directives which are handled by the file backend and which
+// are not passed on to the route parser.
+const (
+	includeDirective = "include"
+	norouteDirective = "noroute.html"
+)
+
+// maxIncludeDepth limits the nesting of include directives.
+const maxIncludeDepth = 8
+
+type be struct {
+	cfg *config.File
+
+	// reloads counts successful reloads and reloadErrors
+	// counts reloads which were rejected.
+	reloads      metrics.Counter
+	reloadErrors metrics.Counter
+
+	// svc and html hold the routing table and the noroute HTML
+	// which are updated by the watcher which is started on first use.
+	svc   *registry.Watch
+	html  *registry.Watch
+	watch sync.Once
+
+	// last is the last successfully loaded snapshot.
+	last *snapshot
+
+	mu     sync.Mutex
+	status Status
+}
+
+// Status describes the state of the file backend.
+type Status struct {
+	// Files contains all files which make up the routing table
+	// in the order they were read.
+	Files []string `json:"files"`
+
+	// LastLoad is the time of the last successful load.
+	LastLoad time.Time `json:"lastLoad"`
+
+	// LastError contains the error of the last failed reload
+	// and is empty if the last reload succeeded.
+	LastError string `json:"lastError,omitempty"`
+
+	// Reloads and Errors count the successful and the failed reloads.
+	Reloads uint64 `json:"reloads"`
+	Errors  uint64 `json:"errors"`
+}
+
+// snapshot contains the content of the routing table file after all
+// directives have been processed.
+type snapshot struct {
+	routes      string
+	noroutehtml string
+	files       []string
+	modTimes    map[string]time.Time
+
+	// globs contains the files which matched the patterns of
+	// the include directives.
+	globs map[string][]string
+}
+
 func NewBackend(cfg *config.File) (registry.Backend, error) {
-	routes, err := ioutil.ReadFile(cfg.RoutesPath)
+	b := &be{
+		cfg:          cfg,
+		reloads:      metrics.DefaultRegistry.GetCounter("registry.file.reload"),
+		reloadErrors: metrics.DefaultRegistry.GetCounter("registry.file.reload.error"),
+		svc:          registry.NewWatch(),
+		html:         registry.NewWatch(),
+	}
+
+	// the initial load must succeed
+	s, err := b.load()
 	if err != nil {
-		log.Println("[ERROR] Cannot read routes from ", cfg.RoutesPath)
+		log.Printf("[ERROR] Cannot read routes from %s. %s", cfg.RoutesPath, err)
 		return nil, err
 	}
-	noroutehtml, err := ioutil.ReadFile(cfg.NoRouteHTMLPath)
+	b.last = s
+	b.svc.Publish(s.routes)
+	b.html.Publish(s.noroutehtml)
+	return b, nil
+}
+
+func (b *be) Register(services []string) error {
+	return nil
+}
+
+func (b *be) Deregister(serviceName string) error {
+	return nil
+}
+
+func (b *be) DeregisterAll() error {
+	return nil
+}
+
+func (b *be) ManualPaths() ([]string, error) {
+	return nil, nil
+}
+
+func (b *be) ReadManual(string) (value string, version uint64, err error) {
+	return "", 0, nil
+}
+
+func (b *be) WriteManual(path string, value string, version uint64) (ok bool, err error) {
+	return false, nil
+}
+
+func (b *be) WatchServices() *registry.Watch {
+	b.startWatch()
+	return b.svc
+}
+
+func (b *be) WatchManual() *registry.Watch {
+	return registry.NewWatch()
+}
+
+func (b *be) WatchNoRouteHTML() *registry.Watch {
+	b.startWatch()
+	return b.html
+}
+
+// Status returns the current state of the backend.
+func (b *be) Status() interface{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := b.status
+	s.Files = append([]string(nil), b.status.Files...)
+	return s
+}
+
+func (b *be) startWatch() {
+	if b.cfg.Refresh <= 0 {
+		return
+	}
+	b.watch.Do(func() { go b.poll() })
+}
+
+// poll reloads the files every refresh interval when one of them
+// was modified or when files were added to or removed from the
+// directories of the include patterns. If the new routing table
+// cannot be parsed the last good one is kept until the files
+// change again.
+func (b *be) poll() {
+	modTimes, globs := b.last.modTimes, b.last.globs
+	for {
+		time.Sleep(b.cfg.Refresh)
+
+		if !changed(modTimes, globs) {
+			continue
+		}
+
+		s, err := b.load()
+		if err != nil {
+			b.reloadErrors.Inc(1)
+			b.mu.Lock()
+			b.status.LastError = err.Error()
+			b.status.Errors++
+			b.mu.Unlock()
+			log.Printf("[WARN] file: Keeping last routing table. %s", err)
+			// do not retry until one of the files changes again
+			modTimes, globs = stat(keys(modTimes)), glob(patterns(globs))
+			continue
+		}
+		b.reloads.Inc(1)
+		b.mu.Lock()
+		b.status.Reloads++
+		b.mu.Unlock()
+		log.Printf("[INFO] file: Reloaded %s", strings.Join(s.files, ", "))
+
+		if s.routes != b.last.routes {
+			b.svc.Publish(s.routes)
+		}
+		if s.noroutehtml != b.last.noroutehtml {
+			b.html.Publish(s.noroutehtml)
+		}
+		b.last, modTimes, globs = s, s.modTimes, s.globs
+	}
+}
+
+// load reads the routing table and the noroute HTML and
+// validates the routing table.
+func (b *be) load() (*snapshot, error) {
+	s := &snapshot{modTimes: map[string]time.Time{}, globs: map[string][]string{}}
+
+	routes, err := readRoutes(b.cfg.RoutesPath, s, 0)
 	if err != nil {
-		log.Println("[ERROR] Cannot read no route HTML from ", cfg.NoRouteHTMLPath)
 		return nil, err
 	}
-	staticCfg := &config.Static{
-		NoRouteHTML: string(noroutehtml),
-		Routes:      string(routes),
+	s.routes = routes
+
+	if _, err := route.Parse(bytes.NewBufferString(s.routes)); err != nil {
+		return nil, fmt.Errorf("file: %s", err)
+	}
+
+	// the noroute.html directive overrides the configured path
+	if s.noroutehtml == "" && b.cfg.NoRouteHTMLPath != "" {
+		html, err := readFile(b.cfg.NoRouteHTMLPath, s)
+		if err != nil {
+			return nil, err
+		}
+		s.noroutehtml = html
+	}
+
+	b.mu.Lock()
+	b.status.Files = s.files
+	b.status.LastLoad = time.Now()
+	b.status.LastError = ""
+	b.mu.Unlock()
+
+	return s, nil
+}
+
+// readRoutes reads the routing table from path and expands the include
+// and noroute.html directives. Relative paths in directives are resolved
+// relative to the directory of the file which contains them.
+func readRoutes(path string, s *snapshot, depth int) (string, error) {
+	if depth > maxIncludeDepth {
+		return "", fmt.Errorf("file: include depth exceeds %d in %s", maxIncludeDepth, path)
+	}
+
+	data, err := readFile(path, s)
+	if err != nil {
+		return "", err
+	}
+
+	dir := filepath.Dir(path)
+	resolve := func(p string) string {
+		if filepath.IsAbs(p) {
+			return p
+		}
+		return filepath.Join(dir, p)
+	}
+
+	var out []string
+	for i, line := range strings.Split(data, "\n") {
+		f := strings.Fields(line)
+		switch {
+		case len(f) == 0:
+			out = append(out, line)
+
+		case f[0] == includeDirective:
+			if len(f) != 2 {
+				return "", fmt.Errorf("file: %s line %d: syntax error: include <pattern>", path, i+1)
+			}
+			pattern := resolve(f[1])
+			names, err := filepath.Glob(pattern)
+			if err != nil {
+				return "", fmt.Errorf("file: %s line %d: %s", path, i+1, err)
+			}
+			s.globs[pattern] = names
+			if len(names) == 0 {
+				log.Printf("[WARN] file: %s line %d: no files match %q", path, i+1, f[1])
+			}
+			for _, name := range names {
+				r, err := readRoutes(name, s, depth+1)
+				if err != nil {
+					return "", err
+				}
+				out = append(out, "# --- "+name, r)
+			}
+
+		case f[0] == norouteDirective:
+			if len(f) != 2 {
+				return "", fmt.Errorf("file: %s line %d: syntax error: noroute.html <path>", path, i+1)
+			}
+			html, err := readFile(resolve(f[1]), s)
+			if err != nil {
+				return "", err
+			}
+			s.noroutehtml = html
+
+		default:
+			out = append(out, line)
+		}
+	}
+	return strings.Join(out, "\n"), nil
+}
+
+// readFile reads the file and records its modification time.
+func readFile(path string, s *snapshot) (string, error) {
+	if _, ok := s.modTimes[path]; ok {
+		return "", fmt.Errorf("file: %s included more than once", path)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("file: %s", err)
+	}
+	s.files = append(s.files, path)
+	s.modTimes[path] = stat([]string{path})[path]
+	return string(data), nil
+}
+
+// stat returns the modification times of the given files.
+// Files which cannot be accessed have a zero time.
+func stat(files []string) map[string]time.Time {
+	m := make(map[string]time.Time, len(files))
+	for _, f := range files {
+		if fi, err := os.Stat(f); err == nil {
+			m[f] = fi.ModTime()
+		} else {
+			m[f] = time.Time{}
+		}
+	}
+	return m
+}
+
+// glob returns the files which match the given patterns.
+func glob(patterns []string) map[string][]string {
+	m := make(map[string][]string, len(patterns))
+	for _, p := range patterns {
+		// the patterns have been validated when they were read
+		m[p], _ = filepath.Glob(p)
+	}
+	return m
+}
+
+// changed returns true if any of the files was modified or if
+// the files which match the include patterns have changed.
+func changed(modTimes map[string]time.Time, globs map[string][]string) bool {
+	return !reflect.DeepEqual(modTimes, stat(keys(modTimes))) ||
+		!reflect.DeepEqual(globs, glob(patterns(globs)))
+}
+
+func keys(m map[string]time.Time) []string {
+	files := make([]string, 0, len(m))
+	for f := range m {
+		files = append(files, f)
+	}
+	return files
+}
+
+func patterns(m map[string][]string) []string {
+	p := make([]string, 0, len(m))
+	for k := range m {
+		p = append(p, k)
 	}
-	return static.NewBackend(staticCfg)
+	return p
 }
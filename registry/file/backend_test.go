@@ -0,0 +1,178 @@
+package file
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fabiolb/fabio/config"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, data := range files {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestBackendIncludes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fabio-file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeFiles(t, dir, map[string]string{
+		"routes.txt":      "# main\nroute add svc / http://1.2.3.4:80/ weight 0.2\ninclude routes.d/*.conf\nnoroute.html 404.html\n",
+		"routes.d/a.conf": "route add a /a http://1.2.3.4:81/ opts \"strip=/a\"\n",
+		"404.html":        "<h1>not found</h1>",
+	})
+
+	b, err := NewBackend(&config.File{RoutesPath: filepath.Join(dir, "routes.txt")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	routes := b.WatchServices().Next(0).Value
+	if !strings.Contains(routes, "route add svc /") || !strings.Contains(routes, "route add a /a") {
+		t.Fatalf("got %q want both routes", routes)
+	}
+	if strings.Contains(routes, "include") || strings.Contains(routes, "noroute.html") {
+		t.Fatalf("got %q want directives removed", routes)
+	}
+	if got, want := b.WatchNoRouteHTML().Next(0).Value, "<h1>not found</h1>"; got != want {
+		t.Fatalf("got noroute html %q want %q", got, want)
+	}
+}
+
+func TestBackendInvalid(t *testing.T) {
+	tests := []struct {
+		desc  string
+		files map[string]string
+	}{
+		{"bad route", map[string]string{"routes.txt": "route add svc\n"}},
+		{"missing noroute", map[string]string{"routes.txt": "noroute.html missing.html\n"}},
+		{"include cycle", map[string]string{"routes.txt": "include routes.txt\n"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "fabio-file")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			writeFiles(t, dir, tt.files)
+			if _, err := NewBackend(&config.File{RoutesPath: filepath.Join(dir, "routes.txt")}); err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+}
+
+func TestBackendReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fabio-file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "routes.txt")
+	writeFiles(t, dir, map[string]string{"routes.txt": "route add a / http://1.2.3.4:80/\n"})
+
+	b, err := NewBackend(&config.File{RoutesPath: path, Refresh: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := b.WatchServices()
+	first := w.Next(0)
+
+	// invalid content is rejected and the error is reported
+	writeFiles(t, dir, map[string]string{"routes.txt": "route add b\n"})
+	os.Chtimes(path, time.Now(), time.Now().Add(time.Second))
+	deadline := time.Now().Add(2 * time.Second)
+	for b.(*be).Status().(Status).Errors == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for reload error")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// valid content is pushed
+	writeFiles(t, dir, map[string]string{"routes.txt": "route add c / http://1.2.3.4:80/\n"})
+	os.Chtimes(path, time.Now(), time.Now().Add(2*time.Second))
+	done := make(chan string)
+	go func() { done <- w.Next(first.Seq).Value }()
+	select {
+	case routes := <-done:
+		if got, want := routes, "route add c / http://1.2.3.4:80/\n"; got != want {
+			t.Fatalf("got %q want %q", got, want)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for reload")
+	}
+	if s := b.(*be).Status().(Status); s.LastError != "" || s.Reloads != 1 {
+		t.Fatalf("got status %+v", s)
+	}
+}
+
+func TestBackendReloadInclude(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fabio-file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "routes.txt")
+	writeFiles(t, dir, map[string]string{
+		"routes.txt":      "include routes.d/*.conf\n",
+		"routes.d/a.conf": "route add a / http://1.2.3.4:80/\n",
+	})
+
+	b, err := NewBackend(&config.File{RoutesPath: path, Refresh: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := b.WatchServices()
+	v := w.Next(0)
+
+	next := func(want string) {
+		t.Helper()
+		done := make(chan string)
+		go func() {
+			v = w.Next(v.Seq)
+			done <- v.Value
+		}()
+		select {
+		case routes := <-done:
+			if !strings.Contains(routes, want) {
+				t.Fatalf("got %q want %q", routes, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("timeout waiting for reload")
+		}
+	}
+
+	// a new file which matches the pattern is picked up
+	writeFiles(t, dir, map[string]string{"routes.d/b.conf": "route add b / http://1.2.3.4:80/\n"})
+	next("route add b")
+
+	// a removed file is dropped
+	if err := os.Remove(filepath.Join(dir, "routes.d/a.conf")); err != nil {
+		t.Fatal(err)
+	}
+	next("route add b")
+	if strings.Contains(v.Value, "route add a") {
+		t.Fatalf("got %q want no route for a", v.Value)
+	}
+}
@@ -0,0 +1,344 @@
+// Package flags implements feature flags which are stored in the
+// registry so that all fabio instances which watch the same key change
+// their behavior at the same time without calling the admin API of
+// every instance.
+package flags
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fabiolb/fabio/registry"
+)
+
+// Flags contains the parsed feature flags.
+type Flags struct {
+	// Drain takes the instance out of service.
+	Drain bool
+
+	// KillSwitches contains the kill switches by service.
+	KillSwitches map[string]KillSwitch
+
+	// Faults contains the injected faults by service.
+	Faults map[string]Fault
+
+	// Mirrors contains the percentage of the mirrored requests
+	// by service.
+	Mirrors map[string]float64
+}
+
+// KillSwitch replaces the responses of all HTTP routes to a service
+// with a static response.
+type KillSwitch struct {
+	Service string
+
+	// Status is the status code of the response. If it is
+	// zero the default status code is used.
+	Status int
+
+	// Until is the time the kill switch expires. If it is
+	// zero the kill switch is enabled until the flag is removed.
+	Until time.Time
+}
+
+// Fault delays or aborts a percentage of the HTTP requests to a
+// service.
+type Fault struct {
+	Service string
+
+	// Delay is the time the requests are delayed.
+	Delay time.Duration
+
+	// Abort is the status code of the response to the aborted
+	// requests. If it is zero the requests are not aborted.
+	Abort int
+
+	// Percent is the percentage of the requests which are
+	// delayed or aborted.
+	Percent float64
+}
+
+// reKillSwitch matches
+//
+//	killswitch <svc>[ status <code>][ until <time>]
+var reKillSwitch = regexp.MustCompile(`^killswitch\s+(\S+)(\s+status\s+(\S+))?(\s+until\s+(\S+))?$`)
+
+// reFault matches
+//
+//	fault <svc>[ delay <duration>][ abort <code>][ percent <p>]
+var reFault = regexp.MustCompile(`^fault\s+(\S+)(\s+delay\s+(\S+))?(\s+abort\s+(\S+))?(\s+percent\s+(\S+))?$`)
+
+// reMirror matches
+//
+//	mirror <svc> percent <p>
+var reMirror = regexp.MustCompile(`^mirror\s+(\S+)\s+percent\s+(\S+)$`)
+
+// Parse parses the feature flags. Every line contains one flag in one
+// of the forms
+//
+//	drain
+//	killswitch <svc>[ status <code>][ until <time>]
+//	fault <svc>[ delay <duration>][ abort <code>][ percent <p>]
+//	mirror <svc> percent <p>
+//
+// where time is in RFC3339 format, e.g. 2020-01-01T12:00:00Z, and p
+// is a percentage between 0 and 100 which defaults to 100 for faults.
+// Empty lines and lines starting with '#' are ignored.
+func Parse(s string) (Flags, error) {
+	f := Flags{KillSwitches: map[string]KillSwitch{}, Faults: map[string]Fault{}, Mirrors: map[string]float64{}}
+	sc := bufio.NewScanner(strings.NewReader(s))
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if line == "drain" {
+			f.Drain = true
+			continue
+		}
+		if m := reFault.FindStringSubmatch(line); m != nil {
+			ft, err := parseFault(n, m)
+			if err != nil {
+				return Flags{}, err
+			}
+			f.Faults[ft.Service] = ft
+			continue
+		}
+		if m := reMirror.FindStringSubmatch(line); m != nil {
+			p, err := parsePercent(n, m[2])
+			if err != nil {
+				return Flags{}, err
+			}
+			f.Mirrors[m[1]] = p
+			continue
+		}
+		m := reKillSwitch.FindStringSubmatch(line)
+		if m == nil {
+			return Flags{}, fmt.Errorf("flags: line %d: syntax error", n)
+		}
+		k := KillSwitch{Service: m[1]}
+		if m[3] != "" {
+			code, err := strconv.Atoi(m[3])
+			if err != nil || code < 100 || code > 999 {
+				return Flags{}, fmt.Errorf("flags: line %d: invalid status %q", n, m[3])
+			}
+			k.Status = code
+		}
+		if m[5] != "" {
+			t, err := time.Parse(time.RFC3339, m[5])
+			if err != nil {
+				return Flags{}, fmt.Errorf("flags: line %d: invalid time %q", n, m[5])
+			}
+			k.Until = t
+		}
+		f.KillSwitches[k.Service] = k
+	}
+	return f, nil
+}
+
+func parseFault(n int, m []string) (Fault, error) {
+	f := Fault{Service: m[1], Percent: 100}
+	if m[3] == "" && m[5] == "" {
+		return Fault{}, fmt.Errorf("flags: line %d: fault requires delay or abort", n)
+	}
+	if m[3] != "" {
+		d, err := time.ParseDuration(m[3])
+		if err != nil || d <= 0 {
+			return Fault{}, fmt.Errorf("flags: line %d: invalid delay %q", n, m[3])
+		}
+		f.Delay = d
+	}
+	if m[5] != "" {
+		code, err := strconv.Atoi(m[5])
+		if err != nil || code < 100 || code > 999 {
+			return Fault{}, fmt.Errorf("flags: line %d: invalid status %q", n, m[5])
+		}
+		f.Abort = code
+	}
+	if m[7] != "" {
+		p, err := parsePercent(n, m[7])
+		if err != nil {
+			return Fault{}, err
+		}
+		f.Percent = p
+	}
+	return f, nil
+}
+
+func parsePercent(n int, s string) (float64, error) {
+	p, err := strconv.ParseFloat(s, 64)
+	if err != nil || p < 0 || p > 100 {
+		return 0, fmt.Errorf("flags: line %d: invalid percentage %q", n, s)
+	}
+	return p, nil
+}
+
+// Controller applies the changes of the feature flags.
+type Controller struct {
+	// Drain takes the instance out of service.
+	Drain func()
+
+	// SetKillSwitch enables or updates a kill switch.
+	SetKillSwitch func(k KillSwitch)
+
+	// ClearKillSwitch disables the kill switch of a service.
+	ClearKillSwitch func(service string)
+
+	// SetFault enables or updates the fault injection for a service.
+	SetFault func(f Fault)
+
+	// ClearFault disables the fault injection for a service.
+	ClearFault func(service string)
+
+	// SetMirror sets the percentage of the mirrored requests
+	// to a service.
+	SetMirror func(service string, percent float64)
+
+	// ClearMirror mirrors all requests to a service again.
+	ClearMirror func(service string)
+
+	drained bool
+	kills   map[string]KillSwitch
+	faults  map[string]Fault
+	mirrors map[string]float64
+}
+
+// Update applies the differences between f and the flags of the
+// previous update. Draining cannot be undone.
+func (c *Controller) Update(f Flags) {
+	switch {
+	case f.Drain && !c.drained:
+		log.Print("[INFO] flags: Draining")
+		c.drained = true
+		if c.Drain != nil {
+			c.Drain()
+		}
+	case !f.Drain && c.drained:
+		log.Print("[WARN] flags: Draining cannot be undone. Restart fabio instead")
+	}
+
+	if c.kills == nil {
+		c.kills = map[string]KillSwitch{}
+	}
+	update(keys(c.kills), keys(f.KillSwitches),
+		func(svc string) bool {
+			old, ok := c.kills[svc]
+			k := f.KillSwitches[svc]
+			return ok && old.Status == k.Status && old.Until.Equal(k.Until)
+		},
+		func(svc string) {
+			c.kills[svc] = f.KillSwitches[svc]
+			if c.SetKillSwitch != nil {
+				c.SetKillSwitch(f.KillSwitches[svc])
+			}
+		},
+		func(svc string) {
+			delete(c.kills, svc)
+			if c.ClearKillSwitch != nil {
+				c.ClearKillSwitch(svc)
+			}
+		},
+	)
+
+	if c.faults == nil {
+		c.faults = map[string]Fault{}
+	}
+	update(keys(c.faults), keys(f.Faults),
+		func(svc string) bool { old, ok := c.faults[svc]; return ok && old == f.Faults[svc] },
+		func(svc string) {
+			c.faults[svc] = f.Faults[svc]
+			if c.SetFault != nil {
+				c.SetFault(f.Faults[svc])
+			}
+		},
+		func(svc string) {
+			delete(c.faults, svc)
+			if c.ClearFault != nil {
+				c.ClearFault(svc)
+			}
+		},
+	)
+
+	if c.mirrors == nil {
+		c.mirrors = map[string]float64{}
+	}
+	update(keys(c.mirrors), keys(f.Mirrors),
+		func(svc string) bool { old, ok := c.mirrors[svc]; return ok && old == f.Mirrors[svc] },
+		func(svc string) {
+			c.mirrors[svc] = f.Mirrors[svc]
+			if c.SetMirror != nil {
+				c.SetMirror(svc, f.Mirrors[svc])
+			}
+		},
+		func(svc string) {
+			delete(c.mirrors, svc)
+			if c.ClearMirror != nil {
+				c.ClearMirror(svc)
+			}
+		},
+	)
+}
+
+// update clears the services of cur which are not in next and then
+// sets the services of next which have changed, both sorted by name.
+func update(cur, next []string, unchanged func(string) bool, set, clear func(string)) {
+	for _, svc := range cur {
+		if !contains(next, svc) {
+			clear(svc)
+		}
+	}
+	for _, svc := range next {
+		if !unchanged(svc) {
+			set(svc)
+		}
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+// keys returns the sorted keys of a map with service names as keys.
+func keys(m interface{}) []string {
+	var list []string
+	switch x := m.(type) {
+	case map[string]KillSwitch:
+		for k := range x {
+			list = append(list, k)
+		}
+	case map[string]Fault:
+		for k := range x {
+			list = append(list, k)
+		}
+	case map[string]float64:
+		for k := range x {
+			list = append(list, k)
+		}
+	}
+	sort.Strings(list)
+	return list
+}
+
+// Run applies the feature flags which are published on w.
+// It does not return.
+func (c *Controller) Run(w *registry.Watch) {
+	for range w.C() {
+		f, err := Parse(w.Latest().Value)
+		if err != nil {
+			log.Printf("[WARN] %s", err)
+			continue
+		}
+		c.Update(f)
+	}
+}
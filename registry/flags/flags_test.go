@@ -0,0 +1,96 @@
+package flags
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	until := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		in    string
+		flags Flags
+		err   bool
+	}{
+		{in: "", flags: Flags{KillSwitches: map[string]KillSwitch{}, Faults: map[string]Fault{}, Mirrors: map[string]float64{}}},
+		{in: "# comment\n\n", flags: Flags{KillSwitches: map[string]KillSwitch{}, Faults: map[string]Fault{}, Mirrors: map[string]float64{}}},
+		{in: "drain", flags: Flags{Drain: true, KillSwitches: map[string]KillSwitch{}, Faults: map[string]Fault{}, Mirrors: map[string]float64{}}},
+		{
+			in: "killswitch svc-a\nkillswitch svc-b status 502 until 2020-01-01T12:00:00Z",
+			flags: Flags{KillSwitches: map[string]KillSwitch{
+				"svc-a": {Service: "svc-a"},
+				"svc-b": {Service: "svc-b", Status: 502, Until: until},
+			}, Faults: map[string]Fault{}, Mirrors: map[string]float64{}},
+		},
+		{
+			in: "fault svc-a delay 500ms\nfault svc-b abort 503 percent 2.5\nmirror svc-a percent 10",
+			flags: Flags{KillSwitches: map[string]KillSwitch{}, Faults: map[string]Fault{
+				"svc-a": {Service: "svc-a", Delay: 500 * time.Millisecond, Percent: 100},
+				"svc-b": {Service: "svc-b", Abort: 503, Percent: 2.5},
+			}, Mirrors: map[string]float64{"svc-a": 10}},
+		},
+		{in: "killswitch", err: true},
+		{in: "killswitch svc-a status 42", err: true},
+		{in: "killswitch svc-a until tomorrow", err: true},
+		{in: "drain now", err: true},
+		{in: "mirror svc-a 0.1", err: true},
+		{in: "mirror svc-a percent 101", err: true},
+		{in: "fault svc-a", err: true},
+		{in: "fault svc-a percent 10", err: true},
+		{in: "fault svc-a delay -1s", err: true},
+		{in: "fault svc-a abort 42", err: true},
+	}
+
+	for i, tt := range tests {
+		f, err := Parse(tt.in)
+		if got, want := err != nil, tt.err; got != want {
+			t.Fatalf("%d: got error %v want %v", i, err, want)
+		}
+		if tt.err {
+			continue
+		}
+		if got, want := f, tt.flags; !reflect.DeepEqual(got, want) {
+			t.Fatalf("%d: got %#v want %#v", i, got, want)
+		}
+	}
+}
+
+func TestController(t *testing.T) {
+	var calls []string
+	c := &Controller{
+		Drain:           func() { calls = append(calls, "drain") },
+		SetKillSwitch:   func(k KillSwitch) { calls = append(calls, "set "+k.Service) },
+		ClearKillSwitch: func(svc string) { calls = append(calls, "clear "+svc) },
+		SetFault:        func(f Fault) { calls = append(calls, "fault "+f.Service) },
+		ClearFault:      func(svc string) { calls = append(calls, "clear fault "+svc) },
+		SetMirror:       func(svc string, p float64) { calls = append(calls, fmt.Sprintf("mirror %s %g", svc, p)) },
+		ClearMirror:     func(svc string) { calls = append(calls, "clear mirror "+svc) },
+	}
+
+	update := func(in string, want ...string) {
+		t.Helper()
+		f, err := Parse(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		calls = nil
+		c.Update(f)
+		if got := strings.Join(calls, ","); got != strings.Join(want, ",") {
+			t.Fatalf("got %q want %q", got, strings.Join(want, ","))
+		}
+	}
+
+	update("killswitch b\nkillswitch a", "set a", "set b")
+	update("killswitch b\nkillswitch a")
+	update("killswitch a status 502", "clear b", "set a")
+	update("drain\nkillswitch a status 502", "drain")
+	update("drain", "clear a")
+	update("")
+	update("fault a abort 503\nmirror a percent 10", "fault a", "mirror a 10")
+	update("fault a abort 503 percent 100\nmirror a percent 20", "mirror a 20")
+	update("fault a delay 1s", "fault a", "clear mirror a")
+	update("", "clear fault a")
+}
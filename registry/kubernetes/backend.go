@@ -0,0 +1,228 @@
+// Package kubernetes implements a registry backend which builds the
+// routing table from annotated Kubernetes services and ingresses.
+package kubernetes
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/url"
+	"time"
+
+	"github.com/fabiolb/fabio/config"
+	"github.com/fabiolb/fabio/registry"
+)
+
+// debounce is the time to wait for more changes before the
+// routing table is rebuilt.
+const debounce = 100 * time.Millisecond
+
+// retryInterval is the time to wait after a failed request.
+const retryInterval = 5 * time.Second
+
+type be struct {
+	c   *client
+	cfg *config.Kubernetes
+}
+
+func NewBackend(cfg *config.Kubernetes) (registry.Backend, error) {
+	var rc *restConfig
+	var err error
+	if cfg.Kubeconfig != "" {
+		rc, err = kubeconfig(cfg.Kubeconfig, cfg.Context)
+	} else {
+		rc, err = inClusterConfig()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c := newClient(rc)
+
+	// ping the API server
+	var version struct{ GitVersion string }
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := c.list(ctx, "/version", nil, &version); err != nil {
+		return nil, err
+	}
+
+	ns := cfg.Namespace
+	if ns == "" {
+		ns = "all namespaces"
+	}
+	log.Printf("[INFO] kubernetes: Connecting to %s (%s) watching %s", rc.server, version.GitVersion, ns)
+	return &be{c: c, cfg: cfg}, nil
+}
+
+func (b *be) Register(services []string) error {
+	return nil
+}
+
+func (b *be) Deregister(serviceName string) error {
+	return nil
+}
+
+func (b *be) DeregisterAll() error {
+	return nil
+}
+
+func (b *be) ManualPaths() ([]string, error) {
+	return nil, nil
+}
+
+func (b *be) ReadManual(string) (value string, version uint64, err error) {
+	return "", 0, nil
+}
+
+func (b *be) WriteManual(path string, value string, version uint64) (ok bool, err error) {
+	return false, nil
+}
+
+func (b *be) WatchManual() *registry.Watch {
+	return registry.NewWatch()
+}
+
+func (b *be) WatchNoRouteHTML() *registry.Watch {
+	return registry.NewWatch()
+}
+
+// WatchServices watches the services, endpoints and optionally the
+// ingresses and rebuilds the routes from the cached resources on
+// every change.
+func (b *be) WatchServices() *registry.Watch {
+	w := registry.NewWatch()
+
+	changed := make(chan struct{}, 1)
+	notify := func() {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+
+	svcs, eps := newCache(), newCache()
+	caches := []*cache{svcs, eps}
+	go b.watchResource(b.path("/api/v1", "services"), b.selector(), svcs, notify)
+	go b.watchResource(b.path("/api/v1", "endpoints"), nil, eps, notify)
+
+	// the ingress rules can refer to services without
+	// the labels of the selector.
+	var all, ings *cache
+	if b.cfg.Ingress {
+		all, ings = newCache(), newCache()
+		caches = append(caches, all, ings)
+		go b.watchResource(b.path("/api/v1", "services"), nil, all, notify)
+		go b.watchResource(b.path("/apis/networking.k8s.io/v1", "ingresses"), nil, ings, notify)
+	}
+
+	go func() {
+		for range changed {
+			time.Sleep(debounce)
+			select {
+			case <-changed:
+			default:
+			}
+
+			// publish the routes only once all resources are listed
+			synced := true
+			for _, c := range caches {
+				synced = synced && c.isSynced()
+			}
+			if !synced {
+				continue
+			}
+
+			cfg, err := b.build(svcs, eps, all, ings)
+			if err != nil {
+				log.Printf("[WARN] kubernetes: Error building routes. %s", err)
+				continue
+			}
+			w.Publish(cfg)
+		}
+	}()
+	return w
+}
+
+// watchResource keeps the cache of the resources at path up to date
+// and notifies about the changes. The watch is restarted with a new
+// list request after an error.
+func (b *be) watchResource(path string, q url.Values, c *cache, notify func()) {
+	ctx := context.Background()
+	apply := func(typ string, obj json.RawMessage) error {
+		if err := c.apply(typ, obj); err != nil {
+			return err
+		}
+		notify()
+		return nil
+	}
+	for {
+		var l struct {
+			Metadata listMeta
+			Items    []json.RawMessage
+		}
+		err := b.c.list(ctx, path, q, &l)
+		if err == nil {
+			err = c.replace(l.Items)
+		}
+		if err != nil {
+			log.Printf("[WARN] kubernetes: Error listing %s. %s", path, err)
+			time.Sleep(retryInterval)
+			continue
+		}
+		notify()
+
+		rv := l.Metadata.ResourceVersion
+		for {
+			var err error
+			rv, err = b.c.watch(ctx, path, q, rv, apply)
+			if err != nil {
+				// 410 Gone means that the resource version is too
+				// old and the resources need to be listed again.
+				if e, ok := err.(*statusError); !ok || e.code != 410 {
+					log.Printf("[WARN] kubernetes: Error watching %s. %s", path, err)
+					time.Sleep(retryInterval)
+				}
+				break
+			}
+		}
+	}
+}
+
+// build builds the routes from the cached resources. all and ings
+// are nil if the ingresses are not routed.
+func (b *be) build(svcs, eps, all, ings *cache) (string, error) {
+	r := routes{prefix: b.cfg.Prefix}
+	if err := svcs.decode(&r.services); err != nil {
+		return "", err
+	}
+	if err := eps.decode(&r.endpoints); err != nil {
+		return "", err
+	}
+	if b.cfg.Ingress {
+		if err := all.decode(&r.ingressServices); err != nil {
+			return "", err
+		}
+		if err := ings.decode(&r.ingresses); err != nil {
+			return "", err
+		}
+		r.ingressClass = b.cfg.IngressClass
+	}
+	return r.build(), nil
+}
+
+// path returns the path of the resources in the configured
+// namespace or in all namespaces.
+func (b *be) path(api, resource string) string {
+	if b.cfg.Namespace == "" {
+		return api + "/" + resource
+	}
+	return api + "/namespaces/" + url.PathEscape(b.cfg.Namespace) + "/" + resource
+}
+
+func (b *be) selector() url.Values {
+	if b.cfg.Selector == "" {
+		return nil
+	}
+	return url.Values{"labelSelector": {b.cfg.Selector}}
+}
@@ -0,0 +1,138 @@
+package kubernetes
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fabiolb/fabio/config"
+	"github.com/fabiolb/fabio/registry"
+)
+
+// fakeAPI serves the services and endpoints and streams
+// a watch event for the endpoints on demand.
+type fakeAPI struct {
+	mu        sync.Mutex
+	endpoints string
+	events    chan string
+	stop      chan struct{}
+	selector  string
+	auth      string
+	lists     int
+}
+
+func (f *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.auth = r.Header.Get("Authorization")
+	eps := f.endpoints
+	f.mu.Unlock()
+
+	watch := r.URL.Query().Get("watch") == "true"
+	switch {
+	case r.URL.Path == "/version":
+		fmt.Fprint(w, `{"gitVersion": "v1.20.0"}`)
+	case r.URL.Path == "/api/v1/namespaces/default/services" && !watch:
+		f.mu.Lock()
+		f.selector = r.URL.Query().Get("labelSelector")
+		f.mu.Unlock()
+		fmt.Fprint(w, `{"metadata": {"resourceVersion": "1"}, "items": [
+			{"metadata": {"name": "web", "namespace": "default", "annotations": {"fabio.io/urlprefix": "/"}},
+			 "spec": {"ports": [{"port": 80}]}}
+		]}`)
+	case r.URL.Path == "/api/v1/namespaces/default/endpoints" && !watch:
+		f.mu.Lock()
+		f.lists++
+		f.mu.Unlock()
+		fmt.Fprintf(w, `{"metadata": {"resourceVersion": "1"}, "items": [%s]}`, eps)
+	case r.URL.Path == "/api/v1/namespaces/default/endpoints" && watch:
+		w.(http.Flusher).Flush()
+		for {
+			select {
+			case ev := <-f.events:
+				fmt.Fprintln(w, ev)
+				w.(http.Flusher).Flush()
+			case <-f.stop:
+				return
+			}
+		}
+	case watch:
+		// block the other watches until the test ends
+		<-f.stop
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// endpointsJSON returns the endpoints of the web service.
+func endpointsJSON(ips ...string) string {
+	var addrs []string
+	for _, ip := range ips {
+		addrs = append(addrs, fmt.Sprintf(`{"ip": %q}`, ip))
+	}
+	return fmt.Sprintf(`{"metadata": {"name": "web", "namespace": "default", "resourceVersion": "2"}, "subsets": [{"addresses": [%s], "ports": [{"port": 8080}]}]}`, strings.Join(addrs, ","))
+}
+
+func (f *fakeAPI) setEndpoints(ips ...string) {
+	f.mu.Lock()
+	f.endpoints = endpointsJSON(ips...)
+	f.mu.Unlock()
+}
+
+func TestWatchServices(t *testing.T) {
+	api := &fakeAPI{events: make(chan string), stop: make(chan struct{})}
+	api.setEndpoints("10.0.0.1")
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+	defer close(api.stop)
+
+	token := "abc"
+	b := &be{
+		c:   newClient(&restConfig{server: srv.URL, token: func() (string, error) { return token, nil }}),
+		cfg: &config.Kubernetes{Namespace: "default", Selector: "app=web", Prefix: "fabio.io/urlprefix"},
+	}
+
+	w := b.WatchServices()
+	snap := waitFor(t, w.Next, 0, "route add web.default / http://10.0.0.1:8080/")
+
+	// the watch events update the cached resources
+	api.events <- `{"type": "MODIFIED", "object": ` + endpointsJSON("10.0.0.1", "10.0.0.2") + `}`
+	snap = waitFor(t, w.Next, snap, "route add web.default / http://10.0.0.2:8080/\nroute add web.default / http://10.0.0.1:8080/")
+	api.events <- `{"type": "DELETED", "object": ` + endpointsJSON() + `}`
+	waitFor(t, w.Next, snap, "")
+
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	if got, want := api.lists, 1; got != want {
+		t.Fatalf("got %d list requests want %d", got, want)
+	}
+	if got, want := api.selector, "app=web"; got != want {
+		t.Fatalf("got selector %q want %q", got, want)
+	}
+	if got, want := api.auth, "Bearer abc"; got != want {
+		t.Fatalf("got authorization %q want %q", got, want)
+	}
+}
+
+// waitFor waits until the watch publishes the wanted value and
+// returns its sequence number.
+func waitFor(t *testing.T, next func(uint64) registry.Snapshot, seq uint64, want string) uint64 {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		c := make(chan registry.Snapshot, 1)
+		go func() { c <- next(seq) }()
+		select {
+		case s := <-c:
+			if s.Value == want {
+				return s.Seq
+			}
+			seq = s.Seq
+		case <-timeout:
+			t.Fatalf("timeout waiting for %q", want)
+		}
+	}
+}
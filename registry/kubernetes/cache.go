@@ -0,0 +1,97 @@
+package kubernetes
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"sync"
+)
+
+// cache contains the resources of a watched path like the store
+// of an informer. It is filled by a list request and updated with
+// the events of the watch so that the routes can be rebuilt
+// without listing the resources again.
+type cache struct {
+	mu     sync.Mutex
+	synced bool
+	items  map[string]json.RawMessage
+}
+
+func newCache() *cache {
+	return &cache{items: map[string]json.RawMessage{}}
+}
+
+// objectKey returns the namespace and the name of a resource.
+func objectKey(obj json.RawMessage) (string, error) {
+	var o struct{ Metadata objectMeta }
+	if err := json.Unmarshal(obj, &o); err != nil {
+		return "", err
+	}
+	return o.Metadata.Namespace + "/" + o.Metadata.Name, nil
+}
+
+// replace replaces the resources with the result of a list request.
+func (c *cache) replace(objs []json.RawMessage) error {
+	items := map[string]json.RawMessage{}
+	for _, obj := range objs {
+		k, err := objectKey(obj)
+		if err != nil {
+			return err
+		}
+		items[k] = obj
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.items = items
+	c.synced = true
+	return nil
+}
+
+// apply updates the resources with a watch event.
+func (c *cache) apply(typ string, obj json.RawMessage) error {
+	k, err := objectKey(obj)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch typ {
+	case "ADDED", "MODIFIED":
+		c.items[k] = obj
+	case "DELETED":
+		delete(c.items, k)
+	}
+	return nil
+}
+
+// isSynced returns true after the first list request has completed.
+func (c *cache) isSynced() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.synced
+}
+
+// decode decodes the resources sorted by namespace and name into
+// the slice v.
+func (c *cache) decode(v interface{}) error {
+	c.mu.Lock()
+	var keys []string
+	for k := range c.items {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b bytes.Buffer
+	b.WriteByte('[')
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.Write(c.items[k])
+	}
+	b.WriteByte(']')
+	c.mu.Unlock()
+
+	return json.Unmarshal(b.Bytes(), v)
+}
@@ -0,0 +1,146 @@
+package kubernetes
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// client is a minimal client for the Kubernetes API which
+// supports listing and watching resources.
+type client struct {
+	cfg  *restConfig
+	http *http.Client
+}
+
+func newClient(cfg *restConfig) *client {
+	return &client{
+		cfg: cfg,
+		http: &http.Client{
+			Transport: &http.Transport{
+				Proxy:               http.ProxyFromEnvironment,
+				TLSClientConfig:     cfg.tls,
+				TLSHandshakeTimeout: 10 * time.Second,
+			},
+		},
+	}
+}
+
+// statusError is returned for responses with an error status.
+type statusError struct {
+	code int
+	msg  string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("kubernetes: %d %s", e.code, e.msg)
+}
+
+func (c *client) do(ctx context.Context, path string, q url.Values) (*http.Response, error) {
+	u := c.cfg.server + path
+	if len(q) > 0 {
+		u += "?" + q.Encode()
+	}
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/json")
+	if c.cfg.token != nil {
+		token, err := c.cfg.token()
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else if c.cfg.username != "" {
+		req.SetBasicAuth(c.cfg.username, c.cfg.password)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		var status struct{ Message string }
+		b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		if json.Unmarshal(b, &status) != nil || status.Message == "" {
+			status.Message = resp.Status
+		}
+		return nil, &statusError{resp.StatusCode, status.Message}
+	}
+	return resp, nil
+}
+
+// list decodes the list of resources at path into v.
+func (c *client) list(ctx context.Context, path string, q url.Values, v interface{}) error {
+	resp, err := c.do(ctx, path, q)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// watchEvent is a change notification of the watch API.
+type watchEvent struct {
+	Type   string
+	Object json.RawMessage
+}
+
+// watch calls fn with the type and the object of every change of the
+// resources at path after resourceVersion until the server closes
+// the connection or the context is cancelled. It returns the last
+// seen resource version.
+func (c *client) watch(ctx context.Context, path string, q url.Values, resourceVersion string, fn func(typ string, obj json.RawMessage) error) (string, error) {
+	wq := url.Values{}
+	for k, v := range q {
+		wq[k] = v
+	}
+	wq.Set("watch", "true")
+	wq.Set("resourceVersion", resourceVersion)
+	wq.Set("allowWatchBookmarks", "true")
+	wq.Set("timeoutSeconds", "300")
+
+	resp, err := c.do(ctx, path, wq)
+	if err != nil {
+		return resourceVersion, err
+	}
+	defer resp.Body.Close()
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var ev watchEvent
+		if err := dec.Decode(&ev); err != nil {
+			if err == io.EOF {
+				return resourceVersion, nil
+			}
+			return resourceVersion, err
+		}
+		var obj struct {
+			Metadata listMeta
+			Code     int
+		}
+		if err := json.Unmarshal(ev.Object, &obj); err != nil {
+			return resourceVersion, err
+		}
+		switch ev.Type {
+		case "ERROR":
+			return resourceVersion, &statusError{obj.Code, "watch error"}
+		case "BOOKMARK":
+		default:
+			if err := fn(ev.Type, ev.Object); err != nil {
+				return resourceVersion, err
+			}
+		}
+		if v := obj.Metadata.ResourceVersion; v != "" {
+			resourceVersion = v
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package kubernetes
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Location of the service account credentials within a pod.
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// restConfig contains the address of the API server and the
+// credentials for the requests.
+type restConfig struct {
+	server    string
+	namespace string
+	tls       *tls.Config
+
+	// token returns the bearer token for a request.
+	// It is read on every request since service
+	// account tokens are rotated.
+	token func() (string, error)
+
+	username, password string
+}
+
+// inClusterConfig returns the configuration for the service
+// account of the pod fabio is running in.
+func inClusterConfig() (*restConfig, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("not running in a kubernetes cluster. KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT are not set")
+	}
+
+	ca, err := ioutil.ReadFile(filepath.Join(serviceAccountDir, "ca.crt"))
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.New("invalid service account CA certificate")
+	}
+
+	tokenFile := filepath.Join(serviceAccountDir, "token")
+	if _, err := os.Stat(tokenFile); err != nil {
+		return nil, err
+	}
+
+	ns, _ := ioutil.ReadFile(filepath.Join(serviceAccountDir, "namespace"))
+	return &restConfig{
+		server:    "https://" + net.JoinHostPort(host, port),
+		namespace: strings.TrimSpace(string(ns)),
+		tls:       &tls.Config{RootCAs: pool},
+		token:     readToken(tokenFile),
+	}, nil
+}
+
+func readToken(path string) func() (string, error) {
+	return func() (string, error) {
+		b, err := ioutil.ReadFile(path)
+		return strings.TrimSpace(string(b)), err
+	}
+}
+
+// kubeconfig returns the configuration for the given context of
+// a kubeconfig file. The current context is used if context is empty.
+func kubeconfig(path, context string) (*restConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := parseYAML(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	root, _ := doc.(map[string]interface{})
+	dir := filepath.Dir(path)
+
+	if context == "" {
+		context = str(root, "current-context")
+	}
+	if context == "" {
+		return nil, fmt.Errorf("%s: no current context", path)
+	}
+	ctx := named(root, "contexts", "context", context)
+	if ctx == nil {
+		return nil, fmt.Errorf("%s: context %q not found", path, context)
+	}
+	cluster := named(root, "clusters", "cluster", str(ctx, "cluster"))
+	if cluster == nil {
+		return nil, fmt.Errorf("%s: cluster %q not found", path, str(ctx, "cluster"))
+	}
+	user := named(root, "users", "user", str(ctx, "user"))
+	if user == nil {
+		user = map[string]interface{}{}
+	}
+
+	cfg := &restConfig{
+		server:    strings.TrimSuffix(str(cluster, "server"), "/"),
+		namespace: str(ctx, "namespace"),
+		tls: &tls.Config{
+			ServerName:         str(cluster, "tls-server-name"),
+			InsecureSkipVerify: str(cluster, "insecure-skip-tls-verify") == "true",
+		},
+		username: str(user, "username"),
+		password: str(user, "password"),
+	}
+	if cfg.server == "" {
+		return nil, fmt.Errorf("%s: cluster %q has no server", path, str(ctx, "cluster"))
+	}
+
+	ca, err := fileOrData(cluster, "certificate-authority", dir)
+	if err != nil {
+		return nil, err
+	}
+	if ca != nil {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("%s: invalid certificate authority", path)
+		}
+		cfg.tls.RootCAs = pool
+	}
+
+	cert, err := fileOrData(user, "client-certificate", dir)
+	if err != nil {
+		return nil, err
+	}
+	key, err := fileOrData(user, "client-key", dir)
+	if err != nil {
+		return nil, err
+	}
+	if cert != nil || key != nil {
+		c, err := tls.X509KeyPair(cert, key)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid client certificate. %s", path, err)
+		}
+		cfg.tls.Certificates = []tls.Certificate{c}
+	}
+
+	switch {
+	case str(user, "token") != "":
+		token := str(user, "token")
+		cfg.token = func() (string, error) { return token, nil }
+	case str(user, "tokenFile") != "":
+		cfg.token = readToken(abs(dir, str(user, "tokenFile")))
+	case user["exec"] != nil || user["auth-provider"] != nil:
+		return nil, fmt.Errorf("%s: exec and auth-provider credentials are not supported", path)
+	}
+	return cfg, nil
+}
+
+// named returns the value of the field of the element with
+// the given name in the list, e.g. a context in 'contexts'.
+func named(root map[string]interface{}, list, field, name string) map[string]interface{} {
+	items, _ := root[list].([]interface{})
+	for _, item := range items {
+		m, _ := item.(map[string]interface{})
+		if str(m, "name") == name {
+			v, _ := m[field].(map[string]interface{})
+			return v
+		}
+	}
+	return nil
+}
+
+func str(m map[string]interface{}, key string) string {
+	s, _ := m[key].(string)
+	return s
+}
+
+// fileOrData returns the content of the file in 'key' or the
+// base64 decoded value of 'key-data'.
+func fileOrData(m map[string]interface{}, key, dir string) ([]byte, error) {
+	if s := str(m, key+"-data"); s != "" {
+		b, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s-data. %s", key, err)
+		}
+		return b, nil
+	}
+	if s := str(m, key); s != "" {
+		return ioutil.ReadFile(abs(dir, s))
+	}
+	return nil, nil
+}
+
+// abs resolves paths relative to the directory of the kubeconfig file.
+func abs(dir, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
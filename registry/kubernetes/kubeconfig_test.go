@@ -0,0 +1,146 @@
+package kubernetes
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseYAML(t *testing.T) {
+	tests := []struct {
+		desc string
+		in   string
+		want interface{}
+		err  bool
+	}{
+		{
+			desc: "mapping",
+			in:   "a: b\nc: 'it''s'\nd: \"x\\ty\" # comment\ne: http://host:80/#frag\n",
+			want: map[string]interface{}{"a": "b", "c": "it's", "d": "x\ty", "e": "http://host:80/#frag"},
+		},
+		{
+			desc: "nested",
+			in:   "a:\n  b:\n    c: d\n  e: []\nf: {}\n",
+			want: map[string]interface{}{"a": map[string]interface{}{"b": map[string]interface{}{"c": "d"}, "e": []interface{}{}}, "f": map[string]interface{}{}},
+		},
+		{
+			desc: "sequence of mappings",
+			in:   "items:\n- name: a\n  value:\n    x: 1\n-   name: b\n- c\n-\n  - d\n",
+			want: map[string]interface{}{"items": []interface{}{
+				map[string]interface{}{"name": "a", "value": map[string]interface{}{"x": "1"}},
+				map[string]interface{}{"name": "b"},
+				"c",
+				[]interface{}{"d"},
+			}},
+		},
+		{
+			desc: "json",
+			in:   `{"a": [1, true, null, "x"]}`,
+			want: map[string]interface{}{"a": []interface{}{"1", "true", "", "x"}},
+		},
+		{
+			desc: "colons",
+			in:   "\"a: b\": \"c: d\"\n'e:f': g:h\nurl: https://host:6443\nlist:\n- \"x: y\"\n- z:1\n",
+			want: map[string]interface{}{"a: b": "c: d", "e:f": "g:h", "url": "https://host:6443", "list": []interface{}{"x: y", "z:1"}},
+		},
+		{
+			desc: "hashes",
+			in:   "a: \"x # y\"\nb: 'x # y' # comment\nc: x#y\n\"d#e\": f\ng: \"x\\\" # y\"\nh: 'it'' # s'\n# comment\n",
+			want: map[string]interface{}{"a": "x # y", "b": "x # y", "c": "x#y", "d#e": "f", "g": "x\" # y", "h": "it' # s"},
+		},
+		{
+			desc: "leading dashes",
+			in:   "a: -x\nb: \"- y\"\nc:\n- -z\n- '- w'\n- \"-\"\n",
+			want: map[string]interface{}{"a": "-x", "b": "- y", "c": []interface{}{"-z", "- w", "-"}},
+		},
+		{desc: "bad indentation", in: "a: b\n  c: d\n", err: true},
+		{desc: "unterminated quote", in: "a: 'b\n", err: true},
+		{desc: "block scalar", in: "a: |\n  b\n", err: true},
+		{desc: "no key", in: "a\n", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			got, err := parseYAML([]byte(tt.in))
+			if gotErr := err != nil; gotErr != tt.err {
+				t.Fatalf("got error %v want error %v", err, tt.err)
+			}
+			if tt.err {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %#v want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKubeconfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubeconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "token"), []byte("file-token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := `apiVersion: v1
+kind: Config
+current-context: dev
+clusters:
+- cluster:
+    server: https://dev.example.com:6443/
+    insecure-skip-tls-verify: true
+  name: dev-cluster
+- cluster:
+    server: https://prod.example.com
+  name: prod-cluster
+contexts:
+- context:
+    cluster: dev-cluster
+    user: dev-user
+    namespace: web
+  name: dev
+- context:
+    cluster: prod-cluster
+    user: prod-user
+  name: prod
+users:
+- name: dev-user
+  user:
+    token: secret
+- name: prod-user
+  user:
+    tokenFile: token
+`
+	path := filepath.Join(dir, "config")
+	if err := ioutil.WriteFile(path, []byte(cfg), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	rc, err := kubeconfig(path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, _ := rc.token()
+	if rc.server != "https://dev.example.com:6443" || rc.namespace != "web" || !rc.tls.InsecureSkipVerify || token != "secret" {
+		t.Fatalf("got server %q namespace %q insecure %v token %q", rc.server, rc.namespace, rc.tls.InsecureSkipVerify, token)
+	}
+
+	rc, err = kubeconfig(path, "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, _ = rc.token()
+	if rc.server != "https://prod.example.com" || rc.tls.InsecureSkipVerify || token != "file-token" {
+		t.Fatalf("got server %q insecure %v token %q", rc.server, rc.tls.InsecureSkipVerify, token)
+	}
+
+	if _, err := kubeconfig(path, "test"); err == nil {
+		t.Fatal("expected error for unknown context")
+	}
+}
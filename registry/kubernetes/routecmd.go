@@ -0,0 +1,290 @@
+package kubernetes
+
+import (
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/fabiolb/fabio/registry"
+)
+
+// The types below contain the fields of the Kubernetes
+// resources which are used to build the routes.
+
+type objectMeta struct {
+	Name        string
+	Namespace   string
+	Annotations map[string]string
+}
+
+type listMeta struct {
+	ResourceVersion string
+}
+
+type serviceList struct {
+	Metadata listMeta
+	Items    []service
+}
+
+type service struct {
+	Metadata objectMeta
+	Spec     struct {
+		Ports []servicePort
+	}
+}
+
+type servicePort struct {
+	Name string
+	Port int
+}
+
+type endpointsList struct {
+	Metadata listMeta
+	Items    []endpoints
+}
+
+type endpoints struct {
+	Metadata objectMeta
+	Subsets  []struct {
+		Addresses []struct {
+			IP string
+		}
+		Ports []struct {
+			Name string
+			Port int
+		}
+	}
+}
+
+type ingressList struct {
+	Metadata listMeta
+	Items    []ingress
+}
+
+type ingress struct {
+	Metadata objectMeta
+	Spec     struct {
+		IngressClassName string
+		Rules            []struct {
+			Host string
+			HTTP *struct {
+				Paths []struct {
+					Path    string
+					Backend struct {
+						Service *struct {
+							Name string
+							Port struct {
+								Name   string
+								Number int
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+// ingressClassAnnotation is the legacy way to select the ingress controller.
+const ingressClassAnnotation = "kubernetes.io/ingress.class"
+
+// ingressOptsAnnotation contains the route options for all rules of an ingress.
+const ingressOptsAnnotation = "fabio.io/opts"
+
+// routes contains the resources from which the routing table is built.
+type routes struct {
+	// prefix is the prefix of the annotations which contain the routes.
+	prefix string
+
+	// ingressClass selects the ingresses which are routed by fabio.
+	ingressClass string
+
+	services  []service
+	endpoints []endpoints
+	ingresses []ingress
+
+	// ingressServices are the services the ingress rules can refer
+	// to. If it is nil the annotated services are used.
+	ingressServices []service
+}
+
+// build returns the route commands for the annotated services
+// and the ingress rules sorted with the most specific first.
+func (r routes) build() string {
+	eps := map[string]endpoints{}
+	for _, ep := range r.endpoints {
+		eps[ep.Metadata.Namespace+"/"+ep.Metadata.Name] = ep
+	}
+	all := r.ingressServices
+	if all == nil {
+		all = r.services
+	}
+	svcs := map[string]service{}
+	for _, svc := range all {
+		svcs[svc.Metadata.Namespace+"/"+svc.Metadata.Name] = svc
+	}
+
+	var config []string
+	for _, svc := range r.services {
+		ep := eps[svc.Metadata.Namespace+"/"+svc.Metadata.Name]
+		for _, line := range annotationRoutes(svc.Metadata.Annotations, r.prefix) {
+			config = append(config, serviceRoute(svc, ep, line)...)
+		}
+	}
+
+	for _, ing := range r.ingresses {
+		class := ing.Spec.IngressClassName
+		if class == "" {
+			class = ing.Metadata.Annotations[ingressClassAnnotation]
+		}
+		if r.ingressClass != "" && class != r.ingressClass {
+			continue
+		}
+		config = append(config, ingressRoutes(ing, svcs, eps)...)
+	}
+
+	return registry.SortRoutes(config)
+}
+
+// annotationRoutes returns the routes in the values of the annotations
+// whose key starts with prefix sorted by key. An annotation can contain
+// multiple routes on separate lines.
+func annotationRoutes(annotations map[string]string, prefix string) []string {
+	var keys []string
+	for k := range annotations {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var lines []string
+	for _, k := range keys {
+		for _, l := range strings.Split(annotations[k], "\n") {
+			if l = strings.TrimSpace(l); l != "" {
+				lines = append(lines, l)
+			}
+		}
+	}
+	return lines
+}
+
+// serviceRoute builds the route commands for a route annotation of
+// a service in the form of 'host/path[ opts]' or ':port[ opts]'. The
+// 'port' option selects the service port by name or number and
+// defaults to the first port of the service.
+func serviceRoute(svc service, ep endpoints, line string) []string {
+	src, opts, _ := registry.ParseURLPrefixTag(line, "", nil)
+
+	var port string
+	var ropts []string
+	for _, o := range strings.Fields(opts) {
+		if strings.HasPrefix(o, "port=") {
+			port = o[len("port="):]
+			continue
+		}
+		ropts = append(ropts, o)
+	}
+
+	sp, ok := findServicePort(svc.Spec.Ports, port)
+	if !ok {
+		log.Printf("[WARN] kubernetes: Service %s/%s has no port %q", svc.Metadata.Namespace, svc.Metadata.Name, port)
+		return nil
+	}
+	return routeCmds(serviceName(svc.Metadata), src, endpointAddrs(ep, sp, len(svc.Spec.Ports) == 1), ropts)
+}
+
+// ingressRoutes builds the route commands for the rules of an ingress.
+func ingressRoutes(ing ingress, svcs map[string]service, eps map[string]endpoints) []string {
+	opts := strings.Fields(ing.Metadata.Annotations[ingressOptsAnnotation])
+
+	var config []string
+	for _, rule := range ing.Spec.Rules {
+		if rule.HTTP == nil {
+			continue
+		}
+		for _, path := range rule.HTTP.Paths {
+			b := path.Backend.Service
+			if b == nil {
+				continue
+			}
+			key := ing.Metadata.Namespace + "/" + b.Name
+			svc, ok := svcs[key]
+			if !ok {
+				log.Printf("[WARN] kubernetes: Ingress %s/%s refers to unknown service %s", ing.Metadata.Namespace, ing.Metadata.Name, b.Name)
+				continue
+			}
+			port := b.Port.Name
+			if port == "" {
+				port = strconv.Itoa(b.Port.Number)
+			}
+			sp, ok := findServicePort(svc.Spec.Ports, port)
+			if !ok {
+				log.Printf("[WARN] kubernetes: Service %s has no port %q", key, port)
+				continue
+			}
+
+			src := path.Path
+			if src == "" {
+				src = "/"
+			}
+			src = strings.ToLower(rule.Host) + src
+			config = append(config, routeCmds(serviceName(svc.Metadata), src, endpointAddrs(eps[key], sp, len(svc.Spec.Ports) == 1), opts)...)
+		}
+	}
+	return config
+}
+
+// routeCmds builds a route command per address in the same way
+// as the other backends build them from the urlprefix tags.
+func routeCmds(name, src string, addrs []string, opts []string) []string {
+	var config []string
+	for _, addr := range addrs {
+		config = append(config, registry.RouteCmd(name, src, addr, nil, opts))
+	}
+	return config
+}
+
+// findServicePort returns the port with the given name or number
+// or the first port of the service if port is empty.
+func findServicePort(ports []servicePort, port string) (servicePort, bool) {
+	if len(ports) == 0 {
+		return servicePort{}, false
+	}
+	if port == "" || port == "0" {
+		return ports[0], true
+	}
+	for _, p := range ports {
+		if p.Name == port || strconv.Itoa(p.Port) == port {
+			return p, true
+		}
+	}
+	return servicePort{}, false
+}
+
+// endpointAddrs returns the sorted host:port addresses of the ready
+// endpoints of the service port. The endpoint ports are named like
+// the service ports. Unnamed ports are only used for services with
+// a single port.
+func endpointAddrs(ep endpoints, sp servicePort, single bool) []string {
+	var addrs []string
+	for _, s := range ep.Subsets {
+		for _, p := range s.Ports {
+			if p.Name != sp.Name && !(single && p.Name == "") {
+				continue
+			}
+			for _, a := range s.Addresses {
+				addrs = append(addrs, net.JoinHostPort(a.IP, strconv.Itoa(p.Port)))
+			}
+		}
+	}
+	sort.Strings(addrs)
+	return addrs
+}
+
+// serviceName returns the name of a service in the routing table.
+func serviceName(m objectMeta) string {
+	return m.Name + "." + m.Namespace
+}
@@ -0,0 +1,120 @@
+package kubernetes
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+const testServices = `{"items": [
+	{
+		"metadata": {"name": "web", "namespace": "default", "annotations": {
+			"fabio.io/urlprefix": "Example.com/ strip=/foo",
+			"fabio.io/urlprefix-admin": "/admin port=admin\n/redirect redirect=301,https://example.com/",
+			"other": "/ignored"
+		}},
+		"spec": {"ports": [{"name": "http", "port": 80}, {"name": "admin", "port": 8080}]}
+	},
+	{
+		"metadata": {"name": "db", "namespace": "default", "annotations": {"fabio.io/urlprefix": ":5432 proto=tcp"}},
+		"spec": {"ports": [{"port": 5432}]}
+	},
+	{
+		"metadata": {"name": "api", "namespace": "shop"},
+		"spec": {"ports": [{"name": "http", "port": 80}]}
+	}
+]}`
+
+const testEndpoints = `{"items": [
+	{
+		"metadata": {"name": "web", "namespace": "default"},
+		"subsets": [{
+			"addresses": [{"ip": "10.0.0.2"}, {"ip": "10.0.0.1"}],
+			"notReadyAddresses": [{"ip": "10.0.0.3"}],
+			"ports": [{"name": "http", "port": 8000}, {"name": "admin", "port": 9000}]
+		}]
+	},
+	{
+		"metadata": {"name": "db", "namespace": "default"},
+		"subsets": [{"addresses": [{"ip": "10.0.1.1"}], "ports": [{"port": 5432}]}]
+	},
+	{
+		"metadata": {"name": "api", "namespace": "shop"},
+		"subsets": [{"addresses": [{"ip": "10.0.2.1"}], "ports": [{"name": "http", "port": 3000}]}]
+	}
+]}`
+
+const testIngresses = `{"items": [
+	{
+		"metadata": {"name": "shop", "namespace": "shop", "annotations": {"fabio.io/opts": "strip=/api"}},
+		"spec": {
+			"ingressClassName": "fabio",
+			"rules": [{"host": "Shop.example.com", "http": {"paths": [
+				{"path": "/api", "backend": {"service": {"name": "api", "port": {"name": "http"}}}},
+				{"path": "/missing", "backend": {"service": {"name": "missing", "port": {"number": 80}}}}
+			]}}]
+		}
+	},
+	{
+		"metadata": {"name": "other", "namespace": "shop", "annotations": {"kubernetes.io/ingress.class": "nginx"}},
+		"spec": {"rules": [{"host": "other.example.com", "http": {"paths": [
+			{"path": "/", "backend": {"service": {"name": "api", "port": {"number": 80}}}}
+		]}}]}
+	}
+]}`
+
+func TestRoutesBuild(t *testing.T) {
+	var svcs serviceList
+	var eps endpointsList
+	var ings ingressList
+	for _, x := range []struct {
+		s string
+		v interface{}
+	}{{testServices, &svcs}, {testEndpoints, &eps}, {testIngresses, &ings}} {
+		if err := json.Unmarshal([]byte(x.s), x.v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := routes{
+		prefix:       "fabio.io/urlprefix",
+		ingressClass: "fabio",
+		services:     svcs.Items,
+		endpoints:    eps.Items,
+		ingresses:    ings.Items,
+	}
+
+	want := []string{
+		`route add web.default example.com/ http://10.0.0.2:8000/ opts "strip=/foo"`,
+		`route add web.default example.com/ http://10.0.0.1:8000/ opts "strip=/foo"`,
+		`route add web.default /redirect https://example.com/ opts "redirect=301"`,
+		`route add web.default /redirect https://example.com/ opts "redirect=301"`,
+		`route add web.default /admin http://10.0.0.2:9000/`,
+		`route add web.default /admin http://10.0.0.1:9000/`,
+		`route add db.default :5432 tcp://10.0.1.1:5432`,
+		`route add api.shop shop.example.com/api http://10.0.2.1:3000/ opts "strip=/api"`,
+	}
+	if got := r.build(); got != strings.Join(want, "\n") {
+		t.Fatalf("got\n%s\nwant\n%s", got, strings.Join(want, "\n"))
+	}
+}
+
+func TestFindServicePort(t *testing.T) {
+	ports := []servicePort{{Name: "http", Port: 80}, {Name: "admin", Port: 8080}}
+	tests := []struct {
+		port string
+		want servicePort
+		ok   bool
+	}{
+		{"", ports[0], true},
+		{"admin", ports[1], true},
+		{"8080", ports[1], true},
+		{"9090", servicePort{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := findServicePort(ports, tt.port)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("%q: got %v, %v want %v, %v", tt.port, got, ok, tt.want, tt.ok)
+		}
+	}
+}
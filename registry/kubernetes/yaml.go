@@ -0,0 +1,280 @@
+package kubernetes
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseYAML parses the subset of YAML which is used by kubeconfig
+// files: block mappings and sequences with plain or quoted scalars,
+// empty flow collections and comments. JSON documents are parsed as
+// JSON. Mappings are returned as map[string]interface{}, sequences
+// as []interface{} and all scalars as strings.
+func parseYAML(data []byte) (interface{}, error) {
+	s := strings.TrimSpace(string(data))
+	if strings.HasPrefix(s, "{") {
+		var v interface{}
+		if err := json.Unmarshal(data, &v); err != nil {
+			return nil, err
+		}
+		return stringify(v), nil
+	}
+
+	var lines []yamlLine
+	for i, l := range strings.Split(string(data), "\n") {
+		l = strings.TrimRight(stripComment(l), " \t\r")
+		t := strings.TrimLeft(l, " ")
+		if t == "" || t == "---" {
+			continue
+		}
+		if strings.HasPrefix(t, "\t") {
+			return nil, fmt.Errorf("line %d: tabs are not allowed for indentation", i+1)
+		}
+		lines = append(lines, yamlLine{num: i + 1, indent: len(l) - len(t), text: t})
+	}
+	if len(lines) == 0 {
+		return map[string]interface{}{}, nil
+	}
+
+	p := &yamlParser{lines: lines}
+	v, err := p.block(lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.i < len(p.lines) {
+		return nil, fmt.Errorf("line %d: unexpected indentation", p.lines[p.i].num)
+	}
+	return v, nil
+}
+
+type yamlLine struct {
+	num    int
+	indent int
+	text   string
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	i     int
+}
+
+func (l yamlLine) isItem() bool {
+	return l.text == "-" || strings.HasPrefix(l.text, "- ")
+}
+
+// block parses the mapping or sequence which starts at the current line.
+func (p *yamlParser) block(indent int) (interface{}, error) {
+	if p.lines[p.i].isItem() {
+		return p.sequence(indent)
+	}
+	return p.mapping(indent)
+}
+
+func (p *yamlParser) mapping(indent int) (interface{}, error) {
+	m := map[string]interface{}{}
+	for p.i < len(p.lines) {
+		l := p.lines[p.i]
+		if l.indent < indent || (l.indent == indent && l.isItem()) {
+			break
+		}
+		if l.indent > indent {
+			return nil, fmt.Errorf("line %d: unexpected indentation", l.num)
+		}
+		key, rest, ok := splitKey(l.text)
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key: value", l.num)
+		}
+		kv, err := scalar(key)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", l.num, err)
+		}
+		k, ok := kv.(string)
+		if !ok {
+			return nil, fmt.Errorf("line %d: invalid key %s", l.num, key)
+		}
+		p.i++
+
+		if rest != "" {
+			v, err := scalar(rest)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s", l.num, err)
+			}
+			m[k] = v
+			continue
+		}
+
+		// nested block which is either indented or
+		// a sequence on the same indentation level
+		if p.i < len(p.lines) {
+			next := p.lines[p.i]
+			if next.indent > indent || (next.indent == indent && next.isItem()) {
+				v, err := p.block(next.indent)
+				if err != nil {
+					return nil, err
+				}
+				m[k] = v
+				continue
+			}
+		}
+		m[k] = ""
+	}
+	return m, nil
+}
+
+func (p *yamlParser) sequence(indent int) (interface{}, error) {
+	list := []interface{}{}
+	for p.i < len(p.lines) {
+		l := p.lines[p.i]
+		if l.indent != indent || !l.isItem() {
+			if l.indent > indent {
+				return nil, fmt.Errorf("line %d: unexpected indentation", l.num)
+			}
+			break
+		}
+
+		rest := strings.TrimLeft(strings.TrimPrefix(l.text, "-"), " ")
+		if rest == "" {
+			p.i++
+			if p.i < len(p.lines) && p.lines[p.i].indent > indent {
+				v, err := p.block(p.lines[p.i].indent)
+				if err != nil {
+					return nil, err
+				}
+				list = append(list, v)
+			} else {
+				list = append(list, "")
+			}
+			continue
+		}
+
+		// "- key: value" starts a mapping which is indented
+		// by the position of the key.
+		if _, _, ok := splitKey(rest); ok && !isQuoted(rest) {
+			p.lines[p.i] = yamlLine{num: l.num, indent: indent + len(l.text) - len(rest), text: rest}
+			v, err := p.block(p.lines[p.i].indent)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+			continue
+		}
+
+		v, err := scalar(rest)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", l.num, err)
+		}
+		list = append(list, v)
+		p.i++
+	}
+	return list, nil
+}
+
+// splitKey splits "key: value" and "key:" outside of quotes.
+func splitKey(s string) (key, rest string, ok bool) {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			i, quote = skipQuoted(s, i, quote)
+		case (c == '"' || c == '\'') && i == 0:
+			quote = c
+		case c == ':' && (i == len(s)-1 || s[i+1] == ' '):
+			return strings.TrimSpace(s[:i]), strings.TrimSpace(s[i+1:]), true
+		}
+	}
+	return "", "", false
+}
+
+// skipQuoted processes the character at s[i] within a string quoted
+// with quote and returns the index of the last processed character
+// and the quote which is zero if the string has ended. Escaped quotes
+// like a backslash before a double quote and two single quotes do
+// not end the string.
+func skipQuoted(s string, i int, quote byte) (int, byte) {
+	switch {
+	case quote == '"' && s[i] == '\\' && i+1 < len(s):
+		return i + 1, quote
+	case quote == '\'' && s[i] == '\'' && i+1 < len(s) && s[i+1] == '\'':
+		return i + 1, quote
+	case s[i] == quote:
+		return i, 0
+	}
+	return i, quote
+}
+
+func isQuoted(s string) bool {
+	return strings.HasPrefix(s, `"`) || strings.HasPrefix(s, "'")
+}
+
+// scalar returns the value of a plain or quoted scalar.
+func scalar(s string) (interface{}, error) {
+	switch {
+	case s == "{}":
+		return map[string]interface{}{}, nil
+	case s == "[]":
+		return []interface{}{}, nil
+	case s == "|" || s == ">" || strings.HasPrefix(s, "|") || strings.HasPrefix(s, ">"):
+		return nil, fmt.Errorf("block scalars are not supported")
+	case strings.HasPrefix(s, `"`):
+		v, err := strconv.Unquote(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid quoted string %s", s)
+		}
+		return v, nil
+	case strings.HasPrefix(s, "'"):
+		if len(s) < 2 || !strings.HasSuffix(s, "'") {
+			return nil, fmt.Errorf("invalid quoted string %s", s)
+		}
+		return strings.Replace(s[1:len(s)-1], "''", "'", -1), nil
+	case s == "~" || s == "null":
+		return "", nil
+	default:
+		return s, nil
+	}
+}
+
+// stripComment removes a comment which starts with
+// a '#' at the beginning or after a space outside of quotes.
+func stripComment(s string) string {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			i, quote = skipQuoted(s, i, quote)
+		case c == '"' || c == '\'':
+			if i == 0 || s[i-1] == ' ' || s[i-1] == ':' {
+				quote = c
+			}
+		case c == '#' && (i == 0 || s[i-1] == ' ' || s[i-1] == '\t'):
+			return s[:i]
+		}
+	}
+	return s
+}
+
+// stringify converts the scalars of a decoded JSON
+// document to strings like the YAML parser does.
+func stringify(v interface{}) interface{} {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		for k, e := range x {
+			x[k] = stringify(e)
+		}
+		return x
+	case []interface{}:
+		for i, e := range x {
+			x[i] = stringify(e)
+		}
+		return x
+	case nil:
+		return ""
+	case string:
+		return x
+	default:
+		return fmt.Sprint(x)
+	}
+}
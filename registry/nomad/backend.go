@@ -0,0 +1,147 @@
+// Package nomad implements a registry backend which builds the
+// routing table from the tags of the services registered with
+// the native service discovery of Nomad.
+package nomad
+
+import (
+	"context"
+	"log"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/fabiolb/fabio/config"
+	"github.com/fabiolb/fabio/registry"
+)
+
+// retryInterval is the time to wait after a failed request.
+const retryInterval = 5 * time.Second
+
+type be struct {
+	c   *client
+	cfg *config.Nomad
+}
+
+func NewBackend(cfg *config.Nomad) (registry.Backend, error) {
+	c, err := newClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// ping the agent
+	var leader string
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := c.get(ctx, "/v1/status/leader", nil, 0, 0, &leader); err != nil {
+		return nil, err
+	}
+
+	log.Printf("[INFO] nomad: Connecting to %s (leader %s) in namespace %q", cfg.Addr, leader, cfg.Namespace)
+	return &be{c: c, cfg: cfg}, nil
+}
+
+func (b *be) Register(services []string) error {
+	return nil
+}
+
+func (b *be) Deregister(serviceName string) error {
+	return nil
+}
+
+func (b *be) DeregisterAll() error {
+	return nil
+}
+
+func (b *be) ManualPaths() ([]string, error) {
+	return nil, nil
+}
+
+func (b *be) ReadManual(string) (value string, version uint64, err error) {
+	return "", 0, nil
+}
+
+func (b *be) WriteManual(path string, value string, version uint64) (ok bool, err error) {
+	return false, nil
+}
+
+func (b *be) WatchManual() *registry.Watch {
+	return registry.NewWatch()
+}
+
+func (b *be) WatchNoRouteHTML() *registry.Watch {
+	return registry.NewWatch()
+}
+
+// WatchServices watches the service list with blocking queries and
+// rebuilds the routes when a service registration has changed.
+func (b *be) WatchServices() *registry.Watch {
+	w := registry.NewWatch()
+	go func() {
+		var lastIndex uint64
+		for {
+			var stubs []serviceStubs
+			index, err := b.c.get(context.Background(), "/v1/services", b.query(), lastIndex, b.cfg.WaitTime, &stubs)
+			if err != nil {
+				log.Printf("[WARN] nomad: Error fetching services. %s", err)
+				time.Sleep(retryInterval)
+				continue
+			}
+
+			// the wait time has passed without changes
+			if lastIndex > 0 && index == lastIndex {
+				continue
+			}
+
+			cfg, err := b.load(stubs)
+			if err != nil {
+				log.Printf("[WARN] nomad: Error loading routes. %s", err)
+				time.Sleep(retryInterval)
+				continue
+			}
+			log.Printf("[DEBUG] nomad: Services changed to #%d", index)
+			w.Publish(cfg)
+
+			switch {
+			case index == 0:
+				// blocking queries are not supported
+				time.Sleep(retryInterval)
+			case index < lastIndex:
+				// start over if the index went backwards,
+				// e.g. after a snapshot was restored.
+				index = 0
+			}
+			lastIndex = index
+		}
+	}()
+	return w
+}
+
+// load fetches the registrations of the services with
+// urlprefix tags and builds the routes.
+func (b *be) load(stubs []serviceStubs) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	// sort the stubs to build the routes in a stable order
+	sort.Slice(stubs, func(i, j int) bool { return stubs[i].Namespace < stubs[j].Namespace })
+
+	var regs []registration
+	for _, ns := range stubs {
+		for _, svc := range ns.Services {
+			if !hasPrefix(svc.Tags, b.cfg.TagPrefix) {
+				continue
+			}
+			var r []registration
+			q := url.Values{"namespace": {ns.Namespace}}
+			if _, err := b.c.get(ctx, "/v1/service/"+url.PathEscape(svc.ServiceName), q, 0, 0, &r); err != nil {
+				return "", err
+			}
+			regs = append(regs, r...)
+		}
+	}
+	return buildRoutes(regs, b.cfg.TagPrefix), nil
+}
+
+func (b *be) query() url.Values {
+	return url.Values{"namespace": {b.cfg.Namespace}}
+}
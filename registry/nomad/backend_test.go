@@ -0,0 +1,146 @@
+package nomad
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fabiolb/fabio/config"
+	"github.com/fabiolb/fabio/registry"
+)
+
+// fakeAPI serves the service list with blocking queries
+// and the registrations of the web service.
+type fakeAPI struct {
+	mu        sync.Mutex
+	index     uint64
+	addrs     []string
+	changed   chan struct{}
+	token     string
+	namespace string
+}
+
+func newFakeAPI() *fakeAPI {
+	return &fakeAPI{index: 1, changed: make(chan struct{})}
+}
+
+func (f *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.token = r.Header.Get("X-Nomad-Token")
+	f.namespace = r.URL.Query().Get("namespace")
+	index, changed := f.index, f.changed
+	f.mu.Unlock()
+
+	switch r.URL.Path {
+	case "/v1/status/leader":
+		fmt.Fprint(w, `"10.0.0.100:4647"`)
+
+	case "/v1/services":
+		if wait, _ := strconv.ParseUint(r.URL.Query().Get("index"), 10, 64); wait >= index {
+			select {
+			case <-changed:
+			case <-r.Context().Done():
+				return
+			}
+			f.mu.Lock()
+			index = f.index
+			f.mu.Unlock()
+		}
+		w.Header().Set("X-Nomad-Index", strconv.FormatUint(index, 10))
+		fmt.Fprint(w, `[
+			{"Namespace": "default", "Services": [
+				{"ServiceName": "web", "Tags": ["urlprefix-/"]},
+				{"ServiceName": "db", "Tags": ["primary"]}
+			]}
+		]`)
+
+	case "/v1/service/web":
+		f.mu.Lock()
+		var regs []string
+		for _, a := range f.addrs {
+			regs = append(regs, fmt.Sprintf(`{"ServiceName": "web", "Namespace": "default", "Address": %q, "Port": 8080, "Tags": ["urlprefix-/"]}`, a))
+		}
+		f.mu.Unlock()
+		w.Header().Set("X-Nomad-Index", strconv.FormatUint(index, 10))
+		fmt.Fprintf(w, "[%s]", strings.Join(regs, ","))
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// setAddrs updates the registrations and unblocks the blocking queries.
+func (f *fakeAPI) setAddrs(addrs ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.addrs = addrs
+	f.index++
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+func TestNewBackend(t *testing.T) {
+	srv := httptest.NewServer(newFakeAPI())
+	defer srv.Close()
+
+	if _, err := NewBackend(&config.Nomad{Addr: srv.URL}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewBackend(&config.Nomad{Addr: srv.URL + "/missing"}); err == nil {
+		t.Fatal("got nil want error")
+	}
+}
+
+func TestWatchServices(t *testing.T) {
+	api := newFakeAPI()
+	api.addrs = []string{"10.0.0.1"}
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+
+	cfg := &config.Nomad{Addr: srv.URL, Token: "secret", Namespace: "default", TagPrefix: "urlprefix-", WaitTime: time.Minute}
+	c, err := newClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &be{c: c, cfg: cfg}
+
+	w := b.WatchServices()
+	snap := waitFor(t, w.Next, 0, "route add web / http://10.0.0.1:8080/")
+
+	api.setAddrs("10.0.0.1", "10.0.0.2")
+	waitFor(t, w.Next, snap, "route add web / http://10.0.0.2:8080/\nroute add web / http://10.0.0.1:8080/")
+
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	if got, want := api.token, "secret"; got != want {
+		t.Fatalf("got token %q want %q", got, want)
+	}
+	if got, want := api.namespace, "default"; got != want {
+		t.Fatalf("got namespace %q want %q", got, want)
+	}
+}
+
+// waitFor waits until the watch publishes the wanted value and
+// returns its sequence number.
+func waitFor(t *testing.T, next func(uint64) registry.Snapshot, seq uint64, want string) uint64 {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		c := make(chan registry.Snapshot, 1)
+		go func() { c <- next(seq) }()
+		select {
+		case s := <-c:
+			if s.Value == want {
+				return s.Seq
+			}
+			seq = s.Seq
+		case <-timeout:
+			t.Fatalf("timeout waiting for %q", want)
+		}
+	}
+}
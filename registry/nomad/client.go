@@ -0,0 +1,135 @@
+package nomad
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fabiolb/fabio/config"
+)
+
+// client is a minimal client for the Nomad HTTP API which
+// supports blocking queries.
+type client struct {
+	addr   string
+	token  string
+	region string
+	http   *http.Client
+}
+
+func newClient(cfg *config.Nomad) (*client, error) {
+	tlscfg := &tls.Config{InsecureSkipVerify: cfg.TLS.InsecureSkipVerify}
+	if cfg.TLS.CAFile != "" {
+		ca, err := ioutil.ReadFile(cfg.TLS.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("nomad: invalid CA file %s", cfg.TLS.CAFile)
+		}
+		tlscfg.RootCAs = pool
+	}
+	if cfg.TLS.CertFile != "" || cfg.TLS.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlscfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return &client{
+		addr:   strings.TrimSuffix(cfg.Addr, "/"),
+		token:  cfg.Token,
+		region: cfg.Region,
+		http: &http.Client{
+			Transport: &http.Transport{
+				Proxy:               http.ProxyFromEnvironment,
+				TLSClientConfig:     tlscfg,
+				TLSHandshakeTimeout: 10 * time.Second,
+			},
+		},
+	}, nil
+}
+
+// statusError is returned for responses with an error status.
+type statusError struct {
+	code int
+	msg  string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("nomad: %d %s", e.code, e.msg)
+}
+
+// get decodes the response for path into v and returns the
+// value of the X-Nomad-Index header. If index is not zero the
+// request is a blocking query which returns when the index
+// has changed or the wait time has passed.
+func (c *client) get(ctx context.Context, path string, q url.Values, index uint64, wait time.Duration, v interface{}) (uint64, error) {
+	if q == nil {
+		q = url.Values{}
+	}
+	if c.region != "" {
+		q.Set("region", c.region)
+	}
+	if index > 0 {
+		q.Set("index", strconv.FormatUint(index, 10))
+		q.Set("wait", strconv.FormatInt(int64(wait/time.Millisecond), 10)+"ms")
+
+		// nomad adds a jitter of up to wait/16 to the wait time
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, wait+wait/16+10*time.Second)
+		defer cancel()
+	}
+
+	u := c.addr + path
+	if len(q) > 0 {
+		u += "?" + q.Encode()
+	}
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return 0, err
+	}
+	req = req.WithContext(ctx)
+	if c.token != "" {
+		req.Header.Set("X-Nomad-Token", c.token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		msg := strings.TrimSpace(string(b))
+		if msg == "" {
+			msg = resp.Status
+		}
+		return 0, &statusError{resp.StatusCode, msg}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return 0, err
+	}
+
+	h := resp.Header.Get("X-Nomad-Index")
+	if h == "" {
+		return 0, nil
+	}
+	idx, err := strconv.ParseUint(h, 10, 64)
+	if err != nil {
+		return 0, errors.New("nomad: invalid X-Nomad-Index header " + h)
+	}
+	return idx, nil
+}
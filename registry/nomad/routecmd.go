@@ -0,0 +1,63 @@
+package nomad
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/fabiolb/fabio/registry"
+)
+
+// serviceStubs is the response of the service list endpoint
+// with the services of a namespace.
+type serviceStubs struct {
+	Namespace string
+	Services  []struct {
+		ServiceName string
+		Tags        []string
+	}
+}
+
+// registration is an instance of a service registered
+// by a nomad task or task group.
+type registration struct {
+	ID          string
+	ServiceName string
+	Namespace   string
+	Datacenter  string
+	JobID       string
+	AllocID     string
+	Address     string
+	Port        int
+	Tags        []string
+}
+
+// hasPrefix returns true if one of the tags starts with prefix.
+func hasPrefix(tags []string, prefix string) bool {
+	for _, t := range tags {
+		if strings.HasPrefix(strings.TrimSpace(t), prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// buildRoutes returns the route commands for the service
+// registrations sorted with the most specific first.
+func buildRoutes(regs []registration, prefix string) string {
+	var config []string
+	for _, r := range regs {
+		config = append(config, routeCmds(r, prefix)...)
+	}
+	return registry.SortRoutes(config)
+}
+
+// routeCmds builds the route commands for the urlprefix tags
+// of a service registration.
+func routeCmds(r registration, prefix string) []string {
+	env := map[string]string{
+		"DC": r.Datacenter,
+	}
+	addr := net.JoinHostPort(r.Address, strconv.Itoa(r.Port))
+	return registry.RouteCmds(r.ServiceName, addr, r.Tags, prefix, env)
+}
@@ -0,0 +1,97 @@
+package nomad
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRouteCmds(t *testing.T) {
+	reg := func(tags ...string) registration {
+		return registration{
+			ServiceName: "svc-a",
+			Datacenter:  "dc1",
+			Address:     "10.0.0.1",
+			Port:        8080,
+			Tags:        tags,
+		}
+	}
+
+	tests := []struct {
+		name string
+		reg  registration
+		cmds []string
+	}{
+		{
+			name: "http",
+			reg:  reg("urlprefix-/foo"),
+			cmds: []string{`route add svc-a /foo http://10.0.0.1:8080/`},
+		},
+		{
+			name: "host lower cased and env expanded",
+			reg:  reg("urlprefix-Foo.$DC.com/Bar"),
+			cmds: []string{`route add svc-a foo.dc1.com/Bar http://10.0.0.1:8080/`},
+		},
+		{
+			name: "tags and opts",
+			reg:  reg("urlprefix-/foo strip=/foo weight=0.2", "v1", "blue"),
+			cmds: []string{`route add svc-a /foo http://10.0.0.1:8080/ weight 0.2 tags "v1,blue" opts "strip=/foo"`},
+		},
+		{
+			name: "tcp",
+			reg:  reg("urlprefix-:1234 proto=tcp"),
+			cmds: []string{`route add svc-a :1234 tcp://10.0.0.1:8080`},
+		},
+		{
+			name: "udp",
+			reg:  reg("urlprefix-:53 proto=udp"),
+			cmds: []string{`route add svc-a :53 udp://10.0.0.1:8080`},
+		},
+		{
+			name: "h2",
+			reg:  reg("urlprefix-/foo proto=h2"),
+			cmds: []string{`route add svc-a /foo https://10.0.0.1:8080 opts "proto=h2"`},
+		},
+		{
+			name: "grpc",
+			reg:  reg("urlprefix-/ proto=grpc"),
+			cmds: []string{`route add svc-a / grpc://10.0.0.1:8080`},
+		},
+		{
+			name: "redirect",
+			reg:  reg("urlprefix-/foo redirect=301,https://example.com$path"),
+			cmds: []string{`route add svc-a /foo https://example.com$path opts "redirect=301"`},
+		},
+		{
+			name: "tag without slash",
+			reg:  reg("urlprefix-foo.com"),
+			cmds: []string{`route add svc-a foo.com http://10.0.0.1:8080/`},
+		},
+		{
+			name: "no route tags",
+			reg:  reg("v1"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, want := routeCmds(tt.reg, "urlprefix-"), tt.cmds; !reflect.DeepEqual(got, want) {
+				t.Fatalf("got %q want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestBuildRoutes(t *testing.T) {
+	regs := []registration{
+		{ServiceName: "a", Address: "10.0.0.1", Port: 80, Tags: []string{"urlprefix-/"}},
+		{ServiceName: "b", Address: "10.0.0.2", Port: 80, Tags: []string{"urlprefix-/b"}},
+		{ServiceName: "a", Address: "10.0.0.3", Port: 80, Tags: []string{"urlprefix-/"}},
+	}
+	got := buildRoutes(regs, "urlprefix-")
+	want := "route add b /b http://10.0.0.2:80/\n" +
+		"route add a / http://10.0.0.3:80/\n" +
+		"route add a / http://10.0.0.1:80/"
+	if got != want {
+		t.Fatalf("got\n%s\nwant\n%s", got, want)
+	}
+}
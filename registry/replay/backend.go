@@ -0,0 +1,176 @@
+package replay
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/fabiolb/fabio/config"
+	"github.com/fabiolb/fabio/registry"
+)
+
+// maxEventSize limits the size of a single line in a recording.
+const maxEventSize = 64 << 20
+
+type be struct {
+	cfg    *config.Replay
+	events []Event
+	watch  map[string]*registry.Watch
+	start  sync.Once
+
+	mu     sync.Mutex
+	status Status
+}
+
+// Status describes the progress of the replay.
+type Status struct {
+	// Path is the replayed recording.
+	Path string `json:"path"`
+
+	// Events is the number of events in the recording
+	// and Replayed the number of replayed events.
+	Events   int `json:"events"`
+	Replayed int `json:"replayed"`
+
+	// Time is the recording time of the last replayed event.
+	Time time.Time `json:"time,omitempty"`
+
+	// Done is true when all events have been replayed.
+	Done bool `json:"done"`
+}
+
+// NewBackend returns a backend which replays the
+// recording at cfg.Path.
+func NewBackend(cfg *config.Replay) (registry.Backend, error) {
+	events, err := ReadFile(cfg.Path)
+	if err != nil {
+		return nil, err
+	}
+	return &be{
+		cfg:    cfg,
+		events: events,
+		watch: map[string]*registry.Watch{
+			WatchServices:    registry.NewWatch(),
+			WatchManual:      registry.NewWatch(),
+			WatchNoRouteHTML: registry.NewWatch(),
+			WatchRollout:     registry.NewWatch(),
+		},
+		status: Status{Path: cfg.Path, Events: len(events)},
+	}, nil
+}
+
+// ReadFile reads the events of a recording.
+func ReadFile(path string) ([]Event, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("replay: Cannot open recording. %s", err)
+	}
+	defer f.Close()
+
+	var events []Event
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, maxEventSize)
+	for n := 1; sc.Scan(); n++ {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		var e Event
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("replay: Invalid event in line %d of %s. %s", n, path, err)
+		}
+		events = append(events, e)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("replay: Cannot read recording. %s", err)
+	}
+	return events, nil
+}
+
+func (b *be) Register(services []string) error {
+	return nil
+}
+
+func (b *be) Deregister(serviceName string) error {
+	return nil
+}
+
+func (b *be) DeregisterAll() error {
+	return nil
+}
+
+func (b *be) ManualPaths() ([]string, error) {
+	return nil, nil
+}
+
+func (b *be) ReadManual(string) (value string, version uint64, err error) {
+	s := b.watch[WatchManual].Latest()
+	return s.Value, s.Seq, nil
+}
+
+func (b *be) WriteManual(path string, value string, version uint64) (ok bool, err error) {
+	return false, fmt.Errorf("replay: Manual overrides are read-only")
+}
+
+func (b *be) WatchServices() *registry.Watch {
+	return b.replay(WatchServices)
+}
+
+func (b *be) WatchManual() *registry.Watch {
+	return b.replay(WatchManual)
+}
+
+func (b *be) WatchNoRouteHTML() *registry.Watch {
+	return b.replay(WatchNoRouteHTML)
+}
+
+func (b *be) WatchRollout() *registry.Watch {
+	return b.replay(WatchRollout)
+}
+
+func (b *be) Status() interface{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.status
+}
+
+// replay returns the watch with the given name and starts the replay
+// when the first watch is requested.
+func (b *be) replay(name string) *registry.Watch {
+	b.start.Do(func() { go b.run() })
+	return b.watch[name]
+}
+
+// run publishes the events with the recorded delays divided by the
+// replay speed.
+func (b *be) run() {
+	log.Printf("[INFO] replay: Replaying %d events from %s", len(b.events), b.cfg.Path)
+	var last time.Time
+	for i, e := range b.events {
+		if !last.IsZero() && b.cfg.Speed > 0 {
+			time.Sleep(time.Duration(float64(e.Time.Sub(last)) / b.cfg.Speed))
+		}
+		last = e.Time
+
+		w := b.watch[e.Watch]
+		if w == nil {
+			log.Printf("[WARN] replay: Skipping event %d for unknown watch %q", i+1, e.Watch)
+			continue
+		}
+		log.Printf("[INFO] replay: Event %d/%d: %s update recorded at %s", i+1, len(b.events), e.Watch, e.Time.Format(time.RFC3339Nano))
+		w.Publish(e.Value)
+
+		b.mu.Lock()
+		b.status.Replayed = i + 1
+		b.status.Time = e.Time
+		b.mu.Unlock()
+	}
+
+	b.mu.Lock()
+	b.status.Done = true
+	b.mu.Unlock()
+	log.Printf("[INFO] replay: Replay of %s finished", b.cfg.Path)
+}
@@ -0,0 +1,176 @@
+// Package replay records the updates of a registry backend to a file
+// and implements a registry backend which replays them.
+//
+// Recordings allow reproducing problems with the routing table which
+// depend on the sequence of registry updates offline, e.g. with a
+// recording of a production instance.
+package replay
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/fabiolb/fabio/registry"
+)
+
+// Names of the watches in a recording.
+const (
+	WatchServices    = "services"
+	WatchManual      = "manual"
+	WatchNoRouteHTML = "noroutehtml"
+	WatchRollout     = "rollout"
+)
+
+// Event is a single update of a registry watch. A recording
+// contains one JSON encoded event per line.
+type Event struct {
+	Time  time.Time `json:"time"`
+	Watch string    `json:"watch"`
+	Value string    `json:"value"`
+}
+
+// Recorder is a registry backend which appends all updates of the
+// watches of another backend to a file. All other calls are passed
+// through to that backend.
+type Recorder struct {
+	registry.Backend
+
+	path string
+
+	// mu guards the fields below.
+	mu     sync.Mutex
+	f      *os.File
+	enc    *json.Encoder
+	events uint64
+	err    error
+}
+
+// RecorderStatus describes the state of the recorder.
+type RecorderStatus struct {
+	// Path is the file the updates are recorded to.
+	Path string `json:"path"`
+
+	// Events is the number of recorded updates.
+	Events uint64 `json:"events"`
+
+	// LastError contains the error of the last failed write.
+	LastError string `json:"lastError,omitempty"`
+
+	// Backend contains the status of the recorded backend
+	// if it reports one.
+	Backend interface{} `json:"backend,omitempty"`
+}
+
+// NewRecorder returns a backend which records the updates of be to
+// the file at path. Recordings are appended to an existing file.
+func NewRecorder(be registry.Backend, path string) (*Recorder, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("replay: Cannot open recording. %s", err)
+	}
+	return &Recorder{Backend: be, path: path, f: f, enc: json.NewEncoder(f)}, nil
+}
+
+func (r *Recorder) WatchServices() *registry.Watch {
+	return r.record(WatchServices, r.Backend.WatchServices())
+}
+
+func (r *Recorder) WatchManual() *registry.Watch {
+	return r.record(WatchManual, r.Backend.WatchManual())
+}
+
+func (r *Recorder) WatchNoRouteHTML() *registry.Watch {
+	return r.record(WatchNoRouteHTML, r.Backend.WatchNoRouteHTML())
+}
+
+// WatchRollout records the rollout definitions if
+// the recorded backend supports rollouts.
+func (r *Recorder) WatchRollout() *registry.Watch {
+	rw, ok := r.Backend.(registry.RolloutWatcher)
+	if !ok {
+		return nil
+	}
+	w := rw.WatchRollout()
+	if w == nil {
+		return nil
+	}
+	return r.record(WatchRollout, w)
+}
+
+// WatchFlags returns the feature flags of the recorded backend.
+// They are not recorded since they do not change the routes.
+func (r *Recorder) WatchFlags() *registry.Watch {
+	fw, ok := r.Backend.(registry.FlagWatcher)
+	if !ok {
+		return nil
+	}
+	return fw.WatchFlags()
+}
+
+// DeleteManual deletes the override in the recorded backend.
+func (r *Recorder) DeleteManual(path string, version uint64) (bool, error) {
+	d, ok := r.Backend.(registry.ManualDeleter)
+	if !ok {
+		return false, registry.ErrNotSupported
+	}
+	return d.DeleteManual(path, version)
+}
+
+// SetPollInterval sets the poll interval of the recorded backend.
+func (r *Recorder) SetPollInterval(d time.Duration) {
+	if p, ok := r.Backend.(registry.Poller); ok {
+		p.SetPollInterval(d)
+	}
+}
+
+// Status reports the state of the recorder.
+func (r *Recorder) Status() interface{} {
+	r.mu.Lock()
+	s := RecorderStatus{Path: r.path, Events: r.events}
+	if r.err != nil {
+		s.LastError = r.err.Error()
+	}
+	r.mu.Unlock()
+	if sr, ok := r.Backend.(registry.StatusReporter); ok {
+		s.Backend = sr.Status()
+	}
+	return s
+}
+
+// Close closes the recording.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.f.Close()
+}
+
+// record returns a watch which publishes the values of w
+// after they have been written to the recording.
+func (r *Recorder) record(name string, w *registry.Watch) *registry.Watch {
+	out := registry.NewWatch()
+	go func() {
+		var s registry.Snapshot
+		for {
+			s = w.Next(s.Seq)
+			r.write(Event{Time: time.Now().UTC(), Watch: name, Value: s.Value})
+			out.PublishIndex(s.Value, s.Index)
+		}
+	}()
+	return out
+}
+
+func (r *Recorder) write(e Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.enc.Encode(e); err != nil {
+		// keep serving the updates even if they cannot be recorded
+		log.Printf("[ERROR] replay: Cannot record %s update. %s", e.Watch, err)
+		r.err = err
+		return
+	}
+	r.events++
+}
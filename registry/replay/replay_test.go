@@ -0,0 +1,163 @@
+package replay
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fabiolb/fabio/config"
+	"github.com/fabiolb/fabio/registry"
+)
+
+// testBackend provides the watches of a backend.
+type testBackend struct {
+	registry.Backend
+	svc, man, html *registry.Watch
+}
+
+func (b *testBackend) WatchServices() *registry.Watch    { return b.svc }
+func (b *testBackend) WatchManual() *registry.Watch      { return b.man }
+func (b *testBackend) WatchNoRouteHTML() *registry.Watch { return b.html }
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "fabio-replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "registry.rec")
+
+	be := &testBackend{svc: registry.NewWatch(), man: registry.NewWatch(), html: registry.NewWatch()}
+	rec, err := NewRecorder(be, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc, man := rec.WatchServices(), rec.WatchManual()
+
+	updates := []struct {
+		watch string
+		in    *registry.Watch
+		out   *registry.Watch
+		value string
+	}{
+		{WatchServices, be.svc, svc, "route add a / http://1.2.3.4/"},
+		{WatchManual, be.man, man, "route weight a / weight 0.5"},
+		{WatchServices, be.svc, svc, "route add a / http://1.2.3.4/\nroute add b /b http://1.2.3.5/"},
+	}
+	var want []string
+	for _, u := range updates {
+		seq := u.out.Latest().Seq
+		u.in.Publish(u.value)
+		if got := u.out.Next(seq).Value; got != u.value {
+			t.Fatalf("got %q want %q", got, u.value)
+		}
+		want = append(want, u.watch+" "+u.value)
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got, want := rec.Status().(RecorderStatus).Events, uint64(3); got != want {
+		t.Fatalf("got %d recorded events want %d", got, want)
+	}
+	rec.Close()
+
+	events, err := ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for i, e := range events {
+		got = append(got, e.Watch+" "+e.Value)
+		if i > 0 && e.Time.Before(events[i-1].Time) {
+			t.Fatalf("event %d recorded before event %d", i, i-1)
+		}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q want %q", got, want)
+	}
+
+	// replay the recording in the recorded order
+	rb, err := NewBackend(&config.Replay{Path: path, Speed: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsvc, rman := rb.WatchServices(), rb.WatchManual()
+	var s, m registry.Snapshot
+	for _, u := range updates {
+		var v string
+		switch u.watch {
+		case WatchServices:
+			s = rsvc.Next(s.Seq)
+			v = s.Value
+		case WatchManual:
+			m = rman.Next(m.Seq)
+			v = m.Value
+		}
+		if v != u.value {
+			t.Fatalf("got %q want %q", v, u.value)
+		}
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for !rb.(registry.StatusReporter).Status().(Status).Done {
+		if time.Now().After(deadline) {
+			t.Fatal("replay not done")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got, want := rb.(registry.StatusReporter).Status().(Status).Replayed, 3; got != want {
+		t.Fatalf("got %d replayed events want %d", got, want)
+	}
+}
+
+func TestReplaySpeed(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "registry.rec")
+
+	data := `{"time":"2020-01-01T00:00:00Z","watch":"services","value":"a"}
+{"time":"2020-01-01T00:00:01Z","watch":"services","value":"b"}
+`
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// one second replayed 20 times faster
+	rb, err := NewBackend(&config.Replay{Path: path, Speed: 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := rb.WatchServices()
+	first := svc.Next(0)
+	start := time.Now()
+	if got, want := svc.Next(first.Seq).Value, "b"; got != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+	if d := time.Since(start); d < 40*time.Millisecond || d > 500*time.Millisecond {
+		t.Fatalf("got delay %s want about 50ms", d)
+	}
+}
+
+func TestReadFileError(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "registry.rec")
+
+	data := `{"time":"2020-01-01T00:00:00Z","watch":"services","value":"a"}
+not json
+`
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := ReadFile(path)
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Fatalf("got error %v want invalid event in line 2", err)
+	}
+}
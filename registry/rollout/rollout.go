@@ -0,0 +1,233 @@
+// Package rollout implements a controller which moves the weight of
+// canary routes gradually towards a desired value which is stored in
+// the registry instead of changing it in a single step.
+package rollout
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fabiolb/fabio/registry"
+)
+
+// Def describes the desired weight of the targets of a service.
+type Def struct {
+	Service string
+	Src     string
+	Tags    []string
+
+	// Weight is the desired share of the traffic between 0 and 1.
+	Weight float64
+
+	// Duration is the time it takes to move from the current
+	// weight to the desired weight. If it is zero the default
+	// duration of the controller is used.
+	Duration time.Duration
+}
+
+func (d Def) key() string {
+	return d.Service + " " + d.Src + " " + strings.Join(d.Tags, ",")
+}
+
+// reRollout matches
+//
+//	rollout <svc> <src> weight <w>[ tags "<t1>,<t2>,..."][ over <duration>]
+var reRollout = regexp.MustCompile(`^rollout\s+(\S+)\s+(\S+)\s+weight\s+(\S+)(\s+tags\s+"([^"]*)")?(\s+over\s+(\S+))?$`)
+
+// Parse parses the rollout definitions. Every line contains one
+// definition in the form
+//
+//	rollout <svc> <src> weight <w>[ tags "<t1>,<t2>,..."][ over <duration>]
+//
+// where w is the desired share of the traffic for all targets of the
+// service on src with the given tags, e.g. 0.25 for 25%. Empty lines
+// and lines starting with '#' are ignored.
+func Parse(s string) ([]Def, error) {
+	var defs []Def
+	sc := bufio.NewScanner(strings.NewReader(s))
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		m := reRollout.FindStringSubmatch(line)
+		if m == nil {
+			return nil, fmt.Errorf("rollout: line %d: syntax error", n)
+		}
+		w, err := strconv.ParseFloat(m[3], 64)
+		if err != nil || w < 0 || w > 1 {
+			return nil, fmt.Errorf("rollout: line %d: weight must be between 0 and 1", n)
+		}
+		d := Def{Service: m[1], Src: m[2], Weight: w}
+		if m[5] != "" {
+			for _, t := range strings.Split(m[5], ",") {
+				if t = strings.TrimSpace(t); t != "" {
+					d.Tags = append(d.Tags, t)
+				}
+			}
+		}
+		if m[7] != "" {
+			if d.Duration, err = time.ParseDuration(m[7]); err != nil || d.Duration < 0 {
+				return nil, fmt.Errorf("rollout: line %d: invalid duration %q", n, m[7])
+			}
+		}
+		defs = append(defs, d)
+	}
+	return defs, sc.Err()
+}
+
+// state describes the progress of a single rollout.
+type state struct {
+	def   Def
+	from  float64
+	start time.Time
+}
+
+// weight returns the interpolated weight at time now.
+func (s *state) weight(dur time.Duration, now time.Time) float64 {
+	if s.def.Duration > 0 {
+		dur = s.def.Duration
+	}
+	elapsed := now.Sub(s.start)
+	if dur <= 0 || elapsed >= dur {
+		return s.def.Weight
+	}
+	if elapsed < 0 {
+		return s.from
+	}
+	return s.from + (s.def.Weight-s.from)*float64(elapsed)/float64(dur)
+}
+
+// Controller moves the weights of the targets towards
+// the desired weights of the rollout definitions.
+type Controller struct {
+	// Duration is the default duration of a rollout.
+	Duration time.Duration
+
+	// Step is the interval in which the weights are updated
+	// while a rollout is in progress.
+	Step time.Duration
+
+	// Current returns the weight the targets of a definition have
+	// before the rollout starts. If it is nil the weight is zero.
+	Current func(d Def) float64
+
+	// now is stubbed out for testing.
+	now func() time.Time
+
+	mu     sync.Mutex
+	states map[string]*state
+}
+
+func (c *Controller) time() time.Time {
+	if c.now != nil {
+		return c.now()
+	}
+	return time.Now()
+}
+
+// Update replaces the rollout definitions. Rollouts of new definitions
+// and of definitions with a different weight start from the current
+// weight. On the first call the weights are set immediately since
+// there is nothing to roll out from.
+func (c *Controller) Update(defs []Def) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.time()
+	first := c.states == nil
+	next := map[string]*state{}
+	for _, d := range defs {
+		s := &state{def: d, from: d.Weight, start: now}
+		if old := c.states[d.key()]; old != nil {
+			s.from = old.weight(c.Duration, now)
+			if old.def.Weight == d.Weight && old.def.Duration == d.Duration {
+				s.from, s.start = old.from, old.start
+			}
+		} else if !first && c.Current != nil {
+			s.from = c.Current(d)
+		} else if !first {
+			s.from = 0
+		}
+		if s.start == now && s.from != d.Weight {
+			log.Printf("[INFO] rollout: Moving %s from %.4f to %.4f", d.key(), s.from, d.Weight)
+		}
+		next[d.key()] = s
+	}
+	c.states = next
+}
+
+// Commands returns the route weight commands for the current weights
+// and whether all rollouts have reached the desired weight.
+func (c *Controller) Commands() (cmds string, done bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.time()
+	keys := make([]string, 0, len(c.states))
+	for k := range c.states {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	done = true
+	var lines []string
+	for _, k := range keys {
+		s := c.states[k]
+		w := s.weight(c.Duration, now)
+		if w != s.def.Weight {
+			done = false
+		}
+		line := fmt.Sprintf("route weight %s %s weight %.4f", s.def.Service, s.def.Src, w)
+		if len(s.def.Tags) > 0 {
+			line += fmt.Sprintf(" tags %q", strings.Join(s.def.Tags, ","))
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n"), done
+}
+
+// Run reads the rollout definitions from defs and publishes the
+// route weight commands to out whenever the definitions change and
+// every step while a rollout is in progress. Invalid definitions
+// are logged and ignored.
+func (c *Controller) Run(defs, out *registry.Watch) {
+	step := c.Step
+	if step <= 0 {
+		step = time.Second
+	}
+
+	var tick <-chan time.Time
+	var ticker *time.Ticker
+	for {
+		select {
+		case <-defs.C():
+			d, err := Parse(defs.Latest().Value)
+			if err != nil {
+				log.Printf("[WARN] %s", err)
+				continue
+			}
+			c.Update(d)
+		case <-tick:
+		}
+
+		cmds, done := c.Commands()
+		out.Publish(cmds)
+
+		switch {
+		case done && ticker != nil:
+			ticker.Stop()
+			ticker, tick = nil, nil
+		case !done && ticker == nil:
+			ticker = time.NewTicker(step)
+			tick = ticker.C
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package rollout
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in   string
+		defs []Def
+		err  bool
+	}{
+		{in: "", defs: nil},
+		{in: "# comment\n\n", defs: nil},
+		{
+			in:   `rollout svc-a /foo weight 0.25`,
+			defs: []Def{{Service: "svc-a", Src: "/foo", Weight: 0.25}},
+		},
+		{
+			in: "rollout svc-a /foo weight 0.25 tags \"canary, v2\" over 10m\nrollout svc-b a.com/ weight 1",
+			defs: []Def{
+				{Service: "svc-a", Src: "/foo", Tags: []string{"canary", "v2"}, Weight: 0.25, Duration: 10 * time.Minute},
+				{Service: "svc-b", Src: "a.com/", Weight: 1},
+			},
+		},
+		{in: `rollout svc-a /foo weight 1.5`, err: true},
+		{in: `rollout svc-a /foo weight x`, err: true},
+		{in: `rollout svc-a /foo weight 0.5 over x`, err: true},
+		{in: `route add svc-a /foo http://1.2.3.4/`, err: true},
+	}
+
+	for i, tt := range tests {
+		defs, err := Parse(tt.in)
+		if got, want := err != nil, tt.err; got != want {
+			t.Fatalf("%d: got error %v want %v", i, err, want)
+		}
+		if got, want := defs, tt.defs; !reflect.DeepEqual(got, want) {
+			t.Fatalf("%d: got %#v want %#v", i, got, want)
+		}
+	}
+}
+
+func TestController(t *testing.T) {
+	now := time.Unix(0, 0)
+	c := &Controller{
+		Duration: 10 * time.Second,
+		now:      func() time.Time { return now },
+		Current:  func(Def) float64 { return 0.1 },
+	}
+
+	check := func(wantCmds string, wantDone bool) {
+		t.Helper()
+		cmds, done := c.Commands()
+		if cmds != wantCmds || done != wantDone {
+			t.Fatalf("got %q, %v want %q, %v", cmds, done, wantCmds, wantDone)
+		}
+	}
+
+	// the first definitions are applied immediately
+	a := Def{Service: "svc-a", Src: "/foo", Tags: []string{"canary"}, Weight: 0.2}
+	c.Update([]Def{a})
+	check(`route weight svc-a /foo weight 0.2000 tags "canary"`, true)
+
+	// a changed weight is moved over the default duration
+	a.Weight = 0.6
+	c.Update([]Def{a})
+	now = now.Add(5 * time.Second)
+	check(`route weight svc-a /foo weight 0.4000 tags "canary"`, false)
+
+	// an unchanged definition continues the rollout
+	c.Update([]Def{a})
+	now = now.Add(5 * time.Second)
+	check(`route weight svc-a /foo weight 0.6000 tags "canary"`, true)
+
+	// new definitions start from the current weight
+	b := Def{Service: "svc-b", Src: "/bar", Weight: 0.5, Duration: 20 * time.Second}
+	c.Update([]Def{a, b})
+	now = now.Add(10 * time.Second)
+	check("route weight svc-a /foo weight 0.6000 tags \"canary\"\nroute weight svc-b /bar weight 0.3000", false)
+
+	// a change during a rollout starts from the current weight
+	b.Weight = 0
+	c.Update([]Def{a, b})
+	now = now.Add(10 * time.Second)
+	check("route weight svc-a /foo weight 0.6000 tags \"canary\"\nroute weight svc-b /bar weight 0.1500", false)
+
+	// removed definitions are dropped
+	c.Update(nil)
+	check("", true)
+}
@@ -0,0 +1,139 @@
+package registry
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// RouteCmds returns the route commands for the tags of a service
+// instance at addr which start with prefix, e.g. 'urlprefix-'. The
+// other tags are added as tags of the routes. env contains the values
+// for the $x and ${x} variables in the host and path of the tags.
+// A nil env leaves the variables unexpanded.
+func RouteCmds(service, addr string, tags []string, prefix string, env map[string]string) []string {
+	var svctags, routetags []string
+	for _, t := range tags {
+		if strings.HasPrefix(t, prefix) {
+			routetags = append(routetags, t)
+		} else {
+			svctags = append(svctags, t)
+		}
+	}
+
+	var config []string
+	for _, tag := range routetags {
+		if route, opts, ok := ParseURLPrefixTag(tag, prefix, env); ok {
+			config = append(config, RouteCmd(service, route, addr, svctags, strings.Fields(opts)))
+		}
+	}
+	return config
+}
+
+// RouteCmd returns the route command for the service instance at addr
+// on the route src. The proto, weight and redirect options determine
+// the target and the weight. All other options are passed through.
+func RouteCmd(service, src, addr string, tags, opts []string) string {
+	dst := "http://" + addr + "/"
+
+	var weight string
+	var ropts []string
+	for _, o := range opts {
+		switch {
+		case o == "proto=tcp":
+			dst = "tcp://" + addr
+
+		case o == "proto=udp":
+			dst = "udp://" + addr
+
+		case o == "proto=https":
+			dst = "https://" + addr
+
+		case o == "proto=h2":
+			dst = "https://" + addr
+			ropts = append(ropts, o)
+
+		case o == "proto=grpcs":
+			dst = "grpcs://" + addr
+
+		case o == "proto=grpc":
+			dst = "grpc://" + addr
+
+		case strings.HasPrefix(o, "weight="):
+			weight = o[len("weight="):]
+
+		case strings.HasPrefix(o, "redirect="):
+			redir := strings.Split(o[len("redirect="):], ",")
+			if len(redir) == 2 {
+				dst = redir[1]
+				ropts = append(ropts, fmt.Sprintf("redirect=%s", redir[0]))
+			} else {
+				log.Printf("[ERROR] Invalid syntax for redirect: %s. should be redirect=<code>,<url>", o)
+				continue
+			}
+		default:
+			ropts = append(ropts, o)
+		}
+	}
+
+	cfg := "route add " + service + " " + src + " " + dst
+	if weight != "" {
+		cfg += " weight " + weight
+	}
+	if len(tags) > 0 {
+		cfg += " tags " + strconv.Quote(strings.Join(tags, ","))
+	}
+	if len(ropts) > 0 {
+		cfg += " opts " + strconv.Quote(strings.Join(ropts, " "))
+	}
+	return cfg
+}
+
+// SortRoutes sorts the route commands in reverse order so that the
+// most specific routes come first and joins them.
+func SortRoutes(config []string) string {
+	sort.Sort(sort.Reverse(sort.StringSlice(config)))
+	return strings.Join(config, "\n")
+}
+
+// ParseURLPrefixTag expects an input in the form of 'tag-host/path[ opts]'
+// and returns the lower cased host and the unaltered path if the
+// prefix matches the tag.
+func ParseURLPrefixTag(s, prefix string, env map[string]string) (route, opts string, ok bool) {
+	// expand $x or ${x} to env[x] or "". A nil env disables expansion.
+	expand := func(s string) string {
+		if env == nil {
+			return s
+		}
+		return os.Expand(s, func(x string) string { return env[x] })
+	}
+
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, prefix) {
+		return "", "", false
+	}
+	s = strings.TrimSpace(s[len(prefix):])
+
+	p := strings.SplitN(s, " ", 2)
+	if len(p) == 2 {
+		opts = p[1]
+	}
+	s = p[0]
+
+	// prefix is ":port"
+	if strings.HasPrefix(s, ":") {
+		return s, opts, true
+	}
+
+	if !strings.Contains(s, "/") {
+		return s, opts, true
+	}
+
+	// prefix is "host/path"
+	p = strings.SplitN(s, "/", 2)
+	host, path := p[0], p[1]
+	return strings.ToLower(expand(host)) + "/" + expand(path), opts, true
+}
@@ -39,18 +39,18 @@ func (b *be) WriteManual(path string, value string, version uint64) (ok bool, er
 	return false, nil
 }
 
-func (b *be) WatchServices() chan string {
-	ch := make(chan string, 1)
-	ch <- b.cfg.Routes
-	return ch
+func (b *be) WatchServices() *registry.Watch {
+	w := registry.NewWatch()
+	w.Publish(b.cfg.Routes)
+	return w
 }
 
-func (b *be) WatchManual() chan string {
-	return make(chan string)
+func (b *be) WatchManual() *registry.Watch {
+	return registry.NewWatch()
 }
 
-func (b *be) WatchNoRouteHTML() chan string {
-	ch := make(chan string, 1)
-	ch <- b.cfg.NoRouteHTML
-	return ch
+func (b *be) WatchNoRouteHTML() *registry.Watch {
+	w := registry.NewWatch()
+	w.Publish(b.cfg.NoRouteHTML)
+	return w
 }
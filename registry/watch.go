@@ -0,0 +1,90 @@
+package registry
+
+import "sync"
+
+// Snapshot is a value published by a backend together with its
+// sequence number.
+type Snapshot struct {
+	// Seq increases with every published value and is zero
+	// if no value has been published yet.
+	Seq uint64
+
+	// Value is the published value.
+	Value string
+
+	// Index identifies the state of the registry the value was read
+	// from. Values of different watches with the same index were read
+	// from the same state. It is zero if the backend does not provide
+	// consistent snapshots.
+	Index uint64
+}
+
+// Watch holds the latest value of a watched registry resource, e.g. the
+// routes generated from the service registrations or the manual
+// overrides.
+//
+// Publishing never blocks. A value which has not been read yet is
+// replaced by a newer one so that a burst of updates is coalesced into
+// a single one, readers never process stale intermediate values and the
+// memory used by pending updates stays bounded. A Watch supports a
+// single reader.
+type Watch struct {
+	mu   sync.Mutex
+	snap Snapshot
+	c    chan struct{}
+}
+
+// NewWatch creates a watch without a value.
+func NewWatch() *Watch {
+	return &Watch{c: make(chan struct{}, 1)}
+}
+
+// Publish stores v as the latest value, notifies the reader
+// and returns the sequence number of the value.
+func (w *Watch) Publish(v string) uint64 {
+	return w.PublishIndex(v, 0)
+}
+
+// PublishIndex is like Publish but also stores the
+// index of the registry state v was read from.
+func (w *Watch) PublishIndex(v string, index uint64) uint64 {
+	w.mu.Lock()
+	w.snap.Seq++
+	w.snap.Value = v
+	w.snap.Index = index
+	seq := w.snap.Seq
+	w.mu.Unlock()
+
+	select {
+	case w.c <- struct{}{}:
+	default:
+		// the reader has not seen the last notification yet
+	}
+	return seq
+}
+
+// Latest returns the latest published value.
+func (w *Watch) Latest() Snapshot {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.snap
+}
+
+// C returns a channel which receives a notification when a new
+// value has been published. Multiple values published before
+// the reader receives from the channel result in a single
+// notification.
+func (w *Watch) C() <-chan struct{} {
+	return w.c
+}
+
+// Next blocks until a value with a sequence number greater than seq
+// has been published and returns the latest value.
+func (w *Watch) Next(seq uint64) Snapshot {
+	for {
+		if s := w.Latest(); s.Seq > seq {
+			return s
+		}
+		<-w.c
+	}
+}
@@ -0,0 +1,69 @@
+package registry
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWatchCoalesce(t *testing.T) {
+	w := NewWatch()
+	if got, want := w.Latest(), (Snapshot{}); got != want {
+		t.Fatalf("got %v want %v", got, want)
+	}
+
+	// publishing never blocks and only the last value is kept
+	for _, v := range []string{"a", "b", "c"} {
+		w.Publish(v)
+	}
+	if got, want := w.Next(0), (Snapshot{Seq: 3, Value: "c"}); got != want {
+		t.Fatalf("got %v want %v", got, want)
+	}
+
+	select {
+	case <-w.C():
+		// the notification for the coalesced values
+	default:
+	}
+	select {
+	case <-w.C():
+		t.Fatal("got notification want none")
+	default:
+	}
+}
+
+func TestWatchNextBlocks(t *testing.T) {
+	w := NewWatch()
+	w.Publish("a")
+	s := w.Next(0)
+
+	done := make(chan Snapshot)
+	go func() { done <- w.Next(s.Seq) }()
+
+	select {
+	case <-done:
+		t.Fatal("Next returned without a new value")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	w.Publish("b")
+	select {
+	case got := <-done:
+		if want := (Snapshot{Seq: 2, Value: "b"}); got != want {
+			t.Fatalf("got %v want %v", got, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout")
+	}
+}
+
+func TestWatchPublishIndex(t *testing.T) {
+	w := NewWatch()
+	w.PublishIndex("a", 42)
+	if got, want := w.Latest(), (Snapshot{Seq: 1, Value: "a", Index: 42}); got != want {
+		t.Fatalf("got %v want %v", got, want)
+	}
+	w.Publish("b")
+	if got, want := w.Latest(), (Snapshot{Seq: 2, Value: "b"}); got != want {
+		t.Fatalf("got %v want %v", got, want)
+	}
+}
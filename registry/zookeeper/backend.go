@@ -0,0 +1,449 @@
+// Package zookeeper implements a registry backend which reads the
+// service registrations, the routes, the manual overrides and the
+// noroute HTML page from znodes in ZooKeeper.
+package zookeeper
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fabiolb/fabio/config"
+	"github.com/fabiolb/fabio/registry"
+)
+
+// retryInterval is the time to wait after a failed request.
+const retryInterval = time.Second
+
+// watchTimeout is the time after which the znodes are read again
+// even if no watch has fired.
+const watchTimeout = 5 * time.Minute
+
+type be struct {
+	c   *client
+	cfg *config.Zookeeper
+
+	// registered contains the paths of the ephemeral znodes
+	// of the registered services by service name.
+	mu         sync.Mutex
+	registered map[string]string
+}
+
+func NewBackend(cfg *config.Zookeeper) (registry.Backend, error) {
+	c, err := newClient(cfg.Addr, cfg.SessionTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	// establish the session
+	if _, err := c.exists("/", nil); err != nil {
+		return nil, err
+	}
+
+	log.Printf("[INFO] zookeeper: Connecting to %s", cfg.Addr)
+	b := &be{c: c, cfg: cfg, registered: map[string]string{}}
+	c.session = b.reregister
+	return b, nil
+}
+
+// Register creates an ephemeral znode below the services path for
+// every service and removes the znodes of the other services.
+func (b *be) Register(services []string) error {
+	if b.cfg.Register {
+		services = append(services, b.cfg.ServiceName)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// deregister unneeded services
+	for name := range b.registered {
+		if !contains(services, name) {
+			if err := b.deregister(name); err != nil {
+				return err
+			}
+		}
+	}
+
+	// register new services
+	for _, name := range services {
+		if _, ok := b.registered[name]; ok {
+			continue
+		}
+		path, err := b.register(name)
+		if err != nil {
+			return err
+		}
+		log.Printf("[INFO] zookeeper: Registered %q in %s", name, path)
+		b.registered[name] = path
+	}
+	return nil
+}
+
+func (b *be) Deregister(service string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.registered[service]; !ok {
+		log.Printf("[WARN] zookeeper: Attempted to deregister unknown service %q", service)
+		return nil
+	}
+	return b.deregister(service)
+}
+
+func (b *be) DeregisterAll() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for name := range b.registered {
+		if err := b.deregister(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// register creates the ephemeral znode of the service and returns its
+// path. A znode which is left over from a previous session is replaced
+// since it is deleted when that session expires. The caller must hold
+// mu.
+func (b *be) register(name string) (string, error) {
+	inst, err := b.instance(name)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(inst)
+	if err != nil {
+		return "", err
+	}
+	path := join(join(b.cfg.ServicesPath, name), inst.ID)
+	err = b.c.createAll(path, data, flagEphemeral)
+	if err == errNodeExists {
+		if err = b.c.del(path, -1); err == nil || err == errNoNode {
+			err = b.c.create(path, data, flagEphemeral)
+		}
+	}
+	return path, err
+}
+
+// deregister deletes the znode of the service. The caller must hold mu.
+func (b *be) deregister(name string) error {
+	path := b.registered[name]
+	if err := b.c.del(path, -1); err != nil && err != errNoNode {
+		return err
+	}
+	log.Printf("[INFO] zookeeper: Deregistered %q", name)
+	delete(b.registered, name)
+	return nil
+}
+
+// reregister creates the znodes of the registered services again
+// after the session has expired.
+func (b *be) reregister() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for name := range b.registered {
+		if _, err := b.register(name); err != nil {
+			log.Printf("[ERROR] zookeeper: Cannot register %q again. %s", name, err)
+			continue
+		}
+		log.Printf("[INFO] zookeeper: Registered %q again", name)
+	}
+}
+
+// instance returns the registration of fabio for the service.
+func (b *be) instance(name string) (instance, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return instance{}, err
+	}
+	host, portstr, err := net.SplitHostPort(b.cfg.ServiceAddr)
+	if err != nil {
+		return instance{}, err
+	}
+	port, err := strconv.Atoi(portstr)
+	if err != nil {
+		return instance{}, err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		ip, err = config.LocalIP()
+		if err != nil {
+			return instance{}, err
+		}
+		if ip == nil {
+			return instance{}, errors.New("no local ip")
+		}
+	}
+	return instance{
+		Name:    name,
+		ID:      fmt.Sprintf("%s-%s-%d", name, hostname, port),
+		Address: ip.String(),
+		Port:    port,
+	}, nil
+}
+
+func (b *be) ManualPaths() ([]string, error) {
+	nodes, err := b.readTree(b.cfg.KVPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, n := range nodes {
+		if n.data != "" {
+			paths = append(paths, n.path)
+		}
+	}
+	return paths, nil
+}
+
+// ReadManual returns the value of the znode and its version plus one
+// so that a missing znode has version zero.
+func (b *be) ReadManual(path string) (value string, version uint64, err error) {
+	data, st, err := b.c.get(b.cfg.KVPath+path, nil)
+	if err == errNoNode {
+		return "", 0, nil
+	}
+	if err != nil {
+		return "", 0, err
+	}
+	return strings.TrimSpace(string(data)), uint64(st.Version) + 1, nil
+}
+
+func (b *be) WriteManual(path string, value string, version uint64) (ok bool, err error) {
+	// try to create the znode first
+	err = b.c.createAll(b.cfg.KVPath+path, []byte(value), 0)
+	if err != errNodeExists {
+		return err == nil, err
+	}
+
+	// then try the update with the version
+	if version == 0 {
+		return false, nil
+	}
+	err = b.c.set(b.cfg.KVPath+path, []byte(value), int32(version-1))
+	if err == errBadVersion || err == errNoNode {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (b *be) DeleteManual(path string, version uint64) (ok bool, err error) {
+	if version == 0 {
+		return false, nil
+	}
+	err = b.c.del(b.cfg.KVPath+path, int32(version-1))
+	if err == errBadVersion || err == errNoNode {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (b *be) WatchServices() *registry.Watch {
+	log.Printf("[INFO] zookeeper: Watching services in %q and routes in %q", b.cfg.ServicesPath, b.cfg.RoutesPath)
+
+	svc := registry.NewWatch()
+	go b.watch(b.cfg.RoutesPath, svc, b.readServices)
+	return svc
+}
+
+func (b *be) WatchManual() *registry.Watch {
+	log.Printf("[INFO] zookeeper: Watching KV path %q", b.cfg.KVPath)
+
+	kv := registry.NewWatch()
+	go b.watch(b.cfg.KVPath, kv, func(w chan struct{}) (string, error) {
+		nodes, err := b.readTree(b.cfg.KVPath, w)
+		return formatTree(nodes), err
+	})
+	return kv
+}
+
+func (b *be) WatchNoRouteHTML() *registry.Watch {
+	log.Printf("[INFO] zookeeper: Watching KV path %q", b.cfg.NoRouteHTMLPath)
+
+	html := registry.NewWatch()
+	go b.watch(b.cfg.NoRouteHTMLPath, html, func(w chan struct{}) (string, error) {
+		for {
+			data, _, err := b.c.get(b.cfg.NoRouteHTMLPath, w)
+			if err != errNoNode {
+				return strings.TrimSpace(string(data)), err
+			}
+			// watch for the creation of the znode
+			if ok, err := b.c.exists(b.cfg.NoRouteHTMLPath, w); !ok || err != nil {
+				return "", err
+			}
+		}
+	})
+	return html
+}
+
+// watch publishes the value returned by read and reads it again when
+// one of the watches which read has set on the znodes fires. The
+// same channel is used for all watches so that the watches of znodes
+// which have not changed are not registered again.
+func (b *be) watch(path string, w *registry.Watch, read func(w chan struct{}) (string, error)) {
+	var lastValue string
+	var published bool
+
+	changed := make(chan struct{}, 1)
+	for {
+		value, err := read(changed)
+		if err != nil {
+			log.Printf("[WARN] zookeeper: Error fetching config from %s. %v", path, err)
+			time.Sleep(retryInterval)
+			continue
+		}
+
+		if !published || value != lastValue {
+			log.Printf("[DEBUG] zookeeper: Config in %s changed", path)
+			w.Publish(value)
+			lastValue, published = value, true
+		}
+
+		t := time.NewTimer(watchTimeout)
+		select {
+		case <-changed:
+		case <-t.C:
+		}
+		t.Stop()
+	}
+}
+
+// readServices returns the route commands of the service registrations
+// followed by the route commands in the znodes below the routes path.
+func (b *be) readServices(w chan struct{}) (string, error) {
+	regs, err := b.readRegistrations(w)
+	if err != nil {
+		return "", err
+	}
+	nodes, err := b.readTree(b.cfg.RoutesPath, w)
+	if err != nil {
+		return "", err
+	}
+
+	var s []string
+	if routes := buildRoutes(regs, b.cfg.TagPrefix); routes != "" {
+		s = append(s, routes)
+	}
+	if routes := formatTree(nodes); routes != "" {
+		s = append(s, routes)
+	}
+	return strings.Join(s, "\n\n"), nil
+}
+
+// readRegistrations returns the service instances which are registered
+// in the znodes <services path>/<service>/<instance>. Instances with an
+// invalid registration are skipped.
+func (b *be) readRegistrations(w chan struct{}) ([]instance, error) {
+	services, err := b.c.children(b.cfg.ServicesPath, w)
+	if err == errNoNode {
+		// watch for the creation of the znode
+		ok, err := b.c.exists(b.cfg.ServicesPath, w)
+		if ok && err == nil {
+			return b.readRegistrations(w)
+		}
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var regs []instance
+	for _, service := range services {
+		path := join(b.cfg.ServicesPath, service)
+		ids, err := b.c.children(path, w)
+		if err == errNoNode {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range ids {
+			data, _, err := b.c.get(join(path, id), w)
+			if err == errNoNode {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			r, err := parseInstance(service, data)
+			if err != nil {
+				log.Printf("[WARN] zookeeper: Skipping invalid registration %s. %s", join(path, id), err)
+				continue
+			}
+			regs = append(regs, r)
+		}
+	}
+	return regs, nil
+}
+
+// node is the path and the data of a znode.
+type node struct {
+	path, data string
+}
+
+// readTree returns the znode and its descendants in depth-first order
+// with the children sorted by name. If w is not nil it is notified
+// when one of the znodes or the list of their children changes.
+func (b *be) readTree(path string, w chan struct{}) ([]node, error) {
+	data, _, err := b.c.get(path, w)
+	if err == errNoNode {
+		if w == nil {
+			return nil, nil
+		}
+		// watch for the creation of the znode
+		ok, err := b.c.exists(path, w)
+		if ok && err == nil {
+			return b.readTree(path, w)
+		}
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := []node{{path, strings.TrimSpace(string(data))}}
+	children, err := b.c.children(path, w)
+	if err == errNoNode {
+		// deleted in the meantime
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range children {
+		sub, err := b.readTree(join(path, name), w)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, sub...)
+	}
+	return nodes, nil
+}
+
+// formatTree returns the data of the znodes which are not empty
+// separated by a comment with the path.
+func formatTree(nodes []node) string {
+	var s []string
+	for _, n := range nodes {
+		if n.data == "" {
+			continue
+		}
+		s = append(s, "# --- "+n.path+"\n"+n.data)
+	}
+	return strings.Join(s, "\n\n")
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
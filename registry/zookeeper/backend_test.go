@@ -0,0 +1,621 @@
+package zookeeper
+
+import (
+	"encoding/json"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fabiolb/fabio/config"
+	"github.com/fabiolb/fabio/registry"
+)
+
+// fakeZK implements the parts of the ZooKeeper protocol
+// which are used by the client.
+type fakeZK struct {
+	mu       sync.Mutex
+	zxid     int64
+	nodes    map[string]*znode
+	watches  map[string]map[*fakeConn]bool
+	cwatches map[string]map[*fakeConn]bool
+	sessions int64
+	expired  map[int64]bool
+	conns    map[*fakeConn]bool
+	ln       net.Listener
+}
+
+type znode struct {
+	data    []byte
+	version int32
+	owner   int64
+}
+
+type fakeConn struct {
+	net.Conn
+	session int64
+}
+
+func newFakeZK() *fakeZK {
+	return &fakeZK{
+		nodes:    map[string]*znode{"/": {}},
+		watches:  map[string]map[*fakeConn]bool{},
+		cwatches: map[string]map[*fakeConn]bool{},
+		expired:  map[int64]bool{},
+		conns:    map[*fakeConn]bool{},
+	}
+}
+
+// start starts the server and returns its address.
+func (f *fakeZK) start(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.ln = ln
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// stop stops the server and closes all connections.
+func (f *fakeZK) stop() {
+	f.ln.Close()
+	f.drop()
+}
+
+// drop closes all connections.
+func (f *fakeZK) drop() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for c := range f.conns {
+		c.Close()
+	}
+}
+
+// expire ends the session, deletes its ephemeral
+// nodes and closes its connections.
+func (f *fakeZK) expire(session int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.expired[session] = true
+	f.closeSession(session)
+	for c := range f.conns {
+		if c.session == session {
+			c.Close()
+		}
+	}
+}
+
+func (f *fakeZK) serve(nc net.Conn) {
+	defer nc.Close()
+	b, err := readPacket(nc)
+	if err != nil {
+		return
+	}
+	d := &decoder{b: b}
+	d.int32()
+	d.int64()
+	timeout := d.int32()
+	session := d.int64()
+
+	f.mu.Lock()
+	e := &encoder{}
+	e.int32(0)
+	if session != 0 && f.expired[session] {
+		e.int32(0)
+		e.int64(0)
+		e.bytes(make([]byte, 16))
+		writePacket(nc, e.b)
+		f.mu.Unlock()
+		return
+	}
+	if session == 0 {
+		f.sessions++
+		session = f.sessions
+	}
+	c := &fakeConn{Conn: nc, session: session}
+	f.conns[c] = true
+	e.int32(timeout)
+	e.int64(session)
+	e.bytes(make([]byte, 16))
+	writePacket(nc, e.b)
+	f.mu.Unlock()
+
+	defer func() {
+		f.mu.Lock()
+		delete(f.conns, c)
+		for _, m := range []map[string]map[*fakeConn]bool{f.watches, f.cwatches} {
+			for _, w := range m {
+				delete(w, c)
+			}
+		}
+		f.mu.Unlock()
+	}()
+
+	for {
+		b, err := readPacket(nc)
+		if err != nil {
+			return
+		}
+		d := &decoder{b: b}
+		xid, op := d.int32(), d.int32()
+		f.mu.Lock()
+		body, code := f.handle(c, op, d)
+		e := &encoder{}
+		e.int32(xid)
+		e.int64(f.zxid)
+		e.int32(int32(code))
+		if code == 0 {
+			e.b = append(e.b, body...)
+		}
+		writePacket(nc, e.b)
+		f.mu.Unlock()
+		if op == opClose {
+			return
+		}
+	}
+}
+
+// handle executes the request. The caller must hold mu.
+func (f *fakeZK) handle(c *fakeConn, op int32, d *decoder) ([]byte, zkError) {
+	e := &encoder{}
+	switch op {
+	case opPing:
+		return nil, 0
+
+	case opClose:
+		f.closeSession(c.session)
+		return nil, 0
+
+	case opGetData, opExists:
+		path, watch := d.string(), d.bool()
+		n := f.nodes[path]
+		if watch && (n != nil || op == opExists) {
+			addWatch(f.watches, path, c)
+		}
+		if n == nil {
+			return nil, errNoNode
+		}
+		if op == opGetData {
+			e.bytes(n.data)
+		}
+		f.stat(e, path)
+		return e.b, 0
+
+	case opGetChildren:
+		path, watch := d.string(), d.bool()
+		if f.nodes[path] == nil {
+			return nil, errNoNode
+		}
+		if watch {
+			addWatch(f.cwatches, path, c)
+		}
+		names := f.children(path)
+		e.int32(int32(len(names)))
+		for _, name := range names {
+			e.string(name)
+		}
+		return e.b, 0
+
+	case opCreate:
+		path, data := d.string(), d.bytes()
+		for n := d.int32(); n > 0; n-- {
+			d.int32()
+			d.string()
+			d.string()
+		}
+		flags := d.int32()
+		if f.nodes[path] != nil {
+			return nil, errNodeExists
+		}
+		if f.nodes[parent(path)] == nil {
+			return nil, errNoNode
+		}
+		var owner int64
+		if flags&flagEphemeral != 0 {
+			owner = c.session
+		}
+		f.create(path, data, owner)
+		e.string(path)
+		return e.b, 0
+
+	case opSetData:
+		path, data, version := d.string(), d.bytes(), d.int32()
+		n := f.nodes[path]
+		switch {
+		case n == nil:
+			return nil, errNoNode
+		case version != -1 && version != n.version:
+			return nil, errBadVersion
+		}
+		f.zxid++
+		n.data, n.version = data, n.version+1
+		f.fire(f.watches, path, 3)
+		f.stat(e, path)
+		return e.b, 0
+
+	case opDelete:
+		path, version := d.string(), d.int32()
+		n := f.nodes[path]
+		switch {
+		case n == nil:
+			return nil, errNoNode
+		case version != -1 && version != n.version:
+			return nil, errBadVersion
+		case len(f.children(path)) > 0:
+			return nil, errNotEmpty
+		}
+		f.delete(path)
+		return nil, 0
+	}
+	return nil, zkError(-6)
+}
+
+func (f *fakeZK) stat(e *encoder, path string) {
+	n := f.nodes[path]
+	e.int64(0)
+	e.int64(0)
+	e.int64(0)
+	e.int64(0)
+	e.int32(n.version)
+	e.int32(0)
+	e.int32(0)
+	e.int64(n.owner)
+	e.int32(int32(len(n.data)))
+	e.int32(int32(len(f.children(path))))
+	e.int64(0)
+}
+
+func (f *fakeZK) children(path string) []string {
+	var names []string
+	for p := range f.nodes {
+		if p != "/" && parent(p) == path {
+			names = append(names, p[strings.LastIndex(p, "/")+1:])
+		}
+	}
+	return names
+}
+
+// create creates the node and fires the watches. The caller must hold mu.
+func (f *fakeZK) create(path string, data []byte, owner int64) {
+	f.zxid++
+	f.nodes[path] = &znode{data: data, owner: owner}
+	f.fire(f.watches, path, 1)
+	f.fire(f.cwatches, parent(path), 4)
+}
+
+// delete deletes the node and fires the watches. The caller must hold mu.
+func (f *fakeZK) delete(path string) {
+	f.zxid++
+	delete(f.nodes, path)
+	f.fire(f.watches, path, 2)
+	f.fire(f.cwatches, path, 2)
+	f.fire(f.cwatches, parent(path), 4)
+}
+
+// closeSession deletes the ephemeral nodes of the session.
+// The caller must hold mu.
+func (f *fakeZK) closeSession(session int64) {
+	for p, n := range f.nodes {
+		if n.owner == session {
+			f.delete(p)
+		}
+	}
+}
+
+// fire sends the watch event to the watchers of the path.
+// The caller must hold mu.
+func (f *fakeZK) fire(watches map[string]map[*fakeConn]bool, path string, typ int32) {
+	for c := range watches[path] {
+		e := &encoder{}
+		e.int32(xidWatch)
+		e.int64(-1)
+		e.int32(0)
+		e.int32(typ)
+		e.int32(3)
+		e.string(path)
+		writePacket(c, e.b)
+	}
+	delete(watches, path)
+}
+
+// set stores the data in the node and creates the missing parents.
+func (f *fakeZK) set(path, data string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := 1; i < len(path); i++ {
+		if path[i] == '/' && f.nodes[path[:i]] == nil {
+			f.create(path[:i], nil, 0)
+		}
+	}
+	if n := f.nodes[path]; n != nil {
+		f.zxid++
+		n.data, n.version = []byte(data), n.version+1
+		f.fire(f.watches, path, 3)
+		return
+	}
+	f.create(path, []byte(data), 0)
+}
+
+// del deletes the node.
+func (f *fakeZK) del(path string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.delete(path)
+}
+
+// get returns the node.
+func (f *fakeZK) get(path string) *znode {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if n := f.nodes[path]; n != nil {
+		return &znode{data: n.data, version: n.version, owner: n.owner}
+	}
+	return nil
+}
+
+func addWatch(watches map[string]map[*fakeConn]bool, path string, c *fakeConn) {
+	if watches[path] == nil {
+		watches[path] = map[*fakeConn]bool{}
+	}
+	watches[path][c] = true
+}
+
+func parent(path string) string {
+	i := strings.LastIndex(path, "/")
+	if i <= 0 {
+		return "/"
+	}
+	return path[:i]
+}
+
+func newTestBackend(t *testing.T, addr string) *be {
+	t.Helper()
+	cfg := &config.Zookeeper{
+		Addr:            addr,
+		SessionTimeout:  5 * time.Second,
+		ServicesPath:    "/fabio/services",
+		TagPrefix:       "urlprefix-",
+		RoutesPath:      "/fabio/routes",
+		KVPath:          "/fabio/config",
+		NoRouteHTMLPath: "/fabio/noroute.html",
+		ServiceName:     "fabio",
+		ServiceAddr:     "10.0.0.9:9998",
+	}
+	b, err := NewBackend(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b.(*be)
+}
+
+func TestNewBackend(t *testing.T) {
+	zk := newFakeZK()
+	addr := zk.start(t)
+	zk.stop()
+
+	if _, err := NewBackend(&config.Zookeeper{Addr: addr, SessionTimeout: time.Second}); err == nil {
+		t.Fatal("got nil want error")
+	}
+}
+
+func TestFailover(t *testing.T) {
+	zk := newFakeZK()
+	addr := zk.start(t)
+	defer zk.stop()
+
+	down := newFakeZK()
+	downAddr := down.start(t)
+	down.stop()
+
+	b := newTestBackend(t, downAddr+","+addr)
+	defer b.c.close()
+	if got, want := b.c.cur, 1; got != want {
+		t.Fatalf("got server %d want %d", got, want)
+	}
+}
+
+func TestWatchServices(t *testing.T) {
+	zk := newFakeZK()
+	zk.set("/fabio/services/web/1", `{"address":"10.0.0.1","port":8080,"tags":["urlprefix-/"]}`)
+	addr := zk.start(t)
+	defer zk.stop()
+
+	b := newTestBackend(t, addr)
+	defer b.c.close()
+	w := b.WatchServices()
+	snap := waitFor(t, w.Next, 0, "route add web / http://10.0.0.1:8080/")
+
+	zk.set("/fabio/services/web/2", `{"address":"10.0.0.2","port":8080,"payload":{"tags":["urlprefix-/"]}}`)
+	snap = waitFor(t, w.Next, snap, "route add web / http://10.0.0.2:8080/\nroute add web / http://10.0.0.1:8080/")
+
+	zk.set("/fabio/routes/api", "route add api /api http://10.0.0.3:8080/")
+	snap = waitFor(t, w.Next, snap, "route add web / http://10.0.0.2:8080/\nroute add web / http://10.0.0.1:8080/\n\n"+
+		"# --- /fabio/routes/api\nroute add api /api http://10.0.0.3:8080/")
+
+	// invalid registrations are skipped
+	zk.set("/fabio/services/web/1", `{"address":"10.0.0.1"}`)
+	zk.del("/fabio/routes/api")
+	waitFor(t, w.Next, snap, "route add web / http://10.0.0.2:8080/")
+}
+
+func TestWatchNoRouteHTML(t *testing.T) {
+	zk := newFakeZK()
+	addr := zk.start(t)
+	defer zk.stop()
+
+	b := newTestBackend(t, addr)
+	defer b.c.close()
+	w := b.WatchNoRouteHTML()
+	snap := waitFor(t, w.Next, 0, "")
+
+	zk.set("/fabio/noroute.html", "<h1>no route</h1>")
+	waitFor(t, w.Next, snap, "<h1>no route</h1>")
+}
+
+func TestReconnect(t *testing.T) {
+	zk := newFakeZK()
+	zk.set("/fabio/routes/web", "route add web / http://10.0.0.1:8080/")
+	addr := zk.start(t)
+	defer zk.stop()
+
+	b := newTestBackend(t, addr)
+	defer b.c.close()
+	w := b.WatchServices()
+	snap := waitFor(t, w.Next, 0, "# --- /fabio/routes/web\nroute add web / http://10.0.0.1:8080/")
+
+	// the watches are set again after the connection was lost
+	zk.drop()
+	time.Sleep(100 * time.Millisecond)
+	zk.set("/fabio/routes/web", "route add web / http://10.0.0.2:8080/")
+	waitFor(t, w.Next, snap, "# --- /fabio/routes/web\nroute add web / http://10.0.0.2:8080/")
+}
+
+func TestManual(t *testing.T) {
+	zk := newFakeZK()
+	addr := zk.start(t)
+	defer zk.stop()
+
+	b := newTestBackend(t, addr)
+	defer b.c.close()
+	w := b.WatchManual()
+	snap := waitFor(t, w.Next, 0, "")
+
+	ok, err := b.WriteManual("", "route del web", 0)
+	if err != nil || !ok {
+		t.Fatalf("got %v, %v want true, nil", ok, err)
+	}
+	value, version, err := b.ReadManual("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := value, "route del web"; got != want {
+		t.Fatalf("got value %q want %q", got, want)
+	}
+	snap = waitFor(t, w.Next, snap, "# --- /fabio/config\nroute del web")
+
+	// updates with a stale version fail
+	if ok, err := b.WriteManual("", "route del api", 0); err != nil || ok {
+		t.Fatalf("got %v, %v want false, nil", ok, err)
+	}
+	if ok, err := b.WriteManual("", "route del api", version+1); err != nil || ok {
+		t.Fatalf("got %v, %v want false, nil", ok, err)
+	}
+	if ok, err := b.WriteManual("", "route del api", version); err != nil || !ok {
+		t.Fatalf("got %v, %v want true, nil", ok, err)
+	}
+
+	// missing parents are created
+	if ok, err := b.WriteManual("/routes/canary", "route weight web / weight 0.1", 0); err != nil || !ok {
+		t.Fatalf("got %v, %v want true, nil", ok, err)
+	}
+	waitFor(t, w.Next, snap, "# --- /fabio/config\nroute del api\n\n# --- /fabio/config/routes/canary\nroute weight web / weight 0.1")
+
+	paths, err := b.ManualPaths()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := paths, []string{"/fabio/config", "/fabio/config/routes/canary"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got paths %q want %q", got, want)
+	}
+
+	// deletes with a stale version fail
+	_, version, err = b.ReadManual("/routes/canary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := b.DeleteManual("/routes/canary", version+1); err != nil || ok {
+		t.Fatalf("got %v, %v want false, nil", ok, err)
+	}
+	if ok, err := b.DeleteManual("/routes/canary", version); err != nil || !ok {
+		t.Fatalf("got %v, %v want true, nil", ok, err)
+	}
+	if value, version, err := b.ReadManual("/routes/canary"); err != nil || value != "" || version != 0 {
+		t.Fatalf("got %q, %d, %v want empty value", value, version, err)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	zk := newFakeZK()
+	addr := zk.start(t)
+	defer zk.stop()
+
+	b := newTestBackend(t, addr)
+	defer b.c.close()
+	b.cfg.Register = true
+	if err := b.Register([]string{"alias"}); err != nil {
+		t.Fatal(err)
+	}
+	path := b.registered["fabio"]
+	n := zk.get(path)
+	if n == nil {
+		t.Fatalf("%s not registered", path)
+	}
+	var inst instance
+	if err := json.Unmarshal(n.data, &inst); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := inst.Address+" "+inst.Name, "10.0.0.9 fabio"; got != want || inst.Port != 9998 {
+		t.Fatalf("got %+v want %s:9998", inst, want)
+	}
+	if n.owner == 0 {
+		t.Fatal("registration is not ephemeral")
+	}
+
+	// the services are registered again in a new session
+	zk.expire(n.owner)
+	waitUntil(t, func() bool { n := zk.get(path); return n != nil && zk.get(b.registered["alias"]) != nil })
+
+	if err := b.Deregister("alias"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.DeregisterAll(); err != nil {
+		t.Fatal(err)
+	}
+	if zk.get(path) != nil {
+		t.Fatalf("%s still registered", path)
+	}
+}
+
+// waitFor waits until the watch publishes the wanted value and
+// returns its sequence number.
+func waitFor(t *testing.T, next func(uint64) registry.Snapshot, seq uint64, want string) uint64 {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		c := make(chan registry.Snapshot, 1)
+		go func() { c <- next(seq) }()
+		select {
+		case s := <-c:
+			if s.Value == want {
+				return s.Seq
+			}
+			seq = s.Seq
+		case <-timeout:
+			t.Fatalf("timeout waiting for %q", want)
+		}
+	}
+}
+
+// waitUntil waits until the condition is true.
+func waitUntil(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
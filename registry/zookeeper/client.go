@@ -0,0 +1,663 @@
+package zookeeper
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The operations of the ZooKeeper protocol which are used by the client.
+const (
+	opCreate      = 1
+	opDelete      = 2
+	opExists      = 3
+	opGetData     = 4
+	opSetData     = 5
+	opGetChildren = 8
+	opPing        = 11
+	opClose       = -11
+)
+
+// The reserved xids of watch events and ping responses.
+const (
+	xidWatch = -1
+	xidPing  = -2
+)
+
+// flagEphemeral creates a znode which is deleted
+// when the session of its owner ends.
+const flagEphemeral = 1
+
+// permAll grants all permissions in the ACL of a new znode.
+const permAll = 31
+
+// maxPacket limits the size of a response.
+const maxPacket = 64 << 20
+
+// zkError is an error code returned by the server.
+type zkError int32
+
+const (
+	errNoNode     zkError = -101
+	errNoAuth     zkError = -102
+	errBadVersion zkError = -103
+	errNodeExists zkError = -110
+	errNotEmpty   zkError = -111
+)
+
+func (e zkError) Error() string {
+	switch e {
+	case errNoNode:
+		return "zookeeper: node does not exist"
+	case errNoAuth:
+		return "zookeeper: not authorized"
+	case errBadVersion:
+		return "zookeeper: version conflict"
+	case errNodeExists:
+		return "zookeeper: node already exists"
+	case errNotEmpty:
+		return "zookeeper: node has children"
+	}
+	return fmt.Sprintf("zookeeper: error %d", int32(e))
+}
+
+var (
+	errSessionExpired = errors.New("zookeeper: session expired")
+	errTimeout        = errors.New("zookeeper: request timeout")
+	errShortPacket    = errors.New("zookeeper: short packet")
+)
+
+// stat is the metadata of a znode.
+type stat struct {
+	Czxid, Mzxid   int64
+	Version        int32
+	EphemeralOwner int64
+	DataLength     int32
+	NumChildren    int32
+}
+
+// client is a minimal client for the ZooKeeper protocol with a single
+// connection. If the connection is lost the next request connects to
+// the next server and resumes the session. All watches are triggered
+// when the connection is lost since they are not restored.
+type client struct {
+	servers []string
+
+	// session is called when a new session has been
+	// established after the previous one has expired.
+	session func()
+
+	mu        sync.Mutex
+	conn      net.Conn
+	closed    bool
+	cur       int
+	timeout   time.Duration
+	sessionID int64
+	passwd    []byte
+	lastZxid  int64
+	xid       int32
+	pending   map[int32]*call
+	watchers  map[string]map[chan struct{}]bool
+}
+
+// call is a request which waits for its response.
+type call struct {
+	path   string
+	watch  chan struct{}
+	exists bool
+	done   chan reply
+}
+
+type reply struct {
+	body []byte
+	err  error
+}
+
+func newClient(addr string, timeout time.Duration) (*client, error) {
+	var servers []string
+	for _, a := range strings.Split(addr, ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			servers = append(servers, a)
+		}
+	}
+	if len(servers) == 0 {
+		return nil, errors.New("zookeeper: no servers")
+	}
+	return &client{
+		servers:  servers,
+		timeout:  timeout,
+		pending:  map[int32]*call{},
+		watchers: map[string]map[chan struct{}]bool{},
+	}, nil
+}
+
+// connect connects to the next server which can be reached unless
+// the client is connected. The caller must hold mu.
+func (c *client) connect() error {
+	if c.conn != nil {
+		return nil
+	}
+	var err error
+	for i := 0; i < len(c.servers); i++ {
+		addr := c.servers[c.cur]
+		err = c.handshake(addr)
+		if err == errSessionExpired {
+			log.Printf("[WARN] zookeeper: Session expired. Starting a new one")
+			if err = c.handshake(addr); err == nil && c.session != nil {
+				go c.session()
+			}
+		}
+		if err == nil {
+			return nil
+		}
+		c.cur = (c.cur + 1) % len(c.servers)
+	}
+	return err
+}
+
+// handshake establishes a new session or resumes the current one
+// with the server. The caller must hold mu.
+func (c *client) handshake(addr string) error {
+	conn, err := net.DialTimeout("tcp", addr, c.timeout)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(c.timeout))
+
+	passwd := c.passwd
+	if passwd == nil {
+		passwd = make([]byte, 16)
+	}
+	e := &encoder{}
+	e.int32(0) // protocol version
+	e.int64(c.lastZxid)
+	e.int32(int32(c.timeout / time.Millisecond))
+	e.int64(c.sessionID)
+	e.bytes(passwd)
+	if err := writePacket(conn, e.b); err != nil {
+		conn.Close()
+		return err
+	}
+	b, err := readPacket(conn)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	d := &decoder{b: b}
+	d.int32() // protocol version
+	timeout := d.int32()
+	sessionID := d.int64()
+	passwd = d.bytes()
+	if d.err != nil {
+		conn.Close()
+		return d.err
+	}
+	if timeout <= 0 {
+		conn.Close()
+		c.sessionID, c.passwd = 0, nil
+		return errSessionExpired
+	}
+	conn.SetDeadline(time.Time{})
+
+	c.conn, c.sessionID, c.passwd = conn, sessionID, passwd
+	c.timeout = time.Duration(timeout) * time.Millisecond
+	go c.recv(conn)
+	go c.ping(conn)
+
+	log.Printf("[INFO] zookeeper: Connected to %s with session 0x%x", addr, sessionID)
+	return nil
+}
+
+// disconnect closes the connection and fails all pending requests.
+// All watches are triggered so that the watchers read their nodes
+// again after the client has reconnected.
+func (c *client) disconnect(conn net.Conn, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn != conn {
+		return
+	}
+	log.Printf("[WARN] zookeeper: Connection to %s lost. %s", c.servers[c.cur], err)
+	conn.Close()
+	c.conn = nil
+	c.cur = (c.cur + 1) % len(c.servers)
+	for xid, call := range c.pending {
+		call.done <- reply{err: err}
+		delete(c.pending, xid)
+	}
+	for path := range c.watchers {
+		c.trigger(path)
+	}
+	go c.reconnect()
+}
+
+// reconnect connects to the next server in the background so that
+// the session is resumed before it expires.
+func (c *client) reconnect() {
+	for {
+		c.mu.Lock()
+		if c.closed || c.conn != nil {
+			c.mu.Unlock()
+			return
+		}
+		err := c.connect()
+		c.mu.Unlock()
+		if err == nil {
+			return
+		}
+		log.Printf("[WARN] zookeeper: Cannot reconnect. %s", err)
+		time.Sleep(retryInterval)
+	}
+}
+
+// recv reads the responses and the watch events from the connection.
+func (c *client) recv(conn net.Conn) {
+	for {
+		conn.SetReadDeadline(time.Now().Add(c.readTimeout()))
+		b, err := readPacket(conn)
+		if err != nil {
+			c.disconnect(conn, err)
+			return
+		}
+		d := &decoder{b: b}
+		xid, zxid, code := d.int32(), d.int64(), d.int32()
+		if d.err != nil {
+			c.disconnect(conn, d.err)
+			return
+		}
+
+		switch xid {
+		case xidPing:
+			continue
+		case xidWatch:
+			typ, _, path := d.int32(), d.int32(), d.string()
+			// events without a type report state changes
+			if d.err == nil && typ > 0 {
+				c.mu.Lock()
+				c.trigger(path)
+				c.mu.Unlock()
+			}
+			continue
+		}
+
+		c.mu.Lock()
+		if zxid > 0 {
+			c.lastZxid = zxid
+		}
+		call := c.pending[xid]
+		delete(c.pending, xid)
+		// the server sets the watch of an exists request
+		// also if the node does not exist
+		if call != nil && call.watch != nil && (code == 0 || (call.exists && zkError(code) == errNoNode)) {
+			m := c.watchers[call.path]
+			if m == nil {
+				m = map[chan struct{}]bool{}
+				c.watchers[call.path] = m
+			}
+			m[call.watch] = true
+		}
+		c.mu.Unlock()
+
+		if call == nil {
+			continue
+		}
+		if code != 0 {
+			call.done <- reply{err: zkError(code)}
+			continue
+		}
+		call.done <- reply{body: d.b}
+	}
+}
+
+// ping keeps the session alive while the connection is idle.
+func (c *client) ping(conn net.Conn) {
+	for {
+		c.mu.Lock()
+		d := c.timeout / 3
+		c.mu.Unlock()
+		time.Sleep(d)
+
+		c.mu.Lock()
+		if c.conn != conn {
+			c.mu.Unlock()
+			return
+		}
+		e := &encoder{}
+		e.int32(xidPing)
+		e.int32(opPing)
+		conn.SetWriteDeadline(time.Now().Add(c.timeout))
+		err := writePacket(conn, e.b)
+		c.mu.Unlock()
+		if err != nil {
+			c.disconnect(conn, err)
+			return
+		}
+	}
+}
+
+func (c *client) readTimeout() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.timeout
+}
+
+// trigger notifies the watchers of the path and removes them since
+// watches only fire once. The caller must hold mu.
+func (c *client) trigger(path string) {
+	for ch := range c.watchers[path] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+	delete(c.watchers, path)
+}
+
+// do sends the request and waits for the response. If watch is not
+// nil it is notified when the node of the request changes.
+func (c *client) do(op int32, body []byte, path string, watch chan struct{}) ([]byte, error) {
+	c.mu.Lock()
+	if err := c.connect(); err != nil {
+		c.mu.Unlock()
+		return nil, err
+	}
+	c.xid++
+	if c.xid <= 0 {
+		c.xid = 1
+	}
+	xid := c.xid
+	call := &call{path: path, watch: watch, exists: op == opExists, done: make(chan reply, 1)}
+	c.pending[xid] = call
+
+	e := &encoder{}
+	e.int32(xid)
+	e.int32(op)
+	e.b = append(e.b, body...)
+	conn, timeout := c.conn, c.timeout
+	conn.SetWriteDeadline(time.Now().Add(timeout))
+	err := writePacket(conn, e.b)
+	c.mu.Unlock()
+	if err != nil {
+		c.disconnect(conn, err)
+		return nil, err
+	}
+
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case r := <-call.done:
+		return r.body, r.err
+	case <-t.C:
+		c.mu.Lock()
+		delete(c.pending, xid)
+		c.mu.Unlock()
+		return nil, errTimeout
+	}
+}
+
+// get returns the data and the metadata of the node.
+func (c *client) get(path string, watch chan struct{}) ([]byte, stat, error) {
+	e := &encoder{}
+	e.string(path)
+	e.bool(watch != nil)
+	b, err := c.do(opGetData, e.b, path, watch)
+	if err != nil {
+		return nil, stat{}, err
+	}
+	d := &decoder{b: b}
+	data := d.bytes()
+	st := d.stat()
+	return data, st, d.err
+}
+
+// children returns the sorted names of the children of the node.
+func (c *client) children(path string, watch chan struct{}) ([]string, error) {
+	e := &encoder{}
+	e.string(path)
+	e.bool(watch != nil)
+	b, err := c.do(opGetChildren, e.b, path, watch)
+	if err != nil {
+		return nil, err
+	}
+	d := &decoder{b: b}
+	n := d.int32()
+	var names []string
+	for i := int32(0); i < n && d.err == nil; i++ {
+		names = append(names, d.string())
+	}
+	sort.Strings(names)
+	return names, d.err
+}
+
+// exists returns true if the node exists. The watch is also
+// notified when a missing node is created.
+func (c *client) exists(path string, watch chan struct{}) (bool, error) {
+	e := &encoder{}
+	e.string(path)
+	e.bool(watch != nil)
+	_, err := c.do(opExists, e.b, path, watch)
+	if err == errNoNode {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// create creates the node with an ACL which grants all permissions
+// to everyone.
+func (c *client) create(path string, data []byte, flags int32) error {
+	e := &encoder{}
+	e.string(path)
+	e.bytes(data)
+	e.int32(1)
+	e.int32(permAll)
+	e.string("world")
+	e.string("anyone")
+	e.int32(flags)
+	_, err := c.do(opCreate, e.b, path, nil)
+	return err
+}
+
+// createAll creates the node and its missing parents. The parents
+// are created as persistent nodes without data.
+func (c *client) createAll(path string, data []byte, flags int32) error {
+	err := c.create(path, data, flags)
+	if err != errNoNode {
+		return err
+	}
+	for i := 1; i < len(path); i++ {
+		if path[i] != '/' {
+			continue
+		}
+		if err := c.create(path[:i], nil, 0); err != nil && err != errNodeExists {
+			return err
+		}
+	}
+	return c.create(path, data, flags)
+}
+
+// set updates the data of the node if it has the given version.
+// A version of -1 matches any version.
+func (c *client) set(path string, data []byte, version int32) error {
+	e := &encoder{}
+	e.string(path)
+	e.bytes(data)
+	e.int32(version)
+	_, err := c.do(opSetData, e.b, path, nil)
+	return err
+}
+
+// del deletes the node if it has the given version.
+// A version of -1 matches any version.
+func (c *client) del(path string, version int32) error {
+	e := &encoder{}
+	e.string(path)
+	e.int32(version)
+	_, err := c.do(opDelete, e.b, path, nil)
+	return err
+}
+
+// close ends the session which deletes the ephemeral nodes.
+func (c *client) close() error {
+	c.mu.Lock()
+	c.closed = true
+	connected := c.conn != nil
+	c.mu.Unlock()
+	if !connected {
+		return nil
+	}
+	_, err := c.do(opClose, nil, "", nil)
+	c.mu.Lock()
+	conn := c.conn
+	c.sessionID, c.passwd = 0, nil
+	c.mu.Unlock()
+	if conn != nil {
+		c.disconnect(conn, io.EOF)
+	}
+	return err
+}
+
+// join returns the path of the child node.
+func join(path, name string) string {
+	if path == "/" {
+		return "/" + name
+	}
+	return path + "/" + name
+}
+
+// writePacket writes the length prefixed packet.
+func writePacket(w io.Writer, b []byte) error {
+	buf := make([]byte, 4+len(b))
+	binary.BigEndian.PutUint32(buf, uint32(len(b)))
+	copy(buf[4:], b)
+	_, err := w.Write(buf)
+	return err
+}
+
+// readPacket reads a length prefixed packet.
+func readPacket(r io.Reader) ([]byte, error) {
+	var hdr [4]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(hdr[:])
+	if n > maxPacket {
+		return nil, fmt.Errorf("zookeeper: packet of %d bytes too large", n)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// encoder encodes values in the jute format of the ZooKeeper protocol.
+type encoder struct {
+	b []byte
+}
+
+func (e *encoder) int32(v int32) {
+	e.b = append(e.b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func (e *encoder) int64(v int64) {
+	e.int32(int32(v >> 32))
+	e.int32(int32(v))
+}
+
+func (e *encoder) bool(v bool) {
+	if v {
+		e.b = append(e.b, 1)
+	} else {
+		e.b = append(e.b, 0)
+	}
+}
+
+func (e *encoder) bytes(b []byte) {
+	if b == nil {
+		e.int32(-1)
+		return
+	}
+	e.int32(int32(len(b)))
+	e.b = append(e.b, b...)
+}
+
+func (e *encoder) string(s string) {
+	e.int32(int32(len(s)))
+	e.b = append(e.b, s...)
+}
+
+// decoder decodes values in the jute format. The first
+// error is kept and all further values are zero.
+type decoder struct {
+	b   []byte
+	err error
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || len(d.b) < n {
+		d.err = errShortPacket
+		return nil
+	}
+	b := d.b[:n]
+	d.b = d.b[n:]
+	return b
+}
+
+func (d *decoder) int32() int32 {
+	b := d.next(4)
+	if b == nil {
+		return 0
+	}
+	return int32(binary.BigEndian.Uint32(b))
+}
+
+func (d *decoder) int64() int64 {
+	b := d.next(8)
+	if b == nil {
+		return 0
+	}
+	return int64(binary.BigEndian.Uint64(b))
+}
+
+func (d *decoder) bool() bool {
+	b := d.next(1)
+	return b != nil && b[0] != 0
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.int32()
+	if n < 0 {
+		return nil
+	}
+	return d.next(int(n))
+}
+
+func (d *decoder) string() string {
+	return string(d.bytes())
+}
+
+func (d *decoder) stat() stat {
+	var st stat
+	st.Czxid = d.int64()
+	st.Mzxid = d.int64()
+	d.int64() // ctime
+	d.int64() // mtime
+	st.Version = d.int32()
+	d.int32() // cversion
+	d.int32() // aversion
+	st.EphemeralOwner = d.int64()
+	st.DataLength = d.int32()
+	st.NumChildren = d.int32()
+	d.int64() // pzxid
+	return st
+}
@@ -0,0 +1,62 @@
+package zookeeper
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/fabiolb/fabio/registry"
+)
+
+// instance is a service registration in the JSON format of the
+// service discovery of Apache Curator. The tags are read from the
+// tags field or from the tags field of the payload.
+type instance struct {
+	Name    string          `json:"name"`
+	ID      string          `json:"id"`
+	Address string          `json:"address"`
+	Port    int             `json:"port"`
+	Tags    []string        `json:"tags,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// parseInstance parses the registration of an instance of
+// the service.
+func parseInstance(service string, data []byte) (instance, error) {
+	var r instance
+	if err := json.Unmarshal(data, &r); err != nil {
+		return r, err
+	}
+	if r.Address == "" || r.Port <= 0 {
+		return r, fmt.Errorf("missing address or port")
+	}
+	r.Name = service
+	if len(r.Tags) == 0 && len(r.Payload) > 0 {
+		var p struct {
+			Tags []string `json:"tags"`
+		}
+		// payloads which are not objects have no tags
+		if json.Unmarshal(r.Payload, &p) == nil {
+			r.Tags = p.Tags
+		}
+	}
+	return r, nil
+}
+
+// buildRoutes returns the route commands for the service
+// registrations sorted with the most specific first.
+func buildRoutes(regs []instance, prefix string) string {
+	var config []string
+	for _, r := range regs {
+		config = append(config, routeCmds(r, prefix)...)
+	}
+	return registry.SortRoutes(config)
+}
+
+// routeCmds builds the route commands for the urlprefix tags
+// of a service registration.
+func routeCmds(r instance, prefix string) []string {
+	addr := net.JoinHostPort(r.Address, strconv.Itoa(r.Port))
+	return registry.RouteCmds(r.Name, addr, r.Tags, prefix, nil)
+}
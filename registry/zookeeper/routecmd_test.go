@@ -0,0 +1,122 @@
+package zookeeper
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseInstance(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		inst instance
+		err  bool
+	}{
+		{
+			name: "tags",
+			data: `{"address":"10.0.0.1","port":8080,"tags":["urlprefix-/foo","v1"]}`,
+			inst: instance{Name: "svc-a", Address: "10.0.0.1", Port: 8080, Tags: []string{"urlprefix-/foo", "v1"}},
+		},
+		{
+			name: "curator with tags in payload",
+			data: `{"name":"other","id":"1","address":"10.0.0.1","port":8080,"sslPort":null,"payload":{"@class":"x","tags":["urlprefix-/foo"]},"serviceType":"DYNAMIC"}`,
+			inst: instance{Name: "svc-a", ID: "1", Address: "10.0.0.1", Port: 8080, Tags: []string{"urlprefix-/foo"}},
+		},
+		{
+			name: "payload which is not an object",
+			data: `{"address":"10.0.0.1","port":8080,"payload":"foo"}`,
+			inst: instance{Name: "svc-a", Address: "10.0.0.1", Port: 8080},
+		},
+		{
+			name: "missing port",
+			data: `{"address":"10.0.0.1"}`,
+			err:  true,
+		},
+		{
+			name: "invalid json",
+			data: `urlprefix-/foo`,
+			err:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inst, err := parseInstance("svc-a", []byte(tt.data))
+			if got, want := err != nil, tt.err; got != want {
+				t.Fatalf("got error %v want %v", err, want)
+			}
+			if tt.err {
+				return
+			}
+			inst.Payload = nil
+			if got, want := inst, tt.inst; !reflect.DeepEqual(got, want) {
+				t.Fatalf("got %+v want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestRouteCmds(t *testing.T) {
+	inst := func(tags ...string) instance {
+		return instance{Name: "svc-a", Address: "10.0.0.1", Port: 8080, Tags: tags}
+	}
+
+	tests := []struct {
+		name string
+		inst instance
+		cmds []string
+	}{
+		{
+			name: "http",
+			inst: inst("urlprefix-/foo"),
+			cmds: []string{`route add svc-a /foo http://10.0.0.1:8080/`},
+		},
+		{
+			name: "host lower cased",
+			inst: inst("urlprefix-Foo.com/Bar"),
+			cmds: []string{`route add svc-a foo.com/Bar http://10.0.0.1:8080/`},
+		},
+		{
+			name: "tags and opts",
+			inst: inst("urlprefix-/foo strip=/foo weight=0.2", "v1", "blue"),
+			cmds: []string{`route add svc-a /foo http://10.0.0.1:8080/ weight 0.2 tags "v1,blue" opts "strip=/foo"`},
+		},
+		{
+			name: "tcp",
+			inst: inst("urlprefix-:1234 proto=tcp"),
+			cmds: []string{`route add svc-a :1234 tcp://10.0.0.1:8080`},
+		},
+		{
+			name: "tag without slash",
+			inst: inst("urlprefix-foo.com"),
+			cmds: []string{`route add svc-a foo.com http://10.0.0.1:8080/`},
+		},
+		{
+			name: "no route tags",
+			inst: inst("v1"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, want := routeCmds(tt.inst, "urlprefix-"), tt.cmds; !reflect.DeepEqual(got, want) {
+				t.Fatalf("got %q want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestBuildRoutes(t *testing.T) {
+	regs := []instance{
+		{Name: "a", Address: "10.0.0.1", Port: 80, Tags: []string{"urlprefix-/"}},
+		{Name: "b", Address: "10.0.0.2", Port: 80, Tags: []string{"urlprefix-/b"}},
+		{Name: "a", Address: "10.0.0.3", Port: 80, Tags: []string{"urlprefix-/"}},
+	}
+	got := buildRoutes(regs, "urlprefix-")
+	want := "route add b /b http://10.0.0.2:80/\n" +
+		"route add a / http://10.0.0.3:80/\n" +
+		"route add a / http://10.0.0.1:80/"
+	if got != want {
+		t.Fatalf("got\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/fabiolb/fabio/config"
+	"github.com/fabiolb/fabio/logger"
+	"github.com/fabiolb/fabio/metrics"
+	"github.com/fabiolb/fabio/proxy"
+	"github.com/fabiolb/fabio/registry"
+)
+
+// runtimeCfg contains the running configuration. It is replaced
+// when the configuration is reloaded on SIGHUP.
+var runtimeCfg atomic.Value // *config.Config
+
+// currentConfig returns the running configuration.
+func currentConfig() *config.Config {
+	return runtimeCfg.Load().(*config.Config)
+}
+
+// reloadable contains the settings which are applied
+// at runtime when the configuration is reloaded.
+var reloadable = []struct {
+	name string
+	set  func(dst, src *config.Config)
+}{
+	{"log.level", func(dst, src *config.Config) { dst.Log.Level = src.Log.Level }},
+	{"proxy.strategy", func(dst, src *config.Config) { dst.Proxy.Strategy = src.Proxy.Strategy }},
+	{"proxy.matcher", func(dst, src *config.Config) { dst.Proxy.Matcher = src.Proxy.Matcher }},
+	{"proxy.dialtimeout", func(dst, src *config.Config) { dst.Proxy.DialTimeout = src.Proxy.DialTimeout }},
+	{"proxy.responseheadertimeout", func(dst, src *config.Config) { dst.Proxy.ResponseHeaderTimeout = src.Proxy.ResponseHeaderTimeout }},
+	{"proxy.keepalivetimeout", func(dst, src *config.Config) { dst.Proxy.KeepAliveTimeout = src.Proxy.KeepAliveTimeout }},
+	{"proxy.idleconntimeout", func(dst, src *config.Config) { dst.Proxy.IdleConnTimeout = src.Proxy.IdleConnTimeout }},
+	{"proxy.flushinterval", func(dst, src *config.Config) { dst.Proxy.FlushInterval = src.Proxy.FlushInterval }},
+	{"proxy.globalflushinterval", func(dst, src *config.Config) { dst.Proxy.GlobalFlushInterval = src.Proxy.GlobalFlushInterval }},
+	{"proxy.shutdownwait", func(dst, src *config.Config) { dst.Proxy.ShutdownWait = src.Proxy.ShutdownWait }},
+	{"proxy.upgrade.timeout", func(dst, src *config.Config) { dst.Proxy.UpgradeTimeout = src.Proxy.UpgradeTimeout }},
+	{"registry.consul.pollinterval", func(dst, src *config.Config) { dst.Registry.Consul.PollInterval = src.Registry.Consul.PollInterval }},
+	{"metrics.interval", func(dst, src *config.Config) { dst.Metrics.Interval = src.Metrics.Interval }},
+}
+
+// restartListeners contains the reloadable settings which the
+// listeners with these protocols only use after a restart.
+var restartListeners = map[string][]string{
+	"proxy.dialtimeout": {"tcp", "tcp+sni", "tcp-dynamic", "udp", "https+tcp+sni"},
+}
+
+// restartProtos returns the protocols of the listeners of cfg
+// which only use the new value of the setting after a restart.
+func restartProtos(cfg *config.Config, name string) []string {
+	var protos []string
+	for _, l := range cfg.Listen {
+		for _, p := range restartListeners[name] {
+			if l.Proto == p && !contains(protos, p) {
+				protos = append(protos, p)
+			}
+		}
+	}
+	return protos
+}
+
+func contains(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeConfig returns a copy of cur with the reloadable settings of
+// next, the names of the settings which were changed and the fields
+// of the settings which differ but require a restart.
+func mergeConfig(cur, next *config.Config) (cfg *config.Config, changed, restart []string) {
+	c := *cur
+	for _, r := range reloadable {
+		prev := c
+		r.set(&c, next)
+		if !reflect.DeepEqual(prev, c) {
+			changed = append(changed, r.name)
+		}
+	}
+	return &c, changed, diffFields("", reflect.ValueOf(c), reflect.ValueOf(*next))
+}
+
+// diffFields returns the paths of the struct fields which differ.
+func diffFields(prefix string, a, b reflect.Value) []string {
+	if a.Kind() != reflect.Struct {
+		if reflect.DeepEqual(a.Interface(), b.Interface()) {
+			return nil
+		}
+		return []string{prefix}
+	}
+	var fields []string
+	for i := 0; i < a.NumField(); i++ {
+		name := a.Type().Field(i).Name
+		if prefix != "" {
+			name = prefix + "." + name
+		}
+		fields = append(fields, diffFields(name, a.Field(i), b.Field(i))...)
+	}
+	return fields
+}
+
+// listenReload reloads the configuration on SIGHUP.
+func listenReload(logOutput *logger.LevelWriter) {
+	sigchan := make(chan os.Signal, 1)
+	signal.Notify(sigchan, syscall.SIGHUP)
+	go func() {
+		for range sigchan {
+			log.Print("[INFO] Caught SIGHUP. Reloading configuration")
+			reloadConfig(logOutput)
+		}
+	}()
+}
+
+// reloadConfig loads the configuration again and applies the
+// settings which can be changed at runtime. The other settings
+// are logged and keep their value until fabio is restarted.
+func reloadConfig(logOutput *logger.LevelWriter) {
+	next, err := config.Load(os.Args, os.Environ())
+	if err != nil {
+		log.Printf("[ERROR] Cannot reload configuration. %s", err)
+		return
+	}
+
+	cur := currentConfig()
+	cfg, changed, restart := mergeConfig(cur, next)
+	for _, f := range restart {
+		log.Printf("[WARN] Changing %s requires a restart", f)
+	}
+	if len(changed) == 0 {
+		log.Print("[INFO] No configuration changes to apply")
+		return
+	}
+	runtimeCfg.Store(cfg)
+
+	if cfg.Log.Level != cur.Log.Level && !logOutput.SetLevel(cfg.Log.Level) {
+		log.Printf("[WARN] Cannot set log level to %s", cfg.Log.Level)
+	}
+	if cfg.Registry.Consul.PollInterval != cur.Registry.Consul.PollInterval {
+		if p, ok := registry.Default.(registry.Poller); ok {
+			p.SetPollInterval(cfg.Registry.Consul.PollInterval)
+		}
+	}
+	if cfg.Metrics.Interval != cur.Metrics.Interval {
+		metrics.SetFlushInterval(cfg.Metrics.Interval)
+	}
+	httpProxies.reload(cfg)
+
+	for _, name := range changed {
+		log.Printf("[INFO] Applied %s", name)
+		if protos := restartProtos(cfg, name); len(protos) > 0 {
+			log.Printf("[WARN] Changing %s for the %s listeners requires a restart", name, strings.Join(protos, ", "))
+		}
+	}
+}
+
+// httpProxies contains the handlers of the HTTP listeners.
+var httpProxies reloadableProxies
+
+type reloadableProxies struct {
+	mu sync.Mutex
+	h  []*reloadableProxy
+}
+
+func (p *reloadableProxies) add(hp *proxy.HTTPProxy) *reloadableProxy {
+	h := &reloadableProxy{}
+	h.v.Store(hp)
+	p.mu.Lock()
+	p.h = append(p.h, h)
+	p.mu.Unlock()
+	return h
+}
+
+// reload replaces the proxies with proxies which use the timeouts
+// of cfg. The idle connections of the previous proxies are closed.
+func (p *reloadableProxies) reload(cfg *config.Config) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, h := range p.h {
+		old := h.v.Load().(*proxy.HTTPProxy)
+		h.v.Store(reloadHTTPProxy(old, cfg))
+		old.CloseIdleConnections()
+	}
+}
+
+// reloadableProxy serves the requests with the current proxy.
+type reloadableProxy struct {
+	v atomic.Value // *proxy.HTTPProxy
+}
+
+func (h *reloadableProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.v.Load().(*proxy.HTTPProxy).ServeHTTP(w, r)
+}
@@ -0,0 +1,22 @@
+// +build !windows
+
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// listenReopen reopens the access log file on SIGUSR1.
+func listenReopen() {
+	sigchan := make(chan os.Signal, 1)
+	signal.Notify(sigchan, syscall.SIGUSR1)
+	go func() {
+		for range sigchan {
+			log.Print("[INFO] Caught SIGUSR1. Reopening access log")
+			reopenAccessLog()
+		}
+	}()
+}
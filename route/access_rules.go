@@ -14,6 +14,11 @@ const (
 	ipDenyTag  = "deny:ip"
 )
 
+// HasAccessRules returns true if the target has allow or deny rules.
+func (t *Target) HasAccessRules() bool {
+	return len(t.accessRules) > 0
+}
+
 // AccessDeniedHTTP checks rules on the target for HTTP proxy routes.
 func (t *Target) AccessDeniedHTTP(r *http.Request) bool {
 	// No rules ... skip checks
@@ -89,6 +94,19 @@ func (t *Target) AccessDeniedTCP(c net.Conn) bool {
 	return false
 }
 
+// AccessDeniedUDP checks rules on the target for UDP proxy routes.
+func (t *Target) AccessDeniedUDP(addr net.Addr) bool {
+	if len(t.accessRules) == 0 {
+		return false
+	}
+	udpAddr, ok := addr.(*net.UDPAddr)
+	if !ok {
+		log.Printf("[ERROR] failed to assert remote udp address for %s", t.Service)
+		return false
+	}
+	return t.denyByIP(udpAddr.IP)
+}
+
 func (t *Target) denyByIP(ip net.IP) bool {
 	if ip == nil || len(t.accessRules) == 0 {
 		return false
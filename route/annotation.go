@@ -0,0 +1,51 @@
+package route
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// annotationPrefix is the prefix of the options
+// which annotate a target with a key/value pair.
+const annotationPrefix = "annotation."
+
+// parseAnnotations returns the annotations of the 'annotation.<key>=<value>'
+// options or nil if there are none. Keys must consist of the characters
+// [a-zA-Z0-9_-]. Options with an invalid key are skipped and reported
+// in the error.
+func parseAnnotations(opts map[string]string) (map[string]string, error) {
+	var m map[string]string
+	var invalid []string
+	for k, v := range opts {
+		if !strings.HasPrefix(k, annotationPrefix) {
+			continue
+		}
+		key := k[len(annotationPrefix):]
+		if !validAnnotationKey(key) {
+			invalid = append(invalid, k)
+			continue
+		}
+		if m == nil {
+			m = map[string]string{}
+		}
+		m[key] = v
+	}
+	if len(invalid) > 0 {
+		sort.Strings(invalid)
+		return m, fmt.Errorf("annotation keys should only contain [a-zA-Z0-9_-]. Got: %s", strings.Join(invalid, ","))
+	}
+	return m, nil
+}
+
+func validAnnotationKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, r := range key {
+		if !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' || r == '_' || r == '-') {
+			return false
+		}
+	}
+	return true
+}
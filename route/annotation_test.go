@@ -0,0 +1,44 @@
+package route
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestParseAnnotations(t *testing.T) {
+	tests := []struct {
+		desc string
+		opts map[string]string
+		want map[string]string
+		err  bool
+	}{
+		{"none", map[string]string{"strip": "/foo"}, nil, false},
+		{"annotations", map[string]string{"annotation.team": "payments", "annotation.slo-tier": "1", "strip": "/foo"}, map[string]string{"team": "payments", "slo-tier": "1"}, false},
+		{"empty value", map[string]string{"annotation.team": ""}, map[string]string{"team": ""}, false},
+		{"empty key", map[string]string{"annotation.": "x", "annotation.team": "a"}, map[string]string{"team": "a"}, true},
+		{"invalid key", map[string]string{"annotation.a.b": "x"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			got, err := parseAnnotations(tt.opts)
+			if gotErr := err != nil; gotErr != tt.err {
+				t.Fatalf("got error %v want error %v", err, tt.err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %v want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTargetAnnotations(t *testing.T) {
+	tbl, err := NewTable(bytes.NewBufferString(`route add svc / http://foo.com/ opts "annotation.team=payments annotation.tier=gold"`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := tbl[""][0].Targets[0].Annotations
+	if want := map[string]string{"team": "payments", "tier": "gold"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+}
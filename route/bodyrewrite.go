@@ -0,0 +1,61 @@
+package route
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// BodyRewrite replaces a string or the matches of a regular
+// expression in the response bodies from a target.
+type BodyRewrite struct {
+	// Old is the string which is replaced if Pattern is nil.
+	Old string
+
+	// Pattern is the regular expression which is matched
+	// against the body.
+	Pattern *regexp.Regexp
+
+	// New is the replacement. With a pattern it can reference
+	// the submatches with $1 or ${name}.
+	New string
+}
+
+// parseBodyRewrite parses the bodyrewrite and bodyrewriteregex
+// options. The bodyrewrite option contains the old and the new
+// string separated by a space, e.g.
+// 'bodyrewrite=http://legacy.internal/ https://example.com/legacy/'.
+// It returns nil if the bodyrewrite option is not set.
+func parseBodyRewrite(opts map[string]string) (*BodyRewrite, error) {
+	v := opts["bodyrewrite"]
+	if v == "" {
+		return nil, nil
+	}
+	p := strings.Fields(v)
+	if len(p) != 2 {
+		return nil, fmt.Errorf("bodyrewrite should be the old and the new string separated by a space. Got: %s", v)
+	}
+	br := &BodyRewrite{Old: p[0], New: p[1]}
+	switch opts["bodyrewriteregex"] {
+	case "", "false":
+	case "true":
+		re, err := compileRewrite(p[0])
+		if err != nil {
+			return nil, fmt.Errorf("bodyrewrite has an invalid pattern. %s", err)
+		}
+		br.Pattern = re
+	default:
+		return nil, fmt.Errorf("bodyrewriteregex should be true or false. Got: %s", opts["bodyrewriteregex"])
+	}
+	return br, nil
+}
+
+// Rewrite returns the body with all occurrences of the old
+// string or all matches of the pattern replaced.
+func (br *BodyRewrite) Rewrite(body []byte) []byte {
+	if br.Pattern != nil {
+		return br.Pattern.ReplaceAll(body, []byte(br.New))
+	}
+	return bytes.Replace(body, []byte(br.Old), []byte(br.New), -1)
+}
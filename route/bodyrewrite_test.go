@@ -0,0 +1,46 @@
+package route
+
+import (
+	"testing"
+)
+
+func TestParseBodyRewrite(t *testing.T) {
+	for _, opts := range []map[string]string{
+		{"bodyrewrite": "http://legacy/"},
+		{"bodyrewrite": "a b c"},
+		{"bodyrewrite": "(a b", "bodyrewriteregex": "true"},
+		{"bodyrewrite": "a b", "bodyrewriteregex": "yes"},
+	} {
+		if _, err := parseBodyRewrite(opts); err == nil {
+			t.Errorf("%v: got nil want error", opts)
+		}
+	}
+
+	if br, err := parseBodyRewrite(map[string]string{"bodyrewriteregex": "true"}); br != nil || err != nil {
+		t.Fatalf("got %v, %v want nil, nil", br, err)
+	}
+}
+
+func TestBodyRewrite(t *testing.T) {
+	tests := []struct {
+		desc string
+		opts map[string]string
+		in   string
+		out  string
+	}{
+		{"string", map[string]string{"bodyrewrite": "http://legacy/ /app/"}, `<a href="http://legacy/x">http://legacy/</a>`, `<a href="/app/x">/app/</a>`},
+		{"string is no pattern", map[string]string{"bodyrewrite": "a.c x"}, "abc a.c", "abc x"},
+		{"regex", map[string]string{"bodyrewrite": `href="/(\w+) href="/app/$1`, "bodyrewriteregex": "true"}, `<a href="/a"><a href="/b">`, `<a href="/app/a"><a href="/app/b">`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			br, err := parseBodyRewrite(tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := string(br.Rewrite([]byte(tt.in))), tt.out; got != want {
+				t.Fatalf("got %q want %q", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package route
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+// CachePolicy enables caching the responses from a target.
+type CachePolicy struct {
+	// TTL is the maximum lifetime of a cached response. Responses
+	// with a shorter max-age or s-maxage expire earlier.
+	TTL time.Duration
+
+	// Key contains the components of the request which
+	// distinguish the cached responses: 'host', 'path',
+	// 'query' and 'header:<name>'.
+	Key []string
+}
+
+// defaultCacheKey is the cache key when the cachekey option is not set.
+var defaultCacheKey = []string{"host", "path", "query"}
+
+// parseCachePolicy parses the cache and cachekey options, e.g.
+// 'cache=60s cachekey=path,query'. It returns nil if the cache
+// option is not set.
+func parseCachePolicy(opts map[string]string) (*CachePolicy, error) {
+	v := opts["cache"]
+	if v == "" {
+		if opts["cachekey"] != "" {
+			return nil, fmt.Errorf("cachekey requires the cache option")
+		}
+		return nil, nil
+	}
+	ttl, err := time.ParseDuration(v)
+	if err != nil || ttl <= 0 {
+		return nil, fmt.Errorf("cache should be a positive duration. Got: %s", v)
+	}
+
+	c := &CachePolicy{TTL: ttl, Key: defaultCacheKey}
+	if key := opts["cachekey"]; key != "" {
+		c.Key = nil
+		for _, p := range strings.Split(key, ",") {
+			switch p = strings.TrimSpace(p); {
+			case p == "host" || p == "path" || p == "query":
+			case strings.HasPrefix(p, "header:") && httpguts.ValidHeaderFieldName(p[len("header:"):]):
+				p = "header:" + http.CanonicalHeaderKey(p[len("header:"):])
+			default:
+				return nil, fmt.Errorf("cachekey should be a list of host, path, query and header:<name>. Got: %s", key)
+			}
+			c.Key = append(c.Key, p)
+		}
+	}
+	return c, nil
+}
@@ -0,0 +1,42 @@
+package route
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseCachePolicy(t *testing.T) {
+	for _, opts := range []map[string]string{
+		{"cache": "1h30"},
+		{"cache": "0s"},
+		{"cache": "-1m"},
+		{"cache": "60s", "cachekey": "path,cookie"},
+		{"cache": "60s", "cachekey": "header:"},
+		{"cachekey": "path"},
+	} {
+		if _, err := parseCachePolicy(opts); err == nil {
+			t.Errorf("%v: got nil want error", opts)
+		}
+	}
+
+	if c, err := parseCachePolicy(map[string]string{"strip": "/foo"}); c != nil || err != nil {
+		t.Fatalf("got %v, %v want nil, nil", c, err)
+	}
+
+	c, err := parseCachePolicy(map[string]string{"cache": "60s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (&CachePolicy{TTL: time.Minute, Key: []string{"host", "path", "query"}}); !reflect.DeepEqual(c, want) {
+		t.Fatalf("got %#v want %#v", c, want)
+	}
+
+	c, err = parseCachePolicy(map[string]string{"cache": "5m", "cachekey": "path, header:accept-language"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (&CachePolicy{TTL: 5 * time.Minute, Key: []string{"path", "header:Accept-Language"}}); !reflect.DeepEqual(c, want) {
+		t.Fatalf("got %#v want %#v", c, want)
+	}
+}
@@ -0,0 +1,105 @@
+package route
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// Canary describes the requests which a canary target receives. Canary
+// targets receive only the requests which match all conditions and do
+// not take part in the weighted distribution of the other requests.
+type Canary struct {
+	// Header is the canonical name of the request header which
+	// must be present and HeaderValue its value if not empty.
+	Header, HeaderValue string
+
+	// Cookie is the name of the cookie which must be present
+	// and CookieValue its value if not empty.
+	Cookie, CookieValue string
+}
+
+// parseCanary parses the match-header and match-cookie options which
+// have the form 'name:value' or 'name'. It returns nil if neither
+// option is set.
+func parseCanary(opts map[string]string) (*Canary, error) {
+	header, hasHeader := opts["match-header"]
+	cookie, hasCookie := opts["match-cookie"]
+	if !hasHeader && !hasCookie {
+		return nil, nil
+	}
+
+	c := &Canary{}
+	if hasHeader {
+		c.Header, c.HeaderValue = splitCondition(header)
+		if c.Header == "" || strings.ContainsAny(c.Header, " \t") {
+			return nil, fmt.Errorf("match-header should be name:value or name. Got: %s", header)
+		}
+		c.Header = http.CanonicalHeaderKey(c.Header)
+	}
+	if hasCookie {
+		c.Cookie, c.CookieValue = splitCondition(cookie)
+		if c.Cookie == "" || strings.ContainsAny(c.Cookie, " \t\"(),/:;<=>?@[\\]{}") {
+			return nil, fmt.Errorf("match-cookie should be name:value or name. Got: %s", cookie)
+		}
+	}
+	return c, nil
+}
+
+// splitCondition splits 'name:value' into its name and value.
+func splitCondition(s string) (name, value string) {
+	if i := strings.Index(s, ":"); i >= 0 {
+		return strings.TrimSpace(s[:i]), strings.TrimSpace(s[i+1:])
+	}
+	return strings.TrimSpace(s), ""
+}
+
+// Matches returns true if the request matches all conditions.
+func (c *Canary) Matches(req *http.Request) bool {
+	if c.Header != "" {
+		vals, ok := req.Header[c.Header]
+		if !ok || (c.HeaderValue != "" && !containsString(vals, c.HeaderValue)) {
+			return false
+		}
+	}
+	if c.Cookie != "" {
+		ck, err := req.Cookie(c.Cookie)
+		if err != nil || (c.CookieValue != "" && ck.Value != c.CookieValue) {
+			return false
+		}
+	}
+	return true
+}
+
+// pickCanary returns one of the canary targets of the route which
+// match the request or nil if there is none. Matching canaries are
+// picked in turn.
+func (r *Route) pickCanary(req *http.Request) *Target {
+	if req == nil || len(r.canaries) == 0 {
+		return nil
+	}
+	var matched []*Target
+	for _, t := range r.canaries {
+		if t.Canary.Matches(req) {
+			matched = append(matched, t)
+		}
+	}
+	switch len(matched) {
+	case 0:
+		return nil
+	case 1:
+		return matched[0]
+	default:
+		return matched[(atomic.AddUint64(&r.canaryTotal, 1)-1)%uint64(len(matched))]
+	}
+}
+
+func containsString(vals []string, s string) bool {
+	for _, v := range vals {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,119 @@
+package route
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+)
+
+func TestParseCanary(t *testing.T) {
+	for _, opts := range []map[string]string{
+		{"match-header": ""},
+		{"match-header": ":1"},
+		{"match-cookie": "a b:1"},
+		{"match-header": "X-Canary:1", "match-cookie": ""},
+	} {
+		if _, err := parseCanary(opts); err == nil {
+			t.Errorf("%v: got nil want error", opts)
+		}
+	}
+
+	if c, err := parseCanary(map[string]string{}); c != nil || err != nil {
+		t.Fatalf("got %v, %v want nil, nil", c, err)
+	}
+
+	c, err := parseCanary(map[string]string{"match-header": "x-canary:1", "match-cookie": "beta"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := *c, (Canary{Header: "X-Canary", HeaderValue: "1", Cookie: "beta"}); got != want {
+		t.Fatalf("got %v want %v", got, want)
+	}
+}
+
+func TestCanaryMatches(t *testing.T) {
+	tests := []struct {
+		desc   string
+		canary Canary
+		header http.Header
+		want   bool
+	}{
+		{"header", Canary{Header: "X-Canary", HeaderValue: "1"}, http.Header{"X-Canary": {"1"}}, true},
+		{"header value", Canary{Header: "X-Canary", HeaderValue: "1"}, http.Header{"X-Canary": {"0"}}, false},
+		{"header missing", Canary{Header: "X-Canary", HeaderValue: "1"}, http.Header{}, false},
+		{"header present", Canary{Header: "X-Canary"}, http.Header{"X-Canary": {""}}, true},
+		{"cookie", Canary{Cookie: "beta", CookieValue: "on"}, http.Header{"Cookie": {"a=1; beta=on"}}, true},
+		{"cookie value", Canary{Cookie: "beta", CookieValue: "on"}, http.Header{"Cookie": {"beta=off"}}, false},
+		{"cookie present", Canary{Cookie: "beta"}, http.Header{"Cookie": {"beta=off"}}, true},
+		{"header and cookie", Canary{Header: "X-Canary", Cookie: "beta"}, http.Header{"X-Canary": {"1"}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			req := &http.Request{Header: tt.header}
+			if got := tt.canary.Matches(req); got != tt.want {
+				t.Fatalf("got %v want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCanaryLookup(t *testing.T) {
+	tbl, err := NewTable(bytes.NewBufferString(`
+		route add svc / http://root:1
+		route add svc /api http://stable:1
+		route add svc /api http://stable:2
+		route add svc /api http://canary:1 opts "match-header=X-Canary:1"
+		route add svc /api http://canary:2 opts "match-header=X-Canary:1"
+		route add svc /api http://beta:1 opts "match-cookie=beta:on"
+		route add svc /web http://web-canary:1 opts "match-header=X-Canary:1"
+	`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lookup := func(path string, header http.Header) string {
+		req := &http.Request{Host: "example.com", URL: mustParse(path), Header: header}
+		target := tbl.Lookup(req, "", Picker["rr"], Matcher["prefix"], globCache, false)
+		if target == nil {
+			return ""
+		}
+		return target.URL.Host
+	}
+
+	count := func(path string, header http.Header) map[string]int {
+		m := map[string]int{}
+		for i := 0; i < 100; i++ {
+			m[lookup(path, header)]++
+		}
+		return m
+	}
+
+	if got := count("/api", http.Header{}); got["stable:1"] != 50 || got["stable:2"] != 50 {
+		t.Fatalf("got %v want only stable targets", got)
+	}
+	if got := count("/api", http.Header{"X-Canary": {"1"}}); got["canary:1"] != 50 || got["canary:2"] != 50 {
+		t.Fatalf("got %v want only canary targets", got)
+	}
+	if got, want := lookup("/api", http.Header{"Cookie": {"beta=on"}}), "beta:1"; got != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+
+	// routes with only canaries fall through to the next route
+	if got, want := lookup("/web", http.Header{"X-Canary": {"1"}}), "web-canary:1"; got != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+	if got, want := lookup("/web", http.Header{}), "root:1"; got != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+
+	// TCP connections have no request and are never routed to canaries
+	for _, rt := range tbl[""] {
+		if rt.Path == "/api" {
+			if got := rt.pickTarget(Picker["rr"], nil); got.Canary != nil {
+				t.Fatalf("got canary %s for TCP connection", got.URL)
+			}
+			if got, want := rt.Targets[2].Weight, 0.0; got != want {
+				t.Fatalf("got canary weight %v want %v", got, want)
+			}
+		}
+	}
+}
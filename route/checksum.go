@@ -0,0 +1,49 @@
+package route
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"sort"
+	"sync/atomic"
+
+	"github.com/fabiolb/fabio/metrics"
+)
+
+// checksum contains the checksum of the active routing table.
+var checksum atomic.Value // string
+
+// Checksum returns the SHA-256 checksum of the route definitions of
+// the table as hex string. It does not depend on the order in which
+// the routes and targets were added so that instances which have
+// received the same services from the registry have the same checksum.
+func (t Table) Checksum() string {
+	lines := t.config(false)
+	sort.Strings(lines)
+	h := sha256.New()
+	for _, l := range lines {
+		io.WriteString(h, l)
+		h.Write([]byte{'\n'})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// ActiveChecksum returns the checksum of the active routing table.
+func ActiveChecksum() string {
+	if s, ok := checksum.Load().(string); ok {
+		return s
+	}
+	return GetTable().Checksum()
+}
+
+// syncChecksum stores the checksum of the active table and updates
+// the 'table.checksum' and 'table.version' gauges. The checksum gauge
+// contains the first 32 bits of the checksum. The caller must hold mu.
+func syncChecksum(t Table) {
+	s := t.Checksum()
+	checksum.Store(s)
+	b, _ := hex.DecodeString(s[:8])
+	metrics.DefaultRegistry.GetGauge("table.checksum").Update(int64(binary.BigEndian.Uint32(b)))
+	metrics.DefaultRegistry.GetGauge("table.version").Update(int64(Events.Seq()))
+}
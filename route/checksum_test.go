@@ -0,0 +1,37 @@
+package route
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestTableChecksum(t *testing.T) {
+	table := func(s string) Table {
+		t.Helper()
+		tbl, err := NewTable(bytes.NewBufferString(s))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tbl
+	}
+
+	a := table("route add svc-a /foo http://1.2.3.4:80/\nroute add svc-a /foo http://1.2.3.5:80/\nroute add svc-b /bar http://1.2.3.6:80/")
+	b := table("route add svc-b /bar http://1.2.3.6:80/\nroute add svc-a /foo http://1.2.3.5:80/\nroute add svc-a /foo http://1.2.3.4:80/")
+	c := table("route add svc-a /foo http://1.2.3.4:80/\nroute add svc-b /bar http://1.2.3.6:80/")
+
+	if a.Checksum() != b.Checksum() {
+		t.Fatalf("got different checksums %s and %s for the same routes", a.Checksum(), b.Checksum())
+	}
+	if a.Checksum() == c.Checksum() {
+		t.Fatalf("got the same checksum %s for different routes", a.Checksum())
+	}
+	if got, want := len(a.Checksum()), 64; got != want {
+		t.Fatalf("got checksum length %d want %d", got, want)
+	}
+
+	SetTable(a)
+	defer SetTable(make(Table))
+	if got, want := ActiveChecksum(), a.Checksum(); got != want {
+		t.Fatalf("got active checksum %s want %s", got, want)
+	}
+}
@@ -0,0 +1,57 @@
+package route
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// Compression overrides the compression of the responses
+// from a target which is configured for the proxy.
+type Compression struct {
+	// Disabled turns off the compression for the target.
+	Disabled bool
+
+	// ContentTypes matches the content types of the responses
+	// which are compressed. If nil the content types of the
+	// proxy are used.
+	ContentTypes *regexp.Regexp
+
+	// MinSize is the minimum Content-Length of the responses which
+	// are compressed. A negative value means that the minimum
+	// size of the proxy is used.
+	MinSize int
+}
+
+// parseCompression parses the gzip, gzipcontenttype and gzipminsize
+// options. It returns nil if none of the options is set.
+func parseCompression(opts map[string]string) (*Compression, error) {
+	enabled, types, size := opts["gzip"], opts["gzipcontenttype"], opts["gzipminsize"]
+	if enabled == "" && types == "" && size == "" {
+		return nil, nil
+	}
+
+	c := &Compression{MinSize: -1}
+	switch enabled {
+	case "", "true":
+	case "false":
+		c.Disabled = true
+	default:
+		return nil, fmt.Errorf("gzip should be true or false. Got: %s", enabled)
+	}
+	if types != "" {
+		re, err := compileRewrite(types)
+		if err != nil {
+			return nil, fmt.Errorf("gzipcontenttype has an invalid pattern. %s", err)
+		}
+		c.ContentTypes = re
+	}
+	if size != "" {
+		n, err := strconv.Atoi(size)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("gzipminsize should be a number of bytes. Got: %s", size)
+		}
+		c.MinSize = n
+	}
+	return c, nil
+}
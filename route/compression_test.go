@@ -0,0 +1,38 @@
+package route
+
+import (
+	"testing"
+)
+
+func TestParseCompression(t *testing.T) {
+	for _, opts := range []map[string]string{
+		{"gzip": "yes"},
+		{"gzipcontenttype": "^(text"},
+		{"gzipminsize": "1k"},
+		{"gzipminsize": "-1"},
+	} {
+		if _, err := parseCompression(opts); err == nil {
+			t.Errorf("%v: got nil want error", opts)
+		}
+	}
+
+	if c, err := parseCompression(map[string]string{"strip": "/foo"}); c != nil || err != nil {
+		t.Fatalf("got %v, %v want nil, nil", c, err)
+	}
+
+	c, err := parseCompression(map[string]string{"gzip": "false"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.Disabled || c.ContentTypes != nil || c.MinSize != -1 {
+		t.Fatalf("got %#v", c)
+	}
+
+	c, err = parseCompression(map[string]string{"gzipcontenttype": "^application/json", "gzipminsize": "0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Disabled || !c.ContentTypes.MatchString("application/json") || c.MinSize != 0 {
+		t.Fatalf("got %#v", c)
+	}
+}
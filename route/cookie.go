@@ -0,0 +1,150 @@
+package route
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// CookieRewrite describes how the attributes of the cookies set by a
+// target are rewritten so that they are valid for the host and path
+// of the client request.
+type CookieRewrite struct {
+	// Domain replaces the Domain attribute of the cookies which have
+	// one. The value 'none' removes the attribute which makes them
+	// host-only cookies of the client host.
+	Domain string
+
+	// Path replaces the Path attribute. The value 'true' maps the path
+	// back with the strip and prepend options of the target.
+	Path string
+
+	// Secure adds the Secure attribute if set to 'true'
+	// and removes it if set to 'false'.
+	Secure string
+
+	// SameSite replaces the SameSite attribute with 'Lax', 'Strict'
+	// or 'None'. Cookies with 'SameSite=None' are also marked as
+	// secure since browsers reject them otherwise.
+	SameSite string
+}
+
+// parseCookieRewrite parses the cookiedomain, cookiepath, cookiesecure
+// and cookiesamesite options. It returns nil if none of them is set.
+func parseCookieRewrite(opts map[string]string) (*CookieRewrite, error) {
+	cr := &CookieRewrite{
+		Domain: opts["cookiedomain"],
+		Path:   opts["cookiepath"],
+		Secure: opts["cookiesecure"],
+	}
+	if *cr == (CookieRewrite{}) && opts["cookiesamesite"] == "" {
+		return nil, nil
+	}
+	if strings.ContainsAny(cr.Domain, " \t;,/") {
+		return nil, fmt.Errorf("cookiedomain should be a domain or none. Got: %s", cr.Domain)
+	}
+	if (cr.Path != "" && cr.Path != "true" && !strings.HasPrefix(cr.Path, "/")) || strings.ContainsAny(cr.Path, " \t;,") {
+		return nil, fmt.Errorf("cookiepath should be true or an absolute path. Got: %s", cr.Path)
+	}
+	switch cr.Secure {
+	case "", "true", "false":
+	default:
+		return nil, fmt.Errorf("cookiesecure should be true or false. Got: %s", cr.Secure)
+	}
+	switch v := strings.ToLower(opts["cookiesamesite"]); v {
+	case "":
+	case "lax", "strict", "none":
+		cr.SameSite = strings.ToUpper(v[:1]) + v[1:]
+	default:
+		return nil, fmt.Errorf("cookiesamesite should be lax, strict or none. Got: %s", opts["cookiesamesite"])
+	}
+	if cr.SameSite == "None" && cr.Secure == "false" {
+		return nil, fmt.Errorf("cookiesamesite=none requires secure cookies")
+	}
+	return cr, nil
+}
+
+// RewriteCookie returns the value of a Set-Cookie header from the
+// target with the attributes rewritten according to the cookie options
+// of the target. The name, value and all other attributes of the
+// cookie are not modified.
+func (t *Target) RewriteCookie(cookie string) string {
+	cr := t.CookieRewrite
+	if cr == nil || cookie == "" {
+		return cookie
+	}
+
+	parts := strings.Split(cookie, ";")
+	attrs := []string{strings.TrimSpace(parts[0])}
+	var hasPath, secure bool
+	for _, p := range parts[1:] {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		name, val := p, ""
+		if i := strings.Index(p, "="); i >= 0 {
+			name, val = p[:i], p[i+1:]
+		}
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "domain":
+			switch cr.Domain {
+			case "":
+			case "none":
+				continue
+			default:
+				p = "Domain=" + cr.Domain
+			}
+		case "path":
+			hasPath = true
+			switch cr.Path {
+			case "":
+			case "true":
+				if path, ok := t.publicPath(strings.TrimSpace(val)); ok {
+					p = "Path=" + path
+				}
+			default:
+				p = "Path=" + cr.Path
+			}
+		case "secure":
+			if cr.Secure == "false" {
+				continue
+			}
+			secure = true
+		case "samesite":
+			if cr.SameSite != "" {
+				continue
+			}
+		}
+		attrs = append(attrs, p)
+	}
+
+	if !hasPath && cr.Path != "" && cr.Path != "true" {
+		attrs = append(attrs, "Path="+cr.Path)
+	}
+	if cr.SameSite != "" {
+		attrs = append(attrs, "SameSite="+cr.SameSite)
+	}
+	if !secure && (cr.Secure == "true" || cr.SameSite == "None") {
+		attrs = append(attrs, "Secure")
+	}
+	return strings.Join(attrs, "; ")
+}
+
+// RewriteResponse rewrites the Location and Set-Cookie headers of a
+// response from the target with the given status code for the client
+// request with the URL requestURL and applies the resphdr options.
+func (t *Target) RewriteResponse(code int, h http.Header, requestURL *url.URL) {
+	if t.LocationRewrite != nil && code >= 300 && code < 400 {
+		if loc := h["Location"]; len(loc) > 0 {
+			loc[0] = t.RewriteLocation(loc[0], requestURL)
+		}
+	}
+	if t.CookieRewrite != nil {
+		for i, c := range h["Set-Cookie"] {
+			h["Set-Cookie"][i] = t.RewriteCookie(c)
+		}
+	}
+	t.ResponseHeaders.Apply(h)
+}
@@ -0,0 +1,89 @@
+package route
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestParseCookieRewrite(t *testing.T) {
+	for _, opts := range []map[string]string{
+		{"cookiedomain": "a.com;b.com"},
+		{"cookiepath": "app"},
+		{"cookiepath": "/a;b"},
+		{"cookiesecure": "yes"},
+		{"cookiesamesite": "always"},
+		{"cookiesamesite": "none", "cookiesecure": "false"},
+	} {
+		if _, err := parseCookieRewrite(opts); err == nil {
+			t.Errorf("%v: got nil want error", opts)
+		}
+	}
+
+	if cr, err := parseCookieRewrite(map[string]string{}); cr != nil || err != nil {
+		t.Fatalf("got %v, %v want nil, nil", cr, err)
+	}
+
+	cr, err := parseCookieRewrite(map[string]string{"cookiedomain": "example.com", "cookiesamesite": "STRICT"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := *cr, (CookieRewrite{Domain: "example.com", SameSite: "Strict"}); got != want {
+		t.Fatalf("got %v want %v", got, want)
+	}
+}
+
+func TestRewriteCookie(t *testing.T) {
+	tests := []struct {
+		desc, opts, cookie, want string
+	}{
+		{"disabled", "", "a=1; Domain=app.internal", "a=1; Domain=app.internal"},
+		{"domain", "cookiedomain=example.com", "a=1; domain=app.internal; HttpOnly", "a=1; Domain=example.com; HttpOnly"},
+		{"no domain", "cookiedomain=example.com", "a=1; Path=/", "a=1; Path=/"},
+		{"remove domain", "cookiedomain=none", "a=1; Domain=app.internal; Path=/", "a=1; Path=/"},
+		{"path", "cookiepath=/app", "a=1; Path=/", "a=1; Path=/app"},
+		{"add path", "cookiepath=/app", "a=1", "a=1; Path=/app"},
+		{"map path", "cookiepath=true strip=/app prepend=/v1", "a=1; Path=/v1/users", "a=1; Path=/app/users"},
+		{"map path outside prepend", "cookiepath=true prepend=/v1", "a=1; Path=/v2", "a=1; Path=/v2"},
+		{"add secure", "cookiesecure=true", "a=1; Path=/", "a=1; Path=/; Secure"},
+		{"keep secure", "cookiesecure=true", "a=1; secure", "a=1; secure"},
+		{"remove secure", "cookiesecure=false", "a=1; Secure; HttpOnly", "a=1; HttpOnly"},
+		{"samesite", "cookiesamesite=lax", "a=1; SameSite=None; Secure", "a=1; Secure; SameSite=Lax"},
+		{"samesite none", "cookiesamesite=none", "a=1", "a=1; SameSite=None; Secure"},
+		{"other attributes", "cookiedomain=none", "a=b=c; Max-Age=60; Partitioned", "a=b=c; Max-Age=60; Partitioned"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			r := &Route{}
+			r.addTarget("svc", mustParse("http://10.0.0.1:8080/"), 0, nil, parseOpts(tt.opts))
+			if got := r.Targets[0].RewriteCookie(tt.cookie); got != tt.want {
+				t.Fatalf("got %q want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRewriteResponse(t *testing.T) {
+	r := &Route{}
+	r.addTarget("svc", mustParse("http://10.0.0.1:8080/"), 0, nil, parseOpts("rewritelocation=true cookiesecure=true"))
+	requestURL := &url.URL{Scheme: "https", Host: "example.com", Path: "/"}
+
+	h := http.Header{
+		"Location":   {"http://10.0.0.1:8080/login"},
+		"Set-Cookie": {"a=1", "b=2; Secure"},
+	}
+	r.Targets[0].RewriteResponse(http.StatusFound, h, requestURL)
+	if got, want := h.Get("Location"), "https://example.com/login"; got != want {
+		t.Fatalf("got location %q want %q", got, want)
+	}
+	if got, want := h["Set-Cookie"], []string{"a=1; Secure", "b=2; Secure"}; got[0] != want[0] || got[1] != want[1] {
+		t.Fatalf("got cookies %q want %q", got, want)
+	}
+
+	// only redirects are rewritten
+	h = http.Header{"Location": {"http://10.0.0.1:8080/users/1"}}
+	r.Targets[0].RewriteResponse(http.StatusCreated, h, requestURL)
+	if got, want := h.Get("Location"), "http://10.0.0.1:8080/users/1"; got != want {
+		t.Fatalf("got location %q want %q", got, want)
+	}
+}
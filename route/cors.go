@@ -0,0 +1,216 @@
+package route
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+// CORS describes the Cross-Origin Resource Sharing policy which the
+// proxy enforces for a target. The proxy answers the preflight
+// requests and sets the Access-Control-* headers of the responses.
+type CORS struct {
+	// Origins are the allowed origins, e.g. 'https://example.com'.
+	// An origin of '*' allows all origins.
+	Origins []string
+
+	// Methods are the methods which are allowed in addition
+	// to the simple methods GET, HEAD and POST.
+	Methods []string
+
+	// Headers are the request headers which are allowed in addition
+	// to the safelisted headers. A header of '*' allows all headers
+	// requested by the client.
+	Headers []string
+
+	// Expose are the response headers which are
+	// exposed to the client.
+	Expose []string
+
+	// Credentials allows requests with cookies
+	// and the Authorization header.
+	Credentials bool
+
+	// MaxAge is the time for which the client can
+	// cache the result of a preflight request.
+	MaxAge time.Duration
+}
+
+// parseCORS parses the cors-origins, cors-methods, cors-headers,
+// cors-expose, cors-credentials and cors-maxage options. The lists are
+// comma separated. It returns nil if the cors-origins option is not set.
+func parseCORS(opts map[string]string) (*CORS, error) {
+	origins := opts["cors-origins"]
+	if origins == "" {
+		for _, k := range []string{"cors-methods", "cors-headers", "cors-expose", "cors-credentials", "cors-maxage"} {
+			if opts[k] != "" {
+				return nil, fmt.Errorf("%s requires the cors-origins option", k)
+			}
+		}
+		return nil, nil
+	}
+
+	c := &CORS{}
+	for _, o := range splitList(origins) {
+		if o != "*" && !strings.HasPrefix(o, "http://") && !strings.HasPrefix(o, "https://") {
+			return nil, fmt.Errorf("cors-origins should be * or a list of origins with a scheme. Got: %s", origins)
+		}
+		c.Origins = append(c.Origins, strings.TrimSuffix(strings.ToLower(o), "/"))
+	}
+	for _, m := range splitList(opts["cors-methods"]) {
+		if !httpguts.ValidHeaderFieldName(m) {
+			return nil, fmt.Errorf("cors-methods should be a list of methods. Got: %s", opts["cors-methods"])
+		}
+		c.Methods = append(c.Methods, strings.ToUpper(m))
+	}
+	for _, h := range splitList(opts["cors-headers"]) {
+		if h != "*" && !httpguts.ValidHeaderFieldName(h) {
+			return nil, fmt.Errorf("cors-headers should be * or a list of header names. Got: %s", opts["cors-headers"])
+		}
+		c.Headers = append(c.Headers, http.CanonicalHeaderKey(h))
+	}
+	for _, h := range splitList(opts["cors-expose"]) {
+		if !httpguts.ValidHeaderFieldName(h) {
+			return nil, fmt.Errorf("cors-expose should be a list of header names. Got: %s", opts["cors-expose"])
+		}
+		c.Expose = append(c.Expose, http.CanonicalHeaderKey(h))
+	}
+	switch v := opts["cors-credentials"]; v {
+	case "", "false":
+	case "true":
+		c.Credentials = true
+	default:
+		return nil, fmt.Errorf("cors-credentials should be true or false. Got: %s", v)
+	}
+	if v := opts["cors-maxage"]; v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("cors-maxage should be a duration. Got: %s", v)
+		}
+		c.MaxAge = d
+	}
+	return c, nil
+}
+
+// splitList splits a comma separated list and drops empty elements.
+func splitList(s string) []string {
+	var l []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			l = append(l, v)
+		}
+	}
+	return l
+}
+
+// AllowOrigin returns true if requests from the origin are allowed.
+func (c *CORS) AllowOrigin(origin string) bool {
+	if origin == "" {
+		return false
+	}
+	origin = strings.ToLower(origin)
+	for _, o := range c.Origins {
+		if o == "*" || o == origin {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply sets the Access-Control-* headers of a response to a request
+// from origin. The headers of the target are replaced so that the
+// policy of the proxy applies.
+func (c *CORS) Apply(origin string, h http.Header) {
+	h.Del("Access-Control-Allow-Origin")
+	h.Del("Access-Control-Allow-Credentials")
+	h.Del("Access-Control-Expose-Headers")
+	if !c.wildcard() {
+		h.Add("Vary", "Origin")
+	}
+	if !c.AllowOrigin(origin) {
+		return
+	}
+	if c.wildcard() {
+		h.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		h.Set("Access-Control-Allow-Origin", origin)
+	}
+	if c.Credentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+	if len(c.Expose) > 0 {
+		h.Set("Access-Control-Expose-Headers", strings.Join(c.Expose, ", "))
+	}
+}
+
+// Preflight sets the headers of the response to a preflight request
+// from origin which asks for the method and the comma separated list
+// of request headers. It returns false if the request is not allowed.
+func (c *CORS) Preflight(origin, method, headers string, h http.Header) bool {
+	if !c.AllowOrigin(origin) || !c.allowMethod(method) {
+		return false
+	}
+	requested := splitList(headers)
+	for _, name := range requested {
+		if !c.allowHeader(name) {
+			return false
+		}
+	}
+
+	c.Apply(origin, h)
+	h.Add("Vary", "Access-Control-Request-Method")
+	h.Add("Vary", "Access-Control-Request-Headers")
+	if len(c.Methods) > 0 {
+		h.Set("Access-Control-Allow-Methods", strings.Join(c.Methods, ", "))
+	} else {
+		h.Set("Access-Control-Allow-Methods", strings.ToUpper(method))
+	}
+	if len(requested) > 0 {
+		h.Set("Access-Control-Allow-Headers", strings.Join(requested, ", "))
+	}
+	if c.MaxAge > 0 {
+		h.Set("Access-Control-Max-Age", strconv.Itoa(int(c.MaxAge/time.Second)))
+	}
+	return true
+}
+
+// wildcard returns true if all origins are answered with '*'. With
+// credentials the origin of the request is sent instead since browsers
+// reject credentialed responses with a wildcard origin.
+func (c *CORS) wildcard() bool {
+	return !c.Credentials && len(c.Origins) == 1 && c.Origins[0] == "*"
+}
+
+func (c *CORS) allowMethod(method string) bool {
+	method = strings.ToUpper(method)
+	switch method {
+	case "GET", "HEAD", "POST":
+		return true
+	}
+	for _, m := range c.Methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// allowHeader returns true if the header is allowed. The CORS-safelisted
+// request headers are always allowed.
+func (c *CORS) allowHeader(name string) bool {
+	name = http.CanonicalHeaderKey(name)
+	switch name {
+	case "Accept", "Accept-Language", "Content-Language", "Content-Type":
+		return true
+	}
+	for _, h := range c.Headers {
+		if h == "*" || h == name {
+			return true
+		}
+	}
+	return false
+}
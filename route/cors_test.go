@@ -0,0 +1,135 @@
+package route
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseCORS(t *testing.T) {
+	for _, opts := range []map[string]string{
+		{"cors-origins": "example.com"},
+		{"cors-origins": "*", "cors-methods": "PUT,DE LETE"},
+		{"cors-origins": "*", "cors-headers": "X Auth"},
+		{"cors-origins": "*", "cors-credentials": "yes"},
+		{"cors-origins": "*", "cors-maxage": "10"},
+		{"cors-methods": "PUT"},
+	} {
+		if _, err := parseCORS(opts); err == nil {
+			t.Errorf("%v: got nil want error", opts)
+		}
+	}
+
+	if c, err := parseCORS(map[string]string{"strip": "/foo"}); c != nil || err != nil {
+		t.Fatalf("got %v, %v want nil, nil", c, err)
+	}
+
+	c, err := parseCORS(map[string]string{
+		"cors-origins":     "https://A.example.com/, http://b.example.com",
+		"cors-methods":     "put,DELETE",
+		"cors-headers":     "x-auth,*",
+		"cors-expose":      "x-total",
+		"cors-credentials": "true",
+		"cors-maxage":      "10m",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &CORS{
+		Origins:     []string{"https://a.example.com", "http://b.example.com"},
+		Methods:     []string{"PUT", "DELETE"},
+		Headers:     []string{"X-Auth", "*"},
+		Expose:      []string{"X-Total"},
+		Credentials: true,
+		MaxAge:      10 * time.Minute,
+	}
+	if !reflect.DeepEqual(c, want) {
+		t.Fatalf("got %#v want %#v", c, want)
+	}
+}
+
+func TestCORSApply(t *testing.T) {
+	tests := []struct {
+		desc   string
+		cors   *CORS
+		origin string
+		want   http.Header
+	}{
+		{
+			desc:   "wildcard",
+			cors:   &CORS{Origins: []string{"*"}, Expose: []string{"X-Total"}},
+			origin: "https://example.com",
+			want:   http.Header{"Access-Control-Allow-Origin": {"*"}, "Access-Control-Expose-Headers": {"X-Total"}},
+		},
+		{
+			desc:   "wildcard with credentials",
+			cors:   &CORS{Origins: []string{"*"}, Credentials: true},
+			origin: "https://example.com",
+			want:   http.Header{"Access-Control-Allow-Origin": {"https://example.com"}, "Access-Control-Allow-Credentials": {"true"}, "Vary": {"Origin"}},
+		},
+		{
+			desc:   "allowed origin",
+			cors:   &CORS{Origins: []string{"https://example.com"}},
+			origin: "https://Example.com",
+			want:   http.Header{"Access-Control-Allow-Origin": {"https://Example.com"}, "Vary": {"Origin"}},
+		},
+		{
+			desc:   "other origin",
+			cors:   &CORS{Origins: []string{"https://example.com"}},
+			origin: "https://evil.com",
+			want:   http.Header{"Vary": {"Origin"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			// the headers of the target are replaced
+			h := http.Header{"Access-Control-Allow-Origin": {"https://evil.com"}}
+			tt.cors.Apply(tt.origin, h)
+			if !reflect.DeepEqual(h, tt.want) {
+				t.Fatalf("got %v want %v", h, tt.want)
+			}
+		})
+	}
+}
+
+func TestCORSPreflight(t *testing.T) {
+	c := &CORS{
+		Origins: []string{"https://example.com"},
+		Methods: []string{"PUT"},
+		Headers: []string{"X-Auth"},
+		MaxAge:  time.Minute,
+	}
+	tests := []struct {
+		desc, origin, method, headers string
+		ok                            bool
+	}{
+		{"allowed", "https://example.com", "PUT", "x-auth, content-type", true},
+		{"simple method", "https://example.com", "GET", "", true},
+		{"other origin", "https://evil.com", "PUT", "", false},
+		{"other method", "https://example.com", "DELETE", "", false},
+		{"other header", "https://example.com", "PUT", "X-Other", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			h := http.Header{}
+			if got := c.Preflight(tt.origin, tt.method, tt.headers, h); got != tt.ok {
+				t.Fatalf("got %v want %v", got, tt.ok)
+			}
+		})
+	}
+
+	h := http.Header{}
+	c.Preflight("https://example.com", "PUT", "x-auth", h)
+	want := http.Header{
+		"Access-Control-Allow-Origin":  {"https://example.com"},
+		"Access-Control-Allow-Methods": {"PUT"},
+		"Access-Control-Allow-Headers": {"x-auth"},
+		"Access-Control-Max-Age":       {"60"},
+		"Vary":                         {"Origin", "Access-Control-Request-Method", "Access-Control-Request-Headers"},
+	}
+	if !reflect.DeepEqual(h, want) {
+		t.Fatalf("got %v want %v", h, want)
+	}
+}
@@ -0,0 +1,64 @@
+package route
+
+import (
+	"errors"
+	"net/http"
+	"time"
+)
+
+// DebugHeader is the name of the header with a debug token which
+// routes a request to a specific target of the matching route. It is
+// set on startup. If it is empty the header is ignored.
+var DebugHeader string
+
+// DebugSecret is the key which signs the debug tokens. It is set on
+// startup. Debug tokens are disabled without a secret.
+var DebugSecret []byte
+
+// DebugToken returns a signed token for the DebugHeader which routes
+// the requests to the target with the URL dst until the token expires.
+func DebugToken(dst string, expires time.Time) (string, error) {
+	if DebugHeader == "" || len(DebugSecret) == 0 {
+		return "", errors.New("debug tokens are disabled")
+	}
+	return signToken("debug", DebugSecret, dst, expires), nil
+}
+
+// HasTarget returns true if a route of the table
+// has a target with the URL dst.
+func (t Table) HasTarget(dst string) bool {
+	for _, routes := range t {
+		for _, r := range routes {
+			for _, tg := range r.Targets {
+				if tg.URL != nil && tg.URL.String() == dst {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// debugTarget returns the target of the route which is selected by
+// the debug token of req. It returns nil if the request has no valid
+// token or if the route has no target with the URL of the token.
+// Targets without weight and canaries can be selected as well.
+func (r *Route) debugTarget(req *http.Request) *Target {
+	if DebugHeader == "" {
+		return nil
+	}
+	v := req.Header.Get(DebugHeader)
+	if v == "" {
+		return nil
+	}
+	dst := parseToken("debug", DebugSecret, v)
+	if dst == "" {
+		return nil
+	}
+	for _, t := range r.Targets {
+		if t.URL != nil && t.URL.String() == dst {
+			return t
+		}
+	}
+	return nil
+}
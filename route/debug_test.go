@@ -0,0 +1,68 @@
+package route
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestDebugLookup(t *testing.T) {
+	defer func(h string, s []byte) { DebugHeader, DebugSecret = h, s }(DebugHeader, DebugSecret)
+	defer func(now func() time.Time) { tokenNow = now }(tokenNow)
+
+	tbl, err := NewTable(bytes.NewBufferString(`
+		route add svc / http://foo:1
+		route add svc / http://foo:2
+		route add svc / http://foo:3 opts "match-header=X-Canary"
+		route add api /api http://bar:1
+	`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lookup := func(path, token string) string {
+		req := &http.Request{Host: "example.com", URL: mustParse(path), Header: http.Header{}}
+		if token != "" {
+			req.Header.Set("X-Fabio-Target", token)
+		}
+		return tbl.Lookup(req, "", Picker["rr"], Matcher["prefix"], globCache, false).URL.String()
+	}
+
+	if _, err := DebugToken("http://foo:3", time.Now()); err == nil {
+		t.Fatal("got nil want error for disabled debug tokens")
+	}
+
+	DebugHeader, DebugSecret = "X-Fabio-Target", []byte("secret")
+	now := time.Unix(1000, 0)
+	tokenNow = func() time.Time { return now }
+	token, err := DebugToken("http://foo:3", now.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the token selects the canary target
+	for i := 0; i < 3; i++ {
+		if got, want := lookup("/", token), "http://foo:3"; got != want {
+			t.Fatalf("got %s want %s", got, want)
+		}
+	}
+
+	// only targets of the matching route can be selected
+	if got, want := lookup("/api", token), "http://bar:1"; got != want {
+		t.Fatalf("got %s want %s", got, want)
+	}
+
+	// invalid and expired tokens are ignored
+	if got := lookup("/", token[:len(token)-1]+"x"); got == "http://foo:3" {
+		t.Fatalf("got %s for token with invalid signature", got)
+	}
+	DebugSecret = []byte("other")
+	if got := lookup("/", token); got == "http://foo:3" {
+		t.Fatalf("got %s for token with other secret", got)
+	}
+	DebugSecret = []byte("secret")
+	now = now.Add(2 * time.Minute)
+	if got := lookup("/", token); got == "http://foo:3" {
+		t.Fatalf("got %s for expired token", got)
+	}
+}
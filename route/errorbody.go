@@ -0,0 +1,17 @@
+package route
+
+import "fmt"
+
+// parseErrorBody parses the errorbody option which replaces the
+// bodies of the 5xx responses from a target with a standard error
+// response. The value is 'json', 'html' or 'auto' which chooses the
+// format by the Accept header of the request. It returns an empty
+// string if the option is not set.
+func parseErrorBody(opts map[string]string) (string, error) {
+	switch v := opts["errorbody"]; v {
+	case "", "json", "html", "auto":
+		return v, nil
+	default:
+		return "", fmt.Errorf("errorbody should be json, html or auto. Got: %s", v)
+	}
+}
@@ -0,0 +1,15 @@
+package route
+
+import "testing"
+
+func TestParseErrorBody(t *testing.T) {
+	for _, v := range []string{"", "json", "html", "auto"} {
+		got, err := parseErrorBody(map[string]string{"errorbody": v})
+		if err != nil || got != v {
+			t.Fatalf("%q: got %q, %v want %q, nil", v, got, err, v)
+		}
+	}
+	if _, err := parseErrorBody(map[string]string{"errorbody": "xml"}); err == nil {
+		t.Fatal("got nil want error")
+	}
+}
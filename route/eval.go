@@ -0,0 +1,147 @@
+package route
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// EvalTarget describes a target of the route which
+// matched a request during evaluation.
+type EvalTarget struct {
+	Service string  `json:"service"`
+	Dst     string  `json:"dst"`
+	Weight  float64 `json:"weight"`
+}
+
+// EvalResult describes how a single request of an
+// evaluation would be routed.
+type EvalResult struct {
+	Line     int          `json:"line"`
+	Request  string       `json:"request"`
+	Host     string       `json:"host,omitempty"`
+	Path     string       `json:"path,omitempty"`
+	Src      string       `json:"src,omitempty"`
+	Targets  []EvalTarget `json:"targets,omitempty"`
+	Redirect string       `json:"redirect,omitempty"`
+	NoRoute  bool         `json:"noroute,omitempty"`
+	Error    string       `json:"error,omitempty"`
+}
+
+// Eval reports which route and targets the requests read from r
+// would be sent to with the routing table t without sending them.
+// It is used to validate a routing table before it is applied.
+//
+// Every line describes a single request in the form
+//
+//	[http[s]://]host/path[?query] [Header:value ...]
+//
+// Empty lines and lines starting with '#' are ignored.
+func Eval(t Table, r io.Reader, matcherName string, globDisabled bool) ([]EvalResult, error) {
+	match, ok := Matcher[matcherName]
+	if !ok {
+		return nil, fmt.Errorf("route: invalid matcher %q", matcherName)
+	}
+	globCache := NewGlobCache(1000)
+
+	// first reports the first weighted target so that the result
+	// only depends on the routing table.
+	first := func(r *Route, _ *http.Request) *Target {
+		if len(r.wTargets) == 0 {
+			return nil
+		}
+		return r.wTargets[0]
+	}
+
+	results := []EvalResult{}
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		res := EvalResult{Line: n, Request: line}
+		req, err := evalRequest(line)
+		if err != nil {
+			res.Error = err.Error()
+			results = append(results, res)
+			continue
+		}
+		res.Host, res.Path = req.Host, req.URL.Path
+
+		tg := t.Lookup(req, "", first, match, globCache, globDisabled)
+		if tg == nil {
+			res.NoRoute = true
+			results = append(results, res)
+			continue
+		}
+		if tg.RedirectURL != nil {
+			res.Redirect = tg.RedirectURL.String()
+		}
+		if rt := t.routeOf(tg); rt != nil {
+			res.Src = rt.Host + rt.Path
+			for _, x := range rt.Targets {
+				res.Targets = append(res.Targets, EvalTarget{Service: x.Service, Dst: x.URL.String(), Weight: x.Weight})
+			}
+		}
+		results = append(results, res)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// evalRequest creates the request for a line of an evaluation.
+func evalRequest(line string) (*http.Request, error) {
+	f := strings.Fields(line)
+	rawurl := f[0]
+	if !strings.Contains(rawurl, "://") {
+		rawurl = "http://" + rawurl
+	}
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("missing host in %q", f[0])
+	}
+	if u.Path == "" {
+		u.Path = "/"
+	}
+
+	req := &http.Request{Method: "GET", Host: u.Host, URL: u, Header: http.Header{}}
+	if u.Scheme == "https" {
+		req.TLS = &tls.ConnectionState{}
+		req.Header.Set("X-Forwarded-Proto", "https")
+	} else {
+		req.Header.Set("X-Forwarded-Proto", "http")
+	}
+	for _, h := range f[1:] {
+		p := strings.SplitN(h, ":", 2)
+		if len(p) != 2 || p[0] == "" {
+			return nil, fmt.Errorf("invalid header %q", h)
+		}
+		req.Header.Set(p[0], p[1])
+	}
+	return req, nil
+}
+
+// routeOf returns the route which contains the target.
+func (t Table) routeOf(tg *Target) *Route {
+	for _, routes := range t {
+		for _, r := range routes {
+			for _, x := range r.Targets {
+				if x == tg {
+					return r
+				}
+			}
+		}
+	}
+	return nil
+}
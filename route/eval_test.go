@@ -0,0 +1,61 @@
+package route
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEval(t *testing.T) {
+	tbl, err := NewTable(bytes.NewBufferString(`
+		route add svc-a example.com/foo http://1.1.1.1:5000/
+		route add svc-a example.com/foo http://1.1.1.2:5000/
+		route add svc-b /bar http://2.2.2.2:5000/
+		route add svc-c secure.com/ https://www.secure.com$path opts "redirect=301"
+	`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	requests := `
+		# comment
+		example.com/foo/x
+		http://other.com/bar?x=1 X-Foo:bar
+		example.com/baz
+		https://secure.com/a
+		example.com/x Invalid
+	`
+	got, err := Eval(tbl, strings.NewReader(requests), "prefix", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []EvalResult{
+		{
+			Line: 3, Request: "example.com/foo/x", Host: "example.com", Path: "/foo/x", Src: "example.com/foo",
+			Targets: []EvalTarget{
+				{Service: "svc-a", Dst: "http://1.1.1.1:5000/", Weight: 0.5},
+				{Service: "svc-a", Dst: "http://1.1.1.2:5000/", Weight: 0.5},
+			},
+		},
+		{
+			Line: 4, Request: "http://other.com/bar?x=1 X-Foo:bar", Host: "other.com", Path: "/bar", Src: "/bar",
+			Targets: []EvalTarget{{Service: "svc-b", Dst: "http://2.2.2.2:5000/", Weight: 1}},
+		},
+		{Line: 5, Request: "example.com/baz", Host: "example.com", Path: "/baz", NoRoute: true},
+		{
+			Line: 6, Request: "https://secure.com/a", Host: "secure.com", Path: "/a", Src: "secure.com/",
+			Targets:  []EvalTarget{{Service: "svc-c", Dst: "https://www.secure.com$path", Weight: 1}},
+			Redirect: "https://www.secure.com/a",
+		},
+		{Line: 7, Request: "example.com/x Invalid", Error: `invalid header "Invalid"`},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("\ngot  %+v\nwant %+v", got, want)
+	}
+
+	if _, err := Eval(tbl, strings.NewReader(""), "foo", false); err == nil {
+		t.Fatal("expected error for invalid matcher")
+	}
+}
@@ -0,0 +1,204 @@
+package route
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fabiolb/fabio/metrics"
+)
+
+// RouteChange describes a single route which was added to or
+// removed from the routing table.
+type RouteChange struct {
+	Src string `json:"src"`
+	Dst string `json:"dst"`
+	Cmd string `json:"cmd"`
+}
+
+// ServiceDelta contains the routes of a service which were added
+// or removed between two routing tables.
+type ServiceDelta struct {
+	Service string        `json:"service"`
+	Added   []RouteChange `json:"added,omitempty"`
+	Removed []RouteChange `json:"removed,omitempty"`
+}
+
+// TableEvent describes a change of the active routing table.
+type TableEvent struct {
+	Seq      uint64         `json:"seq"`
+	Time     time.Time      `json:"time"`
+	Services []ServiceDelta `json:"services"`
+}
+
+// Diff returns the routes which were added and removed between the
+// last and the next routing table grouped by service and sorted
+// by service name. Routes are compared by their configuration
+// without the computed weights so that a change of the number of
+// instances of one service does not show up as a change of the
+// others.
+func Diff(last, next Table) []ServiceDelta {
+	lastRoutes, nextRoutes := changes(last), changes(next)
+
+	m := map[string]*ServiceDelta{}
+	delta := func(service string) *ServiceDelta {
+		d := m[service]
+		if d == nil {
+			d = &ServiceDelta{Service: service}
+			m[service] = d
+		}
+		return d
+	}
+	for cmd, c := range nextRoutes {
+		if _, ok := lastRoutes[cmd]; !ok {
+			d := delta(c.service)
+			d.Added = append(d.Added, c.RouteChange)
+		}
+	}
+	for cmd, c := range lastRoutes {
+		if _, ok := nextRoutes[cmd]; !ok {
+			d := delta(c.service)
+			d.Removed = append(d.Removed, c.RouteChange)
+		}
+	}
+
+	var deltas []ServiceDelta
+	for _, d := range m {
+		sort.Slice(d.Added, func(i, j int) bool { return d.Added[i].Cmd < d.Added[j].Cmd })
+		sort.Slice(d.Removed, func(i, j int) bool { return d.Removed[i].Cmd < d.Removed[j].Cmd })
+		deltas = append(deltas, *d)
+	}
+	sort.Slice(deltas, func(i, j int) bool { return deltas[i].Service < deltas[j].Service })
+	return deltas
+}
+
+type serviceChange struct {
+	RouteChange
+	service string
+}
+
+// changes returns all active routes of the table indexed
+// by their configuration.
+func changes(t Table) map[string]serviceChange {
+	m := map[string]serviceChange{}
+	for _, routes := range t {
+		for _, r := range routes {
+			for _, tg := range r.Targets {
+				if tg.Weight <= 0 && !tg.Backup {
+					continue
+				}
+				cmd := r.TargetConfig(tg, false)
+				m[cmd] = serviceChange{
+					RouteChange: RouteChange{Src: r.Host + r.Path, Dst: tg.URL.String(), Cmd: cmd},
+					service:     tg.Service,
+				}
+			}
+		}
+	}
+	return m
+}
+
+// Events records the changes of the active routing table.
+var Events = NewEventLog(100)
+
+// EventLog keeps the last routing table changes and
+// notifies subscribers about new ones.
+type EventLog struct {
+	mu     sync.Mutex
+	size   int
+	seq    uint64
+	events []TableEvent
+	subs   map[chan TableEvent]bool
+}
+
+// NewEventLog creates an event log which keeps the last size events.
+func NewEventLog(size int) *EventLog {
+	return &EventLog{size: size, subs: map[chan TableEvent]bool{}}
+}
+
+// Add records a new event for the given changes and sends
+// it to all subscribers. Subscribers which cannot keep up
+// miss the event and can get it with Fill. The missed events
+// are counted in the route.events.dropped metric.
+func (l *EventLog) Add(services []ServiceDelta) TableEvent {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.seq++
+	ev := TableEvent{Seq: l.seq, Time: time.Now().UTC(), Services: services}
+	l.events = append(l.events, ev)
+	if len(l.events) > l.size {
+		l.events = l.events[len(l.events)-l.size:]
+	}
+
+	for ch := range l.subs {
+		select {
+		case ch <- ev:
+		default:
+			metrics.DefaultRegistry.GetCounter("route.events.dropped").Inc(1)
+			log.Printf("[WARN] route: Dropping table event %d for slow subscriber", ev.Seq)
+		}
+	}
+	return ev
+}
+
+// Seq returns the sequence number of the last event which
+// is the version of the active routing table.
+func (l *EventLog) Seq() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.seq
+}
+
+// Since returns the recorded events with a sequence
+// number larger than seq in the order they occurred.
+func (l *EventLog) Since(seq uint64) []TableEvent {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	events := []TableEvent{}
+	for _, ev := range l.events {
+		if ev.Seq > seq {
+			events = append(events, ev)
+		}
+	}
+	return events
+}
+
+// Fill returns the recorded events between last and ev followed by ev
+// for a subscriber which has received the event with the sequence
+// number last and then ev. missed is the number of events in between
+// which are no longer recorded.
+func (l *EventLog) Fill(last uint64, ev TableEvent) (events []TableEvent, missed uint64) {
+	if ev.Seq <= last+1 {
+		return []TableEvent{ev}, 0
+	}
+	for _, e := range l.Since(last) {
+		if e.Seq < ev.Seq {
+			events = append(events, e)
+		}
+	}
+	missed = ev.Seq - last - 1 - uint64(len(events))
+	return append(events, ev), missed
+}
+
+// Subscribe returns a channel which receives all new events
+// and a function which cancels the subscription.
+func (l *EventLog) Subscribe() (<-chan TableEvent, func()) {
+	ch := make(chan TableEvent, 16)
+
+	l.mu.Lock()
+	l.subs[ch] = true
+	l.mu.Unlock()
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			l.mu.Lock()
+			delete(l.subs, ch)
+			l.mu.Unlock()
+		})
+	}
+	return ch, cancel
+}
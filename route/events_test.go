@@ -0,0 +1,119 @@
+package route
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	mustTable := func(s string) Table {
+		tbl, err := NewTable(bytes.NewBufferString(s))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tbl
+	}
+
+	last := mustTable(`
+		route add svc-a /foo http://1.1.1.1:5000/
+		route add svc-a /foo http://1.1.1.2:5000/
+		route add svc-b /bar http://2.2.2.2:5000/
+	`)
+	next := mustTable(`
+		route add svc-a /foo http://1.1.1.1:5000/
+		route add svc-a /foo http://1.1.1.3:5000/
+		route add svc-c /baz http://3.3.3.3:5000/ opts "strip=/baz"
+	`)
+
+	want := []ServiceDelta{
+		{
+			Service: "svc-a",
+			Added:   []RouteChange{{Src: "/foo", Dst: "http://1.1.1.3:5000/", Cmd: "route add svc-a /foo http://1.1.1.3:5000/"}},
+			Removed: []RouteChange{{Src: "/foo", Dst: "http://1.1.1.2:5000/", Cmd: "route add svc-a /foo http://1.1.1.2:5000/"}},
+		},
+		{
+			Service: "svc-b",
+			Removed: []RouteChange{{Src: "/bar", Dst: "http://2.2.2.2:5000/", Cmd: "route add svc-b /bar http://2.2.2.2:5000/"}},
+		},
+		{
+			Service: "svc-c",
+			Added:   []RouteChange{{Src: "/baz", Dst: "http://3.3.3.3:5000/", Cmd: `route add svc-c /baz http://3.3.3.3:5000/ opts "strip=/baz"`}},
+		},
+	}
+
+	if got := Diff(last, next); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %#v want %#v", got, want)
+	}
+	if got := Diff(next, next); len(got) != 0 {
+		t.Fatalf("got %#v want no changes", got)
+	}
+}
+
+func TestEventLog(t *testing.T) {
+	l := NewEventLog(2)
+	ch, cancel := l.Subscribe()
+	defer cancel()
+
+	for i := 0; i < 3; i++ {
+		l.Add([]ServiceDelta{{Service: "svc"}})
+	}
+
+	seqs := func(events []TableEvent) (s []uint64) {
+		for _, ev := range events {
+			s = append(s, ev.Seq)
+		}
+		return s
+	}
+	if got, want := seqs(l.Since(0)), []uint64{2, 3}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+	if got, want := seqs(l.Since(2)), []uint64{3}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+	if got, want := l.Seq(), uint64(3); got != want {
+		t.Fatalf("got seq %d want %d", got, want)
+	}
+	for want := uint64(1); want <= 3; want++ {
+		if ev := <-ch; ev.Seq != want {
+			t.Fatalf("got event %d want %d", ev.Seq, want)
+		}
+	}
+}
+
+func TestEventLogFill(t *testing.T) {
+	l := NewEventLog(2)
+	var events []TableEvent
+	for i := 0; i < 5; i++ {
+		events = append(events, l.Add(nil))
+	}
+
+	seqs := func(events []TableEvent) (s []uint64) {
+		for _, ev := range events {
+			s = append(s, ev.Seq)
+		}
+		return s
+	}
+	tests := []struct {
+		desc   string
+		last   uint64
+		ev     TableEvent
+		seqs   []uint64
+		missed uint64
+	}{
+		{"no gap", 3, events[3], []uint64{4}, 0},
+		{"gap in log", 3, events[4], []uint64{4, 5}, 0},
+		{"gap not in log", 1, events[4], []uint64{4, 5}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			evs, missed := l.Fill(tt.last, tt.ev)
+			if got, want := seqs(evs), tt.seqs; !reflect.DeepEqual(got, want) {
+				t.Fatalf("got %v want %v", got, want)
+			}
+			if got, want := missed, tt.missed; got != want {
+				t.Fatalf("got missed %d want %d", got, want)
+			}
+		})
+	}
+}
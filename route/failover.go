@@ -0,0 +1,343 @@
+package route
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/fabiolb/fabio/metrics"
+)
+
+// FailoverThreshold is the share of healthy targets of the active
+// region of a service below which its traffic moves to the next
+// region.
+var FailoverThreshold = 0.5
+
+// FailoverRecover is the share of healthy targets a more preferred
+// region must have for FailoverHold before the traffic moves back.
+var FailoverRecover = 0.8
+
+// FailoverHold is the time a more preferred region must be healthy
+// before the traffic moves back to it.
+var FailoverHold = time.Minute
+
+// RegionHealth is the number of healthy targets of a region.
+type RegionHealth struct {
+	Name    string  `json:"name"`
+	Healthy int     `json:"healthy"`
+	Targets int     `json:"targets"`
+	Ratio   float64 `json:"ratio"`
+}
+
+// FailoverStatus is the state of the region failover of a service.
+type FailoverStatus struct {
+	Service string         `json:"service"`
+	Active  string         `json:"active"`
+	Since   time.Time      `json:"since"`
+	Forced  string         `json:"forced,omitempty"`
+	Regions []RegionHealth `json:"regions"`
+}
+
+// failoverState is the state of a service with the failover option.
+type failoverState struct {
+	FailoverStatus
+
+	// candidate is the more preferred region which has been
+	// healthy enough to take the traffic back since candidateSince.
+	candidate      string
+	candidateSince time.Time
+}
+
+// failovers contains the state of the services with the failover
+// option. failoverTimer moves the traffic back to a recovered region
+// after FailoverHold if the routing table does not change. Both are
+// guarded by mu.
+var (
+	failovers     = map[string]*failoverState{}
+	failoverTimer *time.Timer
+)
+
+// activeRegions contains the active region of the services with the
+// failover option as a map[string]string. It is replaced when a
+// service fails over and read without a lock.
+var activeRegions atomic.Value
+
+func init() {
+	activeRegions.Store(map[string]string{})
+}
+
+// parseFailover parses the region and failover options. The region
+// of a target defaults to the first region of the failover option.
+func parseFailover(opts map[string]string) (region string, regions []string, err error) {
+	if v := opts["failover"]; v != "" {
+		seen := map[string]bool{}
+		for _, r := range strings.Split(v, ",") {
+			r = strings.TrimSpace(r)
+			if r == "" || seen[r] {
+				return "", nil, fmt.Errorf("failover should be a list of distinct regions. Got: %s", v)
+			}
+			seen[r] = true
+			regions = append(regions, r)
+		}
+	}
+	region = opts["region"]
+	if region == "" && len(regions) > 0 {
+		region = regions[0]
+	}
+	return region, regions, nil
+}
+
+// failoverTargets returns the targets in the active region of their
+// service. Targets of services without the failover option are
+// always returned. If none of the targets is in the active region
+// all targets are returned.
+func failoverTargets(targets []*Target) []*Target {
+	active := activeRegions.Load().(map[string]string)
+	if len(active) == 0 {
+		return targets
+	}
+	var out []*Target
+	for _, t := range targets {
+		r, ok := active[t.Service]
+		if !ok || t.Failover == nil || t.Region == r {
+			out = append(out, t)
+		}
+	}
+	if len(out) == 0 {
+		return targets
+	}
+	return out
+}
+
+// syncFailover counts the healthy targets per region of the services
+// with the failover option and moves the traffic of a service to
+// another region if necessary. It returns true if the active region
+// of a service has changed. The caller must hold mu.
+func syncFailover(t Table) bool {
+	type service struct {
+		regions   []string
+		healthy   map[string]int
+		instances map[string]map[string]bool
+	}
+	services := map[string]*service{}
+	for _, routes := range t {
+		for _, r := range routes {
+			for _, tg := range r.Targets {
+				if tg.Failover == nil || tg.URL == nil {
+					continue
+				}
+				s := services[tg.Service]
+				if s == nil {
+					s = &service{regions: tg.Failover, healthy: map[string]int{}, instances: map[string]map[string]bool{}}
+					services[tg.Service] = s
+				}
+				// count every service instance once
+				key := flapKey(tg)
+				m := s.instances[tg.Region]
+				if m == nil {
+					m = map[string]bool{}
+					s.instances[tg.Region] = m
+				}
+				if _, ok := m[key]; ok {
+					continue
+				}
+				healthy := !tg.Unhealthy() && !tg.Ejected()
+				m[key] = healthy
+				if healthy {
+					s.healthy[tg.Region]++
+				}
+			}
+		}
+	}
+	if len(services) == 0 && len(failovers) == 0 {
+		return false
+	}
+
+	now := timeNow()
+	changed := false
+	for name := range failovers {
+		if services[name] == nil {
+			delete(failovers, name)
+			changed = true
+		}
+	}
+
+	var next time.Time
+	for name, s := range services {
+		regions := make([]RegionHealth, len(s.regions))
+		ratio := map[string]float64{}
+		for i, r := range s.regions {
+			h := RegionHealth{Name: r, Healthy: s.healthy[r], Targets: len(s.instances[r])}
+			if h.Targets > 0 {
+				h.Ratio = float64(h.Healthy) / float64(h.Targets)
+			}
+			regions[i], ratio[r] = h, h.Ratio
+		}
+
+		st := failovers[name]
+		if st == nil {
+			st = &failoverState{FailoverStatus: FailoverStatus{Service: name, Active: s.regions[0], Since: now}}
+			failovers[name] = st
+			changed = true
+		}
+		st.Regions = regions
+
+		want := nextRegion(st, s.regions, ratio, now)
+		if !st.candidateSince.IsZero() && st.candidate != "" {
+			if at := st.candidateSince.Add(FailoverHold); next.IsZero() || at.Before(next) {
+				next = at
+			}
+		}
+		if want != st.Active {
+			log.Printf("[WARN] route: Failing over %s from region %s to %s", name, st.Active, want)
+			st.Active, st.Since = want, now
+			changed = true
+		}
+		for i, r := range s.regions {
+			if r == st.Active {
+				metrics.DefaultRegistry.GetGauge("failover." + name).Update(int64(i))
+			}
+		}
+	}
+
+	// the active region may also have been changed by
+	// ForceFailover or ClearFailover
+	old := activeRegions.Load().(map[string]string)
+	active := make(map[string]string, len(failovers))
+	for name, st := range failovers {
+		active[name] = st.Active
+		if old[name] != st.Active {
+			changed = true
+		}
+	}
+	if changed || len(old) != len(active) {
+		activeRegions.Store(active)
+	}
+
+	if failoverTimer != nil {
+		failoverTimer.Stop()
+		failoverTimer = nil
+	}
+	if !next.IsZero() {
+		failoverTimer = time.AfterFunc(next.Sub(now), func() {
+			mu.Lock()
+			defer mu.Unlock()
+			table.Store(GetTable().reweigh())
+		})
+	}
+	return changed
+}
+
+// nextRegion returns the region which should receive the traffic of
+// the service. A forced region always receives the traffic. The
+// traffic moves to the first region with at least FailoverThreshold
+// healthy targets when the active region drops below the threshold
+// and back to a more preferred region after it has had at least
+// FailoverRecover healthy targets for FailoverHold.
+func nextRegion(st *failoverState, regions []string, ratio map[string]float64, now time.Time) string {
+	if st.Forced != "" {
+		st.candidate = ""
+		return st.Forced
+	}
+
+	want := st.Active
+	if _, ok := ratio[want]; !ok {
+		want = regions[0]
+	}
+	if ratio[want] < FailoverThreshold {
+		best := want
+		for _, r := range regions {
+			if r != want && ratio[r] >= FailoverThreshold {
+				best = r
+				break
+			}
+		}
+		// no region is healthy enough. Use the best one.
+		if best == want {
+			for _, r := range regions {
+				if ratio[r] > ratio[best] {
+					best = r
+				}
+			}
+		}
+		want = best
+	}
+
+	candidate := ""
+	for _, r := range regions {
+		if r == want {
+			break
+		}
+		if ratio[r] >= FailoverRecover {
+			candidate = r
+			break
+		}
+	}
+	switch {
+	case candidate == "":
+		st.candidate = ""
+	case candidate != st.candidate:
+		st.candidate, st.candidateSince = candidate, now
+	}
+	if st.candidate != "" && now.Sub(st.candidateSince) >= FailoverHold {
+		want, st.candidate = st.candidate, ""
+	}
+	return want
+}
+
+// Failovers returns the state of the services with
+// the failover option sorted by service name.
+func Failovers() []FailoverStatus {
+	mu.Lock()
+	defer mu.Unlock()
+	list := []FailoverStatus{}
+	for _, st := range failovers {
+		list = append(list, st.FailoverStatus)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Service < list[j].Service })
+	return list
+}
+
+// ForceFailover moves the traffic of the service to the region until
+// ClearFailover is called independently of the health of the regions.
+func ForceFailover(service, region string) (FailoverStatus, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	st := failovers[service]
+	if st == nil {
+		return FailoverStatus{}, fmt.Errorf("service %q has no failover regions", service)
+	}
+	found := false
+	for _, r := range st.Regions {
+		found = found || r.Name == region
+	}
+	if !found {
+		return FailoverStatus{}, fmt.Errorf("unknown region %q of service %q", region, service)
+	}
+	log.Printf("[INFO] route: Forcing failover of %s to region %s", service, region)
+	st.Forced = region
+	table.Store(GetTable().reweigh())
+	return st.FailoverStatus, nil
+}
+
+// ClearFailover returns the service to the automatic failover which
+// starts again with the most preferred region that is healthy enough.
+// It returns false if the region of the service was not forced.
+func ClearFailover(service string) bool {
+	mu.Lock()
+	defer mu.Unlock()
+	st := failovers[service]
+	if st == nil || st.Forced == "" {
+		return false
+	}
+	log.Printf("[INFO] route: Cleared forced failover of %s", service)
+	st.Forced = ""
+	if st.Active != st.Regions[0].Name {
+		st.Active, st.Since = st.Regions[0].Name, timeNow()
+	}
+	table.Store(GetTable().reweigh())
+	return true
+}
@@ -0,0 +1,140 @@
+package route
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseFailover(t *testing.T) {
+	tests := []struct {
+		opts    map[string]string
+		region  string
+		regions []string
+		err     bool
+	}{
+		{opts: map[string]string{}},
+		{opts: map[string]string{"region": "eu"}, region: "eu"},
+		{opts: map[string]string{"failover": "eu,us"}, region: "eu", regions: []string{"eu", "us"}},
+		{opts: map[string]string{"region": "us", "failover": "eu, us"}, region: "us", regions: []string{"eu", "us"}},
+		{opts: map[string]string{"failover": "eu,eu"}, err: true},
+		{opts: map[string]string{"failover": "eu,,us"}, err: true},
+	}
+	for i, tt := range tests {
+		region, regions, err := parseFailover(tt.opts)
+		if got, want := err != nil, tt.err; got != want {
+			t.Fatalf("%d: got error %v want %v", i, err, want)
+		}
+		if region != tt.region || !reflect.DeepEqual(regions, tt.regions) {
+			t.Fatalf("%d: got %q %v want %q %v", i, region, regions, tt.region, tt.regions)
+		}
+	}
+}
+
+func TestFailover(t *testing.T) {
+	FailoverThreshold, FailoverRecover, FailoverHold = 0.5, 0.8, time.Minute
+	start := time.Now()
+	now := start
+	timeNow = func() time.Time { return now }
+	defer func() {
+		FailoverThreshold, FailoverRecover, FailoverHold = 0.5, 0.8, time.Minute
+		timeNow = time.Now
+		unhealthy.Store(map[string]bool{})
+		resetFailover()
+		SetTable(make(Table))
+	}()
+
+	const cfg = `route add svc / http://a1/ opts "failover=eu,us healthcheck=tcp region=eu"
+route add svc / http://a2/ opts "failover=eu,us healthcheck=tcp region=eu"
+route add svc / http://b1/ opts "failover=eu,us healthcheck=tcp region=us"
+route add svc / http://b2/ opts "failover=eu,us healthcheck=tcp region=us"`
+	tbl, err := NewTable(bytes.NewBufferString(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetTable(tbl)
+
+	target := func(host string) *Target {
+		for _, tg := range GetTable()[""][0].Targets {
+			if tg.URL.Host == host {
+				return tg
+			}
+		}
+		t.Fatalf("target %s not found", host)
+		return nil
+	}
+	check := func(desc string, want ...float64) {
+		t.Helper()
+		got := []float64{target("a1").Weight, target("a2").Weight, target("b1").Weight, target("b2").Weight}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("%s: got weights %v want %v", desc, got, want)
+		}
+	}
+	a1, a2 := target("a1").HealthKey(), target("a2").HealthKey()
+
+	check("primary", 0.5, 0.5, 0, 0)
+
+	SetHealthy(a1, false)
+	check("at threshold", 0, 1, 0, 0)
+
+	SetHealthy(a2, false)
+	check("failed over", 0, 0, 0.5, 0.5)
+	if got, want := Failovers()[0].Regions, []RegionHealth{{"eu", 0, 2, 0}, {"us", 2, 2, 1}}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got regions %v want %v", got, want)
+	}
+
+	// the traffic stays in the secondary region until
+	// the primary region has recovered for the hold time
+	SetHealthy(a1, true)
+	check("below recover", 0, 0, 0.5, 0.5)
+	now = start.Add(10 * time.Second)
+	SetHealthy(a2, true)
+	check("hold", 0, 0, 0.5, 0.5)
+	now = start.Add(69 * time.Second)
+	reweighTable()
+	check("hold", 0, 0, 0.5, 0.5)
+	now = start.Add(70 * time.Second)
+	reweighTable()
+	check("failed back", 0.5, 0.5, 0, 0)
+
+	// forced failover
+	if _, err := ForceFailover("svc", "mars"); err == nil {
+		t.Fatal("got nil want error for unknown region")
+	}
+	if _, err := ForceFailover("other", "us"); err == nil {
+		t.Fatal("got nil want error for unknown service")
+	}
+	st, err := ForceFailover("svc", "us")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Active != "us" || st.Forced != "us" {
+		t.Fatalf("got %+v want active and forced region us", st)
+	}
+	check("forced", 0, 0, 0.5, 0.5)
+	if !ClearFailover("svc") {
+		t.Fatal("got false want true")
+	}
+	check("cleared", 0.5, 0.5, 0, 0)
+	if ClearFailover("svc") {
+		t.Fatal("got true want false")
+	}
+}
+
+func reweighTable() {
+	mu.Lock()
+	defer mu.Unlock()
+	table.Store(GetTable().reweigh())
+}
+
+func resetFailover() {
+	mu.Lock()
+	defer mu.Unlock()
+	if failoverTimer != nil {
+		failoverTimer.Stop()
+		failoverTimer = nil
+	}
+	failovers = map[string]*failoverState{}
+	activeRegions.Store(map[string]string{})
+}
@@ -0,0 +1,243 @@
+package route
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/fabiolb/fabio/metrics"
+)
+
+// FlapThreshold is the number of times a target has to appear in or
+// disappear from the routing table within FlapWindow to be considered
+// flapping. A value of zero disables the flap detection.
+var FlapThreshold int
+
+// FlapWindow is the time window in which the changes of a target are counted.
+var FlapWindow = 5 * time.Minute
+
+// FlapHold is the time a flapping target has to stay in or out of the
+// routing table before its weight is restored.
+var FlapHold = 5 * time.Minute
+
+// FlapWeight is the factor by which the weight of flapping targets is
+// reduced. A value of zero holds them out of rotation unless all
+// targets of a route are flapping.
+var FlapWeight = 0.1
+
+// flapState records the changes of a service instance.
+type flapState struct {
+	changes  []time.Time
+	last     time.Time
+	flapping bool
+}
+
+// flapStates contains the state of the service instances which have
+// changed within FlapWindow or are flapping. flapTimer restores the
+// weight of the flapping targets after FlapHold if the routing table
+// does not change. Both are guarded by mu.
+var (
+	flapStates = map[string]*flapState{}
+	flapTimer  *time.Timer
+)
+
+// flapping contains the keys of the flapping service instances as a
+// map[string]bool. It is replaced when the set changes and read
+// without a lock.
+var flapping atomic.Value
+
+func init() {
+	flapping.Store(map[string]bool{})
+}
+
+// flapKey identifies the service instance of a target.
+func flapKey(t *Target) string {
+	return t.Service + " " + t.URL.String()
+}
+
+// Flapping returns true if the service instance of the target repeatedly
+// appears in and disappears from the routing table.
+func (t *Target) Flapping() bool {
+	return FlapThreshold > 0 && t.URL != nil && flapping.Load().(map[string]bool)[flapKey(t)]
+}
+
+// syncFlaps records the service instances which appear or disappear
+// in the next routing table and updates the set of flapping targets.
+// It returns true if the set has changed. The caller must hold mu.
+func syncFlaps(last, next Table) bool {
+	if FlapThreshold <= 0 {
+		return false
+	}
+
+	now := timeNow()
+	a, b := instances(last), instances(next)
+	for k := range a {
+		if !b[k] {
+			recordFlap(k, now)
+		}
+	}
+	for k := range b {
+		if !a[k] {
+			recordFlap(k, now)
+		}
+	}
+	return updateFlapping(now)
+}
+
+// instances returns the keys of the service instances in the table.
+func instances(t Table) map[string]bool {
+	m := map[string]bool{}
+	for _, routes := range t {
+		for _, r := range routes {
+			for _, tg := range r.Targets {
+				m[flapKey(tg)] = true
+			}
+		}
+	}
+	return m
+}
+
+func recordFlap(key string, now time.Time) {
+	s := flapStates[key]
+	if s == nil {
+		s = &flapState{}
+		flapStates[key] = s
+	}
+	s.changes = append(s.changes, now)
+	s.last = now
+}
+
+// updateFlapping marks the service instances with FlapThreshold changes
+// within FlapWindow as flapping and the flapping ones without a change
+// within FlapHold as stable. It returns true if the set of flapping
+// targets has changed. The caller must hold mu.
+func updateFlapping(now time.Time) bool {
+	old := flapping.Load().(map[string]bool)
+	cur := map[string]bool{}
+
+	var next time.Duration
+	for key, s := range flapStates {
+		// forget the changes outside of the window
+		i := 0
+		for i < len(s.changes) && now.Sub(s.changes[i]) >= FlapWindow {
+			i++
+		}
+		s.changes = s.changes[i:]
+
+		switch {
+		case !s.flapping && len(s.changes) >= FlapThreshold:
+			s.flapping = true
+			log.Printf("[WARN] route: %s is flapping. It changed %d times within %s", key, len(s.changes), FlapWindow)
+			metrics.DefaultRegistry.GetCounter("flap.detected").Inc(1)
+		case s.flapping && now.Sub(s.last) >= FlapHold:
+			// start counting again so that the target
+			// is not marked as flapping immediately
+			s.flapping, s.changes = false, nil
+			log.Printf("[INFO] route: %s is stable again", key)
+		}
+
+		switch {
+		case s.flapping:
+			cur[key] = true
+			if wait := FlapHold - now.Sub(s.last); next == 0 || wait < next {
+				next = wait
+			}
+		case len(s.changes) == 0:
+			delete(flapStates, key)
+		}
+	}
+	metrics.DefaultRegistry.GetGauge("flap.targets").Update(int64(len(cur)))
+
+	changed := len(old) != len(cur)
+	for key := range cur {
+		if !old[key] {
+			changed = true
+		}
+	}
+	if changed {
+		flapping.Store(cur)
+	}
+
+	// restore the weights after the hold time
+	// even if the routing table does not change.
+	if next > 0 && flapTimer == nil {
+		flapTimer = time.AfterFunc(next, func() {
+			mu.Lock()
+			defer mu.Unlock()
+			flapTimer = nil
+			if updateFlapping(timeNow()) {
+				table.Store(GetTable().reweigh())
+			}
+		})
+	}
+	return changed
+}
+
+// decayFlapping reduces the weight of the flapping targets by
+// FlapWeight and scales the weights of all targets so that their sum
+// does not change. The weights are not changed if all targets would
+// be held out of rotation.
+func decayFlapping(targets []*Target) {
+	var sum, decayed float64
+	var n int
+	for _, t := range targets {
+		sum += t.Weight
+		if t.Flapping() {
+			n++
+			decayed += t.Weight * FlapWeight
+		} else {
+			decayed += t.Weight
+		}
+	}
+	if n == 0 || decayed <= 0 {
+		return
+	}
+	for _, t := range targets {
+		if t.Flapping() {
+			t.Weight *= FlapWeight
+		}
+		t.Weight *= sum / decayed
+	}
+}
+
+// hasFlapping returns true if one of the targets is flapping.
+func hasFlapping(targets []*Target) bool {
+	for _, t := range targets {
+		if t.Flapping() {
+			return true
+		}
+	}
+	return false
+}
+
+// reweigh returns a copy of the table with the weights of the targets
+// computed again after the active regions have been updated. The
+// routes and targets are copied since the weights of a published
+// table must not change.
+func (t Table) reweigh() Table {
+	syncFailover(t)
+	nt := make(Table, len(t))
+	for host, routes := range t {
+		nr := make(Routes, len(routes))
+		for i, r := range routes {
+			c := &Route{
+				Host:        r.Host,
+				Path:        r.Path,
+				Targets:     make([]*Target, len(r.Targets)),
+				total:       atomic.LoadUint64(&r.total),
+				canaryTotal: atomic.LoadUint64(&r.canaryTotal),
+				Glob:        r.Glob,
+				Default:     r.Default,
+			}
+			for j, tg := range r.Targets {
+				ct := *tg
+				ct.route = c
+				c.Targets[j] = &ct
+			}
+			c.weighTargets()
+			nr[i] = c
+		}
+		nt[host] = nr
+	}
+	return nt
+}
@@ -0,0 +1,111 @@
+package route
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestFlapping(t *testing.T) {
+	FlapThreshold, FlapWindow, FlapHold, FlapWeight = 3, time.Minute, time.Minute, 0
+	now := time.Now()
+	timeNow = func() time.Time { return now }
+	defer func() {
+		FlapThreshold, FlapWindow, FlapHold, FlapWeight = 0, 5*time.Minute, 5*time.Minute, 0.1
+		timeNow = time.Now
+		resetFlapping()
+		SetTable(make(Table))
+	}()
+
+	both := "route add svc /foo http://a/\nroute add svc /foo http://b/"
+	onlyA := "route add svc /foo http://a/"
+	setTable := func(s string) {
+		tbl, err := NewTable(bytes.NewBufferString(s))
+		if err != nil {
+			t.Fatal(err)
+		}
+		SetTable(tbl)
+	}
+	weights := func() (a, b float64) {
+		for _, tg := range GetTable()[""][0].Targets {
+			switch tg.URL.Host {
+			case "a":
+				a = tg.Weight
+			case "b":
+				b = tg.Weight
+			}
+		}
+		return a, b
+	}
+
+	// b appears, disappears and appears again
+	setTable(both)
+	setTable(onlyA)
+	now = now.Add(10 * time.Second)
+	setTable(both)
+
+	tg := GetTable()[""][0].Targets[1]
+	if !tg.Flapping() {
+		t.Fatal("target not flapping")
+	}
+	if a, b := weights(); a != 1 || b != 0 {
+		t.Fatalf("got weights %v, %v want 1, 0", a, b)
+	}
+
+	// the weights of the published table do not change
+	mu.Lock()
+	now = now.Add(time.Minute)
+	if !updateFlapping(now) {
+		mu.Unlock()
+		t.Fatal("target still flapping")
+	}
+	old := GetTable()
+	table.Store(old.reweigh())
+	mu.Unlock()
+
+	if a, b := weights(); a != 0.5 || b != 0.5 {
+		t.Fatalf("got weights %v, %v want 0.5, 0.5", a, b)
+	}
+	if w := old[""][0].Targets[1].Weight; w != 0 {
+		t.Fatalf("weight of published table changed to %v", w)
+	}
+}
+
+func TestDecayFlapping(t *testing.T) {
+	FlapThreshold, FlapWeight = 1, 0.5
+	flapping.Store(map[string]bool{"svc http://b/": true, "svc http://c/": true})
+	defer func() {
+		FlapThreshold, FlapWeight = 0, 0.1
+		flapping.Store(map[string]bool{})
+	}()
+
+	tbl, err := NewTable(bytes.NewBufferString("route add svc / http://a/\nroute add svc / http://b/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a, b := tbl[""][0].Targets[0].Weight, tbl[""][0].Targets[1].Weight; a != 2.0/3 || b != 1.0/3 {
+		t.Fatalf("got weights %v, %v want 2/3, 1/3", a, b)
+	}
+
+	// all targets flapping
+	FlapWeight = 0
+	tbl, err = NewTable(bytes.NewBufferString("route add svc / http://b/\nroute add svc / http://c/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, c := tbl[""][0].Targets[0].Weight, tbl[""][0].Targets[1].Weight; b != 0.5 || c != 0.5 {
+		t.Fatalf("got weights %v, %v want 0.5, 0.5", b, c)
+	}
+}
+
+// resetFlapping stops the flap timer and forgets all changes.
+func resetFlapping() {
+	mu.Lock()
+	defer mu.Unlock()
+	if flapTimer != nil {
+		flapTimer.Stop()
+		flapTimer = nil
+	}
+	flapStates = map[string]*flapState{}
+	flapping.Store(map[string]bool{})
+}
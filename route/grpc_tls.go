@@ -0,0 +1,90 @@
+package route
+
+import (
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// GRPCTLS configures the TLS connections to a gRPC target
+// with the grpcs protocol.
+type GRPCTLS struct {
+	// ServerName overrides the host name which is used for
+	// SNI and for verifying the certificate of the target.
+	ServerName string
+
+	// RootCAs contains the certificates which verify the
+	// certificate of the target. If nil the system roots
+	// are used.
+	RootCAs *x509.CertPool
+
+	// RequireALPN rejects connections to targets which do not
+	// negotiate HTTP/2 with ALPN during the handshake.
+	RequireALPN bool
+}
+
+// rootCAFile is a root CA file which has been loaded.
+type rootCAFile struct {
+	modTime time.Time
+	pool    *x509.CertPool
+}
+
+// rootCACache contains the loaded root CA files so that they are
+// only read again after they have changed.
+var rootCACache = struct {
+	sync.Mutex
+	m map[string]rootCAFile
+}{m: map[string]rootCAFile{}}
+
+// loadRootCAs returns the certificate pool of a PEM encoded file.
+func loadRootCAs(path string) (*x509.CertPool, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	rootCACache.Lock()
+	defer rootCACache.Unlock()
+	if f, ok := rootCACache.m[path]; ok && f.modTime.Equal(fi.ModTime()) {
+		return f.pool, nil
+	}
+	pem, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates in %s", path)
+	}
+	rootCACache.m[path] = rootCAFile{fi.ModTime(), pool}
+	return pool, nil
+}
+
+// parseGRPCTLS parses the grpcservername, grpcrootca and grpcalpn
+// options. It returns nil if none of the options is set.
+func parseGRPCTLS(opts map[string]string) (*GRPCTLS, error) {
+	name, ca, alpn := opts["grpcservername"], opts["grpcrootca"], opts["grpcalpn"]
+	if name == "" && ca == "" && alpn == "" {
+		return nil, nil
+	}
+
+	g := &GRPCTLS{ServerName: name}
+	if ca != "" {
+		pool, err := loadRootCAs(ca)
+		if err != nil {
+			return nil, fmt.Errorf("grpcrootca cannot be loaded. %s", err)
+		}
+		g.RootCAs = pool
+	}
+	switch alpn {
+	case "", "optional":
+	case "required":
+		g.RequireALPN = true
+	default:
+		return nil, fmt.Errorf("grpcalpn should be required or optional. Got: %s", alpn)
+	}
+	return g, nil
+}
@@ -0,0 +1,39 @@
+package route
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestParseGRPCTLS(t *testing.T) {
+	f, err := ioutil.TempFile("", "fabio")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("no certificate")
+	f.Close()
+
+	for _, opts := range []map[string]string{
+		{"grpcrootca": "/does/not/exist.pem"},
+		{"grpcrootca": f.Name()},
+		{"grpcalpn": "h2"},
+	} {
+		if _, err := parseGRPCTLS(opts); err == nil {
+			t.Errorf("%v: got nil want error", opts)
+		}
+	}
+
+	if g, err := parseGRPCTLS(map[string]string{"proto": "grpcs"}); g != nil || err != nil {
+		t.Fatalf("got %v, %v want nil, nil", g, err)
+	}
+
+	g, err := parseGRPCTLS(map[string]string{"grpcservername": "example.com", "grpcalpn": "required"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.ServerName != "example.com" || !g.RequireALPN || g.RootCAs != nil {
+		t.Fatalf("got %#v", g)
+	}
+}
@@ -0,0 +1,161 @@
+package route
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// HashKey returns the key of a request for the hash picker. Requests
+// with the same key are routed to the same target as long as the set
+// of targets does not change. It is set on startup.
+var HashKey = ClientIPHashKey
+
+// HashVirtualNodes is the number of points on the hash ring of a
+// target with the average weight. More points spread the keys more
+// evenly at the cost of memory. It is set on startup.
+var HashVirtualNodes = 100
+
+// ClientIPHashKey returns the IP address of the client.
+func ClientIPHashKey(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// ParseHashKey parses the key of the hash picker. Valid keys are 'ip'
+// for the client IP, 'header:<name>' for the value of a request header
+// and 'cookie:<name>' for the value of a cookie. Requests without the
+// header or cookie fall back to the client IP.
+func ParseHashKey(s string) (func(r *http.Request) string, error) {
+	typ, name := s, ""
+	if i := strings.Index(s, ":"); i >= 0 {
+		typ, name = s[:i], s[i+1:]
+	}
+	switch {
+	case typ == "ip" && name == "":
+		return ClientIPHashKey, nil
+	case typ == "header" && name != "":
+		name = http.CanonicalHeaderKey(name)
+		return func(r *http.Request) string {
+			if v := r.Header.Get(name); v != "" {
+				return v
+			}
+			return ClientIPHashKey(r)
+		}, nil
+	case typ == "cookie" && name != "":
+		return func(r *http.Request) string {
+			if c, err := r.Cookie(name); err == nil && c.Value != "" {
+				return c.Value
+			}
+			return ClientIPHashKey(r)
+		}, nil
+	default:
+		return nil, fmt.Errorf("invalid hash key %q", s)
+	}
+}
+
+// hashPicker picks the target for the key of the request with
+// consistent hashing. Each target has a number of points on a ring
+// which is proportional to its weight and the request is routed to
+// the target of the next point after the hash of its key. Adding or
+// removing a target or changing its weight moves only the keys next to
+// the points of that target. Requests without a key are routed with
+// the round-robin picker.
+func hashPicker(r *Route, req *http.Request) *Target {
+	if req == nil {
+		return rrPicker(r, req)
+	}
+	key := HashKey(req)
+	if key == "" {
+		return rrPicker(r, req)
+	}
+	return r.hashRing().lookup(hash64(key))
+}
+
+// hashRing returns the hash ring of the route which is
+// created when the hash picker is used for the first time.
+func (r *Route) hashRing() *hashRing {
+	r.ringOnce.Do(func() {
+		r.ring = newHashRing(r.wTargets, HashVirtualNodes)
+	})
+	return r.ring
+}
+
+// hashRing maps the hash of a key to a target.
+type hashRing struct {
+	// points contains the sorted points of the targets.
+	points []uint64
+
+	// targets contains the target for each point.
+	targets []*Target
+}
+
+// newHashRing creates a hash ring for the targets. The position of the
+// points of a target depends only on its URL so that the ring changes
+// as little as possible when targets are added or removed.
+func newHashRing(targets []*Target, vnodes int) *hashRing {
+	var sum float64
+	for _, t := range targets {
+		sum += t.Weight
+	}
+	type point struct {
+		p uint64
+		t *Target
+	}
+	var points []point
+	for _, t := range targets {
+		n := 1
+		if sum > 0 {
+			n = int(math.Round(float64(vnodes*len(targets)) * t.Weight / sum))
+		}
+		if n < 1 {
+			n = 1
+		}
+		u := t.URL.String()
+		for i := 0; i < n; i++ {
+			points = append(points, point{hash64(u + "#" + strconv.Itoa(i)), t})
+		}
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i].p < points[j].p })
+
+	ring := &hashRing{
+		points:  make([]uint64, len(points)),
+		targets: make([]*Target, len(points)),
+	}
+	for i, p := range points {
+		ring.points[i], ring.targets[i] = p.p, p.t
+	}
+	return ring
+}
+
+// lookup returns the target of the first point after h.
+func (ring *hashRing) lookup(h uint64) *Target {
+	if len(ring.points) == 0 {
+		return nil
+	}
+	i := sort.Search(len(ring.points), func(i int) bool { return ring.points[i] >= h })
+	if i == len(ring.points) {
+		i = 0
+	}
+	return ring.targets[i]
+}
+
+// hash64 returns the FNV-1a hash of s. The result is mixed with the
+// finalizer of splitmix64 since FNV alone spreads similar short
+// strings poorly.
+func hash64(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	x := h.Sum64()
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
@@ -0,0 +1,111 @@
+package route
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"testing"
+)
+
+func TestParseHashKey(t *testing.T) {
+	for _, s := range []string{"", "ip:x", "header:", "cookie:", "query:a"} {
+		if _, err := ParseHashKey(s); err == nil {
+			t.Errorf("%q: got nil want error", s)
+		}
+	}
+
+	req := &http.Request{RemoteAddr: "1.2.3.4:5678", Header: http.Header{}}
+	req.Header.Set("X-User", "alice")
+	req.AddCookie(&http.Cookie{Name: "session", Value: "s1"})
+	noKey := &http.Request{RemoteAddr: "1.2.3.4:5678", Header: http.Header{}}
+
+	tests := []struct {
+		key       string
+		got, none string
+	}{
+		{"ip", "1.2.3.4", "1.2.3.4"},
+		{"header:x-user", "alice", "1.2.3.4"},
+		{"cookie:session", "s1", "1.2.3.4"},
+	}
+	for _, tt := range tests {
+		f, err := ParseHashKey(tt.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := f(req), tt.got; got != want {
+			t.Errorf("%s: got %q want %q", tt.key, got, want)
+		}
+		if got, want := f(noKey), tt.none; got != want {
+			t.Errorf("%s: got %q want %q without key", tt.key, got, want)
+		}
+	}
+}
+
+func TestHashPicker(t *testing.T) {
+	newRoute := func(n int) *Route {
+		r := &Route{Host: "www.bar.com", Path: "/foo"}
+		for i := 0; i < n; i++ {
+			r.addTarget("svc", mustParse(fmt.Sprintf("http://10.0.0.%d:80/", i)), 0, nil, nil)
+		}
+		return r
+	}
+	req := func(ip int) *http.Request {
+		return &http.Request{RemoteAddr: fmt.Sprintf("192.168.%d.%d:1234", ip/256, ip%256), Header: http.Header{}}
+	}
+
+	// the same key is always routed to the same target
+	r := newRoute(5)
+	for i := 0; i < 100; i++ {
+		if got, want := hashPicker(r, req(i)), hashPicker(r, req(i)); got != want {
+			t.Fatalf("%d: got %v want %v", i, got.URL, want.URL)
+		}
+	}
+
+	// the keys are spread evenly over the targets
+	const keys = 10000
+	picks := map[string]int{}
+	for i := 0; i < keys; i++ {
+		picks[hashPicker(r, req(i)).URL.Host]++
+	}
+	for host, n := range picks {
+		if share := float64(n) / keys; math.Abs(share-0.2) > 0.06 {
+			t.Errorf("%s: got share %.3f want 0.2", host, share)
+		}
+	}
+
+	// adding a target moves only the keys of the new target
+	r6 := newRoute(6)
+	moved := 0
+	for i := 0; i < keys; i++ {
+		before, after := hashPicker(r, req(i)).URL.Host, hashPicker(r6, req(i)).URL.Host
+		if before != after {
+			if after != "10.0.0.5:80" {
+				t.Fatalf("%d: key moved from %s to %s", i, before, after)
+			}
+			moved++
+		}
+	}
+	if share := float64(moved) / keys; math.Abs(share-1.0/6) > 0.06 {
+		t.Errorf("got %.3f of the keys moved want 1/6", share)
+	}
+
+	// connections without a request use round-robin
+	if got := hashPicker(r, nil); got == nil {
+		t.Fatal("got nil want target")
+	}
+}
+
+func TestHashPickerWeighted(t *testing.T) {
+	r := &Route{Host: "www.bar.com", Path: "/foo"}
+	r.addTarget("svc", fooDotCom, 0.2, nil, nil)
+	r.addTarget("svc", barDotCom, 0.8, nil, nil)
+
+	const keys = 10000
+	picks := map[*Target]int{}
+	for i := 0; i < keys; i++ {
+		picks[hashPicker(r, &http.Request{RemoteAddr: fmt.Sprintf("10.%d.%d.1:80", i/256, i%256)})]++
+	}
+	if share := float64(picks[r.Targets[1]]) / keys; math.Abs(share-0.8) > 0.06 {
+		t.Errorf("got share %.3f want 0.8", share)
+	}
+}
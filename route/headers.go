@@ -0,0 +1,89 @@
+package route
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+// HeaderOps describes how the headers of a request to a target
+// or of a response from a target are modified.
+type HeaderOps struct {
+	// Del contains the names of the headers which are removed.
+	Del []string
+
+	// Set contains the headers which replace existing values.
+	Set http.Header
+
+	// Add contains the headers which are added to existing values.
+	Add http.Header
+}
+
+// parseHeaderOps parses the <prefix>-add, <prefix>-set and <prefix>-del
+// options. The add and set options contain a comma separated list of
+// 'Name:Value' pairs and the del option a comma separated list of
+// header names. It returns nil if none of the options is set.
+func parseHeaderOps(opts map[string]string, prefix string) (*HeaderOps, error) {
+	add, set, del := opts[prefix+"-add"], opts[prefix+"-set"], opts[prefix+"-del"]
+	if add == "" && set == "" && del == "" {
+		return nil, nil
+	}
+
+	ops := &HeaderOps{}
+	for _, name := range strings.Split(del, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		if !httpguts.ValidHeaderFieldName(name) {
+			return nil, fmt.Errorf("%s-del should be a list of header names. Got: %s", prefix, del)
+		}
+		ops.Del = append(ops.Del, http.CanonicalHeaderKey(name))
+	}
+
+	var err error
+	if ops.Set, err = parseHeaderList(set, prefix+"-set"); err != nil {
+		return nil, err
+	}
+	if ops.Add, err = parseHeaderList(add, prefix+"-add"); err != nil {
+		return nil, err
+	}
+	return ops, nil
+}
+
+// parseHeaderList parses a comma separated list of 'Name:Value' pairs.
+func parseHeaderList(s, opt string) (http.Header, error) {
+	if s == "" {
+		return nil, nil
+	}
+	h := http.Header{}
+	for _, kv := range strings.Split(s, ",") {
+		if kv = strings.TrimSpace(kv); kv == "" {
+			continue
+		}
+		p := strings.SplitN(kv, ":", 2)
+		if len(p) != 2 || !httpguts.ValidHeaderFieldName(p[0]) || !httpguts.ValidHeaderFieldValue(p[1]) {
+			return nil, fmt.Errorf("%s should be a list of Name:Value pairs. Got: %s", opt, s)
+		}
+		h.Add(p[0], p[1])
+	}
+	return h, nil
+}
+
+// Apply modifies the headers. Headers are removed first,
+// then replaced and then added.
+func (o *HeaderOps) Apply(h http.Header) {
+	if o == nil {
+		return
+	}
+	for _, name := range o.Del {
+		h.Del(name)
+	}
+	for name, v := range o.Set {
+		h[name] = append([]string(nil), v...)
+	}
+	for name, v := range o.Add {
+		h[name] = append(h[name], v...)
+	}
+}
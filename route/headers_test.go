@@ -0,0 +1,73 @@
+package route
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestParseHeaderOps(t *testing.T) {
+	for _, opts := range []map[string]string{
+		{"reqhdr-add": "X-Env"},
+		{"reqhdr-set": "X Env:prod"},
+		{"reqhdr-del": "X-Env:prod"},
+		{"reqhdr-add": ":prod"},
+	} {
+		if _, err := parseHeaderOps(opts, "reqhdr"); err == nil {
+			t.Errorf("%v: got nil want error", opts)
+		}
+	}
+
+	if ops, err := parseHeaderOps(map[string]string{"resphdr-del": "Server"}, "reqhdr"); ops != nil || err != nil {
+		t.Fatalf("got %v, %v want nil, nil", ops, err)
+	}
+
+	ops, err := parseHeaderOps(map[string]string{
+		"reqhdr-add": "x-env:prod,X-Env:eu",
+		"reqhdr-set": "X-Tenant:42,X-Auth:",
+		"reqhdr-del": "server, x-powered-by",
+	}, "reqhdr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &HeaderOps{
+		Del: []string{"Server", "X-Powered-By"},
+		Set: http.Header{"X-Tenant": {"42"}, "X-Auth": {""}},
+		Add: http.Header{"X-Env": {"prod", "eu"}},
+	}
+	if !reflect.DeepEqual(ops, want) {
+		t.Fatalf("got %#v want %#v", ops, want)
+	}
+}
+
+func TestHeaderOpsApply(t *testing.T) {
+	ops := &HeaderOps{
+		Del: []string{"Server"},
+		Set: http.Header{"X-Tenant": {"42"}},
+		Add: http.Header{"X-Env": {"prod"}},
+	}
+	h := http.Header{
+		"Server":   {"nginx"},
+		"X-Tenant": {"1", "2"},
+		"X-Env":    {"dev"},
+		"X-Other":  {"a"},
+	}
+	ops.Apply(h)
+	want := http.Header{
+		"X-Tenant": {"42"},
+		"X-Env":    {"dev", "prod"},
+		"X-Other":  {"a"},
+	}
+	if !reflect.DeepEqual(h, want) {
+		t.Fatalf("got %v want %v", h, want)
+	}
+
+	// the values of the options are not shared with the headers
+	h["X-Tenant"][0] = "1"
+	if got := ops.Set.Get("X-Tenant"); got != "42" {
+		t.Fatalf("got %q want 42", got)
+	}
+
+	var nilOps *HeaderOps
+	nilOps.Apply(h)
+}
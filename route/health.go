@@ -0,0 +1,184 @@
+package route
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/fabiolb/fabio/metrics"
+)
+
+// HealthCheck configures the active health check of a target. Targets
+// which fail the check are removed from the selection until they pass
+// again independently of the checks of the registry.
+type HealthCheck struct {
+	// Type is the protocol of the check. 'grpc' uses the standard
+	// grpc.health.v1 protocol, 'http' sends a GET request and 'tcp'
+	// opens a connection.
+	Type string
+
+	// Path is the path and optional query of the request of
+	// an http check.
+	Path string
+
+	// Service is the name of the service in the gRPC health check
+	// request. An empty name checks the health of the server.
+	Service string
+
+	// Interval is the time between two checks and Timeout the
+	// maximum duration of a check.
+	Interval, Timeout time.Duration
+
+	// Healthy is the number of consecutive successful checks after
+	// which an unhealthy target is healthy again and Unhealthy the
+	// number of consecutive failed checks after which a healthy
+	// target becomes unhealthy.
+	Healthy, Unhealthy int
+}
+
+// Defaults of the health check options.
+const (
+	defaultHealthInterval  = 10 * time.Second
+	defaultHealthTimeout   = 2 * time.Second
+	defaultHealthHealthy   = 2
+	defaultHealthUnhealthy = 3
+)
+
+// String returns the configuration of the check which
+// distinguishes the checks of the same service instance.
+func (c *HealthCheck) String() string {
+	return fmt.Sprintf("%s %q %q %s %s %d %d", c.Type, c.Path, c.Service, c.Interval, c.Timeout, c.Healthy, c.Unhealthy)
+}
+
+// parseHealthCheck parses the healthcheck, healthcheck-path,
+// healthcheck-service, healthcheck-interval, healthcheck-timeout, healthcheck-healthy and
+// healthcheck-unhealthy options. It returns nil if the healthcheck
+// option is not set.
+func parseHealthCheck(opts map[string]string) (*HealthCheck, error) {
+	v := opts["healthcheck"]
+	if v == "" {
+		return nil, nil
+	}
+
+	c := &HealthCheck{
+		Type:      v,
+		Service:   opts["healthcheck-service"],
+		Interval:  defaultHealthInterval,
+		Timeout:   defaultHealthTimeout,
+		Healthy:   defaultHealthHealthy,
+		Unhealthy: defaultHealthUnhealthy,
+	}
+	switch c.Type {
+	case "grpc", "tcp":
+	case "http":
+		c.Path = "/"
+		if p := opts["healthcheck-path"]; p != "" {
+			if !strings.HasPrefix(p, "/") {
+				return nil, fmt.Errorf("healthcheck-path should start with '/'. Got: %s", p)
+			}
+			c.Path = p
+		}
+	default:
+		return nil, fmt.Errorf("healthcheck should be grpc, http or tcp. Got: %s", v)
+	}
+
+	for _, d := range []struct {
+		name string
+		p    *time.Duration
+	}{
+		{"healthcheck-interval", &c.Interval},
+		{"healthcheck-timeout", &c.Timeout},
+	} {
+		v := opts[d.name]
+		if v == "" {
+			continue
+		}
+		n, err := time.ParseDuration(v)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("%s should be a positive duration. Got: %s", d.name, v)
+		}
+		*d.p = n
+	}
+
+	for _, d := range []struct {
+		name string
+		p    *int
+	}{
+		{"healthcheck-healthy", &c.Healthy},
+		{"healthcheck-unhealthy", &c.Unhealthy},
+	} {
+		v := opts[d.name]
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("%s should be a positive number. Got: %s", d.name, v)
+		}
+		*d.p = n
+	}
+	return c, nil
+}
+
+// unhealthy contains the health keys of the targets which have failed
+// their health check as a map[string]bool. It is replaced when the set
+// changes and read without a lock.
+var unhealthy atomic.Value
+
+func init() {
+	unhealthy.Store(map[string]bool{})
+}
+
+// HealthKey identifies the health check of a target. Targets of the same
+// service instance with the same check share the state of the check.
+// It returns an empty string if the target has no health check.
+func (t *Target) HealthKey() string {
+	if t.HealthCheck == nil || t.URL == nil {
+		return ""
+	}
+	return flapKey(t) + " " + t.HealthCheck.String()
+}
+
+// Unhealthy returns true if the target has failed its health check.
+func (t *Target) Unhealthy() bool {
+	k := t.HealthKey()
+	return k != "" && unhealthy.Load().(map[string]bool)[k]
+}
+
+// SetHealthy records the result of the health check with the given
+// key and reweighs the routing table if the state has changed.
+func SetHealthy(key string, healthy bool) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	old := unhealthy.Load().(map[string]bool)
+	if old[key] == !healthy {
+		return
+	}
+	cur := make(map[string]bool, len(old)+1)
+	for k := range old {
+		if k != key {
+			cur[k] = true
+		}
+	}
+	if !healthy {
+		cur[key] = true
+	}
+	unhealthy.Store(cur)
+	metrics.DefaultRegistry.GetGauge("health.unhealthy").Update(int64(len(cur)))
+	table.Store(GetTable().reweigh())
+}
+
+// healthyTargets returns the targets which have not failed their
+// health check.
+func healthyTargets(targets []*Target) []*Target {
+	var healthy []*Target
+	for _, t := range targets {
+		if !t.Unhealthy() {
+			healthy = append(healthy, t)
+		}
+	}
+	return healthy
+}
@@ -0,0 +1,155 @@
+package route
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseHealthCheck(t *testing.T) {
+	tests := []struct {
+		desc string
+		opts map[string]string
+		hc   *HealthCheck
+		err  bool
+	}{
+		{
+			desc: "no check",
+			opts: map[string]string{"healthcheck-service": "foo"},
+		},
+		{
+			desc: "defaults",
+			opts: map[string]string{"healthcheck": "grpc"},
+			hc:   &HealthCheck{Type: "grpc", Interval: 10 * time.Second, Timeout: 2 * time.Second, Healthy: 2, Unhealthy: 3},
+		},
+		{
+			desc: "all options",
+			opts: map[string]string{
+				"healthcheck":           "grpc",
+				"healthcheck-service":   "foo.Bar",
+				"healthcheck-interval":  "5s",
+				"healthcheck-timeout":   "500ms",
+				"healthcheck-healthy":   "1",
+				"healthcheck-unhealthy": "5",
+			},
+			hc: &HealthCheck{Type: "grpc", Service: "foo.Bar", Interval: 5 * time.Second, Timeout: 500 * time.Millisecond, Healthy: 1, Unhealthy: 5},
+		},
+		{
+			desc: "http defaults",
+			opts: map[string]string{"healthcheck": "http"},
+			hc:   &HealthCheck{Type: "http", Path: "/", Interval: 10 * time.Second, Timeout: 2 * time.Second, Healthy: 2, Unhealthy: 3},
+		},
+		{
+			desc: "http path",
+			opts: map[string]string{"healthcheck": "http", "healthcheck-path": "/health?full=1"},
+			hc:   &HealthCheck{Type: "http", Path: "/health?full=1", Interval: 10 * time.Second, Timeout: 2 * time.Second, Healthy: 2, Unhealthy: 3},
+		},
+		{
+			desc: "tcp",
+			opts: map[string]string{"healthcheck": "tcp", "healthcheck-interval": "1s"},
+			hc:   &HealthCheck{Type: "tcp", Interval: time.Second, Timeout: 2 * time.Second, Healthy: 2, Unhealthy: 3},
+		},
+		{
+			desc: "invalid http path",
+			opts: map[string]string{"healthcheck": "http", "healthcheck-path": "health"},
+			err:  true,
+		},
+		{
+			desc: "invalid type",
+			opts: map[string]string{"healthcheck": "smtp"},
+			err:  true,
+		},
+		{
+			desc: "invalid interval",
+			opts: map[string]string{"healthcheck": "grpc", "healthcheck-interval": "0s"},
+			err:  true,
+		},
+		{
+			desc: "invalid timeout",
+			opts: map[string]string{"healthcheck": "grpc", "healthcheck-timeout": "soon"},
+			err:  true,
+		},
+		{
+			desc: "invalid threshold",
+			opts: map[string]string{"healthcheck": "grpc", "healthcheck-unhealthy": "0"},
+			err:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			hc, err := parseHealthCheck(tt.opts)
+			if got, want := err != nil, tt.err; got != want {
+				t.Fatalf("got error %v want %v", err, want)
+			}
+			if got, want := hc, tt.hc; !reflect.DeepEqual(got, want) {
+				t.Fatalf("got %+v want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestUnhealthyTargets(t *testing.T) {
+	defer func() {
+		unhealthy.Store(map[string]bool{})
+		SetTable(make(Table))
+	}()
+
+	const cfg = `route add svc / grpc://a/ opts "healthcheck=grpc"
+route add svc / grpc://b/ opts "healthcheck=grpc"
+route add svc / grpc://c/ opts "backup=true healthcheck=grpc"`
+	tbl, err := NewTable(bytes.NewBufferString(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetTable(tbl)
+
+	target := func(host string) *Target {
+		for _, tg := range GetTable()[""][0].Targets {
+			if tg.URL.Host == host {
+				return tg
+			}
+		}
+		t.Fatalf("target %s not found", host)
+		return nil
+	}
+	weights := func() []float64 {
+		return []float64{target("a").Weight, target("b").Weight, target("c").Weight}
+	}
+	a, b, c := target("a").HealthKey(), target("b").HealthKey(), target("c").HealthKey()
+
+	SetHealthy(a, false)
+	if !target("a").Unhealthy() {
+		t.Fatal("a is not unhealthy")
+	}
+	if got, want := weights(), []float64{0, 1, 0}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got weights %v want %v", got, want)
+	}
+	if got := GetTable().String(); got != cfg {
+		t.Fatalf("got config %q want %q", got, cfg)
+	}
+
+	// the backup takes over if all primary targets are unhealthy
+	SetHealthy(b, false)
+	if got, want := weights(), []float64{0, 0, 1}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got weights %v want %v", got, want)
+	}
+
+	// the primary targets receive the traffic if all targets are unhealthy
+	SetHealthy(c, false)
+	if got, want := weights(), []float64{0.5, 0.5, 0}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got weights %v want %v", got, want)
+	}
+
+	// a new table keeps the state
+	SetHealthy(b, true)
+	tbl, err = NewTable(bytes.NewBufferString(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetTable(tbl)
+	if got, want := weights(), []float64{0, 1, 0}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got weights %v want %v", got, want)
+	}
+}
@@ -0,0 +1,133 @@
+package route
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// LocationRewrite describes how the Location header of redirects from
+// a target is rewritten so that clients are not redirected to the
+// internal address of the target.
+type LocationRewrite struct {
+	// Hosts are the internal host names which are rewritten in
+	// addition to the host of the target and the Host header sent to
+	// the target. A host without a port matches all ports.
+	Hosts []string
+}
+
+// parseLocationRewrite parses the rewritelocation option. It returns
+// nil if the option is not set or false.
+func parseLocationRewrite(opts map[string]string) (*LocationRewrite, error) {
+	switch v := opts["rewritelocation"]; v {
+	case "", "false":
+		return nil, nil
+	case "true":
+		return &LocationRewrite{}, nil
+	default:
+		lr := &LocationRewrite{}
+		for _, h := range strings.Split(v, ",") {
+			h = strings.ToLower(strings.TrimSpace(h))
+			if h == "" || strings.ContainsAny(h, "/?#@") {
+				return nil, fmt.Errorf("rewritelocation should be true or a list of hosts. Got: %s", v)
+			}
+			lr.Hosts = append(lr.Hosts, h)
+		}
+		return lr, nil
+	}
+}
+
+// RewriteLocation returns the value of the Location header of a
+// redirect from the target for the client request with the URL
+// requestURL. URLs which point to an internal host of the target are
+// changed to the scheme and host of the client request. The path of
+// these URLs and of absolute paths is mapped back with the strip and
+// prepend options. All other locations are returned unchanged.
+func (t *Target) RewriteLocation(loc string, requestURL *url.URL) string {
+	if t.LocationRewrite == nil || loc == "" {
+		return loc
+	}
+	u, err := url.Parse(loc)
+	if err != nil || u.Opaque != "" {
+		return loc
+	}
+
+	switch {
+	case u.Host != "":
+		if !t.internalHost(u) {
+			return loc
+		}
+		u.Scheme, u.Host = requestURL.Scheme, requestURL.Host
+	case u.Scheme != "" || !strings.HasPrefix(u.Path, "/"):
+		return loc
+	}
+
+	if p, ok := t.publicPath(u.EscapedPath()); ok {
+		u.Path, err = url.PathUnescape(p)
+		if err != nil {
+			return loc
+		}
+		u.RawPath = p
+	}
+	return u.String()
+}
+
+// internalHost returns true if the URL points to the target, the Host
+// header sent to the target or one of the hosts of the rewritelocation
+// option.
+func (t *Target) internalHost(u *url.URL) bool {
+	host := hostPort(u.Scheme, u.Host)
+	if t.URL != nil && host == hostPort(t.URL.Scheme, t.URL.Host) {
+		return true
+	}
+	if t.Host != "" && t.Host != "dst" && host == hostPort(u.Scheme, t.Host) {
+		return true
+	}
+	name, _, _ := net.SplitHostPort(host)
+	for _, h := range t.LocationRewrite.Hosts {
+		if h == host || h == name {
+			return true
+		}
+	}
+	return false
+}
+
+// publicPath maps the path of the target back to the path of the
+// client request by removing the prepend and adding the strip
+// option. It returns false if the path is not below the prepend path.
+func (t *Target) publicPath(p string) (string, bool) {
+	if t.StripPath == "" && t.PrependPath == "" {
+		return p, false
+	}
+	if t.PrependPath != "" {
+		prefix := strings.TrimSuffix(t.PrependPath, "/")
+		if p != prefix && !strings.HasPrefix(p, prefix+"/") {
+			return p, false
+		}
+		p = p[len(prefix):]
+	}
+	if t.StripPath != "" {
+		p = strings.TrimSuffix(t.StripPath, "/") + p
+	}
+	if !strings.HasPrefix(p, "/") {
+		p = "/" + p
+	}
+	return p, true
+}
+
+// hostPort returns the lower case host of a URL
+// with the default port of the scheme if it has none.
+func hostPort(scheme, host string) string {
+	host = strings.ToLower(host)
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	switch scheme {
+	case "https", "wss":
+		return net.JoinHostPort(host, "443")
+	default:
+		return net.JoinHostPort(host, "80")
+	}
+}
@@ -0,0 +1,62 @@
+package route
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestParseLocationRewrite(t *testing.T) {
+	for _, v := range []string{"app,", ",", "app/path", "user@app"} {
+		if _, err := parseLocationRewrite(map[string]string{"rewritelocation": v}); err == nil {
+			t.Errorf("%q: got nil want error", v)
+		}
+	}
+	for _, v := range []string{"", "false"} {
+		if lr, err := parseLocationRewrite(map[string]string{"rewritelocation": v}); lr != nil || err != nil {
+			t.Errorf("%q: got %v, %v want nil, nil", v, lr, err)
+		}
+	}
+	lr, err := parseLocationRewrite(map[string]string{"rewritelocation": "App.internal, 10.0.0.1:8080"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(lr.Hosts), 2; got != want {
+		t.Fatalf("got %d hosts want %d", got, want)
+	}
+	if lr.Hosts[0] != "app.internal" || lr.Hosts[1] != "10.0.0.1:8080" {
+		t.Fatalf("got hosts %v", lr.Hosts)
+	}
+}
+
+func TestRewriteLocation(t *testing.T) {
+	requestURL := &url.URL{Scheme: "https", Host: "example.com", Path: "/"}
+	tests := []struct {
+		desc, opts, loc, want string
+	}{
+		{"disabled", "", "http://10.0.0.1:8080/login", "http://10.0.0.1:8080/login"},
+		{"target host", "rewritelocation=true", "http://10.0.0.1:8080/login?a=1", "https://example.com/login?a=1"},
+		{"default port", "rewritelocation=true host=app.internal:80", "http://app.internal/login", "https://example.com/login"},
+		{"other port", "rewritelocation=true", "http://10.0.0.1:9090/login", "http://10.0.0.1:9090/login"},
+		{"external host", "rewritelocation=true", "https://idp.example.org/auth", "https://idp.example.org/auth"},
+		{"host option", "rewritelocation=true host=app.internal", "http://app.internal/login", "https://example.com/login"},
+		{"listed host", "rewritelocation=app.internal", "http://APP.internal:3000/login", "https://example.com/login"},
+		{"listed host and port", "rewritelocation=app.internal:3000", "http://app.internal:4000/login", "http://app.internal:4000/login"},
+		{"relative path", "rewritelocation=true", "/login", "/login"},
+		{"relative path strip", "rewritelocation=true strip=/app", "/login", "/app/login"},
+		{"relative path prepend", "rewritelocation=true prepend=/v1", "/v1/login", "/login"},
+		{"outside prepend", "rewritelocation=true prepend=/v1", "/v2/login", "/v2/login"},
+		{"strip and prepend", "rewritelocation=true strip=/app prepend=/v1", "http://10.0.0.1:8080/v1/a%2Fb", "https://example.com/app/a%2Fb"},
+		{"prepend root", "rewritelocation=true prepend=/v1", "http://10.0.0.1:8080/v1", "https://example.com/"},
+		{"relative reference", "rewritelocation=true strip=/app", "login", "login"},
+		{"opaque", "rewritelocation=true", "mailto:ops@example.com", "mailto:ops@example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			r := &Route{}
+			r.addTarget("svc", mustParse("http://10.0.0.1:8080/"), 0, nil, parseOpts(tt.opts))
+			if got := r.Targets[0].RewriteLocation(tt.loc, requestURL); got != tt.want {
+				t.Fatalf("got %q want %q", got, tt.want)
+			}
+		})
+	}
+}
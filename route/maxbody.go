@@ -0,0 +1,54 @@
+package route
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// sizeUnits are the suffixes of the sizes in the route options.
+var sizeUnits = []struct {
+	suffix string
+	n      int64
+}{
+	{"KB", 1 << 10},
+	{"MB", 1 << 20},
+	{"GB", 1 << 30},
+	{"K", 1 << 10},
+	{"M", 1 << 20},
+	{"G", 1 << 30},
+	{"B", 1},
+}
+
+// parseSize parses a size in bytes with an optional
+// KB, MB or GB suffix, e.g. '512', '64KB' or '10MB'.
+// The suffixes are case insensitive and are powers of 1024.
+func parseSize(s string) (int64, error) {
+	v, mult := strings.ToUpper(strings.TrimSpace(s)), int64(1)
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(v, u.suffix) {
+			v, mult = strings.TrimSpace(strings.TrimSuffix(v, u.suffix)), u.n
+			break
+		}
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 || n > (1<<62)/mult {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * mult, nil
+}
+
+// parseMaxBody parses the maxbody option which limits the size of
+// the request bodies to a target, e.g. 'maxbody=10MB'. It returns
+// zero if the option is not set.
+func parseMaxBody(opts map[string]string) (int64, error) {
+	v := opts["maxbody"]
+	if v == "" {
+		return 0, nil
+	}
+	n, err := parseSize(v)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("maxbody should be a positive size like 512KB or 10MB. Got: %s", v)
+	}
+	return n, nil
+}
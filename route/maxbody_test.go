@@ -0,0 +1,50 @@
+package route
+
+import "testing"
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in  string
+		n   int64
+		err bool
+	}{
+		{in: "0", n: 0},
+		{in: "512", n: 512},
+		{in: "512B", n: 512},
+		{in: "64KB", n: 64 << 10},
+		{in: "64k", n: 64 << 10},
+		{in: "10MB", n: 10 << 20},
+		{in: "10 mb", n: 10 << 20},
+		{in: "2G", n: 2 << 30},
+		{in: "", err: true},
+		{in: "MB", err: true},
+		{in: "-1", err: true},
+		{in: "1.5MB", err: true},
+		{in: "10TB", err: true},
+		{in: "9999999999999GB", err: true},
+	}
+
+	for _, tt := range tests {
+		n, err := parseSize(tt.in)
+		if got, want := err != nil, tt.err; got != want {
+			t.Fatalf("%q: got error %v want %v", tt.in, err, want)
+		}
+		if n != tt.n {
+			t.Fatalf("%q: got %d want %d", tt.in, n, tt.n)
+		}
+	}
+}
+
+func TestParseMaxBody(t *testing.T) {
+	if n, err := parseMaxBody(map[string]string{}); n != 0 || err != nil {
+		t.Fatalf("got %d, %v want 0, nil", n, err)
+	}
+	if n, err := parseMaxBody(map[string]string{"maxbody": "10MB"}); n != 10<<20 || err != nil {
+		t.Fatalf("got %d, %v want %d, nil", n, err, 10<<20)
+	}
+	for _, v := range []string{"0", "ten", "-5MB"} {
+		if _, err := parseMaxBody(map[string]string{"maxbody": v}); err == nil {
+			t.Errorf("%s: got nil want error", v)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package route
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+// Mirror sends a copy of the requests to a target to a shadow
+// environment. The responses of the shadow environment are
+// discarded.
+type Mirror struct {
+	// URL is the scheme and host of the shadow environment. Its path
+	// is prepended to the path of the request to the target.
+	URL *url.URL
+
+	// Header is the name of the header which marks the copies. Empty
+	// means that the default of the proxy is used.
+	Header string
+
+	// Strip are the names of the headers which are removed from the
+	// copies. 'auth' removes the credentials and 'cookie' the cookies.
+	// Nil means that the default of the proxy is used.
+	Strip []string
+}
+
+// parseMirror parses the mirror, mirrorheader and mirrorstrip options,
+// e.g. 'mirror=http://staging:8080 mirrorstrip=cookie,auth,X-Api-Key'.
+// The value 'none' of the mirrorstrip option keeps all headers. It
+// returns nil if the mirror option is not set.
+func parseMirror(opts map[string]string) (*Mirror, error) {
+	v := opts["mirror"]
+	if v == "" {
+		if opts["mirrorheader"] != "" || opts["mirrorstrip"] != "" {
+			return nil, fmt.Errorf("mirrorheader and mirrorstrip require the mirror option")
+		}
+		return nil, nil
+	}
+	u, err := url.Parse(v)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+		return nil, fmt.Errorf("mirror should be an http or https URL without a query. Got: %s", v)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	m := &Mirror{URL: u}
+
+	if h := opts["mirrorheader"]; h != "" {
+		if !httpguts.ValidHeaderFieldName(h) {
+			return nil, fmt.Errorf("mirrorheader should be a header name. Got: %s", h)
+		}
+		m.Header = http.CanonicalHeaderKey(h)
+	}
+
+	if s, ok := opts["mirrorstrip"]; ok {
+		m.Strip = []string{}
+		if s != "none" {
+			for _, h := range splitList(s) {
+				if !httpguts.ValidHeaderFieldName(h) {
+					return nil, fmt.Errorf("mirrorstrip should be none or a list of header names, auth and cookie. Got: %s", s)
+				}
+				m.Strip = append(m.Strip, h)
+			}
+		}
+	}
+	return m, nil
+}
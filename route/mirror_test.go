@@ -0,0 +1,59 @@
+package route
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMirror(t *testing.T) {
+	tests := []struct {
+		desc   string
+		opts   map[string]string
+		url    string
+		header string
+		strip  []string
+		err    bool
+	}{
+		{desc: "no mirror", opts: map[string]string{}},
+		{desc: "mirror", opts: map[string]string{"mirror": "http://staging:8080"}, url: "http://staging:8080"},
+		{desc: "mirror with path", opts: map[string]string{"mirror": "https://staging/shadow/"}, url: "https://staging/shadow"},
+		{
+			desc:   "header and strip",
+			opts:   map[string]string{"mirror": "http://staging", "mirrorheader": "x-shadow", "mirrorstrip": "auth,X-Api-Key"},
+			url:    "http://staging",
+			header: "X-Shadow",
+			strip:  []string{"auth", "X-Api-Key"},
+		},
+		{desc: "strip none", opts: map[string]string{"mirror": "http://staging", "mirrorstrip": "none"}, url: "http://staging", strip: []string{}},
+		{desc: "no scheme", opts: map[string]string{"mirror": "staging:8080"}, err: true},
+		{desc: "tcp", opts: map[string]string{"mirror": "tcp://staging:8080"}, err: true},
+		{desc: "query", opts: map[string]string{"mirror": "http://staging/?a=b"}, err: true},
+		{desc: "invalid header", opts: map[string]string{"mirror": "http://staging", "mirrorheader": "x shadow"}, err: true},
+		{desc: "invalid strip", opts: map[string]string{"mirror": "http://staging", "mirrorstrip": "auth,a:b"}, err: true},
+		{desc: "header without mirror", opts: map[string]string{"mirrorheader": "X-Shadow"}, err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			m, err := parseMirror(tt.opts)
+			if got, want := err != nil, tt.err; got != want {
+				t.Fatalf("got error %v want %v", err, want)
+			}
+			if tt.url == "" {
+				if m != nil {
+					t.Fatalf("got %+v want nil", m)
+				}
+				return
+			}
+			if got, want := m.URL.String(), tt.url; got != want {
+				t.Fatalf("got url %q want %q", got, want)
+			}
+			if got, want := m.Header, tt.header; got != want {
+				t.Fatalf("got header %q want %q", got, want)
+			}
+			if got, want := m.Strip, tt.strip; !reflect.DeepEqual(got, want) {
+				t.Fatalf("got strip %#v want %#v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,343 @@
+package route
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fabiolb/fabio/metrics"
+)
+
+// OutlierThreshold is the share of failed requests to a service
+// instance within OutlierWindow above which the instance is ejected
+// from the selection. A value of zero disables the outlier detection.
+var OutlierThreshold float64
+
+// OutlierMinRequests is the minimum number of requests to a service
+// instance within OutlierWindow before it can be ejected.
+var OutlierMinRequests = 20
+
+// OutlierWindow is the time window in which the requests are counted.
+var OutlierWindow = 10 * time.Second
+
+// OutlierEjection is the time an instance is ejected for the first
+// time. It grows with every consecutive ejection up to ten times.
+var OutlierEjection = 30 * time.Second
+
+// OutlierMaxEjected is the maximum share of the targets of a route
+// which are ejected. At least one target of a route is never ejected.
+var OutlierMaxEjected = 0.5
+
+// OutlierRampUp is the time in which the weight of an ejected
+// instance is restored after its ejection has ended.
+var OutlierRampUp = 30 * time.Second
+
+// OutlierLatency is the response time above which a request counts as
+// failed. A value of zero only counts errors and 5xx responses.
+var OutlierLatency time.Duration
+
+// outlierSteps is the number of steps in which the weight
+// of an instance is restored.
+const outlierSteps = 5
+
+// maxEjections limits the growth of the ejection time.
+const maxEjections = 10
+
+// outlierStats counts the requests to a service instance in the
+// current window and the consecutive ejections of the instance.
+type outlierStats struct {
+	mu            sync.Mutex
+	start         time.Time
+	total, failed int
+	ejections     int
+}
+
+// outliers contains the *outlierStats of the service instances
+// which have received requests.
+var outliers sync.Map
+
+// ejection is the time an instance was ejected and the time its
+// ejection ends. The weight is restored within OutlierRampUp after
+// the end.
+type ejection struct {
+	since, until time.Time
+}
+
+// ejected contains the ejections of the service instances as a
+// map[string]ejection. It is replaced when an instance is ejected or
+// has recovered and read without a lock. outlierTimer reweighs the
+// routing table when the ejections end and while the weights are
+// restored. Both are guarded by mu.
+var (
+	ejected      atomic.Value
+	outlierTimer *time.Timer
+)
+
+func init() {
+	ejected.Store(map[string]ejection{})
+}
+
+// RecordOutcome records the result of a request to the target for
+// the outlier detection. The service instance is ejected when the
+// share of failed requests exceeds OutlierThreshold.
+func (t *Target) RecordOutcome(failed bool) {
+	if OutlierThreshold <= 0 || t.URL == nil {
+		return
+	}
+
+	now := timeNow()
+	key := flapKey(t)
+	v, ok := outliers.Load(key)
+	if !ok {
+		v, _ = outliers.LoadOrStore(key, &outlierStats{start: now})
+	}
+	s := v.(*outlierStats)
+
+	s.mu.Lock()
+	if now.Sub(s.start) >= OutlierWindow {
+		// an instance with enough successful requests
+		// starts again with the shortest ejection time
+		if s.total >= OutlierMinRequests {
+			s.ejections = 0
+		}
+		s.start, s.total, s.failed = now, 0, 0
+	}
+	s.total++
+	if failed {
+		s.failed++
+	}
+	rate := float64(s.failed) / float64(s.total)
+	eject := s.total >= OutlierMinRequests && rate > OutlierThreshold
+	var n, total int
+	if eject {
+		if s.ejections < maxEjections {
+			s.ejections++
+		}
+		n, total = s.ejections, s.total
+		s.start, s.total, s.failed = now, 0, 0
+	}
+	s.mu.Unlock()
+
+	if eject {
+		ejectOutlier(key, now, time.Duration(n)*OutlierEjection, rate, total)
+	}
+}
+
+// ejectOutlier ejects the service instance for d and reweighs
+// the routing table unless the instance is already ejected.
+func ejectOutlier(key string, now time.Time, d time.Duration, rate float64, total int) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	old := ejected.Load().(map[string]ejection)
+	if e, ok := old[key]; ok && now.Before(e.until) {
+		return
+	}
+	cur := make(map[string]ejection, len(old)+1)
+	for k, e := range old {
+		cur[k] = e
+	}
+	cur[key] = ejection{since: now, until: now.Add(d)}
+	ejected.Store(cur)
+
+	log.Printf("[WARN] route: Ejecting %s for %s. %.0f%% of %d requests failed", key, d, rate*100, total)
+	metrics.DefaultRegistry.GetCounter("outlier.ejected").Inc(1)
+	updateOutliers(now)
+	table.Store(GetTable().reweigh())
+}
+
+// updateOutliers forgets the instances whose weight has been restored
+// and schedules the next reweighing of the routing table. The caller
+// must hold mu.
+func updateOutliers(now time.Time) {
+	old := ejected.Load().(map[string]ejection)
+	cur := make(map[string]ejection, len(old))
+	var next time.Time
+	var n int64
+	for k, e := range old {
+		end := e.until.Add(OutlierRampUp)
+		if !now.Before(end) {
+			log.Printf("[INFO] route: %s has recovered", k)
+			continue
+		}
+		cur[k] = e
+		if now.Before(e.until) {
+			n++
+		}
+		at := e.until
+		if !now.Before(at) {
+			// the next step of the ramp up
+			step := rampStep()
+			at = e.until.Add((now.Sub(e.until)/step + 1) * step)
+		}
+		if next.IsZero() || at.Before(next) {
+			next = at
+		}
+	}
+	if len(cur) != len(old) {
+		ejected.Store(cur)
+	}
+	metrics.DefaultRegistry.GetGauge("outlier.targets").Update(n)
+
+	if outlierTimer != nil {
+		outlierTimer.Stop()
+		outlierTimer = nil
+	}
+	if !next.IsZero() {
+		outlierTimer = time.AfterFunc(next.Sub(now), func() {
+			mu.Lock()
+			defer mu.Unlock()
+			updateOutliers(timeNow())
+			table.Store(GetTable().reweigh())
+		})
+	}
+}
+
+// syncOutliers forgets the request counts of the service instances
+// which are no longer in the routing table. The caller must hold mu.
+func syncOutliers(t Table) {
+	if OutlierThreshold <= 0 {
+		return
+	}
+	m := instances(t)
+	outliers.Range(func(k, _ interface{}) bool {
+		if !m[k.(string)] {
+			outliers.Delete(k)
+		}
+		return true
+	})
+}
+
+// Ejected returns true if the service instance of the target has
+// been ejected because too many of its requests failed.
+func (t *Target) Ejected() bool {
+	return t.outlierWeight(timeNow()) == 0
+}
+
+// outlierWeight returns the factor by which the weight of the target
+// is reduced. It is 0 while the instance is ejected and increases to
+// 1 in steps within OutlierRampUp after the ejection has ended.
+func (t *Target) outlierWeight(now time.Time) float64 {
+	if t.URL == nil {
+		return 1
+	}
+	e, ok := ejected.Load().(map[string]ejection)[flapKey(t)]
+	switch {
+	case !ok:
+		return 1
+	case now.Before(e.until):
+		return 0
+	case OutlierRampUp <= 0:
+		return 1
+	}
+	n := now.Sub(e.until)/rampStep() + 1
+	if n >= outlierSteps {
+		return 1
+	}
+	return float64(n) / outlierSteps
+}
+
+// rampStep returns the duration of a step of the ramp up.
+func rampStep() time.Duration {
+	if d := OutlierRampUp / outlierSteps; d > 0 {
+		return d
+	}
+	return 1
+}
+
+// ejectOutliers returns the targets without the ejected ones. Only
+// OutlierMaxEjected of the targets are removed and the ones which
+// were ejected first take precedence. One target is always kept.
+func ejectOutliers(targets []*Target) []*Target {
+	if len(ejected.Load().(map[string]ejection)) == 0 {
+		return targets
+	}
+	now := timeNow()
+	var out []*Target
+	for _, t := range targets {
+		if t.outlierWeight(now) == 0 {
+			out = append(out, t)
+		}
+	}
+	if len(out) == 0 {
+		return targets
+	}
+
+	max := int(OutlierMaxEjected * float64(len(targets)))
+	if max >= len(targets) {
+		max = len(targets) - 1
+	}
+	if len(out) > max {
+		m := ejected.Load().(map[string]ejection)
+		sort.SliceStable(out, func(i, j int) bool {
+			return m[flapKey(out[i])].since.Before(m[flapKey(out[j])].since)
+		})
+		out = out[:max]
+	}
+	if len(out) == 0 {
+		return targets
+	}
+
+	removed := map[*Target]bool{}
+	for _, t := range out {
+		removed[t] = true
+	}
+	var kept []*Target
+	for _, t := range targets {
+		if !removed[t] {
+			kept = append(kept, t)
+		}
+	}
+	return kept
+}
+
+// rampOutliers reduces the weight of the targets whose weight is
+// being restored after an ejection and scales the weights of all
+// targets so that their sum does not change. Ejected targets which
+// have been kept because of OutlierMaxEjected keep their weight.
+func rampOutliers(targets []*Target) {
+	if len(ejected.Load().(map[string]ejection)) == 0 {
+		return
+	}
+	now := timeNow()
+	factor := func(t *Target) float64 {
+		if f := t.outlierWeight(now); f > 0 {
+			return f
+		}
+		return 1
+	}
+
+	var sum, ramped float64
+	var n int
+	for _, t := range targets {
+		sum += t.Weight
+		f := factor(t)
+		if f < 1 {
+			n++
+		}
+		ramped += t.Weight * f
+	}
+	if n == 0 || ramped <= 0 {
+		return
+	}
+	for _, t := range targets {
+		t.Weight *= factor(t) * sum / ramped
+	}
+}
+
+// hasOutliers returns true if the weight of one of the
+// targets is reduced after an ejection.
+func hasOutliers(targets []*Target) bool {
+	if len(ejected.Load().(map[string]ejection)) == 0 {
+		return false
+	}
+	now := timeNow()
+	for _, t := range targets {
+		if t.outlierWeight(now) < 1 {
+			return true
+		}
+	}
+	return false
+}
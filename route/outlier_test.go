@@ -0,0 +1,135 @@
+package route
+
+import (
+	"bytes"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestOutlierEjection(t *testing.T) {
+	OutlierThreshold, OutlierMinRequests, OutlierWindow = 0.5, 4, time.Minute
+	OutlierEjection, OutlierMaxEjected, OutlierRampUp = time.Minute, 0.5, 50*time.Second
+	start := time.Now()
+	now := start
+	timeNow = func() time.Time { return now }
+	defer func() {
+		OutlierThreshold, OutlierMinRequests, OutlierWindow = 0, 20, 10*time.Second
+		OutlierEjection, OutlierMaxEjected, OutlierRampUp = 30*time.Second, 0.5, 30*time.Second
+		timeNow = time.Now
+		resetOutliers()
+		SetTable(make(Table))
+	}()
+
+	tbl, err := NewTable(bytes.NewBufferString("route add svc /foo http://a/\nroute add svc /foo http://b/\nroute add svc /foo http://c/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetTable(tbl)
+
+	target := func(host string) *Target {
+		for _, tg := range GetTable()[""][0].Targets {
+			if tg.URL.Host == host {
+				return tg
+			}
+		}
+		t.Fatalf("no target %s", host)
+		return nil
+	}
+	fail := func(host string, n int) {
+		for i := 0; i < n; i++ {
+			target(host).RecordOutcome(true)
+		}
+	}
+	check := func(desc string, a, b, c float64) {
+		t.Helper()
+		for host, want := range map[string]float64{"a": a, "b": b, "c": c} {
+			if got := target(host).Weight; math.Abs(got-want) > 1e-9 {
+				t.Fatalf("%s: got weight %v for %s want %v", desc, got, host, want)
+			}
+		}
+	}
+	reweigh := func() {
+		mu.Lock()
+		updateOutliers(now)
+		table.Store(GetTable().reweigh())
+		mu.Unlock()
+	}
+
+	// too few requests and then not above the threshold
+	fail("a", 2)
+	check("min requests", 1.0/3, 1.0/3, 1.0/3)
+	target("a").RecordOutcome(false)
+	target("a").RecordOutcome(false)
+	check("threshold", 1.0/3, 1.0/3, 1.0/3)
+
+	fail("a", 4)
+	if !target("a").Ejected() {
+		t.Fatal("a not ejected")
+	}
+	check("a ejected", 0, 0.5, 0.5)
+
+	// b is ejected but kept because only one of
+	// the three targets of the route can be ejected
+	now = start.Add(10 * time.Second)
+	fail("b", 4)
+	if !target("b").Ejected() {
+		t.Fatal("b not ejected")
+	}
+	check("cap", 0, 0.5, 0.5)
+
+	// a comes back with a fifth of its weight and
+	// b takes its place in the ejected share
+	now = start.Add(61 * time.Second)
+	reweigh()
+	check("ramp up", 0.5*0.2/0.6, 0, 0.5/0.6)
+
+	now = start.Add(110 * time.Second)
+	reweigh()
+	check("recovered", 1.0/3, 1.0/3, 1.0/3)
+	if _, ok := ejected.Load().(map[string]ejection)["svc http://a/"]; ok {
+		t.Fatal("a not forgotten after the ramp up")
+	}
+
+	// the second ejection of a lasts twice as long
+	fail("a", 4)
+	if got, want := ejected.Load().(map[string]ejection)["svc http://a/"].until, now.Add(2*time.Minute); !got.Equal(want) {
+		t.Fatalf("got ejection until %v want %v", got, want)
+	}
+}
+
+func TestEjectOutliersKeepsOneTarget(t *testing.T) {
+	OutlierMaxEjected = 1
+	defer func() {
+		OutlierMaxEjected = 0.5
+		resetOutliers()
+	}()
+
+	targets := []*Target{
+		{Service: "svc", URL: mustParse("http://a/")},
+		{Service: "svc", URL: mustParse("http://b/")},
+	}
+	now := time.Now()
+	ejected.Store(map[string]ejection{
+		"svc http://a/": {since: now.Add(-time.Second), until: now.Add(time.Minute)},
+		"svc http://b/": {since: now, until: now.Add(time.Minute)},
+	})
+	got := ejectOutliers(targets)
+	if len(got) != 1 || got[0].URL.Host != "b" {
+		t.Fatalf("got %v want the target which was ejected last", got)
+	}
+}
+
+func resetOutliers() {
+	mu.Lock()
+	defer mu.Unlock()
+	if outlierTimer != nil {
+		outlierTimer.Stop()
+		outlierTimer = nil
+	}
+	outliers.Range(func(k, _ interface{}) bool {
+		outliers.Delete(k)
+		return true
+	})
+	ejected.Store(map[string]ejection{})
+}
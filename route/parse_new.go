@@ -28,11 +28,28 @@ route add <svc> <src> <dst>[ weight <w>][ tags "<t1>,<t2>,..."][ opts "k1=v1 k2=
 	  strip=/path        : forward '/path/to/file' as '/to/file'
 	  prepend=/prefix    : forward '/path/to/file' as '/prefix/path/to/file'
 	  proto=tcp          : upstream service is TCP, dst is ':port'
+	  proto=udp          : upstream service is UDP, dst is ':port'
 	  proto=https        : upstream service is HTTPS
+	  proto=h2           : upstream service is HTTP/2 over TLS
+	  proto=h2c          : upstream service is HTTP/2 without TLS
 	  tlsskipverify=true : disable TLS cert validation for HTTPS upstream
 	  host=name          : set the Host header to 'name'. If 'name == "dst"' then the 'Host' header will be set to the registered upstream host name
+	  rewritelocation=true : rewrite the Location header of redirects from the target to the host of the client request
+	  cookiedomain=name  : replace the Domain attribute of cookies set by the target. 'none' removes it
+	  cookiepath=/path   : replace the Path attribute of cookies set by the target. 'true' maps it back with strip and prepend
+	  cookiesecure=true  : add or remove the Secure attribute of cookies set by the target
+	  cookiesamesite=lax : replace the SameSite attribute of cookies set by the target with lax, strict or none
+	  middleware=a,b     : process the requests with the middlewares a and b instead of proxy.middleware. 'none' disables them
+	  annotation.k=v     : annotate the metrics, traces and access logs of the target with k=v
 	  register=name      : register fabio as new service 'name'. Useful for registering hostnames for host specific routes.
       auth=name          : name of the auth scheme to use (defined in proxy.auth)
+	  active=windows     : route only within the comma separated time windows, e.g. 'Mon-Fri 08:00-18:00,Sat 10:00-14:00'
+	  tz=name            : time zone of the time windows, e.g. 'Europe/Berlin'
+	  match-header=h:v   : canary target which only receives requests with the header h set to v
+	  match-cookie=c:v   : canary target which only receives requests with the cookie c set to v
+	  sticky=cookie      : route the requests of a client to the same target with a signed cookie
+	  name=FABIOSRV      : name of the sticky session cookie
+	  ttl=1h             : lifetime of the sticky session cookie. Defaults to a session cookie
 
 route del <svc>[ <src>[ <dst>]]
   - Remove route matching svc, src and/or dst
@@ -96,9 +113,38 @@ func Parse(in *bytes.Buffer) (defs []*RouteDef, err error) {
 	return defs, nil
 }
 
+// AliasDef describes a service name which fabio registers for a route
+// with the "register" option and the host the route is serving.
+type AliasDef struct {
+	// Name is the service name fabio registers in the registry.
+	Name string `json:"name"`
+
+	// Service is the name of the upstream service of the route.
+	Service string `json:"service"`
+
+	// Host is the host part of the route source and is empty
+	// for routes without a host.
+	Host string `json:"host"`
+}
+
 // ParseAliases scans a set of route commands for the "register" option and
 // returns a list of services which should be registered by the backend.
 func ParseAliases(in string) (names []string, err error) {
+	defs, err := ParseAliasDefs(in)
+	if err != nil {
+		return nil, err
+	}
+
+	var aliases []string
+	for _, d := range defs {
+		aliases = append(aliases, d.Name)
+	}
+	return aliases, nil
+}
+
+// ParseAliasDefs scans a set of route commands for the "register" option
+// and returns the aliases together with the routes they were defined for.
+func ParseAliasDefs(in string) (aliases []AliasDef, err error) {
 	var defs []*RouteDef
 	var def *RouteDef
 	for i, s := range strings.Split(in, "\n") {
@@ -122,13 +168,16 @@ func ParseAliases(in string) (names []string, err error) {
 		defs = append(defs, def)
 	}
 
-	var aliases []string
-
 	for _, d := range defs {
 		registerName, ok := d.Opts["register"]
-		if ok {
-			aliases = append(aliases, registerName)
+		if !ok {
+			continue
 		}
+		host := d.Src
+		if n := strings.Index(host, "/"); n >= 0 {
+			host = host[:n]
+		}
+		aliases = append(aliases, AliasDef{Name: registerName, Service: d.Service, Host: host})
 	}
 	return aliases, nil
 }
@@ -140,6 +189,15 @@ var reAdd = mustCompileWithFlexibleSpace(`^route add (\S+) (\S+) (\S+)( weight (
 func parseRouteAdd(s string) (*RouteDef, error) {
 	if m := reAdd.FindStringSubmatch(s); m != nil {
 		w, err := parseWeight(m[5])
+		if err != nil {
+			return nil, err
+		}
+		opts := parseOpts(m[9])
+		if active, ok := opts["active"]; ok {
+			if _, err := ParseSchedule(active, opts["tz"]); err != nil {
+				return nil, fmt.Errorf("syntax error: 'active' invalid. %s", err)
+			}
+		}
 		return &RouteDef{
 			Cmd:     RouteAddCmd,
 			Service: m[1],
@@ -147,8 +205,8 @@ func parseRouteAdd(s string) (*RouteDef, error) {
 			Dst:     m[3],
 			Weight:  w,
 			Tags:    parseTags(m[7]),
-			Opts:    parseOpts(m[9]),
-		}, err
+			Opts:    opts,
+		}, nil
 	}
 	return nil, errors.New("syntax error: 'route add' invalid")
 }
@@ -235,17 +293,41 @@ func parseTags(s string) []string {
 	return tags
 }
 
+// multiWordOpts contains the options whose values can contain spaces.
+// Words which follow such an option and do not start with an option
+// name and a '=' are added to its value.
+var multiWordOpts = map[string]bool{
+	"active":      true,
+	"bodyrewrite": true,
+	"rewrite":     true,
+}
+
+// flagOpts contains the options which are used without a value.
+// They end the value of a preceding multi-word option.
+var flagOpts = map[string]bool{
+	"default": true,
+}
+
+// reOptName matches the valid names of options.
+var reOptName = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+
 func parseOpts(s string) map[string]string {
 	if s == "" {
 		return nil
 	}
 	m := make(map[string]string)
+	last := ""
 	for _, f := range strings.Fields(s) {
 		p := strings.SplitN(f, "=", 2)
-		if len(p) == 1 {
+		switch {
+		case multiWordOpts[last] && !flagOpts[f] && (len(p) == 1 || !reOptName.MatchString(p[0])):
+			m[last] += " " + f
+		case len(p) == 1:
 			m[f] = ""
-		} else {
+			last = f
+		default:
 			m[p[0]] = p[1]
+			last = p[0]
 		}
 	}
 	return m
@@ -77,6 +77,31 @@ func TestParse(t *testing.T) {
 			in:   `route add svc /prefix http://1.2.3.4/ opts "foo=bar baz=bang blimp"`,
 			out:  []*RouteDef{{Cmd: RouteAddCmd, Service: "svc", Src: "/prefix", Dst: "http://1.2.3.4/", Opts: map[string]string{"foo": "bar", "baz": "bang", "blimp": ""}}},
 		},
+		{
+			desc: "RouteAddOptsActive",
+			in:   `route add svc /prefix http://1.2.3.4/ opts "active=Mon-Fri 08:00-18:00,Sat 10:00-12:00 tz=Europe/Berlin blimp"`,
+			out:  []*RouteDef{{Cmd: RouteAddCmd, Service: "svc", Src: "/prefix", Dst: "http://1.2.3.4/", Opts: map[string]string{"active": "Mon-Fri 08:00-18:00,Sat 10:00-12:00", "tz": "Europe/Berlin", "blimp": ""}}},
+		},
+		{
+			desc: "RouteAddOptsActiveDefault",
+			in:   `route add svc /prefix http://1.2.3.4/ opts "active=Mon-Fri 08:00-18:00 default"`,
+			out:  []*RouteDef{{Cmd: RouteAddCmd, Service: "svc", Src: "/prefix", Dst: "http://1.2.3.4/", Opts: map[string]string{"active": "Mon-Fri 08:00-18:00", "default": ""}}},
+		},
+		{
+			desc: "FailRouteAddOptsActiveInvalid",
+			in:   `route add svc /prefix http://1.2.3.4/ opts "active=Foo"`,
+			fail: true,
+		},
+		{
+			desc: "RouteAddOptsRewrite",
+			in:   `route add svc /prefix http://1.2.3.4/ opts "rewrite=^/old/(.*) /new?id=$1 rewritequery=true"`,
+			out:  []*RouteDef{{Cmd: RouteAddCmd, Service: "svc", Src: "/prefix", Dst: "http://1.2.3.4/", Opts: map[string]string{"rewrite": "^/old/(.*) /new?id=$1", "rewritequery": "true"}}},
+		},
+		{
+			desc: "RouteAddOptsBodyRewrite",
+			in:   `route add svc /app http://1.2.3.4/ opts "bodyrewrite=http://legacy/ /app/ strip=/app"`,
+			out:  []*RouteDef{{Cmd: RouteAddCmd, Service: "svc", Src: "/app", Dst: "http://1.2.3.4/", Opts: map[string]string{"bodyrewrite": "http://legacy/ /app/", "strip": "/app"}}},
+		},
 		{
 			desc: "RouteDelTags",
 			in:   `route del tags "a,b"`,
@@ -256,3 +281,21 @@ func TestParseAliases(t *testing.T) {
 		t.Run("ParseAliases-"+tt.desc, func(t *testing.T) { run(tt.in, tt.out, tt.fail, ParseAliases) })
 	}
 }
+
+func TestParseAliasDefs(t *testing.T) {
+	in := `route add alpha-be alpha.com/ http://1.2.3.4/ opts "register=alpha"
+	route add bravo-be /bravo http://1.2.3.5/ opts "register=bravo"
+	route add charlie-be charlie.com/ http://1.2.3.6/`
+
+	out, err := ParseAliasDefs(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []AliasDef{
+		{Name: "alpha", Service: "alpha-be", Host: "alpha.com"},
+		{Name: "bravo", Service: "bravo-be", Host: ""},
+	}
+	if got := out; !reflect.DeepEqual(got, want) {
+		t.Errorf("\ngot  %#v\nwant %#v", got, want)
+	}
+}
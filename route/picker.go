@@ -1,30 +1,36 @@
 package route
 
 import (
+	"net/http"
 	"sync/atomic"
 	"time"
 )
 
-// picker selects a target from a list of targets.
-type picker func(r *Route) *Target
+// picker selects a target from a list of targets. req is the request
+// which is routed or nil for TCP connections and retries.
+type picker func(r *Route, req *http.Request) *Target
 
 // Picker contains the available picker functions.
 // Update config/load.go#load after updating.
 var Picker = map[string]picker{
-	"rnd": rndPicker,
-	"rr":  rrPicker,
+	"rnd":  rndPicker,
+	"rr":   rrPicker,
+	"hash": hashPicker,
 }
 
 // rndPicker picks a random target from the list of targets.
-func rndPicker(r *Route) *Target {
-	return r.wTargets[randIntn(len(r.wTargets))]
+func rndPicker(r *Route, _ *http.Request) *Target {
+	if r.thresholds == nil {
+		return r.wTargets[randIntn(len(r.wTargets))]
+	}
+	return r.pick(randUint64())
 }
 
 // rrPicker picks the next target from a list of targets using round-robin.
-func rrPicker(r *Route) *Target {
-	u := r.wTargets[r.total%uint64(len(r.wTargets))]
-	atomic.AddUint64(&r.total, 1)
-	return u
+// Targets with different weights are picked in a deterministic order which
+// spreads the requests evenly according to the weights.
+func rrPicker(r *Route, _ *http.Request) *Target {
+	return r.pick(atomic.AddUint64(&r.total, 1) - 1)
 }
 
 // stubbed out for testing
@@ -41,3 +47,10 @@ var randIntn = func(n int) int {
 	}
 	return int(time.Now().UnixNano()/int64(time.Microsecond)) % n
 }
+
+// randUint64 returns a pseudo random number for picking weighted targets.
+// The microsecond counter is used as the index into the low discrepancy
+// sequence of the round-robin picker which spreads the values evenly.
+var randUint64 = func() uint64 {
+	return uint64(time.Now().UnixNano() / int64(time.Microsecond))
+}
@@ -1,6 +1,8 @@
 package route
 
 import (
+	"fmt"
+	"math"
 	"net/url"
 	"reflect"
 	"testing"
@@ -37,7 +39,7 @@ func TestRndPicker(t *testing.T) {
 
 	for i, tt := range tests {
 		randIntn = func(int) int { return i }
-		if got, want := rndPicker(r).URL, tt.targetURL; !reflect.DeepEqual(got, want) {
+		if got, want := rndPicker(r, nil).URL, tt.targetURL; !reflect.DeepEqual(got, want) {
 			t.Errorf("%d: got %v want %v", i, got, want)
 		}
 	}
@@ -51,8 +53,105 @@ func TestRRPicker(t *testing.T) {
 	tests := []*url.URL{fooDotCom, barDotCom, fooDotCom, barDotCom, fooDotCom, barDotCom}
 
 	for i, tt := range tests {
-		if got, want := rrPicker(r).URL, tt; !reflect.DeepEqual(got, want) {
+		if got, want := rrPicker(r, nil).URL, tt; !reflect.DeepEqual(got, want) {
 			t.Errorf("%d: got %v want %v", i, got, want)
 		}
 	}
 }
+
+func TestRRPickerSmallWeights(t *testing.T) {
+	r := &Route{Host: "www.bar.com", Path: "/foo"}
+	r.addTarget("svc", fooDotCom, 0.0001, nil, nil)
+	r.addTarget("svc", barDotCom, 0.9999, nil, nil)
+
+	n := 1000000
+	foo := 0
+	for i := 0; i < n; i++ {
+		if rrPicker(r, nil).URL == fooDotCom {
+			foo++
+		}
+	}
+	if got, want := foo, 100; got < want-2 || got > want+2 {
+		t.Fatalf("got %d requests for the 0.01%% target want %d", got, want)
+	}
+}
+
+func TestRRPickerManyTargets(t *testing.T) {
+	// addTarget is O(n) so add the targets directly
+	r := &Route{Host: "www.bar.com", Path: "/foo"}
+	r.Targets = append(r.Targets, &Target{URL: fooDotCom, FixedWeight: 0.5})
+	for i := 0; i < 20000; i++ {
+		r.Targets = append(r.Targets, &Target{URL: mustParse(fmt.Sprintf("http://bar.com:%d/", 1000+i))})
+	}
+	r.weighTargets()
+
+	// every target gets 1/40000 of the traffic which a
+	// ring with 10000 slots cannot represent.
+	n := 400000
+	picks := map[*Target]int{}
+	for i := 0; i < n; i++ {
+		picks[rrPicker(r, nil)]++
+	}
+	if got, want := picks[r.Targets[0]], n/2; got < want-2 || got > want+2 {
+		t.Fatalf("got %d requests for the fixed target want %d", got, want)
+	}
+	for _, tg := range r.Targets[1:] {
+		if got := picks[tg]; got < 8 || got > 12 {
+			t.Fatalf("got %d requests for %s want 10", got, tg.URL)
+		}
+	}
+}
+
+func TestRRPickerDeterministic(t *testing.T) {
+	newRoute := func() *Route {
+		r := &Route{Host: "www.bar.com", Path: "/foo"}
+		r.addTarget("svc", fooDotCom, 0.3, nil, nil)
+		r.addTarget("svc", barDotCom, 0.7, nil, nil)
+		return r
+	}
+	r1, r2 := newRoute(), newRoute()
+	for i := 0; i < 1000; i++ {
+		if got, want := rrPicker(r1, nil).URL, rrPicker(r2, nil).URL; got != want {
+			t.Fatalf("%d: got %v want %v", i, got, want)
+		}
+	}
+}
+
+func TestRndPickerWeighted(t *testing.T) {
+	r := &Route{Host: "www.bar.com", Path: "/foo"}
+	r.addTarget("svc", fooDotCom, 0.25, nil, nil)
+	r.addTarget("svc", barDotCom, 0.75, nil, nil)
+
+	prev := randUint64
+	defer func() { randUint64 = prev }()
+
+	// the picks of the random picker follow the same sequence
+	// as the round-robin picker for the same random values.
+	for i := uint64(0); i < 100; i++ {
+		randUint64 = func() uint64 { return i }
+		if got, want := rndPicker(r, nil), r.pick(i); got != want {
+			t.Fatalf("%d: got %v want %v", i, got.URL, want.URL)
+		}
+	}
+}
+
+func TestShares(t *testing.T) {
+	r := &Route{Host: "www.bar.com", Path: "/foo"}
+	r.addTarget("svc", fooDotCom, 0.2, nil, nil)
+	r.addTarget("svc", barDotCom, 0, nil, nil)
+	r.addTarget("svc", mustParse("http://baz.com/"), 0, nil, map[string]string{"backup": "true"})
+
+	shares := r.Shares(10000)
+	want := []struct{ weight, effective float64 }{{0.2, 0.2}, {0.8, 0.8}, {0, 0}}
+	if len(shares) != len(want) {
+		t.Fatalf("got %d shares want %d", len(shares), len(want))
+	}
+	for i, s := range shares {
+		if s.Target != r.Targets[i] {
+			t.Fatalf("%d: got target %v want %v", i, s.Target.URL, r.Targets[i].URL)
+		}
+		if math.Abs(s.Weight-want[i].weight) > 1e-9 || math.Abs(s.Effective-want[i].effective) > 0.001 {
+			t.Fatalf("%d: got weight %f effective %f want %f %f", i, s.Weight, s.Effective, want[i].weight, want[i].effective)
+		}
+	}
+}
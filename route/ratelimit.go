@@ -0,0 +1,129 @@
+package route
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// RateLimiter allows on average Rate requests per second
+// with bursts of up to Burst requests.
+type RateLimiter struct {
+	// Rate is the number of requests per second.
+	Rate float64
+
+	// Burst is the maximum number of requests
+	// which are allowed at once.
+	Burst int
+
+	limiter *rate.Limiter
+}
+
+// NewRateLimiter creates a rate limiter with a full bucket.
+// If burst is not positive it defaults to the rate rounded
+// up to the next integer.
+func NewRateLimiter(r float64, burst int) *RateLimiter {
+	if burst <= 0 {
+		burst = int(math.Ceil(r))
+	}
+	return &RateLimiter{Rate: r, Burst: burst, limiter: rate.NewLimiter(rate.Limit(r), burst)}
+}
+
+// Allow returns true if a request is allowed at the current time.
+func (l *RateLimiter) Allow() bool {
+	return l.allow(timeNow())
+}
+
+func (l *RateLimiter) allow(now time.Time) bool {
+	return l.limiter.AllowN(now, 1)
+}
+
+// ParseRate parses a rate like '100r/s', '600r/m' or '1000r/h'
+// and returns the number of requests per second.
+func ParseRate(s string) (float64, error) {
+	p := strings.SplitN(s, "r/", 2)
+	if len(p) != 2 {
+		return 0, fmt.Errorf("invalid rate %q", s)
+	}
+	n, err := strconv.ParseFloat(p[0], 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid rate %q", s)
+	}
+	switch p[1] {
+	case "s":
+		return n, nil
+	case "m":
+		return n / 60, nil
+	case "h":
+		return n / 3600, nil
+	default:
+		return 0, fmt.Errorf("invalid rate %q", s)
+	}
+}
+
+// rateLimiters contains the rate limiters of the routes. They are
+// kept across routing table updates so that the buckets are not
+// refilled on every change.
+var rateLimiters = struct {
+	sync.Mutex
+	m map[string]*RateLimiter
+}{m: map[string]*RateLimiter{}}
+
+// rateLimiter returns the rate limiter for the ratelimit and burst
+// options of a target of the route. Targets of the route with the
+// same options share the rate limiter.
+func (r *Route) rateLimiter(opts map[string]string) (*RateLimiter, error) {
+	rate, err := ParseRate(opts["ratelimit"])
+	if err != nil {
+		return nil, err
+	}
+	var burst int
+	if opts["burst"] != "" {
+		burst, err = strconv.Atoi(opts["burst"])
+		if err != nil || burst <= 0 {
+			return nil, fmt.Errorf("burst should be a positive number. Got: %s", opts["burst"])
+		}
+	}
+
+	key := rateLimiterKey(r, opts)
+	rateLimiters.Lock()
+	defer rateLimiters.Unlock()
+	l := rateLimiters.m[key]
+	if l == nil {
+		l = NewRateLimiter(rate, burst)
+		rateLimiters.m[key] = l
+	}
+	return l, nil
+}
+
+func rateLimiterKey(r *Route, opts map[string]string) string {
+	return r.Host + r.Path + " " + opts["ratelimit"] + " " + opts["burst"]
+}
+
+// syncRateLimiters removes the rate limiters
+// which are no longer used by the table.
+func syncRateLimiters(t Table) {
+	active := map[string]bool{}
+	for _, routes := range t {
+		for _, r := range routes {
+			for _, tg := range r.Targets {
+				if tg.RateLimit != nil {
+					active[rateLimiterKey(r, tg.Opts)] = true
+				}
+			}
+		}
+	}
+
+	rateLimiters.Lock()
+	defer rateLimiters.Unlock()
+	for key := range rateLimiters.m {
+		if !active[key] {
+			delete(rateLimiters.m, key)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package route
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		in   string
+		want float64
+	}{
+		{"100r/s", 100},
+		{"0.5r/s", 0.5},
+		{"600r/m", 10},
+		{"7200r/h", 2},
+	}
+	for _, tt := range tests {
+		got, err := ParseRate(tt.in)
+		if err != nil {
+			t.Fatalf("%s: %s", tt.in, err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %v want %v", tt.in, got, tt.want)
+		}
+	}
+	for _, s := range []string{"", "100", "100/s", "xr/s", "0r/s", "-1r/s", "100r/d"} {
+		if _, err := ParseRate(s); err == nil {
+			t.Errorf("%q: got nil want error", s)
+		}
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	l := NewRateLimiter(2, 3)
+	now := time.Now()
+	at := func(d time.Duration) time.Time { return now.Add(d) }
+
+	steps := []struct {
+		at   time.Time
+		want bool
+	}{
+		// burst
+		{at(0), true},
+		{at(0), true},
+		{at(0), true},
+		{at(0), false},
+		// one token every 500ms
+		{at(400 * time.Millisecond), false},
+		{at(500 * time.Millisecond), true},
+		{at(500 * time.Millisecond), false},
+		// the bucket holds at most burst tokens
+		{at(time.Minute), true},
+		{at(time.Minute), true},
+		{at(time.Minute), true},
+		{at(time.Minute), false},
+	}
+	for i, s := range steps {
+		if got := l.allow(s.at); got != s.want {
+			t.Fatalf("%d: got %v want %v", i, got, s.want)
+		}
+	}
+
+	if got, want := NewRateLimiter(2.5, 0).Burst, 3; got != want {
+		t.Fatalf("got default burst %d want %d", got, want)
+	}
+}
+
+func TestRouteRateLimiter(t *testing.T) {
+	routes := `
+		route add svc /foo http://foo:1 opts "ratelimit=10r/s burst=5"
+		route add svc /foo http://foo:2 opts "ratelimit=10r/s burst=5"
+		route add svc /bar http://foo:1 opts "ratelimit=10r/s burst=5"
+		route add svc /baz http://foo:1
+	`
+	newTable := func() Table {
+		tbl, err := NewTable(bytes.NewBufferString(routes))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tbl
+	}
+
+	defer SetTable(GetTable())
+	tbl := newTable()
+	foo, bar, baz := tbl.route("", "/foo"), tbl.route("", "/bar"), tbl.route("", "/baz")
+
+	if l := foo.Targets[0].RateLimit; l == nil || l.Rate != 10 || l.Burst != 5 {
+		t.Fatalf("got %+v want rate 10 and burst 5", l)
+	}
+	if foo.Targets[0].RateLimit != foo.Targets[1].RateLimit {
+		t.Fatal("got separate rate limiters for the targets of a route")
+	}
+	if foo.Targets[0].RateLimit == bar.Targets[0].RateLimit {
+		t.Fatal("got the same rate limiter for different routes")
+	}
+	if baz.Targets[0].RateLimit != nil {
+		t.Fatal("got rate limiter for route without ratelimit")
+	}
+
+	// rate limiters are kept across table updates
+	SetTable(tbl)
+	if got, want := newTable().route("", "/foo").Targets[0].RateLimit, foo.Targets[0].RateLimit; got != want {
+		t.Fatal("got new rate limiter after table update")
+	}
+
+	// and removed when they are no longer used
+	SetTable(make(Table))
+	if got := newTable().route("", "/foo").Targets[0].RateLimit; got == foo.Targets[0].RateLimit {
+		t.Fatal("got old rate limiter after route was removed")
+	}
+}
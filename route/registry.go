@@ -0,0 +1,97 @@
+package route
+
+import (
+	"log"
+	"time"
+
+	"github.com/fabiolb/fabio/metrics"
+)
+
+// MetricsGCGrace is the time the metrics of a target are kept after
+// it has been removed from the routing table. Targets which are added
+// again within the grace period keep their metrics. A value of zero
+// unregisters the metrics immediately.
+var MetricsGCGrace time.Duration
+
+// targetMetrics contains the suffixes of the per-target metrics
+// in the default registry. The '.ua.*' counters are the user agent
+// families of the proxy.
+var targetMetrics = []string{
+	".rx", ".tx", ".retry", ".ratelimit.limited", ".cache.hit", ".cache.miss", ".ws.conn", ".health",
+	".ua.bot", ".ua.cli", ".ua.mobile", ".ua.library", ".ua.browser", ".ua.other", ".ua.none",
+}
+
+// registered contains the names of the targets whose metrics have
+// not been unregistered. removed contains the time at which targets
+// have been removed from the routing table. Both are guarded by mu.
+var (
+	registered = map[string]bool{}
+	removed    = map[string]time.Time{}
+	gcTimer    *time.Timer
+)
+
+// syncRegistry unregisters the metrics of the targets which have
+// not been part of the routing table for longer than MetricsGCGrace.
+// It assumes that all timers of the table have already been
+// registered. The caller must hold mu.
+func syncRegistry(t Table) {
+	now := timeNow()
+
+	active := map[string]bool{}
+	for _, routes := range t {
+		for _, r := range routes {
+			for _, tg := range r.Targets {
+				active[tg.TimerName] = true
+				registered[tg.TimerName] = true
+			}
+		}
+	}
+	for _, name := range ServiceRegistry.Names() {
+		registered[name] = true
+	}
+
+	// record removed targets and forget the ones which came back
+	for name := range registered {
+		if active[name] {
+			delete(removed, name)
+			continue
+		}
+		if _, ok := removed[name]; !ok {
+			removed[name] = now
+		}
+	}
+
+	var next time.Duration
+	for name, at := range removed {
+		if wait := MetricsGCGrace - now.Sub(at); wait > 0 {
+			if next == 0 || wait < next {
+				next = wait
+			}
+			continue
+		}
+		unregisterTarget(name)
+		delete(removed, name)
+		delete(registered, name)
+	}
+
+	// collect the remaining targets after their grace period even
+	// if the routing table does not change.
+	if next > 0 && gcTimer == nil {
+		gcTimer = time.AfterFunc(next, func() {
+			mu.Lock()
+			defer mu.Unlock()
+			gcTimer = nil
+			syncRegistry(GetTable())
+		})
+	}
+}
+
+// unregisterTarget unregisters all metrics of the target with the
+// given timer name.
+func unregisterTarget(name string) {
+	ServiceRegistry.Unregister(name)
+	for _, suffix := range targetMetrics {
+		metrics.DefaultRegistry.Unregister(name + suffix)
+	}
+	log.Printf("[INFO] Unregistered metrics of %s", name)
+}
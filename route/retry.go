@@ -0,0 +1,117 @@
+package route
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RetryPolicy describes when a failed request is retried
+// on another target of the same route.
+type RetryPolicy struct {
+	// Retries is the maximum number of retries of a request.
+	// Requests are not retried if the value is 0.
+	Retries int
+
+	// On contains the conditions on which a request is retried.
+	// Valid conditions are '5xx', 'gateway-error', 'connect-failure',
+	// 'reset' and the numeric status codes between 500 and 599.
+	On map[string]bool
+
+	// Backoff is the time to wait before the first retry.
+	// The time is doubled for every further retry.
+	Backoff time.Duration
+}
+
+// defaultRetryOn is the retry condition if the retryon option is not set.
+const defaultRetryOn = "connect-failure"
+
+// parseRetryPolicy parses the retries, retryon and retrybackoff options.
+func parseRetryPolicy(opts map[string]string) (p RetryPolicy, err error) {
+	if opts["retries"] == "" {
+		return p, nil
+	}
+
+	p.Retries, err = strconv.Atoi(opts["retries"])
+	if err != nil || p.Retries < 0 {
+		return RetryPolicy{}, fmt.Errorf("retries should be a positive number. Got: %s", opts["retries"])
+	}
+
+	on := opts["retryon"]
+	if on == "" {
+		on = defaultRetryOn
+	}
+	p.On = map[string]bool{}
+	for _, c := range strings.Split(on, ",") {
+		c = strings.TrimSpace(c)
+		switch c {
+		case "5xx", "gateway-error", "connect-failure", "reset":
+		default:
+			n, err := strconv.Atoi(c)
+			if err != nil || n < 500 || n > 599 {
+				return RetryPolicy{}, fmt.Errorf("invalid retry condition %q", c)
+			}
+		}
+		p.On[c] = true
+	}
+
+	if v := opts["retrybackoff"]; v != "" {
+		p.Backoff, err = time.ParseDuration(v)
+		if err != nil || p.Backoff < 0 {
+			return RetryPolicy{}, fmt.Errorf("retrybackoff should be a positive duration. Got: %s", v)
+		}
+	}
+	return p, nil
+}
+
+// RetryStatus returns true if a response with the given
+// status code should be retried.
+func (p RetryPolicy) RetryStatus(code int) bool {
+	switch {
+	case p.Retries == 0 || code < 500 || code > 599:
+		return false
+	case p.On["5xx"]:
+		return true
+	case p.On["gateway-error"] && (code == 502 || code == 503 || code == 504):
+		return true
+	default:
+		return p.On[strconv.Itoa(code)]
+	}
+}
+
+// RetryError returns true if a request which failed with
+// a connection error should be retried. connect is true
+// if the connection to the target could not be established.
+func (p RetryPolicy) RetryError(connect bool) bool {
+	if p.Retries == 0 {
+		return false
+	}
+	if connect {
+		return p.On["connect-failure"]
+	}
+	return p.On["reset"]
+}
+
+// Wait returns the time to wait before the n-th retry.
+func (p RetryPolicy) Wait(n int) time.Duration {
+	if n < 1 {
+		return 0
+	}
+	return p.Backoff << uint(n-1)
+}
+
+// RetryTarget returns another target of the route of t which is not in
+// failed for retrying a request or nil if there is none. The target is
+// selected with the picker of the given strategy.
+func (t *Target) RetryTarget(strategy string, failed []*Target) *Target {
+	r := t.route
+	if r == nil {
+		return nil
+	}
+	pick := Picker[strategy]
+	if pick == nil {
+		pick = rrPicker
+	}
+	return r.pickExcept(pick, failed)
+}
@@ -0,0 +1,77 @@
+package route
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestParseRetryPolicy(t *testing.T) {
+	for _, opts := range []map[string]string{
+		{"retries": "x"},
+		{"retries": "-1"},
+		{"retries": "1", "retryon": "4xx"},
+		{"retries": "1", "retryon": "404"},
+		{"retries": "1", "retrybackoff": "1"},
+	} {
+		if _, err := parseRetryPolicy(opts); err == nil {
+			t.Errorf("%v: got nil want error", opts)
+		}
+	}
+
+	p, err := parseRetryPolicy(map[string]string{"retries": "3", "retryon": "gateway-error, 500", "retrybackoff": "50ms"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := p.Retries, 3; got != want {
+		t.Fatalf("got %d retries want %d", got, want)
+	}
+	for code, want := range map[int]bool{404: false, 500: true, 501: false, 502: true, 503: true, 504: true} {
+		if got := p.RetryStatus(code); got != want {
+			t.Errorf("%d: got %v want %v", code, got, want)
+		}
+	}
+	if p.RetryError(true) || p.RetryError(false) {
+		t.Error("got retry on error want none")
+	}
+	for n, want := range []time.Duration{0, 50 * time.Millisecond, 100 * time.Millisecond, 200 * time.Millisecond} {
+		if got := p.Wait(n); got != want {
+			t.Errorf("wait %d: got %v want %v", n, got, want)
+		}
+	}
+
+	// retry on connect failures by default
+	p, err = parseRetryPolicy(map[string]string{"retries": "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.RetryError(true) || p.RetryError(false) || p.RetryStatus(503) {
+		t.Errorf("got %v want retry on connect-failure only", p.On)
+	}
+}
+
+func TestRetryTarget(t *testing.T) {
+	tbl, err := NewTable(bytes.NewBufferString(`
+		route add svc / http://foo:1 opts "retries=2"
+		route add svc / http://foo:2 opts "retries=2"
+		route add svc / http://foo:3 opts "retries=2"
+	`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := tbl[""][0]
+	t1, t2, t3 := r.Targets[0], r.Targets[1], r.Targets[2]
+
+	for i := 0; i < 10; i++ {
+		got := t1.RetryTarget("rr", []*Target{t1})
+		if got != t2 && got != t3 {
+			t.Fatalf("got %v want foo:2 or foo:3", got.URL)
+		}
+		if got, want := t1.RetryTarget("rnd", []*Target{t1, t3}), t2; got != want {
+			t.Fatalf("got %v want %v", got.URL, want.URL)
+		}
+	}
+	if got := t1.RetryTarget("rr", []*Target{t1, t2, t3}); got != nil {
+		t.Fatalf("got %v want nil", got.URL)
+	}
+}
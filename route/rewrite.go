@@ -0,0 +1,105 @@
+package route
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// PathRewrite replaces the path of the requests to a target
+// with a regular expression.
+type PathRewrite struct {
+	// Pattern is the regular expression which is matched
+	// against the path.
+	Pattern *regexp.Regexp
+
+	// Replacement is the new path which can reference the
+	// submatches of the pattern with $1 or ${name}.
+	Replacement string
+
+	// Query enables matching the pattern against the path and
+	// the query string separated by a '?'.
+	Query bool
+}
+
+// maxRewriteCache is the maximum number of compiled
+// patterns in the rewrite cache.
+const maxRewriteCache = 1000
+
+// rewriteCache contains the compiled patterns of the rewrite and the
+// other regular expression options so that they are not compiled again when the routing table is
+// rebuilt after a registry update.
+var rewriteCache = struct {
+	sync.Mutex
+	m map[string]*regexp.Regexp
+}{m: map[string]*regexp.Regexp{}}
+
+// compileRewrite returns the compiled pattern from the rewrite cache.
+func compileRewrite(pattern string) (*regexp.Regexp, error) {
+	rewriteCache.Lock()
+	defer rewriteCache.Unlock()
+	if re, ok := rewriteCache.m[pattern]; ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if len(rewriteCache.m) >= maxRewriteCache {
+		rewriteCache.m = map[string]*regexp.Regexp{}
+	}
+	rewriteCache.m[pattern] = re
+	return re, nil
+}
+
+// parsePathRewrite parses the rewrite and rewritequery options. The
+// rewrite option contains the pattern and the replacement separated
+// by a space, e.g. 'rewrite=^/old/(.*) /new/$1'. It returns nil if
+// the rewrite option is not set.
+func parsePathRewrite(opts map[string]string) (*PathRewrite, error) {
+	v := opts["rewrite"]
+	if v == "" {
+		return nil, nil
+	}
+	p := strings.Fields(v)
+	if len(p) != 2 {
+		return nil, fmt.Errorf("rewrite should be a pattern and a replacement separated by a space. Got: %s", v)
+	}
+	re, err := compileRewrite(p[0])
+	if err != nil {
+		return nil, fmt.Errorf("rewrite has an invalid pattern. %s", err)
+	}
+	rw := &PathRewrite{Pattern: re, Replacement: p[1]}
+	switch opts["rewritequery"] {
+	case "", "false":
+	case "true":
+		rw.Query = true
+	default:
+		return nil, fmt.Errorf("rewritequery should be true or false. Got: %s", opts["rewritequery"])
+	}
+	return rw, nil
+}
+
+// Rewrite returns the path and the query after replacing all matches
+// of the pattern. The query is only rewritten if Query is set. The
+// returned path is always absolute.
+func (rw *PathRewrite) Rewrite(path, query string) (string, string) {
+	if !rw.Query {
+		path = rw.Pattern.ReplaceAllString(path, rw.Replacement)
+	} else {
+		s := path
+		if query != "" {
+			s += "?" + query
+		}
+		s = rw.Pattern.ReplaceAllString(s, rw.Replacement)
+		path, query = s, ""
+		if i := strings.IndexByte(s, '?'); i >= 0 {
+			path, query = s[:i], s[i+1:]
+		}
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return path, query
+}
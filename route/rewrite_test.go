@@ -0,0 +1,68 @@
+package route
+
+import (
+	"testing"
+)
+
+func TestParsePathRewrite(t *testing.T) {
+	for _, opts := range []map[string]string{
+		{"rewrite": "^/old/(.*)"},
+		{"rewrite": "^/old/(.* /new/$1"},
+		{"rewrite": "^/old /new /x"},
+		{"rewrite": "^/old /new", "rewritequery": "yes"},
+	} {
+		if _, err := parsePathRewrite(opts); err == nil {
+			t.Errorf("%v: got nil want error", opts)
+		}
+	}
+
+	if rw, err := parsePathRewrite(map[string]string{"rewritequery": "true"}); rw != nil || err != nil {
+		t.Fatalf("got %v, %v want nil, nil", rw, err)
+	}
+
+	a, err := parsePathRewrite(map[string]string{"rewrite": "^/old/(.*) /new/$1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := parsePathRewrite(map[string]string{"rewrite": "^/old/(.*) /other/$1", "rewritequery": "true"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Pattern != b.Pattern {
+		t.Fatal("pattern was compiled twice")
+	}
+	if a.Query || !b.Query {
+		t.Fatalf("got query %v, %v want false, true", a.Query, b.Query)
+	}
+}
+
+func TestPathRewrite(t *testing.T) {
+	tests := []struct {
+		desc, rewrite   string
+		query           bool
+		path, q         string
+		wantPath, wantQ string
+	}{
+		{"no match", "^/old/(.*) /new/$1", false, "/foo", "a=1", "/foo", "a=1"},
+		{"path", "^/old/(.*) /new/$1", false, "/old/a/b", "a=1", "/new/a/b", "a=1"},
+		{"named group", "^/users/(?P<id>\\d+) /u/${id}", false, "/users/42", "", "/u/42", ""},
+		{"relative result", "^/old/ x/", false, "/old/a", "", "/x/a", ""},
+		{"query", "^/old\\?id=(\\d+)$ /new/$1", true, "/old", "id=7", "/new/7", ""},
+		{"query added", "^/old/(\\w+)$ /new?name=$1", true, "/old/bob", "", "/new", "name=bob"},
+		{"query kept", "^/old/ /new/", true, "/old/a", "b=1", "/new/a", "b=1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			rw, err := parsePathRewrite(map[string]string{"rewrite": tt.rewrite})
+			if err != nil {
+				t.Fatal(err)
+			}
+			rw.Query = tt.query
+			path, q := rw.Rewrite(tt.path, tt.q)
+			if path != tt.wantPath || q != tt.wantQ {
+				t.Fatalf("got %q, %q want %q, %q", path, q, tt.wantPath, tt.wantQ)
+			}
+		})
+	}
+}
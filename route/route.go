@@ -3,13 +3,17 @@ package route
 import (
 	"fmt"
 	"log"
+	"net/http"
 	"net/url"
 	"reflect"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/fabiolb/fabio/metrics"
+	"github.com/fabiolb/fabio/proxy/proxyproto"
 	"github.com/gobwas/glob"
 )
 
@@ -31,18 +35,40 @@ type Route struct {
 	// Targets contains the list of URLs
 	Targets []*Target
 
-	// wTargets contains targets distributed according to their weight
+	// wTargets contains the targets which receive traffic.
 	wTargets []*Target
 
+	// thresholds contains the upper bounds of the intervals of the
+	// targets in wTargets on [0, maxPoint). The size of an interval
+	// is proportional to the weight of the target. thresholds is nil
+	// if all targets have the same weight.
+	thresholds []uint64
+
 	// total contains the total number of requests for this route.
 	// Used by the RRPicker
 	total uint64
 
+	// canaries contains the targets which only receive the requests
+	// which match their conditions.
+	canaries []*Target
+
+	// canaryTotal contains the number of requests for the canaries.
+	canaryTotal uint64
+
+	// ring is the hash ring of the hash picker. It is created on
+	// first use and reset when the weights of the targets change.
+	ring     *hashRing
+	ringOnce *sync.Once
+
 	// Glob represents compiled pattern.
 	Glob glob.Glob
+
+	// Default marks a catch-all route which receives all requests
+	// for its host that do not match any other route.
+	Default bool
 }
 
-func (r *Route) addTarget(service string, targetURL *url.URL, fixedWeight float64, tags []string, opts map[string]string) {
+func (r *Route) addTarget(service string, targetURL *url.URL, fixedWeight float64, tags []string, opts map[string]string) *Target {
 	if fixedWeight < 0 {
 		fixedWeight = 0
 	}
@@ -50,11 +76,16 @@ func (r *Route) addTarget(service string, targetURL *url.URL, fixedWeight float6
 	// de-dup existing target
 	for _, t := range r.Targets {
 		if t.Service == service && t.URL.String() == targetURL.String() && t.FixedWeight == fixedWeight && reflect.DeepEqual(t.Tags, tags) {
-			return
+			return t
 		}
 	}
 
-	name, err := metrics.TargetName(service, r.Host, r.Path, targetURL)
+	annotations, err := parseAnnotations(opts)
+	if err != nil {
+		log.Printf("[ERROR] %s", err)
+	}
+
+	name, err := metrics.TargetName(service, r.Host, r.Path, targetURL, annotations)
 	if err != nil {
 		log.Printf("[ERROR] Invalid metrics name: %s", err)
 		name = "unknown"
@@ -64,10 +95,12 @@ func (r *Route) addTarget(service string, targetURL *url.URL, fixedWeight float6
 		Service:     service,
 		Tags:        tags,
 		Opts:        opts,
+		Annotations: annotations,
 		URL:         targetURL,
 		FixedWeight: fixedWeight,
-		Timer:       ServiceRegistry.GetTimer(name),
+		Timer:       metrics.GetTimer(ServiceRegistry, name, annotations),
 		TimerName:   name,
+		route:       r,
 	}
 
 	if opts != nil {
@@ -75,7 +108,35 @@ func (r *Route) addTarget(service string, targetURL *url.URL, fixedWeight float6
 		t.PrependPath = opts["prepend"]
 		t.TLSSkipVerify = opts["tlsskipverify"] == "true"
 		t.Host = opts["host"]
-		t.ProxyProto = opts["pxyproto"] == "true"
+		if v := opts["pxyproto"]; v != "" && v != "false" {
+			n, err := proxyproto.ParseVersion(v)
+			if err != nil {
+				log.Printf("[ERROR] pxyproto should be true, v1 or v2. Got: %s", v)
+			} else {
+				t.ProxyProto = n
+			}
+		}
+		t.HTTP2 = opts["proto"] == "h2" || opts["proto"] == "h2c"
+		t.Capture = opts["capture"] == "true"
+		t.Backup = opts["backup"] == "true"
+
+		if opts["capturebody"] != "" {
+			n, err := strconv.Atoi(opts["capturebody"])
+			if err != nil || n < 0 {
+				log.Printf("[ERROR] capturebody should be a positive number of bytes. Got: %s", opts["capturebody"])
+			} else {
+				t.Capture, t.CaptureBody = true, n
+			}
+		}
+
+		if opts["dscp"] != "" {
+			n, err := strconv.Atoi(opts["dscp"])
+			if err != nil || n < 0 || n > 63 {
+				log.Printf("[ERROR] dscp should be a number between 0 and 63. Got: %s", opts["dscp"])
+			} else {
+				t.DSCP = n
+			}
+		}
 
 		if opts["redirect"] != "" {
 			t.RedirectCode, err = strconv.Atoi(opts["redirect"])
@@ -93,10 +154,145 @@ func (r *Route) addTarget(service string, targetURL *url.URL, fixedWeight float6
 		}
 
 		t.AuthScheme = opts["auth"]
+
+		if t.Retry, err = parseRetryPolicy(opts); err != nil {
+			log.Printf("[ERROR] %s", err)
+		}
+
+		if opts["ratelimit"] != "" {
+			if t.RateLimit, err = r.rateLimiter(opts); err != nil {
+				log.Printf("[ERROR] %s", err)
+			}
+		}
+
+		if t.Sticky, err = parseSticky(opts); err != nil {
+			log.Printf("[ERROR] %s", err)
+		} else if t.Sticky != nil {
+			t.stickyID = stickyID(t)
+		}
+
+		if t.LocationRewrite, err = parseLocationRewrite(opts); err != nil {
+			log.Printf("[ERROR] %s", err)
+		}
+
+		if t.CookieRewrite, err = parseCookieRewrite(opts); err != nil {
+			log.Printf("[ERROR] %s", err)
+		}
+
+		if t.PathRewrite, err = parsePathRewrite(opts); err != nil {
+			log.Printf("[ERROR] %s", err)
+		}
+
+		if t.RequestHeaders, err = parseHeaderOps(opts, "reqhdr"); err != nil {
+			log.Printf("[ERROR] %s", err)
+		}
+
+		if t.ResponseHeaders, err = parseHeaderOps(opts, "resphdr"); err != nil {
+			log.Printf("[ERROR] %s", err)
+		}
+
+		if t.Compression, err = parseCompression(opts); err != nil {
+			log.Printf("[ERROR] %s", err)
+		}
+
+		if t.GRPCTLS, err = parseGRPCTLS(opts); err != nil {
+			log.Printf("[ERROR] %s", err)
+		}
+
+		if t.BodyRewrite, err = parseBodyRewrite(opts); err != nil {
+			log.Printf("[ERROR] %s", err)
+		}
+
+		if t.Cache, err = parseCachePolicy(opts); err != nil {
+			log.Printf("[ERROR] %s", err)
+		}
+
+		if t.CORS, err = parseCORS(opts); err != nil {
+			log.Printf("[ERROR] %s", err)
+		}
+
+		if t.ErrorBody, err = parseErrorBody(opts); err != nil {
+			log.Printf("[ERROR] %s", err)
+		}
+
+		if t.Canary, err = parseCanary(opts); err != nil {
+			log.Printf("[ERROR] Skipping canary condition. %s", err)
+		}
+
+		if v := opts["maxtimeout"]; v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 {
+				log.Printf("[ERROR] maxtimeout should be a positive duration. Got: %s", v)
+			} else {
+				t.MaxTimeout = d
+			}
+		}
+
+		if t.HealthCheck, err = parseHealthCheck(opts); err != nil {
+			log.Printf("[ERROR] %s", err)
+		}
+		if t.Region, t.Failover, err = parseFailover(opts); err != nil {
+			log.Printf("[ERROR] %s", err)
+		}
+		if t.MaxBody, err = parseMaxBody(opts); err != nil {
+			log.Printf("[ERROR] %s", err)
+		}
+		if t.Mirror, err = parseMirror(opts); err != nil {
+			log.Printf("[ERROR] %s", err)
+		}
+
+		if v, ok := opts["middleware"]; ok {
+			t.Middleware = parseMiddleware(v)
+		}
 	}
 
 	r.Targets = append(r.Targets, t)
 	r.weighTargets()
+	return t
+}
+
+// pickTarget returns one of the targets of the route or nil if the
+// route has no targets for the request. Requests which match the
+// conditions of a canary target are routed to the canary.
+func (r *Route) pickTarget(pick picker, req *http.Request) *Target {
+	if t := r.pickCanary(req); t != nil {
+		return t
+	}
+	switch len(r.wTargets) {
+	case 0:
+		return nil
+	case 1:
+		return r.wTargets[0]
+	default:
+		return pick(r, req)
+	}
+}
+
+// pickExcept picks a target of the route which is not in exclude.
+// It returns nil if all targets which receive traffic are excluded.
+func (r *Route) pickExcept(pick picker, exclude []*Target) *Target {
+	excluded := func(t *Target) bool {
+		for _, x := range exclude {
+			if x == t {
+				return true
+			}
+		}
+		return false
+	}
+	if len(r.wTargets) == 0 {
+		return nil
+	}
+	for i := 0; i < len(r.wTargets); i++ {
+		if t := pick(r, nil); !excluded(t) {
+			return t
+		}
+	}
+	for _, t := range r.wTargets {
+		if !excluded(t) {
+			return t
+		}
+	}
+	return nil
 }
 
 func (r *Route) filter(skip func(t *Target) bool) {
@@ -158,6 +354,21 @@ func contains(src, dst []string) bool {
 	return true
 }
 
+// parseMiddleware parses the comma separated list of the middleware
+// option. The value 'none' disables all middlewares.
+func parseMiddleware(s string) []string {
+	names := []string{}
+	if s == "none" {
+		return names
+	}
+	for _, name := range strings.Split(s, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
 func (r *Route) TargetConfig(t *Target, addWeight bool) string {
 	s := fmt.Sprintf("route add %s %s %s", t.Service, r.Host+r.Path, t.URL)
 	if addWeight {
@@ -189,7 +400,7 @@ func (r *Route) TargetConfig(t *Target, addWeight bool) string {
 func (r *Route) config(addWeight bool) []string {
 	var cfg []string
 	for _, t := range r.Targets {
-		if t.Weight <= 0 {
+		if t.Weight <= 0 && !t.Backup && !t.Unhealthy() {
 			continue
 		}
 		cfg = append(cfg, r.TargetConfig(t, addWeight))
@@ -197,12 +408,6 @@ func (r *Route) config(addWeight bool) []string {
 	return cfg
 }
 
-// maxSlots defines the maximum number of slots on the ring for
-// weighted round-robin distribution for a single route. Consequently,
-// this then defines the maximum number of separate instances that can
-// serve a single route. maxSlots must be a power of ten.
-const maxSlots = 1e4 // 10000
-
 // weighTargets computes the share of traffic each target receives based
 // on its weight and the weight of the other targets.
 //
@@ -211,11 +416,66 @@ const maxSlots = 1e4 // 10000
 //
 // Targets with a dynamic weight will receive an equal share of the remaining
 // traffic if there is any left.
+//
+// Backup targets do not receive any traffic unless all targets of the route
+// are backup targets.
+//
+// Canary targets only receive the requests which match their conditions
+// and have no weight.
 func (r *Route) weighTargets() {
+	r.ring, r.ringOnce = nil, new(sync.Once)
+
+	var targets, primary, backups []*Target
+	r.canaries = nil
+	for _, t := range r.Targets {
+		t.Weight = 0
+		t.Backups = nil
+		if t.Canary != nil {
+			r.canaries = append(r.canaries, t)
+		} else {
+			targets = append(targets, t)
+		}
+	}
+	if len(targets) == 0 {
+		r.wTargets, r.thresholds = nil, nil
+		return
+	}
+
+	// services which fail over between regions only
+	// receive traffic in their active region
+	targets = failoverTargets(targets)
+
+	for _, t := range targets {
+		if t.Backup {
+			backups = append(backups, t)
+		} else {
+			primary = append(primary, t)
+		}
+	}
+	if len(primary) == 0 {
+		primary, backups = targets, nil
+	}
+
+	// targets which fail their health check are removed from the
+	// selection. The healthy backups take over if all primary targets
+	// fail and the route keeps all targets if none of them is healthy.
+	if healthy := healthyTargets(primary); len(healthy) > 0 {
+		primary = healthy
+	} else if healthy := healthyTargets(backups); len(healthy) > 0 {
+		primary, backups = healthy, nil
+	}
+
+	// targets whose requests failed too often are ejected by the
+	// outlier detection. Only a share of the targets is removed.
+	primary = ejectOutliers(primary)
+	for _, t := range primary {
+		t.Backups = backups
+	}
+
 	// how big is the fixed weighted traffic?
 	var nFixed int
 	var sumFixed float64
-	for _, t := range r.Targets {
+	for _, t := range primary {
 		if t.FixedWeight > 0 {
 			nFixed++
 			sumFixed += t.FixedWeight
@@ -223,102 +483,125 @@ func (r *Route) weighTargets() {
 	}
 
 	// if there are no targets with fixed weight then each target simply gets
-	// an equal amount of traffic
-	if nFixed == 0 {
-		w := 1.0 / float64(len(r.Targets))
-		for _, t := range r.Targets {
+	// an equal amount of traffic unless some of them are flapping or
+	// recovering from an ejection
+	if nFixed == 0 && !hasFlapping(primary) && !hasOutliers(primary) {
+		w := 1.0 / float64(len(primary))
+		for _, t := range primary {
 			t.Weight = w
 		}
-		r.wTargets = r.Targets
+		r.wTargets, r.thresholds = primary, nil
 		return
 	}
 
 	// normalize fixed weights up (sumFixed < 1) or down (sumFixed > 1)
 	scale := 1.0
-	if sumFixed > 1 || (nFixed == len(r.Targets) && sumFixed < 1) {
+	if sumFixed > 1 || (nFixed == len(primary) && sumFixed < 1) {
 		scale = 1 / sumFixed
 	}
 
 	// compute the weight for the targets with dynamic weights
-	dynamic := (1 - sumFixed) / float64(len(r.Targets)-nFixed)
+	dynamic := (1 - sumFixed) / float64(len(primary)-nFixed)
 	if dynamic < 0 {
 		dynamic = 0
 	}
 
 	// assign the actual weight to each target
-	for _, t := range r.Targets {
+	for _, t := range primary {
 		if t.FixedWeight > 0 {
 			t.Weight = t.FixedWeight * scale
 		} else {
 			t.Weight = dynamic
 		}
 	}
-
-	// distribute the targets on a ring suitable for weighted round-robin
-	// distribution
-	//
-	// This is done in two steps:
-	//
-	// Step one determines the necessary ring size to distribute the targets
-	// according to their weight with reasonable accuracy. For example, two
-	// targets with 50% weight fit in a ring of size 2 whereas two targets with
-	// 10% and 90% weight require a ring of size 10.
-	//
-	// To keep it simple we allocate 10000 slots which provides slots to all
-	// targets with at least a weight of 0.01%. In addition, we guarantee that
-	// every target with a weight > 0 gets at least one slot. The case where
-	// all targets get an equal share of traffic is handled earlier so this is
-	// for situations with some fixed weight.
-	//
-	// Step two distributes the targets onto the ring spacing them out evenly
-	// so that iterating over the ring performs the weighted rr distribution.
-	// For example, a 50/50 distribution on a ring of size 10 should be
-	// 0101010101 instead of 0000011111.
-	//
-	// To ensure that targets with smaller weights are properly placed we place
-	// them on the ring first by sorting the targets by slot count.
-	//
-	// TODO(fs): I assume that this is some sort of mathematical problem
-	// (coloring, optimizing, ...) but I don't know which. Happy to make this
-	// more formal, if possible.
-	//
-	slots := make(byN, len(r.Targets))
-	usedSlots := 0
-	for i, t := range r.Targets {
-		n := int(float64(maxSlots) * t.Weight)
-		if n == 0 && t.Weight > 0 {
-			n = 1
-		}
-		slots[i].i = i
-		slots[i].n = n
-		usedSlots += n
-	}
-
-	sort.Sort(slots)
-	targets := make([]*Target, usedSlots)
-	for _, s := range slots {
-		if s.n <= 0 {
+	decayFlapping(primary)
+	rampOutliers(primary)
+
+	// assign each target an interval on [0, maxPoint) whose size is
+	// proportional to its weight. The pickers map a request to a point
+	// and pick the target whose interval contains the point. Unlike a
+	// ring with a fixed number of slots this honors arbitrarily small
+	// weights and large numbers of targets with O(n) memory.
+	var sum float64
+	for _, t := range primary {
+		sum += t.Weight
+	}
+	var acc float64
+	var prev uint64
+	r.wTargets, r.thresholds = nil, nil
+	for _, t := range primary {
+		if t.Weight <= 0 {
 			continue
 		}
+		acc += t.Weight
+		th := uint64(acc / sum * maxPoint)
+		if th <= prev {
+			// the weight is too small to be represented
+			continue
+		}
+		r.wTargets = append(r.wTargets, t)
+		r.thresholds = append(r.thresholds, th)
+		prev = th
+	}
+	if n := len(r.thresholds); n > 0 {
+		r.thresholds[n-1] = maxPoint
+	}
+}
 
-		next, step := 0, usedSlots/s.n
-		for k := 0; k < s.n; k++ {
-			// find the next empty slot
-			for targets[next] != nil {
-				next = (next + 1) % usedSlots
-			}
+// maxPoint is the upper bound of the points the targets
+// are picked with. Points have 53 bits so that they can be
+// converted from and to float64 without loss of precision.
+const maxPoint = 1 << 53
 
-			// use slot and move to next one
-			targets[next] = r.Targets[s.i]
-			next = (next + step) % usedSlots
-		}
+// golden is 2^64 divided by the golden ratio. The multiples of golden
+// modulo 2^64 are a low discrepancy sequence which spreads consecutive
+// requests evenly over the targets according to their weight.
+const golden = 0x9E3779B97F4A7C15
+
+// point returns the point for the n-th request.
+func point(n uint64) uint64 {
+	return (n * golden) >> 11
+}
+
+// pick returns the target for the n-th request.
+func (r *Route) pick(n uint64) *Target {
+	if r.thresholds == nil {
+		return r.wTargets[n%uint64(len(r.wTargets))]
 	}
+	p := point(n)
+	i := sort.Search(len(r.thresholds), func(i int) bool { return r.thresholds[i] > p })
+	return r.wTargets[i]
+}
+
+// Share describes the share of the traffic of a route which a target receives.
+type Share struct {
+	Target *Target
 
-	r.wTargets = targets
+	// Weight is the share of the traffic according to the weights.
+	Weight float64
+
+	// Effective is the share of the first n requests which the
+	// round-robin picker sends to the target.
+	Effective float64
 }
 
-type byN []struct{ i, n int }
+// Shares returns the shares of the targets of the route computed from
+// the first n requests. Targets without traffic are included.
+func (r *Route) Shares(n int) []Share {
+	picks := map[*Target]int{}
+	if len(r.wTargets) > 0 {
+		for k := 0; k < n; k++ {
+			picks[r.pick(uint64(k))]++
+		}
+	}
 
-func (r byN) Len() int           { return len(r) }
-func (r byN) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
-func (r byN) Less(i, j int) bool { return r[i].n < r[j].n }
+	var shares []Share
+	for _, t := range r.Targets {
+		s := Share{Target: t, Weight: t.Weight}
+		if n > 0 {
+			s.Effective = float64(picks[t]) / float64(n)
+		}
+		shares = append(shares, s)
+	}
+	return shares
+}
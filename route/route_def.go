@@ -16,4 +16,7 @@ type RouteDef struct {
 	Weight  float64           `json:"weight"`
 	Tags    []string          `json:"tags,omitempty"`
 	Opts    map[string]string `json:"opts,omitempty"`
+
+	// Source is the name of the config source of the command.
+	Source string `json:"source,omitempty"`
 }
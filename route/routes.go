@@ -4,9 +4,10 @@ package route
 type Routes []*Route
 
 // find returns the route with the given path and returns nil if none was found.
-func (rt Routes) find(path string) *Route {
+// def selects between the regular and the default routes.
+func (rt Routes) find(path string, def bool) *Route {
 	for _, r := range rt {
-		if r.Path == path {
+		if r.Path == path && r.Default == def {
 			return r
 		}
 	}
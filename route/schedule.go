@@ -0,0 +1,157 @@
+package route
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// timeNow is stubbed out for testing.
+var timeNow = time.Now
+
+// Schedule is a list of weekly time windows in which
+// a target is active.
+type Schedule struct {
+	windows []window
+	loc     *time.Location
+}
+
+// window is active on the given weekdays between from and to which
+// are minutes since midnight. If to is not after from the window
+// ends on the next day.
+type window struct {
+	days     [7]bool
+	from, to int
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// ParseSchedule parses the value of the 'active' and 'tz' route
+// options. active is a comma separated list of time windows of the
+// form
+//
+//	[<day>[-<day>]] [<hh:mm>-<hh:mm>]
+//
+// e.g. "Mon-Fri 08:00-18:00,Sat 10:00-14:00". A window without days
+// is active every day and a window without a time range is active
+// the whole day. A time range which ends before it starts, e.g.
+// "22:00-06:00", ends on the next day. tz is the name of the time
+// zone of the windows and defaults to the local time zone.
+func ParseSchedule(active, tz string) (*Schedule, error) {
+	s := &Schedule{loc: time.Local}
+	if tz != "" {
+		loc, err := time.LoadLocation(tz)
+		if err != nil {
+			return nil, fmt.Errorf("route: invalid time zone %q. %s", tz, err)
+		}
+		s.loc = loc
+	}
+
+	for _, w := range strings.Split(active, ",") {
+		f := strings.Fields(w)
+		if len(f) == 0 || len(f) > 2 {
+			return nil, fmt.Errorf("route: invalid time window %q", strings.TrimSpace(w))
+		}
+
+		win := window{from: 0, to: 24 * 60}
+		days, times := "", ""
+		switch {
+		case len(f) == 2:
+			days, times = f[0], f[1]
+		case strings.Contains(f[0], ":"):
+			times = f[0]
+		default:
+			days = f[0]
+		}
+
+		if days == "" {
+			for i := range win.days {
+				win.days[i] = true
+			}
+		} else if err := parseDays(days, &win.days); err != nil {
+			return nil, err
+		}
+
+		if times != "" {
+			p := strings.Split(times, "-")
+			if len(p) != 2 {
+				return nil, fmt.Errorf("route: invalid time range %q", times)
+			}
+			var err error
+			if win.from, err = parseClock(p[0]); err != nil {
+				return nil, err
+			}
+			if win.to, err = parseClock(p[1]); err != nil {
+				return nil, err
+			}
+		}
+		s.windows = append(s.windows, win)
+	}
+	return s, nil
+}
+
+func parseDays(s string, days *[7]bool) error {
+	p := strings.Split(strings.ToLower(s), "-")
+	if len(p) > 2 {
+		return fmt.Errorf("route: invalid days %q", s)
+	}
+	from, ok := weekdays[p[0]]
+	if !ok {
+		return fmt.Errorf("route: invalid day %q", p[0])
+	}
+	to := from
+	if len(p) == 2 {
+		if to, ok = weekdays[p[1]]; !ok {
+			return fmt.Errorf("route: invalid day %q", p[1])
+		}
+	}
+	for d := from; ; d = (d + 1) % 7 {
+		days[d] = true
+		if d == to {
+			return nil
+		}
+	}
+}
+
+// parseClock parses a time of the form hh:mm and returns the
+// minutes since midnight. 24:00 denotes the end of the day.
+func parseClock(s string) (int, error) {
+	p := strings.Split(s, ":")
+	if len(p) == 2 && len(p[1]) == 2 {
+		h, errh := strconv.Atoi(p[0])
+		m, errm := strconv.Atoi(p[1])
+		if errh == nil && errm == nil && h >= 0 && m >= 0 && m < 60 && (h < 24 || h == 24 && m == 0) {
+			return h*60 + m, nil
+		}
+	}
+	return 0, fmt.Errorf("route: invalid time %q", s)
+}
+
+// Active returns true if t is within one of the time windows.
+func (s *Schedule) Active(t time.Time) bool {
+	t = t.In(s.loc)
+	day, min := t.Weekday(), t.Hour()*60+t.Minute()
+	prev := (day + 6) % 7
+	for _, w := range s.windows {
+		if w.from < w.to {
+			if w.days[day] && min >= w.from && min < w.to {
+				return true
+			}
+			continue
+		}
+		// the window ends on the next day
+		if w.days[day] && min >= w.from || w.days[prev] && min < w.to {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,109 @@
+package route
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	for _, s := range []string{"", "Foo", "Mon-Foo", "Mon-Tue-Wed", "Mon 8-18", "Mon 08:00", "Mon 08:60-09:00", "Mon 24:01-09:00", "Mon 08:00-18:00 x"} {
+		if _, err := ParseSchedule(s, ""); err == nil {
+			t.Errorf("%q: got nil want error", s)
+		}
+	}
+	if _, err := ParseSchedule("Mon", "Foo/Bar"); err == nil {
+		t.Error("got nil want error for invalid time zone")
+	}
+}
+
+func TestScheduleActive(t *testing.T) {
+	// 2019-01-07 was a Monday
+	at := func(day int, clock string) time.Time {
+		tm, err := time.ParseInLocation("2006-01-02 15:04", "2019-01-0"+string('0'+rune(day))+" "+clock, time.UTC)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tm
+	}
+	mon, fri, sat, sun := 7, 4, 5, 6
+
+	tests := []struct {
+		active string
+		t      time.Time
+		want   bool
+	}{
+		{"Mon-Fri 08:00-18:00", at(mon, "08:00"), true},
+		{"Mon-Fri 08:00-18:00", at(mon, "17:59"), true},
+		{"Mon-Fri 08:00-18:00", at(mon, "18:00"), false},
+		{"Mon-Fri 08:00-18:00", at(mon, "07:59"), false},
+		{"Mon-Fri 08:00-18:00", at(sat, "12:00"), false},
+		{"Mon-Fri 08:00-18:00,Sat 10:00-14:00", at(sat, "12:00"), true},
+		{"Sat-Sun", at(sun, "23:59"), true},
+		{"Sat-Sun", at(mon, "00:00"), false},
+		{"Fri-Mon", at(sun, "12:00"), true},
+		{"Fri 22:00-06:00", at(fri, "23:00"), true},
+		{"Fri 22:00-06:00", at(sat, "05:59"), true},
+		{"Fri 22:00-06:00", at(sat, "06:00"), false},
+		{"Fri 22:00-06:00", at(sun, "05:00"), false},
+		{"12:00-24:00", at(sun, "23:59"), true},
+		{"12:00-24:00", at(sun, "11:00"), false},
+	}
+	for _, tt := range tests {
+		s, err := ParseSchedule(tt.active, "")
+		if err != nil {
+			t.Fatalf("%q: %v", tt.active, err)
+		}
+		s.loc = time.UTC
+		if got := s.Active(tt.t); got != tt.want {
+			t.Errorf("%q at %s: got %v want %v", tt.active, tt.t.Format("Mon 15:04"), got, tt.want)
+		}
+	}
+}
+
+func TestScheduleTimeZone(t *testing.T) {
+	s, err := ParseSchedule("Mon 08:00-09:00", "America/New_York")
+	if err != nil {
+		t.Skip("time zone database not available: ", err)
+	}
+	// 08:30 in New York is 13:30 UTC in winter
+	if !s.Active(time.Date(2019, 1, 7, 13, 30, 0, 0, time.UTC)) {
+		t.Fatal("got inactive want active")
+	}
+	if s.Active(time.Date(2019, 1, 7, 8, 30, 0, 0, time.UTC)) {
+		t.Fatal("got active want inactive")
+	}
+}
+
+func TestTableActiveRoutes(t *testing.T) {
+	defer func() { timeNow = time.Now }()
+
+	routes := `
+		route add svc /foo http://1.1.1.1:5000/
+		route add maint /foo http://2.2.2.2:5000/ weight 1 opts "active=Sun 02:00-04:00 tz=UTC"
+		route weight maint /foo weight 0.5
+	`
+	build := func(now time.Time) string {
+		timeNow = func() time.Time { return now }
+		tbl, err := NewTable(bytes.NewBufferString(routes))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tbl.String()
+	}
+
+	// the maintenance route gets its weight within its time window
+	// and the weights do not break the table outside of it
+	if got, want := build(time.Date(2019, 1, 6, 3, 0, 0, 0, time.UTC)), `route add svc /foo http://1.1.1.1:5000/
+route add maint /foo http://2.2.2.2:5000/ weight 0.5000 opts "active=Sun 02:00-04:00 tz=UTC"`; got != want {
+		t.Fatalf("got\n%s\nwant\n%s", got, want)
+	}
+	if got, want := build(time.Date(2019, 1, 6, 4, 0, 0, 0, time.UTC)), `route add svc /foo http://1.1.1.1:5000/`; got != want {
+		t.Fatalf("got\n%s\nwant\n%s", got, want)
+	}
+
+	// an invalid time window is an error and not a closed one
+	if _, err := NewTable(bytes.NewBufferString(`route add bad /bar http://3.3.3.3:5000/ opts "active=Foo"`)); err == nil {
+		t.Fatal("got nil want error")
+	}
+}
@@ -0,0 +1,136 @@
+package route
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultStickyCookie is the name of the sticky session cookie
+// if the name option is not set.
+const DefaultStickyCookie = "FABIOSRV"
+
+// StickySecret is the key which signs the sticky session cookies.
+// It is set on startup. If it is empty a random key is used and
+// the cookies become invalid when fabio restarts.
+var StickySecret []byte
+
+var randomSecret struct {
+	once sync.Once
+	key  []byte
+}
+
+// stickySecret returns the key which signs the sticky session cookies.
+func stickySecret() []byte {
+	if len(StickySecret) > 0 {
+		return StickySecret
+	}
+	randomSecret.once.Do(func() {
+		randomSecret.key = make([]byte, 32)
+		if _, err := rand.Read(randomSecret.key); err != nil {
+			panic("route: cannot generate sticky secret: " + err.Error())
+		}
+	})
+	return randomSecret.key
+}
+
+// Sticky describes the session affinity of the targets of a route.
+// Clients receive a cookie which identifies the target and subsequent
+// requests with the cookie are routed to the same target while it
+// receives traffic.
+type Sticky struct {
+	// Cookie is the name of the cookie.
+	Cookie string
+
+	// TTL is the lifetime of the cookie. The expiry is extended
+	// with every response. A value of zero creates a session cookie.
+	TTL time.Duration
+}
+
+// parseSticky parses the sticky, name and ttl options. It returns
+// nil if the sticky option is not set.
+func parseSticky(opts map[string]string) (*Sticky, error) {
+	switch v := opts["sticky"]; v {
+	case "":
+		return nil, nil
+	case "cookie":
+	default:
+		return nil, fmt.Errorf("sticky should be cookie. Got: %s", v)
+	}
+
+	s := &Sticky{Cookie: opts["name"]}
+	if s.Cookie == "" {
+		s.Cookie = DefaultStickyCookie
+	}
+	if strings.ContainsAny(s.Cookie, " \t\"(),/:;<=>?@[\\]{}") {
+		return nil, fmt.Errorf("invalid sticky cookie name %q", s.Cookie)
+	}
+	if v := opts["ttl"]; v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("ttl should be a positive duration. Got: %s", v)
+		}
+		s.TTL = d
+	}
+	return s, nil
+}
+
+// stickyID returns the signed cookie value which identifies t.
+// The value does not reveal the address of the target.
+func stickyID(t *Target) string {
+	mac := hmac.New(sha256.New, stickySecret())
+	io.WriteString(mac, t.Service+" "+t.URL.String())
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// stickyTarget returns the target of the route which is identified by
+// the sticky session cookie of req. It returns nil if the request has
+// no cookie or if the target no longer receives traffic.
+func (r *Route) stickyTarget(req *http.Request, s *Sticky) *Target {
+	c, err := req.Cookie(s.Cookie)
+	if err != nil || c.Value == "" {
+		return nil
+	}
+	for _, t := range r.wTargets {
+		if t.Sticky != nil && hmac.Equal([]byte(t.stickyID), []byte(c.Value)) {
+			return t
+		}
+	}
+	return nil
+}
+
+// SetStickyCookie adds the sticky session cookie for t to the
+// response unless the request already contains it. Cookies with
+// a TTL are renewed with every response.
+func (t *Target) SetStickyCookie(w http.ResponseWriter, r *http.Request) {
+	if t.Sticky == nil {
+		return
+	}
+	if t.Sticky.TTL == 0 {
+		if c, err := r.Cookie(t.Sticky.Cookie); err == nil && c.Value == t.stickyID {
+			return
+		}
+	}
+	c := &http.Cookie{
+		Name:     t.Sticky.Cookie,
+		Value:    t.stickyID,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if t.Sticky.TTL > 0 {
+		c.MaxAge = int(t.Sticky.TTL / time.Second)
+		if c.MaxAge == 0 {
+			c.MaxAge = 1
+		}
+	}
+	http.SetCookie(w, c)
+}
@@ -0,0 +1,114 @@
+package route
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseSticky(t *testing.T) {
+	for _, opts := range []map[string]string{
+		{"sticky": "ip"},
+		{"sticky": "cookie", "name": "a;b"},
+		{"sticky": "cookie", "ttl": "1"},
+		{"sticky": "cookie", "ttl": "-1h"},
+	} {
+		if _, err := parseSticky(opts); err == nil {
+			t.Errorf("%v: got nil want error", opts)
+		}
+	}
+
+	s, err := parseSticky(map[string]string{"sticky": "cookie"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := *s, (Sticky{Cookie: DefaultStickyCookie}); got != want {
+		t.Fatalf("got %v want %v", got, want)
+	}
+
+	s, err = parseSticky(map[string]string{"sticky": "cookie", "name": "SRV", "ttl": "1h"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := *s, (Sticky{Cookie: "SRV", TTL: time.Hour}); got != want {
+		t.Fatalf("got %v want %v", got, want)
+	}
+
+	if s, err := parseSticky(map[string]string{}); s != nil || err != nil {
+		t.Fatalf("got %v, %v want nil, nil", s, err)
+	}
+}
+
+func TestStickyLookup(t *testing.T) {
+	tbl, err := NewTable(bytes.NewBufferString(`
+		route add svc / http://foo:1 opts "sticky=cookie name=SRV ttl=1h"
+		route add svc / http://foo:2 opts "sticky=cookie name=SRV ttl=1h"
+		route add svc / http://foo:3 opts "sticky=cookie name=SRV ttl=1h"
+	`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lookup := func(cookie *http.Cookie) *Target {
+		req := &http.Request{Host: "example.com", URL: mustParse("/"), Header: http.Header{}}
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		return tbl.Lookup(req, "", Picker["rr"], Matcher["prefix"], globCache, false)
+	}
+
+	// the first request receives a cookie for the picked target
+	target := lookup(nil)
+	rec := httptest.NewRecorder()
+	target.SetStickyCookie(rec, &http.Request{Header: http.Header{}})
+	cookies := (&http.Response{Header: rec.Header()}).Cookies()
+	if len(cookies) != 1 || cookies[0].Name != "SRV" || cookies[0].MaxAge != 3600 {
+		t.Fatalf("got cookies %v want SRV with max age 3600", cookies)
+	}
+
+	// subsequent requests go to the same target
+	for i := 0; i < 5; i++ {
+		if got, want := lookup(cookies[0]), target; got != want {
+			t.Fatalf("got %v want %v", got.URL, want.URL)
+		}
+	}
+
+	// requests fail over to another target when it disappears
+	if err := tbl.delRoute(&RouteDef{Service: "svc", Src: "/", Dst: target.URL.String()}); err != nil {
+		t.Fatal(err)
+	}
+	if got := lookup(cookies[0]); got == nil || got.URL.String() == target.URL.String() {
+		t.Fatalf("got %v want other target", got)
+	}
+
+	// invalid cookies are ignored
+	if got := lookup(&http.Cookie{Name: "SRV", Value: "foo:1"}); got == nil {
+		t.Fatal("got nil want target")
+	}
+}
+
+func TestSetStickyCookie(t *testing.T) {
+	tbl, err := NewTable(bytes.NewBufferString(`route add svc / http://foo:1 opts "sticky=cookie"`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := tbl[""][0].Targets[0]
+
+	// session cookies are only set once
+	req := &http.Request{Header: http.Header{}}
+	req.AddCookie(&http.Cookie{Name: DefaultStickyCookie, Value: target.stickyID})
+	rec := httptest.NewRecorder()
+	target.SetStickyCookie(rec, req)
+	if got := rec.Header().Get("Set-Cookie"); got != "" {
+		t.Fatalf("got Set-Cookie %q want none", got)
+	}
+
+	req = &http.Request{Header: http.Header{}}
+	req.AddCookie(&http.Cookie{Name: DefaultStickyCookie, Value: "stale"})
+	rec = httptest.NewRecorder()
+	target.SetStickyCookie(rec, req)
+	if got, want := rec.Header().Get("Set-Cookie"), DefaultStickyCookie+"="+target.stickyID+"; Path=/; HttpOnly; SameSite=Lax"; got != want {
+		t.Fatalf("got Set-Cookie %q want %q", got, want)
+	}
+}
@@ -1,9 +1,11 @@
 package route
 
 import (
+	"bufio"
 	"bytes"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"net/http"
@@ -20,6 +22,7 @@ import (
 var errInvalidPrefix = errors.New("route: prefix must not be empty")
 var errInvalidTarget = errors.New("route: target must not be empty")
 var errNoMatch = errors.New("route: no target match")
+var errInactive = errors.New("route: time window closed")
 
 // table stores the active routing table. Must never be nil.
 var table atomic.Value
@@ -44,48 +47,32 @@ var mu sync.Mutex
 
 // SetTable sets the active routing table. A nil value
 // logs a warning and is ignored. The function is safe
-// to be called from multiple goroutines.
+// to be called from multiple goroutines. The changes to the
+// previous table are recorded in Events.
 func SetTable(t Table) {
 	if t == nil {
 		log.Print("[WARN] Ignoring nil routing table")
 		return
 	}
 	mu.Lock()
-	table.Store(t)
-	syncRegistry(t)
-	mu.Unlock()
-}
-
-// syncRegistry unregisters all inactive timers.
-// It assumes that all timers of the table have
-// already been registered.
-func syncRegistry(t Table) {
-	timers := map[string]bool{}
-
-	// get all registered timers
-	for _, name := range ServiceRegistry.Names() {
-		timers[name] = false
-	}
-
-	// mark the ones from this table as active.
-	// this can also add new entries but we do not
-	// really care since we are only interested in the
-	// inactive ones.
-	for _, routes := range t {
-		for _, r := range routes {
-			for _, tg := range r.Targets {
-				timers[tg.TimerName] = true
+	last := GetTable()
+	flapped := syncFlaps(last, t)
+	if syncFailover(t) || flapped {
+		for _, routes := range t {
+			for _, r := range routes {
+				r.weighTargets()
 			}
 		}
 	}
-
-	// unregister inactive timers
-	for name, active := range timers {
-		if !active {
-			ServiceRegistry.Unregister(name)
-			log.Printf("[INFO] Unregistered timer %s", name)
-		}
+	table.Store(t)
+	syncRegistry(t)
+	syncRateLimiters(t)
+	syncOutliers(t)
+	if delta := Diff(last, t); len(delta) > 0 {
+		Events.Add(delta)
 	}
+	syncChecksum(t)
+	mu.Unlock()
 }
 
 // Table contains a set of routes grouped by host.
@@ -116,20 +103,39 @@ func NewTable(b *bytes.Buffer) (t Table, err error) {
 	}
 
 	t = make(Table)
+	inactive := map[string][]string{}
 	for _, d := range defs {
-		switch d.Cmd {
-		case RouteAddCmd:
-			err = t.addRoute(d)
-		case RouteDelCmd:
-			err = t.delRoute(d)
-		case RouteWeightCmd:
-			err = t.weighRoute(d)
-		default:
-			err = fmt.Errorf("route: invalid command: %s", d.Cmd)
+		if err := t.apply(d, inactive); err != nil {
+			return nil, err
 		}
+	}
+	return t, nil
+}
+
+// ConfigSource is a part of the routing table config
+// and the name of its origin.
+type ConfigSource struct {
+	Name   string
+	Config string
+}
+
+// NewTableSources builds the routing table from the configs of the
+// sources in the given order. The targets remember the name of the
+// source which added them.
+func NewTableSources(srcs ...ConfigSource) (t Table, err error) {
+	t = make(Table)
+	inactive := map[string][]string{}
+	for _, src := range srcs {
+		defs, err := Parse(bytes.NewBufferString(src.Config))
 		if err != nil {
 			return nil, err
 		}
+		for _, d := range defs {
+			d.Source = src.Name
+			if err := t.apply(d, inactive); err != nil {
+				return nil, err
+			}
+		}
 	}
 	return t, nil
 }
@@ -137,24 +143,52 @@ func NewTable(b *bytes.Buffer) (t Table, err error) {
 func NewTableCustom(defs *[]RouteDef) (t Table, err error) {
 
 	t = make(Table)
+	inactive := map[string][]string{}
 	for _, d := range *defs {
-		switch d.Cmd {
-		case RouteAddCmd:
-			err = t.addRoute(&d)
-		case RouteDelCmd:
-			err = t.delRoute(&d)
-		case RouteWeightCmd:
-			err = t.weighRoute(&d)
-		default:
-			err = fmt.Errorf("route: invalid command: %s", d.Cmd)
-		}
-		if err != nil {
+		d := d
+		if err := t.apply(&d, inactive); err != nil {
 			return nil, err
 		}
 	}
 	return t, nil
 }
 
+// apply executes the route command. inactive records the services of
+// the routes which were skipped since their time window is closed.
+// Weights for these routes are ignored, like in ApplyWeights, since
+// otherwise the table could not be built outside of the time window.
+func (t Table) apply(d *RouteDef, inactive map[string][]string) error {
+	switch d.Cmd {
+	case RouteAddCmd:
+		err := t.addRoute(d)
+		if err == errInactive {
+			k := inactiveKey(d.Src)
+			inactive[k] = append(inactive[k], d.Service)
+			return nil
+		}
+		return err
+	case RouteDelCmd:
+		return t.delRoute(d)
+	case RouteWeightCmd:
+		err := t.weighRoute(d)
+		if err == errNoMatch {
+			for _, svc := range inactive[inactiveKey(d.Src)] {
+				if d.Service == "" || d.Service == svc {
+					return nil
+				}
+			}
+		}
+		return err
+	default:
+		return fmt.Errorf("route: invalid command: %s", d.Cmd)
+	}
+}
+
+func inactiveKey(src string) string {
+	host, path := hostpath(src)
+	return strings.ToLower(host) + path
+}
+
 // addRoute adds a new route prefix -> target for the given service.
 func (t Table) addRoute(d *RouteDef) error {
 	host, path := hostpath(d.Src)
@@ -173,6 +207,20 @@ func (t Table) addRoute(d *RouteDef) error {
 		return fmt.Errorf("route: invalid target. %s", err)
 	}
 
+	// targets with a schedule are only added within their time windows.
+	// The routing table is rebuilt when a time window starts or ends.
+	if active, ok := d.Opts["active"]; ok {
+		s, err := ParseSchedule(active, d.Opts["tz"])
+		if err != nil {
+			return err
+		}
+		if !s.Active(timeNow()) {
+			return errInactive
+		}
+	}
+
+	def := isDefault(d.Opts)
+
 	switch {
 	// add new host
 	case t[host] == nil:
@@ -180,29 +228,65 @@ func (t Table) addRoute(d *RouteDef) error {
 		if err != nil {
 			return err
 		}
-		r := &Route{Host: host, Path: path, Glob: g}
-		r.addTarget(d.Service, targetURL, d.Weight, d.Tags, d.Opts)
+		r := &Route{Host: host, Path: path, Glob: g, Default: def}
+		r.addTarget(d.Service, targetURL, d.Weight, d.Tags, d.Opts).setSource(d.Source)
 		t[host] = Routes{r}
 
 	// add new route to existing host
-	case t[host].find(path) == nil:
+	case t[host].find(path, def) == nil:
 		g, err := glob.Compile(path)
 		if err != nil {
 			return err
 		}
-		r := &Route{Host: host, Path: path, Glob: g}
-		r.addTarget(d.Service, targetURL, d.Weight, d.Tags, d.Opts)
+		r := &Route{Host: host, Path: path, Glob: g, Default: def}
+		r.addTarget(d.Service, targetURL, d.Weight, d.Tags, d.Opts).setSource(d.Source)
 		t[host] = append(t[host], r)
 		sort.Sort(t[host])
 
 	// add new target to existing route
 	default:
-		t[host].find(path).addTarget(d.Service, targetURL, d.Weight, d.Tags, d.Opts)
+		t[host].find(path, def).addTarget(d.Service, targetURL, d.Weight, d.Tags, d.Opts).setSource(d.Source)
 	}
 
 	return nil
 }
 
+// ApplyWeights applies the 'route weight' commands in s to the table.
+// Unlike NewTable it ignores commands for routes which do not exist
+// since they are generated and not written by the user.
+func (t Table) ApplyWeights(s string) error {
+	defs, err := Parse(bytes.NewBufferString(s))
+	if err != nil {
+		return err
+	}
+	for _, d := range defs {
+		if d.Cmd != RouteWeightCmd {
+			return fmt.Errorf("route: invalid command: %s", d.Cmd)
+		}
+		if err := t.weighRoute(d); err != nil && err != errNoMatch {
+			return err
+		}
+	}
+	return nil
+}
+
+// FixedWeight returns the sum of the fixed weights of the targets
+// of the service on src which have all of the given tags.
+func (t Table) FixedWeight(service, src string, tags []string) float64 {
+	host, path := hostpath(src)
+	r := t[host].find(path, false)
+	if r == nil {
+		return 0
+	}
+	var w float64
+	for _, tg := range r.Targets {
+		if tg.Service == service && contains(tg.Tags, tags) {
+			w += tg.FixedWeight
+		}
+	}
+	return w
+}
+
 func (t Table) weighRoute(d *RouteDef) error {
 	host, path := hostpath(d.Src)
 
@@ -210,11 +294,13 @@ func (t Table) weighRoute(d *RouteDef) error {
 		return errInvalidPrefix
 	}
 
-	if t[host] == nil || t[host].find(path) == nil {
-		return errNoMatch
+	n := 0
+	for _, r := range t[host] {
+		if r.Path == path {
+			n += r.setWeight(d.Service, d.Weight, d.Tags)
+		}
 	}
-
-	if n := t[host].find(path).setWeight(d.Service, d.Weight, d.Tags); n == 0 {
+	if n == 0 {
 		return errNoMatch
 	}
 	return nil
@@ -248,13 +334,11 @@ func (t Table) delRoute(d *RouteDef) error {
 		}
 
 	case d.Dst == "":
-		r := t.route(hostpath(d.Src))
-		if r == nil {
-			return nil
+		for _, r := range t.routes(hostpath(d.Src)) {
+			r.filter(func(tg *Target) bool {
+				return tg.Service == d.Service
+			})
 		}
-		r.filter(func(tg *Target) bool {
-			return tg.Service == d.Service
-		})
 
 	default:
 		targetURL, err := url.Parse(d.Dst)
@@ -262,13 +346,11 @@ func (t Table) delRoute(d *RouteDef) error {
 			return fmt.Errorf("route: invalid target. %s", err)
 		}
 
-		r := t.route(hostpath(d.Src))
-		if r == nil {
-			return nil
+		for _, r := range t.routes(hostpath(d.Src)) {
+			r.filter(func(tg *Target) bool {
+				return tg.Service == d.Service && tg.URL.String() == targetURL.String()
+			})
 		}
-		r.filter(func(tg *Target) bool {
-			return tg.Service == d.Service && tg.URL.String() == targetURL.String()
-		})
 	}
 
 	// remove all routes without targets
@@ -299,7 +381,25 @@ func (t Table) route(host, path string) *Route {
 	if routes == nil {
 		return nil
 	}
-	return routes.find(path)
+	return routes.find(path, false)
+}
+
+// routes returns the regular and the default route for host/path.
+func (t Table) routes(host, path string) []*Route {
+	var routes []*Route
+	for _, r := range t[host] {
+		if r.Path == path {
+			routes = append(routes, r)
+		}
+	}
+	return routes
+}
+
+// isDefault returns true if the route options mark
+// the target as the default target of its host.
+func isDefault(opts map[string]string) bool {
+	v, ok := opts["default"]
+	return ok && (v == "" || v == "true")
 }
 
 // normalizeHost returns the hostname from the request
@@ -360,6 +460,22 @@ func (t Table) matchingHostNoGlob(req *http.Request) (hosts []string) {
 	return
 }
 
+// HasHost returns true if the table has a route for the host. Host
+// patterns like *.example.com match the host.
+func (t Table) HasHost(host string) bool {
+	host = normalizeHost(host, true)
+	for pattern := range t {
+		g, err := glob.Compile(normalizeHost(pattern, true))
+		if err != nil {
+			continue
+		}
+		if g.Match(host) {
+			return true
+		}
+	}
+	return false
+}
+
 func sortHostsReverseHostPort(hosts []string) []string {
 	// Issue 506: multiple glob patterns hosts in wrong order
 	//
@@ -406,7 +522,9 @@ func ReverseHostPort(s string) string {
 // Lookup finds a target url based on the current matcher and picker
 // or nil if there is none. It first checks the routes for the host
 // and if none matches then it falls back to generic routes without
-// a host. This is useful for a catch-all '/' rule.
+// a host. This is useful for a catch-all '/' rule. If no route
+// matches at all then the default routes of the matching hosts
+// and then the default routes without a host are used.
 func (t Table) Lookup(req *http.Request, trace string, pick picker, match matcher, globCache *GlobCache, globDisabled bool) (target *Target) {
 
 	var hosts []string
@@ -431,8 +549,44 @@ func (t Table) Lookup(req *http.Request, trace string, pick picker, match matche
 		log.Printf("[TRACE] %s Matching hosts: %v", trace, hosts)
 	}
 	hosts = append(hosts, "")
+
+	target = t.lookupHosts(req, hosts, func(h string) *Target {
+		return t.lookup(h, req.URL.Path, trace, pick, match, req)
+	})
+	if target == nil {
+		target = t.lookupHosts(req, hosts, func(h string) *Target {
+			return t.lookupDefault(h, trace, pick, req)
+		})
+	}
+
+	if target != nil && target.Sticky != nil && target.Canary == nil {
+		if st := target.route.stickyTarget(req, target.Sticky); st != nil {
+			target = st
+			if trace != "" {
+				log.Printf("[TRACE] %s Sticky session for %s", trace, target.URL)
+			}
+		}
+	}
+
+	if target != nil {
+		if dt := target.route.debugTarget(req); dt != nil {
+			log.Printf("[DEBUG] Routing %s%s to %s with a debug token", req.Host, req.URL.Path, dt.URL)
+			target = dt
+		}
+	}
+
+	if target != nil && trace != "" {
+		log.Printf("[TRACE] %s Routing to service %s on %s", trace, target.Service, target.URL)
+	}
+
+	return target
+}
+
+// lookupHosts returns the first target the lookup function finds
+// for the given hosts. Redirects to the request url are skipped.
+func (t Table) lookupHosts(req *http.Request, hosts []string, lookup func(host string) *Target) (target *Target) {
 	for _, h := range hosts {
-		if target = t.lookup(h, req.URL.Path, trace, pick, match); target != nil {
+		if target = lookup(h); target != nil {
 			if target.RedirectCode != 0 {
 				req.URL.Host = req.Host
 				target.BuildRedirectURL(req.URL) // build redirect url and cache in target
@@ -446,37 +600,35 @@ func (t Table) Lookup(req *http.Request, trace string, pick picker, match matche
 			break
 		}
 	}
-
-	if target != nil && trace != "" {
-		log.Printf("[TRACE] %s Routing to service %s on %s", trace, target.Service, target.URL)
-	}
-
 	return target
 }
 
 func (t Table) LookupHost(host string, pick picker) *Target {
-	return t.lookup(host, "/", "", pick, prefixMatcher)
+	if target := t.lookup(host, "/", "", pick, prefixMatcher, nil); target != nil {
+		return target
+	}
+	return t.lookupDefault(host, "", pick, nil)
 }
 
-func (t Table) lookup(host, path, trace string, pick picker, match matcher) *Target {
+func (t Table) lookup(host, path, trace string, pick picker, match matcher, req *http.Request) *Target {
 	host = strings.ToLower(host) // routes are always added lowercase
 	for _, r := range t[host] {
+		if r.Default {
+			continue
+		}
 		if match(path, r) {
-			n := len(r.Targets)
-			if n == 0 {
-				return nil
+			if trace != "" {
+				log.Printf("[TRACE] %s Match %s%s", trace, r.Host, r.Path)
 			}
-
-			var target *Target
-			if n == 1 {
-				target = r.Targets[0]
-			} else {
-				target = pick(r)
+			// routes with only canary targets match only the
+			// requests which match the conditions of a canary
+			if target := r.pickTarget(pick, req); target != nil || len(r.canaries) == 0 || len(r.wTargets) > 0 {
+				return target
 			}
 			if trace != "" {
-				log.Printf("[TRACE] %s Match %s%s", trace, r.Host, r.Path)
+				log.Printf("[TRACE] %s No matching canary %s%s", trace, r.Host, r.Path)
 			}
-			return target
+			continue
 		}
 		if trace != "" {
 			log.Printf("[TRACE] %s No match %s%s", trace, r.Host, r.Path)
@@ -485,7 +637,25 @@ func (t Table) lookup(host, path, trace string, pick picker, match matcher) *Tar
 	return nil
 }
 
-func (t Table) config(addWeight bool) []string {
+// lookupDefault returns a target of the default route for the host
+// or nil if the host has none.
+func (t Table) lookupDefault(host, trace string, pick picker, req *http.Request) *Target {
+	host = strings.ToLower(host) // routes are always added lowercase
+	for _, r := range t[host] {
+		if !r.Default {
+			continue
+		}
+		if trace != "" {
+			log.Printf("[TRACE] %s Default %s%s", trace, r.Host, r.Path)
+		}
+		return r.pickTarget(pick, req)
+	}
+	return nil
+}
+
+// hosts returns the hosts of the table in the order of the config
+// language. Routes without a host come last.
+func (t Table) hosts() []string {
 	var hosts []string
 	for host := range t {
 		if host != "" {
@@ -493,12 +663,12 @@ func (t Table) config(addWeight bool) []string {
 		}
 	}
 	sort.Sort(sort.Reverse(sort.StringSlice(hosts)))
+	return append(hosts, "")
+}
 
-	// entries without host come last
-	hosts = append(hosts, "")
-
+func (t Table) config(addWeight bool) []string {
 	var cfg []string
-	for _, host := range hosts {
+	for _, host := range t.hosts() {
 		for _, routes := range t[host] {
 			cfg = append(cfg, routes.config(addWeight)...)
 		}
@@ -512,10 +682,49 @@ func (t Table) String() string {
 	return strings.Join(t.config(false), "\n")
 }
 
+// WriteTo writes the routing table in the same format as String
+// to w without building the whole table in memory.
+func (t Table) WriteTo(w io.Writer) (int64, error) {
+	var n int64
+	for _, host := range t.hosts() {
+		for _, r := range t[host] {
+			for _, line := range r.config(false) {
+				if n > 0 {
+					line = "\n" + line
+				}
+				m, err := io.WriteString(w, line)
+				n += int64(m)
+				if err != nil {
+					return n, err
+				}
+			}
+		}
+	}
+	return n, nil
+}
+
+// Len returns the number of targets in the routing table.
+func (t Table) Len() int {
+	var n int
+	for _, routes := range t {
+		for _, r := range routes {
+			n += len(r.Targets)
+		}
+	}
+	return n
+}
+
 // Dump returns the routing table as a detailed
+// ascii tree.
 func (t Table) Dump() string {
 	w := new(bytes.Buffer)
+	t.WriteDump(w)
+	return w.String()
+}
 
+// WriteDump writes the routing table as a detailed
+// ascii tree to w.
+func (t Table) WriteDump(w io.Writer) error {
 	hosts := []string{}
 	for k := range t {
 		hosts = append(hosts, k)
@@ -526,8 +735,9 @@ func (t Table) Dump() string {
 		return n == total-1
 	}
 
+	bw := bufio.NewWriter(w)
 	for i, h := range hosts {
-		fmt.Fprintf(w, "+-- host=%s\n", h)
+		fmt.Fprintf(bw, "+-- host=%s\n", h)
 
 		routes := t[h]
 		for j, r := range routes {
@@ -540,29 +750,20 @@ func (t Table) Dump() string {
 				p1 = "+-- "
 			}
 
-			fmt.Fprintf(w, "%s%spath=%s\n", p0, p1, r.Path)
-
-			m := map[*Target]int{}
-			for _, t := range r.wTargets {
-				m[t] += 1
-			}
+			fmt.Fprintf(bw, "%s%spath=%s\n", p0, p1, r.Path)
 
-			total := len(r.wTargets)
-			k := 0
-			for t, n := range m {
+			for k, t := range r.Targets {
 				p1 := "|    "
 				if last(j, len(routes)) {
 					p1 = "    "
 				}
 				p2 := "|-- "
-				if last(k, len(m)) {
+				if last(k, len(r.Targets)) {
 					p2 = "+-- "
 				}
-				weight := float64(n) / float64(total)
-				fmt.Fprintf(w, "%s%s%saddr=%s weight %2.2f slots %d/%d\n", p0, p1, p2, t.URL.Host, weight, n, total)
-				k++
+				fmt.Fprintf(bw, "%s%s%saddr=%s weight %2.2f\n", p0, p1, p2, t.URL.Host, t.Weight)
 			}
 		}
 	}
-	return w.String()
+	return bw.Flush()
 }
@@ -4,6 +4,7 @@ import (
 	"reflect"
 	"sort"
 	"testing"
+	"time"
 
 	"github.com/fabiolb/fabio/metrics"
 )
@@ -27,6 +28,90 @@ func TestSyncRegistry(t *testing.T) {
 	}
 }
 
+func TestSyncRegistryGrace(t *testing.T) {
+	oldRegistry, oldDefault := ServiceRegistry, metrics.DefaultRegistry
+	ServiceRegistry, metrics.DefaultRegistry = newStubRegistry(), newStubRegistry()
+	MetricsGCGrace = time.Minute
+	now := time.Now()
+	timeNow = func() time.Time { return now }
+	defer func() {
+		ServiceRegistry, metrics.DefaultRegistry = oldRegistry, oldDefault
+		MetricsGCGrace = 0
+		timeNow = time.Now
+		resetRegistryGC()
+	}()
+
+	tbl := make(Table)
+	tbl.addRoute(&RouteDef{Service: "svc-a", Src: "/aaa", Dst: "http://localhost:1234", Weight: 1})
+	tbl.addRoute(&RouteDef{Service: "svc-b", Src: "/bbb", Dst: "http://localhost:5678", Weight: 1})
+	metrics.DefaultRegistry.GetCounter("svc-b._./bbb.localhost_5678.retry")
+	metrics.DefaultRegistry.GetCounter("requests")
+
+	mu.Lock()
+	defer mu.Unlock()
+	syncRegistry(tbl)
+
+	tbl.delRoute(&RouteDef{Service: "svc-b", Src: "/bbb", Dst: "http://localhost:5678"})
+	syncRegistry(tbl)
+	if got, want := ServiceRegistry.Names(), []string{"svc-a._./aaa.localhost_1234", "svc-b._./bbb.localhost_5678"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v within grace period", got, want)
+	}
+
+	now = now.Add(time.Minute)
+	syncRegistry(tbl)
+	if got, want := ServiceRegistry.Names(), []string{"svc-a._./aaa.localhost_1234"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+	if got, want := metrics.DefaultRegistry.Names(), []string{"requests"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+}
+
+func TestSyncRegistryGraceReadded(t *testing.T) {
+	oldRegistry := ServiceRegistry
+	ServiceRegistry = newStubRegistry()
+	MetricsGCGrace = time.Minute
+	now := time.Now()
+	timeNow = func() time.Time { return now }
+	defer func() {
+		ServiceRegistry = oldRegistry
+		MetricsGCGrace = 0
+		timeNow = time.Now
+		resetRegistryGC()
+	}()
+
+	b := &RouteDef{Service: "svc-b", Src: "/bbb", Dst: "http://localhost:5678", Weight: 1}
+	tbl := make(Table)
+	tbl.addRoute(b)
+
+	mu.Lock()
+	defer mu.Unlock()
+	tbl.delRoute(b)
+	syncRegistry(tbl)
+
+	now = now.Add(30 * time.Second)
+	tbl.addRoute(b)
+	syncRegistry(tbl)
+
+	now = now.Add(time.Minute)
+	syncRegistry(tbl)
+	if got, want := ServiceRegistry.Names(), []string{"svc-b._./bbb.localhost_5678"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+}
+
+// resetRegistryGC stops the collection of removed targets and
+// forgets all known targets.
+func resetRegistryGC() {
+	mu.Lock()
+	defer mu.Unlock()
+	if gcTimer != nil {
+		gcTimer.Stop()
+		gcTimer = nil
+	}
+	registered, removed = map[string]bool{}, map[string]time.Time{}
+}
+
 func newStubRegistry() metrics.Registry {
 	return &stubRegistry{names: make(map[string]bool)}
 }
@@ -61,3 +146,8 @@ func (p *stubRegistry) GetTimer(name string) metrics.Timer {
 	p.names[name] = true
 	return metrics.NoopTimer{}
 }
+
+func (p *stubRegistry) GetGauge(name string) metrics.Gauge {
+	p.names[name] = true
+	return metrics.NoopGauge{}
+}
@@ -236,6 +236,30 @@ func TestTableParse(t *testing.T) {
 			},
 		},
 
+		{"backup targets receive no traffic",
+			[]string{
+				`route add svc / http://bar:111/`,
+				`route add svc / http://bar:222/ weight 0.5`,
+				`route add svc / http://bar:333/ opts "backup=true"`,
+			},
+			[]string{
+				`route add svc / http://bar:111/ weight 0.5000`,
+				`route add svc / http://bar:222/ weight 0.5000`,
+				`route add svc / http://bar:333/ weight 0.0000 opts "backup=true"`,
+			},
+		},
+
+		{"only backup targets -> even distribution",
+			[]string{
+				`route add svc / http://bar:111/ opts "backup=true"`,
+				`route add svc / http://bar:222/ opts "backup=true"`,
+			},
+			[]string{
+				`route add svc / http://bar:111/ weight 0.5000 opts "backup=true"`,
+				`route add svc / http://bar:222/ weight 0.5000 opts "backup=true"`,
+			},
+		},
+
 		{"weigh multiple entries for same instance with no fixed weight -> de-duplication",
 			[]string{
 				`route add svc / http://bar:111/`,
@@ -396,7 +420,7 @@ func TestTableParse(t *testing.T) {
 			}
 
 			// check that the weights returned in the generated config match
-			// the distribution in the wTargets array of the corresponding route.
+			// the effective distribution of the requests by the rr picker.
 			checked := map[string]bool{}
 
 			for _, s := range tt.out {
@@ -417,23 +441,18 @@ func TestTableParse(t *testing.T) {
 					t.Fatalf("got nil want route %s", path)
 				}
 
-				// check that there are at least some slots
+				// check that there are at least some targets which get traffic
 				if len(r.wTargets) == 0 {
 					t.Fatalf("got 0 targets want some")
 				}
 
-				// pre-generate the target urls for comparison as this
-				// will otherwise slow the test down significantly
-				targetURLs := make([]string, len(r.wTargets))
-				for i, tg := range r.wTargets {
-					targetURLs[i] = tg.URL.Scheme + "://" + tg.URL.Host + tg.URL.Path
+				// compute the share of the first requests for each target url
+				effective := map[string]float64{}
+				for _, sh := range r.Shares(100000) {
+					u := sh.Target.URL
+					effective[u.Scheme+"://"+u.Host+u.Path] += sh.Effective
 				}
 
-				// count how often the 'url' from 'route add svc <path> <url>'
-				// appears in the list of wTargets for all the URLs
-				// from the routes to determine whether the actual
-				// distribution of each target within the wTarget slice
-				// matches what we expect
 				for _, s := range tt.out {
 					// route add <svc> <path> <url> weight <weight> ...`,
 					p := strings.Fields(s)
@@ -443,31 +462,13 @@ func TestTableParse(t *testing.T) {
 						continue
 					}
 
-					// count how often the target url appears in the list of wTargets
-					count := 0
-					for _, u := range targetURLs {
-						if u == p[4] {
-							count++
-						}
-					}
-
-					// calc the weight as nSlots/totalSlots
-					gotWeight := float64(count) / float64(len(r.wTargets))
-
-					// round the weight down to the number of decimal points
-					// supported by maxSlots
-					gotWeight = float64(int(gotWeight*float64(maxSlots))) / float64(maxSlots)
-
 					// compare to the weight from the generated config
-					wantWeight := atof(p[6])
+					gotWeight, wantWeight := effective[p[4]], atof(p[6])
 
-					// check that the actual weight is within 2% of the computed weight
-					if math.Abs(gotWeight-wantWeight) > 0.02 {
-						t.Errorf("got weight %f want %f", gotWeight, wantWeight)
+					// check that the actual weight is within 0.1% of the computed weight
+					if math.Abs(gotWeight-wantWeight) > 0.001 {
+						t.Errorf("%s: got weight %f want %f", p[4], gotWeight, wantWeight)
 					}
-
-					// TODO(fs): verify distriibution of targets across the ring
-					// TODO(fs): verify lookup with 'rr' works as expected. Current test is by proxy of generated config.
 				}
 			}
 		})
@@ -494,6 +495,37 @@ func TestNormalizeHost(t *testing.T) {
 	}
 }
 
+func TestTableHasHost(t *testing.T) {
+	s := `
+	route add svc foo.com/ http://1.2.3.4/
+	route add svc bar.com:443/ http://1.2.3.4/
+	route add svc *.baz.com/ http://1.2.3.4/
+	route add svc / http://1.2.3.4/
+	`
+	tbl, err := NewTable(bytes.NewBufferString(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		host string
+		ok   bool
+	}{
+		{"foo.com", true},
+		{"FOO.com", true},
+		{"bar.com", true},
+		{"a.baz.com", true},
+		{"baz.com", false},
+		{"other.com", false},
+	}
+
+	for _, tt := range tests {
+		if got, want := tbl.HasHost(tt.host), tt.ok; got != want {
+			t.Errorf("%s: got %v want %v", tt.host, got, want)
+		}
+	}
+}
+
 // see https://github.com/fabiolb/fabio/issues/448
 // for more information on the issue and purpose of this test
 func TestTableLookupIssue448(t *testing.T) {
@@ -671,6 +703,101 @@ func TestTableLookup_656(t *testing.T) {
 	}
 }
 
+func TestTableLookupDefault(t *testing.T) {
+	s := `
+	route add svc / http://foo.com:800
+	route add svc /foo http://foo.com:900
+	route add svc abc.com/api http://foo.com:1000
+	route add app abc.com/ http://foo.com:2000 opts "default"
+	route add svc *.abc.com/api http://foo.com:3000
+	route add app *.abc.com/ http://foo.com:4000 opts "default=true"
+	route add svc xyz.com/ http://foo.com:5000
+	route add app xyz.com/ http://foo.com:6000 opts "default"
+	route add app / http://foo.com:7000 opts "default"
+	`
+
+	tbl, err := NewTable(bytes.NewBufferString(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		req *http.Request
+		dst string
+	}{
+		// specific routes have precedence
+		{&http.Request{Host: "abc.com", URL: mustParse("/api")}, "http://foo.com:1000"},
+		{&http.Request{Host: "x.abc.com", URL: mustParse("/api/x")}, "http://foo.com:3000"},
+
+		// routes without host have precedence over default routes
+		{&http.Request{Host: "abc.com", URL: mustParse("/foo")}, "http://foo.com:900"},
+		{&http.Request{Host: "abc.com", URL: mustParse("/")}, "http://foo.com:800"},
+
+		// same path as the default route
+		{&http.Request{Host: "xyz.com", URL: mustParse("/bar")}, "http://foo.com:5000"},
+
+		// a generic catch-all route has precedence over default routes
+		{&http.Request{Host: "x.abc.com", URL: mustParse("/bar")}, "http://foo.com:800"},
+	}
+
+	for i, tt := range tests {
+		if got, want := tbl.Lookup(tt.req, "", rndPicker, prefixMatcher, globCache, globEnabled).URL.String(), tt.dst; got != want {
+			t.Errorf("%d: got %v want %v", i, got, want)
+		}
+	}
+
+	// without a generic route the default routes are used
+	tbl, err = NewTable(bytes.NewBufferString(`
+	route add svc abc.com/api http://foo.com:1000
+	route add app abc.com/ http://foo.com:2000 opts "default"
+	route add app *.abc.com/ http://foo.com:4000 opts "default"
+	route add svc /foo http://foo.com:900
+	route add app / http://foo.com:7000 opts "default"
+	`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests = []struct {
+		req *http.Request
+		dst string
+	}{
+		{&http.Request{Host: "abc.com", URL: mustParse("/api")}, "http://foo.com:1000"},
+		{&http.Request{Host: "abc.com", URL: mustParse("/foo")}, "http://foo.com:900"},
+		{&http.Request{Host: "abc.com", URL: mustParse("/bar")}, "http://foo.com:2000"},
+		{&http.Request{Host: "x.abc.com", URL: mustParse("/bar")}, "http://foo.com:4000"},
+		{&http.Request{Host: "def.com", URL: mustParse("/bar")}, "http://foo.com:7000"},
+	}
+
+	for i, tt := range tests {
+		for _, glob := range []bool{globEnabled, globDisabled} {
+			req := &http.Request{Host: tt.req.Host, URL: tt.req.URL}
+			tg := tbl.Lookup(req, "", rndPicker, prefixMatcher, globCache, glob)
+			if glob == globDisabled && tt.req.Host == "x.abc.com" {
+				// glob host patterns do not match without glob
+				if got, want := tg.URL.String(), "http://foo.com:7000"; got != want {
+					t.Errorf("%d: got %v want %v", i, got, want)
+				}
+				continue
+			}
+			if got, want := tg.URL.String(), tt.dst; got != want {
+				t.Errorf("%d: got %v want %v", i, got, want)
+			}
+		}
+	}
+
+	// default routes survive a round trip through the config
+	if got, want := tbl.config(false), []string{
+		`route add svc abc.com/api http://foo.com:1000`,
+		`route add app abc.com/ http://foo.com:2000 opts "default="`,
+		`route add app *.abc.com/ http://foo.com:4000 opts "default="`,
+		`route add svc /foo http://foo.com:900`,
+		`route add app / http://foo.com:7000 opts "default="`,
+	}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
 func TestNewTableCustom(t *testing.T) {
 
 	var routes []RouteDef
@@ -749,12 +876,12 @@ func TestTable_Dump(t *testing.T) {
 
 	want := `+-- host=
 |   |-- path=/foo
-|   |    +-- addr=foo.com:900 weight 1.00 slots 1/1
+|   |    +-- addr=foo.com:900 weight 1.00
 |   +-- path=/
-|       +-- addr=foo.com:800 weight 1.00 slots 1/1
+|       +-- addr=foo.com:800 weight 1.00
 +-- host=abc.com
     +-- path=/
-        +-- addr=foo.com:1000 weight 1.00 slots 1/1
+        +-- addr=foo.com:1000 weight 1.00
 `
 
 	got := tbl.Dump()
@@ -763,3 +890,82 @@ func TestTable_Dump(t *testing.T) {
 		t.Errorf("Unexpected Dump() output:\nwant:\n%s\ngot:\n%s\n", want, got)
 	}
 }
+
+func TestTable_WriteTo(t *testing.T) {
+	s := `
+	route add svc / http://foo.com:800
+	route add svc /foo http://foo.com:900 opts "strip=/foo"
+	route add svc abc.com/ http://foo.com:1000
+	route add svc abc.com/ http://foo.com:1001
+	`
+
+	tbl, err := NewTable(bytes.NewBufferString(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	n, err := tbl.WriteTo(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := b.String(), tbl.String(); got != want {
+		t.Fatalf("got\n%s\nwant\n%s", got, want)
+	}
+	if got, want := n, int64(b.Len()); got != want {
+		t.Fatalf("got %d bytes want %d", got, want)
+	}
+	if got, want := tbl.Len(), 4; got != want {
+		t.Fatalf("got %d routes want %d", got, want)
+	}
+}
+
+func TestTableApplyWeights(t *testing.T) {
+	tbl, err := NewTable(bytes.NewBufferString(`
+		route add svc-a /foo http://1.1.1.1:5000/
+		route add svc-a /foo http://1.1.1.2:5000/ tags "canary"
+	`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = tbl.ApplyWeights(`
+		route weight svc-a /foo weight 0.25 tags "canary"
+		route weight svc-b /bar weight 0.5
+	`)
+	if err != nil {
+		t.Fatalf("got %v want nil", err)
+	}
+	if got, want := tbl.FixedWeight("svc-a", "/foo", []string{"canary"}), 0.25; got != want {
+		t.Fatalf("got weight %v want %v", got, want)
+	}
+	if got, want := tbl.FixedWeight("svc-b", "/bar", nil), 0.0; got != want {
+		t.Fatalf("got weight %v want %v", got, want)
+	}
+
+	if err := tbl.ApplyWeights(`route add svc-b /bar http://2.2.2.2:5000/`); err == nil {
+		t.Fatal("got nil want error for route add")
+	}
+}
+
+func TestNewTableSources(t *testing.T) {
+	tbl, err := NewTableSources(
+		ConfigSource{Name: "consul", Config: "route add a /a http://1.2.3.4/\nroute add b /b http://1.2.3.5/"},
+		ConfigSource{Name: "manual", Config: "route add a /a http://1.2.3.4/\nroute add c /c http://1.2.3.6/\nroute del b"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]string{}
+	for _, routes := range tbl {
+		for _, r := range routes {
+			for _, tg := range r.Targets {
+				got[tg.Service] = tg.Source
+			}
+		}
+	}
+	// a target which is added again keeps its first source
+	if want := map[string]string{"a": "consul", "c": "manual"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+}
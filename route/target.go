@@ -3,6 +3,7 @@ package route
 import (
 	"net/url"
 	"strings"
+	"time"
 
 	"github.com/fabiolb/fabio/metrics"
 )
@@ -17,6 +18,15 @@ type Target struct {
 	// Opts is the raw options for the target.
 	Opts map[string]string
 
+	// Source is the name of the config source which added the
+	// target, e.g. the registry backend or "manual".
+	Source string
+
+	// Annotations are the key/value pairs of the 'annotation.<key>'
+	// options which are added to the metrics, traces and access
+	// logs of the target.
+	Annotations map[string]string
+
 	// StripPath will be removed from the front of the outgoing
 	// request path
 	StripPath string
@@ -25,6 +35,10 @@ type Target struct {
 	// request path (after StripPath has been removed)
 	PrependPath string
 
+	// PathRewrite rewrites the outgoing request path after
+	// StripPath and PrependPath have been applied.
+	PathRewrite *PathRewrite
+
 	// TLSSkipVerify disables certificate validation for upstream
 	// TLS connections.
 	TLSSkipVerify bool
@@ -65,8 +79,137 @@ type Target struct {
 	// name of the auth handler for this target
 	AuthScheme string
 
-	// ProxyProto enables PROXY Protocol on upstream connection
-	ProxyProto bool
+	// ProxyProto is the version of the PROXY protocol header which
+	// is sent on upstream connections. Zero disables the header and
+	// the default of the listener is used.
+	ProxyProto int
+
+	// HTTP2 enables HTTP/2 for requests to this target. Targets
+	// with an https URL use TLS and all others h2c.
+	HTTP2 bool
+
+	// Capture enables recording the requests to this target
+	// in the traffic capture file.
+	Capture bool
+
+	// CaptureBody is the number of bytes of the request and
+	// response bodies which are recorded with a captured request.
+	CaptureBody int
+
+	// Backup marks a target which only receives connections when
+	// the connection to a primary target of the route fails. Only
+	// the TCP proxies fail over to backup targets.
+	Backup bool
+
+	// Backups are the backup targets of the route in the order in
+	// which they are tried when the connection to this target fails.
+	Backups []*Target
+
+	// DSCP is the DSCP value which marks the packets
+	// of the upstream connections to this target.
+	DSCP int
+
+	// RateLimit limits the requests to the route. It is shared
+	// by the targets of the route with the same options.
+	RateLimit *RateLimiter
+
+	// Retry is the policy for retrying failed requests
+	// on other targets of the route.
+	Retry RetryPolicy
+
+	// Sticky enables session affinity with a cookie
+	// which identifies the target.
+	Sticky *Sticky
+
+	// LocationRewrite enables rewriting the Location header of
+	// redirects from the target to the host of the client request.
+	LocationRewrite *LocationRewrite
+
+	// CookieRewrite enables rewriting the attributes
+	// of the cookies set by the target.
+	CookieRewrite *CookieRewrite
+
+	// RequestHeaders modifies the headers of
+	// the requests to the target.
+	RequestHeaders *HeaderOps
+
+	// ResponseHeaders modifies the headers of
+	// the responses from the target.
+	ResponseHeaders *HeaderOps
+
+	// Compression overrides the compression of
+	// the responses from the target.
+	Compression *Compression
+
+	// GRPCTLS configures the TLS connections
+	// to a gRPC target.
+	GRPCTLS *GRPCTLS
+
+	// BodyRewrite replaces strings in the text
+	// responses from the target.
+	BodyRewrite *BodyRewrite
+
+	// Cache enables caching the responses
+	// from the target.
+	Cache *CachePolicy
+
+	// CORS is the Cross-Origin Resource Sharing policy
+	// which the proxy enforces for the target.
+	CORS *CORS
+
+	// ErrorBody is the format of the standard error response
+	// which replaces the bodies of the 5xx responses from the
+	// target: json, html or auto. Empty means that the bodies
+	// are forwarded unmodified.
+	ErrorBody string
+
+	// Canary restricts the target to the requests which match
+	// its conditions.
+	Canary *Canary
+
+	// MaxTimeout is the maximum timeout a client can request
+	// with one of the timeout headers. Zero means that the
+	// default of the proxy is used.
+	MaxTimeout time.Duration
+
+	// HealthCheck is the active health check of the target
+	// or nil if the target is not checked.
+	HealthCheck *HealthCheck
+
+	// Region is the region of the target and Failover the regions of
+	// the service in the order in which they receive the traffic.
+	// Failover is nil if the service does not fail over.
+	Region   string
+	Failover []string
+
+	// MaxBody is the maximum size of the request bodies to
+	// the target in bytes. Zero means that the default of
+	// the proxy is used.
+	MaxBody int64
+
+	// Mirror sends a copy of the requests to the target to a
+	// shadow environment or is nil if the requests are not mirrored.
+	Mirror *Mirror
+
+	// Middleware is the chain of middlewares which process the
+	// requests to the target. If it is nil the chain of the proxy
+	// is used.
+	Middleware []string
+
+	// stickyID is the value of the sticky session cookie.
+	stickyID string
+
+	// route is the route the target belongs to.
+	route *Route
+}
+
+// Route returns the host and path prefix of the route
+// the target belongs to.
+func (t *Target) Route() string {
+	if t.route == nil {
+		return ""
+	}
+	return t.route.Host + t.route.Path
 }
 
 func (t *Target) BuildRedirectURL(requestURL *url.URL) {
@@ -126,3 +269,12 @@ func (t *Target) BuildRedirectURL(requestURL *url.URL) {
 		t.RedirectURL.Host = strings.Replace(t.RedirectURL.Host, "$host", requestURL.Host, 1)
 	}
 }
+
+// setSource sets the source of a target which does not have one yet.
+// Targets which are added again by a later source keep the source
+// which added them first.
+func (t *Target) setSource(source string) {
+	if t.Source == "" {
+		t.Source = source
+	}
+}
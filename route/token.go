@@ -0,0 +1,53 @@
+package route
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// tokenNow returns the current time and is stubbed out for testing.
+var tokenNow = time.Now
+
+// signToken returns a token with the payload which is valid until it
+// expires. The purpose is signed with the token so that a token which
+// was issued for one purpose is not accepted for another one even if
+// both use the same secret.
+func signToken(purpose string, secret []byte, payload string, expires time.Time) string {
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	payload = base64.RawURLEncoding.EncodeToString([]byte(payload))
+	return exp + "." + payload + "." + tokenSig(purpose, secret, exp, payload)
+}
+
+// parseToken returns the payload of a token for the purpose or an
+// empty string if the token is invalid or expired.
+func parseToken(purpose string, secret []byte, token string) string {
+	p := strings.Split(token, ".")
+	if len(p) != 3 || len(secret) == 0 {
+		return ""
+	}
+	if !hmac.Equal([]byte(p[2]), []byte(tokenSig(purpose, secret, p[0], p[1]))) {
+		return ""
+	}
+	exp, err := strconv.ParseInt(p[0], 10, 64)
+	if err != nil || tokenNow().Unix() > exp {
+		return ""
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(p[1])
+	if err != nil {
+		return ""
+	}
+	return string(payload)
+}
+
+// tokenSig returns the signature of the purpose, the expiry
+// and the encoded payload.
+func tokenSig(purpose string, secret []byte, exp, payload string) string {
+	mac := hmac.New(sha256.New, secret)
+	io.WriteString(mac, purpose+"."+exp+"."+payload)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
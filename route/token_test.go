@@ -0,0 +1,43 @@
+package route
+
+import (
+	"testing"
+	"time"
+)
+
+func TestToken(t *testing.T) {
+	defer func(now func() time.Time) { tokenNow = now }(tokenNow)
+	now := time.Unix(1000, 0)
+	tokenNow = func() time.Time { return now }
+
+	secret := []byte("secret")
+	token := signToken("debug", secret, "http://foo:1", now.Add(time.Minute))
+
+	tests := []struct {
+		desc    string
+		purpose string
+		secret  []byte
+		token   string
+		payload string
+	}{
+		{"valid", "debug", secret, token, "http://foo:1"},
+		{"other purpose", "trace", secret, token, ""},
+		{"other secret", "debug", []byte("other"), token, ""},
+		{"no secret", "debug", nil, token, ""},
+		{"invalid signature", "debug", secret, token[:len(token)-1] + "x", ""},
+		{"malformed", "debug", secret, "abc", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			if got, want := parseToken(tt.purpose, tt.secret, tt.token), tt.payload; got != want {
+				t.Fatalf("got %q want %q", got, want)
+			}
+		})
+	}
+
+	now = now.Add(2 * time.Minute)
+	if got := parseToken("debug", secret, token); got != "" {
+		t.Fatalf("got %q for expired token", got)
+	}
+}
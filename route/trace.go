@@ -0,0 +1,59 @@
+package route
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// TraceHeader is the name of the header with a trace token which
+// enables the tracing of the route lookup of a request. It is set on
+// startup. If it is empty the header is ignored.
+var TraceHeader string
+
+// TraceSecret is the key which signs the trace tokens. It is set on
+// startup. Without it the trace header is ignored.
+var TraceSecret []byte
+
+// TraceToken returns a signed token for the TraceHeader which enables
+// the tracing of the route lookup until the token expires. The id is
+// logged with the trace messages.
+func TraceToken(id string, expires time.Time) (string, error) {
+	if TraceHeader == "" || len(TraceSecret) == 0 {
+		return "", errors.New("trace tokens are disabled")
+	}
+	if !validTraceID(id) {
+		return "", fmt.Errorf("invalid trace id %q. Must be up to 16 letters, digits, '-' or '_'", id)
+	}
+	return signToken("trace", TraceSecret, id, expires), nil
+}
+
+// validTraceID returns true if the id can be logged as is.
+func validTraceID(id string) bool {
+	if id == "" || len(id) > 16 {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// TraceID returns the id of the trace token of the request for
+// Lookup. It returns an empty string which disables the tracing
+// if the request has no valid token.
+func TraceID(req *http.Request) string {
+	if TraceHeader == "" {
+		return ""
+	}
+	v := req.Header.Get(TraceHeader)
+	if v == "" {
+		return ""
+	}
+	return parseToken("trace", TraceSecret, v)
+}
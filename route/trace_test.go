@@ -0,0 +1,64 @@
+package route
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestTraceID(t *testing.T) {
+	defer func(h string, s []byte) { TraceHeader, TraceSecret = h, s }(TraceHeader, TraceSecret)
+	defer func(now func() time.Time) { tokenNow = now }(tokenNow)
+
+	traceID := func(token string) string {
+		req := &http.Request{Header: http.Header{}}
+		req.Header.Set("X-Fabio-Trace", token)
+		return TraceID(req)
+	}
+
+	if _, err := TraceToken("abc", time.Now()); err == nil {
+		t.Fatal("got nil want error for disabled trace tokens")
+	}
+
+	TraceHeader, TraceSecret = "X-Fabio-Trace", []byte("secret")
+	now := time.Unix(1000, 0)
+	tokenNow = func() time.Time { return now }
+
+	for _, id := range []string{"", "a.b", "abc def", "0123456789abcdefg"} {
+		if _, err := TraceToken(id, now.Add(time.Minute)); err == nil {
+			t.Fatalf("%q: got nil want error for invalid id", id)
+		}
+	}
+
+	token, err := TraceToken("abc-1", now.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := traceID(token), "abc-1"; got != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+
+	// a plain value no longer enables tracing
+	if got := traceID("abc"); got != "" {
+		t.Fatalf("got %q for value without token want none", got)
+	}
+
+	// invalid and expired tokens are ignored
+	if got := traceID(token[:len(token)-1] + "x"); got != "" {
+		t.Fatalf("got %q for token with invalid signature", got)
+	}
+	TraceSecret = []byte("other")
+	if got := traceID(token); got != "" {
+		t.Fatalf("got %q for token with other secret", got)
+	}
+	TraceSecret = []byte("secret")
+	now = now.Add(2 * time.Minute)
+	if got := traceID(token); got != "" {
+		t.Fatalf("got %q for expired token", got)
+	}
+
+	TraceHeader = ""
+	if got := traceID(token); got != "" {
+		t.Fatalf("got %q for disabled header", got)
+	}
+}
@@ -0,0 +1,212 @@
+// Package snapshot exports the configuration and the state of fabio
+// into timestamped archives which give operators point-in-time
+// snapshots for audits and post-incident analysis.
+package snapshot
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/fabiolb/fabio/cert"
+	"github.com/fabiolb/fabio/config"
+	"github.com/fabiolb/fabio/route"
+)
+
+// Snapshot contains the state of fabio at a point in time.
+type Snapshot struct {
+	Time     time.Time
+	Version  string
+	Hostname string
+
+	// Config is the runtime configuration without credentials.
+	Config *config.Config
+
+	Table route.Table
+	Certs []cert.Info
+}
+
+// New returns a snapshot of the current routing table and
+// certificates together with the redacted configuration.
+func New(version string, cfg *config.Config) *Snapshot {
+	hostname, _ := os.Hostname()
+	return &Snapshot{
+		Time:     time.Now().UTC(),
+		Version:  version,
+		Hostname: hostname,
+		Config:   cfg.Redacted(),
+		Table:    route.GetTable(),
+		Certs:    cert.Inventory(),
+	}
+}
+
+// Name returns the file name of the archive of the snapshot.
+func (s *Snapshot) Name() string {
+	return "fabio-" + s.Time.UTC().Format("20060102T150405Z") + ".tar.gz"
+}
+
+// TargetHealth describes the state of a target in the health summary.
+type TargetHealth struct {
+	Service  string  `json:"service"`
+	Src      string  `json:"src"`
+	Dst      string  `json:"dst"`
+	Weight   float64 `json:"weight"`
+	Check    string  `json:"check,omitempty"`
+	Health   string  `json:"health,omitempty"`
+	Flapping bool    `json:"flapping,omitempty"`
+}
+
+// HealthSummary counts the targets of the routing table
+// by their state and lists them.
+type HealthSummary struct {
+	Routes    int            `json:"routes"`
+	Targets   int            `json:"targets"`
+	Unhealthy int            `json:"unhealthy"`
+	Ejected   int            `json:"ejected"`
+	Flapping  int            `json:"flapping"`
+	Details   []TargetHealth `json:"details"`
+}
+
+// Health returns the health summary of the routing table.
+func (s *Snapshot) Health() HealthSummary {
+	h := HealthSummary{Details: []TargetHealth{}}
+	for _, routes := range s.Table {
+		for _, r := range routes {
+			h.Routes++
+			for _, t := range r.Targets {
+				th := TargetHealth{
+					Service:  t.Service,
+					Src:      r.Host + r.Path,
+					Dst:      t.URL.String(),
+					Weight:   t.Weight,
+					Flapping: t.Flapping(),
+				}
+				if t.HealthCheck != nil {
+					th.Check, th.Health = t.HealthCheck.Type, "healthy"
+					if t.Unhealthy() {
+						th.Health = "unhealthy"
+						h.Unhealthy++
+					}
+				}
+				if th.Health != "unhealthy" && t.Ejected() {
+					th.Health = "ejected"
+					h.Ejected++
+				}
+				if th.Flapping {
+					h.Flapping++
+				}
+				h.Targets++
+				h.Details = append(h.Details, th)
+			}
+		}
+	}
+	sort.Slice(h.Details, func(i, j int) bool {
+		a, b := h.Details[i], h.Details[j]
+		if a.Src != b.Src {
+			return a.Src < b.Src
+		}
+		return a.Dst < b.Dst
+	})
+	return h
+}
+
+// Archive returns the snapshot as a gzip compressed tar archive with
+// the files meta.json, config.json, routes.txt, health.json and
+// certs.json in a directory with the name of the snapshot.
+func (s *Snapshot) Archive() ([]byte, error) {
+	meta := struct {
+		Time     time.Time `json:"time"`
+		Version  string    `json:"version"`
+		Hostname string    `json:"hostname"`
+	}{s.Time, s.Version, s.Hostname}
+
+	certs := s.Certs
+	if certs == nil {
+		certs = []cert.Info{}
+	}
+
+	files := []struct {
+		name string
+		v    interface{}
+	}{
+		{"meta.json", meta},
+		{"config.json", s.Config},
+		{"routes.txt", s.Table.String() + "\n"},
+		{"health.json", s.Health()},
+		{"certs.json", certs},
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(zw)
+	dir := s.Name()[:len(s.Name())-len(".tar.gz")]
+	for _, f := range files {
+		var data []byte
+		if str, ok := f.v.(string); ok {
+			data = []byte(str)
+		} else {
+			var err error
+			if data, err = json.MarshalIndent(f.v, "", "    "); err != nil {
+				return nil, err
+			}
+			data = append(data, '\n')
+		}
+		hdr := &tar.Header{
+			Name:    dir + "/" + f.name,
+			Mode:    0644,
+			Size:    int64(len(data)),
+			ModTime: s.Time,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write(data); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Store saves the archives of the snapshots.
+type Store interface {
+	// Put saves the archive with the given name.
+	Put(name string, data []byte) error
+
+	// String returns the location of the archives.
+	String() string
+}
+
+// Run writes a snapshot returned by take to the store immediately
+// and then every interval. Run does not return.
+func Run(store Store, interval time.Duration, take func() *Snapshot) {
+	for {
+		if err := Write(store, take()); err != nil {
+			log.Printf("[ERROR] snapshot: %s", err)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// Write saves the archive of the snapshot in the store.
+func Write(store Store, s *Snapshot) error {
+	data, err := s.Archive()
+	if err != nil {
+		return err
+	}
+	if err := store.Put(s.Name(), data); err != nil {
+		return err
+	}
+	log.Printf("[INFO] snapshot: Wrote %s to %s", s.Name(), store)
+	return nil
+}
@@ -0,0 +1,161 @@
+package snapshot
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/fabiolb/fabio/config"
+	"github.com/fabiolb/fabio/route"
+)
+
+// untar returns the files of a gzip compressed tar archive.
+func untar(t *testing.T, data []byte) map[string][]byte {
+	t.Helper()
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(zr)
+	files := map[string][]byte{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[hdr.Name] = b
+	}
+}
+
+func TestArchive(t *testing.T) {
+	const cfg = "route add svc /foo http://a:1/ opts \"healthcheck=http\"\nroute add svc /foo http://b:2/"
+	tbl, err := route.NewTable(bytes.NewBufferString(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Snapshot{
+		Time:     time.Date(2020, 5, 24, 10, 30, 0, 0, time.UTC),
+		Version:  "1.2.3",
+		Hostname: "lb1",
+		Config:   &config.Config{},
+		Table:    tbl,
+	}
+	if got, want := s.Name(), "fabio-20200524T103000Z.tar.gz"; got != want {
+		t.Fatalf("got name %q want %q", got, want)
+	}
+
+	data, err := s.Archive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := untar(t, data)
+
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	const dir = "fabio-20200524T103000Z/"
+	want := []string{dir + "certs.json", dir + "config.json", dir + "health.json", dir + "meta.json", dir + "routes.txt"}
+	if !reflect.DeepEqual(names, want) {
+		t.Fatalf("got files %v want %v", names, want)
+	}
+	if got, want := string(files[dir+"routes.txt"]), cfg+"\n"; got != want {
+		t.Fatalf("got routes %q want %q", got, want)
+	}
+	if got, want := string(files[dir+"certs.json"]), "[]\n"; got != want {
+		t.Fatalf("got certs %q want %q", got, want)
+	}
+
+	var h HealthSummary
+	if err := json.Unmarshal(files[dir+"health.json"], &h); err != nil {
+		t.Fatal(err)
+	}
+	wantHealth := HealthSummary{
+		Routes:  1,
+		Targets: 2,
+		Details: []TargetHealth{
+			{Service: "svc", Src: "/foo", Dst: "http://a:1/", Weight: 0.5, Check: "http", Health: "healthy"},
+			{Service: "svc", Src: "/foo", Dst: "http://b:2/", Weight: 0.5},
+		},
+	}
+	if !reflect.DeepEqual(h, wantHealth) {
+		t.Fatalf("got health %+v want %+v", h, wantHealth)
+	}
+
+	var meta map[string]string
+	if err := json.Unmarshal(files[dir+"meta.json"], &meta); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := meta, map[string]string{"time": "2020-05-24T10:30:00Z", "version": "1.2.3", "hostname": "lb1"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got meta %v want %v", got, want)
+	}
+}
+
+func TestNewRedactsConfig(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Registry.Consul.Token = "s3cr3t-consul"
+	cfg.Registry.Etcd.Password = "s3cr3t-etcd"
+
+	s := New("1.2.3", cfg)
+	data, err := s.Archive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := untar(t, data)[s.Name()[:len(s.Name())-len(".tar.gz")]+"/config.json"]
+	if len(b) == 0 {
+		t.Fatal("config.json is missing")
+	}
+	if bytes.Contains(b, []byte("s3cr3t")) {
+		t.Fatalf("got secret in config.json %s", b)
+	}
+	if got, want := cfg.Registry.Consul.Token, "s3cr3t-consul"; got != want {
+		t.Fatalf("running config was modified")
+	}
+}
+
+func TestDirStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fabio-snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := NewStore(filepath.Join(dir, "snapshots"), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"fabio-20200101T000000Z.tar.gz", "fabio-20200102T000000Z.tar.gz", "fabio-20200103T000000Z.tar.gz"} {
+		if err := store.Put(name, []byte(name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := ioutil.ReadDir(filepath.Join(dir, "snapshots"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range files {
+		names = append(names, f.Name())
+	}
+	if got, want := names, []string{"fabio-20200102T000000Z.tar.gz", "fabio-20200103T000000Z.tar.gz"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+}
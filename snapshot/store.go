@@ -0,0 +1,81 @@
+package snapshot
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// NewStore returns the store for the target which is either a local
+// directory or an S3 URL like s3://bucket/prefix. keep is the number
+// of archives which are kept in a local directory. Zero keeps all
+// archives.
+func NewStore(target string, keep int) (Store, error) {
+	if strings.HasPrefix(target, "s3://") {
+		return newS3Store(target)
+	}
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return nil, err
+	}
+	return &dirStore{dir: target, keep: keep}, nil
+}
+
+// dirStore saves the archives in a local directory.
+type dirStore struct {
+	dir  string
+	keep int
+}
+
+func (s *dirStore) String() string {
+	return s.dir
+}
+
+// Put writes the archive atomically and removes the
+// oldest archives above the number of archives to keep.
+func (s *dirStore) Put(name string, data []byte) error {
+	f, err := ioutil.TempFile(s.dir, "."+name)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), filepath.Join(s.dir, name)); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return s.prune()
+}
+
+// prune removes the oldest archives above the number of archives to
+// keep. The names of the archives sort in the order of their time.
+func (s *dirStore) prune() error {
+	if s.keep <= 0 {
+		return nil
+	}
+	names, err := filepath.Glob(filepath.Join(s.dir, "fabio-*.tar.gz"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+	for len(names) > s.keep {
+		if err := os.Remove(names[0]); err != nil {
+			return fmt.Errorf("cannot remove old snapshot. %s", err)
+		}
+		names = names[1:]
+	}
+	return nil
+}
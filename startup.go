@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/fabiolb/fabio/config"
+	"github.com/fabiolb/fabio/proxy"
+	"github.com/fabiolb/fabio/registry"
+	"github.com/fabiolb/fabio/route"
+)
+
+// startupStatus is logged as a JSON object when fabio has started so
+// that tooling can verify a successful start without parsing the
+// other log messages. With log.format = json its fields are part of
+// the log line.
+type startupStatus struct {
+	Msg     string `json:"msg"`
+	Version string `json:"version"`
+
+	// Ready is true if all configured listeners are bound.
+	Ready      bool     `json:"ready"`
+	Listeners  []string `json:"listeners"`
+	Configured int      `json:"listeners_configured"`
+
+	// Registry is the backend of the routing table and
+	// RegistryStatus its state if the backend reports it.
+	Registry       string      `json:"registry"`
+	RegistryStatus interface{} `json:"registry_status,omitempty"`
+
+	// Routes is the number of routes and TableVersion the sequence
+	// number of the last change of the routing table.
+	Routes       int    `json:"routes"`
+	TableVersion uint64 `json:"table_version"`
+}
+
+// logStartupStatus waits up to timeout for the listeners of the proxy
+// and the admin server to be bound and logs the startup status.
+func logStartupStatus(cfg *config.Config, timeout time.Duration) {
+	st := startupStatus{
+		Msg:          "Startup status",
+		Version:      version,
+		Configured:   len(cfg.Listen) + 1,
+		Registry:     cfg.Registry.Backend,
+		TableVersion: route.Events.Seq(),
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		st.Listeners = proxy.Addrs()
+		if len(st.Listeners) >= st.Configured || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	st.Ready = len(st.Listeners) >= st.Configured
+
+	if r, ok := registry.Default.(registry.StatusReporter); ok {
+		st.RegistryStatus = r.Status()
+	}
+	for _, routes := range route.GetTable() {
+		st.Routes += len(routes)
+	}
+
+	data, err := json.Marshal(st)
+	if err != nil {
+		log.Printf("[ERROR] Cannot encode startup status. %s", err)
+		return
+	}
+	if st.Ready {
+		log.Printf("[INFO] %s", data)
+	} else {
+		log.Printf("[WARN] %s", data)
+	}
+}
@@ -0,0 +1,54 @@
+package trace
+
+import (
+	"context"
+	"strings"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"google.golang.org/grpc/metadata"
+)
+
+// metadataCarrier reads and writes the trace context
+// from and to the metadata of a gRPC request.
+type metadataCarrier metadata.MD
+
+func (c metadataCarrier) Set(key, val string) {
+	key = strings.ToLower(key)
+	c[key] = append(c[key][:0], val)
+}
+
+func (c metadataCarrier) ForeachKey(handler func(key, val string) error) error {
+	for k, vals := range c {
+		for _, v := range vals {
+			if err := handler(k, v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// CreateGRPCSpan creates a span for a proxied gRPC call. If the
+// incoming metadata contains trace data the span is a child of the
+// remote span. The returned context contains the incoming metadata
+// with the trace data of the new span so that it is forwarded to
+// the upstream server.
+func CreateGRPCSpan(ctx context.Context, fullMethod string) (opentracing.Span, context.Context) {
+	tracer := opentracing.GlobalTracer()
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	md = md.Copy()
+
+	var span opentracing.Span
+	spanCtx, err := tracer.Extract(opentracing.TextMap, metadataCarrier(md))
+	if err != nil {
+		span = tracer.StartSpan(fullMethod, ext.SpanKindRPCServer)
+	} else {
+		span = tracer.StartSpan(fullMethod, ext.RPCServerOption(spanCtx))
+	}
+	ext.Component.Set(span, "gRPC")
+
+	tracer.Inject(span.Context(), opentracing.TextMap, metadataCarrier(md))
+	return span, metadata.NewIncomingContext(ctx, md)
+}
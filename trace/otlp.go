@@ -0,0 +1,356 @@
+package trace
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	otlog "github.com/opentracing/opentracing-go/log"
+)
+
+// W3C trace context headers which are used by OpenTelemetry.
+const (
+	traceparentHeader = "traceparent"
+	tracestateHeader  = "tracestate"
+)
+
+// sampler decides whether a new span is recorded and exported.
+type sampler func(traceID [16]byte, parent *otelSpanContext) bool
+
+// newSampler returns the sampler with the given name. ratio is the
+// share of traces which are sampled by the ratio based samplers.
+//
+//	always_on                 sample all traces
+//	always_off                sample no traces
+//	traceidratio              sample the given share of the traces
+//	parentbased_traceidratio  follow the decision of the parent span
+//	                          and sample the share of new traces
+func newSampler(name string, ratio float64) (sampler, error) {
+	byRatio := func(id [16]byte, _ *otelSpanContext) bool {
+		switch {
+		case ratio <= 0:
+			return false
+		case ratio >= 1:
+			return true
+		}
+		// same algorithm as the OpenTelemetry SDKs so that
+		// the decision is consistent for the whole trace.
+		x := binary.BigEndian.Uint64(id[8:16]) >> 1
+		return x < uint64(ratio*(1<<63))
+	}
+
+	switch name {
+	case "always_on":
+		return func([16]byte, *otelSpanContext) bool { return true }, nil
+	case "always_off":
+		return func([16]byte, *otelSpanContext) bool { return false }, nil
+	case "traceidratio":
+		return byRatio, nil
+	case "parentbased_traceidratio", "":
+		return func(id [16]byte, parent *otelSpanContext) bool {
+			if parent != nil {
+				return parent.sampled
+			}
+			return byRatio(id, nil)
+		}, nil
+	default:
+		return nil, fmt.Errorf("trace: unknown sampler %q", name)
+	}
+}
+
+// otelSpanContext is the span context of the OpenTelemetry tracer.
+type otelSpanContext struct {
+	traceID    [16]byte
+	spanID     [8]byte
+	sampled    bool
+	traceState string
+	baggage    map[string]string
+}
+
+func (c *otelSpanContext) ForeachBaggageItem(handler func(k, v string) bool) {
+	for k, v := range c.baggage {
+		if !handler(k, v) {
+			return
+		}
+	}
+}
+
+// traceparent returns the value of the W3C traceparent header.
+func (c *otelSpanContext) traceparent() string {
+	flags := "00"
+	if c.sampled {
+		flags = "01"
+	}
+	return "00-" + hex.EncodeToString(c.traceID[:]) + "-" + hex.EncodeToString(c.spanID[:]) + "-" + flags
+}
+
+// parseTraceparent parses the value of the W3C traceparent header.
+func parseTraceparent(s string) (*otelSpanContext, error) {
+	p := strings.Split(strings.TrimSpace(s), "-")
+	if len(p) < 4 || len(p[0]) != 2 || p[0] == "ff" || len(p[1]) != 32 || len(p[2]) != 16 || len(p[3]) != 2 {
+		return nil, opentracing.ErrSpanContextCorrupted
+	}
+	// version 00 has exactly four fields
+	if p[0] == "00" && len(p) != 4 {
+		return nil, opentracing.ErrSpanContextCorrupted
+	}
+
+	c := &otelSpanContext{}
+	flags, err := hex.DecodeString(p[3])
+	if err != nil {
+		return nil, opentracing.ErrSpanContextCorrupted
+	}
+	if _, err := hex.Decode(c.traceID[:], []byte(p[1])); err != nil || c.traceID == [16]byte{} {
+		return nil, opentracing.ErrSpanContextCorrupted
+	}
+	if _, err := hex.Decode(c.spanID[:], []byte(p[2])); err != nil || c.spanID == [8]byte{} {
+		return nil, opentracing.ErrSpanContextCorrupted
+	}
+	c.sampled = flags[0]&1 == 1
+	return c, nil
+}
+
+// OTelTracer is an OpenTracing compatible tracer which propagates
+// the trace context with the W3C trace context headers and exports
+// the sampled spans with the OpenTelemetry protocol (OTLP).
+type OTelTracer struct {
+	sampler  sampler
+	exporter *spanExporter
+}
+
+// newOTelTracer creates a tracer which sends the sampled spans to exp.
+func newOTelTracer(s sampler, exp *spanExporter) *OTelTracer {
+	return &OTelTracer{sampler: s, exporter: exp}
+}
+
+func (t *OTelTracer) StartSpan(operationName string, opts ...opentracing.StartSpanOption) opentracing.Span {
+	var o opentracing.StartSpanOptions
+	for _, opt := range opts {
+		opt.Apply(&o)
+	}
+
+	var parent *otelSpanContext
+	for _, ref := range o.References {
+		if c, ok := ref.ReferencedContext.(*otelSpanContext); ok {
+			parent = c
+			break
+		}
+	}
+
+	ctx := &otelSpanContext{}
+	if parent != nil {
+		ctx.traceID = parent.traceID
+		ctx.traceState = parent.traceState
+		if len(parent.baggage) > 0 {
+			ctx.baggage = map[string]string{}
+			for k, v := range parent.baggage {
+				ctx.baggage[k] = v
+			}
+		}
+	} else {
+		rand.Read(ctx.traceID[:])
+	}
+	rand.Read(ctx.spanID[:])
+	ctx.sampled = t.sampler(ctx.traceID, parent)
+
+	start := o.StartTime
+	if start.IsZero() {
+		start = time.Now()
+	}
+	s := &otelSpan{tracer: t, ctx: ctx, name: operationName, start: start, tags: map[string]interface{}{}}
+	if parent != nil {
+		s.parentID = parent.spanID
+	}
+	for k, v := range o.Tags {
+		s.tags[k] = v
+	}
+	return s
+}
+
+func (t *OTelTracer) Inject(sc opentracing.SpanContext, format interface{}, carrier interface{}) error {
+	c, ok := sc.(*otelSpanContext)
+	if !ok {
+		return opentracing.ErrInvalidSpanContext
+	}
+	w, ok := carrier.(opentracing.TextMapWriter)
+	if !ok {
+		return opentracing.ErrInvalidCarrier
+	}
+	switch format {
+	case opentracing.HTTPHeaders, opentracing.TextMap:
+	default:
+		return opentracing.ErrUnsupportedFormat
+	}
+	w.Set(traceparentHeader, c.traceparent())
+	if c.traceState != "" {
+		w.Set(tracestateHeader, c.traceState)
+	}
+	return nil
+}
+
+func (t *OTelTracer) Extract(format interface{}, carrier interface{}) (opentracing.SpanContext, error) {
+	r, ok := carrier.(opentracing.TextMapReader)
+	if !ok {
+		return nil, opentracing.ErrInvalidCarrier
+	}
+	switch format {
+	case opentracing.HTTPHeaders, opentracing.TextMap:
+	default:
+		return nil, opentracing.ErrUnsupportedFormat
+	}
+
+	var traceparent, tracestate string
+	err := r.ForeachKey(func(k, v string) error {
+		switch strings.ToLower(k) {
+		case traceparentHeader:
+			traceparent = v
+		case tracestateHeader:
+			tracestate = v
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if traceparent == "" {
+		return nil, opentracing.ErrSpanContextNotFound
+	}
+	c, err := parseTraceparent(traceparent)
+	if err != nil {
+		return nil, err
+	}
+	c.traceState = tracestate
+	return c, nil
+}
+
+// spanEvent is a log record of a span.
+type spanEvent struct {
+	time   time.Time
+	fields []otlog.Field
+}
+
+// otelSpan implements the OpenTracing span for the OpenTelemetry tracer.
+type otelSpan struct {
+	tracer   *OTelTracer
+	ctx      *otelSpanContext
+	parentID [8]byte
+
+	mu       sync.Mutex
+	name     string
+	start    time.Time
+	end      time.Time
+	tags     map[string]interface{}
+	events   []spanEvent
+	finished bool
+}
+
+func (s *otelSpan) Finish() {
+	s.FinishWithOptions(opentracing.FinishOptions{})
+}
+
+func (s *otelSpan) FinishWithOptions(opts opentracing.FinishOptions) {
+	s.mu.Lock()
+	if s.finished {
+		s.mu.Unlock()
+		return
+	}
+	s.finished = true
+	s.end = opts.FinishTime
+	if s.end.IsZero() {
+		s.end = time.Now()
+	}
+	for _, r := range opts.LogRecords {
+		s.events = append(s.events, spanEvent{r.Timestamp, r.Fields})
+	}
+	s.mu.Unlock()
+
+	if s.ctx.sampled && s.tracer.exporter != nil {
+		s.tracer.exporter.add(s)
+	}
+}
+
+func (s *otelSpan) Context() opentracing.SpanContext {
+	return s.ctx
+}
+
+func (s *otelSpan) SetOperationName(operationName string) opentracing.Span {
+	s.mu.Lock()
+	s.name = operationName
+	s.mu.Unlock()
+	return s
+}
+
+func (s *otelSpan) SetTag(key string, value interface{}) opentracing.Span {
+	s.mu.Lock()
+	s.tags[key] = value
+	s.mu.Unlock()
+	return s
+}
+
+func (s *otelSpan) LogFields(fields ...otlog.Field) {
+	s.mu.Lock()
+	s.events = append(s.events, spanEvent{time.Now(), fields})
+	s.mu.Unlock()
+}
+
+func (s *otelSpan) LogKV(alternatingKeyValues ...interface{}) {
+	fields, err := otlog.InterleavedKVToFields(alternatingKeyValues...)
+	if err != nil {
+		fields = []otlog.Field{otlog.Error(err)}
+	}
+	s.LogFields(fields...)
+}
+
+func (s *otelSpan) SetBaggageItem(restrictedKey, value string) opentracing.Span {
+	s.mu.Lock()
+	if s.ctx.baggage == nil {
+		s.ctx.baggage = map[string]string{}
+	}
+	s.ctx.baggage[restrictedKey] = value
+	s.mu.Unlock()
+	return s
+}
+
+func (s *otelSpan) BaggageItem(restrictedKey string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ctx.baggage[restrictedKey]
+}
+
+func (s *otelSpan) Tracer() opentracing.Tracer {
+	return s.tracer
+}
+
+func (s *otelSpan) LogEvent(event string) {
+	s.LogFields(otlog.String("event", event))
+}
+
+func (s *otelSpan) LogEventWithPayload(event string, payload interface{}) {
+	s.LogFields(otlog.String("event", event), otlog.Object("payload", payload))
+}
+
+func (s *otelSpan) Log(data opentracing.LogData) {
+	s.LogFields(data.ToLogRecord().Fields...)
+}
+
+// kind returns the OTLP span kind from the span.kind tag.
+func (s *otelSpan) kind() uint64 {
+	switch fmt.Sprint(s.tags[string(ext.SpanKind)]) {
+	case "server":
+		return 2
+	case "client":
+		return 3
+	case "producer":
+		return 4
+	case "consumer":
+		return 5
+	default:
+		return 1
+	}
+}
@@ -0,0 +1,320 @@
+package trace
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/fabiolb/fabio/internal/rawpb"
+	"github.com/opentracing/opentracing-go/ext"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Default endpoints of an OpenTelemetry collector.
+const (
+	defaultOTLPHTTPEndpoint = "http://localhost:4318"
+	defaultOTLPGRPCEndpoint = "http://localhost:4317"
+)
+
+// otlpGRPCMethod is the method of the OTLP trace service.
+const otlpGRPCMethod = "/opentelemetry.proto.collector.trace.v1.TraceService/Export"
+
+// spanExporter collects the finished spans and sends them
+// in batches to an OpenTelemetry collector.
+type spanExporter struct {
+	send     func(ctx context.Context, req []byte) error
+	resource []byte
+	queue    chan *otelSpan
+	maxBatch int
+	interval time.Duration
+	timeout  time.Duration
+	dropped  uint64
+}
+
+// newSpanExporter creates an exporter which sends the spans with
+// send and describes them with the resource attributes.
+func newSpanExporter(send func(context.Context, []byte) error, resource map[string]string) *spanExporter {
+	return &spanExporter{
+		send:     send,
+		resource: encodeResource(resource),
+		queue:    make(chan *otelSpan, 2048),
+		maxBatch: 512,
+		interval: 5 * time.Second,
+		timeout:  10 * time.Second,
+	}
+}
+
+// add queues a finished span. Spans are dropped if the queue is full.
+func (e *spanExporter) add(s *otelSpan) {
+	select {
+	case e.queue <- s:
+	default:
+		atomic.AddUint64(&e.dropped, 1)
+	}
+}
+
+// run sends the queued spans when the batch is full
+// or the interval has passed.
+func (e *spanExporter) run() {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	var batch []*otelSpan
+	for {
+		select {
+		case s := <-e.queue:
+			batch = append(batch, s)
+			if len(batch) < e.maxBatch {
+				continue
+			}
+		case <-ticker.C:
+		}
+		if n := atomic.SwapUint64(&e.dropped, 0); n > 0 {
+			log.Printf("[WARN] trace: Dropped %d spans since the export queue was full", n)
+		}
+		if len(batch) == 0 {
+			continue
+		}
+		e.export(batch)
+		batch = nil
+	}
+}
+
+func (e *spanExporter) export(spans []*otelSpan) {
+	ctx, cancel := context.WithTimeout(context.Background(), e.timeout)
+	defer cancel()
+	if err := e.send(ctx, encodeRequest(e.resource, spans)); err != nil {
+		log.Printf("[WARN] trace: Cannot export %d spans. %s", len(spans), err)
+	}
+}
+
+// otlpHTTPSender returns a function which posts the
+// requests to the OTLP/HTTP endpoint of a collector.
+func otlpHTTPSender(endpoint string, headers map[string]string) (func(context.Context, []byte) error, error) {
+	if endpoint == "" {
+		endpoint = defaultOTLPHTTPEndpoint
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("trace: invalid OTLP endpoint %q", endpoint)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/v1/traces"
+	}
+	target := u.String()
+	client := &http.Client{}
+
+	return func(ctx context.Context, body []byte) error {
+		req, err := http.NewRequest("POST", target, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-protobuf")
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			return fmt.Errorf("%s returned %s", target, resp.Status)
+		}
+		return nil
+	}, nil
+}
+
+// otlpGRPCSender returns a function which sends the requests
+// to the OTLP/gRPC endpoint of a collector. The connection
+// uses TLS if the scheme of the endpoint is https.
+func otlpGRPCSender(endpoint string, headers map[string]string) (func(context.Context, []byte) error, error) {
+	if endpoint == "" {
+		endpoint = defaultOTLPGRPCEndpoint
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("trace: invalid OTLP endpoint %q", endpoint)
+	}
+
+	opt := grpc.WithInsecure()
+	if u.Scheme == "https" {
+		opt = grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{}))
+	}
+	conn, err := grpc.Dial(u.Host, opt)
+	if err != nil {
+		return nil, err
+	}
+
+	md := metadata.New(headers)
+	return func(ctx context.Context, req []byte) error {
+		ctx = metadata.NewOutgoingContext(ctx, md)
+		var resp []byte
+		return conn.Invoke(ctx, otlpGRPCMethod, &req, &resp, grpc.ForceCodec(rawpb.Codec{}))
+	}, nil
+}
+
+// The functions below encode the messages of the OTLP trace service
+// (opentelemetry/proto/collector/trace/v1) in the protobuf wire format.
+
+// encodeRequest encodes an ExportTraceServiceRequest with a
+// single ResourceSpans message which contains all spans.
+func encodeRequest(resource []byte, spans []*otelSpan) []byte {
+	var scope []byte
+	scope = rawpb.AppendString(scope, 1, "fabio")
+
+	var scopeSpans []byte
+	scopeSpans = appendMessage(scopeSpans, 1, scope)
+	for _, s := range spans {
+		scopeSpans = appendMessage(scopeSpans, 2, encodeSpan(s))
+	}
+
+	var rs []byte
+	rs = appendMessage(rs, 1, resource)
+	rs = appendMessage(rs, 2, scopeSpans)
+
+	var req []byte
+	return appendMessage(req, 1, rs)
+}
+
+// encodeResource encodes a Resource with the attributes sorted by key.
+func encodeResource(attrs map[string]string) []byte {
+	var keys []string
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b []byte
+	for _, k := range keys {
+		b = appendMessage(b, 1, encodeKeyValue(k, attrs[k]))
+	}
+	return b
+}
+
+func encodeSpan(s *otelSpan) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var b []byte
+	b = rawpb.AppendBytes(b, 1, s.ctx.traceID[:])
+	b = rawpb.AppendBytes(b, 2, s.ctx.spanID[:])
+	if s.ctx.traceState != "" {
+		b = rawpb.AppendString(b, 3, s.ctx.traceState)
+	}
+	if s.parentID != [8]byte{} {
+		b = rawpb.AppendBytes(b, 4, s.parentID[:])
+	}
+	b = rawpb.AppendString(b, 5, s.name)
+	b = protowire.AppendTag(b, 6, protowire.VarintType)
+	b = protowire.AppendVarint(b, s.kind())
+	b = protowire.AppendTag(b, 7, protowire.Fixed64Type)
+	b = protowire.AppendFixed64(b, uint64(s.start.UnixNano()))
+	b = protowire.AppendTag(b, 8, protowire.Fixed64Type)
+	b = protowire.AppendFixed64(b, uint64(s.end.UnixNano()))
+
+	var keys []string
+	for k := range s.tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if k == string(ext.SpanKind) {
+			continue
+		}
+		b = appendMessage(b, 9, encodeKeyValue(k, s.tags[k]))
+	}
+
+	for _, ev := range s.events {
+		var e []byte
+		e = protowire.AppendTag(e, 1, protowire.Fixed64Type)
+		e = protowire.AppendFixed64(e, uint64(ev.time.UnixNano()))
+		name := "log"
+		for _, f := range ev.fields {
+			if f.Key() == "event" {
+				name = fmt.Sprint(f.Value())
+				continue
+			}
+			e = appendMessage(e, 3, encodeKeyValue(f.Key(), f.Value()))
+		}
+		e = rawpb.AppendString(e, 2, name)
+		b = appendMessage(b, 11, e)
+	}
+
+	// the error tag marks failed spans
+	if isErr, _ := s.tags[string(ext.Error)].(bool); isErr {
+		var st []byte
+		st = protowire.AppendTag(st, 3, protowire.VarintType)
+		st = protowire.AppendVarint(st, 2)
+		b = appendMessage(b, 15, st)
+	}
+	return b
+}
+
+// encodeKeyValue encodes a KeyValue. Values of other types
+// than strings, booleans and numbers are encoded as strings.
+func encodeKeyValue(key string, v interface{}) []byte {
+	var val []byte
+	switch x := v.(type) {
+	case string:
+		val = rawpb.AppendString(val, 1, x)
+	case bool:
+		val = protowire.AppendTag(val, 2, protowire.VarintType)
+		val = protowire.AppendVarint(val, protowire.EncodeBool(x))
+	case int:
+		val = appendInt(val, int64(x))
+	case int8:
+		val = appendInt(val, int64(x))
+	case int16:
+		val = appendInt(val, int64(x))
+	case int32:
+		val = appendInt(val, int64(x))
+	case int64:
+		val = appendInt(val, x)
+	case uint8:
+		val = appendInt(val, int64(x))
+	case uint16:
+		val = appendInt(val, int64(x))
+	case uint32:
+		val = appendInt(val, int64(x))
+	case float32:
+		val = appendDouble(val, float64(x))
+	case float64:
+		val = appendDouble(val, x)
+	default:
+		val = rawpb.AppendString(val, 1, fmt.Sprint(v))
+	}
+
+	var b []byte
+	b = rawpb.AppendString(b, 1, key)
+	return appendMessage(b, 2, val)
+}
+
+func appendInt(b []byte, v int64) []byte {
+	b = protowire.AppendTag(b, 3, protowire.VarintType)
+	return protowire.AppendVarint(b, uint64(v))
+}
+
+func appendDouble(b []byte, v float64) []byte {
+	b = protowire.AppendTag(b, 4, protowire.Fixed64Type)
+	return protowire.AppendFixed64(b, math.Float64bits(v))
+}
+
+func appendMessage(b []byte, num protowire.Number, msg []byte) []byte {
+	return rawpb.AppendBytes(b, num, msg)
+}
@@ -0,0 +1,229 @@
+package trace
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		in      string
+		sampled bool
+		err     bool
+	}{
+		{in: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", sampled: true},
+		{in: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", sampled: false},
+		{in: "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-future", sampled: true},
+		{in: "", err: true},
+		{in: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", err: true},
+		{in: "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", err: true},
+		{in: "00-00000000000000000000000000000000-00f067aa0ba902b7-01", err: true},
+		{in: "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", err: true},
+		{in: "00-4bf92f3577b34da6a3ce929d0e0e473x-00f067aa0ba902b7-01", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			c, err := parseTraceparent(tt.in)
+			if got, want := err != nil, tt.err; got != want {
+				t.Fatalf("got error %v want error %v", err, want)
+			}
+			if tt.err {
+				return
+			}
+			if got, want := c.sampled, tt.sampled; got != want {
+				t.Fatalf("got sampled %v want %v", got, want)
+			}
+			if tt.in[:2] == "00" {
+				if got, want := c.traceparent(), tt.in; got != want {
+					t.Fatalf("got %q want %q", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSampler(t *testing.T) {
+	id := [16]byte{15: 1}
+	sampled := &otelSpanContext{sampled: true}
+	notSampled := &otelSpanContext{}
+
+	tests := []struct {
+		name   string
+		ratio  float64
+		parent *otelSpanContext
+		want   bool
+	}{
+		{"always_on", 0, nil, true},
+		{"always_off", 1, sampled, false},
+		{"traceidratio", 0, nil, false},
+		{"traceidratio", 1, notSampled, true},
+		{"traceidratio", 0.5, nil, true},
+		{"parentbased_traceidratio", 1, notSampled, false},
+		{"parentbased_traceidratio", 0, sampled, true},
+		{"parentbased_traceidratio", 1, nil, true},
+		{"", 0, nil, false},
+	}
+
+	for _, tt := range tests {
+		s, err := newSampler(tt.name, tt.ratio)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := s(id, tt.parent), tt.want; got != want {
+			t.Errorf("%s(%v, %v): got %v want %v", tt.name, tt.ratio, tt.parent, got, want)
+		}
+	}
+
+	if _, err := newSampler("sometimes", 1); err == nil {
+		t.Fatal("expected error for unknown sampler")
+	}
+}
+
+func TestOTelTracerPropagation(t *testing.T) {
+	s, _ := newSampler("parentbased_traceidratio", 0)
+	tracer := newOTelTracer(s, nil)
+
+	h := http.Header{}
+	h.Set("Traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	h.Set("Tracestate", "vendor=value")
+	parent, err := tracer.Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(h))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	span := tracer.StartSpan("child", ext.RPCServerOption(parent))
+	out := http.Header{}
+	if err := tracer.Inject(span.Context(), opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(out)); err != nil {
+		t.Fatal(err)
+	}
+	c, err := parseTraceparent(out.Get("Traceparent"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := parent.(*otelSpanContext)
+	if c.traceID != p.traceID || c.spanID == p.spanID || !c.sampled {
+		t.Fatalf("got %s want child of %s", out.Get("Traceparent"), p.traceparent())
+	}
+	if got, want := out.Get("Tracestate"), "vendor=value"; got != want {
+		t.Fatalf("got tracestate %q want %q", got, want)
+	}
+
+	if _, err := tracer.Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(http.Header{})); err != opentracing.ErrSpanContextNotFound {
+		t.Fatalf("got %v want %v", err, opentracing.ErrSpanContextNotFound)
+	}
+}
+
+func TestOTLPHTTPExport(t *testing.T) {
+	var body []byte
+	var contentType, apiKey string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" {
+			http.NotFound(w, r)
+			return
+		}
+		contentType, apiKey = r.Header.Get("Content-Type"), r.Header.Get("Api-Key")
+		body, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer srv.Close()
+
+	send, err := otlpHTTPSender(srv.URL, map[string]string{"Api-Key": "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := newSpanExporter(send, map[string]string{"service.name": "fabio"})
+	s, _ := newSampler("always_on", 0)
+	tracer := newOTelTracer(s, exp)
+
+	span := tracer.StartSpan("GET /foo", ext.SpanKindRPCServer)
+	ext.Error.Set(span, true)
+	span.Finish()
+
+	var batch []*otelSpan
+	select {
+	case sp := <-exp.queue:
+		batch = append(batch, sp)
+	default:
+		t.Fatal("span was not queued")
+	}
+	exp.export(batch)
+
+	if got, want := contentType, "application/x-protobuf"; got != want {
+		t.Fatalf("got content type %q want %q", got, want)
+	}
+	if got, want := apiKey, "secret"; got != want {
+		t.Fatalf("got api key %q want %q", got, want)
+	}
+
+	// ExportTraceServiceRequest.resource_spans.scope_spans.spans
+	rs := field(t, body, 1)
+	if !bytes.Contains(field(t, rs, 1), []byte("service.name")) {
+		t.Fatal("resource has no service.name")
+	}
+	sp := field(t, field(t, field(t, rs, 2), 2), 1)
+	ctx := span.Context().(*otelSpanContext)
+	if !bytes.Equal(sp, ctx.traceID[:]) {
+		t.Fatalf("got trace id %x want %x", sp, ctx.traceID)
+	}
+	if got, want := string(field(t, field(t, field(t, rs, 2), 2), 5)), "GET /foo"; got != want {
+		t.Fatalf("got name %q want %q", got, want)
+	}
+}
+
+func TestCreateGRPCSpan(t *testing.T) {
+	s, _ := newSampler("always_on", 0)
+	opentracing.SetGlobalTracer(newOTelTracer(s, nil))
+	defer opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+
+	in := metadata.Pairs("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	span, ctx := CreateGRPCSpan(metadata.NewIncomingContext(context.Background(), in), "/svc/Method")
+	defer span.Finish()
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	if got, want := md.Get("traceparent"), []string{span.Context().(*otelSpanContext).traceparent()}; len(got) != 1 || got[0] != want[0] {
+		t.Fatalf("got traceparent %v want %v", got, want)
+	}
+	if got, want := in.Get("traceparent")[0], "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"; got != want {
+		t.Fatalf("incoming metadata was modified: got %q want %q", got, want)
+	}
+}
+
+// field returns the value of the first length delimited field
+// with the given number in the protobuf message b.
+func field(t *testing.T, b []byte, num protowire.Number) []byte {
+	t.Helper()
+	for len(b) > 0 {
+		n, typ, l := protowire.ConsumeTag(b)
+		if l < 0 {
+			t.Fatal(protowire.ParseError(l))
+		}
+		b = b[l:]
+		if typ == protowire.BytesType {
+			v, l := protowire.ConsumeBytes(b)
+			if l < 0 {
+				t.Fatal(protowire.ParseError(l))
+			}
+			if n == num {
+				return v
+			}
+			b = b[l:]
+			continue
+		}
+		l = protowire.ConsumeFieldValue(n, typ, b)
+		if l < 0 {
+			t.Fatal(protowire.ParseError(l))
+		}
+		b = b[l:]
+	}
+	t.Fatalf("field %d not found", num)
+	return nil
+}
@@ -2,6 +2,8 @@ package trace
 
 import (
 	"bytes"
+	"context"
+	"encoding/hex"
 	"fmt"
 	"log"
 	"net/http"
@@ -10,6 +12,7 @@ import (
 	"text/template"
 
 	"github.com/fabiolb/fabio/config"
+	"github.com/fabiolb/fabio/redact"
 	opentracing "github.com/opentracing/opentracing-go"
 	"github.com/opentracing/opentracing-go/ext"
 	zipkin "github.com/openzipkin-contrib/zipkin-go-opentracing"
@@ -24,6 +27,22 @@ func InjectHeaders(span opentracing.Span, req *http.Request) {
 	)
 }
 
+// TraceID returns the hex encoded id of the trace of the span
+// or an empty string if the span is not from a fabio tracer.
+func TraceID(span opentracing.Span) string {
+	if span == nil {
+		return ""
+	}
+	switch ctx := span.Context().(type) {
+	case zipkin.SpanContext:
+		return ctx.TraceID.ToHex()
+	case *otelSpanContext:
+		return hex.EncodeToString(ctx.traceID[:])
+	default:
+		return ""
+	}
+}
+
 func CreateCollector(collectorType, connectString, topic string) zipkin.Collector {
 	var collector zipkin.Collector
 	var err error
@@ -83,7 +102,7 @@ func CreateSpan(r *http.Request, cfg *config.Tracing) opentracing.Span {
 			span = globalTracer.StartSpan(name, ext.RPCServerOption(spanCtx))
 		}
 		ext.HTTPMethod.Set(span, r.Method)
-		ext.HTTPUrl.Set(span, r.URL.String())
+		ext.HTTPUrl.Set(span, redact.Default.URL(r.URL).String())
 	}
 
 	return span // caller must defer span.finish()
@@ -96,6 +115,15 @@ func InitializeTracer(traceConfig *config.Tracing) {
 		return
 	}
 
+	if traceConfig.CollectorType == "otlp" {
+		tracer, err := createOTelTracer(traceConfig)
+		if err != nil {
+			log.Fatalf("[FATAL] Unable to create OpenTelemetry tracer: %s", err)
+		}
+		opentracing.SetGlobalTracer(tracer)
+		return
+	}
+
 	log.Printf("Tracing initializing - type: %s, connection string: %s, service name: %s, topic: %s, samplerRate: %v",
 		traceConfig.CollectorType, traceConfig.ConnectString, traceConfig.ServiceName, traceConfig.Topic, traceConfig.SamplerRate)
 
@@ -108,6 +136,39 @@ func InitializeTracer(traceConfig *config.Tracing) {
 	opentracing.SetGlobalTracer(tracer)
 }
 
+// createOTelTracer creates the OpenTelemetry tracer and starts
+// the exporter which sends the spans to the OTLP collector.
+func createOTelTracer(cfg *config.Tracing) (*OTelTracer, error) {
+	log.Printf("[INFO] Tracing initializing - type: otlp, protocol: %s, endpoint: %s, service name: %s, sampler: %s, samplerRate: %v",
+		cfg.OTLP.Protocol, cfg.OTLP.Endpoint, cfg.ServiceName, cfg.OTLP.Sampler, cfg.SamplerRate)
+
+	s, err := newSampler(cfg.OTLP.Sampler, cfg.SamplerRate)
+	if err != nil {
+		return nil, err
+	}
+
+	var send func(context.Context, []byte) error
+	switch cfg.OTLP.Protocol {
+	case "http", "":
+		send, err = otlpHTTPSender(cfg.OTLP.Endpoint, cfg.OTLP.Headers)
+	case "grpc":
+		send, err = otlpGRPCSender(cfg.OTLP.Endpoint, cfg.OTLP.Headers)
+	default:
+		err = fmt.Errorf("trace: unknown OTLP protocol %q", cfg.OTLP.Protocol)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	resource := map[string]string{"service.name": cfg.ServiceName}
+	for k, v := range cfg.OTLP.Resource {
+		resource[k] = v
+	}
+	exp := newSpanExporter(send, resource)
+	go exp.run()
+	return newOTelTracer(s, exp), nil
+}
+
 // spanName returns the rendered span name from the configured template.
 // If an error is encountered, it returns the unrendered template.
 func spanName(tmplStr string, r *http.Request) string {
@@ -120,7 +181,7 @@ func spanName(tmplStr string, r *http.Request) string {
 
 	data := struct {
 		Proto, Method, Host, Scheme, Path, RawQuery string
-	}{r.Proto, r.Method, r.Host, r.URL.Scheme, r.URL.Path, r.URL.RawQuery}
+	}{r.Proto, r.Method, r.Host, r.URL.Scheme, r.URL.Path, redact.Default.Query(r.URL.RawQuery)}
 
 	if err = tmpl.Execute(&name, data); err != nil {
 		return tmplStr
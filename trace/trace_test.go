@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"github.com/fabiolb/fabio/config"
+	"github.com/fabiolb/fabio/redact"
 	opentracing "github.com/opentracing/opentracing-go"
 	mocktracer "github.com/opentracing/opentracing-go/mocktracer"
 	zipkin "github.com/openzipkin-contrib/zipkin-go-opentracing"
@@ -59,6 +60,26 @@ func TestCreateSpanWithParent(t *testing.T) {
 	}
 }
 
+func TestCreateSpanRedactsURL(t *testing.T) {
+	redact.Default = redact.New(config.Redact{Query: []string{"token"}})
+	defer func() { redact.Default = nil }()
+
+	mt := mocktracer.New()
+	opentracing.SetGlobalTracer(mt)
+
+	req, _ := http.NewRequest("GET", "http://example.com/foo?token=abc", nil)
+	span := CreateSpan(req, &config.Tracing{ServiceName: "fabiolb-test", SpanName: "{{.Path}}?{{.RawQuery}}"})
+	span.Finish()
+
+	ms := mt.FinishedSpans()[0]
+	if got, want := ms.Tag("http.url"), "http://example.com/foo?token=[REDACTED]"; got != want {
+		t.Fatalf("got http.url %q want %q", got, want)
+	}
+	if got, want := ms.OperationName, "/foo?token=[REDACTED]"; got != want {
+		t.Fatalf("got span name %q want %q", got, want)
+	}
+}
+
 func TestInitializeTracer(t *testing.T) {
 	opentracing.SetGlobalTracer(nil)
 	InitializeTracer(&config.Tracing{TracingEnabled: true, CollectorType: "http"})
@@ -129,6 +150,34 @@ func TestInjectHeadersWithParentSpan(t *testing.T) {
 	}
 }
 
+func TestTraceID(t *testing.T) {
+	tracer, _ := zipkin.NewTracer(nil)
+	parent := zipkin.SpanContext{TraceID: zipkintypes.TraceID{High: 1234, Low: 4321}}
+	span := tracer.StartSpan("zipkin", opentracing.ChildOf(parent))
+	if got, want := TraceID(span), "00000000000004d200000000000010e1"; got != want {
+		t.Fatalf("got zipkin trace id %q want %q", got, want)
+	}
+
+	s, _ := newSampler("always_on", 0)
+	h := http.Header{"Traceparent": {"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}}
+	ot := newOTelTracer(s, nil)
+	ctx, err := ot.Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(h))
+	if err != nil {
+		t.Fatal(err)
+	}
+	span = ot.StartSpan("otel", opentracing.ChildOf(ctx))
+	if got, want := TraceID(span), "4bf92f3577b34da6a3ce929d0e0e4736"; got != want {
+		t.Fatalf("got otel trace id %q want %q", got, want)
+	}
+
+	if got, want := TraceID(mocktracer.New().StartSpan("mock")), ""; got != want {
+		t.Fatalf("got mock trace id %q want %q", got, want)
+	}
+	if got, want := TraceID(nil), ""; got != want {
+		t.Fatalf("got nil trace id %q want %q", got, want)
+	}
+}
+
 func TestSpanName(t *testing.T) {
 	req, _ := http.NewRequest("GET", "http://example.com/demo", nil)
 
@@ -0,0 +1,33 @@
+// +build !windows
+
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/fabiolb/fabio/exit"
+	"github.com/fabiolb/fabio/proxy"
+)
+
+// listenUpgrade hands the listeners over to a new process on SIGUSR2
+// and shuts down gracefully when the new process is ready.
+func listenUpgrade() {
+	sigchan := make(chan os.Signal, 1)
+	signal.Notify(sigchan, syscall.SIGUSR2)
+	go func() {
+		for range sigchan {
+			log.Print("[INFO] Caught SIGUSR2. Starting new process")
+			if err := proxy.Upgrade(currentConfig().Proxy.UpgradeTimeout); err != nil {
+				log.Printf("[ERROR] Upgrade failed. %s", err)
+				continue
+			}
+			log.Print("[INFO] New process is ready. Exiting")
+			atomic.StoreInt32(&upgraded, 1)
+			exit.Exit(0)
+		}
+	}()
+}